@@ -0,0 +1,25 @@
+package main
+
+import "strings"
+
+// airDropAgent is the agent macOS records in the quarantine attribute of
+// files received over AirDrop. Browsers and Mail record their own names, so
+// other downloads are told apart from AirDrop arrivals.
+const airDropAgent = "sharingd"
+
+// quarantineAgent returns the agent field of a com.apple.quarantine
+// attribute, "FLAGS;TIMESTAMP;AGENT;UUID", or "".
+func quarantineAgent(value string) string {
+	fields := strings.Split(strings.TrimRight(value, "\x00"), ";")
+	if len(fields) < 3 {
+		return ""
+	}
+	return fields[2]
+}
+
+// isAirDropped reports whether the file at path was received over AirDrop
+// (--airdrop). Files without a quarantine attribute were not.
+func isAirDropped(path string) bool {
+	value, err := quarantineAttribute(path)
+	return err == nil && quarantineAgent(value) == airDropAgent
+}
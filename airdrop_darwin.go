@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// airDropInbox is where AirDrop puts received files: the Downloads folder.
+func airDropInbox() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Downloads"), nil
+}
+
+// quarantineAttribute reads the com.apple.quarantine extended attribute
+// macOS sets on files from other machines.
+func quarantineAttribute(path string) (string, error) {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(path, "com.apple.quarantine", buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
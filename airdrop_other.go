@@ -0,0 +1,18 @@
+//go:build !darwin
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+var errNoAirDrop = fmt.Errorf("--airdrop is only available on macOS, not %s", runtime.GOOS)
+
+func airDropInbox() (string, error) {
+	return "", errNoAirDrop
+}
+
+func quarantineAttribute(path string) (string, error) {
+	return "", errNoAirDrop
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestQuarantineAgent(t *testing.T) {
+	for value, want := range map[string]string{
+		"0083;65a1b2c3;sharingd;8F2C61A4-5B0E-4D8B-9C3A-2E6F1D7B0A11": airDropAgent,
+		"0081;65a1b2c3;Safari;2B9D7E3C-1F4A-4E6B-8D2C-5A7F9E1B3C40":   "Safari",
+		"0083;65a1b2c3;sharingd;\x00":                                 airDropAgent,
+		"0083":                                                        "",
+		"":                                                            "",
+	} {
+		if got := quarantineAgent(value); got != want {
+			t.Errorf("%q: got %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestParseOptionsAirDrop(t *testing.T) {
+	opts, err := parseOptions([]string{"--airdrop"}, io.Discard)
+	if runtime.GOOS != "darwin" {
+		if err == nil {
+			t.Error("expected --airdrop to be refused outside macOS")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, _ := os.UserHomeDir()
+	if !opts.watch || opts.inputPath != filepath.Join(home, "Downloads") {
+		t.Errorf("got watch %v, input %q", opts.watch, opts.inputPath)
+	}
+	dir := t.TempDir()
+	if opts, err = parseOptions([]string{"--airdrop", dir}, io.Discard); err != nil || opts.inputPath != dir {
+		t.Errorf("given input %q: got %q (%v)", dir, opts.inputPath, err)
+	}
+}
+
+func TestFilterSourcesAirDrop(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Copied files have no quarantine attribute, as if made on this Mac.
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.HEIC"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	opts.airDrop = true
+	kept := filterSources(dir, files, opts)
+	if len(kept) != 1 || kept[0].Name() != "notes.txt" {
+		t.Errorf("kept %v, want only the non-HEIC file", kept)
+	}
+}
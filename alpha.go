@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+
+	"github.com/adrium/goheif/heif"
+	"github.com/iancleary/heictojpeg/heic"
+)
+
+// How --alpha treats a HEIC with an alpha plane, as edited images and
+// stickers have: keep the transparency by saving that file as a PNG,
+// flatten it onto --alpha-background, or leave it out as earlier versions
+// did, showing whatever colour the transparent pixels happen to have.
+const (
+	alphaPNG     = "png"
+	alphaFlatten = "flatten"
+	alphaIgnore  = "ignore"
+)
+
+// defaultAlphaBackground is what transparent images are flattened onto.
+const defaultAlphaBackground = "#ffffff"
+
+func isValidAlpha(mode string) bool {
+	return mode == alphaPNG || mode == alphaFlatten || mode == alphaIgnore
+}
+
+// parseBackground parses an --alpha-background colour: #rrggbb, with or
+// without the #, or white or black.
+func parseBackground(value string) (color.RGBA, error) {
+	switch strings.ToLower(value) {
+	case "white":
+		return color.RGBA{255, 255, 255, 255}, nil
+	case "black":
+		return color.RGBA{0, 0, 0, 255}, nil
+	}
+	rgb, err := hex.DecodeString(strings.TrimPrefix(value, "#"))
+	if err != nil || len(rgb) != 3 {
+		return color.RGBA{}, fmt.Errorf("%q is not a #rrggbb colour", value)
+	}
+	return color.RGBA{rgb[0], rgb[1], rgb[2], 255}, nil
+}
+
+// alphaItemID returns the ID of the alpha plane of the primary image of ra,
+// an auxiliary image referencing it.
+func alphaItemID(ra io.ReaderAt) (uint32, bool) {
+	hf := heif.Open(ra)
+	primary, err := hf.PrimaryItem()
+	if err != nil {
+		return 0, false
+	}
+	infos, err := heifItemInfos(ra)
+	if err != nil {
+		return 0, false
+	}
+	for _, info := range infos {
+		item, err := hf.ItemByID(uint32(info.ItemID))
+		if err != nil {
+			continue
+		}
+		ref := item.Reference("auxl")
+		if ref == nil || auxKind(auxType(item)) != "Alpha" {
+			continue
+		}
+		for _, id := range ref.ToItemIDs {
+			if id == primary.ID {
+				return item.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// alphaPremultiplied reports whether the colours of the primary image of ra
+// are premultiplied by its alpha, which a prem reference marks.
+func alphaPremultiplied(ra io.ReaderAt) bool {
+	primary, err := heif.Open(ra).PrimaryItem()
+	return err == nil && primary.Reference("prem") != nil
+}
+
+// decodeAlpha decodes the alpha plane id of ra, which must cover bounds.
+func decodeAlpha(ra io.ReaderAt, id uint32, bounds image.Rectangle) (*image.Gray, error) {
+	img, err := heic.DecodeItem(io.NewSectionReader(ra, 0, 1<<62), id)
+	if err != nil {
+		return nil, err
+	}
+	var alpha *image.Gray
+	switch img := img.(type) {
+	case *image.Gray:
+		alpha = img
+	case *image.YCbCr:
+		alpha = &image.Gray{Pix: img.Y, Stride: img.YStride, Rect: img.Rect}
+	default:
+		return nil, fmt.Errorf("alpha plane is not monochrome")
+	}
+	if a := alpha.Bounds(); a.Dx() != bounds.Dx() || a.Dy() != bounds.Dy() {
+		return nil, fmt.Errorf("alpha plane is %dx%d, the image %dx%d", a.Dx(), a.Dy(), bounds.Dx(), bounds.Dy())
+	}
+	return alpha, nil
+}
+
+// withAlpha returns img with its alpha plane, premultiplied or not as its
+// colours are.
+func withAlpha(img image.Image, alpha *image.Gray, premultiplied bool) image.Image {
+	b := img.Bounds()
+	a := alpha.Bounds()
+	pix := make([]byte, 4*b.Dx()*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		row := pix[4*y*b.Dx():]
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			row[4*x], row[4*x+1], row[4*x+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+			row[4*x+3] = alpha.GrayAt(a.Min.X+x, a.Min.Y+y).Y
+		}
+	}
+	rect := image.Rect(0, 0, b.Dx(), b.Dy())
+	if premultiplied {
+		return &image.RGBA{Pix: pix, Stride: 4 * b.Dx(), Rect: rect}
+	}
+	return &image.NRGBA{Pix: pix, Stride: 4 * b.Dx(), Rect: rect}
+}
+
+// flattenAlpha composites img with its alpha plane onto background.
+func flattenAlpha(img image.Image, alpha *image.Gray, background color.RGBA, premultiplied bool) *image.RGBA {
+	b := img.Bounds()
+	a := alpha.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	bg := [3]uint32{uint32(background.R), uint32(background.G), uint32(background.B)}
+	for y := 0; y < b.Dy(); y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			c := [3]uint32{r >> 8, g >> 8, bl >> 8}
+			opacity := uint32(alpha.GrayAt(a.Min.X+x, a.Min.Y+y).Y)
+			for i := range c {
+				if !premultiplied {
+					c[i] = c[i] * opacity / 255
+				}
+				v := c[i] + (bg[i]*(255-opacity)+127)/255
+				if v > 255 {
+					v = 255
+				}
+				row[4*x+i] = uint8(v)
+			}
+			row[4*x+3] = 255
+		}
+	}
+	return dst
+}
+
+// applyAlpha handles the alpha plane of the decoded primary image img of
+// ra as opts.alpha asks, and returns the image with the per-file options:
+// its output format switched to PNG to keep transparency, and alphaAction
+// set to what was done, for the log. Formats without transparency (JPEG
+// pages of a PDF, PPM) always get the image flattened. An alpha plane that
+// cannot be decoded is left out.
+func applyAlpha(ra io.ReaderAt, img image.Image, opts options) (image.Image, options) {
+	if opts.alpha == alphaIgnore {
+		return img, opts
+	}
+	id, ok := alphaItemID(ra)
+	if !ok {
+		return img, opts
+	}
+	alpha, err := decodeAlpha(ra, id, img.Bounds())
+	if err != nil {
+		opts.alphaAction = fmt.Sprintf("Alpha ignored (%v)", err)
+		return img, opts
+	}
+	premultiplied := alphaPremultiplied(ra)
+
+	keeps := opts.outputFormat == formatPNG || opts.outputFormat == formatRawRGBA
+	switch {
+	case opts.alpha == alphaFlatten || opts.outputFormat == formatPDF || opts.outputFormat == formatPPM:
+		opts.alphaAction = fmt.Sprintf("Alpha flattened onto #%02x%02x%02x", opts.alphaBackground.R, opts.alphaBackground.G, opts.alphaBackground.B)
+		return flattenAlpha(img, alpha, opts.alphaBackground, premultiplied), opts
+	case keeps:
+		opts.alphaAction = "Alpha kept"
+	default:
+		opts.outputFormat = formatPNG
+		opts.alphaAction = "Alpha kept, saved as PNG"
+	}
+	return withAlpha(img, alpha, premultiplied), opts
+}
+
+// hasAlphaToApply reports whether the source ra has an alpha plane --alpha
+// acts on, which rules out passing its JPEG through.
+func hasAlphaToApply(ra io.ReaderAt, opts options) bool {
+	if opts.alpha == alphaIgnore {
+		return false
+	}
+	_, ok := alphaItemID(ra)
+	return ok
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildAlphaHEIF builds a HEIF file whose primary image and its alpha plane
+// are JPEG-coded items: a green 64x48 image, transparent on its left half.
+func buildAlphaHEIF(t *testing.T) []byte {
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	rgb := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	alpha := image.NewGray(rgb.Bounds())
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			rgb.SetRGBA(x, y, color.RGBA{0, 200, 0, 255})
+			if x >= 32 {
+				alpha.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	colorData, alphaData := encode(rgb), encode(alpha)
+
+	u16 := func(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+	u32 := func(v int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(v)) }
+	ftyp := testBox("ftyp", []byte("mif1"), u32(0), []byte("mif1heic"))
+	meta := func(offset int) []byte {
+		return testFullBox("meta",
+			testFullBox("hdlr", u32(0), []byte("pict"), make([]byte, 13)),
+			testFullBox("pitm", u16(1)),
+			testFullBox("iinf", u16(2),
+				testBox("infe", []byte{2, 0, 0, 0}, u16(1), u16(0), []byte("jpeg\x00")),
+				testBox("infe", []byte{2, 0, 0, 0}, u16(2), u16(0), []byte("jpeg\x00"))),
+			testFullBox("iref", testBox("auxl", u16(2), u16(1), u16(1))),
+			testBox("iprp",
+				testBox("ipco", testFullBox("auxC", []byte("urn:mpeg:mpegB:cicp:systems:auxiliary:alpha\x00"))),
+				testFullBox("ipma", u32(1), u16(2), []byte{1, 0x81})),
+			testFullBox("iloc", []byte{0x44, 0x00}, u16(2),
+				u16(1), u16(0), u16(1), u32(offset), u32(len(colorData)),
+				u16(2), u16(0), u16(1), u32(offset+len(colorData)), u32(len(alphaData))),
+		)
+	}
+	offset := len(ftyp) + len(meta(0)) + 8
+	return bytes.Join([][]byte{ftyp, meta(offset), testBox("mdat", colorData, alphaData)}, nil)
+}
+
+func TestAlphaPlane(t *testing.T) {
+	data := buildAlphaHEIF(t)
+	id, ok := alphaItemID(bytes.NewReader(data))
+	if !ok || id != 2 {
+		t.Fatalf("alpha item %d, found %v", id, ok)
+	}
+	if _, ok := alphaItemID(bytes.NewReader(buildJPEGHEIF(testJPEG(t)))); ok {
+		t.Error("alpha found in an opaque file")
+	}
+	alpha, err := decodeAlpha(bytes.NewReader(data), id, image.Rect(0, 0, 64, 48))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := alpha.GrayAt(8, 8).Y, alpha.GrayAt(56, 8).Y; a > 4 || b < 251 {
+		t.Errorf("alpha %d on the left, %d on the right", a, b)
+	}
+	if _, err := decodeAlpha(bytes.NewReader(data), id, image.Rect(0, 0, 32, 32)); err == nil {
+		t.Error("alpha plane of another size accepted")
+	}
+}
+
+func TestFlattenAlpha(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.SetRGBA(0, 0, color.RGBA{0, 200, 0, 255})
+	img.SetRGBA(1, 0, color.RGBA{0, 200, 0, 255})
+	alpha := image.NewGray(img.Bounds())
+	alpha.SetGray(0, 0, color.Gray{0})
+	alpha.SetGray(1, 0, color.Gray{128})
+	flat := flattenAlpha(img, alpha, color.RGBA{255, 0, 0, 255}, false)
+	if got := flat.RGBAAt(0, 0); got != (color.RGBA{255, 0, 0, 255}) {
+		t.Errorf("transparent pixel is %v, want the background", got)
+	}
+	if got := flat.RGBAAt(1, 0); got.R < 125 || got.R > 129 || got.G < 98 || got.G > 102 || got.A != 255 {
+		t.Errorf("half-transparent pixel is %v", got)
+	}
+}
+
+func TestParseBackground(t *testing.T) {
+	for value, want := range map[string]color.RGBA{
+		"#ffffff": {255, 255, 255, 255},
+		"1a2b3c":  {0x1a, 0x2b, 0x3c, 255},
+		"Black":   {0, 0, 0, 255},
+	} {
+		if got, err := parseBackground(value); err != nil || got != want {
+			t.Errorf("%s: got %v, %v", value, got, err)
+		}
+	}
+	for _, value := range []string{"", "#fff", "red", "#gggggg"} {
+		if _, err := parseBackground(value); err == nil {
+			t.Errorf("%q accepted", value)
+		}
+	}
+}
+
+func TestConvertAlpha(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sticker.heic"), buildAlphaHEIF(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// By default the file is saved as a PNG, keeping its transparency.
+	out := t.TempDir()
+	result := processFile(manifestEntry{path: "sticker.heic", info: statFile(t, filepath.Join(dir, "sticker.heic"))}, dir, out, defaultOptions())
+	if result.err != nil || result.output != "sticker.png" || result.alpha != "Alpha kept, saved as PNG" {
+		t.Fatalf("got output %q, alpha %q, error %v", result.output, result.alpha, result.err)
+	}
+	f, err := os.Open(filepath.Join(out, "sticker.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, a := img.At(8, 8).RGBA(); a>>8 > 4 {
+		t.Errorf("left half has alpha %d, want transparent", a>>8)
+	}
+	if _, g, _, a := img.At(56, 8).RGBA(); a>>8 < 251 || g>>8 < 190 {
+		t.Errorf("right half is green %d, alpha %d", g>>8, a>>8)
+	}
+
+	// Flattened onto red, it stays a JPEG.
+	opts := defaultOptions()
+	opts.alpha = alphaFlatten
+	opts.alphaBackground = color.RGBA{255, 0, 0, 255}
+	out = t.TempDir()
+	result = processFile(manifestEntry{path: "sticker.heic", info: statFile(t, filepath.Join(dir, "sticker.heic"))}, dir, out, opts)
+	if result.err != nil || result.output != "sticker.jpg" || result.alpha != "Alpha flattened onto #ff0000" {
+		t.Fatalf("got output %q, alpha %q, error %v", result.output, result.alpha, result.err)
+	}
+	f, err = os.Open(filepath.Join(out, "sticker.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err = jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, _, _ := img.At(8, 8).RGBA(); r>>8 < 240 || g>>8 > 20 {
+		t.Errorf("transparent half is %d, %d, want red", r>>8, g>>8)
+	}
+
+	// Ignored, as before --alpha: a JPEG and a warning.
+	opts.alpha = alphaIgnore
+	result = processFile(manifestEntry{path: "sticker.heic", info: statFile(t, filepath.Join(dir, "sticker.heic"))}, dir, t.TempDir(), opts)
+	if result.err != nil || result.output != "sticker.jpg" || result.alpha != "" || len(result.warnings) != 1 || !strings.Contains(result.warnings[0], "alpha") {
+		t.Fatalf("got output %q, alpha %q, warnings %v, error %v", result.output, result.alpha, result.warnings, result.err)
+	}
+}
+
+func statFile(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// WinZip AES (AE-2) parameters. See https://www.winzip.com/en/support/aes-encryption/.
+const (
+	zipMethodAES       = 99
+	zipAESExtraID      = 0x9901
+	zipAESVersion      = 2 // AE-2: the CRC is omitted, the HMAC covers integrity
+	zipAESStrength256  = 3
+	zipAESSaltSize     = 16
+	zipAESKeySize      = 32
+	zipAESVerifierSize = 2
+	zipAESMACSize      = 10
+	zipAESIterations   = 1000
+	zipReaderVersion   = 51
+)
+
+// writeZip packs every file under dir into a zip archive at zipPath, named by
+// their paths relative to dir. Files are stored without compression since
+// JPEGs do not shrink further. With a password each entry is encrypted with
+// AES-256 in the WinZip format, which 7-Zip, WinZip and macOS/Linux unzip
+// tools that support AES can open.
+func writeZip(zipPath, dir, password string) (int, error) {
+	f, err := os.Create(zipPath)
+	if err != nil {
+		return 0, err
+	}
+	zw := zip.NewWriter(f)
+
+	count := 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		header := &zip.FileHeader{Name: filepath.ToSlash(rel), Method: zip.Store}
+		header.SetModTime(info.ModTime())
+		if password == "" {
+			err = addZipEntry(zw, header, data)
+		} else {
+			err = addEncryptedZipEntry(zw, header, data, password)
+		}
+		if err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return count, err
+}
+
+// extractLimits bounds what extractHEICFiles extracts from an archive, so
+// that a small archive of highly compressed entries cannot fill the disk.
+type extractLimits struct {
+	entries   int   // entries in the archive, extracted or not
+	entrySize int64 // uncompressed bytes of one file
+	totalSize int64 // uncompressed bytes of all files
+}
+
+// extractHEICFiles extracts the HEIC files of the zip archive r into dir,
+// keeping their folders, and returns their paths in archive order. macOS
+// resource forks ("._" files) are skipped, and entries whose names would
+// leave dir are refused, as are archives beyond limits.
+func extractHEICFiles(r io.ReaderAt, size int64, dir string, limits extractLimits) ([]string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) > limits.entries {
+		return nil, fmt.Errorf("more than %d entries", limits.entries)
+	}
+	var paths []string
+	var extracted int64
+	for _, entry := range zr.File {
+		name := path.Clean(strings.ReplaceAll(entry.Name, "\\", "/"))
+		if entry.FileInfo().IsDir() || !isHEICFile(name) || strings.HasPrefix(path.Base(name), "._") {
+			continue
+		}
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("archive entry %q is outside the archive", entry.Name)
+		}
+		// The sizes in the archive can lie, so the bytes actually
+		// extracted are what counts.
+		limit, tooLarge := limits.entrySize, fmt.Errorf("larger than %d bytes uncompressed", limits.entrySize)
+		if left := limits.totalSize - extracted; left < limit {
+			limit, tooLarge = left, fmt.Errorf("archive larger than %d bytes uncompressed", limits.totalSize)
+		}
+		dest := filepath.Join(dir, filepath.FromSlash(name))
+		written, err := extractZipEntry(entry, dest, limit)
+		if err == nil && written > limit {
+			err = tooLarge
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		extracted += written
+		paths = append(paths, dest)
+	}
+	return paths, nil
+}
+
+// extractZipEntry extracts entry to dest and returns the bytes written. It
+// stops once it has written more than limit bytes.
+func extractZipEntry(entry *zip.File, dest string, limit int64) (int64, error) {
+	if entry.UncompressedSize64 > uint64(limit) {
+		return int64(entry.UncompressedSize64), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, err
+	}
+	src, err := entry.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	f, err := os.Create(dest)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, io.LimitReader(src, limit+1))
+	if err != nil {
+		f.Close()
+		return written, err
+	}
+	return written, f.Close()
+}
+
+func addZipEntry(zw *zip.Writer, header *zip.FileHeader, data []byte) error {
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// addEncryptedZipEntry writes data as an AE-2 entry: a random salt, the
+// password verifier, the AES-CTR encrypted data and a truncated HMAC-SHA1 of
+// the ciphertext.
+func addEncryptedZipEntry(zw *zip.Writer, header *zip.FileHeader, data []byte, password string) error {
+	salt := make([]byte, zipAESSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	encKey, macKey, verifier := zipAESKeys(password, salt)
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return err
+	}
+
+	ciphertext := make([]byte, len(data))
+	zipAESCrypt(block, ciphertext, data)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(ciphertext)
+
+	extra := binary.LittleEndian.AppendUint16(nil, zipAESExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, 7)
+	extra = binary.LittleEndian.AppendUint16(extra, zipAESVersion)
+	extra = append(extra, 'A', 'E', zipAESStrength256)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(header.Method))
+
+	header.Method = zipMethodAES
+	header.Flags |= 0x1 // encrypted
+	if !isASCII(header.Name) && utf8.ValidString(header.Name) {
+		header.Flags |= 0x800 // UTF-8 name
+	}
+	header.ReaderVersion = zipReaderVersion
+	header.CreatorVersion = zipReaderVersion
+	header.Extra = extra
+	header.UncompressedSize64 = uint64(len(data))
+	header.CompressedSize64 = uint64(len(salt) + len(verifier) + len(ciphertext) + zipAESMACSize)
+
+	w, err := zw.CreateRaw(header)
+	if err != nil {
+		return err
+	}
+	for _, part := range [][]byte{salt, verifier, ciphertext, mac.Sum(nil)[:zipAESMACSize]} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipAESKeys derives the encryption key, authentication key and password
+// verifier for an entry from the password and its salt.
+func zipAESKeys(password string, salt []byte) (encKey, macKey, verifier []byte) {
+	key := pbkdf2SHA1([]byte(password), salt, zipAESIterations, 2*zipAESKeySize+zipAESVerifierSize)
+	return key[:zipAESKeySize], key[zipAESKeySize : 2*zipAESKeySize], key[2*zipAESKeySize:]
+}
+
+// zipAESCrypt encrypts or decrypts src into dst with AES in CTR mode using
+// WinZip's little-endian counter starting at 1, which differs from the
+// big-endian counter of crypto/cipher's CTR.
+func zipAESCrypt(block cipher.Block, dst, src []byte) {
+	var counter, stream [aes.BlockSize]byte
+	for i := 0; i < len(src); i += aes.BlockSize {
+		binary.LittleEndian.PutUint64(counter[:8], uint64(i/aes.BlockSize+1))
+		block.Encrypt(stream[:], counter[:])
+		for j := i; j < len(src) && j < i+aes.BlockSize; j++ {
+			dst[j] = src[j] ^ stream[j-i]
+		}
+	}
+}
+
+// pbkdf2SHA1 implements PBKDF2 (RFC 8018) with HMAC-SHA1.
+func pbkdf2SHA1(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		key = append(key, pbkdf2Block(prf, salt, iterations, block)...)
+	}
+	return key[:keyLen]
+}
+
+func pbkdf2Block(prf hash.Hash, salt []byte, iterations int, block uint32) []byte {
+	prf.Reset()
+	prf.Write(salt)
+	prf.Write(binary.BigEndian.AppendUint32(nil, block))
+	u := prf.Sum(nil)
+	t := append([]byte(nil), u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range t {
+			t[j] ^= u[j]
+		}
+	}
+	return t
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA1(t *testing.T) {
+	// Test vectors from RFC 6070.
+	tests := []struct {
+		iterations int
+		want       string
+	}{
+		{1, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{2, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{4096, "4b007901b765489abead49d926f721d065a429c1"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA1([]byte("password"), []byte("salt"), tt.iterations, 20))
+		if got != tt.want {
+			t.Errorf("%d iterations: got %s, want %s", tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func writeZipSources(t *testing.T) (string, map[string][]byte) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a.jpg":           bytes.Repeat([]byte("first"), 100),
+		"batch-002/b.jpg": []byte("second"), // shorter than one AES block
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, files
+}
+
+func TestWriteZip(t *testing.T) {
+	dir, files := writeZipSources(t)
+	zipPath := filepath.Join(t.TempDir(), "out.zip")
+	count, err := writeZip(zipPath, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != len(files) {
+		t.Fatalf("got %d entries, want %d", count, len(files))
+	}
+
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, files[f.Name]) {
+			t.Errorf("%s: content mismatch", f.Name)
+		}
+	}
+}
+
+func TestWriteZipEncrypted(t *testing.T) {
+	dir, files := writeZipSources(t)
+	zipPath := filepath.Join(t.TempDir(), "out.zip")
+	if _, err := writeZip(zipPath, dir, "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != len(files) {
+		t.Fatalf("got %d entries, want %d", len(zr.File), len(files))
+	}
+	for _, f := range zr.File {
+		if f.Method != zipMethodAES || f.Flags&0x1 == 0 {
+			t.Fatalf("%s: method %d, flags %#x; want AES encrypted", f.Name, f.Method, f.Flags)
+		}
+		if len(f.Extra) != 11 || binary.LittleEndian.Uint16(f.Extra) != zipAESExtraID || f.Extra[8] != zipAESStrength256 {
+			t.Fatalf("%s: unexpected AES extra field %x", f.Name, f.Extra)
+		}
+		rc, err := f.OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		salt := raw[:zipAESSaltSize]
+		verifier := raw[zipAESSaltSize : zipAESSaltSize+zipAESVerifierSize]
+		ciphertext := raw[zipAESSaltSize+zipAESVerifierSize : len(raw)-zipAESMACSize]
+		encKey, macKey, wantVerifier := zipAESKeys("s3cret", salt)
+		if !bytes.Equal(verifier, wantVerifier) {
+			t.Fatalf("%s: password verifier mismatch", f.Name)
+		}
+		mac := hmac.New(sha1.New, macKey)
+		mac.Write(ciphertext)
+		if !bytes.Equal(mac.Sum(nil)[:zipAESMACSize], raw[len(raw)-zipAESMACSize:]) {
+			t.Fatalf("%s: authentication code mismatch", f.Name)
+		}
+		if bytes.Equal(ciphertext, files[f.Name]) {
+			t.Fatalf("%s: stored in plain text", f.Name)
+		}
+
+		block, err := aes.NewCipher(encKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain := make([]byte, len(ciphertext))
+		zipAESCrypt(block, plain, ciphertext)
+		if !bytes.Equal(plain, files[f.Name]) {
+			t.Errorf("%s: decrypted content mismatch", f.Name)
+		}
+	}
+}
+
+func TestOutputZipEncryptedLeavesNothing(t *testing.T) {
+	dir := writePlanFixture(t, "a.heic")
+	zipPath := filepath.Join(t.TempDir(), "out.zip")
+	opts, err := parseOptions([]string{"--non-interactive", "--output-zip", zipPath, "--zip-password", "s3cret", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegDir, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neither the staged outputs nor the log, which names them, are left
+	// in plain text.
+	if entries, err := os.ReadDir(jpegDir); err != nil || len(entries) != 0 {
+		t.Errorf("output directory holds %v, %v", entries, err)
+	}
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "a.jpg,logs.txt" {
+		t.Errorf("archive holds %v, want the output and the log", names)
+	}
+}
+
+func TestParseOptionsOutputZip(t *testing.T) {
+	if _, err := parseOptions([]string{"--zip-password", "x"}, io.Discard); err == nil {
+		t.Error("--zip-password without --output-zip should fail")
+	}
+	if _, err := parseOptions([]string{"--output-zip", "out.zip", "--output-format", "pdf"}, io.Discard); err == nil {
+		t.Error("--output-zip with pdf output should fail")
+	}
+	opts, err := parseOptions([]string{"--output-zip", "out.zip", "--zip-password", "x"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.outputZip != "out.zip" || opts.zipPassword != "x" {
+		t.Errorf("got outputZip %q, zipPassword %q", opts.outputZip, opts.zipPassword)
+	}
+}
+
+func TestExtractHEICFilesRefusesEscapes(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.Create("../../evil.heic")
+	w.Write([]byte("data"))
+	zw.Close()
+
+	dir := t.TempDir()
+	if _, err := extractHEICFiles(bytes.NewReader(archive.Bytes()), int64(archive.Len()), dir, uploadLimits); err == nil || !strings.Contains(err.Error(), "outside the archive") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestExtractHEICFilesLimits(t *testing.T) {
+	build := func(sizes ...int) []byte {
+		var archive bytes.Buffer
+		zw := zip.NewWriter(&archive)
+		for i, size := range sizes {
+			w, _ := zw.Create(fmt.Sprintf("IMG_%d.heic", i))
+			w.Write(make([]byte, size)) // zeros deflate to almost nothing
+		}
+		zw.Close()
+		return archive.Bytes()
+	}
+	limits := extractLimits{entries: 3, entrySize: 1000, totalSize: 1500}
+	for _, tt := range []struct {
+		name  string
+		sizes []int
+		want  string
+	}{
+		{"within the limits", []int{1000, 500}, ""},
+		{"too many entries", []int{1, 1, 1, 1}, "more than 3 entries"},
+		{"entry too large", []int{1001}, "IMG_0.heic: larger than 1000 bytes"},
+		{"archive too large", []int{800, 800}, "IMG_1.heic: archive larger than 1500 bytes"},
+	} {
+		archive := build(tt.sizes...)
+		dir := t.TempDir()
+		paths, err := extractHEICFiles(bytes.NewReader(archive), int64(len(archive)), dir, limits)
+		switch {
+		case tt.want == "" && (err != nil || len(paths) != len(tt.sizes)):
+			t.Errorf("%s: got %v, %v", tt.name, paths, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// An entry whose header understates its size is cut off all the same.
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, _ := zw.CreateHeader(&zip.FileHeader{Name: "IMG_0.heic", Method: zip.Deflate})
+	w.Write(make([]byte, 5000))
+	zw.Close()
+	data := archive.Bytes()
+	// Patch the uncompressed size in the local and central headers.
+	for _, sig := range [][]byte{{'P', 'K', 3, 4}, {'P', 'K', 1, 2}} {
+		i := bytes.Index(data, sig)
+		offset := 22
+		if sig[2] == 1 {
+			offset = 24
+		}
+		binary.LittleEndian.PutUint32(data[i+offset:], 10)
+	}
+	if _, err := extractHEICFiles(bytes.NewReader(data), int64(len(data)), t.TempDir(), limits); err == nil {
+		t.Error("understated entry extracted")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// pendingOutput is an output being written to a temporary file next to it,
+// which commit renames into place. An output written over an earlier one,
+// as --existing merge and watch mode do, replaces it in one go: a smaller
+// file leaves none of the old bytes behind, and nothing ever sees it half
+// written.
+type pendingOutput struct {
+	*os.File
+	path string
+}
+
+// createOutput starts writing the output at path.
+func createOutput(path string) (*pendingOutput, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// CreateTemp makes files only their owner can read.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &pendingOutput{File: f, path: path}, nil
+}
+
+// commit closes the temporary file and renames it over the output, once
+// check, unless nil, passes on the temporary file. An output that fails
+// check is removed, and an earlier one left as it was.
+func (p *pendingOutput) commit(check func(path string) error) error {
+	if err := p.File.Close(); err != nil {
+		os.Remove(p.Name())
+		return err
+	}
+	if check != nil {
+		if err := check(p.Name()); err != nil {
+			os.Remove(p.Name())
+			return err
+		}
+	}
+	if err := os.Rename(p.Name(), p.path); err != nil {
+		os.Remove(p.Name())
+		return err
+	}
+	return nil
+}
+
+// discard closes and removes the temporary file, leaving the output as it
+// was.
+func (p *pendingOutput) discard() {
+	p.File.Close()
+	os.Remove(p.Name())
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteEncodedReplaces(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "a.jpg")
+	if err := writeEncoded(output, bytes.NewBuffer(bytes.Repeat([]byte{1}, 1000)), nil, defaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeEncoded(output, bytes.NewBufferString("short"), nil, defaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != "short" {
+		t.Errorf("got %q, %v", data, err)
+	}
+	if info, err := os.Stat(output); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("output mode %v, %v", info.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
+
+func TestExistingMergeShrinks(t *testing.T) {
+	dir := writePlanFixture(t, "a.heic")
+	output := filepath.Join(dir, "jpegs", "a.jpg")
+	sizes := []int64{}
+	for _, quality := range []string{"100", "10"} {
+		opts, err := parseOptions([]string{"--non-interactive", "--existing", "merge", "--quality", quality, dir}, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, statFile(t, output).Size())
+	}
+	if sizes[1] >= sizes[0]/2 {
+		t.Errorf("quality 10 output is %d bytes after %d at quality 100", sizes[1], sizes[0])
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateJPEG(data, image.Rectangle{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOtherOutputsAtomic(t *testing.T) {
+	dir := t.TempDir()
+	opts := defaultOptions()
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	writes := map[string]func() error{
+		"png": func() error { return writePNG(img, nil, filepath.Join(dir, "a.png"), opts) },
+		"copy": func() error {
+			_, err := copySource([]byte("heic"), "a.heic", filepath.Join(dir, "a.jpg"), opts)
+			return err
+		},
+		"raw": func() error {
+			opts := opts
+			opts.outputFormat = formatPPM
+			_, err := writeRawFile(img, filepath.Join(dir, "a.jpg"), opts)
+			return err
+		},
+		"sidecar": func() error { return writeSidecar(dir, "a.jpg", []byte("heic"), "a.heic", "", opts) },
+	}
+	for name, write := range writes {
+		if err := write(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".tmp" {
+			t.Errorf("temporary file left: %s", entry.Name())
+		}
+	}
+	if len(entries) != len(writes) {
+		t.Errorf("got %d files, want %d", len(entries), len(writes))
+	}
+
+	// A video copy that fails part way leaves no output behind.
+	output := filepath.Join(t.TempDir(), "clip.mov")
+	if err := copyVideo(t.TempDir(), output, opts); err == nil {
+		t.Error("copying a directory as a video succeeded")
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(output)); len(entries) != 0 {
+		t.Errorf("failed copy left %v", entries)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Problems reported by `heictojpeg audit`.
+const (
+	auditMissing    = "missing"
+	auditExtra      = "extra"
+	auditEmpty      = "empty"
+	auditUnreadable = "unreadable"
+	auditDimensions = "dimensions"
+)
+
+// auditProblem is one line of the reconciliation list.
+type auditProblem struct {
+	Problem string `json:"problem"`
+	Source  string `json:"source,omitempty"`
+	Output  string `json:"output,omitempty"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// auditReport is the result of auditing an output folder against its
+// source folder.
+type auditReport struct {
+	Sources  int            `json:"sources"`
+	Outputs  int            `json:"outputs"`
+	Matched  int            `json:"matched"`
+	Problems []auditProblem `json:"problems"`
+}
+
+// tileSuffix matches the _tileNN suffix of --tile outputs.
+var tileSuffix = regexp.MustCompile(`_tile\d+$`)
+
+// isAuditOutput reports whether a file in the output folder is a converted
+// image, rather than a log, sidecar or other bookkeeping.
+func isAuditOutput(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".ppm", ".rgba", ".heic":
+		return true
+	}
+	return false
+}
+
+// outputKey is the name outputs are matched to sources by: the file name
+// without extension or tile suffix, in lower case.
+func outputKey(name string) string {
+	base := filepath.Base(name)
+	return strings.ToLower(tileSuffix.ReplaceAllString(strings.TrimSuffix(base, filepath.Ext(base)), ""))
+}
+
+// audit cross-checks the HEIC files of srcDir (after its ignore file) with
+// the outputs anywhere under outDir, including batch, location and
+// per-source subfolders. Outputs are matched to sources by the source path
+// in their sidecar, if they have one, and otherwise by file name. Nothing
+// is written.
+func audit(srcDir, outDir string) (auditReport, error) {
+	var report auditReport
+	entries, err := getFilesInDirectory(srcDir)
+	if err != nil {
+		return report, err
+	}
+	rules, err := loadIgnoreFile(srcDir)
+	if err != nil {
+		return report, err
+	}
+	bySource := map[string]string{} // absolute path -> source name
+	byKey := map[string]string{}    // output key -> source name
+	var sources []string
+	for _, entry := range rules.filter(entries) {
+		if entry.IsDir() || !isHEICFile(entry.Name()) {
+			continue
+		}
+		sources = append(sources, entry.Name())
+		if abs, err := filepath.Abs(filepath.Join(srcDir, entry.Name())); err == nil {
+			bySource[abs] = entry.Name()
+		}
+		byKey[outputKey(entry.Name())] = entry.Name()
+	}
+	report.Sources = len(sources)
+
+	matched := map[string][]string{} // source name -> outputs
+	err = filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isAuditOutput(d.Name()) {
+			return nil
+		}
+		rel, _ := filepath.Rel(outDir, path)
+		rel = filepath.ToSlash(rel)
+		report.Outputs++
+
+		source, ok := byKey[outputKey(d.Name())]
+		if data, err := os.ReadFile(sidecarPath(path)); err == nil {
+			var record sidecar
+			if json.Unmarshal(data, &record) == nil {
+				source, ok = bySource[record.Source]
+			}
+		}
+		if !ok {
+			report.Problems = append(report.Problems, auditProblem{Problem: auditExtra, Output: rel, Detail: "no matching source"})
+			return nil
+		}
+		matched[source] = append(matched[source], rel)
+		if problem := checkAuditOutput(filepath.Join(srcDir, source), path); problem.Problem != "" {
+			problem.Source, problem.Output = source, rel
+			report.Problems = append(report.Problems, problem)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, source := range sources {
+		if len(matched[source]) == 0 {
+			report.Problems = append(report.Problems, auditProblem{Problem: auditMissing, Source: source, Detail: "no output"})
+			continue
+		}
+		report.Matched++
+	}
+	sort.SliceStable(report.Problems, func(i, j int) bool {
+		a, b := report.Problems[i], report.Problems[j]
+		return a.Source+a.Output < b.Source+b.Output
+	})
+	return report, nil
+}
+
+// checkAuditOutput checks one output against its source: that it is not
+// empty, that JPEG and PNG outputs can be read, and that their size is the
+// source's, or the source scaled down with the same aspect ratio (as
+// --resize and --max-pixels do). Tiles are only checked for being readable.
+func checkAuditOutput(sourcePath, outputPath string) auditProblem {
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return auditProblem{Problem: auditUnreadable, Detail: err.Error()}
+	}
+	if info.Size() == 0 {
+		return auditProblem{Problem: auditEmpty, Detail: "zero-byte file"}
+	}
+
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch strings.ToLower(filepath.Ext(outputPath)) {
+	case ".jpg", ".jpeg":
+		decodeConfig = jpeg.DecodeConfig
+	case ".png":
+		decodeConfig = png.DecodeConfig
+	default:
+		return auditProblem{}
+	}
+	f, err := os.Open(outputPath)
+	if err != nil {
+		return auditProblem{Problem: auditUnreadable, Detail: err.Error()}
+	}
+	defer f.Close()
+	config, err := decodeConfig(f)
+	if err != nil {
+		return auditProblem{Problem: auditUnreadable, Detail: err.Error()}
+	}
+	if tileSuffix.MatchString(strings.TrimSuffix(filepath.Base(outputPath), filepath.Ext(outputPath))) {
+		return auditProblem{}
+	}
+
+	src, err := os.Open(sourcePath)
+	if err != nil {
+		return auditProblem{}
+	}
+	defer src.Close()
+	width, height, ok := imageDimensions(src)
+	if !ok || sameShape(config.Width, config.Height, width, height) || sameShape(config.Width, config.Height, height, width) {
+		return auditProblem{}
+	}
+	return auditProblem{Problem: auditDimensions, Detail: fmt.Sprintf("output is %dx%d, source is %dx%d", config.Width, config.Height, width, height)}
+}
+
+// sameShape reports whether a w x h image is the width x height one, or a
+// smaller copy of it with the same aspect ratio to within a pixel.
+func sameShape(w, h, width, height int) bool {
+	if w > width || h > height || w < 1 || h < 1 {
+		return false
+	}
+	// Scaling rounds one side to a whole pixel: compare the other side
+	// with what it would have been.
+	d := w*height - h*width
+	if d < 0 {
+		d = -d
+	}
+	return d <= width || d <= height
+}
+
+// runAudit implements `heictojpeg audit [--json] SOURCE-DIR OUTPUT-DIR`.
+// It returns an error when the folders do not reconcile, so scripts can
+// test the exit status.
+func runAudit(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: heictojpeg audit [--json] SOURCE-DIR OUTPUT-DIR")
+		fs.PrintDefaults()
+	}
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("audit needs a source and an output directory")
+	}
+
+	report, err := audit(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if report.Problems == nil {
+			report.Problems = []auditProblem{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, p := range report.Problems {
+			name := p.Source
+			if p.Output != "" {
+				name = p.Output
+				if p.Source != "" {
+					name = p.Source + " > " + p.Output
+				}
+			}
+			fmt.Fprintf(out, "%-10s %s: %s\n", p.Problem, name, p.Detail)
+		}
+		fmt.Fprintf(out, "%d sources, %d outputs, %d sources converted, %d problems\n", report.Sources, report.Outputs, report.Matched, len(report.Problems))
+	}
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("%s and %s do not reconcile: %d problems", fs.Arg(0), fs.Arg(1), len(report.Problems))
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeAuditJPEG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	camel, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, out := t.TempDir(), t.TempDir()
+	for _, name := range []string{"ok.heic", "scaled.heic", "tiled.heic", "missing.heic", "empty.heic", "cropped.heic", "skipped.heic"} {
+		if err := os.WriteFile(filepath.Join(src, name), camel, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(src, ignoreFileName), []byte("skipped.heic\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The camel is 1596x1064.
+	writeAuditJPEG(t, filepath.Join(out, "ok.jpg"), 1596, 1064)
+	writeAuditJPEG(t, filepath.Join(out, "batch-002", "scaled.jpg"), 800, 533)
+	writeAuditJPEG(t, filepath.Join(out, "tiled_tile1.jpg"), 100, 1064)
+	writeAuditJPEG(t, filepath.Join(out, "cropped.jpg"), 851, 1064)
+	writeAuditJPEG(t, filepath.Join(out, "stray.jpg"), 10, 10)
+	if err := os.WriteFile(filepath.Join(out, "empty.jpg"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(out, logFileName), []byte("log\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sidecar ties a templated name to its source.
+	writeAuditJPEG(t, filepath.Join(out, "2023", "renamed.jpg"), 1596, 1064)
+	abs, _ := filepath.Abs(filepath.Join(src, "ok.heic"))
+	record, _ := json.Marshal(sidecar{Source: abs})
+	if err := os.WriteFile(filepath.Join(out, "2023", "renamed.jpg.json"), record, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := audit(src, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sources != 6 || report.Outputs != 7 || report.Matched != 5 {
+		t.Errorf("unexpected counts %+v", report)
+	}
+	want := []auditProblem{
+		{Problem: auditDimensions, Source: "cropped.heic", Output: "cropped.jpg", Detail: "output is 851x1064, source is 1596x1064"},
+		{Problem: auditEmpty, Source: "empty.heic", Output: "empty.jpg", Detail: "zero-byte file"},
+		{Problem: auditMissing, Source: "missing.heic", Detail: "no output"},
+		{Problem: auditExtra, Output: "stray.jpg", Detail: "no matching source"},
+	}
+	if len(report.Problems) != len(want) {
+		t.Fatalf("got problems %+v, want %+v", report.Problems, want)
+	}
+	for i := range want {
+		if report.Problems[i] != want[i] {
+			t.Errorf("problem %d: got %+v, want %+v", i, report.Problems[i], want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := runAudit([]string{"--json", src, out}, &buf); err == nil {
+		t.Error("expected an error for folders that do not reconcile")
+	}
+	var decoded auditReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Problems) != len(want) {
+		t.Errorf("unexpected JSON report %s (%v)", buf.Bytes(), err)
+	}
+}
+
+func TestSameShape(t *testing.T) {
+	for _, tc := range []struct {
+		w, h, width, height int
+		want                bool
+	}{
+		{4032, 3024, 4032, 3024, true},
+		{1920, 1440, 4032, 3024, true},
+		{1000, 667, 1596, 1064, true},
+		{1080, 1350, 4032, 3024, false},
+		{5000, 3750, 4032, 3024, false},
+	} {
+		if got := sameShape(tc.w, tc.h, tc.width, tc.height); got != tc.want {
+			t.Errorf("%dx%d of %dx%d: got %v", tc.w, tc.h, tc.width, tc.height, got)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"image"
+)
+
+// formatAuto is --output-format auto: PNG for screenshots and other
+// graphics, where JPEG rings around text and hard edges, and JPEG for
+// photos, decided per file.
+const formatAuto = "auto"
+
+// The graphics heuristic samples pixels on a grid of at most
+// autoSampleSide points each way and compares each with its right-hand
+// neighbour. Graphics are mostly flat, with few colours and some sharp
+// edges; photos have sensor noise and smooth gradients everywhere.
+const (
+	autoSampleSide = 512
+	autoFlatDiff   = 3     // largest channel difference of flat neighbours
+	autoEdgeDiff   = 96    // smallest channel difference of an edge
+	autoMinFlat    = 0.6   // share of flat neighbours graphics have at least
+	autoMinEdges   = 0.002 // share of edges graphics have at least
+	autoMaxColors  = 0.02  // distinct colours, 15-bit, per sample at most
+)
+
+// autoOutputFormat picks the output format of img for --output-format
+// auto: PNG when it is an Apple screenshot (isScreenshot) or looks like a
+// graphic, JPEG otherwise.
+func autoOutputFormat(img image.Image, exif []byte) string {
+	b := img.Bounds()
+	if isScreenshot(exif, b.Dx(), b.Dy()) || looksLikeGraphic(img) {
+		return formatPNG
+	}
+	return formatJPEG
+}
+
+// looksLikeGraphic reports whether img has the flat areas, small palette
+// and sharp edges of a screenshot, diagram or other drawn image.
+func looksLikeGraphic(img image.Image) bool {
+	b := img.Bounds()
+	if b.Dx() < 2 || b.Dy() < 1 {
+		return false
+	}
+	step := b.Dx()
+	if b.Dy() > step {
+		step = b.Dy()
+	}
+	step = (step + autoSampleSide - 1) / autoSampleSide
+
+	colors := map[uint16]bool{}
+	samples, flat, edges := 0, 0, 0
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x+1 < b.Max.X; x += step {
+			r1, g1, b1 := rgb8(img, x, y)
+			r2, g2, b2 := rgb8(img, x+1, y)
+			diff := absDiff(r1, r2)
+			if d := absDiff(g1, g2); d > diff {
+				diff = d
+			}
+			if d := absDiff(b1, b2); d > diff {
+				diff = d
+			}
+			switch {
+			case diff <= autoFlatDiff:
+				flat++
+			case diff >= autoEdgeDiff:
+				edges++
+			}
+			colors[uint16(r1>>3)<<10|uint16(g1>>3)<<5|uint16(b1>>3)] = true
+			samples++
+		}
+	}
+	n := float64(samples)
+	return float64(flat)/n >= autoMinFlat && float64(edges)/n >= autoMinEdges &&
+		float64(len(colors))/n <= autoMaxColors
+}
+
+// rgb8 is the 8-bit colour of the pixel at x, y.
+func rgb8(img image.Image, x, y int) (uint8, uint8, uint8) {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
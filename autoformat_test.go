@@ -0,0 +1,91 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testGraphic draws flat panels with hard-edged text-like bars, as a
+// screenshot or diagram has.
+func testGraphic(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, width, 40), image.NewUniform(color.NRGBA{0, 122, 255, 255}), image.Point{}, draw.Src)
+	for y := 60; y+8 < height; y += 20 {
+		for x := 10; x+6 < width; x += 9 {
+			draw.Draw(img, image.Rect(x, y, x+6, y+8), image.NewUniform(color.Black), image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// noisyGradient is a noisy gradient, as a camera records.
+func noisyGradient(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			noise := rng.Intn(24)
+			img.Set(x, y, color.NRGBA{uint8(x * 200 / width), uint8(80 + noise), uint8(y * 200 / height), 255})
+		}
+	}
+	return img
+}
+
+func TestLooksLikeGraphic(t *testing.T) {
+	if !looksLikeGraphic(testGraphic(400, 300)) {
+		t.Error("graphic not recognised")
+	}
+	if looksLikeGraphic(noisyGradient(400, 300)) {
+		t.Error("photo taken for a graphic")
+	}
+	blank := image.NewNRGBA(image.Rect(0, 0, 400, 300))
+	if looksLikeGraphic(blank) {
+		t.Error("blank image without edges taken for a graphic")
+	}
+	if looksLikeGraphic(image.NewNRGBA(image.Rect(0, 0, 1, 1))) {
+		t.Error("single pixel taken for a graphic")
+	}
+}
+
+func TestAutoOutputFormat(t *testing.T) {
+	if got := autoOutputFormat(testGraphic(400, 300), nil); got != formatPNG {
+		t.Errorf("graphic: got %s", got)
+	}
+	if got := autoOutputFormat(noisyGradient(400, 300), nil); got != formatJPEG {
+		t.Errorf("photo: got %s", got)
+	}
+	// A screenshot is PNG whatever it shows.
+	if got := autoOutputFormat(noisyGradient(640, 960), nil); got != formatPNG {
+		t.Errorf("screen-sized image without a camera: got %s", got)
+	}
+}
+
+func TestOutputFormatAuto(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--output-format", "auto", "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegDir, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(jpegDir, "camel.jpg")); err != nil {
+		t.Errorf("photo not converted to JPEG: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+)
+
+var errNotHEIC = errors.New("not a .heic, .hif or .heif file")
+
+// pathEvent reports the outcome of one file in a pathBatch.
+type pathEvent struct {
+	Path   string // source path as passed to add
+	Output string // converted file relative to the output directory, "" on failure
+	PHash  string // perceptual hash of the image, with --compute-phash
+	Err    error
+	Done   int // files finished so far, including this one
+	Total  int
+}
+
+// pathBatch converts an explicit list of files with per-file progress and
+// cancellation, for the file manager integration and the server's job
+// queue. It is not a Go API: the converter is a command, with no library
+// package to import it from.
+type pathBatch struct {
+	opts  options
+	paths []string
+}
+
+// newPathBatch returns an empty batch converting with opts. Outputs go to
+// opts.outputDir, or a jpegs folder in the working directory.
+func newPathBatch(opts options) *pathBatch {
+	return &pathBatch{opts: opts}
+}
+
+// add queues source paths.
+func (b *pathBatch) add(paths ...string) {
+	b.paths = append(b.paths, paths...)
+}
+
+// run converts the queued files on a worker pool, calling progress (if
+// non-nil) from a single goroutine after each file. Cancelling ctx stops new
+// files from starting; files not converted are reported with ctx's error,
+// which run then returns. All events are also returned in completion order.
+func (b *pathBatch) run(ctx context.Context, progress func(pathEvent)) ([]pathEvent, error) {
+	jpegDir, err := resolveOutputDir("", b.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	total := len(b.paths)
+	events := make([]pathEvent, 0, total)
+	report := func(ev pathEvent) {
+		ev.Done, ev.Total = len(events)+1, total
+		events = append(events, ev)
+		if progress != nil {
+			progress(ev)
+		}
+	}
+
+	jobs := make(chan string)
+	results := make(chan pathEvent)
+	var wg sync.WaitGroup
+	for i := 0; i < b.opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				results <- b.convert(ctx, path, jpegDir)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, path := range b.paths {
+			select {
+			case jobs <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	seen := make(map[string]int, total)
+	for ev := range results {
+		seen[ev.Path]++
+		report(ev)
+	}
+	// Files never handed to a worker because of cancellation.
+	var runErr error
+	for _, path := range b.paths {
+		if seen[path] > 0 {
+			seen[path]--
+			continue
+		}
+		runErr = ctx.Err()
+		report(pathEvent{Path: path, Err: runErr})
+	}
+	for _, ev := range events {
+		if errors.Is(ev.Err, context.Canceled) || errors.Is(ev.Err, context.DeadlineExceeded) {
+			runErr = ev.Err
+		}
+	}
+	return events, runErr
+}
+
+func (b *pathBatch) convert(ctx context.Context, path, jpegDir string) pathEvent {
+	if err := ctx.Err(); err != nil {
+		return pathEvent{Path: path, Err: err}
+	}
+	return convertPath(path, jpegDir, b.opts)
+}
+
+// convertPath converts one source file given by path into jpegDir.
+func convertPath(path, jpegDir string, opts options) pathEvent {
+	info, err := os.Stat(path)
+	if err != nil {
+		return pathEvent{Path: path, Err: err}
+	}
+	// Paths are used as given, like --files-from entries.
+	result := processFile(manifestEntry{path: path, info: info}, "", jpegDir, opts)
+	if result == nil {
+		return pathEvent{Path: path, Err: errNotHEIC}
+	}
+	if result.err != nil {
+		return pathEvent{Path: path, Err: result.err}
+	}
+	return pathEvent{Path: path, Output: result.output, PHash: result.phash}
+}
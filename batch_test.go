@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathBatchRun(t *testing.T) {
+	opts := defaultOptions()
+	opts.outputDir = t.TempDir()
+	opts.embedThumbnail = false
+
+	b := newPathBatch(opts)
+	b.add(filepath.Join("testdata", "images", "goheif-camel.heic"), filepath.Join("testdata", "images", "missing.heic"))
+
+	var progress []pathEvent
+	events, err := b.run(context.Background(), func(ev pathEvent) { progress = append(progress, ev) })
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(events) != 2 || len(progress) != 2 {
+		t.Fatalf("got %d events and %d callbacks, want 2", len(events), len(progress))
+	}
+	for i, ev := range events {
+		if ev.Done != i+1 || ev.Total != 2 {
+			t.Errorf("event %d counted %d/%d", i, ev.Done, ev.Total)
+		}
+		switch filepath.Base(ev.Path) {
+		case "goheif-camel.heic":
+			if ev.Err != nil || ev.Output != "goheif-camel.jpg" {
+				t.Errorf("conversion event %+v", ev)
+			}
+		case "missing.heic":
+			if ev.Err == nil || ev.Output != "" {
+				t.Errorf("missing file event %+v", ev)
+			}
+		}
+	}
+}
+
+func TestPathBatchRunCancelled(t *testing.T) {
+	opts := defaultOptions()
+	opts.outputDir = t.TempDir()
+	b := newPathBatch(opts)
+	b.add(filepath.Join("testdata", "images", "goheif-camel.heic"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	events, err := b.run(ctx, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if len(events) != 1 || !errors.Is(events[0].Err, context.Canceled) {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultBenchQualities are the --quality values `heictojpeg bench` compares
+// unless --qualities says otherwise.
+const defaultBenchQualities = "60,70,80,85,90,95"
+
+// benchSubsampling is the only chroma subsampling image/jpeg writes, so the
+// bench grid cannot vary it.
+const benchSubsampling = "4:2:0"
+
+// ssimWindow is the side of the windows SSIM is averaged over, and
+// ssimStep how far apart they are.
+const (
+	ssimWindow = 8
+	ssimStep   = 4
+)
+
+// benchResult is one row of `heictojpeg bench`: the JPEG one combination of
+// settings produces.
+type benchResult struct {
+	Quality         int     `json:"quality"`
+	OptimizeHuffman bool    `json:"optimize_huffman"`
+	Size            int     `json:"size"`
+	Ratio           float64 `json:"ratio"` // Size over the source's size
+	SSIM            float64 `json:"ssim"`
+	EncodeMillis    float64 `json:"encode_ms"`
+}
+
+// benchReport is everything `heictojpeg bench --json` prints.
+type benchReport struct {
+	Source       string        `json:"source"`
+	SourceSize   int           `json:"source_size"`
+	Width        int           `json:"width"`
+	Height       int           `json:"height"`
+	Decoder      string        `json:"decoder"`
+	Subsampling  string        `json:"subsampling"`
+	DecodeMillis float64       `json:"decode_ms"`
+	Results      []benchResult `json:"results"`
+}
+
+// runBench implements `heictojpeg bench FILE`: it decodes one photo and
+// encodes it at every quality in --qualities, with the standard and with
+// optimized Huffman tables, reporting the size, SSIM against the decoded
+// image and encoding time of each.
+func runBench(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(out)
+	qualityList := fs.String("qualities", defaultBenchQualities, "comma-separated JPEG qualities to compare, 1-100")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: heictojpeg bench [--qualities LIST] [--json] FILE")
+	}
+	qualities, err := parseQualities(*qualityList)
+	if err != nil {
+		return err
+	}
+
+	path := fs.Arg(0)
+	source, err := readSource(path)
+	if err != nil {
+		return err
+	}
+	defer putBuffer(source)
+	start := time.Now()
+	img, err := decodeHEIC(bytes.NewReader(source.Bytes()))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	report := benchReport{
+		Source:       path,
+		SourceSize:   source.Len(),
+		Width:        img.Bounds().Dx(),
+		Height:       img.Bounds().Dy(),
+		Decoder:      decoderBackend,
+		Subsampling:  benchSubsampling,
+		DecodeMillis: millis(time.Since(start)),
+	}
+	report.Results, err = benchQualities(img, qualities, report.SourceSize)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	fmt.Fprintf(out, "%s: %dx%d, %s\n", report.Source, report.Width, report.Height, humanReadableFileSize(int64(report.SourceSize)))
+	fmt.Fprintf(out, "Decoder: %s, %.0fms\n", report.Decoder, report.DecodeMillis)
+	fmt.Fprintf(out, "Chroma subsampling: %s (the only one the encoder writes)\n\n", report.Subsampling)
+	fmt.Fprintf(out, "%-7s  %-9s  %9s  %6s  %7s  %8s\n", "QUALITY", "HUFFMAN", "SIZE", "RATIO", "SSIM", "TIME")
+	for _, r := range report.Results {
+		huffman := "standard"
+		if r.OptimizeHuffman {
+			huffman = "optimized"
+		}
+		fmt.Fprintf(out, "%-7d  %-9s  %9s  %5.0f%%  %7.4f  %6.0fms\n", r.Quality, huffman, humanReadableFileSize(int64(r.Size)), 100*r.Ratio, r.SSIM, r.EncodeMillis)
+	}
+	return nil
+}
+
+// parseQualities parses the --qualities list of `heictojpeg bench`.
+func parseQualities(value string) ([]int, error) {
+	var qualities []int
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		q, err := strconv.Atoi(item)
+		if err != nil || q < 1 || q > 100 {
+			return nil, fmt.Errorf("invalid quality %q: must be 1-100", item)
+		}
+		qualities = append(qualities, q)
+	}
+	if len(qualities) == 0 {
+		return nil, fmt.Errorf("--qualities lists no qualities")
+	}
+	return qualities, nil
+}
+
+// benchQualities encodes img at each quality, as --optimize-huffman would
+// and would not. Re-coding keeps the coefficients, so both share the SSIM
+// of the standard encoding.
+func benchQualities(img image.Image, qualities []int, sourceSize int) ([]benchResult, error) {
+	original, width, height := lumaPlane(img)
+	var results []benchResult
+	var encoded bytes.Buffer
+	for _, q := range qualities {
+		for _, optimize := range []bool{false, true} {
+			opts := defaultOptions()
+			opts.optimizeHuffman = optimize
+			encoded.Reset()
+			start := time.Now()
+			if err := encodeJPEGImage(&encoded, img, q, opts); err != nil {
+				return nil, err
+			}
+			r := benchResult{Quality: q, OptimizeHuffman: optimize, Size: encoded.Len(), EncodeMillis: millis(time.Since(start))}
+			if sourceSize > 0 {
+				r.Ratio = float64(r.Size) / float64(sourceSize)
+			}
+			if optimize {
+				r.SSIM = results[len(results)-1].SSIM
+			} else {
+				decoded, err := jpeg.Decode(bytes.NewReader(encoded.Bytes()))
+				if err != nil {
+					return nil, err
+				}
+				luma, _, _ := lumaPlane(decoded)
+				r.SSIM = ssim(original, luma, width, height)
+			}
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// millis is d in fractional milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ssim is the mean structural similarity of two width x height luma planes,
+// over ssimWindow-square windows every ssimStep pixels (smaller ones for
+// tiny images): 1 for identical images, lower the more visible the
+// differences.
+func ssim(a, b []uint8, width, height int) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	if width < 1 || height < 1 || len(a) != width*height || len(b) != len(a) {
+		return 0
+	}
+	window := ssimWindow
+	if width < window {
+		window = width
+	}
+	if height < window {
+		window = height
+	}
+	n := float64(window * window)
+	var total float64
+	windows := 0
+	for y := 0; y+window <= height; y += ssimStep {
+		for x := 0; x+window <= width; x += ssimStep {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for wy := y; wy < y+window; wy++ {
+				row := wy * width
+				for wx := x; wx < x+window; wx++ {
+					va, vb := float64(a[row+wx]), float64(b[row+wx])
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+			meanA, meanB := sumA/n, sumB/n
+			varA, varB := sumAA/n-meanA*meanA, sumBB/n-meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += (2*meanA*meanB + c1) * (2*cov + c2) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestSSIM(t *testing.T) {
+	const width, height = 16, 12
+	a := make([]uint8, width*height)
+	for i := range a {
+		a[i] = uint8(i * 7)
+	}
+	if got := ssim(a, a, width, height); math.Abs(got-1) > 1e-9 {
+		t.Errorf("identical planes: got %v", got)
+	}
+	noisy := append([]uint8(nil), a...)
+	for i := range noisy {
+		if i%3 == 0 {
+			noisy[i] ^= 0x20
+		}
+	}
+	if got := ssim(a, noisy, width, height); got >= 0.99 || got <= 0 {
+		t.Errorf("noisy plane: got %v", got)
+	}
+	if got := ssim(a[:4], a[:4], 2, 2); math.Abs(got-1) > 1e-9 {
+		t.Errorf("plane smaller than a window: got %v", got)
+	}
+}
+
+func TestParseQualities(t *testing.T) {
+	got, err := parseQualities(" 75, 90,,95")
+	if err != nil || len(got) != 3 || got[0] != 75 || got[2] != 95 {
+		t.Errorf("got %v (%v)", got, err)
+	}
+	for _, value := range []string{"", "0", "101", "high"} {
+		if _, err := parseQualities(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	var out bytes.Buffer
+	if err := runBench([]string{"--qualities", "60,95", "--json", "testdata/images/canon-layout.hif"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var report benchReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Width != 320 || report.Height != 240 || report.Decoder != decoderBackend || len(report.Results) != 4 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	low, lowOptimized, high := report.Results[0], report.Results[1], report.Results[2]
+	if low.Quality != 60 || !lowOptimized.OptimizeHuffman || high.Quality != 95 {
+		t.Errorf("unexpected grid %+v", report.Results)
+	}
+	if lowOptimized.Size > low.Size || lowOptimized.SSIM != low.SSIM {
+		t.Errorf("optimized Huffman tables: %+v against %+v", lowOptimized, low)
+	}
+	if high.Size <= low.Size || high.SSIM <= low.SSIM || high.SSIM > 1 {
+		t.Errorf("quality 95 %+v against 60 %+v", high, low)
+	}
+
+	out.Reset()
+	if err := runBench([]string{"--qualities", "80", "testdata/images/canon-layout.hif"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"320x240", "Chroma subsampling: 4:2:0", "QUALITY  HUFFMAN", "80       optimized"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+	if err := runBench(nil, &out); err == nil {
+		t.Error("expected a usage error without a file")
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxPooledBuffer bounds the buffers kept for reuse so one unusually large
+// image does not pin its memory for the rest of the run.
+const maxPooledBuffer = 64 << 20
+
+// bufferPool recycles the source and encode buffers used for each image.
+// Buffers keep their capacity, so after the first few files a worker reuses
+// buffers already sized for the largest images seen instead of allocating.
+// Decoded pixel planes are allocated by goheif and cannot be pooled here.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// poolBuffers is cleared by --low-memory, so every buffer is freed as soon
+// as its file is done rather than kept at the size of the largest image.
+var poolBuffers = true
+
+func getBuffer(sizeHint int) *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	if sizeHint > 0 {
+		buf.Grow(sizeHint)
+	}
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if !poolBuffers || buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// readIntoBuffer reads r to EOF into a pooled buffer. The caller must return
+// it with putBuffer once nothing references its bytes.
+func readIntoBuffer(r io.Reader, sizeHint int64) (*bytes.Buffer, error) {
+	buf := getBuffer(int(sizeHint) + bytes.MinRead)
+	if _, err := buf.ReadFrom(r); err != nil {
+		putBuffer(buf)
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBufferPoolReuse(t *testing.T) {
+	buf, err := readIntoBuffer(strings.NewReader("heic bytes"), 10)
+	if err != nil {
+		t.Fatalf("readIntoBuffer failed: %v", err)
+	}
+	if buf.String() != "heic bytes" {
+		t.Fatalf("unexpected contents %q", buf.String())
+	}
+	putBuffer(buf)
+
+	reused := getBuffer(4)
+	defer putBuffer(reused)
+	if reused.Len() != 0 {
+		t.Fatalf("pooled buffer was not reset, has %d bytes", reused.Len())
+	}
+}
+
+func BenchmarkConvertFile(b *testing.B) {
+	jpegDir := b.TempDir()
+	opts := defaultOptions()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := convertFile("testdata/images", "goheif-camel.heic", jpegDir, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
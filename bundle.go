@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bundleEmail is the --bundle preset for mailing photos: outputs small
+// enough to attach, with plain numbered names, packed into as few zips of
+// at most --bundle-size as they fit in.
+const bundleEmail = "email"
+
+// Defaults of --bundle email, each of which an explicit --resize, --quality
+// or --bundle-size overrides. 2048 pixels on the long edge fills a laptop
+// screen; quality 70 keeps a photo at a few hundred kilobytes.
+const (
+	bundleEmailResize  = "2048x2048"
+	bundleEmailQuality = 70
+	defaultBundleSize  = "20MB"
+)
+
+// bundleName is the name of the bundle zips: photos.zip, or photos-1.zip,
+// photos-2.zip and so on when the photos need several.
+const bundleName = "photos"
+
+// bundleEntryOverhead is what the zip format adds for an entry, besides its
+// name (stored twice) and data: local and central headers, data descriptor
+// and timestamps, rounded up.
+const bundleEntryOverhead = 128
+
+// zipEndOverhead is the size of the end of central directory record.
+const zipEndOverhead = 22
+
+// isValidBundle reports whether preset names a --bundle preset.
+func isValidBundle(preset string) bool {
+	return preset == bundleEmail
+}
+
+// bundlePhoto is an output to bundle, under its simplified name.
+type bundlePhoto struct {
+	path    string
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// bundlePhotos names the outputs of results, converted into stagingDir, in
+// the order of index: photo-001.jpg, photo-002.jpg and so on.
+func bundlePhotos(stagingDir string, results []*fileResult, index map[string]int) ([]bundlePhoto, error) {
+	sort.Slice(results, func(i, j int) bool { return index[results[i].name] < index[results[j].name] })
+	digits := len(fmt.Sprint(len(results)))
+	if digits < 3 {
+		digits = 3
+	}
+	photos := make([]bundlePhoto, len(results))
+	for i, result := range results {
+		path := filepath.Join(stagingDir, result.output)
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		ext := strings.ToLower(filepath.Ext(result.output))
+		photos[i] = bundlePhoto{path: path, name: fmt.Sprintf("photo-%0*d%s", digits, i+1, ext), size: info.Size(), modTime: info.ModTime()}
+	}
+	return photos, nil
+}
+
+// packBundles groups photos, in order, into zips of at most limit bytes. A
+// photo larger than limit on its own gets a zip of its own.
+func packBundles(photos []bundlePhoto, limit int64) [][]bundlePhoto {
+	var bundles [][]bundlePhoto
+	var current []bundlePhoto
+	size := int64(zipEndOverhead)
+	for _, photo := range photos {
+		cost := photo.size + bundleEntryOverhead + 2*int64(len(photo.name))
+		if len(current) > 0 && size+cost > limit {
+			bundles = append(bundles, current)
+			current, size = nil, zipEndOverhead
+		}
+		current = append(current, photo)
+		size += cost
+	}
+	if len(current) > 0 {
+		bundles = append(bundles, current)
+	}
+	return bundles
+}
+
+// writeBundles packs the outputs of results, converted into stagingDir, into
+// zips of at most limit bytes in jpegDir, and returns their paths.
+func writeBundles(jpegDir, stagingDir string, results []*fileResult, index map[string]int, limit int64) ([]string, error) {
+	photos, err := bundlePhotos(stagingDir, results, index)
+	if err != nil {
+		return nil, err
+	}
+	bundles := packBundles(photos, limit)
+	paths := make([]string, len(bundles))
+	for i, bundle := range bundles {
+		name := bundleName + ".zip"
+		if len(bundles) > 1 {
+			name = fmt.Sprintf("%s-%d.zip", bundleName, i+1)
+		}
+		paths[i] = filepath.Join(jpegDir, name)
+		if err := writeBundle(paths[i], bundle); err != nil {
+			os.Remove(paths[i])
+			return paths[:i], err
+		}
+	}
+	return paths, nil
+}
+
+// writeBundle writes photos into a zip at path, stored uncompressed like
+// --output-zip.
+func writeBundle(path string, photos []bundlePhoto) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	for _, photo := range photos {
+		data, err := os.ReadFile(photo.path)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		header := &zip.FileHeader{Name: photo.name, Method: zip.Store}
+		header.SetModTime(photo.modTime)
+		if err := addZipEntry(zw, header, data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPackBundles(t *testing.T) {
+	photo := func(name string, size int64) bundlePhoto { return bundlePhoto{name: name, size: size} }
+	photos := []bundlePhoto{photo("a", 300), photo("b", 300), photo("c", 2000), photo("d", 100)}
+	var names [][]string
+	for _, bundle := range packBundles(photos, 1000) {
+		var group []string
+		for _, p := range bundle {
+			group = append(group, p.name)
+		}
+		names = append(names, group)
+	}
+	// c is larger than the limit on its own.
+	if want := [][]string{{"a", "b"}, {"c"}, {"d"}}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+	if bundles := packBundles(nil, 1000); len(bundles) != 0 {
+		t.Errorf("got %d bundles of nothing", len(bundles))
+	}
+}
+
+func TestBundleEmail(t *testing.T) {
+	dir := writePlanFixture(t, "b.heic", "a.heic", "c.heic")
+	opts, err := parseOptions([]string{"--non-interactive", "--bundle", "email", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.quality != bundleEmailQuality || opts.resizeWidth != 2048 || opts.bundleSize != 20<<20 {
+		t.Errorf("got quality %d, resize %d, bundle size %d", opts.quality, opts.resizeWidth, opts.bundleSize)
+	}
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := zipNames(t, filepath.Join(dir, "jpegs", "photos.zip")); !reflect.DeepEqual(got, []string{"photo-001.jpg", "photo-002.jpg", "photo-003.jpg"}) {
+		t.Errorf("photos.zip holds %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jpegs", "a.jpg")); !os.IsNotExist(err) {
+		t.Error("outputs left outside the bundle")
+	}
+
+	// A limit smaller than any photo gives each a zip of its own.
+	out := t.TempDir()
+	if opts, err = parseOptions([]string{"--non-interactive", "--bundle", "email", "--bundle-size", "1", "--quality", "90", "--output-dir", out, dir}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if opts.quality != 90 {
+		t.Errorf("explicit --quality overridden: %d", opts.quality)
+	}
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"photos-1.zip", "photos-2.zip", "photos-3.zip"} {
+		if got := zipNames(t, filepath.Join(out, name)); len(got) != 1 || got[0] != []string{"photo-001.jpg", "photo-002.jpg", "photo-003.jpg"}[i] {
+			t.Errorf("%s holds %v", name, got)
+		}
+	}
+}
+
+func TestBundleOptions(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"--bundle", "print", dir},
+		{"--bundle-size", "10MB", dir},
+		{"--bundle", "email", "--bundle-size", "0", dir},
+		{"--bundle", "email", "--output-zip", "out.zip", dir},
+		{"--bundle", "email", "--output-format", "png", dir},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func zipNames(t *testing.T, path string) []string {
+	t.Helper()
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
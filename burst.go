@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// --burst modes: keep every frame of a burst, the first one, or the one that
+// measures sharpest.
+const (
+	burstAll      = "all"
+	burstFirst    = "first"
+	burstSharpest = "sharpest"
+)
+
+func isValidBurst(mode string) bool {
+	return mode == burstAll || mode == burstFirst || mode == burstSharpest
+}
+
+// burstUUID returns the identifier iPhones give every frame of a burst
+// (Apple maker note tag 0x0b), or "" for single shots.
+func burstUUID(rawExif []byte) string {
+	return appleMakerNoteString(rawExif, 0x0b)
+}
+
+// selectBursts keeps one frame of each burst for --burst first and sharpest.
+// Files outside bursts, and files whose EXIF cannot be read, are kept, in
+// their original order.
+func selectBursts(currentDir string, files []os.DirEntry, opts options) []os.DirEntry {
+	if opts.burst == burstAll {
+		return files
+	}
+
+	bursts := map[string][]os.DirEntry{}
+	for _, file := range files {
+		if !isHEICFile(file.Name()) {
+			continue
+		}
+		if id := burstUUID(readExifFile(filepath.Join(currentDir, file.Name()))); id != "" {
+			bursts[id] = append(bursts[id], file)
+		}
+	}
+
+	dropped := map[string]bool{}
+	for _, frames := range bursts {
+		best := bestFrame(currentDir, frames, opts.burst)
+		for _, frame := range frames {
+			if frame.Name() != best.Name() {
+				dropped[frame.Name()] = true
+				opts.skipped.add(frame.Name(), skipBurstFrame, "")
+			}
+		}
+	}
+	if len(dropped) == 0 {
+		return files
+	}
+	fmt.Printf("Keeping the %s frame of %d bursts, skipping %d files\n", opts.burst, len(bursts), len(dropped))
+
+	kept := make([]os.DirEntry, 0, len(files)-len(dropped))
+	for _, file := range files {
+		if !dropped[file.Name()] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// readExifFile returns the EXIF block of a HEIC file, or nil.
+func readExifFile(path string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	rawExif, _ := extractExif(f)
+	return rawExif
+}
+
+// bestFrame picks the frame of a burst to keep: the first by name, which is
+// the order the camera numbered them, or the sharpest. Frames that cannot be
+// decoded are never picked over one that can.
+func bestFrame(currentDir string, frames []os.DirEntry, mode string) os.DirEntry {
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Name() < frames[j].Name() })
+	if mode != burstSharpest {
+		return frames[0]
+	}
+
+	best, bestScore := frames[0], -1.0
+	for _, frame := range frames {
+		source, err := readSource(filepath.Join(currentDir, frame.Name()))
+		if err != nil {
+			continue
+		}
+		img, err := decodeHEIC(bytes.NewReader(source.Bytes()))
+		putBuffer(source)
+		if err != nil {
+			continue
+		}
+		if score := sharpness(img); score > bestScore {
+			best, bestScore = frame, score
+		}
+	}
+	return best
+}
+
+// sharpness is the variance of the Laplacian of the image's luma: motion
+// blur and missed focus soften edges and lower it.
+func sharpness(img image.Image) float64 {
+	luma, width, height := lumaPlane(img)
+	if width < 3 || height < 3 {
+		return 0
+	}
+	var sum, sumSquares float64
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			l := float64(int(luma[i-1]) + int(luma[i+1]) + int(luma[i-width]) + int(luma[i+width]) - 4*int(luma[i]))
+			sum += l
+			sumSquares += l * l
+		}
+	}
+	n := float64((width - 2) * (height - 2))
+	mean := sum / n
+	return sumSquares/n - mean*mean
+}
+
+// lumaPlane returns the luma of img as a tightly packed plane, taking the Y
+// plane of decoded HEIC images directly.
+func lumaPlane(img image.Image) ([]uint8, int, int) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	luma := make([]uint8, width*height)
+	if ycc, ok := img.(*image.YCbCr); ok {
+		for y := 0; y < height; y++ {
+			copy(luma[y*width:(y+1)*width], ycc.Y[ycc.YOffset(b.Min.X, b.Min.Y+y):])
+		}
+		return luma, width, height
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			luma[y*width+x] = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+		}
+	}
+	return luma, width, height
+}
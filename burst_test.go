@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestBurstUUID(t *testing.T) {
+	const id = "9E3C5B0A-5D1B-4C7E-8F2A-0123456789AB"
+	note := testAppleMakerNote(0x0b, id)
+	raw := buildTestExif(nil, []testTag{{id: 0x927c, typ: 7, count: uint32(len(note)), value: note}}, nil)
+	if got := burstUUID(raw); got != id {
+		t.Errorf("got %q, want %q", got, id)
+	}
+	if got := appleContentIdentifier(raw); got != "" {
+		t.Errorf("the burst identifier was taken for a Live Photo identifier: %q", got)
+	}
+}
+
+func TestSharpness(t *testing.T) {
+	sharp := image.NewGray(image.Rect(0, 0, 32, 32))
+	soft := image.NewGray(sharp.Bounds())
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if (x/4+y/4)%2 == 0 {
+				sharp.SetGray(x, y, color.Gray{255})
+			}
+			// The same pattern as a gentle ramp.
+			soft.SetGray(x, y, color.Gray{uint8(4 * (x%8 + y%8))})
+		}
+	}
+	if sharpness(sharp) <= sharpness(soft) {
+		t.Errorf("hard edges scored %.1f, a soft ramp %.1f", sharpness(sharp), sharpness(soft))
+	}
+
+	ycc := image.NewYCbCr(image.Rect(0, 0, 32, 32), image.YCbCrSubsampleRatio420)
+	copy(ycc.Y, sharp.Pix)
+	if got, want := sharpness(ycc.SubImage(image.Rect(4, 4, 20, 20))), sharpness(sharp.SubImage(image.Rect(4, 4, 20, 20))); got != want {
+		t.Errorf("Y plane gave %.1f, gray image %.1f", got, want)
+	}
+}
+
+func TestBestFrameFirst(t *testing.T) {
+	frames := []os.DirEntry{&mockDirEntry{"IMG_0003.HEIC"}, &mockDirEntry{"IMG_0001.HEIC"}, &mockDirEntry{"IMG_0002.HEIC"}}
+	if got := bestFrame(".", frames, burstFirst); got.Name() != "IMG_0001.HEIC" {
+		t.Errorf("got %s", got.Name())
+	}
+}
+
+func TestSelectBurstsWithoutBursts(t *testing.T) {
+	files, err := os.ReadDir("testdata/images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseOptions([]string{"--burst", "sharpest"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept := selectBursts("testdata/images", files, opts); len(kept) != len(files) {
+		t.Errorf("single shots were dropped: %d of %d kept", len(kept), len(files))
+	}
+	if _, err := parseOptions([]string{"--burst", "best"}, io.Discard); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
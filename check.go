@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/cpu"
+)
+
+// checkSample is a 320x240 HEVC-coded HEIC (the thumbnail of the goheif test
+// image) that `heictojpeg check` converts as a self-test.
+//
+//go:embed checkdata/sample.heic
+var checkSample []byte
+
+// Dimensions of checkSample.
+const (
+	checkSampleWidth  = 320
+	checkSampleHeight = 240
+)
+
+// checkResult is one line of `heictojpeg check`. Checks that only report
+// what the build offers have ok set regardless.
+type checkResult struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// outputFormats are the --output-format values, all encoded in pure Go.
+var outputFormats = []string{formatJPEG, formatAuto, formatPDF, formatPNG, formatPPM, formatRawRGBA}
+
+// runChecks runs every check of `heictojpeg check`.
+func runChecks() []checkResult {
+	info := currentVersion()
+	results := []checkResult{
+		{Name: "Build", OK: true, Detail: fmt.Sprintf("heictojpeg %s (%s, %s)", info.Version, info.Go, info.Platform)},
+	}
+
+	start := time.Now()
+	img, err := decodeHEIC(bytes.NewReader(checkSample))
+	decoded := err == nil
+	if err != nil {
+		results = append(results, checkResult{Name: "Decoder", Detail: fmt.Sprintf("%s: %v", decoderBackend, err)})
+	} else if b := img.Bounds(); b.Dx() != checkSampleWidth || b.Dy() != checkSampleHeight {
+		decoded = false
+		results = append(results, checkResult{Name: "Decoder", Detail: fmt.Sprintf("%s: sample decoded to %dx%d, want %dx%d", decoderBackend, b.Dx(), b.Dy(), checkSampleWidth, checkSampleHeight)})
+	} else {
+		results = append(results, checkResult{Name: "Decoder", OK: true, Detail: fmt.Sprintf("%s, decodes HEVC (%v)", decoderBackend, time.Since(start).Round(time.Millisecond))})
+	}
+
+	var readable []string
+	for name, format := range transcodeFormats {
+		if format.readable {
+			readable = append(readable, name)
+		}
+	}
+	sort.Strings(readable)
+	inputs := strings.Join(readable, ", ") + " (JPEG-coded images always"
+	if decoded {
+		inputs += ", HEVC-coded ones with the decoder)"
+	} else {
+		inputs += "; HEVC-coded ones need a working decoder)"
+	}
+	results = append(results,
+		checkResult{Name: "Input formats", OK: true, Detail: inputs},
+		checkResult{Name: "Output formats", OK: true, Detail: strings.Join(outputFormats, ", ")},
+		checkResult{Name: "Acceleration", OK: true, Detail: accelerationDetail()},
+		checkResult{Name: "Resources", OK: true, Detail: checkResources()},
+		checkJPEGEncoders(),
+		selfTest(),
+	)
+	return results
+}
+
+// accelerationDetail describes how decoding can use the hardware. libde265
+// decodes in software, one image per CPU; hardware HEVC decoders are not
+// used.
+func accelerationDetail() string {
+	var features []string
+	switch runtime.GOARCH {
+	case "amd64", "386":
+		for _, f := range []struct {
+			name string
+			has  bool
+		}{{"SSE4.1", cpu.X86.HasSSE41}, {"AVX2", cpu.X86.HasAVX2}, {"AVX-512", cpu.X86.HasAVX512F}} {
+			if f.has {
+				features = append(features, f.name)
+			}
+		}
+	case "arm64":
+		if cpu.ARM64.HasASIMD {
+			features = append(features, "NEON")
+		}
+	}
+	cpus := fmt.Sprintf("%d CPUs", runtime.NumCPU())
+	if runtime.NumCPU() == 1 {
+		cpus = "1 CPU"
+	}
+	detail := "no hardware decoder; software decoding on " + cpus
+	if len(features) > 0 {
+		detail += " with " + strings.Join(features, ", ")
+	}
+	return detail
+}
+
+// checkResources reports the limits a run would detect and fit.
+func checkResources() string {
+	opts := defaultOptions()
+	opts.limits = detectResourceLimits(0)
+	opts.workers = opts.limits.workers(runtime.NumCPU())
+	opts.lowMemory = opts.limits.memory > 0 && opts.limits.memory < lowMemoryLimit
+	return resourceSummary(opts)
+}
+
+// checkJPEGEncoders lists the --jpeg-encoder values that work here. Each
+// native encoder found encodes a small image, which has to decode; one that
+// is found but fails is an error, one that is missing is not.
+func checkJPEGEncoders() checkResult {
+	result := checkResult{Name: "JPEG encoders", OK: true}
+	details := []string{encoderStdlib}
+	sample := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for _, name := range jpegEncoderNames[1:] {
+		encoder, err := findJPEGEncoder(name)
+		if err != nil {
+			details = append(details, name+" not found")
+			continue
+		}
+		var out bytes.Buffer
+		if err := encoder.encode(&out, sample, 75, defaultOptions()); err != nil {
+			result.OK = false
+			details = append(details, fmt.Sprintf("%s failed: %v", encoder, err))
+			continue
+		}
+		if _, err := jpeg.DecodeConfig(&out); err != nil {
+			result.OK = false
+			details = append(details, fmt.Sprintf("%s wrote an invalid JPEG: %v", encoder, err))
+			continue
+		}
+		details = append(details, encoder.String())
+	}
+	result.Detail = strings.Join(details, "; ")
+	return result
+}
+
+// selfTest converts the embedded sample to a JPEG in memory, checks the
+// result decodes, and writes it to the temporary directory, as a run writes
+// its outputs.
+func selfTest() checkResult {
+	result := checkResult{Name: "Self-test"}
+	start := time.Now()
+	var out bytes.Buffer
+	if err := convertStream(bytes.NewReader(checkSample), &out, defaultOptions()); err != nil {
+		result.Detail = fmt.Sprintf("converting the sample failed: %v", err)
+		return result
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(out.Bytes()))
+	if err != nil || config.Width != checkSampleWidth || config.Height != checkSampleHeight {
+		result.Detail = fmt.Sprintf("the sample's JPEG is invalid: %dx%d, %v", config.Width, config.Height, err)
+		return result
+	}
+	f, err := os.CreateTemp("", "heictojpeg-check-*.jpg")
+	if err == nil {
+		_, err = out.WriteTo(f)
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if err != nil {
+		result.Detail = fmt.Sprintf("writing the sample's JPEG failed: %v", err)
+		return result
+	}
+	result.OK = true
+	result.Detail = fmt.Sprintf("sample converted to a %dx%d JPEG in %v", config.Width, config.Height, time.Since(start).Round(time.Millisecond))
+	return result
+}
+
+// runCheck implements the check subcommand. It returns an error when any
+// check failed, so scripts can test the exit status.
+func runCheck(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(out)
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	results := runChecks()
+	failed := 0
+	for _, r := range results {
+		if !r.OK {
+			failed++
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			status := "ok  "
+			if !r.OK {
+				status = "FAIL"
+			}
+			fmt.Fprintf(out, "%s %-15s %s\n", status, r.Name+":", r.Detail)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(results))
+	}
+	return nil
+}
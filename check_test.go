@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRunChecks(t *testing.T) {
+	results := runChecks()
+	names := map[string]bool{}
+	for _, r := range results {
+		names[r.Name] = true
+		if !r.OK {
+			t.Errorf("%s failed: %s", r.Name, r.Detail)
+		}
+	}
+	for _, name := range []string{"Build", "Decoder", "Input formats", "Output formats", "Acceleration", "Resources", "JPEG encoders", "Self-test"} {
+		if !names[name] {
+			t.Errorf("no %s check", name)
+		}
+	}
+}
+
+func TestRunCheckOutput(t *testing.T) {
+	var out bytes.Buffer
+	if err := runCheck(nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "ok   Self-test:") || !strings.Contains(out.String(), "320x240") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runCheck([]string{"--json"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var results []checkResult
+	if err := json.Unmarshal(out.Bytes(), &results); err != nil || len(results) != 8 {
+		t.Fatalf("unexpected JSON (%v):\n%s", err, out.String())
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/adrium/goheif/heif"
+)
+
+// matrix3 is a row-major 3×3 matrix.
+type matrix3 [3][3]float64
+
+func (m matrix3) mul(n matrix3) matrix3 {
+	var r matrix3
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+func (m matrix3) apply(v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+func (m matrix3) inverse() (matrix3, bool) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-12 {
+		return matrix3{}, false
+	}
+	return matrix3{
+		{(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det, (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det, (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det},
+		{(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det, (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det, (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det},
+		{(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det, (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det, (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det},
+	}, true
+}
+
+// D50 is the ICC profile connection space white point.
+var d50 = [3]float64{0.9642, 1.0, 0.8249}
+
+var bradford = matrix3{
+	{0.8951, 0.2664, -0.1614},
+	{-0.7502, 1.7135, 0.0367},
+	{0.0389, -0.0685, 1.0296},
+}
+
+func xyToXYZ(x, y float64) [3]float64 {
+	return [3]float64{x / y, 1, (1 - x - y) / y}
+}
+
+// primariesToXYZ returns the matrix taking linear RGB with the given xy
+// chromaticities and white point to D50 XYZ, adapted with Bradford the way
+// ICC matrix/TRC profiles store it.
+func primariesToXYZ(red, green, blue, white [2]float64) matrix3 {
+	r, g, b := xyToXYZ(red[0], red[1]), xyToXYZ(green[0], green[1]), xyToXYZ(blue[0], blue[1])
+	p := matrix3{{r[0], g[0], b[0]}, {r[1], g[1], b[1]}, {r[2], g[2], b[2]}}
+	pInv, _ := p.inverse()
+	w := xyToXYZ(white[0], white[1])
+	s := pInv.apply(w)
+	m := matrix3{
+		{p[0][0] * s[0], p[0][1] * s[1], p[0][2] * s[2]},
+		{p[1][0] * s[0], p[1][1] * s[1], p[1][2] * s[2]},
+		{p[2][0] * s[0], p[2][1] * s[1], p[2][2] * s[2]},
+	}
+
+	src, dst := bradford.apply(w), bradford.apply(d50)
+	scale := matrix3{{dst[0] / src[0], 0, 0}, {0, dst[1] / src[1], 0}, {0, 0, dst[2] / src[2]}}
+	bradfordInv, _ := bradford.inverse()
+	return bradfordInv.mul(scale).mul(bradford).mul(m)
+}
+
+// srgbToLinear is the sRGB transfer function, shared by Display P3.
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func gammaCurve(gamma float64) func(float64) float64 {
+	return func(v float64) float64 { return math.Pow(v, gamma) }
+}
+
+// colorProfile describes an RGB colour space as a matrix/TRC pair, the model
+// used by display ICC profiles.
+type colorProfile struct {
+	name  string
+	toXYZ matrix3                  // linear RGB to D50 XYZ
+	trc   [3]func(float64) float64 // encoded [0,1] to linear [0,1], per channel
+	gamma float64                  // non-zero when trc is a pure power law
+	icc   []byte                   // profile embedded in outputs; nil for sRGB
+
+	once   sync.Once
+	encode [3][]uint8 // linear to 8-bit encoded lookup tables
+}
+
+var d65 = [2]float64{0.3127, 0.3290}
+
+func newBuiltinProfile(name string, red, green, blue [2]float64, gamma float64) *colorProfile {
+	curve := srgbToLinear
+	if gamma != 0 {
+		curve = gammaCurve(gamma)
+	}
+	return &colorProfile{
+		name:  name,
+		toXYZ: primariesToXYZ(red, green, blue, d65),
+		trc:   [3]func(float64) float64{curve, curve, curve},
+		gamma: gamma,
+	}
+}
+
+// Built-in target profiles. Display P3 and Adobe RGB carry a generated ICC
+// profile so viewers interpret the converted pixels correctly; sRGB is what
+// untagged JPEGs are assumed to be, so it is left untagged.
+var (
+	profileSRGB      = newBuiltinProfile("srgb", [2]float64{0.64, 0.33}, [2]float64{0.30, 0.60}, [2]float64{0.15, 0.06}, 0)
+	profileDisplayP3 = withICC(newBuiltinProfile("display-p3", [2]float64{0.680, 0.320}, [2]float64{0.265, 0.690}, [2]float64{0.150, 0.060}, 0), "Display P3")
+	profileAdobeRGB  = withICC(newBuiltinProfile("adobe-rgb", [2]float64{0.64, 0.33}, [2]float64{0.21, 0.71}, [2]float64{0.15, 0.06}, 563.0/256), "Adobe RGB (1998) compatible")
+)
+
+func withICC(p *colorProfile, description string) *colorProfile {
+	p.icc = buildICCProfile(p, description)
+	return p
+}
+
+// loadColorProfile resolves a --target-profile value: a built-in name or the
+// path of an RGB matrix/TRC ICC profile.
+func loadColorProfile(value string) (*colorProfile, error) {
+	switch strings.ToLower(value) {
+	case "srgb":
+		return profileSRGB, nil
+	case "display-p3":
+		return profileDisplayP3, nil
+	case "adobe-rgb":
+		return profileAdobeRGB, nil
+	}
+
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := parseICCProfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", value, err)
+	}
+	profile.name = value
+	profile.icc = data
+	return profile, nil
+}
+
+// sourceColorProfile returns the colour space of a HEIC's primary image from
+// its colr properties. Images without one, or with colour information that
+// cannot be modelled, are treated as sRGB.
+func sourceColorProfile(ra io.ReaderAt) *colorProfile {
+	for _, body := range colrBodies(ra) {
+		if profile := colrProfile(body); profile != nil {
+			return profile
+		}
+	}
+	return profileSRGB
+}
+
+// sourceICC returns the ICC profile describing a HEIC's primary image: an
+// embedded profile as-is, or the built-in Display P3 profile for nclx colour
+// information with P3 primaries. It returns nil for sRGB and untagged
+// images, which need no profile.
+func sourceICC(ra io.ReaderAt) []byte {
+	bodies := colrBodies(ra)
+	for _, body := range bodies {
+		if len(body) > 4 && (string(body[:4]) == "prof" || string(body[:4]) == "rICC") {
+			return body[4:]
+		}
+	}
+	for _, body := range bodies {
+		if profile := colrProfile(body); profile != nil {
+			return profile.icc
+		}
+	}
+	return nil
+}
+
+// colrBodies returns the bodies of the primary image's colr properties.
+func colrBodies(ra io.ReaderAt) [][]byte {
+	item, err := heif.Open(ra).PrimaryItem()
+	if err != nil {
+		return nil
+	}
+	var bodies [][]byte
+	for _, prop := range item.Properties {
+		if !prop.Type().EqualString("colr") {
+			continue
+		}
+		if body, err := io.ReadAll(prop.Body()); err == nil {
+			bodies = append(bodies, body)
+		}
+	}
+	return bodies
+}
+
+// nclx colour primaries, as defined in ISO/IEC 23091-2.
+const (
+	nclxPrimariesBT709 = 1
+	nclxPrimariesP3D65 = 12
+)
+
+// colrProfile interprets the body of a colr box.
+func colrProfile(body []byte) *colorProfile {
+	if len(body) < 4 {
+		return nil
+	}
+	switch string(body[:4]) {
+	case "nclx":
+		if len(body) < 6 {
+			return nil
+		}
+		switch binary.BigEndian.Uint16(body[4:6]) {
+		case nclxPrimariesBT709:
+			return profileSRGB
+		case nclxPrimariesP3D65:
+			return profileDisplayP3
+		}
+	case "prof", "rICC":
+		profile, err := parseICCProfile(body[4:])
+		if err == nil {
+			return profile
+		}
+	}
+	return nil
+}
+
+// encodeTables builds, once per profile, lookup tables from linear light to
+// 8-bit encoded values by inverting the profile's curves.
+func (p *colorProfile) encodeTables() [3][]uint8 {
+	p.once.Do(func() {
+		for c := 0; c < 3; c++ {
+			table := make([]uint8, encodeSteps+1)
+			for i := range table {
+				table[i] = uint8(math.Round(invertCurve(p.trc[c], float64(i)/encodeSteps) * 255))
+			}
+			p.encode[c] = table
+		}
+	})
+	return p.encode
+}
+
+// encodeSteps is the resolution of the linear-to-encoded lookup tables.
+const encodeSteps = 16384
+
+// invertCurve finds x in [0,1] with curve(x) = y by bisection; curves are
+// monotonically non-decreasing.
+func invertCurve(curve func(float64) float64, y float64) float64 {
+	lo, hi := 0.0, 1.0
+	for i := 0; i < 32; i++ {
+		mid := (lo + hi) / 2
+		if curve(mid) < y {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// convertColors converts img from one colour space to another: pixels are
+// linearised with the source curves, taken through D50 XYZ into the target
+// primaries, clipped to the target gamut and re-encoded with the target
+// curves.
+func convertColors(img image.Image, from, to *colorProfile) image.Image {
+	if from == to {
+		return img
+	}
+	toInv, ok := to.toXYZ.inverse()
+	if !ok {
+		return img
+	}
+	m := toInv.mul(from.toXYZ)
+
+	var decode [3][256]float64
+	for c := 0; c < 3; c++ {
+		for i := range decode[c] {
+			decode[c][i] = from.trc[c](float64(i) / 255)
+		}
+	}
+	encode := to.encodeTables()
+
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	pix := rgba.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		out := m.apply([3]float64{decode[0][pix[i]], decode[1][pix[i+1]], decode[2][pix[i+2]]})
+		for c := 0; c < 3; c++ {
+			v := math.Min(math.Max(out[c], 0), 1)
+			pix[i+c] = encode[c][int(v*encodeSteps+0.5)]
+		}
+	}
+	return rgba
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestBuiltinProfileMatrix(t *testing.T) {
+	// sRGB primaries adapted to D50, as found in common sRGB ICC profiles.
+	want := matrix3{
+		{0.4361, 0.3851, 0.1431},
+		{0.2225, 0.7169, 0.0606},
+		{0.0139, 0.0971, 0.7142},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(profileSRGB.toXYZ[i][j]-want[i][j]) > 1e-3 {
+				t.Fatalf("sRGB matrix[%d][%d] = %f, want %f", i, j, profileSRGB.toXYZ[i][j], want[i][j])
+			}
+		}
+	}
+}
+
+func TestConvertColorsSRGBToDisplayP3(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	img.Set(1, 0, color.RGBA{255, 255, 255, 255})
+	img.Set(2, 0, color.RGBA{0, 0, 0, 255})
+
+	out := convertColors(img, profileSRGB, profileDisplayP3)
+	checks := []struct {
+		x    int
+		want color.RGBA
+	}{
+		{0, color.RGBA{234, 51, 35, 255}}, // sRGB red inside the wider P3 gamut
+		{1, color.RGBA{255, 255, 255, 255}},
+		{2, color.RGBA{0, 0, 0, 255}},
+	}
+	for _, c := range checks {
+		got := out.At(c.x, 0).(color.RGBA)
+		if diff(got.R, c.want.R) > 1 || diff(got.G, c.want.G) > 1 || diff(got.B, c.want.B) > 1 {
+			t.Errorf("pixel %d: got %v, want %v", c.x, got, c.want)
+		}
+	}
+
+	// Converting back clips nothing and round-trips closely.
+	back := convertColors(out, profileDisplayP3, profileSRGB).At(0, 0).(color.RGBA)
+	if back.R < 254 || back.G > 1 || back.B > 1 {
+		t.Errorf("round trip gave %v", back)
+	}
+}
+
+func diff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+func TestColrProfile(t *testing.T) {
+	nclx := []byte{'n', 'c', 'l', 'x', 0, 12, 0, 13, 0, 6, 0x80}
+	if p := colrProfile(nclx); p != profileDisplayP3 {
+		t.Fatalf("nclx primaries 12 gave %v", p)
+	}
+	nclx[5] = 1
+	if p := colrProfile(nclx); p != profileSRGB {
+		t.Fatalf("nclx primaries 1 gave %v", p)
+	}
+	nclx[5] = 9 // BT.2020 is not modelled
+	if p := colrProfile(nclx); p != nil {
+		t.Fatalf("nclx primaries 9 gave %v", p)
+	}
+
+	prof := append([]byte("prof"), profileAdobeRGB.icc...)
+	p := colrProfile(prof)
+	if p == nil || math.Abs(p.gamma-563.0/256) > 1e-6 {
+		t.Fatalf("embedded ICC profile not recognised: %+v", p)
+	}
+}
+
+func TestLoadColorProfile(t *testing.T) {
+	if p, err := loadColorProfile("Display-P3"); err != nil || p != profileDisplayP3 {
+		t.Fatalf("got %v, %v", p, err)
+	}
+	if _, err := loadColorProfile("missing.icc"); err == nil {
+		t.Fatal("expected error for a missing profile file")
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// completionShells are the shells `heictojpeg completion` writes scripts
+// for.
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// completionFlag is a flag offered for completion.
+type completionFlag struct {
+	name   string
+	usage  string
+	value  bool     // whether it takes a value
+	values []string // the values it takes, when they are a fixed set
+}
+
+// completionCommand is heictojpeg or one of its subcommands with the flags
+// it takes.
+type completionCommand struct {
+	name  string // "" for heictojpeg itself
+	flags []completionFlag
+	args  []string // fixed positional arguments, such as queue's actions
+}
+
+// flagValues are the values of the flags that take one of a fixed set,
+// across heictojpeg and its subcommands. Comma-separated lists complete one
+// item.
+func flagValues() map[string][]string {
+	sizes := []string{pageSizeImage}
+	for size := range pageSizes {
+		sizes = append(sizes, size)
+	}
+	sort.Strings(sizes[1:])
+	return map[string][]string{
+		"output-format": outputFormats,
+		"fit":           {fitContain, fitCover, fitExact},
+		"page-size":     sizes,
+		"page-fit":      {pageFitContain, pageFitCover},
+		"order":         {orderName, orderSizeAsc, orderSizeDesc, orderDateAsc, orderDateDesc, orderDirectory},
+		"existing":      {existingMerge, existingClean, existingSkip},
+		"lock":          {lockFail, lockWait, lockIgnore},
+		"burst":         {burstAll, burstFirst, burstSharpest},
+		"if-larger":     {largerSkip, largerRetry, largerCopy},
+		"notify-format": {notifyJSON, notifySlack, notifyTelegram},
+		"jpeg-encoder":  jpegEncoderNames,
+		"alpha":         {alphaPNG, alphaFlatten, alphaIgnore},
+		"offloaded":     {offloadedDownload, offloadedSkip},
+		"bundle":        {bundleEmail},
+		"rotate":        {"90", "180", "270"},
+		"flip":          {flipHorizontal, flipVertical},
+		"keep-metadata": {"exif", "xmp", "icc", "none"},
+		"drop-metadata": {"exif", "xmp", "icc", "gps", "serial", "camera", "datetime", "makernote", "ids"},
+		"privacy":       {"none", "share", "paranoid"},
+		"mode":          {viewAuto, viewWindow, viewITerm, viewSixel},
+		"from":          strings.Split(transcodeNames(func(f transcodeFormat) bool { return f.readable }), ", "),
+		"to":            strings.Split(transcodeNames(func(f transcodeFormat) bool { return f.outputFormat != "" }), ", "),
+	}
+}
+
+// completionCommands lists heictojpeg and its subcommands, in the order
+// they are offered. The conversion flags come from newFlagSet; subcommands
+// with flag sets of their own list them here, which TestCompletionFlags
+// checks against their -h output.
+func completionCommands() []completionCommand {
+	values := flagValues()
+	var main []completionFlag
+	newFlagSet(&options{}, io.Discard).VisitAll(func(f *flag.Flag) {
+		main = append(main, completionFlag{name: f.Name, usage: f.Usage, value: !isBoolFlag(f), values: values[f.Name]})
+	})
+	option := func(name, usage string, value bool) completionFlag {
+		return completionFlag{name: name, usage: usage, value: value, values: values[name]}
+	}
+	jsonFlag := func(what string) completionFlag { return option("json", "print the "+what+" as JSON", false) }
+	return []completionCommand{
+		{name: "", flags: main},
+		{name: "check", flags: []completionFlag{jsonFlag("results")}},
+		{name: "info"},
+		{name: "view", flags: []completionFlag{
+			option("mode", "how to show the image: auto, window, iterm or sixel", true),
+			option("width", "width in pixels the image is scaled down to for iterm and sixel", true),
+		}},
+		{name: "diff", flags: []completionFlag{
+			jsonFlag("differences"),
+			option("threshold", "report outputs whose size changed by more than this many percent", true),
+		}},
+		{name: "audit", flags: []completionFlag{jsonFlag("report")}},
+		{name: "bench", flags: []completionFlag{
+			jsonFlag("results"),
+			option("qualities", "comma-separated JPEG qualities to compare, 1-100", true),
+		}},
+		{name: "transcode", flags: append([]completionFlag{
+			option("from", "format of the sources", true),
+			option("to", "format to convert them to", true),
+		}, main...)},
+		{name: "watch", flags: append([]completionFlag{
+			option("install-service", "run the watcher as a background service", false),
+			option("uninstall-service", "remove the background service", false),
+		}, main...)},
+		{name: "serve", flags: main},
+		{name: "queue", flags: []completionFlag{
+			option("interactive", "add at interactive priority (10), ahead of bulk jobs", false),
+			option("listen", "address of the serve control API", true),
+			option("priority", "priority of added files; higher runs first", true),
+		}, args: []string{"list", "add", "cancel", "pause", "resume"}},
+		{name: "open"},
+		{name: "install-integration", flags: []completionFlag{option("remove", "remove the file manager actions instead of installing them", false)}},
+		{name: "selftest", flags: []completionFlag{
+			jsonFlag("results"),
+			option("min-ssim", "lowest SSIM of an output against its golden JPEG that passes", true),
+			option("update", "write the outputs as the new golden JPEGs instead of comparing them", false),
+		}},
+		{name: "version", flags: []completionFlag{jsonFlag("version, backend and library versions")}},
+		{name: "completion", args: completionShells},
+	}
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// runCompletion implements `heictojpeg completion SHELL`, which prints a
+// completion script for SHELL, to be sourced from its startup file.
+func runCompletion(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("completion", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: heictojpeg completion {%s}\n", strings.Join(completionShells, ","))
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("completion needs a shell")
+	}
+	commands := completionCommands()
+	switch fs.Arg(0) {
+	case "bash":
+		writeBashCompletion(out, commands)
+	case "zsh":
+		writeZshCompletion(out, commands)
+	case "fish":
+		writeFishCompletion(out, commands)
+	case "powershell":
+		writePowerShellCompletion(out, commands)
+	default:
+		return fmt.Errorf("unknown shell %q: use one of %s", fs.Arg(0), strings.Join(completionShells, ", "))
+	}
+	return nil
+}
+
+// subcommandNames are the names of the subcommands of commands.
+func subcommandNames(commands []completionCommand) []string {
+	var names []string
+	for _, c := range commands {
+		if c.name != "" {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+// dashed returns the flags as --name.
+func dashed(flags []completionFlag) []string {
+	names := make([]string, len(flags))
+	for i, f := range flags {
+		names[i] = "--" + f.name
+	}
+	return names
+}
+
+// valueFlags returns the flags of commands with a fixed set of values, once
+// each, by name.
+func valueFlags(commands []completionCommand) []completionFlag {
+	seen := map[string]bool{}
+	var flags []completionFlag
+	for _, c := range commands {
+		for _, f := range c.flags {
+			if len(f.values) > 0 && !seen[f.name] {
+				seen[f.name] = true
+				flags = append(flags, f)
+			}
+		}
+	}
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+func writeBashCompletion(w io.Writer, commands []completionCommand) {
+	fmt.Fprint(w, `# bash completion for heictojpeg, from "heictojpeg completion bash".
+# Add to ~/.bashrc: source <(heictojpeg completion bash)
+_heictojpeg() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" sub=""
+    if [[ ${COMP_CWORD} -gt 1 ]]; then
+        sub="${COMP_WORDS[1]}"
+    fi
+    case "${prev#-}" in
+`)
+	for _, f := range valueFlags(commands) {
+		fmt.Fprintf(w, "        -%s)\n            COMPREPLY=($(compgen -W %q -- \"$cur\"))\n            return ;;\n", f.name, strings.Join(f.values, " "))
+	}
+	fmt.Fprint(w, "    esac\n    local flags=\"\" args=\"\"\n    case \"$sub\" in\n")
+	for _, c := range commands[1:] {
+		fmt.Fprintf(w, "        %s)\n            flags=%q\n            args=%q ;;\n", c.name, strings.Join(dashed(c.flags), " "), strings.Join(c.args, " "))
+	}
+	fmt.Fprintf(w, "        *)\n            flags=%q ;;\n    esac\n", strings.Join(dashed(commands[0].flags), " "))
+	fmt.Fprintf(w, `    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+    elif [[ ${COMP_CWORD} -eq 1 ]]; then
+        COMPREPLY=($(compgen -W %q -- "$cur") $(compgen -f -- "$cur"))
+    elif [[ -n "$args" && ${COMP_CWORD} -eq 2 ]]; then
+        COMPREPLY=($(compgen -W "$args" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -f -- "$cur"))
+    fi
+}
+complete -o filenames -F _heictojpeg heictojpeg
+`, strings.Join(subcommandNames(commands), " "))
+}
+
+func writeZshCompletion(w io.Writer, commands []completionCommand) {
+	fmt.Fprint(w, `#compdef heictojpeg
+# zsh completion for heictojpeg, from "heictojpeg completion zsh".
+# Add to ~/.zshrc: source <(heictojpeg completion zsh)
+_heictojpeg() {
+    local cur=${words[CURRENT]} prev=${words[CURRENT-1]} sub=""
+    (( CURRENT > 2 )) && sub=${words[2]}
+    case ${prev#-} in
+`)
+	for _, f := range valueFlags(commands) {
+		fmt.Fprintf(w, "        -%s) compadd -- %s; return ;;\n", f.name, strings.Join(f.values, " "))
+	}
+	fmt.Fprint(w, "    esac\n    local -a flags args\n    case $sub in\n")
+	for _, c := range commands[1:] {
+		fmt.Fprintf(w, "        %s) flags=(%s); args=(%s) ;;\n", c.name, strings.Join(dashed(c.flags), " "), strings.Join(c.args, " "))
+	}
+	fmt.Fprintf(w, "        *) flags=(%s) ;;\n    esac\n", strings.Join(dashed(commands[0].flags), " "))
+	fmt.Fprintf(w, `    if [[ $cur == -* ]]; then
+        compadd -- $flags
+    elif (( CURRENT == 2 )); then
+        compadd -- %s
+        _files
+    elif (( CURRENT == 3 && ${#args} > 0 )); then
+        compadd -- $args
+    else
+        _files
+    fi
+}
+compdef _heictojpeg heictojpeg
+`, strings.Join(subcommandNames(commands), " "))
+}
+
+func writeFishCompletion(w io.Writer, commands []completionCommand) {
+	subcommands := strings.Join(subcommandNames(commands), " ")
+	fmt.Fprint(w, "# fish completion for heictojpeg, from \"heictojpeg completion fish\".\n")
+	fmt.Fprint(w, "# Save as ~/.config/fish/completions/heictojpeg.fish\n")
+	fmt.Fprintf(w, "complete -c heictojpeg -n __fish_use_subcommand -a %q\n", subcommands)
+	for _, c := range commands {
+		condition := fmt.Sprintf("not __fish_seen_subcommand_from %s", subcommands)
+		if c.name != "" {
+			condition = "__fish_seen_subcommand_from " + c.name
+		}
+		for _, f := range c.flags {
+			line := fmt.Sprintf("complete -c heictojpeg -n %q -l %s", condition, f.name)
+			switch {
+			case len(f.values) > 0:
+				line += fmt.Sprintf(" -x -a %q", strings.Join(f.values, " "))
+			case f.value:
+				line += " -r"
+			}
+			if usage := firstLine(f.usage); usage != "" {
+				line += fmt.Sprintf(" -d %s", fishQuote(usage))
+			}
+			fmt.Fprintln(w, line)
+		}
+		if len(c.args) > 0 {
+			fmt.Fprintf(w, "complete -c heictojpeg -n %q -x -a %q\n", condition, strings.Join(c.args, " "))
+		}
+	}
+}
+
+func writePowerShellCompletion(w io.Writer, commands []completionCommand) {
+	list := func(items []string) string {
+		quoted := make([]string, len(items))
+		for i, item := range items {
+			quoted[i] = "'" + strings.ReplaceAll(item, "'", "''") + "'"
+		}
+		return "@(" + strings.Join(quoted, ", ") + ")"
+	}
+	fmt.Fprint(w, `# PowerShell completion for heictojpeg, from "heictojpeg completion powershell".
+# Add to $PROFILE: heictojpeg completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName heictojpeg -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $values = @{
+`)
+	for _, f := range valueFlags(commands) {
+		fmt.Fprintf(w, "        '%s' = %s\n", f.name, list(f.values))
+	}
+	fmt.Fprint(w, "    }\n    $flags = @{\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "        '%s' = %s\n", c.name, list(dashed(c.flags)))
+	}
+	fmt.Fprint(w, "    }\n    $arguments = @{\n")
+	for _, c := range commands {
+		if len(c.args) > 0 {
+			fmt.Fprintf(w, "        '%s' = %s\n", c.name, list(c.args))
+		}
+	}
+	fmt.Fprintf(w, `    }
+    $subcommands = %s
+    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })
+    $count = $words.Count
+    if ($wordToComplete -ne '') { $count-- }
+    $prev = if ($count -gt 1) { $words[$count - 1].TrimStart('-') } else { '' }
+    $sub = if ($count -gt 1 -and $flags.ContainsKey($words[1])) { $words[1] } else { '' }
+    if ($values.ContainsKey($prev)) {
+        $candidates = $values[$prev]
+    } elseif ($wordToComplete -like '-*') {
+        $candidates = $flags[$sub]
+    } elseif ($count -eq 1) {
+        $candidates = $subcommands
+    } elseif ($count -eq 2 -and $arguments.ContainsKey($sub)) {
+        $candidates = $arguments[$sub]
+    } else {
+        return
+    }
+    $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`, list(subcommandNames(commands)))
+}
+
+// firstLine is the first line of a flag's usage, for shells that show it.
+func firstLine(usage string) string {
+	line, _, _ := strings.Cut(usage, "\n")
+	return strings.TrimSpace(line)
+}
+
+// fishQuote quotes s as a single-quoted fish string.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompletionScripts(t *testing.T) {
+	for _, shell := range completionShells {
+		var out bytes.Buffer
+		if err := runCompletion([]string{shell}, &out); err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		script := out.String()
+		newFlagSet(&options{}, io.Discard).VisitAll(func(f *flag.Flag) {
+			if !strings.Contains(script, f.Name) {
+				t.Errorf("%s script misses --%s", shell, f.Name)
+			}
+		})
+		for _, want := range []string{"transcode", "completion", "raw-rgba", "sharpest", "powershell"} {
+			if !strings.Contains(script, want) {
+				t.Errorf("%s script misses %s", shell, want)
+			}
+		}
+		if strings.Contains(script, "run-service") {
+			t.Errorf("%s script offers the internal run-service", shell)
+		}
+	}
+	for _, args := range [][]string{nil, {"tcsh"}, {"bash", "zsh"}} {
+		if err := runCompletion(args, io.Discard); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+// TestCompletionFlags checks the flags completionCommands lists for the
+// subcommands with flag sets of their own against what their -h prints.
+func TestCompletionFlags(t *testing.T) {
+	runners := map[string]func([]string, io.Writer) error{
+		"check":               runCheck,
+		"view":                runView,
+		"diff":                runDiff,
+		"audit":               runAudit,
+		"bench":               runBench,
+		"queue":               runQueueCommand,
+		"install-integration": installIntegration,
+		"version":             runVersion,
+		"selftest":            runSelftest,
+	}
+	for _, c := range completionCommands() {
+		runner, ok := runners[c.name]
+		if !ok {
+			continue
+		}
+		var out bytes.Buffer
+		runner([]string{"-h"}, &out)
+		var want, got []string
+		lines := strings.Split(out.String(), "\n")
+		for i, line := range lines {
+			if !strings.HasPrefix(line, "  -") || i+1 == len(lines) {
+				continue
+			}
+			name, _, _ := strings.Cut(strings.TrimPrefix(line, "  -"), " ")
+			usage, _, _ := strings.Cut(strings.TrimSpace(lines[i+1]), " (default")
+			want = append(want, name+": "+usage)
+		}
+		for _, f := range c.flags {
+			got = append(got, f.name+": "+f.usage)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: completing %q, -h lists %q", c.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// EXIF tags giving the size of the compressed image.
+const (
+	tagPixelXDimension = 0xa002
+	tagPixelYDimension = 0xa003
+)
+
+// parseCrop parses a --crop value such as 1080x1350+120+0.
+func parseCrop(value string) (image.Rectangle, error) {
+	size, offset, ok := strings.Cut(value, "+")
+	xs, ys, ok2 := strings.Cut(offset, "+")
+	if !ok || !ok2 {
+		return image.Rectangle{}, fmt.Errorf("%q is not WIDTHxHEIGHT+X+Y", value)
+	}
+	width, height, err := parseResize(size)
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("%q is not WIDTHxHEIGHT+X+Y", value)
+	}
+	x, errX := strconv.Atoi(xs)
+	y, errY := strconv.Atoi(ys)
+	if errX != nil || errY != nil || x < 0 || y < 0 {
+		return image.Rectangle{}, fmt.Errorf("%q is not WIDTHxHEIGHT+X+Y", value)
+	}
+	return image.Rect(x, y, x+width, y+height), nil
+}
+
+// parseAspect parses a --crop-aspect value such as 4:5.
+func parseAspect(value string) (width, height int, err error) {
+	w, h, ok := strings.Cut(value, ":")
+	if ok {
+		width, err = strconv.Atoi(w)
+	}
+	if ok && err == nil {
+		height, err = strconv.Atoi(h)
+	}
+	if !ok || err != nil || width < 1 || height < 1 {
+		return 0, 0, fmt.Errorf("%q is not WIDTH:HEIGHT", value)
+	}
+	return width, height, nil
+}
+
+// aspectRect is the largest centred rectangle of a width x height image
+// with the aspect ratio aw:ah.
+func aspectRect(width, height, aw, ah int) image.Rectangle {
+	w, h := width, width*ah/aw
+	if h > height {
+		w, h = height*aw/ah, height
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	x, y := (width-w)/2, (height-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+// storedPoint maps pixel x, y of the image as displayed to the stored pixel
+// of a width x height image with the given EXIF orientation.
+func storedPoint(x, y, orientation, width, height int) image.Point {
+	switch orientation {
+	case 2:
+		return image.Pt(width-1-x, y)
+	case 3:
+		return image.Pt(width-1-x, height-1-y)
+	case 4:
+		return image.Pt(x, height-1-y)
+	case 5:
+		return image.Pt(y, x)
+	case 6:
+		return image.Pt(y, height-1-x)
+	case 7:
+		return image.Pt(width-1-y, height-1-x)
+	case 8:
+		return image.Pt(width-1-y, x)
+	}
+	return image.Pt(x, y)
+}
+
+// storedRect maps a rectangle of the image as displayed to the stored
+// pixels of a width x height image with the given EXIF orientation.
+func storedRect(r image.Rectangle, orientation, width, height int) image.Rectangle {
+	// Map the corner pixels, then widen the span between them back to a
+	// rectangle of whole pixels.
+	a := storedPoint(r.Min.X, r.Min.Y, orientation, width, height)
+	b := storedPoint(r.Max.X-1, r.Max.Y-1, orientation, width, height)
+	stored := image.Rect(a.X, a.Y, b.X, b.Y)
+	stored.Max = stored.Max.Add(image.Pt(1, 1))
+	return stored
+}
+
+// cropImage applies --crop or --crop-aspect, both given for the image as
+// displayed. A --crop rectangle is clipped to the image and fails when
+// nothing is left.
+func cropImage(img image.Image, opts options, orientation int) (image.Image, error) {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	rotated := orientation >= 5 && orientation <= 8
+	if rotated {
+		width, height = height, width
+	}
+
+	var r image.Rectangle
+	if opts.aspectWidth > 0 {
+		r = aspectRect(width, height, opts.aspectWidth, opts.aspectHeight)
+	} else {
+		r = opts.crop.Intersect(image.Rect(0, 0, width, height))
+		if r.Empty() {
+			return nil, fmt.Errorf("--crop %s is outside the %dx%d image", opts.cropValue, width, height)
+		}
+	}
+	if r == image.Rect(0, 0, width, height) {
+		return img, nil
+	}
+
+	stored := storedRect(r, orientation, b.Dx(), b.Dy()).Add(b.Min)
+	cropped := image.NewRGBA(image.Rect(0, 0, stored.Dx(), stored.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, stored.Min, draw.Src)
+	return cropped, nil
+}
+
+// setPixelDimensions records the size of the encoded image in the EXIF
+// PixelXDimension and PixelYDimension tags, where the source has them, so
+// they do not keep the size from before cropping or scaling.
+func setPixelDimensions(rawExif []byte, width, height int) []byte {
+	block, err := parseExifBlock(rawExif)
+	if err != nil || findEntry(block.exif, tagPixelXDimension) == nil && findEntry(block.exif, tagPixelYDimension) == nil {
+		return rawExif
+	}
+	block.exif = setEntry(block.exif, block.long(tagPixelXDimension, uint32(width)))
+	block.exif = setEntry(block.exif, block.long(tagPixelYDimension, uint32(height)))
+	return block.encode()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseCrop(t *testing.T) {
+	r, err := parseCrop("1080x1350+120+0")
+	if err != nil || r != image.Rect(120, 0, 1200, 1350) {
+		t.Fatalf("got %v (%v)", r, err)
+	}
+	for _, value := range []string{"1080x1350", "1080x1350+1", "0x10+0+0", "10x10+-1+0", "10x10+a+0"} {
+		if _, err := parseCrop(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+}
+
+func TestParseOptionsCrop(t *testing.T) {
+	opts, err := parseOptions([]string{"--crop-aspect", "4:5"}, io.Discard)
+	if err != nil || opts.aspectWidth != 4 || opts.aspectHeight != 5 {
+		t.Fatalf("unexpected aspect %d:%d (%v)", opts.aspectWidth, opts.aspectHeight, err)
+	}
+	for _, args := range [][]string{
+		{"--crop-aspect", "4x5"},
+		{"--crop-aspect", "0:5"},
+		{"--crop", "10x10+0+0", "--crop-aspect", "1:1"},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
+
+func TestAspectRect(t *testing.T) {
+	for _, tc := range []struct {
+		width, height, aw, ah int
+		want                  image.Rectangle
+	}{
+		{1600, 1000, 4, 5, image.Rect(400, 0, 1200, 1000)},
+		{1000, 1600, 1, 1, image.Rect(0, 300, 1000, 1300)},
+		{800, 1000, 4, 5, image.Rect(0, 0, 800, 1000)},
+	} {
+		if got := aspectRect(tc.width, tc.height, tc.aw, tc.ah); got != tc.want {
+			t.Errorf("%dx%d %d:%d: got %v, want %v", tc.width, tc.height, tc.aw, tc.ah, got, tc.want)
+		}
+	}
+}
+
+// TestCropImageOrientation crops the top-left pixel of the displayed image
+// for every orientation and checks it is the stored pixel a viewer shows
+// there.
+func TestCropImageOrientation(t *testing.T) {
+	// A 4x3 stored image with a distinct colour per pixel.
+	src := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 4; x++ {
+			src.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+	// The stored pixel displayed at the top left, per orientation.
+	corners := map[int]image.Point{
+		1: {0, 0}, 2: {3, 0}, 3: {3, 2}, 4: {0, 2},
+		5: {0, 0}, 6: {0, 2}, 7: {3, 2}, 8: {3, 0},
+	}
+	for orientation, want := range corners {
+		opts := defaultOptions()
+		opts.crop = image.Rect(0, 0, 1, 1)
+		img, err := cropImage(src, opts, orientation)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.At(0, 0); got != src.At(want.X, want.Y) {
+			t.Errorf("orientation %d: got %v, want pixel %v", orientation, got, want)
+		}
+	}
+
+	// Rotated images are cropped as displayed, 3 wide and 4 high.
+	opts := defaultOptions()
+	opts.crop = image.Rect(0, 0, 3, 2)
+	img, err := cropImage(src, opts, 6)
+	if err != nil || img.Bounds() != image.Rect(0, 0, 2, 3) {
+		t.Errorf("got %v (%v), want 2x3 stored pixels", img.Bounds(), err)
+	}
+	opts.crop = image.Rect(5, 0, 8, 2)
+	if _, err := cropImage(src, opts, 6); err == nil {
+		t.Error("expected error for a crop outside the image")
+	}
+}
+
+func TestSetPixelDimensions(t *testing.T) {
+	block := newExifBlock()
+	block.exif = append(block.exif, block.long(tagPixelXDimension, 4032), block.long(tagPixelYDimension, 3024))
+	updated, err := parseExifBlock(setPixelDimensions(block.encode(), 1080, 1350))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, y := findEntry(updated.exif, tagPixelXDimension), findEntry(updated.exif, tagPixelYDimension)
+	if x == nil || y == nil || updated.order.Uint32(x.value) != 1080 || updated.order.Uint32(y.value) != 1350 {
+		t.Errorf("dimensions not updated: %+v %+v", x, y)
+	}
+
+	// Blocks without the tags do not get them.
+	plain := newExifBlock().encode()
+	if got, _ := parseExifBlock(setPixelDimensions(plain, 10, 10)); findEntry(got.exif, tagPixelXDimension) != nil {
+		t.Error("dimensions added")
+	}
+}
+
+func TestConvertWithCropAspect(t *testing.T) {
+	jpegDir := t.TempDir()
+	opts := defaultOptions()
+	opts.aspectWidth, opts.aspectHeight = 4, 5
+	output, err := convertFile("testdata/images", "goheif-camel.heic", jpegDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(jpegDir, output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The camel is 1596x1064.
+	if config.Width != 851 || config.Height != 1064 {
+		t.Errorf("got %dx%d, want 851x1064", config.Width, config.Height)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/iancleary/heictojpeg/heic"
+)
+
+// decoderBackend names the HEVC decoder used for every conversion.
+const decoderBackend = heic.Backend
+
+// decodeHEIC decodes the primary image of a HEIC file with the heic package,
+// which image.Decode also uses once it is imported.
+func decodeHEIC(r io.Reader) (image.Image, error) {
+	return heic.Decode(r)
+}
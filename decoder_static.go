@@ -0,0 +1,11 @@
+//go:build cgo && static && (linux || windows)
+
+package main
+
+// Building with -tags "static osusergo" links libde265, the C++ runtime and
+// libc into the binary, so it runs on systems without matching shared
+// libraries, such as NAS devices. osusergo keeps --chown's user lookups in Go,
+// which static glibc cannot do reliably.
+
+// #cgo LDFLAGS: -static
+import "C"
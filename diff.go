@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// defaultDiffThreshold is the change in output size, in percent, that
+// `heictojpeg diff` reports by default.
+const defaultDiffThreshold = 5.0
+
+// reportDiff is what changed between two run reports, by source.
+type reportDiff struct {
+	NewlyConverted []reportFile `json:"newly_converted"`
+	NewlyFailing   []reportFile `json:"newly_failing"`
+	SizeChanged    []sizeChange `json:"size_changed"`
+	Missing        []reportFile `json:"missing"` // in the first report only
+}
+
+// sizeChange is a source converted by both runs into outputs of different
+// sizes.
+type sizeChange struct {
+	Source  string  `json:"source"`
+	Before  int64   `json:"before"`
+	After   int64   `json:"after"`
+	Percent float64 `json:"percent"`
+}
+
+func (d reportDiff) empty() bool {
+	return len(d.NewlyConverted)+len(d.NewlyFailing)+len(d.SizeChanged)+len(d.Missing) == 0
+}
+
+// diffReports compares the run after with the run before: the sources after
+// converted that before did not, those it failed that before did not fail,
+// outputs whose size changed by more than threshold percent, and sources of
+// before that after does not have. Sources after has that before does not
+// count as newly converted or failing.
+func diffReports(before, after *runReport, threshold float64) reportDiff {
+	previous := make(map[string]reportFile, len(before.Files))
+	for _, file := range before.Files {
+		previous[file.Source] = file
+	}
+	// Empty rather than null in JSON.
+	d := reportDiff{NewlyConverted: []reportFile{}, NewlyFailing: []reportFile{}, SizeChanged: []sizeChange{}, Missing: []reportFile{}}
+	seen := make(map[string]bool, len(after.Files))
+	for _, file := range after.Files {
+		seen[file.Source] = true
+		old, ok := previous[file.Source]
+		switch {
+		case file.Status == reportConverted && (!ok || old.Status != reportConverted):
+			d.NewlyConverted = append(d.NewlyConverted, file)
+		case file.Status == reportFailed && (!ok || old.Status != reportFailed):
+			d.NewlyFailing = append(d.NewlyFailing, file)
+		case file.Status == reportConverted && old.OutputSize != file.OutputSize:
+			percent := 100.0
+			if old.OutputSize > 0 {
+				percent = float64(file.OutputSize-old.OutputSize) * 100 / float64(old.OutputSize)
+			}
+			if math.Abs(percent) > threshold {
+				d.SizeChanged = append(d.SizeChanged, sizeChange{Source: file.Source, Before: old.OutputSize, After: file.OutputSize, Percent: percent})
+			}
+		}
+	}
+	for _, file := range before.Files {
+		if !seen[file.Source] {
+			d.Missing = append(d.Missing, file)
+		}
+	}
+	sort.Slice(d.SizeChanged, func(i, j int) bool {
+		return math.Abs(d.SizeChanged[i].Percent) > math.Abs(d.SizeChanged[j].Percent)
+	})
+	return d
+}
+
+// runDiff implements `heictojpeg diff [--threshold PERCENT] [--json]
+// BEFORE.json AFTER.json`, comparing two --report files, as of a reference
+// corpus converted before and after upgrading. Like diff(1), it returns an
+// error when the runs differ, so scripts can test the exit status.
+func runDiff(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: heictojpeg diff [--threshold PERCENT] [--json] BEFORE.json AFTER.json")
+		fs.PrintDefaults()
+	}
+	threshold := fs.Float64("threshold", defaultDiffThreshold, "report outputs whose size changed by more than this many percent")
+	asJSON := fs.Bool("json", false, "print the differences as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff needs two reports written by --report")
+	}
+	if *threshold < 0 {
+		return fmt.Errorf("invalid --threshold %v", *threshold)
+	}
+	before, err := readRunReport(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	after, err := readRunReport(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	d := diffReports(before, after, *threshold)
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	} else {
+		writeReportDiff(out, before, after, d, *threshold)
+	}
+	if !d.empty() {
+		return fmt.Errorf("%s and %s differ: %d newly converted, %d newly failing, %d size changes, %d missing",
+			fs.Arg(0), fs.Arg(1), len(d.NewlyConverted), len(d.NewlyFailing), len(d.SizeChanged), len(d.Missing))
+	}
+	return nil
+}
+
+// writeReportDiff prints d a section per kind of change, after the versions
+// that made the two runs.
+func writeReportDiff(out io.Writer, before, after *runReport, d reportDiff, threshold float64) {
+	fmt.Fprintf(out, "Before: %s, %s backend, %d files (%s)\n", before.Version, before.Backend, len(before.Files), before.Started.Format("2006-01-02 15:04"))
+	fmt.Fprintf(out, "After:  %s, %s backend, %d files (%s)\n", after.Version, after.Backend, len(after.Files), after.Started.Format("2006-01-02 15:04"))
+	if len(d.NewlyConverted) > 0 {
+		fmt.Fprintf(out, "\nNewly converted (%d):\n", len(d.NewlyConverted))
+		for _, file := range d.NewlyConverted {
+			fmt.Fprintf(out, "  %s > %s %s\n", file.Source, file.Output, humanReadableFileSize(file.OutputSize))
+		}
+	}
+	if len(d.NewlyFailing) > 0 {
+		fmt.Fprintf(out, "\nNewly failing (%d):\n", len(d.NewlyFailing))
+		for _, file := range d.NewlyFailing {
+			fmt.Fprintf(out, "  %s: %s [%s]\n", file.Source, file.Error, file.Code)
+		}
+	}
+	if len(d.SizeChanged) > 0 {
+		fmt.Fprintf(out, "\nOutput size changed by more than %g%% (%d):\n", threshold, len(d.SizeChanged))
+		for _, change := range d.SizeChanged {
+			fmt.Fprintf(out, "  %s %s > %s (%+.1f%%)\n", change.Source, humanReadableFileSize(change.Before), humanReadableFileSize(change.After), change.Percent)
+		}
+	}
+	if len(d.Missing) > 0 {
+		fmt.Fprintf(out, "\nNot in the second report (%d):\n", len(d.Missing))
+		for _, file := range d.Missing {
+			fmt.Fprintf(out, "  %s (%s)\n", file.Source, file.Status)
+		}
+	}
+	if d.empty() {
+		fmt.Fprintln(out, "\nNo differences")
+	}
+}
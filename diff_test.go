@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testReports() (before, after *runReport) {
+	before = &runReport{Files: []reportFile{
+		{Source: "fixed.heic", Status: reportFailed, Error: "decode failed", Code: codeDecodeFailed},
+		{Source: "broken.heic", Status: reportConverted, Output: "broken.jpg", OutputSize: 1000},
+		{Source: "grown.heic", Status: reportConverted, Output: "grown.jpg", OutputSize: 1000},
+		{Source: "same.heic", Status: reportConverted, Output: "same.jpg", OutputSize: 1000},
+		{Source: "gone.heic", Status: reportConverted, Output: "gone.jpg", OutputSize: 1000},
+	}}
+	before.Version = "v1.0.0"
+	after = &runReport{Files: []reportFile{
+		{Source: "fixed.heic", Status: reportConverted, Output: "fixed.jpg", OutputSize: 900},
+		{Source: "broken.heic", Status: reportFailed, Error: "decode failed", Code: codeDecodeFailed},
+		{Source: "grown.heic", Status: reportConverted, Output: "grown.jpg", OutputSize: 1200},
+		{Source: "same.heic", Status: reportConverted, Output: "same.jpg", OutputSize: 1040},
+	}}
+	after.Version = "v1.1.0"
+	return before, after
+}
+
+func TestDiffReports(t *testing.T) {
+	before, after := testReports()
+	d := diffReports(before, after, 5)
+	if len(d.NewlyConverted) != 1 || d.NewlyConverted[0].Source != "fixed.heic" {
+		t.Errorf("newly converted = %+v", d.NewlyConverted)
+	}
+	if len(d.NewlyFailing) != 1 || d.NewlyFailing[0].Source != "broken.heic" {
+		t.Errorf("newly failing = %+v", d.NewlyFailing)
+	}
+	if len(d.SizeChanged) != 1 || d.SizeChanged[0] != (sizeChange{Source: "grown.heic", Before: 1000, After: 1200, Percent: 20}) {
+		t.Errorf("size changed = %+v", d.SizeChanged)
+	}
+	if len(d.Missing) != 1 || d.Missing[0].Source != "gone.heic" {
+		t.Errorf("missing = %+v", d.Missing)
+	}
+
+	if d := diffReports(before, after, 1); len(d.SizeChanged) != 2 || d.SizeChanged[0].Source != "grown.heic" {
+		t.Errorf("with a 1%% threshold, size changed = %+v", d.SizeChanged)
+	}
+	if d := diffReports(after, after, 0); !d.empty() {
+		t.Errorf("a report differs from itself: %+v", d)
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	before, after := testReports()
+	write := func(name string, r *runReport) string {
+		path := filepath.Join(dir, name)
+		data, err := json.Marshal(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	beforePath, afterPath := write("before.json", before), write("after.json", after)
+
+	var out bytes.Buffer
+	if err := runDiff([]string{beforePath, afterPath}, &out); err == nil {
+		t.Error("expected an error for reports that differ")
+	}
+	for _, want := range []string{
+		"Before: v1.0.0", "After:  v1.1.0",
+		"Newly converted (1):\n  fixed.heic > fixed.jpg",
+		"Newly failing (1):\n  broken.heic: decode failed [" + codeDecodeFailed + "]",
+		"Output size changed by more than 5% (1):\n  grown.heic 1000B > 1.2KB (+20.0%)",
+		"Not in the second report (1):\n  gone.heic (converted)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := runDiff([]string{"--json", afterPath, afterPath}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var d reportDiff
+	if err := json.Unmarshal(out.Bytes(), &d); err != nil || !d.empty() || d.Missing == nil {
+		t.Errorf("got %s (%v)", out.String(), err)
+	}
+
+	if err := runDiff([]string{beforePath}, &out); err == nil {
+		t.Error("expected an error for one report")
+	}
+	if err := runDiff([]string{"--threshold", "-1", beforePath, afterPath}, &out); err == nil {
+		t.Error("expected an error for a negative threshold")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "errors"
+
+// freeSpace is not known here; plans leave it out.
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space is not available on this platform")
+}
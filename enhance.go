@@ -0,0 +1,138 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Limits of --auto-enhance, which keep it from making a photo look edited:
+// levels are stretched from the darkest and brightest enhanceClip of
+// pixels, channel gains stay within enhanceMaxGain of neutral, and the
+// midtones are lifted or lowered no further than the gamma range.
+const (
+	enhanceClip     = 0.005
+	enhanceMaxGain  = 1.25
+	enhanceMinGamma = 0.6
+	enhanceMaxGamma = 1.25
+
+	// enhanceMidtone is the brightness the median of the image is brought
+	// towards, a little under middle grey.
+	enhanceMidtone = 0.45
+
+	// enhanceSamples is about how many pixels the statistics are taken
+	// from, on a grid over the whole image.
+	enhanceSamples = 1 << 18
+)
+
+// enhancement is what --auto-enhance did to an image, as the sidecar
+// records it: the gains applied to red, green and blue for white balance,
+// the input levels stretched to black and white, and the gamma applied to
+// the midtones after that.
+type enhancement struct {
+	Gains      [3]float64 `json:"gains"`
+	BlackPoint int        `json:"blackPoint"`
+	WhitePoint int        `json:"whitePoint"`
+	Gamma      float64    `json:"gamma"`
+}
+
+// measureEnhancement works out the --auto-enhance correction of img from a
+// sample of its pixels: grey-world white balance, then levels that clip
+// enhanceClip of the balanced luma at either end, then a gamma that brings
+// its median towards enhanceMidtone. Images too flat to measure are left
+// with levels of 0 and 255.
+func measureEnhancement(img image.Image) enhancement {
+	b := img.Bounds()
+	step := int(math.Sqrt(float64(b.Dx()) * float64(b.Dy()) / enhanceSamples))
+	if step < 1 {
+		step = 1
+	}
+	var sums [3]float64
+	var pixels [][3]float64
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			p := [3]float64{float64(r >> 8), float64(g >> 8), float64(bl >> 8)}
+			pixels = append(pixels, p)
+			for c := range p {
+				sums[c] += p[c]
+			}
+		}
+	}
+
+	e := enhancement{Gains: [3]float64{1, 1, 1}, BlackPoint: 0, WhitePoint: 255, Gamma: 1}
+	grey := (sums[0] + sums[1] + sums[2]) / 3
+	for c := range sums {
+		if sums[c] > 0 {
+			e.Gains[c] = round2(math.Min(math.Max(grey/sums[c], 1/enhanceMaxGain), enhanceMaxGain))
+		}
+	}
+
+	var histogram [256]int
+	for _, p := range pixels {
+		histogram[lumaLevel(p[0]*e.Gains[0], p[1]*e.Gains[1], p[2]*e.Gains[2])]++
+	}
+	clip := int(float64(len(pixels)) * enhanceClip)
+	black, white, median := percentileLevel(histogram, clip), percentileLevel(histogram, len(pixels)-1-clip), percentileLevel(histogram, len(pixels)/2)
+	if white-black < 16 {
+		return e
+	}
+	e.BlackPoint, e.WhitePoint = black, white
+
+	level := float64(median-black) / float64(white-black)
+	if level > 0 && level < 1 {
+		e.Gamma = round2(math.Min(math.Max(math.Log(enhanceMidtone)/math.Log(level), enhanceMinGamma), enhanceMaxGamma))
+	}
+	return e
+}
+
+// lumaLevel is the Rec. 601 luma of an 8-bit colour, clamped to a level.
+func lumaLevel(r, g, b float64) int {
+	return int(math.Min(math.Max(0.299*r+0.587*g+0.114*b+0.5, 0), 255))
+}
+
+// percentileLevel returns the level of the histogram that the pixel of
+// rank n, counting from the darkest, falls in.
+func percentileLevel(histogram [256]int, n int) int {
+	for level, count := range histogram {
+		if n -= count; n < 0 {
+			return level
+		}
+	}
+	return 255
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// apply returns img with the correction applied, through a lookup table
+// per channel.
+func (e enhancement) apply(img image.Image) image.Image {
+	var tables [3][256]uint8
+	span := float64(e.WhitePoint - e.BlackPoint)
+	for c := range tables {
+		for i := range tables[c] {
+			v := (float64(i)*e.Gains[c] - float64(e.BlackPoint)) / span
+			v = math.Pow(math.Min(math.Max(v, 0), 1), e.Gamma)
+			tables[c][i] = uint8(v*255 + 0.5)
+		}
+	}
+
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	draw.Draw(rgba, b, img, b.Min, draw.Src)
+	pix := rgba.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		pix[i] = tables[0][pix[i]]
+		pix[i+1] = tables[1][pix[i+1]]
+		pix[i+2] = tables[2][pix[i+2]]
+	}
+	return rgba
+}
+
+// autoEnhance measures and applies the --auto-enhance correction of img.
+func autoEnhance(img image.Image) (image.Image, *enhancement) {
+	e := measureEnhancement(img)
+	return e.apply(img), &e
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// underexposedImage is a dark, blue-tinted gradient, as a phone shot in dim
+// light under a cool light source might be.
+func underexposedImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(10 + x + y/2)
+			img.Set(x, y, color.RGBA{v, v + 4, v + 20, 255})
+		}
+	}
+	return img
+}
+
+func meanLevels(img image.Image) [3]float64 {
+	var sums [3]float64
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sums[0], sums[1], sums[2] = sums[0]+float64(r>>8), sums[1]+float64(g>>8), sums[2]+float64(bl>>8)
+		}
+	}
+	n := float64(b.Dx() * b.Dy())
+	return [3]float64{sums[0] / n, sums[1] / n, sums[2] / n}
+}
+
+func TestMeasureEnhancement(t *testing.T) {
+	e := measureEnhancement(underexposedImage())
+	if !(e.Gains[0] > 1 && e.Gains[2] < 1) {
+		t.Errorf("blue cast not balanced: gains %v", e.Gains)
+	}
+	if e.BlackPoint == 0 || e.WhitePoint == 255 || e.WhitePoint <= e.BlackPoint {
+		t.Errorf("levels %d-%d not stretched", e.BlackPoint, e.WhitePoint)
+	}
+	if e.Gamma < enhanceMinGamma || e.Gamma > enhanceMaxGamma {
+		t.Errorf("gamma %v out of range", e.Gamma)
+	}
+
+	before, after := meanLevels(underexposedImage()), meanLevels(e.apply(underexposedImage()))
+	if after[0]+after[1]+after[2] <= before[0]+before[1]+before[2] {
+		t.Errorf("image not brightened: %v to %v", before, after)
+	}
+	if spread := after[2] - after[0]; spread >= before[2]-before[0] {
+		t.Errorf("blue cast not reduced: %v to %v", before, after)
+	}
+}
+
+func TestMeasureEnhancementFlatImage(t *testing.T) {
+	e := measureEnhancement(image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	if e.BlackPoint != 0 || e.WhitePoint != 255 || e.Gamma != 1 {
+		t.Errorf("a black image got %+v", e)
+	}
+	flat := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range flat.Pix {
+		flat.Pix[i] = 128
+	}
+	if e := measureEnhancement(flat); e.BlackPoint != 0 || e.WhitePoint != 255 || e.Gains != [3]float64{1, 1, 1} {
+		t.Errorf("a grey image got %+v", e)
+	}
+}
+
+func TestAutoEnhanceSidecar(t *testing.T) {
+	jpegDir := t.TempDir()
+	opts := defaultOptions()
+	opts.sidecar = true
+	opts.autoEnhance = true
+	if _, err := convertFile("testdata/images", "goheif-camel.heic", jpegDir, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(jpegDir, "goheif-camel.jpg.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record sidecar
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Enhancement == nil || record.Enhancement.WhitePoint == 0 || record.Settings["auto-enhance"] != "true" {
+		t.Errorf("enhancement not recorded: %s", data)
+	}
+
+	opts.autoEnhance = false
+	if _, err := convertFile("testdata/images", "goheif-camel.heic", jpegDir, opts); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(jpegDir, "goheif-camel.jpg.json"))
+	record = sidecar{}
+	if err := json.Unmarshal(data, &record); err != nil || record.Enhancement != nil {
+		t.Errorf("enhancement recorded without --auto-enhance: %s", data)
+	}
+}
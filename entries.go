@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// streamBatchSize is how many directory entries are read at a time when a
+// directory is streamed.
+const streamBatchSize = 1024
+
+// streamsInput reports whether the input directory can be converted while it
+// is being listed, rather than listed first: with --order directory, when
+// nothing needs the whole list up front. Other orders, --burst selection,
+// --watch, --dry-run, plans and PDFs look at every file before converting any.
+// Photos libraries stream the masters of their tree as the scan finds them.
+func streamsInput(opts options) bool {
+	if opts.order != orderDirectory || opts.filesFrom != "" ||
+		opts.burst != burstAll || opts.watch || opts.dryRun || opts.planOnly != "" || opts.plan != nil ||
+		opts.outputFormat == formatPDF || opts.bundle != "" {
+		return false
+	}
+	if opts.library != nil {
+		return true
+	}
+	info, err := os.Stat(opts.inputPath)
+	return err == nil && info.IsDir()
+}
+
+// entryStream lists a directory lazily, streamBatchSize entries at a time.
+// err is set, if listing failed part way, once entries is closed.
+type entryStream struct {
+	entries chan os.DirEntry
+	err     error
+}
+
+// streamDirectory starts listing dir in the order the filesystem returns its
+// entries.
+func streamDirectory(dir string) *entryStream {
+	s := &entryStream{entries: make(chan os.DirEntry, streamBatchSize)}
+	go func() {
+		defer close(s.entries)
+		f, err := os.Open(dir)
+		if err != nil {
+			s.err = err
+			return
+		}
+		defer f.Close()
+		for {
+			batch, err := f.ReadDir(streamBatchSize)
+			for _, entry := range batch {
+				s.entries <- entry
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				s.err = err
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// streamFiles converts the files of currentDir as they are listed and
+// returns the general logs. The per-file log lines are written to logFile as
+// results come in, along with the carried over lines of sources skipped as
+// converted, when set, reports them converted by an earlier run. Memory use
+// does not grow with the size of the directory.
+func streamFiles(currentDir, jpegDir string, logFile io.Writer, converted func(name string) (string, bool), opts options) map[string][]string {
+	fmt.Println("Processing files...")
+	startTime := time.Now()
+
+	// Lines of skipped sources are written while results come in.
+	logFile = &lockedWriter{w: logFile}
+	var stream *entryStream
+	if opts.library != nil {
+		stream = opts.library.scan(jpegDir, nil)
+	} else {
+		stream = streamDirectory(currentDir)
+	}
+	var filter *sourceFilter
+	if filtersSources(opts) {
+		filter = newSourceFilter(currentDir, opts)
+	}
+	carried := 0
+	kept := make(chan os.DirEntry)
+	go func() {
+		defer close(kept)
+		for file := range stream.entries {
+			if opts.ignore.ignored(file.Name(), file.IsDir()) {
+				opts.skipped.add(file.Name(), skipExcluded, "")
+				continue
+			}
+			if converted != nil {
+				if line, ok := converted(file.Name()); ok {
+					fmt.Fprintln(logFile, line)
+					opts.skipped.add(file.Name(), skipAlreadyConverted, "")
+					carried++
+					continue
+				}
+			}
+			if filter != nil && !filter.keep(file) {
+				continue
+			}
+			kept <- file
+		}
+	}()
+
+	logs := map[string][]string{}
+	logChan := make(chan *fileResult, opts.workers)
+	go runPipeline(kept, currentDir, jpegDir, opts, logChan)
+	aggregateLogs(logChan, logs, logFile, currentDir, jpegDir, 0, opts, startTime)
+
+	// The listing is over once the pipeline has drained it.
+	if filter != nil {
+		filter.report()
+	}
+	if converted != nil {
+		fmt.Printf("Skipped %d files converted by the previous run\n", carried)
+	}
+	if stream.err != nil {
+		fmt.Printf("Failed to list all of %s: %v\n", currentDir, stream.err)
+		logs["general"] = append(logs["general"], fmt.Sprintf("Listing Error==%v", stream.err))
+	}
+	return logs
+}
+
+// lockedWriter serializes writes from several goroutines.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
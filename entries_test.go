@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamDirectory(t *testing.T) {
+	dir := t.TempDir()
+	want := streamBatchSize + 3
+	for i := 0; i < want; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("IMG_%04d.heic", i)), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := streamDirectory(dir)
+	seen := map[string]bool{}
+	for entry := range stream.entries {
+		seen[entry.Name()] = true
+	}
+	if stream.err != nil || len(seen) != want {
+		t.Errorf("streamed %d of %d entries, err %v", len(seen), want, stream.err)
+	}
+
+	stream = streamDirectory(filepath.Join(dir, "missing"))
+	for range stream.entries {
+	}
+	if !os.IsNotExist(stream.err) {
+		t.Errorf("err = %v, want not exist", stream.err)
+	}
+}
+
+func TestStreamsInput(t *testing.T) {
+	opts := defaultOptions()
+	opts.inputPath = t.TempDir()
+	if streamsInput(opts) {
+		t.Error("streams with name order")
+	}
+	opts.order = orderDirectory
+	if !streamsInput(opts) {
+		t.Error("does not stream a directory with --order directory")
+	}
+	burst := opts
+	burst.burst = burstFirst
+	if streamsInput(burst) {
+		t.Error("streams with --burst first")
+	}
+	file := opts
+	file.inputPath = "testdata/images/goheif-camel.heic"
+	if streamsInput(file) {
+		t.Error("streams a single file")
+	}
+	library := opts
+	library.inputPath, library.library = "Missing.photoslibrary", &photosLibrary{path: "Missing.photoslibrary"}
+	if !streamsInput(library) {
+		t.Error("does not stream a Photos library with --order directory")
+	}
+}
+
+func TestRunStreamed(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.inputPath = dir
+	opts.outputDir = filepath.Join(dir, "out")
+	opts.order = orderDirectory
+	var total int
+	opts.progress = func(done, n int) { total = n }
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.outputDir, "camel.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	if total != 0 {
+		t.Errorf("progress total %d, want 0 while streaming", total)
+	}
+	logs, _ := os.ReadFile(filepath.Join(opts.outputDir, logFileName))
+	lines := strings.Split(string(logs), "\n")
+	if !strings.HasPrefix(lines[0], "{") || !strings.Contains(lines[1], "camel.heic") || !strings.Contains(string(logs), "\n1 Files") {
+		t.Errorf("log file:\n%s", logs)
+	}
+
+	// A second run skipping converted files carries the line over.
+	opts.existing = existingSkip
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	logs, _ = os.ReadFile(filepath.Join(opts.outputDir, logFileName))
+	if strings.Count(string(logs), "camel.heic") != 1 || !strings.Contains(string(logs), "\n0 Files") {
+		t.Errorf("log file after skipping:\n%s", logs)
+	}
+}
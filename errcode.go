@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// Error codes of failed files and runs in the JSON records (the server's job
+// status and --notify-webhook payloads), so scripts can act on a class of
+// failure without parsing messages. The codes are stable; messages are not.
+const (
+	codeNotHEIC          = "E_SOURCE_NOT_HEIC"
+	codeSourceMissing    = "E_SOURCE_MISSING"
+	codeSourceEmpty      = "E_SOURCE_EMPTY"
+	codeSourceTruncated  = "E_SOURCE_TRUNCATED"
+	codeSourceMalformed  = "E_SOURCE_MALFORMED"
+	codeNotDownloaded    = "E_SOURCE_NOT_DOWNLOADED"
+	codeUnsupportedCodec = "E_DECODE_UNSUPPORTED_CODEC"
+	codeNoDecoder        = "E_DECODE_NO_DECODER"
+	codeDecodeFailed     = "E_DECODE_FAILED"
+	codeEncodeFailed     = "E_ENCODE_FAILED"
+	codeEncodeInvalid    = "E_ENCODE_INVALID"
+	codeNotSmaller       = "E_NOT_SMALLER"
+	codeMetadataLoss     = "E_METADATA_LOSS"
+	codeReadPermission   = "E_READ_PERMISSION"
+	codeReadFailed       = "E_READ_FAILED"
+	codeWritePermission  = "E_WRITE_PERMISSION"
+	codeWriteNoSpace     = "E_WRITE_NO_SPACE"
+	codeWriteFailed      = "E_WRITE_FAILED"
+	codeUploadFailed     = "E_UPLOAD_FAILED"
+	codeCancelled        = "E_CANCELLED"
+	codeUnknown          = "E_UNKNOWN"
+)
+
+// stageError is an error of one stage of a conversion ("check", "read",
+// "decode", "encode", "write" or "upload"), which errorCode falls back on
+// for errors it does not know. The message is the underlying error's.
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string { return e.err.Error() }
+func (e *stageError) Unwrap() error { return e.err }
+
+// errorCode classifies err, "" for nil: known errors by what they are,
+// others by the stage of the conversion they happened in.
+func errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	stage := ""
+	var se *stageError
+	if errors.As(err, &se) {
+		stage = se.stage
+	}
+	reading := stage == "check" || stage == "read"
+
+	switch {
+	case errors.Is(err, errNotHEIC):
+		return codeNotHEIC
+	case errors.Is(err, errEmptySource):
+		return codeSourceEmpty
+	case errors.Is(err, errTruncatedSource):
+		return codeSourceTruncated
+	case errors.Is(err, errUnsupportedCodec):
+		return codeUnsupportedCodec
+	case errors.Is(err, errMalformedSource):
+		return codeSourceMalformed
+	case errors.Is(err, errNotDownloaded):
+		return codeNotDownloaded
+	case errors.Is(err, errNoDecoder):
+		return codeNoDecoder
+	case errors.Is(err, errNotSmaller):
+		return codeNotSmaller
+	case errors.Is(err, errMetadataLoss):
+		return codeMetadataLoss
+	case errors.Is(err, errInvalidOutput):
+		return codeEncodeInvalid
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return codeCancelled
+	case errors.Is(err, fs.ErrNotExist) && (reading || stage == ""):
+		return codeSourceMissing
+	case errors.Is(err, fs.ErrPermission) && (reading || stage == ""):
+		return codeReadPermission
+	case errors.Is(err, fs.ErrPermission) && stage != "upload":
+		return codeWritePermission
+	case errors.Is(err, syscall.ENOSPC):
+		return codeWriteNoSpace
+	}
+	switch stage {
+	case "check", "read":
+		return codeReadFailed
+	case "decode":
+		return codeDecodeFailed
+	case "encode":
+		return codeEncodeFailed
+	case "write":
+		return codeWriteFailed
+	case "upload":
+		return codeUploadFailed
+	}
+	return codeUnknown
+}
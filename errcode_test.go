@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	permission := &fs.PathError{Op: "open", Path: "x", Err: fs.ErrPermission}
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{errNotHEIC, codeNotHEIC},
+		{&stageError{"check", fmt.Errorf("%w: 12 bytes", errTruncatedSource)}, codeSourceTruncated},
+		{&codecError{name: "AVIF", brand: "avif"}, codeUnsupportedCodec},
+		{&stageError{"check", fmt.Errorf("%w: bad box", errMalformedSource)}, codeSourceMalformed},
+		{&stageError{"decode", errNoDecoder}, codeNoDecoder},
+		{&stageError{"decode", fmt.Errorf("%w: item 1 has no HEVC configuration", errUnsupportedCodec)}, codeUnsupportedCodec},
+		{&stageError{"decode", errors.New("corrupt slice")}, codeDecodeFailed},
+		{&stageError{"encode", errNotSmaller}, codeNotSmaller},
+		{&stageError{"read", permission}, codeReadPermission},
+		{&stageError{"write", permission}, codeWritePermission},
+		{&stageError{"write", &fs.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}}, codeWriteNoSpace},
+		{&stageError{"write", errors.New("short write")}, codeWriteFailed},
+		{&stageError{"upload", permission}, codeUploadFailed},
+		{&fs.PathError{Op: "stat", Path: "x", Err: fs.ErrNotExist}, codeSourceMissing},
+		{context.Canceled, codeCancelled},
+		{errors.New("something else"), codeUnknown},
+	} {
+		if got := errorCode(tc.err); got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.err, got, tc.want)
+		}
+	}
+	// Tagging an error with its stage keeps its message.
+	if err := (&stageError{"decode", errors.New("corrupt slice")}); err.Error() != "corrupt slice" {
+		t.Errorf("message %q", err.Error())
+	}
+}
+
+func TestConversionErrorCodes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "empty.heic"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	avif, err := os.ReadFile("testdata/images/libheif-example.avif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "renamed.heif"), avif, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("text"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jpegDir := t.TempDir()
+	for name, want := range map[string]string{
+		"empty.heic":   codeSourceEmpty,
+		"renamed.heif": codeUnsupportedCodec,
+		"missing.heic": codeSourceMissing,
+		"notes.txt":    codeNotHEIC,
+	} {
+		ev := convertPath(filepath.Join(dir, name), jpegDir, defaultOptions())
+		if got := errorCode(ev.Err); got != want {
+			t.Errorf("%s: got %q (%v), want %q", name, got, ev.Err, want)
+		}
+	}
+}
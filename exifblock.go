@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// EXIF tags the rewriter handles structurally.
+const (
+	tagExifIFDPointer      = 0x8769
+	tagGPSIFDPointer       = 0x8825
+	tagInteropIFDPointer   = 0xa005
+	tagCompression         = 0x0103
+	tagXResolution         = 0x011a
+	tagYResolution         = 0x011b
+	tagResolutionUnit      = 0x0128
+	tagJPEGInterchange     = 0x0201
+	tagJPEGInterchangeSize = 0x0202
+)
+
+// TIFF field types.
+const (
+	tiffByte      = 1
+	tiffASCII     = 2
+	tiffShort     = 3
+	tiffLong      = 4
+	tiffRational  = 5
+	tiffUndefined = 7
+	tiffSLong     = 9
+	tiffSRational = 10
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// tiffEntry is a single IFD entry. value holds the raw value bytes in the
+// block's byte order.
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// exifBlock is an editable EXIF block. Sub-IFD pointers and thumbnail offsets
+// are not stored as entries; they are regenerated when the block is encoded,
+// so entries can be added and removed freely.
+type exifBlock struct {
+	order     binary.ByteOrder
+	ifd0      []tiffEntry
+	exif      []tiffEntry
+	gps       []tiffEntry
+	interop   []tiffEntry
+	ifd1      []tiffEntry
+	thumbnail []byte
+}
+
+func tiffTypeSize(typ uint16) int {
+	switch typ {
+	case 1, 2, 6, 7:
+		return 1
+	case 3, 8:
+		return 2
+	case 4, 9, 11, 13:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+	return 0
+}
+
+// newExifBlock returns an empty little-endian block with the baseline
+// resolution tags.
+func newExifBlock() *exifBlock {
+	b := &exifBlock{order: binary.LittleEndian}
+	b.ifd0 = b.resolutionEntries()
+	return b
+}
+
+func (b *exifBlock) resolutionEntries() []tiffEntry {
+	return []tiffEntry{
+		b.rational(tagXResolution, 72, 1),
+		b.rational(tagYResolution, 72, 1),
+		b.short(tagResolutionUnit, 2),
+	}
+}
+
+// parseExifBlock parses a raw EXIF block, with or without the "Exif\0\0"
+// prefix.
+func parseExifBlock(raw []byte) (*exifBlock, error) {
+	data := bytes.TrimPrefix(raw, exifHeader)
+	if len(data) < 8 {
+		return nil, errors.New("exif: block too short")
+	}
+
+	b := &exifBlock{}
+	switch string(data[:2]) {
+	case "II":
+		b.order = binary.LittleEndian
+	case "MM":
+		b.order = binary.BigEndian
+	default:
+		return nil, errors.New("exif: invalid byte order")
+	}
+	if b.order.Uint16(data[2:]) != 42 {
+		return nil, errors.New("exif: invalid TIFF header")
+	}
+
+	var err error
+	var next uint32
+	var pointers map[uint16]uint32
+	b.ifd0, pointers, next, err = readIFD(data, b.order, b.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+
+	if offset, ok := pointers[tagExifIFDPointer]; ok {
+		var exifPointers map[uint16]uint32
+		if b.exif, exifPointers, _, err = readIFD(data, b.order, offset); err != nil {
+			return nil, err
+		}
+		if offset, ok := exifPointers[tagInteropIFDPointer]; ok {
+			// A broken interoperability IFD is not worth failing over.
+			b.interop, _, _, _ = readIFD(data, b.order, offset)
+		}
+	}
+	if offset, ok := pointers[tagGPSIFDPointer]; ok {
+		if b.gps, _, _, err = readIFD(data, b.order, offset); err != nil {
+			return nil, err
+		}
+	}
+
+	if next != 0 {
+		ifd1, thumbPointers, _, err := readIFD(data, b.order, next)
+		if err == nil {
+			b.ifd1 = ifd1
+			start, hasStart := thumbPointers[tagJPEGInterchange]
+			length, hasLength := thumbPointers[tagJPEGInterchangeSize]
+			if hasStart && hasLength && uint64(start)+uint64(length) <= uint64(len(data)) {
+				b.thumbnail = append([]byte(nil), data[start:start+length]...)
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// readIFD reads the IFD at offset. Pointer-like entries (sub-IFDs and the
+// thumbnail location) are returned separately from regular entries.
+func readIFD(data []byte, order binary.ByteOrder, offset uint32) ([]tiffEntry, map[uint16]uint32, uint32, error) {
+	if uint64(offset)+2 > uint64(len(data)) {
+		return nil, nil, 0, fmt.Errorf("exif: IFD offset %d out of range", offset)
+	}
+	count := int(order.Uint16(data[offset:]))
+	end := uint64(offset) + 2 + uint64(count)*12
+	if end+4 > uint64(len(data)) {
+		return nil, nil, 0, fmt.Errorf("exif: IFD at %d truncated", offset)
+	}
+
+	var entries []tiffEntry
+	pointers := map[uint16]uint32{}
+	for i := 0; i < count; i++ {
+		e := data[offset+2+uint32(i)*12:]
+		entry := tiffEntry{
+			tag:   order.Uint16(e[0:]),
+			typ:   order.Uint16(e[2:]),
+			count: order.Uint32(e[4:]),
+		}
+
+		switch entry.tag {
+		case tagExifIFDPointer, tagGPSIFDPointer, tagInteropIFDPointer, tagJPEGInterchange, tagJPEGInterchangeSize:
+			pointers[entry.tag] = order.Uint32(e[8:])
+			continue
+		}
+
+		size := uint64(tiffTypeSize(entry.typ)) * uint64(entry.count)
+		if size == 0 {
+			continue
+		}
+		if size <= 4 {
+			entry.value = append([]byte(nil), e[8:8+size]...)
+		} else {
+			valueOffset := uint64(order.Uint32(e[8:]))
+			if valueOffset+size > uint64(len(data)) {
+				// Skip entries pointing outside the block rather than
+				// rejecting the whole block.
+				continue
+			}
+			entry.value = append([]byte(nil), data[valueOffset:valueOffset+size]...)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, pointers, order.Uint32(data[end:]), nil
+}
+
+// encode serializes the block, including the "Exif\0\0" prefix.
+func (b *exifBlock) encode() []byte {
+	exifIFD := append([]tiffEntry(nil), b.exif...)
+	ifd0 := append([]tiffEntry(nil), b.ifd0...)
+	ifd1 := append([]tiffEntry(nil), b.ifd1...)
+
+	// Pointer values are patched below once offsets are known; the entries
+	// only need to exist so the IFD sizes are right.
+	if len(b.interop) > 0 {
+		exifIFD = append(exifIFD, b.long(tagInteropIFDPointer, 0))
+	}
+	if len(exifIFD) > 0 {
+		ifd0 = append(ifd0, b.long(tagExifIFDPointer, 0))
+	}
+	if len(b.gps) > 0 {
+		ifd0 = append(ifd0, b.long(tagGPSIFDPointer, 0))
+	}
+	if len(b.thumbnail) > 0 {
+		ifd1 = append(ifd1, b.long(tagJPEGInterchange, 0), b.long(tagJPEGInterchangeSize, uint32(len(b.thumbnail))))
+	}
+
+	offset := uint32(8)
+	ifd0Offset := offset
+	offset += ifdSizeOf(ifd0)
+	exifOffset := offset
+	if len(exifIFD) > 0 {
+		offset += ifdSizeOf(exifIFD)
+	}
+	interopOffset := offset
+	if len(b.interop) > 0 {
+		offset += ifdSizeOf(b.interop)
+	}
+	gpsOffset := offset
+	if len(b.gps) > 0 {
+		offset += ifdSizeOf(b.gps)
+	}
+	ifd1Offset := uint32(0)
+	thumbOffset := offset
+	if len(ifd1) > 0 {
+		ifd1Offset = offset
+		offset += ifdSizeOf(ifd1)
+		thumbOffset = offset
+	}
+
+	setLong := func(entries []tiffEntry, tag uint16, value uint32) {
+		for i := range entries {
+			if entries[i].tag == tag {
+				entries[i] = b.long(tag, value)
+			}
+		}
+	}
+	setLong(ifd0, tagExifIFDPointer, exifOffset)
+	setLong(ifd0, tagGPSIFDPointer, gpsOffset)
+	setLong(exifIFD, tagInteropIFDPointer, interopOffset)
+	setLong(ifd1, tagJPEGInterchange, thumbOffset)
+
+	out := append([]byte(nil), exifHeader...)
+	tiff := make([]byte, 8)
+	if b.order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	b.order.PutUint16(tiff[2:], 42)
+	b.order.PutUint32(tiff[4:], ifd0Offset)
+
+	tiff = b.appendIFD(tiff, ifd0, ifd1Offset)
+	if len(exifIFD) > 0 {
+		tiff = b.appendIFD(tiff, exifIFD, 0)
+	}
+	if len(b.interop) > 0 {
+		tiff = b.appendIFD(tiff, b.interop, 0)
+	}
+	if len(b.gps) > 0 {
+		tiff = b.appendIFD(tiff, b.gps, 0)
+	}
+	if len(ifd1) > 0 {
+		tiff = b.appendIFD(tiff, ifd1, 0)
+		tiff = append(tiff, b.thumbnail...)
+	}
+
+	return append(out, tiff...)
+}
+
+// ifdSizeOf returns the encoded size of an IFD including its value area.
+func ifdSizeOf(entries []tiffEntry) uint32 {
+	size := uint32(2 + 12*len(entries) + 4)
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += uint32(len(e.value) + len(e.value)%2)
+		}
+	}
+	return size
+}
+
+func (b *exifBlock) appendIFD(out []byte, entries []tiffEntry, next uint32) []byte {
+	sorted := append([]tiffEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].tag < sorted[j].tag })
+
+	start := uint32(len(out))
+	dataOffset := start + uint32(2+12*len(sorted)+4)
+
+	var header [12]byte
+	b.order.PutUint16(header[:2], uint16(len(sorted)))
+	out = append(out, header[:2]...)
+
+	var values []byte
+	for _, e := range sorted {
+		b.order.PutUint16(header[0:], e.tag)
+		b.order.PutUint16(header[2:], e.typ)
+		b.order.PutUint32(header[4:], e.count)
+		for i := 8; i < 12; i++ {
+			header[i] = 0
+		}
+		if len(e.value) <= 4 {
+			copy(header[8:], e.value)
+		} else {
+			b.order.PutUint32(header[8:], dataOffset+uint32(len(values)))
+			values = append(values, e.value...)
+			if len(e.value)%2 == 1 {
+				values = append(values, 0)
+			}
+		}
+		out = append(out, header[:]...)
+	}
+
+	b.order.PutUint32(header[:4], next)
+	out = append(out, header[:4]...)
+	return append(out, values...)
+}
+
+func (b *exifBlock) short(tag uint16, v uint16) tiffEntry {
+	value := make([]byte, 2)
+	b.order.PutUint16(value, v)
+	return tiffEntry{tag: tag, typ: tiffShort, count: 1, value: value}
+}
+
+func (b *exifBlock) long(tag uint16, v uint32) tiffEntry {
+	value := make([]byte, 4)
+	b.order.PutUint32(value, v)
+	return tiffEntry{tag: tag, typ: tiffLong, count: 1, value: value}
+}
+
+func (b *exifBlock) rational(tag uint16, num, den uint32) tiffEntry {
+	value := make([]byte, 8)
+	b.order.PutUint32(value, num)
+	b.order.PutUint32(value[4:], den)
+	return tiffEntry{tag: tag, typ: tiffRational, count: 1, value: value}
+}
+
+func (b *exifBlock) ascii(tag uint16, s string) tiffEntry {
+	value := append([]byte(s), 0)
+	return tiffEntry{tag: tag, typ: tiffASCII, count: uint32(len(value)), value: value}
+}
+
+// findEntry returns the entry with the given tag, or nil.
+func findEntry(entries []tiffEntry, tag uint16) *tiffEntry {
+	for i := range entries {
+		if entries[i].tag == tag {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// setEntry replaces the entry with the same tag or appends it.
+func setEntry(entries []tiffEntry, entry tiffEntry) []tiffEntry {
+	if existing := findEntry(entries, entry.tag); existing != nil {
+		*existing = entry
+		return entries
+	}
+	return append(entries, entry)
+}
+
+// removeEntry drops every entry with the given tag.
+func removeEntry(entries []tiffEntry, tag uint16) []tiffEntry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.tag != tag {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
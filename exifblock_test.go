@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+func TestExifBlockRoundTrip(t *testing.T) {
+	raw := buildTestExif(
+		[]testTag{asciiTag(0x010f, "Apple"), asciiTag(0x0110, "iPhone 12")},
+		[]testTag{asciiTag(0x9003, "2023:07:14 18:30:05")},
+		gpsTags(48.8584, 2.2945),
+	)
+
+	block, err := parseExifBlock(raw)
+	if err != nil {
+		t.Fatalf("parseExifBlock failed: %v", err)
+	}
+	block.ifd0 = setEntry(block.ifd0, block.ascii(0x013b, "Jane Doe"))
+
+	x := decodeExif(block.encode())
+	if x == nil {
+		t.Fatal("re-encoded block does not parse")
+	}
+	for field, want := range map[exif.FieldName]string{
+		exif.Model:            "iPhone 12",
+		exif.Artist:           "Jane Doe",
+		exif.DateTimeOriginal: "2023:07:14 18:30:05",
+	} {
+		if got := exifStringField(x, field); got != want {
+			t.Errorf("%s: got %q, want %q", field, got, want)
+		}
+	}
+	if lat, lon, err := x.LatLong(); err != nil || lat < 48.85 || lon < 2.29 {
+		t.Errorf("GPS lost in round trip: %f,%f %v", lat, lon, err)
+	}
+}
+
+func TestEmbedThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 800; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	for _, raw := range [][]byte{nil, buildTestExif([]testTag{asciiTag(0x0110, "iPhone 12")}, nil, nil)} {
+		withThumb, err := embedThumbnail(raw, img)
+		if err != nil {
+			t.Fatalf("embedThumbnail failed: %v", err)
+		}
+
+		x := decodeExif(withThumb)
+		if x == nil {
+			t.Fatal("EXIF with thumbnail does not parse")
+		}
+		thumb, err := x.JpegThumbnail()
+		if err != nil {
+			t.Fatalf("thumbnail not found: %v", err)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(thumb))
+		if err != nil {
+			t.Fatalf("thumbnail is not a JPEG: %v", err)
+		}
+		if config.Width != 160 || config.Height != 80 {
+			t.Fatalf("unexpected thumbnail size %dx%d", config.Width, config.Height)
+		}
+		if raw != nil && exifStringField(x, exif.Model) != "iPhone 12" {
+			t.Fatal("existing EXIF fields were not preserved")
+		}
+	}
+}
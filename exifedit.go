@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EXIF date/time tags and the offset tags (EXIF 2.31) that give their time
+// zone.
+const (
+	tagDateTime            = 0x0132
+	tagDateTimeOriginal    = 0x9003
+	tagDateTimeDigitized   = 0x9004
+	tagOffsetTime          = 0x9010
+	tagOffsetTimeOriginal  = 0x9011
+	tagOffsetTimeDigitized = 0x9012
+)
+
+// Attribution tags in IFD0.
+const (
+	tagArtist    = 0x013b
+	tagCopyright = 0x8298
+)
+
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// needsExifEdits reports whether opts asks for EXIF to be rewritten.
+func needsExifEdits(opts options) bool {
+	return opts.timeShift != 0 || opts.timezone != nil || opts.artist != "" || opts.copyright != ""
+}
+
+// editExif applies the metadata changes requested in opts to a raw EXIF
+// block. Sources without EXIF are returned unchanged, unless there is an
+// artist or copyright to record.
+func editExif(rawExif []byte, opts options) ([]byte, error) {
+	if !needsExifEdits(opts) {
+		return rawExif, nil
+	}
+	var block *exifBlock
+	if len(rawExif) == 0 {
+		if opts.artist == "" && opts.copyright == "" {
+			return rawExif, nil
+		}
+		block = newExifBlock()
+	} else {
+		var err error
+		if block, err = parseExifBlock(rawExif); err != nil {
+			return nil, err
+		}
+	}
+	adjustTimestamps(block, opts.timeShift, opts.timezone)
+	if opts.artist != "" {
+		block.ifd0 = setEntry(block.ifd0, block.ascii(tagArtist, opts.artist))
+	}
+	if opts.copyright != "" {
+		block.ifd0 = setEntry(block.ifd0, block.ascii(tagCopyright, opts.copyright))
+	}
+	return block.encode(), nil
+}
+
+// adjustTimestamps shifts the three EXIF timestamps by shift and, when zone
+// is set, records the zone's UTC offset at each (shifted) time in the
+// matching OffsetTime tag.
+func adjustTimestamps(block *exifBlock, shift time.Duration, zone *time.Location) {
+	stamps := []struct {
+		entries   *[]tiffEntry
+		tag       uint16
+		offsetTag uint16
+	}{
+		{&block.ifd0, tagDateTime, tagOffsetTime},
+		{&block.exif, tagDateTimeOriginal, tagOffsetTimeOriginal},
+		{&block.exif, tagDateTimeDigitized, tagOffsetTimeDigitized},
+	}
+	for _, stamp := range stamps {
+		entry := findEntry(*stamp.entries, stamp.tag)
+		if entry == nil {
+			continue
+		}
+		t, err := time.Parse(exifTimeLayout, string(bytes.TrimRight(entry.value, "\x00 ")))
+		if err != nil {
+			continue
+		}
+		t = t.Add(shift)
+		*entry = block.ascii(stamp.tag, t.Format(exifTimeLayout))
+
+		if zone != nil {
+			local := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, zone)
+			block.exif = setEntry(block.exif, block.ascii(stamp.offsetTag, local.Format("-07:00")))
+		}
+	}
+}
+
+// parseTimeShift parses a --time-shift value: a signed Go duration that may
+// start with a whole number of days, e.g. "+2h", "-45m" or "+1d6h".
+func parseTimeShift(s string) (time.Duration, error) {
+	value := strings.TrimSpace(s)
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(value, "-"):
+		sign, value = -1, value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+
+	var days time.Duration
+	if i := strings.IndexByte(value, 'd'); i >= 0 {
+		n, err := strconv.Atoi(value[:i])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid day count in %q", s)
+		}
+		days, value = time.Duration(n)*24*time.Hour, value[i+1:]
+	}
+	var rest time.Duration
+	if value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest = d
+	}
+	if days == 0 && rest == 0 && value == "" {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return sign * (days + rest), nil
+}
+
+// parseTimezone parses a --set-timezone value: a UTC offset such as "+02:00"
+// or "-0530", "Z"/"UTC", or an IANA zone name such as "Europe/Paris" whose
+// offset is worked out for each timestamp.
+func parseTimezone(s string) (*time.Location, error) {
+	if strings.EqualFold(s, "Z") || strings.EqualFold(s, "UTC") {
+		return time.UTC, nil
+	}
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		for _, layout := range []string{"-07:00", "-0700", "-07"} {
+			if t, err := time.Parse(layout, s); err == nil {
+				_, offset := t.Zone()
+				return time.FixedZone(s, offset), nil
+			}
+		}
+		return nil, fmt.Errorf("invalid UTC offset %q", s)
+	}
+	return time.LoadLocation(s)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+func TestParseTimeShift(t *testing.T) {
+	tests := map[string]time.Duration{
+		"+2h":    2 * time.Hour,
+		"-45m":   -45 * time.Minute,
+		"+1d6h":  30 * time.Hour,
+		"-2d":    -48 * time.Hour,
+		"90s":    90 * time.Second,
+		"+1h30m": 90 * time.Minute,
+	}
+	for input, want := range tests {
+		got, err := parseTimeShift(input)
+		if err != nil || got != want {
+			t.Errorf("parseTimeShift(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "+", "soon", "+-2h", "xd2h"} {
+		if _, err := parseTimeShift(input); err == nil {
+			t.Errorf("parseTimeShift(%q) should fail", input)
+		}
+	}
+}
+
+func TestEditExifTimeShiftAndZone(t *testing.T) {
+	raw := buildTestExif(
+		[]testTag{asciiTag(0x0110, "iPhone 12"), asciiTag(tagDateTime, "2023:03:25 23:30:00")},
+		[]testTag{asciiTag(tagDateTimeOriginal, "2023:03:25 23:30:00"), asciiTag(tagDateTimeDigitized, "2023:03:25 23:30:00")},
+		nil,
+	)
+	zone, err := parseTimezone("Europe/Paris")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	opts := defaultOptions()
+	opts.timeShift = 3 * time.Hour
+	opts.timezone = zone
+	edited, err := editExif(raw, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := decodeExif(edited)
+	// Shifting across the DST change picks up the summer offset.
+	for field, want := range map[exif.FieldName]string{
+		exif.DateTime:          "2023:03:26 02:30:00",
+		exif.DateTimeOriginal:  "2023:03:26 02:30:00",
+		exif.DateTimeDigitized: "2023:03:26 02:30:00",
+		exif.Model:             "iPhone 12",
+	} {
+		if got := exifStringField(x, field); got != want {
+			t.Errorf("%s: got %q, want %q", field, got, want)
+		}
+	}
+	block, err := parseExifBlock(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := findEntry(block.exif, tagOffsetTimeOriginal); entry == nil || string(entry.value) != "+02:00\x00" {
+		t.Errorf("unexpected OffsetTimeOriginal %v", entry)
+	}
+}
+
+func TestParseTimezoneOffsets(t *testing.T) {
+	for input, want := range map[string]int{"+02:00": 7200, "-0530": -19800, "Z": 0, "+09": 32400} {
+		zone, err := parseTimezone(input)
+		if err != nil {
+			t.Errorf("parseTimezone(%q): %v", input, err)
+			continue
+		}
+		if _, offset := time.Date(2023, 1, 1, 0, 0, 0, 0, zone).Zone(); offset != want {
+			t.Errorf("parseTimezone(%q) offset %d, want %d", input, offset, want)
+		}
+	}
+	if _, err := parseTimezone("+25:99"); err == nil {
+		t.Error("expected error for an invalid offset")
+	}
+}
+
+func TestEditExifWithoutExif(t *testing.T) {
+	opts := defaultOptions()
+	opts.timeShift = time.Hour
+	if edited, err := editExif(nil, opts); err != nil || edited != nil {
+		t.Fatalf("got %v, %v", edited, err)
+	}
+}
+
+func TestEditExifAttribution(t *testing.T) {
+	opts := defaultOptions()
+	opts.artist = "Jane Doe"
+	opts.copyright = "© 2024 Jane Doe"
+
+	// Sources without EXIF get a block just for the attribution.
+	edited, err := editExif(nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := decodeExif(edited)
+	if got := exifStringField(x, exif.Artist); got != opts.artist {
+		t.Errorf("Artist = %q", got)
+	}
+	if got := exifStringField(x, exif.Copyright); got != opts.copyright {
+		t.Errorf("Copyright = %q", got)
+	}
+
+	// Existing values are replaced, the rest of the block kept.
+	raw := buildTestExif([]testTag{asciiTag(0x010f, "Apple"), asciiTag(tagArtist, "Someone Else")}, nil, nil)
+	opts.copyright = ""
+	if edited, err = editExif(raw, opts); err != nil {
+		t.Fatal(err)
+	}
+	x = decodeExif(edited)
+	if exifStringField(x, exif.Artist) != "Jane Doe" || exifStringField(x, exif.Make) != "Apple" || exifStringField(x, exif.Copyright) != "" {
+		t.Errorf("got Artist %q, Make %q, Copyright %q", exifStringField(x, exif.Artist), exifStringField(x, exif.Make), exifStringField(x, exif.Copyright))
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What to do when the output directory holds the results of an earlier run
+// (--existing). Without the flag, interactive runs ask and others merge.
+const (
+	existingMerge = "merge" // convert everything, overwriting outputs of the same name
+	existingClean = "clean" // delete the earlier outputs first
+	existingSkip  = "skip"  // leave out sources the earlier run converted
+)
+
+var errAborted = errors.New("aborted")
+
+func isValidExisting(mode string) bool {
+	return mode == "" || mode == existingMerge || mode == existingClean || mode == existingSkip
+}
+
+// previousRun is what the log file of an earlier run says it converted:
+// each source name's output, relative to the output directory, and its log
+// line.
+type previousRun struct {
+	outputs map[string]string
+	lines   map[string]string
+}
+
+// readPreviousRun parses the log file in jpegDir. It returns nil when there
+// is none.
+func readPreviousRun(jpegDir string) *previousRun {
+	f, err := os.Open(filepath.Join(jpegDir, logFileName))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	return parsePreviousRun(f)
+}
+
+// previousActions are the log actions that leave an output behind.
+var previousActions = map[string]bool{"Converted": true, "Copied": true, "Extracted": true, "Transcoded": true}
+
+// parsePreviousRun picks the "NAME SIZE > Converted > jpegs/OUTPUT SIZE"
+// lines (and Copied, Extracted and Transcoded ones) out of a log file.
+func parsePreviousRun(r io.Reader) *previousRun {
+	run := &previousRun{outputs: map[string]string{}, lines: map[string]string{}}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.Split(line, " > ")
+		if len(parts) < 3 || !previousActions[parts[1]] {
+			continue
+		}
+		i := strings.LastIndexByte(parts[0], ' ')
+		if i <= 0 {
+			continue
+		}
+		source, output := parts[0][:i], parts[2]
+		if i := strings.LastIndexByte(output, ' '); i > 0 {
+			output = output[:i]
+		}
+		output = strings.TrimPrefix(output, "jpegs/")
+		if output == "" {
+			continue
+		}
+		run.outputs[source] = filepath.FromSlash(output)
+		run.lines[source] = line
+	}
+	return run
+}
+
+// handleExistingOutput applies --existing when jpegDir holds an earlier
+// run's log file, asking on in when the mode is not set and the run is
+// interactive. It returns the files still to convert and, for skip, the log
+// lines of the sources left out, to carry over into the new log.
+func handleExistingOutput(jpegDir string, files []os.DirEntry, opts options, in io.Reader, out io.Writer) ([]os.DirEntry, map[string]string, error) {
+	previous, err := prepareExistingOutput(jpegDir, opts, in, out)
+	if err != nil {
+		return nil, nil, err
+	}
+	if previous == nil {
+		return files, nil, nil
+	}
+	kept := make([]os.DirEntry, 0, len(files))
+	carried := map[string]string{}
+	for _, file := range files {
+		if line, ok := previous.converted(jpegDir, file.Name()); ok {
+			carried[file.Name()] = line
+			opts.skipped.add(file.Name(), skipAlreadyConverted, "")
+			continue
+		}
+		kept = append(kept, file)
+	}
+	fmt.Fprintf(out, "Skipping %d files converted by the previous run\n", len(carried))
+	return kept, carried, nil
+}
+
+// prepareExistingOutput settles --existing like handleExistingOutput, for
+// inputs that are not listed up front. It returns the earlier run when the
+// sources it converted are to be skipped, and nil otherwise.
+func prepareExistingOutput(jpegDir string, opts options, in io.Reader, out io.Writer) (*previousRun, error) {
+	previous := readPreviousRun(jpegDir)
+	if previous == nil {
+		return nil, nil
+	}
+
+	mode := opts.existing
+	if mode == "" {
+		fmt.Fprintf(out, "%s already holds the output of a previous run (%d files).\n", jpegDir, len(previous.outputs))
+		if !opts.interactive || in == nil {
+			fmt.Fprintln(out, "Merging with it; use --existing merge, clean or skip to choose.")
+			return nil, nil
+		}
+		var err error
+		if mode, err = askExisting(in, out); err != nil {
+			return nil, err
+		}
+	}
+
+	switch mode {
+	case existingClean:
+		// The log may have been edited or damaged, so nothing is removed
+		// unless every output it lists is inside jpegDir.
+		for _, output := range previous.outputs {
+			if !insideDir(jpegDir, output) {
+				return nil, fmt.Errorf("%s lists %s, which is outside %s; not removing anything", logFileName, output, jpegDir)
+			}
+		}
+		removed := 0
+		for _, output := range previous.outputs {
+			path := filepath.Join(jpegDir, output)
+			if err := os.Remove(path); err == nil {
+				removed++
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+			os.Remove(sidecarPath(path))
+		}
+		os.Remove(filepath.Join(jpegDir, logFileName))
+		fmt.Fprintf(out, "Removed %d files from the previous run\n", removed)
+	case existingSkip:
+		return previous, nil
+	}
+	return nil, nil
+}
+
+// converted returns the log line of the source name when the earlier run
+// converted it and its output is still there.
+func (run *previousRun) converted(jpegDir, name string) (string, bool) {
+	output, ok := run.outputs[name]
+	if !ok || !insideDir(jpegDir, output) {
+		return "", false
+	}
+	if _, err := os.Stat(filepath.Join(jpegDir, output)); err != nil {
+		return "", false
+	}
+	return run.lines[name], true
+}
+
+// insideDir reports whether the relative path output stays inside dir.
+func insideDir(dir, output string) bool {
+	if filepath.IsAbs(output) || filepath.VolumeName(output) != "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, filepath.Join(dir, output))
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// askExisting prompts for --existing until it gets an answer.
+func askExisting(in io.Reader, out io.Writer) (string, error) {
+	reader := bufio.NewReader(in)
+	for {
+		fmt.Fprint(out, "[m]erge, [c]lean up the old files first, [s]kip files already converted, or [a]bort? ")
+		answer, err := reader.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "m", "merge":
+			return existingMerge, nil
+		case "c", "clean":
+			return existingClean, nil
+		case "s", "skip":
+			return existingSkip, nil
+		case "a", "abort":
+			return "", errAborted
+		}
+		if err != nil {
+			return "", errAborted
+		}
+	}
+}
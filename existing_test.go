@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPreviousLog = `{"version":"v1.0.0"}
+IMG 0001.heic 2.1MB > Converted > jpegs/IMG 0001.jpg 1.4MB
+IMG_0002.heic 1.9MB > Copied > jpegs/IMG_0002.heic 1.9MB
+IMG_0003.heic 0B > Empty file
+IMG_0004.heic 2.0MB > Converted > jpegs/Japan/Kyoto/IMG_0004.jpg 1.2MB > Unchanged on remote
+IMG_0006.heic 900.0KB > Extracted > jpegs/IMG_0006.jpg 910.0KB
+
+3 Files
+`
+
+func TestParsePreviousRun(t *testing.T) {
+	run := parsePreviousRun(strings.NewReader(testPreviousLog))
+	want := map[string]string{
+		"IMG 0001.heic": "IMG 0001.jpg",
+		"IMG_0002.heic": "IMG_0002.heic",
+		"IMG_0004.heic": filepath.Join("Japan", "Kyoto", "IMG_0004.jpg"),
+		"IMG_0006.heic": "IMG_0006.jpg",
+	}
+	if len(run.outputs) != len(want) {
+		t.Fatalf("got %v", run.outputs)
+	}
+	for source, output := range want {
+		if run.outputs[source] != output {
+			t.Errorf("%s: got %q, want %q", source, run.outputs[source], output)
+		}
+	}
+}
+
+// setupPreviousRun creates an output directory holding a converted file
+// and its log, and the sources of the next run.
+func setupPreviousRun(t *testing.T) (string, []os.DirEntry) {
+	jpegDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(jpegDir, logFileName), []byte(testPreviousLog), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jpegDir, "IMG 0001.jpg"), []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jpegDir, "IMG 0001.jpg.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jpegDir, "unrelated.jpg"), []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := []os.DirEntry{&mockDirEntry{"IMG 0001.heic"}, &mockDirEntry{"IMG_0004.heic"}, &mockDirEntry{"IMG_0005.heic"}}
+	return jpegDir, files
+}
+
+func TestHandleExistingOutput(t *testing.T) {
+	jpegDir, files := setupPreviousRun(t)
+	opts := defaultOptions()
+	opts.existing = existingSkip
+	kept, carried, err := handleExistingOutput(jpegDir, files, opts, nil, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IMG_0004's output is gone, so it is converted again.
+	if len(kept) != 2 || kept[0].Name() != "IMG_0004.heic" || kept[1].Name() != "IMG_0005.heic" {
+		t.Errorf("skip kept %v", kept)
+	}
+	if !strings.HasSuffix(carried["IMG 0001.heic"], "Converted > jpegs/IMG 0001.jpg 1.4MB") {
+		t.Errorf("skip carried %v", carried)
+	}
+
+	opts.existing = existingClean
+	kept, _, err = handleExistingOutput(jpegDir, files, opts, nil, io.Discard)
+	if err != nil || len(kept) != len(files) {
+		t.Fatalf("clean: %v, %d files", err, len(kept))
+	}
+	for _, name := range []string{"IMG 0001.jpg", "IMG 0001.jpg.json", logFileName} {
+		if _, err := os.Stat(filepath.Join(jpegDir, name)); !os.IsNotExist(err) {
+			t.Errorf("clean left %s", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(jpegDir, "unrelated.jpg")); err != nil {
+		t.Error("clean removed a file the previous run did not write")
+	}
+}
+
+func TestExistingCleanStaysInside(t *testing.T) {
+	parent := t.TempDir()
+	jpegDir := filepath.Join(parent, "jpegs")
+	if err := os.Mkdir(jpegDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(parent, "keep.jpg")
+	for _, path := range []string{outside, filepath.Join(jpegDir, "IMG_0001.jpg")} {
+		if err := os.WriteFile(path, []byte("jpeg"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, output := range []string{"../keep.jpg", outside, "."} {
+		log := "IMG_0001.heic 1.0MB > Converted > jpegs/IMG_0001.jpg 1.4MB\n" +
+			"IMG_0002.heic 1.0MB > Converted > " + output + " 1.4MB\n"
+		if err := os.WriteFile(filepath.Join(jpegDir, logFileName), []byte(log), 0644); err != nil {
+			t.Fatal(err)
+		}
+		opts := defaultOptions()
+		opts.existing = existingClean
+		if _, _, err := handleExistingOutput(jpegDir, nil, opts, nil, io.Discard); err == nil || !strings.Contains(err.Error(), "outside") {
+			t.Errorf("%s: got %v", output, err)
+		}
+		for _, path := range []string{outside, filepath.Join(jpegDir, "IMG_0001.jpg")} {
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("%s: %s removed", output, path)
+			}
+		}
+	}
+}
+
+func TestHandleExistingOutputDefault(t *testing.T) {
+	jpegDir, files := setupPreviousRun(t)
+	opts := defaultOptions()
+	opts.interactive = false
+	var out bytes.Buffer
+	if kept, _, err := handleExistingOutput(jpegDir, files, opts, nil, &out); err != nil || len(kept) != len(files) {
+		t.Errorf("non-interactive runs should merge: %v, %d files", err, len(kept))
+	}
+	if !strings.Contains(out.String(), "previous run") {
+		t.Errorf("no warning printed: %q", out.String())
+	}
+
+	opts.interactive = true
+	kept, _, err := handleExistingOutput(jpegDir, files, opts, strings.NewReader("x\ns\n"), io.Discard)
+	if err != nil || len(kept) != 2 {
+		t.Errorf("answering s should skip: %v, %d files", err, len(kept))
+	}
+	if _, _, err := handleExistingOutput(jpegDir, files, opts, strings.NewReader(""), io.Discard); err != errAborted {
+		t.Errorf("got %v at the end of input, want errAborted", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// filtersSources reports whether any filter that needs the file headers is
+// enabled.
+func filtersSources(opts options) bool {
+	return opts.skipScreenshots || opts.onlyScreenshots || opts.minWidth > 0 || opts.minHeight > 0 ||
+		opts.minMegapixels > 0 || opts.maxMegapixels > 0 || opts.minRating > 0 || opts.favoritesOnly || opts.airDrop
+}
+
+// skipReason returns why a source should be left out of the batch, as a
+// skip constant for the logs and in words for the terminal, or "" to keep
+// it. Sizes that could not be read never exclude a file.
+func skipReason(info sourceInfo, opts options) (code, reason string) {
+	if opts.skipScreenshots || opts.onlyScreenshots {
+		if isScreenshot(info.exif, info.width, info.height) == opts.skipScreenshots {
+			if opts.skipScreenshots {
+				return skipScreenshot, "screenshot"
+			}
+			return skipNotScreenshot, "not a screenshot"
+		}
+	}
+	if info.width > 0 && (info.width < opts.minWidth || info.height < opts.minHeight) {
+		return skipTooSmall, "too small"
+	}
+	if info.width > 0 {
+		megapixels := float64(info.width*info.height) / 1e6
+		if megapixels < opts.minMegapixels {
+			return skipTooSmall, fmt.Sprintf("below %g MP", opts.minMegapixels)
+		}
+		if opts.maxMegapixels > 0 && megapixels > opts.maxMegapixels {
+			return skipTooLarge, fmt.Sprintf("above %g MP", opts.maxMegapixels)
+		}
+	}
+	if opts.minRating > 0 || opts.favoritesOnly {
+		rating := sourceRating(info.exif, info.xmp)
+		if rating < opts.minRating {
+			return skipLowRating, fmt.Sprintf("rated below %d", opts.minRating)
+		}
+		if opts.favoritesOnly && !info.favorite && rating < maxRating {
+			return skipNotFavorite, "not a favourite"
+		}
+	}
+	return "", ""
+}
+
+// filterSources drops HEIC files excluded by --skip-screenshots,
+// --only-screenshots, --min-width, --min-height, --min-megapixels,
+// --max-megapixels, --min-rating, --favorites-only and --airdrop. Only the header boxes of each file are read. Other files are kept; they are ignored later anyway.
+func filterSources(currentDir string, files []os.DirEntry, opts options) []os.DirEntry {
+	if !filtersSources(opts) {
+		return files
+	}
+	filter := newSourceFilter(currentDir, opts)
+	kept := make([]os.DirEntry, 0, len(files))
+	for _, file := range files {
+		if filter.keep(file) {
+			kept = append(kept, file)
+		}
+	}
+	filter.report()
+	return kept
+}
+
+// sourceFilter applies the source filters one file at a time, counting the
+// files it skips by reason.
+type sourceFilter struct {
+	currentDir string
+	opts       options
+	skipped    map[string]int
+}
+
+func newSourceFilter(currentDir string, opts options) *sourceFilter {
+	return &sourceFilter{currentDir: currentDir, opts: opts, skipped: map[string]int{}}
+}
+
+func (f *sourceFilter) keep(file os.DirEntry) bool {
+	if !isHEICFile(file.Name()) {
+		return true
+	}
+	path := filepath.Join(f.currentDir, file.Name())
+	if f.opts.airDrop && !isAirDropped(path) {
+		f.skipped["not from AirDrop"]++
+		f.opts.skipped.add(file.Name(), skipExcluded, "not from AirDrop")
+		return false
+	}
+	// Reading the headers of an offloaded file would download it.
+	if skipsOffloaded(path, f.opts) {
+		f.skipped["not downloaded locally"]++
+		f.opts.skipped.add(file.Name(), skipNotDownloaded, errNotDownloaded.Error())
+		return false
+	}
+	// Unreadable files are kept so the conversion reports them.
+	info, err := probeSource(path)
+	if f.opts.library != nil {
+		info.favorite = f.opts.library.isFavorite(file.Name())
+	}
+	if code, reason := skipReason(info, f.opts); err == nil && code != "" {
+		f.skipped[reason]++
+		f.opts.skipped.add(file.Name(), code, reason)
+		return false
+	}
+	return true
+}
+
+// report prints how many files were skipped and why.
+func (f *sourceFilter) report() {
+	if len(f.skipped) == 0 {
+		return
+	}
+	reasons := make([]string, 0, len(f.skipped))
+	for reason, n := range f.skipped {
+		reasons = append(reasons, fmt.Sprintf("%d %s", n, reason))
+	}
+	sort.Strings(reasons)
+	fmt.Printf("Skipping files: %s\n", strings.Join(reasons, ", "))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cityData is a coarse list of major cities and travel destinations used for
+// offline reverse geocoding. Coordinates are rounded to two decimals.
+//
+//go:embed geodata/cities.csv
+var cityData string
+
+const (
+	unknownLocation = "Unknown Location"
+
+	// Beyond cityRadiusKm the nearest city is too far away to name, so only
+	// its country is used. Beyond countryRadiusKm (open ocean, polar regions)
+	// the location is reported as unknown.
+	cityRadiusKm    = 150
+	countryRadiusKm = 1000
+	earthRadiusKm   = 6371
+)
+
+type city struct {
+	country string
+	name    string
+	lat     float64
+	lon     float64
+}
+
+var (
+	citiesOnce sync.Once
+	cities     []city
+)
+
+func loadCities() []city {
+	citiesOnce.Do(func() {
+		records, err := csv.NewReader(strings.NewReader(cityData)).ReadAll()
+		if err != nil {
+			panic("geodata/cities.csv: " + err.Error())
+		}
+		for _, record := range records[1:] {
+			lat, errLat := strconv.ParseFloat(record[2], 64)
+			lon, errLon := strconv.ParseFloat(record[3], 64)
+			if errLat != nil || errLon != nil {
+				continue
+			}
+			cities = append(cities, city{country: record[0], name: record[1], lat: lat, lon: lon})
+		}
+	})
+	return cities
+}
+
+// distanceKm returns the great-circle distance between two coordinates.
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// reverseGeocode returns the country and city nearest to a coordinate. The
+// city is empty when no bundled city is close enough, and both are empty when
+// even the nearest country is implausibly far away.
+func reverseGeocode(lat, lon float64) (country, cityName string) {
+	best, bestDistance := city{}, math.Inf(1)
+	for _, c := range loadCities() {
+		if d := distanceKm(lat, lon, c.lat, c.lon); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+
+	switch {
+	case bestDistance > countryRadiusKm:
+		return "", ""
+	case bestDistance > cityRadiusKm:
+		return best.country, ""
+	default:
+		return best.country, best.name
+	}
+}
+
+// locationFolder returns the output subfolder for a photo taken at the given
+// coordinates, e.g. "Japan/Kyoto".
+func locationFolder(lat, lon float64, ok bool) string {
+	if !ok {
+		return unknownLocation
+	}
+	country, cityName := reverseGeocode(lat, lon)
+	switch {
+	case country == "":
+		return unknownLocation
+	case cityName == "":
+		return country
+	default:
+		return country + "/" + cityName
+	}
+}
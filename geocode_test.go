@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestLocationFolder(t *testing.T) {
+	tests := []struct {
+		name     string
+		lat, lon float64
+		ok       bool
+		want     string
+	}{
+		{"kyoto", 35.0116, 135.7681, true, "Japan/Kyoto"},
+		{"lisbon suburbs", 38.70, -9.30, true, "Portugal/Lisbon"},
+		{"rural nevada", 39.5, -117.0, true, "United States"},
+		{"south pacific", -45.0, -130.0, true, unknownLocation},
+		{"no gps", 0, 0, false, unknownLocation},
+	}
+	for _, tt := range tests {
+		if got := locationFolder(tt.lat, tt.lon, tt.ok); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
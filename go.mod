@@ -1,4 +1,4 @@
-module heictojpeg
+module github.com/iancleary/heictojpeg
 
 go 1.19
 
@@ -7,11 +7,11 @@ require github.com/adrium/goheif v0.0.0-20230113233934-ca402e77a786
 require (
 	github.com/akavel/rsrc v0.10.2 // indirect
 	github.com/lxn/win v0.0.0-20210218163916-a377121e959e // indirect
-	golang.org/x/sys v0.12.0 // indirect
 	gopkg.in/Knetic/govaluate.v3 v3.0.0 // indirect
 )
 
 require (
 	github.com/lxn/walk v0.0.0-20210112085537-c389da54e794
-	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd // indirect
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
+	golang.org/x/sys v0.12.0
 )
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// runGUI is only implemented for Windows, where the walk toolkit is available.
+func runGUI(opts options) error {
+	return errors.New("the GUI is only available in Windows builds; run without --gui to use the command line")
+}
@@ -0,0 +1,113 @@
+//go:build windows
+
+//go:generate go run github.com/akavel/rsrc -manifest heictojpeg.manifest -o rsrc_windows.syso
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+// runGUI shows a small window with a folder picker, a quality slider and a
+// progress bar. Conversions run through the same code path as the CLI.
+func runGUI(opts options) error {
+	var (
+		mw            *walk.MainWindow
+		folderEdit    *walk.LineEdit
+		qualitySlider *walk.Slider
+		qualityLabel  *walk.Label
+		progressBar   *walk.ProgressBar
+		statusLabel   *walk.Label
+		convertButton *walk.PushButton
+	)
+
+	folder, _ := filepath.Abs(opts.inputPath)
+
+	convert := func() {
+		runOpts := opts
+		runOpts.inputPath = folderEdit.Text()
+		runOpts.quality = qualitySlider.Value()
+		runOpts.progress = func(done, total int) {
+			mw.Synchronize(func() {
+				progressBar.SetRange(0, total)
+				progressBar.SetValue(done)
+				statusLabel.SetText(fmt.Sprintf("Converted %d of %d files", done, total))
+			})
+		}
+
+		convertButton.SetEnabled(false)
+		progressBar.SetValue(0)
+		statusLabel.SetText("Converting…")
+
+		go func() {
+			jpegDir, err := run(runOpts)
+			mw.Synchronize(func() {
+				convertButton.SetEnabled(true)
+				if err != nil {
+					statusLabel.SetText("Conversion failed")
+					walk.MsgBox(mw, "HEIC to JPEG", err.Error(), walk.MsgBoxIconError)
+					return
+				}
+				statusLabel.SetText("Done. JPEGs saved to " + jpegDir)
+			})
+		}()
+	}
+
+	err := MainWindow{
+		AssignTo: &mw,
+		Title:    "HEIC to JPEG",
+		MinSize:  Size{Width: 480, Height: 220},
+		Layout:   VBox{},
+		Children: []Widget{
+			Label{Text: "Folder with HEIC photos:"},
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					LineEdit{AssignTo: &folderEdit, Text: folder, ReadOnly: true},
+					PushButton{
+						Text: "Browse…",
+						OnClicked: func() {
+							dlg := &walk.FileDialog{
+								Title:    "Choose a folder with HEIC photos",
+								FilePath: folderEdit.Text(),
+							}
+							if ok, err := dlg.ShowBrowseFolder(mw); err == nil && ok {
+								folderEdit.SetText(dlg.FilePath)
+							}
+						},
+					},
+				},
+			},
+			Composite{
+				Layout: HBox{MarginsZero: true},
+				Children: []Widget{
+					Label{Text: "Quality:"},
+					Slider{
+						AssignTo: &qualitySlider,
+						MinValue: 1,
+						MaxValue: 100,
+						Value:    opts.quality,
+						OnValueChanged: func() {
+							qualityLabel.SetText(strconv.Itoa(qualitySlider.Value()))
+						},
+					},
+					Label{AssignTo: &qualityLabel, Text: strconv.Itoa(opts.quality), MinSize: Size{Width: 30}},
+				},
+			},
+			ProgressBar{AssignTo: &progressBar},
+			Label{AssignTo: &statusLabel, Text: "Ready"},
+			PushButton{AssignTo: &convertButton, Text: "Convert", OnClicked: convert},
+		},
+	}.Create()
+	if err != nil {
+		return err
+	}
+
+	mw.Run()
+	return nil
+}
@@ -0,0 +1,52 @@
+package heic
+
+import (
+	"image"
+	"io"
+
+	"github.com/adrium/goheif/heif"
+)
+
+// hevcBitDepth returns the luma bit depth of an HEVC-coded item from its
+// hvcC box, 8 when it has none.
+func hevcBitDepth(item *heif.Item) int {
+	for _, prop := range item.Properties {
+		if !prop.Type().EqualString("hvcC") {
+			continue
+		}
+		body, err := io.ReadAll(prop.Body())
+		if err != nil || len(body) < 19 {
+			break
+		}
+		return 8 + int(body[17]&7)
+	}
+	return 8
+}
+
+// narrowSamples converts an image of more than 8 bits per sample, as
+// libde265 returns it in 16-bit little-endian samples behind 8-bit image
+// planes, to 8 bits, rounding to the nearest value.
+func narrowSamples(src *image.YCbCr, depth int) *image.YCbCr {
+	dst := image.NewYCbCr(src.Rect, src.SubsampleRatio)
+	shift := uint(depth - 8)
+	narrow := func(dst []byte, dstStride int, src []byte, srcStride int) {
+		if dstStride == 0 {
+			return
+		}
+		for y := 0; y < len(dst)/dstStride && y*srcStride < len(src); y++ {
+			row := src[y*srcStride:]
+			for x := 0; x < dstStride && 2*x+1 < len(row); x++ {
+				sample := uint32(row[2*x]) | uint32(row[2*x+1])<<8
+				v := (sample + 1<<shift>>1) >> shift
+				if v > 255 {
+					v = 255
+				}
+				dst[y*dstStride+x] = uint8(v)
+			}
+		}
+	}
+	narrow(dst.Y, dst.YStride, src.Y, src.YStride)
+	narrow(dst.Cb, dst.CStride, src.Cb, src.CStride)
+	narrow(dst.Cr, dst.CStride, src.Cr, src.CStride)
+	return dst
+}
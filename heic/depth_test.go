@@ -0,0 +1,37 @@
+package heic
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestNarrowSamples(t *testing.T) {
+	// A 2x2 4:4:4 image of 10-bit samples, two bytes each, little-endian.
+	plane := func(samples ...uint16) []byte {
+		var b []byte
+		for _, s := range samples {
+			b = append(b, byte(s), byte(s>>8))
+		}
+		return b
+	}
+	src := &image.YCbCr{
+		Y:              plane(0, 1023, 512, 2),
+		Cb:             plane(512, 512, 512, 512),
+		Cr:             plane(0, 4, 8, 1020),
+		YStride:        4,
+		CStride:        4,
+		SubsampleRatio: image.YCbCrSubsampleRatio444,
+		Rect:           image.Rect(0, 0, 2, 2),
+	}
+	got := narrowSamples(src, 10)
+	if want := []byte{0, 255, 128, 1}; !bytes.Equal(got.Y, want) {
+		t.Errorf("Y: got %v, want %v", got.Y, want)
+	}
+	if want := []byte{128, 128, 128, 128}; !bytes.Equal(got.Cb, want) {
+		t.Errorf("Cb: got %v, want %v", got.Cb, want)
+	}
+	if want := []byte{0, 1, 2, 255}; !bytes.Equal(got.Cr, want) {
+		t.Errorf("Cr: got %v, want %v", got.Cr, want)
+	}
+}
@@ -0,0 +1,265 @@
+package heic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+
+	"github.com/adrium/goheif/heif"
+)
+
+// maxDerivationDepth bounds how deeply derived images may reference other
+// derived images (an overlay of grids, say), so a malformed file cannot make
+// decoding recurse without end.
+const maxDerivationDepth = 4
+
+// codedDecoder decodes a coded (hvc1) item.
+type codedDecoder func(item *heif.Item) (image.Image, error)
+
+// decodeItem decodes an item of a HEIF file, reconstructing derived images:
+// grids ("grid"), whose tiles are laid out row by row and cropped to the
+// output size, and overlays ("iovl"), whose images are drawn at offsets on a
+// filled canvas. HEVC items are passed to decodeCoded; JPEG-coded ones are
+// decoded directly.
+func decodeItem(hf *heif.File, item *heif.Item, decodeCoded codedDecoder, depth int) (image.Image, error) {
+	if item.Info == nil {
+		return nil, fmt.Errorf("item %d has no item info", item.ID)
+	}
+	if item.Info.ItemType == "hvc1" {
+		return decodeCoded(item)
+	}
+	if item.Info.ItemType == JPEGItemType {
+		data, err := hf.GetItemData(item)
+		if err != nil {
+			return nil, err
+		}
+		return jpeg.Decode(bytes.NewReader(data))
+	}
+	if item.Info.ItemType != "grid" && item.Info.ItemType != "iovl" {
+		return nil, fmt.Errorf("unsupported item type %q", item.Info.ItemType)
+	}
+	if depth >= maxDerivationDepth {
+		return nil, fmt.Errorf("derived images nested too deeply")
+	}
+
+	data, err := hf.GetItemData(item)
+	if err != nil {
+		return nil, err
+	}
+	dimg := item.Reference("dimg")
+	if dimg == nil || len(dimg.ToItemIDs) == 0 {
+		return nil, fmt.Errorf("%s item %d references no images", item.Info.ItemType, item.ID)
+	}
+	inputs := make([]image.Image, len(dimg.ToItemIDs))
+	for i, id := range dimg.ToItemIDs {
+		input, err := hf.ItemByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if inputs[i], err = decodeItem(hf, input, decodeCoded, depth+1); err != nil {
+			return nil, err
+		}
+	}
+
+	if item.Info.ItemType == "grid" {
+		layout, err := parseGrid(data)
+		if err != nil {
+			return nil, err
+		}
+		return assembleGrid(inputs, layout)
+	}
+	layout, err := parseOverlay(data, len(inputs))
+	if err != nil {
+		return nil, err
+	}
+	return composeOverlay(inputs, layout), nil
+}
+
+// gridLayout is the content of a grid item: tiles in rows*columns, cropped
+// to width x height.
+type gridLayout struct {
+	rows, columns int
+	width, height int
+}
+
+func parseGrid(data []byte) (gridLayout, error) {
+	if len(data) < 4 {
+		return gridLayout{}, fmt.Errorf("grid data too short")
+	}
+	layout := gridLayout{rows: int(data[2]) + 1, columns: int(data[3]) + 1}
+	sizes, ok := readFields(data[4:], data[1]&1 != 0, 2)
+	if !ok {
+		return gridLayout{}, fmt.Errorf("grid data too short")
+	}
+	layout.width, layout.height = int(sizes[0]), int(sizes[1])
+	if layout.width <= 0 || layout.height <= 0 {
+		return gridLayout{}, fmt.Errorf("invalid grid size %dx%d", layout.width, layout.height)
+	}
+	return layout, nil
+}
+
+// overlayLayout is the content of an iovl item: a canvas of width x height
+// filled with fill, with each input drawn at its offset.
+type overlayLayout struct {
+	fill          color.NRGBA64
+	width, height int
+	offsets       []image.Point
+}
+
+func parseOverlay(data []byte, inputs int) (overlayLayout, error) {
+	if len(data) < 10 {
+		return overlayLayout{}, fmt.Errorf("overlay data too short")
+	}
+	var layout overlayLayout
+	layout.fill = color.NRGBA64{
+		R: binary.BigEndian.Uint16(data[2:]),
+		G: binary.BigEndian.Uint16(data[4:]),
+		B: binary.BigEndian.Uint16(data[6:]),
+		A: binary.BigEndian.Uint16(data[8:]),
+	}
+	fields, ok := readFields(data[10:], data[1]&1 != 0, 2+2*inputs)
+	if !ok {
+		return overlayLayout{}, fmt.Errorf("overlay data too short for %d images", inputs)
+	}
+	layout.width, layout.height = int(fields[0]), int(fields[1])
+	if layout.width <= 0 || layout.height <= 0 {
+		return overlayLayout{}, fmt.Errorf("invalid overlay size %dx%d", layout.width, layout.height)
+	}
+	for i := 0; i < inputs; i++ {
+		// Offsets are signed.
+		x, y := fields[2+2*i], fields[3+2*i]
+		if data[1]&1 != 0 {
+			layout.offsets = append(layout.offsets, image.Pt(int(int32(x)), int(int32(y))))
+		} else {
+			layout.offsets = append(layout.offsets, image.Pt(int(int16(x)), int(int16(y))))
+		}
+	}
+	return layout, nil
+}
+
+// readFields reads n big-endian fields of 16 bits, or 32 with wide.
+func readFields(data []byte, wide bool, n int) ([]uint32, bool) {
+	size := 2
+	if wide {
+		size = 4
+	}
+	if len(data) < n*size {
+		return nil, false
+	}
+	fields := make([]uint32, n)
+	for i := range fields {
+		if wide {
+			fields[i] = binary.BigEndian.Uint32(data[i*size:])
+		} else {
+			fields[i] = uint32(binary.BigEndian.Uint16(data[i*size:]))
+		}
+	}
+	return fields, true
+}
+
+// assembleGrid lays out tiles row by row, each placed after the full size of
+// the tiles before it, and crops the result to the grid's size. All tiles
+// must have the size of the first; edge tiles usually extend past the output
+// and are cut off.
+func assembleGrid(tiles []image.Image, layout gridLayout) (image.Image, error) {
+	if len(tiles) != layout.rows*layout.columns {
+		return nil, fmt.Errorf("grid of %dx%d tiles has %d images", layout.columns, layout.rows, len(tiles))
+	}
+	tileSize := tiles[0].Bounds().Size()
+	for _, tile := range tiles {
+		if tile.Bounds().Size() != tileSize {
+			return nil, fmt.Errorf("grid tiles differ in size: %v and %v", tileSize, tile.Bounds().Size())
+		}
+	}
+	if tileSize.X*layout.columns < layout.width || tileSize.Y*layout.rows < layout.height {
+		return nil, fmt.Errorf("%dx%d tiles of %v do not cover %dx%d", layout.columns, layout.rows, tileSize, layout.width, layout.height)
+	}
+
+	bounds := image.Rect(0, 0, layout.width, layout.height)
+	at := func(i int) image.Point {
+		return image.Pt(i%layout.columns*tileSize.X, i/layout.columns*tileSize.Y)
+	}
+
+	// Tiles decoded by libde265 share a chroma subsampling; copy their
+	// planes directly when the tile size keeps chroma samples aligned.
+	if first, ok := tiles[0].(*image.YCbCr); ok {
+		h, v := subsampleFactors(first.SubsampleRatio)
+		aligned := tileSize.X%h == 0 && tileSize.Y%v == 0
+		for _, tile := range tiles {
+			ycc, ok := tile.(*image.YCbCr)
+			aligned = aligned && ok && ycc.SubsampleRatio == first.SubsampleRatio &&
+				ycc.Rect.Min.X%h == 0 && ycc.Rect.Min.Y%v == 0
+		}
+		if aligned {
+			out := image.NewYCbCr(bounds, first.SubsampleRatio)
+			for i, tile := range tiles {
+				copyYCbCr(out, tile.(*image.YCbCr), at(i))
+			}
+			return out, nil
+		}
+	}
+
+	out := image.NewRGBA(bounds)
+	for i, tile := range tiles {
+		r := tile.Bounds()
+		draw.Draw(out, r.Sub(r.Min).Add(at(i)), tile, r.Min, draw.Src)
+	}
+	return out, nil
+}
+
+// subsampleFactors returns the horizontal and vertical chroma subsampling of
+// a ratio.
+func subsampleFactors(ratio image.YCbCrSubsampleRatio) (h, v int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	}
+	return 1, 1
+}
+
+// copyYCbCr copies src into dst with its top-left corner at at, clipped to
+// dst. Both share a subsample ratio and at is aligned to it. Rows are copied
+// by width, not stride, since decoders pad their planes.
+func copyYCbCr(dst, src *image.YCbCr, at image.Point) {
+	offset := src.Rect.Min.Sub(at)
+	r := src.Rect.Sub(offset).Intersect(dst.Rect)
+	if r.Empty() {
+		return
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		d := dst.YOffset(r.Min.X, y)
+		copy(dst.Y[d:d+r.Dx()], src.Y[src.YOffset(r.Min.X+offset.X, y+offset.Y):])
+	}
+
+	_, v := subsampleFactors(src.SubsampleRatio)
+	for y := r.Min.Y; y < r.Max.Y; y += v {
+		d, dEnd := dst.COffset(r.Min.X, y), dst.COffset(r.Max.X-1, y)+1
+		s := src.COffset(r.Min.X+offset.X, y+offset.Y)
+		copy(dst.Cb[d:dEnd], src.Cb[s:])
+		copy(dst.Cr[d:dEnd], src.Cr[s:])
+	}
+}
+
+// composeOverlay draws the inputs over a filled canvas in order, so later
+// images cover earlier ones.
+func composeOverlay(inputs []image.Image, layout overlayLayout) *image.RGBA {
+	out := image.NewRGBA(image.Rect(0, 0, layout.width, layout.height))
+	draw.Draw(out, out.Bounds(), image.NewUniform(layout.fill), image.Point{}, draw.Src)
+	for i, input := range inputs {
+		r := input.Bounds()
+		draw.Draw(out, r.Sub(r.Min).Add(layout.offsets[i]), input, r.Min, draw.Over)
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package heic
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// paddedTile returns a YCbCr tile of the given size whose planes are wider
+// than the image, like decoder output, with luma set to value.
+func paddedTile(width, height int, value uint8) *image.YCbCr {
+	full := image.NewYCbCr(image.Rect(0, 0, width+16, height), image.YCbCrSubsampleRatio420)
+	for i := range full.Y {
+		full.Y[i] = value
+	}
+	for i := range full.Cb {
+		full.Cb[i], full.Cr[i] = value/2, 255-value
+	}
+	return full.SubImage(image.Rect(0, 0, width, height)).(*image.YCbCr)
+}
+
+func TestParseGrid(t *testing.T) {
+	layout, err := parseGrid([]byte{0, 0, 1, 2, 0x0c, 0x00, 0x06, 0x00})
+	if err != nil || layout != (gridLayout{rows: 2, columns: 3, width: 3072, height: 1536}) {
+		t.Errorf("16-bit sizes: got %+v, %v", layout, err)
+	}
+	layout, err = parseGrid([]byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 0, 0x80, 0})
+	if err != nil || layout.width != 65536 || layout.height != 32768 {
+		t.Errorf("32-bit sizes: got %+v, %v", layout, err)
+	}
+	if _, err := parseGrid([]byte{0, 1, 0, 0, 0, 1}); err == nil {
+		t.Error("truncated grid accepted")
+	}
+}
+
+func TestParseOverlay(t *testing.T) {
+	data := []byte{
+		0, 0,
+		0xff, 0xff, 0, 0, 0, 0, 0xff, 0xff, // opaque red
+		0, 100, 0, 50, // 100x50
+		0, 10, 0xff, 0xfe, // (10, -2)
+		0, 0, 0, 0,
+	}
+	layout, err := parseOverlay(data, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.width != 100 || layout.height != 50 || layout.fill != (color.NRGBA64{R: 0xffff, A: 0xffff}) {
+		t.Errorf("got %+v", layout)
+	}
+	if layout.offsets[0] != image.Pt(10, -2) || layout.offsets[1] != image.Pt(0, 0) {
+		t.Errorf("offsets %v", layout.offsets)
+	}
+	if _, err := parseOverlay(data, 3); err == nil {
+		t.Error("overlay with too few offsets accepted")
+	}
+}
+
+func TestAssembleGrid(t *testing.T) {
+	// Three columns and two rows of 64x32 tiles cropped to 150x50, with the
+	// tiles numbered in their luma.
+	var tiles []image.Image
+	for i := 0; i < 6; i++ {
+		tiles = append(tiles, paddedTile(64, 32, uint8(10*(i+1))))
+	}
+	img, err := assembleGrid(tiles, gridLayout{rows: 2, columns: 3, width: 150, height: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycc, ok := img.(*image.YCbCr)
+	if !ok || ycc.Bounds() != image.Rect(0, 0, 150, 50) {
+		t.Fatalf("got %T %v", img, img.Bounds())
+	}
+	for _, p := range []struct {
+		x, y int
+		luma uint8
+	}{{0, 0, 10}, {63, 31, 10}, {64, 0, 20}, {149, 0, 30}, {0, 32, 40}, {100, 49, 50}, {149, 49, 60}} {
+		c := ycc.YCbCrAt(p.x, p.y)
+		if c.Y != p.luma || c.Cb != p.luma/2 || c.Cr != 255-p.luma {
+			t.Errorf("(%d,%d) = %v, want tile with luma %d", p.x, p.y, c, p.luma)
+		}
+	}
+
+	if _, err := assembleGrid(tiles[:5], gridLayout{rows: 2, columns: 3, width: 150, height: 50}); err == nil {
+		t.Error("missing tile accepted")
+	}
+	if _, err := assembleGrid(tiles, gridLayout{rows: 2, columns: 3, width: 200, height: 50}); err == nil {
+		t.Error("tiles not covering the grid accepted")
+	}
+
+	// Tiles that are not YCbCr are drawn instead.
+	gray := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range gray.Pix {
+		gray.Pix[i] = 200
+	}
+	plain := []image.Image{image.NewRGBA(image.Rect(0, 0, 8, 8)), gray}
+	img, err = assembleGrid(plain, gridLayout{rows: 1, columns: 2, width: 16, height: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, _, _, _ := img.At(12, 4).RGBA(); r>>8 != 200 {
+		t.Errorf("second tile not drawn: %v", img.At(12, 4))
+	}
+}
+
+func TestComposeOverlay(t *testing.T) {
+	blue := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(blue.Pix); i += 4 {
+		blue.Pix[i+2], blue.Pix[i+3] = 255, 255
+	}
+	out := composeOverlay([]image.Image{blue}, overlayLayout{
+		fill:    color.NRGBA64{R: 0xffff, A: 0xffff},
+		width:   10,
+		height:  10,
+		offsets: []image.Point{{-2, 8}},
+	})
+	if got := out.RGBAAt(0, 9); got != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("overlaid pixel = %v", got)
+	}
+	if got := out.RGBAAt(5, 5); got != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("canvas pixel = %v", got)
+	}
+}
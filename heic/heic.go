@@ -0,0 +1,123 @@
+// Package heic decodes the primary image of HEIC files, the HEVC-coded HEIF
+// images of phones (.heic) and Canon and Sony cameras (.hif), including grid
+// and overlay images and 10-bit samples, and with DecodeItem their other
+// images, such as alpha planes. It registers itself with the image package,
+// so a blank import lets image.Decode read HEIC:
+//
+//	import _ "github.com/iancleary/heictojpeg/heic"
+//
+// Images are returned as stored, without applying their EXIF orientation or
+// irot/imir transformations. Decoding needs cgo (see Backend).
+package heic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"strings"
+
+	"github.com/adrium/goheif/heif"
+)
+
+// JPEGItemType is the HEIF item type of JPEG-coded images (ISO/IEC 23008-12
+// annex H), which some apps store instead of HEVC. Decode reads them too.
+const JPEGItemType = "jpeg"
+
+// ErrUnsupportedCodec marks images that are not HEVC-coded, and ErrNoDecoder
+// every image in builds without cgo.
+var (
+	ErrUnsupportedCodec = errors.New("not HEVC-coded")
+	ErrNoDecoder        = errors.New("this build has no HEIC decoder; rebuild with CGO_ENABLED=1")
+)
+
+// registeredBrands are the major brands image.Decode recognises as HEIC,
+// those of HEVC-coded files. Other ISO BMFF files, such as MP4 and AVIF,
+// are left alone.
+var registeredBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx", "hevm", "hevs"}
+
+// Files of the structural major brands mif1 and msf1, as some phones write,
+// are recognised by a heic, heix or hevc brand among the first
+// sniffedCompatibleBrands of their compatible brands, since AVIF files use
+// the same major brands.
+var (
+	structuralBrands        = []string{"mif1", "msf1"}
+	compatibleBrands        = []string{"heic", "heix", "hevc"}
+	sniffedCompatibleBrands = 8
+)
+
+func init() {
+	for _, brand := range registeredBrands {
+		image.RegisterFormat("heic", "????ftyp"+brand, Decode, DecodeConfig)
+	}
+	// The minor version, then the compatible brands, follow the major one.
+	for _, major := range structuralBrands {
+		for i := 0; i < sniffedCompatibleBrands; i++ {
+			for _, brand := range compatibleBrands {
+				image.RegisterFormat("heic", "????ftyp"+major+"????"+strings.Repeat("????", i)+brand, Decode, DecodeConfig)
+			}
+		}
+	}
+}
+
+// Decode decodes the primary image of a HEIC file.
+func Decode(r io.Reader) (image.Image, error) {
+	hf, err := open(r)
+	if err != nil {
+		return nil, err
+	}
+	primary, err := hf.PrimaryItem()
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(hf, primary)
+}
+
+// DecodeItem decodes the image item id of a HEIC file, such as the alpha
+// plane or depth map stored as an auxiliary image of the primary one.
+// Monochrome images come back as the luma of an *image.YCbCr.
+func DecodeItem(r io.Reader, id uint32) (image.Image, error) {
+	hf, err := open(r)
+	if err != nil {
+		return nil, err
+	}
+	item, err := hf.ItemByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(hf, item)
+}
+
+// DecodeConfig returns the dimensions of the primary image of a HEIC file
+// from its headers, without decoding it.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	hf, err := open(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	primary, err := hf.PrimaryItem()
+	if err != nil {
+		return image.Config{}, err
+	}
+	width, height, ok := primary.SpatialExtents()
+	if !ok {
+		return image.Config{}, fmt.Errorf("item %d has no dimensions", primary.ID)
+	}
+	return image.Config{ColorModel: color.YCbCrModel, Width: width, Height: height}, nil
+}
+
+// open opens a HEIF file, reading r into memory unless it can be read at
+// random already.
+func open(r io.Reader) (*heif.File, error) {
+	ra, ok := r.(io.ReaderAt)
+	if !ok {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		ra = bytes.NewReader(data)
+	}
+	return heif.Open(ra), nil
+}
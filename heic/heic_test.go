@@ -0,0 +1,71 @@
+package heic
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"os"
+	"testing"
+)
+
+func TestRegisteredFormat(t *testing.T) {
+	data, err := os.ReadFile("../testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "heic" {
+		t.Fatalf("DecodeConfig: format %q, %v", format, err)
+	}
+
+	// A plain io.Reader, as image.Decode passes it, is read into memory.
+	img, format, err := image.Decode(struct{ *bytes.Reader }{bytes.NewReader(data)})
+	if errors.Is(err, ErrNoDecoder) {
+		t.Skip(err)
+	}
+	if err != nil || format != "heic" {
+		t.Fatalf("Decode: format %q, %v", format, err)
+	}
+	if b := img.Bounds(); b.Dx() != config.Width || b.Dy() != config.Height {
+		t.Errorf("decoded %v, DecodeConfig said %dx%d", b, config.Width, config.Height)
+	}
+}
+
+func TestOtherFormatsNotClaimed(t *testing.T) {
+	mp4 := []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00isomiso2")
+	if _, format, err := image.DecodeConfig(bytes.NewReader(mp4)); !errors.Is(err, image.ErrFormat) {
+		t.Errorf("MP4 taken for %q: %v", format, err)
+	}
+
+	avif, err := os.ReadFile("../testdata/images/libheif-example.avif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(avif)); !errors.Is(err, image.ErrFormat) {
+		t.Errorf("AVIF taken for %q: %v", format, err)
+	}
+	// AVIF files may give mif1 as their major brand too.
+	copy(avif[8:], "mif1")
+	if _, format, err := image.DecodeConfig(bytes.NewReader(avif)); !errors.Is(err, image.ErrFormat) {
+		t.Errorf("AVIF with major brand mif1 taken for %q: %v", format, err)
+	}
+}
+
+func TestStructuralBrands(t *testing.T) {
+	data, err := os.ReadFile("../testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[8:12]) != "mif1" {
+		t.Fatalf("fixture has major brand %q", data[8:12])
+	}
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err != nil || format != "heic" {
+		t.Errorf("mif1 with heic compatible: format %q, %v", format, err)
+	}
+	// Its compatible brands are mif1, heic and hevc; with only the
+	// structural one left it could be anything.
+	copy(data[20:], "mif1mif1")
+	if _, format, err := image.DecodeConfig(bytes.NewReader(data)); !errors.Is(err, image.ErrFormat) {
+		t.Errorf("mif1 without an HEVC brand taken for %q: %v", format, err)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build cgo
+
+package heic
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/adrium/goheif/heif"
+	"github.com/adrium/goheif/libde265"
+)
+
+// Backend names the HEVC decoder Decode uses: the copy of libde265 bundled
+// with goheif. Update it together with the goheif dependency.
+const Backend = "libde265 0.10.0 (cgo, bundled with github.com/adrium/goheif)"
+
+// decodeImage decodes an image item of hf, the primary one or one of its
+// auxiliary images, assembling grid and overlay images from the HEVC images
+// they are made of.
+func decodeImage(hf *heif.File, item *heif.Item) (image.Image, error) {
+	// Without safe encoding, images are returned backed by decoder memory
+	// that is freed before the caller reads them.
+	dec, err := libde265.NewDecoder(libde265.WithSafeEncoding(true))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Free()
+	return decodeItem(hf, item, func(item *heif.Item) (image.Image, error) {
+		hvcc, ok := item.HevcConfig()
+		if !ok {
+			return nil, fmt.Errorf("%w: item %d has no HEVC configuration", ErrUnsupportedCodec, item.ID)
+		}
+		data, err := hf.GetItemData(item)
+		if err != nil {
+			return nil, err
+		}
+		dec.Reset()
+		if err := dec.Push(hvcc.AsHeader()); err != nil {
+			return nil, err
+		}
+		img, err := dec.DecodeImage(data)
+		if ycc, ok := img.(*image.YCbCr); ok && err == nil {
+			// 10-bit camera files (.HIF) come out in 16-bit samples.
+			if depth := hevcBitDepth(item); depth > 8 {
+				img = narrowSamples(ycc, depth)
+			}
+		}
+		return img, err
+	}, 0)
+}
@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package heic
+
+import (
+	"image"
+
+	"github.com/adrium/goheif/heif"
+)
+
+// Without cgo there is no HEVC decoder to link. Such builds still read the
+// HEIC container: DecodeConfig works, Decode fails with ErrNoDecoder.
+const Backend = "none (built without cgo; HEIC images cannot be decoded)"
+
+func decodeImage(hf *heif.File, item *heif.Item) (image.Image, error) {
+	return nil, ErrNoDecoder
+}
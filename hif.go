@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/iancleary/heictojpeg/heic"
+)
+
+// heifExtensions are the source file extensions converted: .heic from
+// phones, .hif from Canon and Sony cameras and the generic .heif.
+var heifExtensions = map[string]bool{".heic": true, ".hif": true, ".heif": true}
+
+// hevcBrands are the ftyp brands of HEVC-coded HEIF files (ISO/IEC
+// 23008-12 Annex B): heic for 8-bit 4:2:0, heix for the 10-bit and 4:2:2
+// images of Canon and Sony cameras, heim and heis for multi-layer images and
+// the hev* brands for sequences. The structural mif1, msf1 and miaf brands
+// say nothing about the codec.
+var hevcBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "hevm": true, "hevs": true,
+}
+
+// otherBrands name the HEIF-based formats that are not HEVC-coded.
+var otherBrands = map[string]string{"avif": "AVIF", "avis": "AVIF", "jxl ": "JPEG XL"}
+
+// isHEICFile reports whether name has one of the heifExtensions.
+func isHEICFile(name string) bool {
+	return heifExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// ftypBrands returns the major and compatible brands of an ftyp box body.
+func ftypBrands(body []byte) (major string, compatible []string) {
+	if len(body) < 8 {
+		return "", nil
+	}
+	for i := 8; i+4 <= len(body); i += 4 {
+		compatible = append(compatible, string(body[i:i+4]))
+	}
+	return string(body[:4]), compatible
+}
+
+// errUnsupportedCodec marks sources whose images are not HEVC-coded, and
+// errNoDecoder every source in builds without cgo, as the heic package
+// reports them.
+var (
+	errUnsupportedCodec = heic.ErrUnsupportedCodec
+	errNoDecoder        = heic.ErrNoDecoder
+)
+
+// codecError is the error of a source whose brand names another format. It
+// is both errMalformedSource, a problem with the source, and
+// errUnsupportedCodec.
+type codecError struct {
+	name, brand string
+}
+
+func (e *codecError) Error() string {
+	return fmt.Sprintf("%v: %s file (brand %q), not HEIC", errMalformedSource, e.name, e.brand)
+}
+
+func (e *codecError) Is(target error) bool {
+	return target == errMalformedSource || target == errUnsupportedCodec
+}
+
+// checkBrands rejects files whose brands name a format that is not
+// HEVC-coded, such as AVIF with an .heif extension. Files with no brand
+// known either way are left to the decoder.
+func checkBrands(major string, compatible []string) error {
+	all := append([]string{major}, compatible...)
+	for _, brand := range all {
+		if hevcBrands[brand] {
+			return nil
+		}
+	}
+	for _, brand := range all {
+		if name, ok := otherBrands[brand]; ok {
+			return &codecError{name: name, brand: brand}
+		}
+	}
+	return nil
+}
+
+// describeBrands is the brand line of `heictojpeg info`.
+func describeBrands(major string, compatible []string) string {
+	desc := major
+	switch major {
+	case "heix":
+		desc += " (HEVC, 10-bit or 4:2:2, e.g. Canon and Sony cameras)"
+	case "heic":
+		desc += " (HEVC)"
+	}
+	if len(compatible) > 0 {
+		desc += ", compatible with " + strings.Join(compatible, " ")
+	}
+	return desc
+}
+
+// readBrands reads the brands of the ftyp box at the start of a file.
+func readBrands(ra io.ReaderAt) (major string, compatible []string) {
+	var header [8]byte
+	if _, err := ra.ReadAt(header[:], 0); err != nil || string(header[4:]) != "ftyp" {
+		return "", nil
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size < 16 || size > 4096 {
+		return "", nil
+	}
+	body := make([]byte, size-8)
+	if _, err := ra.ReadAt(body, 8); err != nil {
+		return "", nil
+	}
+	return ftypBrands(body)
+}
+
+// normalizeExif returns the EXIF block of a HEIF Exif item, after the
+// 4-byte header offset goheif drops, as the "Exif\0\0"-prefixed block JPEG
+// APP1 segments hold. Phones write the prefix after the offset; Canon and
+// Sony cameras write an offset of 0 and start with the TIFF header, and some
+// encoders pad before it.
+func normalizeExif(data []byte) []byte {
+	if bytes.HasPrefix(data, exifHeader) {
+		return data
+	}
+	search := data
+	if len(search) > 64 {
+		search = search[:64]
+	}
+	if i := bytes.Index(search, exifHeader); i >= 0 {
+		return data[i:]
+	}
+	for _, tiff := range [][]byte{[]byte("MM\x00\x2a"), []byte("II\x2a\x00")} {
+		if i := bytes.Index(search, tiff); i >= 0 {
+			return append(append([]byte(nil), exifHeader...), data[i:]...)
+		}
+	}
+	return data
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+func TestIsHEICFile(t *testing.T) {
+	for name, want := range map[string]bool{
+		"IMG_0001.HEIC": true,
+		"IMG_0001.heic": true,
+		"_MG_0001.HIF":  true,
+		"DSC00001.HIF":  true,
+		"photo.heif":    true,
+		"photo.avif":    false,
+		"photo.jpg":     false,
+		"hif":           false,
+	} {
+		if got := isHEICFile(name); got != want {
+			t.Errorf("%s: got %v", name, got)
+		}
+	}
+}
+
+func TestCheckBrands(t *testing.T) {
+	major, compatible := ftypBrands([]byte("heix\x00\x00\x00\x00mif1heix"))
+	if major != "heix" || len(compatible) != 2 || compatible[0] != "mif1" {
+		t.Fatalf("unexpected brands %q %q", major, compatible)
+	}
+	if err := checkBrands(major, compatible); err != nil {
+		t.Errorf("heix: %v", err)
+	}
+	if err := checkBrands("mif1", []string{"avif", "heic"}); err != nil {
+		t.Errorf("HEVC brand listed: %v", err)
+	}
+	if err := checkBrands("mif1", []string{"miaf"}); err != nil {
+		t.Errorf("structural brands only: %v", err)
+	}
+	if err := checkBrands("avif", []string{"mif1", "miaf"}); !errors.Is(err, errMalformedSource) {
+		t.Errorf("AVIF: got %v", err)
+	}
+	if err := checkSource("testdata/images/libheif-example.avif"); !errors.Is(err, errMalformedSource) {
+		t.Errorf("AVIF fixture: got %v", err)
+	}
+
+	f, err := os.Open("testdata/images/canon-layout.hif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if major, _ := readBrands(f); major != "heix" {
+		t.Errorf("fixture brand %q", major)
+	}
+}
+
+func TestNormalizeExif(t *testing.T) {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	withHeader := append(append([]byte(nil), exifHeader...), tiff...)
+	for name, data := range map[string][]byte{
+		"phone":  withHeader,
+		"camera": tiff,
+		"padded": append([]byte{0, 0, 0, 0}, tiff...),
+		"offset": append([]byte{0, 0}, withHeader...),
+	} {
+		if got := normalizeExif(data); !bytes.Equal(got, withHeader) {
+			t.Errorf("%s: got % x", name, got)
+		}
+	}
+	if got := normalizeExif([]byte("junk")); string(got) != "junk" {
+		t.Errorf("unrecognised data changed to % x", got)
+	}
+}
+
+func TestConvertHIF(t *testing.T) {
+	jpegDir := t.TempDir()
+	output, err := convertFile("testdata/images", "canon-layout.hif", jpegDir, defaultOptions())
+	if err != nil {
+		t.Fatalf("convertFile failed: %v", err)
+	}
+	if output != "canon-layout.jpg" {
+		t.Fatalf("unexpected output path %s", output)
+	}
+	data, err := os.ReadFile(filepath.Join(jpegDir, output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width != 320 || config.Height != 240 {
+		t.Fatalf("unexpected output %dx%d (%v)", config.Width, config.Height, err)
+	}
+
+	// The camera's bare TIFF block is written as a regular APP1 segment.
+	i := bytes.Index(data, exifHeader)
+	if i < 4 || data[i-4] != 0xff || data[i-3] != 0xe1 {
+		t.Fatal("no EXIF APP1 segment in output")
+	}
+	x, err := exif.Decode(bytes.NewReader(data[i+len(exifHeader):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := exifStringField(x, exif.Model); got != "Canon EOS R5" {
+		t.Errorf("model %q", got)
+	}
+}
+
+func TestInfoHIF(t *testing.T) {
+	var out bytes.Buffer
+	if err := runInfo([]string{"testdata/images/canon-layout.hif"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Brand:      heix (HEVC, 10-bit or 4:2:2", "Camera:     Canon Canon EOS R5", "Taken:      2023-06-01 12:00:00"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+}
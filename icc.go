@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const iccHeaderSize = 128
+
+// parseICCProfile reads an RGB matrix/TRC ICC profile: the rXYZ/gXYZ/bXYZ
+// colorant tags and the rTRC/gTRC/bTRC curves. LUT-based profiles are not
+// supported.
+func parseICCProfile(data []byte) (*colorProfile, error) {
+	if len(data) < iccHeaderSize+4 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("not an ICC profile")
+	}
+	if string(data[16:20]) != "RGB " {
+		return nil, fmt.Errorf("unsupported ICC colour space %q", data[16:20])
+	}
+
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[iccHeaderSize:]))
+	for i := 0; i < count; i++ {
+		entry := iccHeaderSize + 4 + 12*i
+		if entry+12 > len(data) {
+			return nil, errors.New("truncated ICC tag table")
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4:]))
+		size := int(binary.BigEndian.Uint32(data[entry+8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errors.New("ICC tag out of range")
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+
+	profile := &colorProfile{}
+	var gammas [3]float64
+	for c, name := range []string{"r", "g", "b"} {
+		xyz, err := parseICCXYZ(tags[name+"XYZ"])
+		if err != nil {
+			return nil, fmt.Errorf("%sXYZ: %v", name, err)
+		}
+		for row := 0; row < 3; row++ {
+			profile.toXYZ[row][c] = xyz[row]
+		}
+		curve, gamma, err := parseICCCurve(tags[name+"TRC"])
+		if err != nil {
+			return nil, fmt.Errorf("%sTRC: %v", name, err)
+		}
+		profile.trc[c] = curve
+		gammas[c] = gamma
+	}
+	if gammas[0] == gammas[1] && gammas[1] == gammas[2] {
+		profile.gamma = gammas[0]
+	}
+	return profile, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+func parseICCXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+		return [3]float64{}, errors.New("missing or invalid XYZ tag")
+	}
+	return [3]float64{s15Fixed16(tag[8:]), s15Fixed16(tag[12:]), s15Fixed16(tag[16:])}, nil
+}
+
+// parseICCCurve decodes a curv or para tag into a transfer function. gamma is
+// non-zero for pure power laws.
+func parseICCCurve(tag []byte) (func(float64) float64, float64, error) {
+	if len(tag) < 12 {
+		return nil, 0, errors.New("missing or invalid curve tag")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if len(tag) < 12+2*n {
+			return nil, 0, errors.New("truncated curve")
+		}
+		switch n {
+		case 0:
+			return func(v float64) float64 { return v }, 1, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return gammaCurve(gamma), gamma, nil
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			pos := v * float64(n-1)
+			i := int(pos)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, 0, nil
+
+	case "para":
+		funcType := binary.BigEndian.Uint16(tag[8:])
+		counts := []int{1, 3, 4, 5, 7}
+		if int(funcType) >= len(counts) || len(tag) < 12+4*counts[funcType] {
+			return nil, 0, fmt.Errorf("unsupported parametric curve type %d", funcType)
+		}
+		var p [7]float64
+		for i := 0; i < counts[funcType]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch funcType {
+		case 0:
+			return gammaCurve(g), g, nil
+		case 1:
+			return func(v float64) float64 {
+				if v >= -b/a {
+					return math.Pow(a*v+b, g)
+				}
+				return 0
+			}, 0, nil
+		case 2:
+			return func(v float64) float64 {
+				if v >= -b/a {
+					return math.Pow(a*v+b, g) + c
+				}
+				return c
+			}, 0, nil
+		case 3:
+			return func(v float64) float64 {
+				if v >= d {
+					return math.Pow(a*v+b, g)
+				}
+				return c * v
+			}, 0, nil
+		default:
+			return func(v float64) float64 {
+				if v >= d {
+					return math.Pow(a*v+b, g) + e
+				}
+				return c*v + f
+			}, 0, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("unsupported curve type %q", tag[:4])
+}
+
+// iccCurveEntries is the size of the sampled curv table written for curves
+// that are not pure power laws.
+const iccCurveEntries = 1024
+
+// buildICCProfile serialises p as a version 2 display profile with matrix/TRC
+// tags, the most widely understood form.
+func buildICCProfile(p *colorProfile, description string) []byte {
+	desc := append([]byte("desc\x00\x00\x00\x00"), binary.BigEndian.AppendUint32(nil, uint32(len(description)+1))...)
+	desc = append(append(desc, description...), 0)
+	desc = append(desc, make([]byte, 4+4+2+1+67)...) // empty Unicode and ScriptCode descriptions
+	var xyz [3][]byte
+	for c := 0; c < 3; c++ {
+		xyz[c] = iccXYZ([3]float64{p.toXYZ[0][c], p.toXYZ[1][c], p.toXYZ[2][c]})
+	}
+
+	trc := []byte("curv\x00\x00\x00\x00")
+	if p.gamma != 0 {
+		trc = binary.BigEndian.AppendUint32(trc, 1)
+		trc = binary.BigEndian.AppendUint16(trc, uint16(math.Round(p.gamma*256)))
+	} else {
+		trc = binary.BigEndian.AppendUint32(trc, iccCurveEntries)
+		for i := 0; i < iccCurveEntries; i++ {
+			v := p.trc[0](float64(i) / (iccCurveEntries - 1))
+			trc = binary.BigEndian.AppendUint16(trc, uint16(math.Round(v*65535)))
+		}
+	}
+
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	tags := []tag{
+		{"desc", desc},
+		{"cprt", append([]byte("text\x00\x00\x00\x00"), "No copyright, use freely\x00"...)},
+		{"wtpt", iccXYZ(d50)},
+		{"rXYZ", xyz[0]},
+		{"gXYZ", xyz[1]},
+		{"bXYZ", xyz[2]},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	offset := iccHeaderSize + 4 + 12*len(tags)
+	var table, body []byte
+	offsets := map[string]int{} // the three TRC tags share one curve
+	for _, t := range tags {
+		key := string(t.data)
+		at, shared := offsets[key]
+		if !shared {
+			for (offset+len(body))%4 != 0 {
+				body = append(body, 0)
+			}
+			at = offset + len(body)
+			offsets[key] = at
+			body = append(body, t.data...)
+		}
+		table = append(table, t.sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(at))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(t.data)))
+	}
+
+	header := make([]byte, iccHeaderSize)
+	total := iccHeaderSize + 4 + len(table) + len(body)
+	binary.BigEndian.PutUint32(header[0:], uint32(total))
+	binary.BigEndian.PutUint32(header[8:], 0x02100000)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	copy(header[68:], iccXYZ(d50)[8:])
+
+	out := append(header, binary.BigEndian.AppendUint32(nil, uint32(len(tags)))...)
+	out = append(out, table...)
+	return append(out, body...)
+}
+
+func iccXYZ(v [3]float64) []byte {
+	b := []byte("XYZ \x00\x00\x00\x00")
+	for _, c := range v {
+		b = binary.BigEndian.AppendUint32(b, uint32(int32(math.Round(c*65536))))
+	}
+	return b
+}
+
+// maxICCChunk is the profile payload one APP2 segment can carry after the
+// length, "ICC_PROFILE\0" identifier and chunk numbering.
+const maxICCChunk = 0xffff - 2 - 14
+
+// writeICCProfile writes profile as the APP2 ICC_PROFILE segments that
+// follow the JPEG SOI marker.
+func writeICCProfile(w io.Writer, profile []byte) error {
+	if len(profile) == 0 {
+		return nil
+	}
+	chunks := (len(profile) + maxICCChunk - 1) / maxICCChunk
+	if chunks > 255 {
+		return fmt.Errorf("ICC profile of %d bytes is too large to embed", len(profile))
+	}
+	for i := 0; i < chunks; i++ {
+		chunk := profile[i*maxICCChunk:]
+		if len(chunk) > maxICCChunk {
+			chunk = chunk[:maxICCChunk]
+		}
+		var segment bytes.Buffer
+		segment.Write([]byte{0xff, 0xe2})
+		binary.Write(&segment, binary.BigEndian, uint16(2+14+len(chunk)))
+		segment.WriteString("ICC_PROFILE\x00")
+		segment.Write([]byte{byte(i + 1), byte(chunks)})
+		segment.Write(chunk)
+		if _, err := segment.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestICCProfileRoundTrip(t *testing.T) {
+	for _, builtin := range []*colorProfile{profileDisplayP3, profileAdobeRGB} {
+		if string(builtin.icc[36:40]) != "acsp" {
+			t.Fatalf("%s: malformed profile", builtin.name)
+		}
+		parsed, err := parseICCProfile(builtin.icc)
+		if err != nil {
+			t.Fatalf("%s: %v", builtin.name, err)
+		}
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				if math.Abs(parsed.toXYZ[i][j]-builtin.toXYZ[i][j]) > 1e-4 {
+					t.Fatalf("%s: matrix[%d][%d] = %f, want %f", builtin.name, i, j, parsed.toXYZ[i][j], builtin.toXYZ[i][j])
+				}
+			}
+		}
+		for _, v := range []float64{0, 0.02, 0.2, 0.5, 0.9, 1} {
+			if got, want := parsed.trc[1](v), builtin.trc[1](v); math.Abs(got-want) > 1e-3 {
+				t.Fatalf("%s: curve(%g) = %f, want %f", builtin.name, v, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadColorProfileFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p3.icc")
+	if err := os.WriteFile(path, profileDisplayP3.icc, 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := loadColorProfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.icc, profileDisplayP3.icc) {
+		t.Fatal("profile file should be embedded verbatim")
+	}
+
+	if err := os.WriteFile(path, []byte("not a profile"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadColorProfile(path); err == nil {
+		t.Fatal("expected error for an invalid profile")
+	}
+}
+
+func TestParametricCurve(t *testing.T) {
+	// Type 3 parameters for the sRGB curve.
+	tag := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		tag = append(tag, iccXYZ([3]float64{v})[8:12]...)
+	}
+	curve, gamma, err := parseICCCurve(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gamma != 0 {
+		t.Fatalf("parametric sRGB curve reported gamma %f", gamma)
+	}
+	for _, v := range []float64{0.01, 0.3, 0.8} {
+		if math.Abs(curve(v)-srgbToLinear(v)) > 1e-4 {
+			t.Fatalf("curve(%g) = %f, want %f", v, curve(v), srgbToLinear(v))
+		}
+	}
+}
+
+func TestWriteICCProfile(t *testing.T) {
+	profile := bytes.Repeat([]byte{7}, maxICCChunk+10)
+	var buf bytes.Buffer
+	if err := writeICCProfile(&buf, profile); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if b[0] != 0xff || b[1] != 0xe2 || string(b[4:16]) != "ICC_PROFILE\x00" || b[16] != 1 || b[17] != 2 {
+		t.Fatalf("unexpected first segment header % x", b[:18])
+	}
+	if want := 2*(4+14) + len(profile); len(b) != want {
+		t.Fatalf("wrote %d bytes, want %d", len(b), want)
+	}
+}
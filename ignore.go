@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the file in the input folder whose gitignore-style
+// patterns exclude sources from a run.
+const ignoreFileName = ".heicignore"
+
+// ignoreRule is one pattern of an ignore file.
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool // "!pattern" re-includes what earlier patterns ignored
+	dirOnly bool // "pattern/" only matches folders
+}
+
+// ignoreRules are the patterns of an ignore file, in order. The last pattern
+// matching a path decides whether it is ignored; with none, nothing is.
+type ignoreRules []ignoreRule
+
+// loadIgnoreFile reads the .heicignore file in dir. A missing file ignores
+// nothing.
+func loadIgnoreFile(dir string) (ignoreRules, error) {
+	f, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := parseIgnore(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ignoreFileName, err)
+	}
+	return rules, nil
+}
+
+// parseIgnore parses gitignore syntax: blank lines and lines starting with #
+// are skipped, ! negates a pattern, a trailing / matches folders only, and a
+// pattern with a / anywhere else is relative to the input folder, while one
+// without matches a name at any depth. *, ? and [...] match within a path
+// component, and ** across components.
+func parseIgnore(r io.Reader) (ignoreRules, error) {
+	var rules ignoreRules
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		pattern := trimIgnoreSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate, pattern = true, pattern[1:]
+		} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly, pattern = true, strings.TrimRight(pattern, "/")
+		}
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(ignorePatternRegexp(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid pattern %q", line, scanner.Text())
+		}
+		rule.re = re
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// trimIgnoreSpace removes trailing spaces, unless escaped with a backslash.
+func trimIgnoreSpace(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// ignorePatternRegexp translates a pattern into a regular expression matching
+// slash-separated paths relative to the input folder.
+func ignorePatternRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	if strings.Contains(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && i > 0 && pattern[i-1] == '/':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+// ignored reports whether the slash-separated path rel is ignored. As with
+// git, files in an ignored folder cannot be re-included.
+func (rules ignoreRules) ignored(rel string, isDir bool) bool {
+	if len(rules) == 0 {
+		return false
+	}
+	rel = path.Clean(filepath.ToSlash(rel))
+	for i := strings.IndexByte(rel, '/'); i >= 0; i = nextSlash(rel, i) {
+		if rules.match(rel[:i], true) {
+			return true
+		}
+	}
+	return rules.match(rel, isDir)
+}
+
+func nextSlash(s string, i int) int {
+	j := strings.IndexByte(s[i+1:], '/')
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+func (rules ignoreRules) match(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// filter drops the ignored files.
+func (rules ignoreRules) filter(files []os.DirEntry) []os.DirEntry {
+	if len(rules) == 0 {
+		return files
+	}
+	kept := make([]os.DirEntry, 0, len(files))
+	for _, file := range files {
+		if !rules.ignored(file.Name(), file.IsDir()) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// inputIgnoreRules loads the ignore file of the input folder. Manifests,
+// Photos libraries and single files have no input folder of their own.
+func inputIgnoreRules(opts options) (ignoreRules, error) {
+	if opts.filesFrom != "" || opts.library != nil {
+		return nil, nil
+	}
+	if info, err := os.Stat(opts.inputPath); err != nil || !info.IsDir() {
+		return nil, nil
+	}
+	return loadIgnoreFile(opts.inputPath)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := parseIgnore(strings.NewReader(`# edits and exports
+*-edited.heic
+Exports/
+/IMG_00??.heic
+!IMG_0001.heic
+raw/**/draft*.heic
+**/tmp/*.heic
+\#hash.heic
+trailing.heic   
+[!A]bc.heic
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{"IMG_1-edited.heic", true},
+		{"2023/IMG_1-edited.heic", true},
+		{"IMG_1.heic", false},
+		{"Exports/IMG_1.heic", true},
+		{"2023/Exports/IMG_1.heic", true},
+		{"Exports", false}, // a file named like the folder
+		{"IMG_0042.heic", true},
+		{"2023/IMG_0042.heic", false}, // anchored to the input folder
+		{"IMG_0001.heic", false},      // re-included
+		{"raw/draft1.heic", true},
+		{"raw/a/b/draft1.heic", true},
+		{"raw/a/final.heic", false},
+		{"tmp/x.heic", true},
+		{"a/tmp/x.heic", true},
+		{"#hash.heic", true},
+		{"trailing.heic", true},
+		{"bbc.heic", true},
+		{"Abc.heic", false},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(tt.path, false); got != tt.ignored {
+			t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.ignored)
+		}
+	}
+	if !rules.ignored("Exports", true) {
+		t.Error("folder Exports not ignored")
+	}
+}
+
+func TestIgnoreRulesFolderCannotBeReincluded(t *testing.T) {
+	rules, _ := parseIgnore(strings.NewReader("Exports/\n!Exports/keep.heic\n"))
+	if !rules.ignored("Exports/keep.heic", false) {
+		t.Error("file in an ignored folder re-included")
+	}
+}
+
+func TestRunWithIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "camel-edited.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte("*-edited.heic\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, order := range []string{orderName, orderDirectory} {
+		opts := defaultOptions()
+		opts.inputPath = dir
+		opts.outputDir = filepath.Join(dir, "out-"+order)
+		opts.order = order
+		if _, err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(opts.outputDir, "camel.jpg")); err != nil {
+			t.Errorf("%s: %v", order, err)
+		}
+		if _, err := os.Stat(filepath.Join(opts.outputDir, "camel-edited.jpg")); err == nil {
+			t.Errorf("%s: ignored file converted", order)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	integrationActionName = "Convert to JPEG"
+	dolphinServiceMenu    = "heictojpeg-convert.desktop"
+)
+
+// integrationFiles returns the Nautilus script and Dolphin service menu that
+// add a "Convert to JPEG" action running `exe open` on the selection, keyed
+// by path.
+func integrationFiles(dataHome, exe string) map[string]string {
+	quoted := "'" + strings.ReplaceAll(exe, "'", `'\''`) + "'"
+	return map[string]string{
+		// Nautilus passes the selected local files as arguments.
+		filepath.Join(dataHome, "nautilus", "scripts", integrationActionName): "#!/bin/sh\nexec " + quoted + " open \"$@\"\n",
+
+		filepath.Join(dataHome, "kio", "servicemenus", dolphinServiceMenu): `[Desktop Entry]
+Type=Service
+MimeType=image/heic;image/heif;
+Actions=convertToJpeg
+X-KDE-Priority=TopLevel
+
+[Desktop Action convertToJpeg]
+Name=` + integrationActionName + `
+Icon=image-x-generic
+Exec=` + desktopExecArg(exe) + ` open %U
+`,
+	}
+}
+
+// desktopExecArg quotes arg for the Exec key of a desktop entry as the
+// Desktop Entry Specification has it: % is doubled so it is not taken for a
+// field code, and an argument holding a reserved character goes in double
+// quotes, inside which ", `, $ and \ are escaped with a backslash. The key's
+// value is a string, so its own escapes are applied on top, doubling every
+// backslash again.
+func desktopExecArg(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if strings.ContainsAny(arg, " \t\n\"'\\><~|&;$*?#()`") {
+		arg = `"` + strings.NewReplacer(`"`, `\"`, "`", "\\`", "$", `\$`, `\`, `\\`).Replace(arg) + `"`
+	}
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\t", `\t`, "\r", `\r`).Replace(arg)
+}
+
+// xdgDataHome returns $XDG_DATA_HOME, defaulting to ~/.local/share.
+func xdgDataHome() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// installIntegration implements `heictojpeg install-integration [--remove]`.
+func installIntegration(args []string, output io.Writer) error {
+	fs := flag.NewFlagSet("install-integration", flag.ContinueOnError)
+	fs.SetOutput(output)
+	remove := fs.Bool("remove", false, "remove the file manager actions instead of installing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("file manager integration is only available on Linux desktops")
+	}
+
+	dataHome, err := xdgDataHome()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	files := integrationFiles(dataHome, exe)
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if *remove {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			fmt.Fprintf(output, "Removed %s\n", path)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		// Both file managers only run executable scripts and service menus.
+		if err := os.WriteFile(path, []byte(files[path]), 0755); err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "Installed %s\n", path)
+	}
+	return nil
+}
+
+// uriToPath accepts a file:// URI or a plain path.
+func uriToPath(uri string) (string, error) {
+	if !strings.Contains(uri, "://") {
+		return uri, nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") {
+		return "", fmt.Errorf("only local files can be converted: %s", uri)
+	}
+	return u.Path, nil
+}
+
+// openSelection implements `heictojpeg open URI...`, the handler behind the
+// file manager action. Selected files are converted into a jpegs folder next
+// to them; selected folders are converted as if passed on the command line.
+func openSelection(uris []string, opts options, output io.Writer) error {
+	byDir := map[string][]string{}
+	var dirs []string
+	for _, uri := range uris {
+		path, err := uriToPath(uri)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirOpts := opts
+			dirOpts.inputPath = path
+			if _, err := run(dirOpts); err != nil {
+				return err
+			}
+			continue
+		}
+		dir := filepath.Dir(path)
+		if _, seen := byDir[dir]; !seen {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], path)
+	}
+
+	failed := 0
+	for _, dir := range dirs {
+		batchOpts := opts
+		if batchOpts.outputDir == "" {
+			batchOpts.outputDir = filepath.Join(dir, "jpegs")
+		}
+		b := newPathBatch(batchOpts)
+		b.add(byDir[dir]...)
+		events, err := b.run(context.Background(), nil)
+		if err != nil {
+			return err
+		}
+		for _, ev := range events {
+			if ev.Err != nil {
+				failed++
+				fmt.Fprintf(output, "%s: %v\n", ev.Path, ev.Err)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the selected files could not be converted", failed)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIntegrationFiles(t *testing.T) {
+	files := integrationFiles("/home/u/.local/share", "/opt/my tools/heictojpeg")
+
+	script := files[filepath.Join("/home/u/.local/share", "nautilus", "scripts", integrationActionName)]
+	if !strings.Contains(script, `exec '/opt/my tools/heictojpeg' open "$@"`) {
+		t.Errorf("unexpected Nautilus script:\n%s", script)
+	}
+	menu := files[filepath.Join("/home/u/.local/share", "kio", "servicemenus", dolphinServiceMenu)]
+	if !strings.Contains(menu, `Exec="/opt/my tools/heictojpeg" open %U`) || !strings.Contains(menu, "MimeType=image/heic;") {
+		t.Errorf("unexpected Dolphin service menu:\n%s", menu)
+	}
+}
+
+func TestDesktopExecArg(t *testing.T) {
+	for _, c := range []struct{ arg, want string }{
+		{"/usr/bin/heictojpeg", "/usr/bin/heictojpeg"},
+		{"/opt/100%/heictojpeg", "/opt/100%%/heictojpeg"},
+		{"/opt/my tools/heictojpeg", `"/opt/my tools/heictojpeg"`},
+		{"/opt/$HOME/`x`/heictojpeg", `"/opt/\\$HOME/\\` + "`x\\\\`" + `/heictojpeg"`},
+		{`/opt/"a"\b/heictojpeg`, `"/opt/\\"a\\"\\\\b/heictojpeg"`},
+		{"/opt/a\nb/heictojpeg", `"/opt/a\nb/heictojpeg"`},
+	} {
+		if got := desktopExecArg(c.arg); got != c.want {
+			t.Errorf("desktopExecArg(%q) = %s, want %s", c.arg, got, c.want)
+		}
+	}
+}
+
+func TestURIToPath(t *testing.T) {
+	tests := map[string]string{
+		"file:///home/u/My%20Photos/IMG_0001.HEIC": "/home/u/My Photos/IMG_0001.HEIC",
+		"file://localhost/tmp/a.heic":              "/tmp/a.heic",
+		"/tmp/plain.heic":                          "/tmp/plain.heic",
+	}
+	for uri, want := range tests {
+		if got, err := uriToPath(uri); err != nil || got != want {
+			t.Errorf("uriToPath(%q) = %q, %v; want %q", uri, got, err, want)
+		}
+	}
+	for _, uri := range []string{"smb://nas/photos/a.heic", "file://nas/photos/a.heic"} {
+		if _, err := uriToPath(uri); err == nil {
+			t.Errorf("uriToPath(%q) should fail", uri)
+		}
+	}
+}
+
+func TestOpenSelection(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(dir, "camel.heic")
+	if err := os.WriteFile(source, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.embedThumbnail = false
+	if err := openSelection([]string{"file://" + filepath.ToSlash(source)}, opts, os.Stderr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jpegs", "camel.jpg")); err != nil {
+		t.Fatalf("expected output next to the selection: %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// IPTC-IIM datasets written by --artist and --copyright, as record and
+// dataset numbers.
+var (
+	iptcCodedCharacterSet = [2]byte{1, 90}
+	iptcRecordVersion     = [2]byte{2, 0}
+	iptcByline            = [2]byte{2, 80}
+	iptcCopyrightNotice   = [2]byte{2, 116}
+)
+
+// Limits of the IPTC-IIM specification for the two fields.
+const (
+	maxIPTCByline    = 32
+	maxIPTCCopyright = 128
+)
+
+// photoshopNamespace starts the APP13 segment holding Photoshop image
+// resources, of which the IPTC block (resource 0x0404) is one.
+var photoshopNamespace = []byte("Photoshop 3.0\x00")
+
+// writeIPTC writes an APP13 segment with an IPTC-IIM block naming the artist
+// and the copyright notice, declared as UTF-8. Nothing is written when both
+// are empty.
+func writeIPTC(w io.Writer, artist, copyright string) error {
+	if artist == "" && copyright == "" {
+		return nil
+	}
+	var iptc bytes.Buffer
+	writeIPTCDataset(&iptc, iptcCodedCharacterSet, "\x1b%G")
+	writeIPTCDataset(&iptc, iptcRecordVersion, "\x00\x04")
+	if artist != "" {
+		writeIPTCDataset(&iptc, iptcByline, truncateUTF8(artist, maxIPTCByline))
+	}
+	if copyright != "" {
+		writeIPTCDataset(&iptc, iptcCopyrightNotice, truncateUTF8(copyright, maxIPTCCopyright))
+	}
+	if iptc.Len()%2 == 1 {
+		iptc.WriteByte(0) // resource data is padded to an even length
+	}
+
+	var segment bytes.Buffer
+	segment.Write([]byte{0xff, 0xed, 0, 0})
+	segment.Write(photoshopNamespace)
+	segment.WriteString("8BIM")
+	binary.Write(&segment, binary.BigEndian, uint16(0x0404))
+	segment.Write([]byte{0, 0}) // empty resource name, padded
+	binary.Write(&segment, binary.BigEndian, uint32(iptc.Len()))
+	segment.Write(iptc.Bytes())
+	length := segment.Len() - 2
+	if length > 0xffff {
+		return fmt.Errorf("IPTC block of %d bytes is too large", length)
+	}
+	binary.BigEndian.PutUint16(segment.Bytes()[2:], uint16(length))
+	_, err := segment.WriteTo(w)
+	return err
+}
+
+func writeIPTCDataset(b *bytes.Buffer, id [2]byte, value string) {
+	b.Write([]byte{0x1c, id[0], id[1]})
+	binary.Write(b, binary.BigEndian, uint16(len(value)))
+	b.WriteString(value)
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xc0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
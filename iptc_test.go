@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteIPTC(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeIPTC(&out, "", ""); err != nil || out.Len() != 0 {
+		t.Fatalf("wrote %d bytes without attribution, err %v", out.Len(), err)
+	}
+
+	if err := writeIPTC(&out, "Jane Doe", "© 2024 Jane Doe"); err != nil {
+		t.Fatal(err)
+	}
+	segment := out.Bytes()
+	if segment[0] != 0xff || segment[1] != 0xed || int(binary.BigEndian.Uint16(segment[2:])) != len(segment)-2 {
+		t.Fatalf("bad APP13 header % x", segment[:4])
+	}
+	rest := segment[4:]
+	if !bytes.HasPrefix(rest, photoshopNamespace) {
+		t.Fatalf("missing Photoshop namespace")
+	}
+	rest = rest[len(photoshopNamespace):]
+	if string(rest[:4]) != "8BIM" || binary.BigEndian.Uint16(rest[4:]) != 0x0404 {
+		t.Fatalf("bad image resource header % x", rest[:8])
+	}
+	size := binary.BigEndian.Uint32(rest[8:])
+	iptc := rest[12:]
+	if int(size) != len(iptc) || size%2 != 0 {
+		t.Fatalf("resource size %d, data %d bytes", size, len(iptc))
+	}
+
+	datasets := map[[2]byte]string{}
+	for len(iptc) >= 5 && iptc[0] == 0x1c {
+		n := int(binary.BigEndian.Uint16(iptc[3:]))
+		datasets[[2]byte{iptc[1], iptc[2]}] = string(iptc[5 : 5+n])
+		iptc = iptc[5+n:]
+	}
+	if datasets[iptcByline] != "Jane Doe" || datasets[iptcCopyrightNotice] != "© 2024 Jane Doe" || datasets[iptcCodedCharacterSet] != "\x1b%G" {
+		t.Errorf("datasets %q", datasets)
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	if got := truncateUTF8("©©©", 5); got != "©©" {
+		t.Errorf("got %q", got)
+	}
+	if got := truncateUTF8("short", 32); got != "short" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestConvertWithAttribution(t *testing.T) {
+	jpegDir := t.TempDir()
+	opts := defaultOptions()
+	opts.artist = "Jane Doe"
+	opts.copyright = "© 2024 Jane Doe"
+	output, err := convertFile("testdata/images", "goheif-camel.heic", jpegDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(jpegDir, output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "Jane Doe") != 4 {
+		t.Errorf("expected the artist in EXIF and IPTC, and the copyright in both")
+	}
+	if !bytes.Contains(data, photoshopNamespace) {
+		t.Error("no IPTC segment")
+	}
+}
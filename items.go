@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/adrium/goheif/heif"
+)
+
+// heifItem summarises one top-level item of a HEIC file for `info`: the
+// primary image and everything stored next to it.
+type heifItem struct {
+	id            uint32
+	kind          string
+	width, height int   // 0 for non-image items
+	size          int64 // stored bytes, including grid tiles
+	tiles         int
+	converted     bool // whether conversion uses it (primary image, EXIF, XMP)
+}
+
+// Auxiliary image types, keyed by the URN in their auxC property. Apple
+// URNs are matched on their last component since the year in them varies.
+var auxKinds = map[string]string{
+	"urn:mpeg:hevc:2015:auxid:1":                  "Alpha",
+	"urn:mpeg:mpegB:cicp:systems:auxiliary:alpha": "Alpha",
+	"urn:mpeg:hevc:2015:auxid:2":                  "Depth map",
+	"urn:mpeg:mpegB:cicp:systems:auxiliary:depth": "Depth map",
+	"depth":                "Depth map",
+	"disparity":            "Depth map",
+	"portraiteffectsmatte": "Portrait matte",
+	"semanticskinmatte":    "Skin matte",
+	"semantichairmatte":    "Hair matte",
+	"semanticteethmatte":   "Teeth matte",
+	"semanticglassesmatte": "Glasses matte",
+	"semanticskymatte":     "Sky matte",
+	"hdrgainmap":           "HDR gain map",
+}
+
+func auxKind(urn string) string {
+	if kind, ok := auxKinds[urn]; ok {
+		return kind
+	}
+	if strings.HasPrefix(urn, "urn:com:apple:photo:") {
+		if kind, ok := auxKinds[urn[strings.LastIndex(urn, ":")+1:]]; ok {
+			return kind
+		}
+	}
+	return "Auxiliary image (" + urn + ")"
+}
+
+// listItems lists the items of a HEIC file from its meta box, primary image
+// first. Grid tiles are counted into the image they make up rather than
+// listed.
+func listItems(ra io.ReaderAt) ([]heifItem, error) {
+	infos, err := heifItemInfos(ra)
+	if err != nil {
+		return nil, err
+	}
+	f := heif.Open(ra)
+	primary, err := f.PrimaryItem()
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[uint32]*heif.Item{}
+	tiles := map[uint32]bool{}
+	for _, info := range infos {
+		item, err := f.ItemByID(uint32(info.ItemID))
+		if err != nil {
+			continue
+		}
+		byID[item.ID] = item
+		if ref := item.Reference("dimg"); ref != nil {
+			for _, id := range ref.ToItemIDs {
+				tiles[id] = true
+			}
+		}
+	}
+
+	var items []heifItem
+	for id, item := range byID {
+		if tiles[id] {
+			continue
+		}
+		summary := heifItem{id: id, kind: item.Info.ItemType, size: itemSize(item)}
+		summary.width, summary.height, _ = item.VisualDimensions()
+		if ref := item.Reference("dimg"); ref != nil {
+			summary.tiles = len(ref.ToItemIDs)
+			for _, tile := range ref.ToItemIDs {
+				if t, ok := byID[tile]; ok {
+					summary.size += itemSize(t)
+				}
+			}
+		}
+
+		switch {
+		case id == primary.ID:
+			summary.kind, summary.converted = "Primary image", true
+		case item.Info.ItemType == "Exif":
+			summary.kind, summary.converted = "EXIF", true
+		case item.Info.ItemType == "mime" && item.Info.ContentType == xmpContentType:
+			summary.kind, summary.converted = "XMP", true
+		case item.Info.ItemType == "mime":
+			summary.kind = "Metadata (" + item.Info.ContentType + ")"
+		case item.Reference("auxl") != nil:
+			summary.kind = auxKind(auxType(item))
+		case item.Reference("thmb") != nil:
+			summary.kind = "Thumbnail"
+		case summary.width > 0:
+			summary.kind = "Image (" + item.Info.ItemType + ")"
+		}
+		items = append(items, summary)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if (items[i].id == primary.ID) != (items[j].id == primary.ID) {
+			return items[i].id == primary.ID
+		}
+		return items[i].id < items[j].id
+	})
+	return items, nil
+}
+
+// itemSize is the number of bytes an item's extents occupy.
+func itemSize(item *heif.Item) int64 {
+	if item.Location == nil {
+		return 0
+	}
+	var size int64
+	for _, extent := range item.Location.Extents {
+		size += int64(extent.Length)
+	}
+	return size
+}
+
+// auxType returns the URN of an auxiliary image's auxC property.
+func auxType(item *heif.Item) string {
+	for _, prop := range item.Properties {
+		if !prop.Type().EqualString("auxC") {
+			continue
+		}
+		body, err := io.ReadAll(prop.Body())
+		if err != nil || len(body) < 4 {
+			break
+		}
+		urn, _, _ := bytes.Cut(body[4:], []byte{0}) // after the version and flags
+		return string(urn)
+	}
+	return "unknown"
+}
+
+// appleContentIdentifier returns the Live Photo pairing identifier stored in
+// the Apple maker note (tag 0x11), which the paired video's metadata repeats.
+func appleContentIdentifier(rawExif []byte) string {
+	return appleMakerNoteString(rawExif, 0x11)
+}
+
+// appleMakerNoteString returns a string tag of the Apple maker note, or "".
+func appleMakerNoteString(rawExif []byte, tag uint16) string {
+	if len(rawExif) == 0 {
+		return ""
+	}
+	block, err := parseExifBlock(rawExif)
+	if err != nil {
+		return ""
+	}
+	note := findEntry(block.exif, 0x927c)
+	// "Apple iOS\0", a version and the "MM" byte order mark precede an IFD
+	// whose offsets are relative to the start of the maker note.
+	if note == nil || !bytes.HasPrefix(note.value, []byte("Apple iOS\x00")) || len(note.value) < 16 || string(note.value[12:14]) != "MM" {
+		return ""
+	}
+	entries, _, _, err := readIFD(note.value, binary.BigEndian, 14)
+	if err != nil {
+		return ""
+	}
+	if entry := findEntry(entries, tag); entry != nil && entry.typ == 2 {
+		return string(bytes.TrimRight(entry.value, "\x00"))
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListItems(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := listItems(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want the primary image and its thumbnail: %+v", len(items), items)
+	}
+	if p := items[0]; p.kind != "Primary image" || p.width != 1596 || p.height != 1064 || !p.converted || p.size == 0 {
+		t.Errorf("unexpected primary item %+v", p)
+	}
+	if th := items[1]; th.kind != "Thumbnail" || th.converted {
+		t.Errorf("unexpected thumbnail item %+v", th)
+	}
+}
+
+func TestAuxKind(t *testing.T) {
+	tests := map[string]string{
+		"urn:mpeg:hevc:2015:auxid:2":                        "Depth map",
+		"urn:com:apple:photo:2020:aux:hdrgainmap":           "HDR gain map",
+		"urn:com:apple:photo:2018:aux:portraiteffectsmatte": "Portrait matte",
+		"urn:example:aux:custom":                            "Auxiliary image (urn:example:aux:custom)",
+	}
+	for urn, want := range tests {
+		if got := auxKind(urn); got != want {
+			t.Errorf("%s: got %q, want %q", urn, got, want)
+		}
+	}
+}
+
+// testAppleMakerNote builds an Apple maker note holding one string tag.
+func testAppleMakerNote(tag uint16, value string) []byte {
+	note := append([]byte("Apple iOS\x00"), 0, 1, 'M', 'M')
+	note = binary.BigEndian.AppendUint16(note, 1)
+	note = binary.BigEndian.AppendUint16(note, tag)
+	note = binary.BigEndian.AppendUint16(note, 2)
+	note = binary.BigEndian.AppendUint32(note, uint32(len(value)+1))
+	note = binary.BigEndian.AppendUint32(note, uint32(len(note)+8)) // value follows the next-IFD offset
+	note = binary.BigEndian.AppendUint32(note, 0)
+	return append(note, value+"\x00"...)
+}
+
+func TestAppleContentIdentifier(t *testing.T) {
+	const id = "0B1F6F8E-3C2A-4B4E-9A57-1D2C3E4F5A6B"
+	note := testAppleMakerNote(0x11, id)
+	raw := buildTestExif(nil, []testTag{{id: 0x927c, typ: 7, count: uint32(len(note)), value: note}}, nil)
+	if got := appleContentIdentifier(raw); got != id {
+		t.Errorf("got %q, want %q", got, id)
+	}
+	if got := appleContentIdentifier(buildTestExif([]testTag{asciiTag(0x010f, "Apple")}, nil, nil)); got != "" {
+		t.Errorf("got %q without a maker note", got)
+	}
+}
+
+func TestWriteInfoItems(t *testing.T) {
+	var out bytes.Buffer
+	writeInfo(&out, "IMG.HEIC", sourceInfo{size: 2048, items: []heifItem{
+		{kind: "Primary image", width: 4032, height: 3024, tiles: 48, size: 2 << 20, converted: true},
+		{kind: "HDR gain map", width: 2016, height: 1512, size: 100 << 10},
+	}})
+	got := out.String()
+	if !strings.Contains(got, "Primary image    4032x3024, 48 tiles, 2.0MB\n") || !strings.Contains(got, "HDR gain map     2016x1512, 100.0KB, not converted\n") {
+		t.Errorf("unexpected info output:\n%s", got)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job priorities for `heictojpeg queue add`. Any integer is accepted; files
+// of higher priority jobs are converted first, so an interactive request
+// overtakes a bulk import that is already running.
+const (
+	priorityBulk        = 0
+	priorityInteractive = 10
+)
+
+// Job states reported by the control API.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobCancelled = "cancelled"
+)
+
+// fileFailed is the state of a file whose conversion failed. Files otherwise
+// go through the job states.
+const fileFailed = "failed"
+
+var (
+	errUnknownJob  = errors.New("unknown job")
+	errJobFinished = errors.New("job already finished")
+)
+
+// jobInfo is a job as the control API reports it.
+type jobInfo struct {
+	ID        int       `json:"id"`
+	Priority  int       `json:"priority"`
+	Status    string    `json:"status"`
+	Files     int       `json:"files"`
+	Done      int       `json:"done"`
+	Failed    int       `json:"failed"`
+	Submitted time.Time `json:"submitted"`
+}
+
+// fileStatus is the progress of one file of a job.
+type fileStatus struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	Output string `json:"output,omitempty"` // relative to the output directory
+	Error  string `json:"error,omitempty"`
+	Code   string `json:"code,omitempty"`  // errorCode of Error
+	PHash  string `json:"phash,omitempty"` // with --compute-phash
+}
+
+// jobDetail is a job with the progress of each of its files, as GET
+// /jobs/{id} reports it.
+type jobDetail struct {
+	jobInfo
+	Items []fileStatus `json:"items"`
+}
+
+// job is one submission to the server: source files converted at a
+// priority. Files of an uploaded archive are extracted into upload, which is
+// removed once the job is over.
+type job struct {
+	info    jobInfo
+	files   []fileStatus
+	running int
+	upload  string
+}
+
+// task is a file handed to a worker.
+type task struct {
+	job, index int
+	path       string
+}
+
+func (info jobInfo) active() bool {
+	return info.Status == jobQueued || info.Status == jobRunning
+}
+
+// queuedFile is one file of a job waiting for a worker.
+type queuedFile struct {
+	job   *job
+	index int
+}
+
+// fileHeap orders queued files by job priority, then by submission, then by
+// their position in the job.
+type fileHeap []queuedFile
+
+func (h fileHeap) Len() int { return len(h) }
+func (h fileHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.job.info.Priority != b.job.info.Priority {
+		return a.job.info.Priority > b.job.info.Priority
+	}
+	if a.job.info.ID != b.job.info.ID {
+		return a.job.info.ID < b.job.info.ID
+	}
+	return a.index < b.index
+}
+func (h fileHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *fileHeap) Push(x interface{}) { *h = append(*h, x.(queuedFile)) }
+func (h *fileHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// jobQueue holds the jobs of a server and hands their files to workers in
+// priority order, unless paused. Finished jobs are kept so they can still
+// be listed.
+type jobQueue struct {
+	mu     sync.Mutex
+	ready  *sync.Cond
+	jobs   map[int]*job
+	files  fileHeap
+	nextID int
+	closed bool
+	pause  *pauseControl
+	now    func() time.Time
+}
+
+func newJobQueue() *jobQueue {
+	q := &jobQueue{jobs: map[int]*job{}, nextID: 1, pause: newPauseControl(), now: time.Now}
+	q.ready = sync.NewCond(&q.mu)
+	return q
+}
+
+// submit queues paths as a new job.
+func (q *jobQueue) submit(paths []string, priority int) jobInfo {
+	return q.submitUpload(paths, priority, "")
+}
+
+// submitUpload queues the files extracted from an uploaded archive into
+// the directory upload as a new job.
+func (q *jobQueue) submitUpload(paths []string, priority int, upload string) jobInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := &job{
+		info:   jobInfo{ID: q.nextID, Priority: priority, Status: jobQueued, Files: len(paths), Submitted: q.now()},
+		files:  make([]fileStatus, len(paths)),
+		upload: upload,
+	}
+	for i, path := range paths {
+		j.files[i] = fileStatus{Path: path, Status: jobQueued}
+	}
+	q.nextID++
+	q.jobs[j.info.ID] = j
+	if len(paths) == 0 {
+		j.info.Status = jobDone
+		q.settle(j)
+		return j.info
+	}
+	for i := range paths {
+		heap.Push(&q.files, queuedFile{job: j, index: i})
+	}
+	q.ready.Broadcast()
+	return j.info
+}
+
+// next blocks until a file is ready and the queue is not paused and returns
+// it, or returns false once the queue is closed.
+func (q *jobQueue) next() (task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for len(q.files) > 0 && !q.pause.isPaused() {
+			f := heap.Pop(&q.files).(queuedFile)
+			if !f.job.info.active() {
+				continue // cancelled
+			}
+			f.job.info.Status = jobRunning
+			f.job.files[f.index].Status = jobRunning
+			f.job.running++
+			return task{job: f.job.info.ID, index: f.index, path: f.job.files[f.index].Path}, true
+		}
+		if q.closed {
+			return task{}, false
+		}
+		q.ready.Wait()
+	}
+}
+
+// finish records the outcome of a task returned by next: its output relative
+// to the output directory and perceptual hash, or the error it failed with.
+func (q *jobQueue) finish(t task, ev pathEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.jobs[t.job]
+	j.running--
+	j.info.Done++
+	f := &j.files[t.index]
+	if ev.Err != nil {
+		j.info.Failed++
+		f.Status, f.Error, f.Code = fileFailed, ev.Err.Error(), errorCode(ev.Err)
+	} else {
+		f.Status, f.Output, f.PHash = jobDone, ev.Output, ev.PHash
+	}
+	if j.info.Status == jobRunning && j.info.Done == j.info.Files {
+		j.info.Status = jobDone
+	}
+	q.settle(j)
+}
+
+// setPaused pauses or resumes handing out files, and reports whether that
+// changed anything. Files already being converted finish.
+func (q *jobQueue) setPaused(paused bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if paused {
+		return q.pause.pause()
+	}
+	q.ready.Broadcast()
+	return q.pause.resume()
+}
+
+// cancel stops a job. Its files already being converted finish; the rest are
+// dropped.
+func (q *jobQueue) cancel(id int) (jobInfo, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return jobInfo{}, errUnknownJob
+	}
+	if !j.info.active() {
+		return j.info, errJobFinished
+	}
+	j.info.Status = jobCancelled
+	for i := range j.files {
+		if j.files[i].Status == jobQueued {
+			j.files[i].Status = jobCancelled
+		}
+	}
+	q.settle(j)
+	return j.info, nil
+}
+
+// settle removes the extracted upload of a job once it is over and none of
+// its files are being converted.
+func (q *jobQueue) settle(j *job) {
+	if j.upload != "" && !j.info.active() && j.running == 0 {
+		os.RemoveAll(j.upload)
+		j.upload = ""
+	}
+}
+
+// detail returns a job with the progress of each file.
+func (q *jobQueue) detail(id int) (jobDetail, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return jobDetail{}, errUnknownJob
+	}
+	return jobDetail{jobInfo: j.info, Items: append([]fileStatus(nil), j.files...)}, nil
+}
+
+// list returns the unfinished jobs in the order their files will be
+// converted, followed by the finished ones, newest first.
+func (q *jobQueue) list() []jobInfo {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]jobInfo, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, j.info)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		a, b := jobs[i], jobs[j]
+		switch {
+		case a.active() != b.active():
+			return a.active()
+		case !a.active():
+			return a.ID > b.ID
+		case a.Priority != b.Priority:
+			return a.Priority > b.Priority
+		}
+		return a.ID < b.ID
+	})
+	return jobs
+}
+
+// close wakes the workers blocked in next. Files still queued are dropped.
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.files = nil
+	q.mu.Unlock()
+	q.ready.Broadcast()
+}
+
+// removeUploads removes the extracted uploads of every job, once the workers
+// have stopped.
+func (q *jobQueue) removeUploads() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.upload != "" {
+			os.RemoveAll(j.upload)
+			j.upload = ""
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJobQueuePriority(t *testing.T) {
+	q := newJobQueue()
+	bulk := q.submit([]string{"/b/1.heic", "/b/2.heic", "/b/3.heic"}, priorityBulk)
+
+	first, _ := q.next()
+	if first.job != bulk.ID || first.path != "/b/1.heic" {
+		t.Fatalf("got job %d %s, want the first bulk file", first.job, first.path)
+	}
+	q.finish(first, pathEvent{Output: "1.jpg"})
+
+	interactive := q.submit([]string{"/i/1.heic"}, priorityInteractive)
+	if jobs := q.list(); jobs[0].ID != interactive.ID || jobs[1].Status != jobRunning {
+		t.Errorf("list = %+v, want the interactive job first and the bulk job running", jobs)
+	}
+	jumped, _ := q.next()
+	if jumped.job != interactive.ID || jumped.path != "/i/1.heic" {
+		t.Fatalf("got job %d %s, want the interactive file to jump ahead", jumped.job, jumped.path)
+	}
+	q.finish(jumped, pathEvent{Err: errors.New("broken")})
+
+	for _, want := range []string{"/b/2.heic", "/b/3.heic"} {
+		next, _ := q.next()
+		if next.path != want {
+			t.Fatalf("got %s, want %s", next.path, want)
+		}
+		q.finish(next, pathEvent{})
+	}
+	jobs := q.list()
+	if jobs[0].ID != interactive.ID || jobs[0].Status != jobDone || jobs[0].Failed != 1 {
+		t.Errorf("interactive job = %+v, want done with 1 failure, listed first as the newest", jobs[0])
+	}
+	if jobs[1].Status != jobDone || jobs[1].Done != 3 {
+		t.Errorf("bulk job = %+v, want done", jobs[1])
+	}
+}
+
+func TestJobQueueCancel(t *testing.T) {
+	q := newJobQueue()
+	first := q.submit([]string{"/a/1.heic", "/a/2.heic"}, priorityBulk)
+	second := q.submit([]string{"/b/1.heic"}, priorityBulk)
+
+	running, _ := q.next()
+	if _, err := q.cancel(first.ID); err != nil {
+		t.Fatal(err)
+	}
+	q.finish(running, pathEvent{Output: "1.jpg"})
+	next, _ := q.next()
+	if next.job != second.ID || next.path != "/b/1.heic" {
+		t.Fatalf("got job %d %s, want the cancelled job's remaining file dropped", next.job, next.path)
+	}
+	q.finish(next, pathEvent{})
+
+	if info, err := q.cancel(first.ID); !errors.Is(err, errJobFinished) || info.Status != jobCancelled || info.Done != 1 {
+		t.Errorf("cancel again = %+v, %v", info, err)
+	}
+	if _, err := q.cancel(99); !errors.Is(err, errUnknownJob) {
+		t.Errorf("cancel unknown = %v", err)
+	}
+
+	q.close()
+	if _, ok := q.next(); ok {
+		t.Error("next returned a file after close")
+	}
+}
+
+func TestJobQueueDetail(t *testing.T) {
+	q := newJobQueue()
+	upload := t.TempDir()
+	info := q.submitUpload([]string{"/u/1.heic", "/u/2.heic", "/u/3.heic"}, priorityBulk, upload)
+
+	converted, _ := q.next()
+	q.finish(converted, pathEvent{Output: "job-1/1.jpg"})
+	failed, _ := q.next()
+	q.finish(failed, pathEvent{Err: errors.New("broken")})
+	running, _ := q.next()
+	q.cancel(info.ID)
+
+	detail, err := q.detail(info.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []fileStatus{
+		{Path: "/u/1.heic", Status: jobDone, Output: "job-1/1.jpg"},
+		{Path: "/u/2.heic", Status: fileFailed, Error: "broken", Code: codeUnknown},
+		{Path: "/u/3.heic", Status: jobRunning},
+	}
+	for i, f := range detail.Items {
+		if f != want[i] {
+			t.Errorf("file %d = %+v, want %+v", i, f, want[i])
+		}
+	}
+	if _, err := os.Stat(upload); err != nil {
+		t.Error("upload removed while a file is still being converted")
+	}
+	q.finish(running, pathEvent{Output: "job-1/3.jpg"})
+	if _, err := os.Stat(upload); !os.IsNotExist(err) {
+		t.Errorf("upload not removed after the job ended: %v", err)
+	}
+	if _, err := q.detail(42); !errors.Is(err, errUnknownJob) {
+		t.Errorf("detail unknown = %v", err)
+	}
+}
+
+func TestJobQueuePause(t *testing.T) {
+	q := newJobQueue()
+	running := q.submit([]string{"/a/1.heic", "/a/2.heic"}, priorityBulk)
+	first, _ := q.next()
+	if !q.setPaused(true) || q.setPaused(true) {
+		t.Error("pausing twice should change the state once")
+	}
+
+	// Files being converted finish; no new ones start.
+	q.finish(first, pathEvent{Output: "1.jpg"})
+	q.submit([]string{"/b/1.heic"}, priorityInteractive)
+	handed := make(chan task)
+	go func() {
+		next, _ := q.next()
+		handed <- next
+	}()
+	select {
+	case next := <-handed:
+		t.Fatalf("paused queue handed out %s", next.path)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if !q.setPaused(false) || q.setPaused(false) {
+		t.Error("resuming twice should change the state once")
+	}
+	select {
+	case next := <-handed:
+		if next.path != "/b/1.heic" {
+			t.Errorf("got %s after resuming, want the interactive file", next.path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no file handed out after resuming")
+	}
+	if jobs := q.list(); jobs[1].ID != running.ID || jobs[1].Done != 1 {
+		t.Errorf("list = %+v", jobs)
+	}
+
+	// Closing ends a paused queue too.
+	q.setPaused(true)
+	ended := make(chan bool)
+	go func() {
+		_, ok := q.next()
+		ended <- ok
+	}()
+	q.close()
+	if <-ended {
+		t.Error("closed queue handed out a file")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Values of --jpeg-encoder.
+const (
+	encoderStdlib  = "stdlib"        // image/jpeg, always available
+	encoderMozJPEG = "mozjpeg"       // trellis quantization, smaller files
+	encoderTurbo   = "libjpeg-turbo" // SIMD, faster on large images
+)
+
+// jpegEncoderNames are the --jpeg-encoder values, in the order check lists
+// them.
+var jpegEncoderNames = []string{encoderStdlib, encoderMozJPEG, encoderTurbo}
+
+// nativeEncoderDirs are where the native encoders install their cjpeg when
+// it is kept off PATH, as Homebrew does for both and mozjpeg's own packages
+// do so it does not shadow the system libjpeg.
+var nativeEncoderDirs = map[string][]string{
+	encoderMozJPEG: {"/opt/mozjpeg/bin", "/opt/homebrew/opt/mozjpeg/bin", "/usr/local/opt/mozjpeg/bin"},
+	encoderTurbo:   {"/opt/libjpeg-turbo/bin", "/opt/homebrew/opt/jpeg-turbo/bin", "/usr/local/opt/jpeg-turbo/bin"},
+}
+
+// jpegEncoder is a native JPEG encoder backend: the cjpeg tool of mozjpeg
+// or libjpeg-turbo, fed the pixels as a PPM on its standard input. image/jpeg, the default, has
+// no jpegEncoder; a nil one stands for it.
+type jpegEncoder struct {
+	name    string // encoderMozJPEG or encoderTurbo
+	path    string // of its cjpeg
+	version string
+}
+
+func isValidJPEGEncoder(name string) bool {
+	for _, known := range jpegEncoderNames {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// findJPEGEncoder looks for the cjpeg of the native encoder name, on PATH
+// and in nativeEncoderDirs, and returns the first that identifies as it.
+func findJPEGEncoder(name string) (*jpegEncoder, error) {
+	var candidates []string
+	if path, err := exec.LookPath("cjpeg"); err == nil {
+		candidates = append(candidates, path)
+	}
+	for _, dir := range nativeEncoderDirs[name] {
+		candidates = append(candidates, filepath.Join(dir, "cjpeg"))
+	}
+	for _, path := range candidates {
+		if kind, version := cjpegVersion(path); kind == name {
+			return &jpegEncoder{name: name, path: path, version: version}, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s cjpeg found on PATH or in %s", name, strings.Join(nativeEncoderDirs[name], ", "))
+}
+
+// cjpegVersion runs cjpeg -version, which prints e.g. "mozjpeg version
+// 4.1.1 (build 20230101)" or "libjpeg-turbo version 3.0.0 (build ...)",
+// and returns which encoder it is and its version.
+func cjpegVersion(path string) (kind, version string) {
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return "", ""
+	}
+	var out bytes.Buffer
+	cmd := exec.Command(path, "-version")
+	cmd.Stdout, cmd.Stderr = &out, &out
+	cmd.Stdin = strings.NewReader("")
+	cmd.Run()
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[1] == "version" && (fields[0] == encoderMozJPEG || fields[0] == encoderTurbo) {
+			return fields[0], fields[2]
+		}
+	}
+	return "", ""
+}
+
+func (e *jpegEncoder) String() string {
+	return fmt.Sprintf("%s %s (%s)", e.name, e.version, e.path)
+}
+
+// encode encodes img at quality into w, start marker included, through
+// cjpeg. --optimize-huffman and --restart-interval are passed on to it;
+// mozjpeg optimizes its tables anyway. The JFIF segment cjpeg writes is
+// left out, since the metadata segments a run writes come first.
+func (e *jpegEncoder) encode(w io.Writer, img image.Image, quality int, opts options) error {
+	args := []string{"-quality", strconv.Itoa(quality)}
+	if opts.optimizeHuffman {
+		args = append(args, "-optimize")
+	}
+	if opts.restartInterval > 0 {
+		args = append(args, "-restart", strconv.Itoa(opts.restartInterval)+"B")
+	}
+	var out, stderr bytes.Buffer
+	cmd := exec.Command(e.path, args...)
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	writeErr := writeRaw(stdin, img, formatPPM)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s: %v: %s", e.name, err, strings.TrimSpace(stderr.String()))
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	_, err = w.Write(stripJFIF(out.Bytes()))
+	return err
+}
+
+// stripJFIF drops the APP0 JFIF segment that follows the start marker of
+// data, if there is one.
+func stripJFIF(data []byte) []byte {
+	if len(data) < 11 || data[2] != 0xff || data[3] != 0xe0 || string(data[6:11]) != "JFIF\x00" {
+		return data
+	}
+	end := 4 + (int(data[4])<<8 | int(data[5]))
+	if end > len(data) {
+		return data
+	}
+	return append(data[:2:2], data[end:]...)
+}
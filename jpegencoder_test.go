@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// jfifSegment is the APP0 segment cjpeg writes after the start marker.
+var jfifSegment = []byte{0xff, 0xe0, 0, 16, 'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0}
+
+// fakeCJPEG puts a cjpeg on PATH that identifies as mozjpeg, records its
+// arguments in args and writes a stdlib JPEG of a 16x16 image, with a JFIF
+// segment, as real ones do.
+func fakeCJPEG(t *testing.T) (dir string, want []byte) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+	dir = t.TempDir()
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 16, 16)), nil)
+	want = encoded.Bytes()
+	withJFIF := append(append(append([]byte{}, want[:2]...), jfifSegment...), want[2:]...)
+	if err := os.WriteFile(filepath.Join(dir, "out.jpg"), withJFIF, 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := `#!/bin/sh
+if [ "$1" = "-version" ]; then echo "mozjpeg version 4.1.1 (build 20230101)" >&2; exit 0; fi
+echo "$@" > "` + filepath.Join(dir, "args") + `"
+cat > /dev/null
+cat "` + filepath.Join(dir, "out.jpg") + `"
+`
+	if err := os.WriteFile(filepath.Join(dir, "cjpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir, want
+}
+
+func TestNativeJPEGEncoder(t *testing.T) {
+	dir, want := fakeCJPEG(t)
+	opts, err := parseOptions([]string{"--jpeg-encoder", "mozjpeg", "--optimize-huffman", "--restart-interval", "4", "."}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.jpegEncoder == nil || opts.jpegEncoder.version != "4.1.1" {
+		t.Fatalf("encoder not found: %v", opts.jpegEncoder)
+	}
+
+	var out bytes.Buffer
+	if err := encodeJPEGImage(&out, image.NewRGBA(image.Rect(0, 0, 16, 16)), 75, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("encoder output not passed on without its JFIF segment")
+	}
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if got := strings.TrimSpace(string(args)); got != "-quality 75 -optimize -restart 4B" {
+		t.Errorf("cjpeg arguments %q", got)
+	}
+}
+
+func TestJPEGEncoderNotFound(t *testing.T) {
+	fakeCJPEG(t)
+	for _, dir := range nativeEncoderDirs[encoderTurbo] {
+		if _, err := os.Stat(filepath.Join(dir, "cjpeg")); err == nil {
+			t.Skip("libjpeg-turbo is installed")
+		}
+	}
+	// The cjpeg on PATH is mozjpeg's.
+	if _, err := findJPEGEncoder(encoderTurbo); err == nil {
+		t.Error("mozjpeg's cjpeg taken for libjpeg-turbo")
+	}
+	if _, err := parseOptions([]string{"--jpeg-encoder", "guetzli", "."}, io.Discard); err == nil {
+		t.Error("unknown --jpeg-encoder accepted")
+	}
+}
+
+func TestStripJFIF(t *testing.T) {
+	data := append([]byte{0xff, 0xd8}, jfifSegment...)
+	data = append(data, 0xff, 0xdb)
+	if got := stripJFIF(data); !bytes.Equal(got, []byte{0xff, 0xd8, 0xff, 0xdb}) {
+		t.Errorf("got % x", got)
+	}
+	plain := []byte{0xff, 0xd8, 0xff, 0xe1, 0, 2}
+	if got := stripJFIF(plain); !bytes.Equal(got, plain) {
+		t.Errorf("EXIF segment removed: % x", got)
+	}
+}
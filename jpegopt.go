@@ -0,0 +1,634 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math/bits"
+)
+
+// maxRestartInterval is the largest --restart-interval a DRI segment can
+// hold.
+const maxRestartInterval = 0xffff
+
+var errUnsupportedJPEG = errors.New("only single-scan baseline JPEGs can be re-coded")
+
+// encodeJPEGImage encodes img at quality into w, start marker included, with
+// the --jpeg-encoder. With --optimize-huffman or --restart-interval the
+// output of image/jpeg is then re-coded; it offers neither itself.
+func encodeJPEGImage(w io.Writer, img image.Image, quality int, opts options) error {
+	if opts.jpegEncoder != nil {
+		return opts.jpegEncoder.encode(w, img, quality, opts)
+	}
+	if !opts.optimizeHuffman && opts.restartInterval == 0 {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: quality}); err != nil {
+		return err
+	}
+	recoded, err := recodeJPEG(encoded.Bytes(), opts.optimizeHuffman, opts.restartInterval)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(recoded)
+	return err
+}
+
+// recodeJPEG rewrites the entropy-coded data of a baseline JPEG without
+// touching its coefficients, like jpegtran: with Huffman tables built for
+// this image rather than the example tables of the standard (optimize), and
+// with a restart marker every restartInterval MCUs, so a damaged file only
+// loses the rows up to the next marker.
+func recodeJPEG(data []byte, optimize bool, restartInterval int) ([]byte, error) {
+	j, err := parseBaselineJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+	dcTables, acTables := j.dcTables, j.acTables
+	if optimize {
+		counter := &entropyWriter{counting: true}
+		if err := j.transcode(counter, restartInterval); err != nil {
+			return nil, err
+		}
+		for id := range dcTables {
+			if dcTables[id] != nil {
+				dcTables[id] = optimalTable(&counter.dcFreq[id])
+			}
+			if acTables[id] != nil {
+				acTables[id] = optimalTable(&counter.acFreq[id])
+			}
+		}
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write([]byte{0xff, markerSOI})
+	out.Write(j.header)
+	for class, tables := range [][4]*huffTable{dcTables, acTables} {
+		for id, t := range tables {
+			if t != nil {
+				writeDHT(out, class, id, t)
+			}
+		}
+	}
+	if restartInterval > 0 {
+		out.Write([]byte{0xff, 0xdd, 0, 4, byte(restartInterval >> 8), byte(restartInterval)})
+	}
+	out.Write(j.scanHeader)
+
+	w := &entropyWriter{out: out}
+	for id := range dcTables {
+		if dcTables[id] != nil {
+			w.dcCodes[id] = dcTables[id].codes()
+		}
+		if acTables[id] != nil {
+			w.acCodes[id] = acTables[id].codes()
+		}
+	}
+	if err := j.transcode(w, restartInterval); err != nil {
+		return nil, err
+	}
+	w.flush()
+	out.Write([]byte{0xff, markerEOI})
+	return out.Bytes(), nil
+}
+
+// huffTable is a Huffman table as a DHT segment stores it: how many codes
+// there are of each length from 1 to 16 bits, and the symbols in code order.
+type huffTable struct {
+	counts  [16]int
+	symbols []byte
+}
+
+type huffCode struct {
+	code uint32
+	size uint
+}
+
+// codes returns the code of each symbol (JPEG annex C).
+func (t *huffTable) codes() *[256]huffCode {
+	var codes [256]huffCode
+	code, k := uint32(0), 0
+	for length := 1; length <= 16; length++ {
+		for i := 0; i < t.counts[length-1]; i++ {
+			codes[t.symbols[k]] = huffCode{code, uint(length)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return &codes
+}
+
+// huffDecoder decodes the codes of a table (JPEG annex F.2.2.3).
+type huffDecoder struct {
+	mincode, maxcode [17]int32
+	valptr           [17]int
+	symbols          []byte
+}
+
+func newHuffDecoder(t *huffTable) *huffDecoder {
+	d := &huffDecoder{symbols: t.symbols}
+	code, k := int32(0), 0
+	for length := 1; length <= 16; length++ {
+		n := t.counts[length-1]
+		d.maxcode[length] = -1
+		if n > 0 {
+			d.valptr[length], d.mincode[length] = k, code
+			code += int32(n)
+			k += n
+			d.maxcode[length] = code - 1
+		}
+		code <<= 1
+	}
+	return d
+}
+
+// optimalTable builds the Huffman table for symbol frequencies, with code
+// lengths limited to 16 bits, following JPEG annex K.2. freq[256] is a
+// reserved symbol that keeps any real code from being all ones.
+func optimalTable(freq *[257]int) *huffTable {
+	var f [257]int
+	copy(f[:], freq[:])
+	f[256] = 1
+	var size [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+	for {
+		// The two least frequent symbols, preferring higher indexes.
+		c1, c2 := -1, -1
+		for i, n := range f {
+			if n == 0 {
+				continue
+			}
+			if c1 < 0 || n <= f[c1] {
+				c2, c1 = c1, i
+			} else if c2 < 0 || n <= f[c2] {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		f[c1] += f[c2]
+		f[c2] = 0
+		size[c1]++
+		for others[c1] >= 0 {
+			c1 = others[c1]
+			size[c1]++
+		}
+		others[c1] = c2
+		size[c2]++
+		for others[c2] >= 0 {
+			c2 = others[c2]
+			size[c2]++
+		}
+	}
+
+	var count [33]int
+	for _, s := range size {
+		if s > 0 {
+			count[s]++
+		}
+	}
+	// Move codes longer than 16 bits up the tree.
+	for i := 32; i > 16; i-- {
+		for count[i] > 0 {
+			j := i - 2
+			for count[j] == 0 {
+				j--
+			}
+			count[i] -= 2
+			count[i-1]++
+			count[j+1] += 2
+			count[j]--
+		}
+	}
+	// Drop the reserved symbol, which has one of the longest codes.
+	i := 16
+	for count[i] == 0 {
+		i--
+	}
+	count[i]--
+
+	t := &huffTable{}
+	copy(t.counts[:], count[1:17])
+	for length := 1; length <= 32; length++ {
+		for symbol := 0; symbol < 256; symbol++ {
+			if size[symbol] == length {
+				t.symbols = append(t.symbols, byte(symbol))
+			}
+		}
+	}
+	return t
+}
+
+func writeDHT(out *bytes.Buffer, class, id int, t *huffTable) {
+	length := 2 + 1 + 16 + len(t.symbols)
+	out.Write([]byte{0xff, 0xc4, byte(length >> 8), byte(length), byte(class<<4 | id)})
+	for _, n := range t.counts {
+		out.WriteByte(byte(n))
+	}
+	out.Write(t.symbols)
+}
+
+// frameComponent is a colour component of a frame header.
+type frameComponent struct{ id, h, v int }
+
+// scanComponent is a colour component of a baseline frame and the tables
+// its scan uses.
+type scanComponent struct {
+	h, v       int
+	dc, ac     int
+	blocksWide int // for single-component scans
+	blocksHigh int
+}
+
+// baselineJPEG is a single-scan baseline JPEG taken apart for re-coding.
+type baselineJPEG struct {
+	header     []byte // segments before the scan, without DHT and DRI
+	scanHeader []byte // the SOS segment
+	entropy    []byte // entropy-coded data and whatever follows it
+	restart    int
+	dcTables   [4]*huffTable
+	acTables   [4]*huffTable
+	components []scanComponent // in scan order
+	mcusWide   int
+	mcusHigh   int
+}
+
+func parseBaselineJPEG(data []byte) (*baselineJPEG, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, fmt.Errorf("not a JPEG")
+	}
+	j := &baselineJPEG{}
+	var frame []frameComponent
+	var width, height int
+	rest := data[2:]
+	for {
+		if len(rest) < 4 || rest[0] != 0xff {
+			return nil, fmt.Errorf("malformed JPEG")
+		}
+		marker := rest[1]
+		length := int(rest[2])<<8 | int(rest[3])
+		if length < 2 || len(rest) < 2+length {
+			return nil, fmt.Errorf("malformed JPEG")
+		}
+		segment, payload := rest[:2+length], rest[4:2+length]
+		rest = rest[2+length:]
+
+		switch {
+		case marker == 0xc0 || marker == 0xc1:
+			if len(payload) < 6 || payload[0] != 8 {
+				return nil, errUnsupportedJPEG
+			}
+			height, width = int(payload[1])<<8|int(payload[2]), int(payload[3])<<8|int(payload[4])
+			n := int(payload[5])
+			if len(payload) < 6+3*n || width == 0 || height == 0 {
+				return nil, fmt.Errorf("malformed JPEG frame")
+			}
+			for i := 0; i < n; i++ {
+				c := payload[6+3*i:]
+				frame = append(frame, frameComponent{id: int(c[0]), h: int(c[1] >> 4), v: int(c[1] & 15)})
+			}
+			j.header = append(j.header, segment...)
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			return nil, errUnsupportedJPEG
+		case marker == 0xc4:
+			for len(payload) > 0 {
+				if len(payload) < 17 || payload[0]>>4 > 1 || payload[0]&15 > 3 {
+					return nil, fmt.Errorf("malformed Huffman table")
+				}
+				t := &huffTable{}
+				total := 0
+				for i := range t.counts {
+					t.counts[i] = int(payload[1+i])
+					total += t.counts[i]
+				}
+				if len(payload) < 17+total {
+					return nil, fmt.Errorf("malformed Huffman table")
+				}
+				t.symbols = append([]byte(nil), payload[17:17+total]...)
+				if payload[0]>>4 == 0 {
+					j.dcTables[payload[0]&15] = t
+				} else {
+					j.acTables[payload[0]&15] = t
+				}
+				payload = payload[17+total:]
+			}
+		case marker == 0xdd:
+			if len(payload) < 2 {
+				return nil, fmt.Errorf("malformed restart interval")
+			}
+			j.restart = int(payload[0])<<8 | int(payload[1])
+		case marker == markerSOS:
+			return j, j.parseScan(segment, payload, rest, frame, width, height)
+		default:
+			j.header = append(j.header, segment...)
+		}
+	}
+}
+
+func (j *baselineJPEG) parseScan(segment, payload, entropy []byte, frame []frameComponent, width, height int) error {
+	if len(frame) == 0 || len(payload) < 1 {
+		return fmt.Errorf("malformed JPEG scan")
+	}
+	n := int(payload[0])
+	if len(payload) < 1+2*n+3 || n == 0 {
+		return fmt.Errorf("malformed JPEG scan")
+	}
+	if ss, se, a := payload[1+2*n], payload[2+2*n], payload[3+2*n]; ss != 0 || se != 63 || a != 0 {
+		return errUnsupportedJPEG
+	}
+	hmax, vmax := 1, 1
+	for _, c := range frame {
+		if c.h > hmax {
+			hmax = c.h
+		}
+		if c.v > vmax {
+			vmax = c.v
+		}
+	}
+	for i := 0; i < n; i++ {
+		id, tables := int(payload[1+2*i]), payload[2+2*i]
+		var sc *scanComponent
+		for _, c := range frame {
+			if c.id == id {
+				sc = &scanComponent{h: c.h, v: c.v, dc: int(tables >> 4), ac: int(tables & 15)}
+				// A component's own size in blocks, for single-component scans.
+				sc.blocksWide = ((width*c.h+hmax-1)/hmax + 7) / 8
+				sc.blocksHigh = ((height*c.v+vmax-1)/vmax + 7) / 8
+			}
+		}
+		if sc == nil || sc.dc > 3 || sc.ac > 3 || j.dcTables[sc.dc] == nil || j.acTables[sc.ac] == nil {
+			return fmt.Errorf("malformed JPEG scan")
+		}
+		j.components = append(j.components, *sc)
+	}
+	if n != len(frame) {
+		return errUnsupportedJPEG
+	}
+	j.scanHeader = segment
+	j.entropy = entropy
+	if n == 1 {
+		// Non-interleaved: every block is an MCU of its own.
+		c := &j.components[0]
+		c.h, c.v = 1, 1
+		j.mcusWide, j.mcusHigh = c.blocksWide, c.blocksHigh
+	} else {
+		j.mcusWide = (width + 8*hmax - 1) / (8 * hmax)
+		j.mcusHigh = (height + 8*vmax - 1) / (8 * vmax)
+	}
+	return nil
+}
+
+// transcode decodes the scan block by block and passes the blocks to w,
+// predicting DC values afresh every restartInterval MCUs.
+func (j *baselineJPEG) transcode(w *entropyWriter, restartInterval int) error {
+	var dc, ac [4]*huffDecoder
+	for id := range j.dcTables {
+		if j.dcTables[id] != nil {
+			dc[id] = newHuffDecoder(j.dcTables[id])
+		}
+		if j.acTables[id] != nil {
+			ac[id] = newHuffDecoder(j.acTables[id])
+		}
+	}
+	r := &entropyReader{data: j.entropy}
+	pred := make([]int32, len(j.components))    // of the source
+	outPred := make([]int32, len(j.components)) // of the output
+	var block [64]int32
+	mcus := j.mcusWide * j.mcusHigh
+	for mcu := 0; mcu < mcus; mcu++ {
+		if j.restart > 0 && mcu > 0 && mcu%j.restart == 0 {
+			if err := r.restart(); err != nil {
+				return err
+			}
+			for i := range pred {
+				pred[i] = 0
+			}
+		}
+		if restartInterval > 0 && mcu > 0 && mcu%restartInterval == 0 {
+			w.restart(mcu/restartInterval - 1)
+			for i := range outPred {
+				outPred[i] = 0
+			}
+		}
+		for i, c := range j.components {
+			for b := 0; b < c.h*c.v; b++ {
+				if err := r.decodeBlock(dc[c.dc], ac[c.ac], &block); err != nil {
+					return err
+				}
+				pred[i] += block[0]
+				block[0] = pred[i] - outPred[i]
+				outPred[i] = pred[i]
+				w.block(c.dc, c.ac, &block)
+			}
+		}
+	}
+	return nil
+}
+
+// entropyReader reads the bits of entropy-coded data, removing stuffed
+// zero bytes. Bits past the end of a segment read as zero.
+type entropyReader struct {
+	data []byte
+	pos  int
+	acc  uint32 // left-aligned
+	n    uint
+}
+
+func (r *entropyReader) fill() {
+	for r.n <= 24 {
+		var b byte
+		if r.pos < len(r.data) && (r.data[r.pos] != 0xff || r.pos+1 < len(r.data) && r.data[r.pos+1] == 0) {
+			b = r.data[r.pos]
+			r.pos++
+			if b == 0xff {
+				r.pos++ // stuffed zero
+			}
+		}
+		r.acc |= uint32(b) << (24 - r.n)
+		r.n += 8
+	}
+}
+
+func (r *entropyReader) bits(n uint) int32 {
+	if n == 0 {
+		return 0
+	}
+	if r.n < n {
+		r.fill()
+	}
+	v := int32(r.acc >> (32 - n))
+	r.acc <<= n
+	r.n -= n
+	return v
+}
+
+func (r *entropyReader) decode(d *huffDecoder) (byte, error) {
+	if r.n < 16 {
+		r.fill()
+	}
+	for length := uint(1); length <= 16; length++ {
+		code := int32(r.acc >> (32 - length))
+		if code <= d.maxcode[length] {
+			r.acc <<= length
+			r.n -= length
+			return d.symbols[d.valptr[length]+int(code-d.mincode[length])], nil
+		}
+	}
+	return 0, fmt.Errorf("invalid Huffman code in JPEG data")
+}
+
+// restart skips to the data after the next restart marker.
+func (r *entropyReader) restart() error {
+	r.acc, r.n = 0, 0
+	for r.pos+1 < len(r.data) && r.data[r.pos] == 0xff && r.data[r.pos+1] == 0xff {
+		r.pos++
+	}
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xff || r.data[r.pos+1]&0xf8 != 0xd0 {
+		return fmt.Errorf("missing restart marker in JPEG data")
+	}
+	r.pos += 2
+	return nil
+}
+
+// decodeBlock decodes a block's coefficients in zig-zag order, with its DC
+// difference in block[0].
+func (r *entropyReader) decodeBlock(dc, ac *huffDecoder, block *[64]int32) error {
+	*block = [64]int32{}
+	s, err := r.decode(dc)
+	if err != nil {
+		return err
+	}
+	if s > 11 {
+		return fmt.Errorf("invalid DC difference in JPEG data")
+	}
+	block[0] = extend(r.bits(uint(s)), uint(s))
+	for k := 1; k < 64; k++ {
+		rs, err := r.decode(ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), uint(rs&15)
+		if size == 0 {
+			if run != 15 {
+				break // end of block
+			}
+			k += 15
+			continue
+		}
+		k += run
+		if k > 63 {
+			return fmt.Errorf("invalid AC run in JPEG data")
+		}
+		block[k] = extend(r.bits(size), size)
+	}
+	return nil
+}
+
+// extend turns the size low bits of a coefficient into its value (JPEG
+// annex F.2.2.1).
+func extend(v int32, size uint) int32 {
+	if size > 0 && v < 1<<(size-1) {
+		return v - 1<<size + 1
+	}
+	return v
+}
+
+// entropyWriter encodes blocks, or with counting only tallies the symbols
+// they need for optimalTable.
+type entropyWriter struct {
+	counting         bool
+	dcFreq, acFreq   [4][257]int
+	dcCodes, acCodes [4]*[256]huffCode
+
+	out *bytes.Buffer
+	acc uint64
+	n   uint
+}
+
+func (w *entropyWriter) emit(code uint32, size uint) {
+	w.acc = w.acc<<size | uint64(code)&(1<<size-1)
+	w.n += size
+	for w.n >= 8 {
+		b := byte(w.acc >> (w.n - 8))
+		w.out.WriteByte(b)
+		if b == 0xff {
+			w.out.WriteByte(0)
+		}
+		w.n -= 8
+	}
+}
+
+// flush pads the last byte with one bits.
+func (w *entropyWriter) flush() {
+	if w.n > 0 {
+		w.emit(1<<(8-w.n)-1, 8-w.n)
+	}
+}
+
+func (w *entropyWriter) restart(m int) {
+	if w.counting {
+		return
+	}
+	w.flush()
+	w.out.Write([]byte{0xff, byte(0xd0 + m%8)})
+}
+
+func (w *entropyWriter) symbol(freq *[257]int, codes *[256]huffCode, s byte) {
+	if w.counting {
+		freq[s]++
+		return
+	}
+	w.emit(codes[s].code, codes[s].size)
+}
+
+// value writes a coefficient's size low bits, one less for negative values.
+func (w *entropyWriter) value(v int32, size uint) {
+	if w.counting || size == 0 {
+		return
+	}
+	if v < 0 {
+		v--
+	}
+	w.emit(uint32(v), size)
+}
+
+func (w *entropyWriter) block(dc, ac int, block *[64]int32) {
+	size := coefficientSize(block[0])
+	w.symbol(&w.dcFreq[dc], w.dcCodes[dc], byte(size))
+	w.value(block[0], size)
+	run := 0
+	for k := 1; k < 64; k++ {
+		if block[k] == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			w.symbol(&w.acFreq[ac], w.acCodes[ac], 0xf0)
+			run -= 16
+		}
+		size := coefficientSize(block[k])
+		w.symbol(&w.acFreq[ac], w.acCodes[ac], byte(run<<4)|byte(size))
+		w.value(block[k], size)
+		run = 0
+	}
+	if run > 0 {
+		w.symbol(&w.acFreq[ac], w.acCodes[ac], 0x00)
+	}
+}
+
+// coefficientSize is the number of bits of a coefficient's magnitude.
+func coefficientSize(v int32) uint {
+	if v < 0 {
+		v = -v
+	}
+	return uint(bits.Len32(uint32(v)))
+}
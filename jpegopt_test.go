@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+// testPhoto is an image with enough detail to exercise every kind of
+// Huffman symbol, with sides that are not multiples of the MCU size.
+func testPhoto(gray bool) image.Image {
+	r := image.Rect(0, 0, 45, 37)
+	var img interface {
+		image.Image
+		Set(x, y int, c color.Color)
+	}
+	if gray {
+		img = image.NewGray(r)
+	} else {
+		img = image.NewRGBA(r)
+	}
+	seed := uint32(1)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			seed = seed*1664525 + 1013904223
+			noise := uint8(seed >> 28)
+			img.Set(x, y, color.RGBA{uint8(x*5) + noise, uint8(y*7) ^ noise, uint8((x + y) * 3), 255})
+		}
+	}
+	return img
+}
+
+func decodeTestJPEG(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestRecodeJPEG(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, testPhoto(gray), &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		original := buf.Bytes()
+		want := decodeTestJPEG(t, original)
+
+		for _, tc := range []struct {
+			optimize bool
+			restart  int
+		}{{true, 0}, {false, 1}, {false, 4}, {true, 3}} {
+			recoded, err := recodeJPEG(original, tc.optimize, tc.restart)
+			if err != nil {
+				t.Fatalf("gray %v, %+v: %v", gray, tc, err)
+			}
+			got := decodeTestJPEG(t, recoded)
+			if got.Bounds() != want.Bounds() {
+				t.Fatalf("gray %v, %+v: bounds %v, want %v", gray, tc, got.Bounds(), want.Bounds())
+			}
+			for y := 0; y < want.Bounds().Dy(); y++ {
+				for x := 0; x < want.Bounds().Dx(); x++ {
+					if got.At(x, y) != want.At(x, y) {
+						t.Fatalf("gray %v, %+v: pixel %d,%d is %v, want %v", gray, tc, x, y, got.At(x, y), want.At(x, y))
+					}
+				}
+			}
+			if tc.optimize && tc.restart == 0 && len(recoded) >= len(original) {
+				t.Errorf("gray %v: optimized JPEG is %d bytes, original %d", gray, len(recoded), len(original))
+			}
+			if hasDRI := bytes.Contains(recoded, []byte{0xff, 0xdd, 0, 4}); hasDRI != (tc.restart > 0) {
+				t.Errorf("gray %v, %+v: DRI segment present: %v", gray, tc, hasDRI)
+			}
+			if tc.restart > 0 && !bytes.Contains(recoded, []byte{0xff, 0xd0}) {
+				t.Errorf("gray %v, %+v: no restart markers", gray, tc)
+			}
+
+			// Re-coding reads restart markers back.
+			again, err := recodeJPEG(recoded, true, 0)
+			if err != nil {
+				t.Fatalf("gray %v, %+v: re-coding again: %v", gray, tc, err)
+			}
+			if got := decodeTestJPEG(t, again); got.At(44, 36) != want.At(44, 36) {
+				t.Errorf("gray %v, %+v: re-coded again, corner is %v", gray, tc, got.At(44, 36))
+			}
+		}
+	}
+}
+
+func TestRecodeJPEGRejectsProgressive(t *testing.T) {
+	progressive := []byte{0xff, 0xd8, 0xff, 0xc2, 0, 11, 8, 0, 8, 0, 8, 1, 1, 0x11, 0, 0xff, 0xd9}
+	if _, err := recodeJPEG(progressive, true, 0); !errors.Is(err, errUnsupportedJPEG) {
+		t.Fatalf("expected errUnsupportedJPEG, got %v", err)
+	}
+}
+
+func TestOptimalTableLimitsCodeLength(t *testing.T) {
+	// Fibonacci frequencies give the most unbalanced tree, over 16 levels.
+	var freq [257]int
+	a, b := 1, 1
+	for i := 0; i < 30; i++ {
+		freq[i] = a
+		a, b = b, a+b
+	}
+	table := optimalTable(&freq)
+	if len(table.symbols) != 30 {
+		t.Fatalf("expected 30 symbols, got %d", len(table.symbols))
+	}
+	// The codes must leave room for at least one unused all-ones code.
+	kraft, total := 0, 0
+	for i, n := range table.counts {
+		kraft += n << (15 - i)
+		total += n
+	}
+	if total != 30 || kraft >= 1<<16 {
+		t.Fatalf("invalid code lengths %v", table.counts)
+	}
+	// The most frequent symbol gets one of the shortest codes.
+	shortest := 0
+	for table.counts[shortest] == 0 {
+		shortest++
+	}
+	if !bytes.Contains(table.symbols[:table.counts[shortest]], []byte{29}) {
+		t.Errorf("expected symbol 29 among the shortest codes, got %v", table.symbols[:table.counts[shortest]])
+	}
+}
+
+func TestParseOptionsRestartInterval(t *testing.T) {
+	opts, err := parseOptions([]string{"--restart-interval", "8", "--optimize-huffman"}, io.Discard)
+	if err != nil || opts.restartInterval != 8 || !opts.optimizeHuffman {
+		t.Fatalf("unexpected options %d, %v (%v)", opts.restartInterval, opts.optimizeHuffman, err)
+	}
+	if _, err := parseOptions([]string{"--restart-interval", "65536"}, io.Discard); err == nil {
+		t.Fatal("expected error for out-of-range restart interval")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// lockFileName is the lock a run holds in its output directory, so two runs
+// started at once, such as a cron job overlapping a manual run, do not
+// convert into the same folder.
+const lockFileName = ".heictojpeg.lock"
+
+// --lock modes: what a run does when another one holds the lock.
+const (
+	lockFail   = "fail"   // exit with an error naming the other run
+	lockWait   = "wait"   // wait for the other run to finish
+	lockIgnore = "ignore" // run anyway, without taking the lock
+)
+
+// lockPollInterval is how often --lock wait tries the lock again.
+const lockPollInterval = time.Second
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked")
+
+func isValidLockMode(mode string) bool {
+	return mode == lockFail || mode == lockWait || mode == lockIgnore
+}
+
+// runLock is a lock held on an output directory.
+type runLock struct {
+	f *os.File
+}
+
+// acquireRunLock takes the lock of dir as mode says, telling out when it
+// waits. It returns a nil lock for --lock ignore.
+func acquireRunLock(ctx context.Context, dir, mode string, out io.Writer) (*runLock, error) {
+	if mode == lockIgnore {
+		return nil, nil
+	}
+	path := filepath.Join(dir, lockFileName)
+	waiting := false
+	for {
+		l, err := tryRunLock(path)
+		if !errors.Is(err, errLocked) {
+			return l, err
+		}
+		holder := lockHolder(path)
+		if mode == lockFail {
+			return nil, fmt.Errorf("%s is in use by another run (%s); use --lock wait to wait for it, or --lock ignore", dir, holder)
+		}
+		if !waiting {
+			fmt.Fprintf(out, "Waiting for another run converting into %s (%s)...\n", dir, holder)
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// tryRunLock takes the lock at path once, or returns errLocked.
+func tryRunLock(path string) (*runLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// The holder before us may have removed the file while we opened it;
+	// the lock is then on a file nobody else will see.
+	held, err1 := f.Stat()
+	current, err2 := os.Stat(path)
+	if err1 != nil || err2 != nil || !os.SameFile(held, current) {
+		f.Close()
+		return tryRunLock(path)
+	}
+	// Who holds the lock, for the message other runs print.
+	host, _ := os.Hostname()
+	f.Truncate(0)
+	fmt.Fprintf(f, "pid %d on %s, started %s\n", os.Getpid(), host, time.Now().Format("2006-01-02 15:04:05"))
+	return &runLock{f: f}, nil
+}
+
+// lockHolder describes the run holding the lock at path, as it wrote it.
+func lockHolder(path string) string {
+	data, err := os.ReadFile(path)
+	if holder := strings.TrimSpace(string(data)); err == nil && holder != "" {
+		return holder
+	}
+	return "unknown process"
+}
+
+// release removes the lock file and unlocks it. It does nothing on a nil
+// lock.
+func (l *runLock) release() {
+	if l == nil {
+		return
+	}
+	// Removed before it is unlocked, so a waiting run that gets the lock
+	// of the old file notices and opens the path again.
+	os.Remove(l.f.Name())
+	l.f.Close()
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "os"
+
+// lockFile does nothing where there are no file locks: runs are not kept
+// from sharing an output directory.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunLock(t *testing.T) {
+	dir := t.TempDir()
+	held, err := acquireRunLock(context.Background(), dir, lockFail, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = acquireRunLock(context.Background(), dir, lockFail, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "pid ") || !strings.Contains(err.Error(), "--lock wait") {
+		t.Fatalf("second run: %v", err)
+	}
+	if l, err := acquireRunLock(context.Background(), dir, lockIgnore, io.Discard); l != nil || err != nil {
+		t.Errorf("--lock ignore: %v, %v", l, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var out bytes.Buffer
+	if _, err := acquireRunLock(ctx, dir, lockWait, &out); err != context.DeadlineExceeded {
+		t.Errorf("waiting run: %v", err)
+	}
+	if !strings.Contains(out.String(), "Waiting for another run") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+
+	// A waiting run gets the lock once the first one is done.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		held.release()
+	}()
+	next, err := acquireRunLock(context.Background(), dir, lockWait, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.release()
+	if _, err := os.Stat(filepath.Join(dir, lockFileName)); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
+
+func TestLockRejected(t *testing.T) {
+	if _, err := parseOptions([]string{"--lock", "later", "."}, io.Discard); err == nil {
+		t.Error("invalid --lock accepted")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on f without waiting. It is released
+// when f is closed, or when the process exits however it does. Windows
+// locks keep other processes from reading the locked bytes, so a byte far
+// past the end of the file is locked rather than the holder it names.
+func lockFile(f *os.File) error {
+	overlapped := windows.Overlapped{OffsetHigh: 1 << 8}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &overlapped)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return errLocked
+	}
+	return err
+}
@@ -1,51 +1,517 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"image/jpeg"
+	"image"
 	"io"
 	"io/fs"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
-
-	"github.com/adrium/goheif"
 )
 
 const logFileName = "logs.txt"
 
 func main() {
-	fmt.Println("Starting the program...")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "install-integration":
+			if err := installIntegration(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "version":
+			if err := runVersion(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "watch":
+			if err := runWatchCommand(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "serve":
+			if err := runServeCommand(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "queue":
+			if err := runQueueCommand(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "run-service":
+			if err := runWindowsService(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "transcode":
+			if err := runTranscodeCommand(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "check":
+			if err := runCheck(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "diff":
+			if err := runDiff(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "audit":
+			if err := runAudit(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "bench":
+			if err := runBench(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "info":
+			if err := runInfo(os.Args[2:], os.Stdout); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "view":
+			if err := runView(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "selftest":
+			if err := runSelftest(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "completion":
+			if err := runCompletion(os.Args[2:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+				log.Fatal(err)
+			}
+			return
+		case "open":
+			// Settings come from the environment; the arguments are the
+			// file manager selection.
+			opts, err := parseOptions(nil, os.Stderr)
+			if err != nil {
+				log.Fatalf("Invalid arguments: %v", err)
+			}
+			if err := openSelection(os.Args[2:], opts, os.Stderr); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
 
-	currentDir, files, err := resolveInput()
+	opts, err := parseOptions(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
-		log.Fatalf("Failed to resolve input path: %v", err)
+		log.Fatalf("Invalid arguments: %v", err)
+	}
+
+	if opts.gui {
+		if err := runGUI(opts); err != nil {
+			log.Fatalf("Failed to start GUI: %v", err)
+		}
+		return
+	}
+
+	if opts.inputPath == stdioPath {
+		if err := runStream(opts); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
+	if opts.outputTar == stdioPath {
+		if isTerminal(os.Stdout) {
+			log.Fatal("Refusing to write a tar archive to a terminal; redirect or pipe stdout")
+		}
+		// The archive is all that goes to stdout; messages go to stderr.
+		opts.tarStdout = os.Stdout
+		os.Stdout = os.Stderr
+	}
+
+	fmt.Println("Starting the program...")
 
-	jpegDir := ensureJPEGDirectoryExists(currentDir)
-	logs := processFiles(currentDir, jpegDir, files)
-	saveLogsToFile(jpegDir, logs)
+	if _, err := run(opts); err != nil {
+		log.Fatal(err)
+	}
 
 	fmt.Println("Program completed!")
 }
 
-func resolveInput() (string, []os.DirEntry, error) {
-	inputPath := "."
-	if len(os.Args) > 1 {
-		inputPath = os.Args[1]
+// run converts the files selected by opts and saves the log file. It returns
+// the local directory the outputs were written to.
+func run(opts options) (string, error) {
+	return runContext(context.Background(), opts)
+}
+
+// runContext is run with a context that ends --watch mode when cancelled, in
+// addition to Ctrl+C and SIGTERM.
+func runContext(ctx context.Context, opts options) (string, error) {
+	jpegDir, err := convertInput(ctx, opts)
+	if err != nil {
+		notify(opts, newNotification(opts, jpegDir, nil, err))
+	}
+	return jpegDir, err
+}
+
+// convertInput does the work of runContext. Finished runs and watch cycles
+// send their notifications here, failures are sent by runContext.
+func convertInput(ctx context.Context, opts options) (string, error) {
+	started := time.Now()
+	applyResourceLimits(opts)
+	if opts.limits.cpus > 0 || opts.limits.memory > 0 {
+		fmt.Printf("Resource %s\n", resourceSummary(opts))
+	}
+	// Streamed directories are listed while they are converted.
+	streaming := streamsInput(opts)
+	currentDir := opts.inputPath
+	if opts.library != nil {
+		currentDir = opts.library.originalsDir()
+	}
+	var files []os.DirEntry
+	var err error
+	if opts.ignore, err = inputIgnoreRules(opts); err != nil {
+		return "", err
+	}
+	opts.skipped = &skipLog{}
+	if opts.plan != nil {
+		// The plan's sources were listed and filtered when it was made.
+		currentDir = opts.plan.Dir
+		files, opts.plannedNames = opts.plan.entries(os.Stdout)
+		if opts.outputDir == "" && opts.library == nil {
+			opts.outputDir = opts.plan.OutputDir
+		}
+	} else if !streaming {
+		if currentDir, files, err = resolveInput(opts); err != nil {
+			return "", fmt.Errorf("failed to resolve input path: %w", err)
+		}
+		if kept := opts.ignore.filter(files); len(kept) < len(files) {
+			fmt.Printf("Ignoring %d files matching %s\n", len(files)-len(kept), ignoreFileName)
+			opts.skipped.addUnlisted(files, kept, skipExcluded)
+			files = kept
+		}
+	}
+
+	if opts.dryRun {
+		return "", dryRun(currentDir, files, opts, os.Stdout)
+	}
+	if opts.planOnly != "" {
+		summary := io.Writer(os.Stdout)
+		if opts.planOnly == stdioPath {
+			summary = os.Stderr
+		}
+		return "", writePlan(currentDir, files, opts, summary)
+	}
+
+	jpegDir, err := resolveOutputDir(currentDir, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create directory: %v", err)
+	}
+	if err := checkOutputLoop(inputDirs(currentDir, opts), jpegDir, opts); err != nil {
+		return "", err
+	}
+	if opts.sessionDir {
+		fmt.Printf("Writing this run's outputs to %s\n", jpegDir)
+	}
+	if opts.remote == nil && opts.outputTar == "" {
+		// Held until the run, and --watch, ends.
+		lock, err := acquireRunLock(ctx, jpegDir, opts.lock, os.Stdout)
+		if err != nil {
+			return "", err
+		}
+		defer lock.release()
+	}
+	if opts.remote != nil {
+		fmt.Printf("Uploading converted files to %s\n", opts.remote)
+	}
+	if opts.reportPath != "" {
+		opts.report = newRunReport(opts, started, outputDescription(opts, jpegDir))
+	}
+	if opts.sync {
+		if opts.remoteSync, err = loadRemoteSync(opts.remote, jpegDir); err != nil {
+			return "", fmt.Errorf("failed to list %s: %v", opts.remote, err)
+		}
+	}
+	// The watcher's baseline covers every source, including those an
+	// earlier run converted.
+	sources := files
+	var carried map[string]string
+	var previous *previousRun
+	if opts.remote == nil && opts.outputTar == "" {
+		var in io.Reader
+		if isTerminal(os.Stdin) {
+			in = os.Stdin
+		}
+		if streaming {
+			previous, err = prepareExistingOutput(jpegDir, opts, in, os.Stdout)
+		} else {
+			files, carried, err = handleExistingOutput(jpegDir, files, opts, in, os.Stdout)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	var restoreTerminal func()
+	if opts.interactive && opts.pauser == nil && isTerminal(os.Stdin) {
+		if restore, err := enableKeyInput(os.Stdin); err == nil {
+			restoreTerminal = restore
+			opts.pauser = newPauseControl()
+		}
+	}
+	if opts.showTUI {
+		opts.tui = newTUI(os.Stdout, opts.pauser, opts.verbose)
+	}
+	if restoreTerminal != nil {
+		handle := func(r io.Reader) { opts.pauser.handleKeys(r, os.Stdout) }
+		if opts.tui != nil {
+			handle = opts.tui.handleKeys
+		} else {
+			fmt.Println("Press p to pause after the files in progress, r to resume.")
+		}
+		keys := startKeyInput(os.Stdin, restoreTerminal, handle)
+		defer keys.close()
+		// Ctrl+C and SIGTERM would otherwise end the run with the terminal
+		// still unechoed. --watch ends on its own once ctx is done; other
+		// runs exit as they would have without the handler.
+		parent := ctx
+		var stopSignals context.CancelFunc
+		ctx, stopSignals = signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		ended := make(chan struct{})
+		defer func() {
+			close(ended)
+			stopSignals()
+		}()
+		if !opts.watch {
+			go func() {
+				<-ctx.Done()
+				select {
+				case <-ended:
+					return
+				default:
+				}
+				if parent.Err() == nil {
+					keys.close()
+					os.Exit(130)
+				}
+			}()
+		}
+	}
+	if opts.tui != nil {
+		opts.tui.start()
+		defer opts.tui.stop()
+	}
+
+	// The baseline for --watch is taken before converting, so files that
+	// change during the first run are picked up again.
+	var seen map[string]sourceState
+	if opts.watch {
+		seen = snapshotSources(sources)
+		if now := time.Now(); !opts.schedule.allows(now) {
+			// Everything is left for the watcher to queue.
+			fmt.Printf("Outside the --schedule windows; converting from %s\n", opts.schedule.nextStart(now).Format("15:04"))
+			seen, files = map[string]sourceState{}, nil
+		}
 	}
 
+	if opts.outputTar != "" {
+		tarStdout := opts.tarStdout
+		if tarStdout == nil {
+			tarStdout = os.Stdout
+		}
+		if opts.tar, err = openTarStream(opts.outputTar, tarStdout); err != nil {
+			return "", fmt.Errorf("failed to create tar archive: %v", err)
+		}
+	}
+
+	outputDir, logDir := jpegDir, jpegDir
+	var converted []*fileResult
+	if opts.outputFormat == formatPDF || opts.outputZip != "" || opts.bundle != "" {
+		// Outputs are converted into a scratch directory and bound or
+		// archived afterwards. Outputs to be encrypted are staged in the
+		// output directory rather than the shared temporary one, in a
+		// folder only the user can read, and so is the log, which names
+		// every file and goes into the archive.
+		stagingParent, stagingPrefix := "", "heictojpeg-staging-"
+		if opts.zipPassword != "" {
+			stagingParent, stagingPrefix = jpegDir, ".heictojpeg-staging-"
+		}
+		staging, err := os.MkdirTemp(stagingParent, stagingPrefix)
+		if err != nil {
+			return "", fmt.Errorf("failed to create directory: %v", err)
+		}
+		defer os.RemoveAll(staging)
+		outputDir = staging
+		if opts.zipPassword != "" {
+			logDir = staging
+		}
+		opts.onResult = func(result *fileResult) {
+			if result.err == nil {
+				converted = append(converted, result)
+			}
+		}
+	}
+
+	var logs map[string][]string
+	var logFile *os.File
+	if streaming {
+		// Per-file lines go straight to the log file, after the header.
+		logFile = createLogFile(logDir, newSessionHeader(opts, started))
+		defer logFile.Close()
+		var converted func(string) (string, bool)
+		if previous != nil {
+			converted = func(name string) (string, bool) { return previous.converted(jpegDir, name) }
+		}
+		logs = streamFiles(currentDir, outputDir, logFile, converted, opts)
+	} else {
+		logs = processFiles(currentDir, outputDir, files, opts)
+	}
+	for name, line := range carried {
+		logs[name] = append(logs[name], line)
+	}
+
+	if opts.outputFormat == formatPDF {
+		pdfPath := filepath.Join(jpegDir, pdfFileName)
+		if err := bindPDF(pdfPath, outputDir, converted, indexFiles(sortFiles(files, opts.order)), opts); err != nil {
+			return "", fmt.Errorf("failed to write PDF: %v", err)
+		}
+		if err := applyOwnership(pdfPath, opts); err != nil {
+			return "", fmt.Errorf("failed to set PDF permissions: %v", err)
+		}
+		logs["general"] = append(logs["general"], fmt.Sprintf("PDF==%s (%d pages)", pdfPath, len(converted)))
+	}
+	saveLogs := func() {
+		if logFile != nil {
+			fmt.Println("Saving logs to logs.txt...")
+			writeLogs(logFile, logs)
+			logFile.Close()
+		} else {
+			saveLogsToFile(logDir, newSessionHeader(opts, started), logs)
+		}
+	}
+	if opts.outputZip != "" {
+		if logDir != jpegDir {
+			saveLogs()
+		}
+		count, err := writeZip(opts.outputZip, outputDir, opts.zipPassword)
+		os.RemoveAll(outputDir)
+		if err != nil {
+			os.Remove(opts.outputZip)
+			return "", fmt.Errorf("failed to write zip archive: %v", err)
+		}
+		if err := applyOwnership(opts.outputZip, opts); err != nil {
+			return "", fmt.Errorf("failed to set zip archive permissions: %v", err)
+		}
+		logs["general"] = append(logs["general"], fmt.Sprintf("Zip==%s (%d files, encrypted: %t)", opts.outputZip, count, opts.zipPassword != ""))
+	}
+	if opts.bundle != "" {
+		bundles, err := writeBundles(jpegDir, outputDir, converted, indexFiles(sortFiles(files, opts.order)), opts.bundleSize)
+		if err != nil {
+			return "", fmt.Errorf("failed to write bundle: %v", err)
+		}
+		for _, path := range bundles {
+			if err := applyOwnership(path, opts); err != nil {
+				return "", fmt.Errorf("failed to set bundle permissions: %v", err)
+			}
+			logs["general"] = append(logs["general"], fmt.Sprintf("Bundle==%s (%s)", path, humanReadableFileSize(getFileSize(path))))
+		}
+	}
+	if opts.tar != nil {
+		logs["general"] = append(logs["general"], fmt.Sprintf("Tar==%s (%d files)", opts.outputTar, opts.tar.archived()))
+	}
+	if logDir == jpegDir {
+		saveLogs()
+	}
+	if err := opts.report.save(opts.reportPath); err != nil {
+		log.Printf("Failed to write report: %v", err)
+	}
+	if opts.tar != nil {
+		// The log file ends the archive; nothing is left in the staging
+		// directory.
+		err := opts.tar.add(jpegDir, logFileName)
+		if closeErr := opts.tar.close(); err == nil {
+			err = closeErr
+		}
+		os.RemoveAll(jpegDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to write tar archive: %v", err)
+		}
+	}
+	notify(opts, newNotification(opts, jpegDir, logs["general"], nil))
+
+	if opts.watch {
+		ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := watchDirectory(ctx, currentDir, jpegDir, seen, opts); err != nil {
+			return "", err
+		}
+	}
+
+	if opts.remoteSync != nil {
+		if err := opts.remoteSync.save(jpegDir); err != nil {
+			log.Printf("Failed to upload sync manifest: %v", err)
+		}
+	}
+	if opts.remote != nil {
+		if err := opts.remote.upload(filepath.Join(jpegDir, logFileName), logFileName); err != nil {
+			log.Printf("Failed to upload log file: %v", err)
+		}
+		os.RemoveAll(jpegDir)
+	}
+
+	return jpegDir, nil
+}
+
+func resolveInput(opts options) (string, []os.DirEntry, error) {
+	if opts.filesFrom != "" {
+		manifest, err := openManifest(opts.filesFrom)
+		if err != nil {
+			return "", nil, err
+		}
+		defer manifest.Close()
+
+		// Manifest entries carry their own paths, so there is no input
+		// directory to join them with.
+		files, err := readManifest(manifest, opts.strict, os.Stderr)
+		return "", files, err
+	}
+
+	if len(opts.inputPaths) > 0 {
+		files, err := sourceEntries(opts.inputPaths)
+		return "", files, err
+	}
+
+	if opts.library != nil {
+		indicator := startScanIndicator(os.Stdout, opts.interactive)
+		dir := opts.library.originalsDir()
+		files, err := opts.library.entries(plannedOutputDir(dir, opts), indicator.found)
+		indicator.finish()
+		return dir, files, err
+	}
+
+	inputPath := opts.inputPath
+
 	info, err := os.Stat(inputPath)
 	if err != nil {
 		return "", nil, err
 	}
 
 	if info.IsDir() {
-		files, err := getFilesInDirectory(inputPath)
+		files, err := watchedSources(inputPath, plannedOutputDir(inputPath, opts), opts)
 		if err != nil {
 			return "", nil, err
 		}
@@ -57,133 +523,350 @@ func resolveInput() (string, []os.DirEntry, error) {
 	return parentDir, []os.DirEntry{entry}, nil
 }
 
-func ensureJPEGDirectoryExists(dir string) string {
-	jpegDir := filepath.Join(dir, "jpegs")
-	if err := os.MkdirAll(jpegDir, 0755); err != nil {
-		log.Fatalf("Failed to create directory: %v", err)
+// ensureJPEGDirectoryExists creates the output directory dir and, with
+// session set (--session-dir), a new folder for the run inside it, and
+// returns the directory the run writes to.
+func ensureJPEGDirectoryExists(dir string, session bool) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if session {
+		return createSessionDir(dir, time.Now())
+	}
+	return dir, nil
+}
+
+// resolveOutputDir returns the local directory converted files are written to.
+// For remote destinations and --output-tar this is a temporary staging
+// directory whose files are uploaded or archived as they are converted. With
+// --session-dir it is a new folder inside the usual one.
+func resolveOutputDir(currentDir string, opts options) (string, error) {
+	dir := plannedOutputDir(currentDir, opts)
+	if dir == "" {
+		return os.MkdirTemp("", "heictojpeg-")
 	}
-	return jpegDir
+	return ensureJPEGDirectoryExists(dir, opts.sessionDir)
 }
 
 func getFilesInDirectory(dir string) ([]os.DirEntry, error) {
 	return os.ReadDir(dir)
 }
 
-func saveLogsToFile(jpegDir string, logs map[string][]string) {
-	logFilePath := filepath.Join(jpegDir, logFileName)
-	logFile, err := os.Create(logFilePath)
+func saveLogsToFile(jpegDir string, header sessionHeader, logs map[string][]string) {
+	logFile := createLogFile(jpegDir, header)
+	defer logFile.Close()
+
+	fmt.Println("Saving logs to logs.txt...")
+	writeLogs(logFile, logs)
+}
+
+// createLogFile creates the log file and writes the settings header, which
+// comes first, as one line of JSON.
+func createLogFile(jpegDir string, header sessionHeader) *os.File {
+	logFile, err := os.Create(filepath.Join(jpegDir, logFileName))
 	if err != nil {
 		log.Fatalf("Failed to create log file: %v", err)
 	}
-	defer logFile.Close()
+	if line, err := json.Marshal(header); err == nil {
+		fmt.Fprintf(logFile, "%s\n", line)
+	}
+	return logFile
+}
 
-	fmt.Println("Saving logs to logs.txt...")
+// appendLogsToFile adds the logs of a later batch, such as one picked up in
+// watch mode, to the end of the log file.
+func appendLogsToFile(jpegDir string, logs map[string][]string) {
+	logFile, err := os.OpenFile(filepath.Join(jpegDir, logFileName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("Failed to open log file: %v", err)
+		return
+	}
+	defer logFile.Close()
+	writeLogs(logFile, logs)
+}
 
+func writeLogs(w io.Writer, logs map[string][]string) {
 	for key, logMessages := range logs {
 		if key == "general" {
 			continue
 		}
 		for _, logMessage := range logMessages {
-			fmt.Fprintln(logFile, logMessage)
+			fmt.Fprintln(w, logMessage)
 		}
 	}
 
 	// Now write the general logs at the end of the file.
 	if generalLogs, ok := logs["general"]; ok {
 		for _, logMessage := range generalLogs {
-			fmt.Fprintln(logFile, logMessage)
+			fmt.Fprintln(w, logMessage)
 		}
 	}
 }
 
-func processFiles(currentDir, jpegDir string, files []os.DirEntry) map[string][]string {
+func processFiles(currentDir, jpegDir string, files []os.DirEntry, opts options) map[string][]string {
 	fmt.Println("Processing files...")
 	startTime := time.Now()
 
 	logs := make(map[string][]string)
-	fileChan, logChan := setupWorkers(currentDir, jpegDir, len(files))
-
-	for _, file := range files {
-		fileChan <- file
+	sorted := files
+	if opts.plan == nil {
+		sorted = sortFiles(selectBursts(currentDir, filterSources(currentDir, files, opts), opts), opts.order)
+	}
+	opts.fileIndex = indexFiles(sorted)
+	if opts.nameClaims != nil {
+		opts.nameClaims.reserve(currentDir, jpegDir, sorted, opts)
 	}
-	close(fileChan)
+	logChan := make(chan *fileResult, opts.workers)
+	go runPipeline(sendEntries(sorted), currentDir, jpegDir, opts, logChan)
 
-	aggregateLogs(logChan, logs, currentDir, jpegDir, startTime)
+	aggregateLogs(logChan, logs, nil, currentDir, jpegDir, countSources(sorted, opts), opts, startTime)
 
 	return logs
 }
 
-func setupWorkers(currentDir, jpegDir string, filesCount int) (chan os.DirEntry, chan map[string]string) {
-	fileChan := make(chan os.DirEntry, filesCount)
-	logChan := make(chan map[string]string, filesCount)
-
-	var wg sync.WaitGroup
-	workerCount := runtime.NumCPU()
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go worker(fileChan, logChan, currentDir, jpegDir, &wg)
+func countSources(files []os.DirEntry, opts options) int {
+	count := 0
+	for _, file := range files {
+		if isSourceFile(file.Name(), opts) {
+			count++
+		}
 	}
+	return count
+}
+
+// fileResult is the outcome of processing one input file.
+type fileResult struct {
+	name   string // input file name, relative to currentDir
+	output string // converted file, relative to jpegDir
+	err    error
+
+	// quarantined is where a broken source was moved by --quarantine-dir.
+	quarantined string
+
+	// unchanged is set when --sync found the output already on the remote.
+	unchanged bool
+
+	// extracted is set when the source was JPEG-coded and its image was
+	// written out without re-encoding.
+	extracted bool
 
-	go func() {
-		wg.Wait()
-		close(logChan)
-	}()
+	// sourceRemoval says what --delete-source did with the source.
+	sourceRemoval string
 
-	return fileChan, logChan
+	// renamedFrom is the --name-template-file name the output did not get
+	// because another source had it.
+	renamedFrom string
+
+	// phash is the perceptual hash of the image, for --compute-phash.
+	phash string
+
+	// warnings are the decoder warnings of the source, see decodeWarnings.
+	warnings []string
+
+	// alpha is what --alpha did with the source's alpha plane, if it has one.
+	alpha string
+
+	// duration and camera are only filled in for --stats.
+	duration time.Duration
+	camera   string
 }
 
-func worker(fileChan chan os.DirEntry, logChan chan map[string]string, currentDir, jpegDir string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for file := range fileChan {
-		logChan <- processFile(file, currentDir, jpegDir)
+// processFile converts a single entry, running the pipeline stages one after
+// another. It returns nil for files that are not HEIC images, or videos
+// with --copy-videos.
+func processFile(file os.DirEntry, currentDir, jpegDir string, opts options) *fileResult {
+	if !isSourceFile(file.Name(), opts) {
+		return nil
 	}
+	c := newConversion(currentDir, file.Name(), jpegDir, opts)
+	c.check()
+	c.read()
+	c.decode()
+	c.encode()
+	c.write()
+	return c.finish()
 }
 
-func processFile(file os.DirEntry, currentDir, jpegDir string) map[string]string {
-	logEntry := make(map[string]string)
-	ext := strings.ToLower(filepath.Ext(file.Name()))
-
-	if ext == ".heic" {
-		fmt.Printf("Processing file: %s\n", file.Name())
-		err := convertFile(currentDir, file.Name(), jpegDir)
-		if err != nil {
-			logEntry[file.Name()] = fmt.Sprintf("error details: %s", err)
-		} else {
-			logEntry[file.Name()] = "converted successfully"
+// aggregateLogs collects the results into logs, one entry per source and a
+// "general" summary. With lines, the per-source lines are written there as
+// they come in rather than kept, so streamed runs do not hold one line per
+// file in memory.
+func aggregateLogs(logChan chan *fileResult, logs map[string][]string, lines io.Writer, currentDir, jpegDir string, total int, opts options, startTime time.Time) {
+	var totalHEICSize, totalJPEGSize, totalVideoSize int64
+	generalLogs := []string{} // Storing general logs here
+	var stats []fileStat
+	record := func(k, line string) {
+		if lines != nil {
+			fmt.Fprintln(lines, line)
+			return
 		}
+		logs[k] = append(logs[k], line)
 	}
+	done, failed, warnings, warned, renamed, videos := 0, 0, 0, 0, 0, 0
+	for result := range logChan {
+		done++
+		if result.err != nil && !errors.Is(result.err, errNotSmaller) {
+			failed++
+		}
+		if opts.progress != nil {
+			opts.progress(done, total)
+		}
+		opts.tui.finished(result, done, total)
+		if opts.onResult != nil {
+			opts.onResult(result)
+		}
 
-	return logEntry
-}
-func aggregateLogs(logChan chan map[string]string, logs map[string][]string, currentDir, jpegDir string, startTime time.Time) {
-	var totalHEICSize, totalJPEGSize int64
-	generalLogs := []string{} // Storing general logs here
-	for logItem := range logChan {
-		for k := range logItem {
-			heicFilePath := filepath.Join(currentDir, k)
-			jpgFilePath := getJPEGFilePath(jpegDir, k)
+		k := result.name
+		if len(result.warnings) > 0 {
+			warnings += len(result.warnings)
+			warned++
+			if opts.verbose && !opts.tui.active() {
+				for _, warning := range result.warnings {
+					fmt.Printf("%s: warning: %s\n", k, warning)
+				}
+			}
+		}
+		if problem := sourceProblem(result.err); problem != "" {
+			size := getFileSize(filepath.Join(currentDir, k))
+			opts.report.addResult(result, size, 0)
+			line := fmt.Sprintf("%s %s > %s", k, humanReadableFileSize(size), problem)
+			if result.quarantined != "" {
+				line = fmt.Sprintf("%s %s > %s > Quarantined > %s", k, humanReadableFileSize(getFileSize(result.quarantined)), problem, result.quarantined)
+			}
+			record(k, line)
+			continue
+		}
+
+		if errors.Is(result.err, errMetadataLoss) || errors.Is(result.err, errNotDownloaded) {
+			size := getFileSize(filepath.Join(currentDir, k))
+			if result.quarantined != "" {
+				size = getFileSize(result.quarantined)
+			}
+			opts.report.addResult(result, size, 0)
+			line := fmt.Sprintf("%s %s > Failed > %v", k, humanReadableFileSize(size), result.err)
+			if result.quarantined != "" {
+				line += " > Quarantined > " + result.quarantined
+			}
+			record(k, line)
+			continue
+		}
+
+		if errors.Is(result.err, errNotSmaller) {
+			size := getFileSize(filepath.Join(currentDir, k))
+			opts.report.addResult(result, size, 0)
+			record(k, fmt.Sprintf("%s %s > Skipped > %v", k, humanReadableFileSize(size), result.err))
+			continue
+		}
+
+		output := result.output
+		if output == "" {
+			// The conversion failed before an output path was chosen.
+			output = filepath.Base(getJPEGFilePath(jpegDir, k))
+		}
+		heicFilePath := filepath.Join(currentDir, k)
+		jpgFilePath := filepath.Join(jpegDir, output)
 
-			heicSizeBytes := getFileSize(heicFilePath)
-			jpgSizeBytes := getFileSize(jpgFilePath)
+		heicSizeBytes := getFileSize(heicFilePath)
+		jpgSizeBytes := getFileSize(jpgFilePath)
+		opts.report.addResult(result, heicSizeBytes, jpgSizeBytes)
 
+		video := opts.copyVideos && isVideoFile(k)
+		if video {
+			// Videos are kept out of the HEIC and JPEG totals.
+			if result.err == nil {
+				videos++
+				totalVideoSize += jpgSizeBytes
+			}
+		} else {
 			totalHEICSize += heicSizeBytes
 			totalJPEGSize += jpgSizeBytes
+		}
+		if opts.stats && result.err == nil && !video {
+			stats = append(stats, fileStat{heicSize: heicSizeBytes, jpegSize: jpgSizeBytes, duration: result.duration, camera: result.camera})
+		}
 
-			heicSize := humanReadableFileSize(heicSizeBytes)
-			jpgSize := humanReadableFileSize(jpgSizeBytes)
+		heicSize := humanReadableFileSize(heicSizeBytes)
+		jpgSize := humanReadableFileSize(jpgSizeBytes)
 
-			logs[k] = append(logs[k], fmt.Sprintf("%s %s > Converted > jpegs/%s.jpg %s", k, heicSize, strings.TrimSuffix(k, filepath.Ext(k)), jpgSize))
+		action := "Converted"
+		if video && opts.videoCommand != nil {
+			action = "Transcoded"
+		} else if video || isHEICFile(output) {
+			action = "Copied" // --copy-videos, or --if-larger copy
+		} else if result.extracted {
+			action = "Extracted" // JPEG-coded source, not re-encoded
+		}
+		line := fmt.Sprintf("%s %s > %s > jpegs/%s %s", k, heicSize, action, filepath.ToSlash(output), jpgSize)
+		if result.alpha != "" && result.err == nil {
+			line += " > " + result.alpha
+		}
+		if result.phash != "" && result.err == nil {
+			line += " > pHash " + result.phash
+		}
+		if result.unchanged && result.err == nil {
+			line += " > Unchanged on remote"
+		}
+		if result.renamedFrom != "" {
+			line += " > Name collision, template gave jpegs/" + filepath.ToSlash(result.renamedFrom)
+			renamed++
+		}
+		if result.sourceRemoval != "" {
+			line += " > " + result.sourceRemoval
+		}
+		record(k, line)
+	}
+
+	// The summary is printed to the terminal as usual.
+	opts.tui.stop()
+
+	// Entries left out were recorded while the pipeline was fed.
+	skipped := opts.skipped.drain()
+	for _, file := range skipped {
+		opts.report.add(reportFile{Source: file.Name, Status: reportSkipped, Size: getFileSize(filepath.Join(currentDir, file.Name)), Error: file.Detail, Code: file.Reason})
+	}
+	if opts.verbose {
+		for _, file := range skipped {
+			line := skipLine(currentDir, file)
+			fmt.Println(line)
+			record(file.Name, line)
 		}
 	}
 
 	// Add general logs to the generalLogs slice
 	totalDuration := time.Since(startTime)
-	totalLogLines := len(logs)
+	totalLogLines := done
 	generalLogs = append(generalLogs, fmt.Sprintf("\n%v Files", totalLogLines))
+	if failed > 0 {
+		generalLogs = append(generalLogs, fmt.Sprintf("Failed Files==%d", failed))
+	}
 	generalLogs = append(generalLogs, fmt.Sprintf("Total Time Taken==%v", totalDuration))
-	generalLogs = append(generalLogs, fmt.Sprintf("Average Time Per File==%v", totalDuration/time.Duration(totalLogLines)))
+	if totalLogLines > 0 {
+		generalLogs = append(generalLogs, fmt.Sprintf("Average Time Per File==%v", totalDuration/time.Duration(totalLogLines)))
+	}
 	generalLogs = append(generalLogs, fmt.Sprintf("Total HEIC File Size==%s", humanReadableFileSize(totalHEICSize)))
 	generalLogs = append(generalLogs, fmt.Sprintf("Total JPEG Folder Size==%s", humanReadableFileSize(totalJPEGSize)))
+	if videos > 0 {
+		generalLogs = append(generalLogs, fmt.Sprintf("Videos==%d (%s)", videos, humanReadableFileSize(totalVideoSize)))
+	}
+	if renamed > 0 {
+		generalLogs = append(generalLogs, fmt.Sprintf("Name Collisions==%d", renamed))
+	}
+	if summary := skipSummary(skipped); summary != "" {
+		generalLogs = append(generalLogs, summary)
+	}
+	if warnings > 0 {
+		generalLogs = append(generalLogs, fmt.Sprintf("Decoder Warnings==%d in %d files", warnings, warned))
+		if !opts.verbose {
+			fmt.Printf("%d decoder warnings in %d files, run with --verbose to see them\n", warnings, warned)
+		}
+	}
+	if opts.stats {
+		distributions := statsLines(stats)
+		for _, line := range distributions {
+			fmt.Println(line)
+		}
+		generalLogs = append(generalLogs, distributions...)
+	}
 
 	// Add the generalLogs slice to the main logs map
 	logs["general"] = generalLogs
@@ -201,11 +884,16 @@ func getFileSize(path string) int64 {
 	return fileInfo.Size()
 }
 
-func convertFile(currentDir, inputFileName, jpegDir string) error {
-	inputFilePath := filepath.Join(currentDir, inputFileName)
-	outputFileName := strings.TrimSuffix(filepath.Base(inputFileName), filepath.Ext(inputFileName)) + ".jpg"
-	outputFilePath := filepath.Join(jpegDir, outputFileName)
-	return convertHeicToJpg(inputFilePath, outputFilePath)
+// convertFile converts inputFileName and returns the path of the written JPEG
+// relative to jpegDir.
+func convertFile(currentDir, inputFileName, jpegDir string, opts options) (string, error) {
+	c := newConversion(currentDir, inputFileName, jpegDir, opts)
+	c.read()
+	c.decode()
+	c.encode()
+	c.write()
+	c.release()
+	return c.outputName, c.err
 }
 
 func humanReadableFileSize(bytes int64) string {
@@ -221,38 +909,172 @@ func humanReadableFileSize(bytes int64) string {
 	return fmt.Sprintf("%.1f%cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
-func convertHeicToJpg(input, output string) error {
-	fileInput, err := os.Open(input)
+// readSource reads a whole input file into a pooled buffer.
+func readSource(path string) (*bytes.Buffer, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer fileInput.Close()
+	defer f.Close()
 
-	exif, err := goheif.ExtractExif(fileInput)
-	if err != nil {
-		return err
+	var size int64
+	if info, err := f.Stat(); err == nil {
+		size = info.Size()
 	}
+	return readIntoBuffer(f, size)
+}
 
+// decodeImage decodes fileInput and applies everything that happens before
+// encoding: scaling, colour conversion and plugins. It returns the EXIF block
+// with a thumbnail added, and opts with the ICC profile to embed.
+func decodeImage(fileInput *bytes.Reader, exif []byte, opts options) (image.Image, []byte, options, error) {
 	// Seek back to the beginning of the file for the next operation.
 	fileInput.Seek(0, 0)
 
-	img, err := goheif.Decode(fileInput)
+	img, err := decodeHEIC(fileInput)
 	if err != nil {
+		return nil, nil, opts, err
+	}
+	if opts.outputFormat == formatAuto {
+		// Decided on the image as shot, before it is cropped or resized.
+		opts.outputFormat = autoOutputFormat(img, exif)
+	}
+	img, opts = applyAlpha(fileInput, img, opts)
+
+	decoded := img.Bounds()
+	if opts.rotate != 0 || opts.flip != "" {
+		img = orientImage(img, exifOrientation(exif), opts.rotate, opts.flip)
+		exif = resetOrientation(exif)
+	}
+	if !opts.crop.Empty() || opts.aspectWidth > 0 {
+		if img, err = cropImage(img, opts, exifOrientation(exif)); err != nil {
+			return nil, nil, opts, err
+		}
+	}
+	if opts.maxPixels > 0 {
+		img = limitPixels(img, opts.maxPixels)
+	}
+	if opts.resizeWidth > 0 {
+		img = resizeImage(img, opts.resizeWidth, opts.resizeHeight, opts.fit, isRotated(exif))
+	}
+	if opts.autoEnhance {
+		img, opts.enhancement = autoEnhance(img)
+	}
+
+	if opts.targetProfile != nil {
+		img = convertColors(img, sourceColorProfile(fileInput), opts.targetProfile)
+		opts.iccProfile = opts.targetProfile.icc
+	} else if opts.metadata.icc {
+		opts.iccProfile = sourceICC(fileInput)
+	}
+
+	if len(opts.plugins) > 0 {
+		if img, err = runPlugins(img, opts.plugins, opts.sourcePath); err != nil {
+			return nil, nil, opts, err
+		}
+	}
+
+	if b := img.Bounds(); b.Size() != decoded.Size() {
+		exif = setPixelDimensions(exif, b.Dx(), b.Dy())
+	}
+	if opts.embedThumbnail && opts.metadata.exif && !isRawFormat(opts.outputFormat) {
+		// A thumbnail is a nicety; keep the original EXIF if it cannot be added.
+		if withThumbnail, err := embedThumbnail(exif, img); err == nil {
+			exif = withThumbnail
+		}
+	}
+	return img, exif, opts, nil
+}
+
+// writeJPEG encodes img with exif into a pooled buffer of about sizeHint
+// bytes, then writes it out in one go.
+func writeJPEG(img image.Image, exif []byte, output string, sizeHint int, opts options) error {
+	encoded := getBuffer(sizeHint)
+	defer putBuffer(encoded)
+	if err := encodeJPEG(encoded, img, exif, output, opts); err != nil {
 		return err
 	}
+	return writeEncoded(output, encoded, nil, opts)
+}
 
-	fileOutput, err := os.OpenFile(output, os.O_RDWR|os.O_CREATE, 0644)
+// writeEncoded writes an encoded output file, replacing any earlier one once
+// it passes check, and applies --chmod/--chown.
+func writeEncoded(output string, encoded *bytes.Buffer, check func(string) error, opts options) error {
+	fileOutput, err := createOutput(output)
 	if err != nil {
 		return err
 	}
-	defer fileOutput.Close()
+	if _, err := encoded.WriteTo(fileOutput); err != nil {
+		fileOutput.discard()
+		return err
+	}
+	if err := fileOutput.commit(check); err != nil {
+		return err
+	}
+	return applyOwnership(output, opts)
+}
 
-	w, err := newWriterExif(fileOutput, exif)
+// encodeJPEG encodes img into encoded with exif, the ICC profile and XMP
+// packet from opts. output only names the file in messages.
+func encodeJPEG(encoded *bytes.Buffer, img image.Image, exif []byte, output string, opts options) error {
+	w, err := writeJPEGHeader(encoded, exif, opts)
 	if err != nil {
 		return err
 	}
+	headerLen := encoded.Len()
+	if err := encodeJPEGImage(w, img, opts.quality, opts); err != nil {
+		return err
+	}
+	if opts.onlyIfSmaller && opts.sourceSize > 0 {
+		return shrinkJPEG(encoded, headerLen, img, output, opts)
+	}
+	return nil
+}
 
-	return jpeg.Encode(w, img, nil)
+// writeJPEGHeader writes the start of a JPEG and its metadata segments:
+// EXIF, ICC profile, XMP and IPTC. The returned writer takes the output of
+// the JPEG encoder, whose own start marker it drops.
+func writeJPEGHeader(w io.Writer, exif []byte, opts options) (io.Writer, error) {
+	skipper, err := newWriterExif(w, exif)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeICCProfile(w, opts.iccProfile); err != nil {
+		return nil, err
+	}
+	if err := writeXMP(w, opts.xmpPacket); err != nil {
+		return nil, err
+	}
+	if err := writeIPTC(w, opts.artist, opts.copyright); err != nil {
+		return nil, err
+	}
+	return skipper, nil
+}
+
+// streamJPEG encodes img with exif straight into the output file, for
+// --low-memory, so the encoded JPEG is never held in memory as a whole. It
+// replaces any earlier output once it passes check.
+func streamJPEG(img image.Image, exif []byte, output string, check func(string) error, opts options) error {
+	f, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(f)
+	w, err := writeJPEGHeader(buffered, exif, opts)
+	if err == nil {
+		err = encodeJPEGImage(w, img, opts.quality, opts)
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		f.discard()
+		return err
+	}
+	if err := f.commit(check); err != nil {
+		return err
+	}
+	return applyOwnership(output, opts)
 }
 
 type writerSkipper struct {
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"image/jpeg"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
@@ -32,8 +34,10 @@ func (m *mockDirEntry) Info() (fs.FileInfo, error) {
 // Testing ensureJPEGDirectoryExists function
 func TestEnsureJPEGDirectoryExists(t *testing.T) {
 	dir := os.TempDir()
-	_ = ensureJPEGDirectoryExists(dir)
 	jpegDir := filepath.Join(dir, "jpegs")
+	if got, err := ensureJPEGDirectoryExists(jpegDir, false); err != nil || got != jpegDir {
+		t.Fatalf("got %q, %v", got, err)
+	}
 	if _, err := os.Stat(jpegDir); os.IsNotExist(err) {
 		t.Fatalf("Directory jpegs was not created")
 	}
@@ -53,9 +57,9 @@ func TestProcessFileNonHEIC(t *testing.T) {
 	entry := &mockDirEntry{name: "test.txt"}
 	currentDir := os.TempDir()
 	jpegDir := filepath.Join(currentDir, "jpegs")
-	logs := processFile(entry, currentDir, jpegDir)
+	result := processFile(entry, currentDir, jpegDir, defaultOptions())
 
-	if _, exists := logs["test.txt"]; exists {
+	if result != nil {
 		t.Fatalf("Non-HEIC file should not be processed")
 	}
 }
@@ -85,10 +89,19 @@ func TestProcessFiles(t *testing.T) {
 		t.Fatalf("Failed to read directory: %v", err)
 	}
 
-	logs := processFiles(currentDir, jpegDir, entries)
+	opts := defaultOptions()
+	var progress []int
+	opts.progress = func(done, total int) {
+		progress = append(progress, done, total)
+	}
+
+	logs := processFiles(currentDir, jpegDir, entries, opts)
 	if _, ok := logs["test.heic"]; !ok {
 		t.Errorf("Expected log entry for test.heic but didn't find one")
 	}
+	if len(progress) != 2 || progress[0] != 1 || progress[1] != 1 {
+		t.Errorf("Expected one progress update of 1/1, got %v", progress)
+	}
 
 }
 
@@ -98,7 +111,12 @@ func TestResolveInputDirectoryArg(t *testing.T) {
 
 	os.Args = []string{"heictojpeg", "testdata/images"}
 
-	dir, files, err := resolveInput()
+	opts, err := parseOptions(os.Args[1:], io.Discard)
+	if err != nil {
+		t.Fatalf("parseOptions failed: %v", err)
+	}
+
+	dir, files, err := resolveInput(opts)
 	if err != nil {
 		t.Fatalf("resolveInput failed: %v", err)
 	}
@@ -117,7 +135,12 @@ func TestResolveInputFileArg(t *testing.T) {
 	inputFile := "testdata/images/libheif-example.heic"
 	os.Args = []string{"heictojpeg", inputFile}
 
-	dir, files, err := resolveInput()
+	opts, err := parseOptions(os.Args[1:], io.Discard)
+	if err != nil {
+		t.Fatalf("parseOptions failed: %v", err)
+	}
+
+	dir, files, err := resolveInput(opts)
 	if err != nil {
 		t.Fatalf("resolveInput failed: %v", err)
 	}
@@ -149,3 +172,28 @@ func TestOpenSourceFixturesPresent(t *testing.T) {
 		}
 	}
 }
+
+func TestConvertFileFixture(t *testing.T) {
+	jpegDir := t.TempDir()
+
+	output, err := convertFile("testdata/images", "goheif-camel.heic", jpegDir, defaultOptions())
+	if err != nil {
+		t.Fatalf("convertFile failed: %v", err)
+	}
+	if output != "goheif-camel.jpg" {
+		t.Fatalf("unexpected output path %s", output)
+	}
+
+	f, err := os.Open(filepath.Join(jpegDir, output))
+	if err != nil {
+		t.Fatalf("output missing: %v", err)
+	}
+	defer f.Close()
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatalf("output is not a JPEG: %v", err)
+	}
+	if config.Width != 1596 || config.Height != 1064 {
+		t.Fatalf("unexpected dimensions %dx%d", config.Width, config.Height)
+	}
+}
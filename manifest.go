@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// manifestEntry is a file listed in a --files-from manifest. Its name is the
+// path exactly as listed, so it is resolved relative to the working directory.
+type manifestEntry struct {
+	path string
+	info fs.FileInfo
+}
+
+func (e manifestEntry) Name() string               { return e.path }
+func (e manifestEntry) IsDir() bool                { return e.info.IsDir() }
+func (e manifestEntry) Type() fs.FileMode          { return e.info.Mode().Type() }
+func (e manifestEntry) Info() (fs.FileInfo, error) { return e.info, nil }
+
+// readManifest reads one path per line. Blank lines are ignored. Paths that
+// do not exist or are not regular files are reported on warnings and skipped;
+// with strict set the first such path is returned as an error instead.
+func readManifest(r io.Reader, strict bool, warnings io.Writer) ([]os.DirEntry, error) {
+	var entries []os.DirEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		path := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(path) == "" {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err == nil && !info.Mode().IsRegular() {
+			err = fmt.Errorf("not a regular file")
+		}
+		if err != nil {
+			if strict {
+				return nil, fmt.Errorf("line %d: %s: %v", line, path, err)
+			}
+			fmt.Fprintf(warnings, "Skipping %s (line %d): %v\n", path, line, err)
+			continue
+		}
+
+		entries = append(entries, manifestEntry{path: path, info: info})
+	}
+	return entries, scanner.Err()
+}
+
+// openManifest opens a manifest file, or stdin for "-".
+func openManifest(name string) (io.ReadCloser, error) {
+	if name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	list := "testdata/images/goheif-camel.heic\n\ntestdata/images/missing.heic\r\ntestdata/images\ntestdata/images/libheif-example.heic\n"
+
+	var warnings bytes.Buffer
+	entries, err := readManifest(strings.NewReader(list), false, &warnings)
+	if err != nil {
+		t.Fatalf("readManifest failed: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Name() != "testdata/images/goheif-camel.heic" {
+		t.Fatalf("unexpected entry %s", entries[0].Name())
+	}
+	for _, skipped := range []string{"missing.heic (line 3)", "testdata/images (line 4)"} {
+		if !strings.Contains(warnings.String(), skipped) {
+			t.Errorf("expected warning for %s, got:\n%s", skipped, warnings.String())
+		}
+	}
+
+	if _, err := readManifest(strings.NewReader(list), true, &warnings); err == nil {
+		t.Fatal("expected strict mode to fail on a missing entry")
+	}
+}
+
+func TestResolveInputFilesFrom(t *testing.T) {
+	fixture, err := filepath.Abs("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := filepath.Join(t.TempDir(), "list.txt")
+	if err := os.WriteFile(manifest, []byte(fixture+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--files-from", manifest}, io.Discard)
+	if err != nil {
+		t.Fatalf("parseOptions failed: %v", err)
+	}
+	dir, files, err := resolveInput(opts)
+	if err != nil {
+		t.Fatalf("resolveInput failed: %v", err)
+	}
+	if dir != "" || len(files) != 1 || filepath.Join(dir, files[0].Name()) != fixture {
+		t.Fatalf("unexpected input: dir %q, files %v", dir, files)
+	}
+
+	if _, err := parseOptions([]string{"--files-from", manifest, "photos"}, io.Discard); err == nil {
+		t.Fatal("expected error when combining --files-from with an input path")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/adrium/goheif/heif"
+	"github.com/adrium/goheif/heif/bmff"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// extractExif returns the raw EXIF block of a HEIC file, or nil if the file
+// does not carry one.
+func extractExif(ra io.ReaderAt) ([]byte, error) {
+	data, err := heif.Open(ra).EXIF()
+	if errors.Is(err, heif.ErrNoEXIF) {
+		return nil, nil
+	}
+	return normalizeExif(data), err
+}
+
+// heifItemInfos lists the item info entries (ID, type, name, MIME type) of
+// every item in a HEIC file. heif.File only looks items up by ID, so the
+// iinf box is read directly.
+func heifItemInfos(ra io.ReaderAt) ([]*bmff.ItemInfoEntry, error) {
+	r := bmff.NewReader(io.NewSectionReader(ra, 0, 1<<62))
+	if _, err := r.ReadAndParseBox(bmff.TypeFtyp); err != nil {
+		return nil, err
+	}
+	box, err := r.ReadAndParseBox(bmff.TypeMeta)
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range box.(*bmff.MetaBox).Children {
+		if !child.Type().EqualString("iinf") {
+			continue
+		}
+		parsed, err := child.Parse()
+		if err != nil {
+			return nil, err
+		}
+		return parsed.(*bmff.ItemInfoBox).ItemInfos, nil
+	}
+	return nil, nil
+}
+
+// xmpContentType is the MIME type of XMP items in HEIF files.
+const xmpContentType = "application/rdf+xml"
+
+// extractXMP returns the XMP packet of a HEIC file, or nil if it has none.
+func extractXMP(ra io.ReaderAt) []byte {
+	infos, err := heifItemInfos(ra)
+	if err != nil {
+		return nil
+	}
+	f := heif.Open(ra)
+	for _, info := range infos {
+		if info.ItemType != "mime" || info.ContentType != xmpContentType {
+			continue
+		}
+		item, err := f.ItemByID(uint32(info.ItemID))
+		if err != nil {
+			continue
+		}
+		if data, err := f.GetItemData(item); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// imageDimensions returns the displayed size of a HEIC's primary image from
+// its header boxes, without decoding any pixels.
+func imageDimensions(ra io.ReaderAt) (width, height int, ok bool) {
+	item, err := heif.Open(ra).PrimaryItem()
+	if err != nil {
+		return 0, 0, false
+	}
+	return item.VisualDimensions()
+}
+
+// decodeExif parses a raw EXIF block. It returns nil if the block is missing
+// or unreadable.
+func decodeExif(rawExif []byte) *exif.Exif {
+	if len(rawExif) == 0 {
+		return nil
+	}
+	x, err := exif.Decode(bytes.NewReader(rawExif))
+	if x == nil || (err != nil && exif.IsCriticalError(err)) {
+		return nil
+	}
+	return x
+}
+
+// gpsCoordinates returns the GPS position recorded in an EXIF block.
+func gpsCoordinates(rawExif []byte) (lat, lon float64, ok bool) {
+	x := decodeExif(rawExif)
+	if x == nil {
+		return 0, 0, false
+	}
+	lat, lon, err := x.LatLong()
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, lon, true
+}
+
+// exifStringField returns the value of an ASCII EXIF field, or "".
+func exifStringField(x *exif.Exif, name exif.FieldName) string {
+	if x == nil {
+		return ""
+	}
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(value, "\x00"))
+}
+
+// exifFieldWalker collects every EXIF field as a display string.
+type exifFieldWalker map[string]string
+
+func (w exifFieldWalker) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	if value, err := tag.StringVal(); err == nil {
+		w[string(name)] = strings.TrimSpace(strings.TrimRight(value, "\x00"))
+	} else {
+		w[string(name)] = tag.String()
+	}
+	return nil
+}
+
+// exifFields returns all EXIF fields keyed by their goexif field name.
+func exifFields(x *exif.Exif) map[string]string {
+	fields := exifFieldWalker{}
+	if x != nil {
+		x.Walk(fields)
+	}
+	return fields
+}
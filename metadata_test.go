@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// testTag is a TIFF directory entry used to build EXIF fixtures in tests.
+type testTag struct {
+	id    uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+func asciiTag(id uint16, s string) testTag {
+	return testTag{id: id, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func rationalTag(id uint16, values ...float64) testTag {
+	var b []byte
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint32(b, uint32(math.Round(v*10000)))
+		b = binary.LittleEndian.AppendUint32(b, 10000)
+	}
+	return testTag{id: id, typ: 5, count: uint32(len(values)), value: b}
+}
+
+func longTag(id uint16, v uint32) testTag {
+	return testTag{id: id, typ: 4, count: 1, value: binary.LittleEndian.AppendUint32(nil, v)}
+}
+
+func ifdSize(tags []testTag) int {
+	size := 2 + 12*len(tags) + 4
+	for _, tag := range tags {
+		if len(tag.value) > 4 {
+			size += len(tag.value) + len(tag.value)%2
+		}
+	}
+	return size
+}
+
+func appendIFD(b []byte, tags []testTag) []byte {
+	start := len(b) - 6 // offsets are relative to the TIFF header
+	dataOffset := start + 2 + 12*len(tags) + 4
+
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(tags)))
+	var data []byte
+	for _, tag := range tags {
+		b = binary.LittleEndian.AppendUint16(b, tag.id)
+		b = binary.LittleEndian.AppendUint16(b, tag.typ)
+		b = binary.LittleEndian.AppendUint32(b, tag.count)
+		if len(tag.value) <= 4 {
+			var inline [4]byte
+			copy(inline[:], tag.value)
+			b = append(b, inline[:]...)
+			continue
+		}
+		b = binary.LittleEndian.AppendUint32(b, uint32(dataOffset+len(data)))
+		data = append(data, tag.value...)
+		if len(tag.value)%2 == 1 {
+			data = append(data, 0)
+		}
+	}
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	return append(b, data...)
+}
+
+// buildTestExif returns an "Exif\0\0"-prefixed little-endian EXIF block with
+// the given IFD0, EXIF sub-IFD and GPS sub-IFD entries.
+func buildTestExif(ifd0, exifIFD, gps []testTag) []byte {
+	ifd0 = append([]testTag(nil), ifd0...)
+	pointers := 0
+	if len(exifIFD) > 0 {
+		pointers++
+	}
+	if len(gps) > 0 {
+		pointers++
+	}
+	next := 8 + ifdSize(ifd0) + 12*pointers
+	if len(exifIFD) > 0 {
+		ifd0 = append(ifd0, longTag(0x8769, uint32(next)))
+		next += ifdSize(exifIFD)
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, longTag(0x8825, uint32(next)))
+	}
+
+	b := []byte("Exif\x00\x00II*\x00")
+	b = binary.LittleEndian.AppendUint32(b, 8)
+	b = appendIFD(b, ifd0)
+	if len(exifIFD) > 0 {
+		b = appendIFD(b, exifIFD)
+	}
+	if len(gps) > 0 {
+		b = appendIFD(b, gps)
+	}
+	return b
+}
+
+func gpsTags(lat, lon float64) []testTag {
+	latRef, lonRef := "N", "E"
+	if lat < 0 {
+		latRef, lat = "S", -lat
+	}
+	if lon < 0 {
+		lonRef, lon = "W", -lon
+	}
+	return []testTag{
+		asciiTag(0x1, latRef),
+		rationalTag(0x2, math.Floor(lat), 0, (lat-math.Floor(lat))*3600),
+		asciiTag(0x3, lonRef),
+		rationalTag(0x4, math.Floor(lon), 0, (lon-math.Floor(lon))*3600),
+	}
+}
+
+func TestGPSCoordinates(t *testing.T) {
+	raw := buildTestExif([]testTag{asciiTag(0x0110, "iPhone 12")}, nil, gpsTags(-33.8568, 151.2153))
+
+	lat, lon, ok := gpsCoordinates(raw)
+	if !ok {
+		t.Fatal("expected GPS coordinates")
+	}
+	if math.Abs(lat+33.8568) > 1e-3 || math.Abs(lon-151.2153) > 1e-3 {
+		t.Fatalf("unexpected coordinates %f,%f", lat, lon)
+	}
+	if folder := locationFolder(lat, lon, ok); folder != "Australia/Sydney" {
+		t.Fatalf("unexpected folder %q", folder)
+	}
+
+	if _, _, ok := gpsCoordinates(buildTestExif([]testTag{asciiTag(0x0110, "iPhone 12")}, nil, nil)); ok {
+		t.Fatal("expected no coordinates without a GPS IFD")
+	}
+	if _, _, ok := gpsCoordinates(nil); ok {
+		t.Fatal("expected no coordinates without EXIF")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Metadata copied from sources into outputs, selected with --keep-metadata.
+const (
+	metadataEXIF = "exif"
+	metadataXMP  = "xmp"
+	metadataICC  = "icc"
+)
+
+// metadataTagGroup lists the EXIF tags and XMP property names (without
+// namespace prefix) that --drop-metadata removes for one group.
+type metadataTagGroup struct {
+	ifd0, exif []uint16
+	gps        bool
+	xmp        *regexp.Regexp
+}
+
+var metadataTagGroups = map[string]metadataTagGroup{
+	"gps": {
+		gps: true,
+		xmp: regexp.MustCompile(`^GPS`),
+	},
+	"serial": {
+		ifd0: []uint16{0xc62f},                         // CameraSerialNumber
+		exif: []uint16{0xa420, 0xa430, 0xa431, 0xa435}, // ImageUniqueID, CameraOwnerName, BodySerialNumber, LensSerialNumber
+		xmp:  regexp.MustCompile(`^(SerialNumber|LensSerialNumber|ImageUniqueID|OwnerName|CameraOwnerName|BodySerialNumber)$`),
+	},
+	"camera": {
+		ifd0: []uint16{0x010f, 0x0110},         // Make, Model
+		exif: []uint16{0xa432, 0xa433, 0xa434}, // LensSpecification, LensMake, LensModel
+		xmp:  regexp.MustCompile(`^(Make|Model|Lens|LensInfo|LensID|LensMake|LensModel|LensSpecification)$`),
+	},
+	"datetime": {
+		ifd0: []uint16{tagDateTime},
+		exif: []uint16{tagDateTimeOriginal, tagDateTimeDigitized, tagOffsetTime, tagOffsetTimeOriginal, tagOffsetTimeDigitized, 0x9290, 0x9291, 0x9292},
+		xmp:  regexp.MustCompile(`^(DateTime|DateTimeOriginal|DateTimeDigitized|DateCreated|CreateDate|ModifyDate|MetadataDate)$`),
+	},
+	"makernote": {
+		exif: []uint16{0x927c},
+	},
+	"ids": {
+		exif: []uint16{0xa420}, // ImageUniqueID
+		xmp:  regexp.MustCompile(`^(ImageUniqueID|DocumentID|InstanceID|OriginalDocumentID|ContentIdentifier)$`),
+	},
+}
+
+// privacyProfiles are the --privacy profiles, each what it adds to
+// --drop-metadata: share takes out what identifies the photographer and
+// their camera and where they were, paranoid also what the photo was taken
+// with and when, unique IDs linking copies, and XMP, which can hold
+// anything.
+var privacyProfiles = map[string][]string{
+	"none":     nil,
+	"share":    {"gps", "serial", "makernote"},
+	"paranoid": {"gps", "serial", "makernote", "ids", "camera", "datetime", metadataXMP},
+}
+
+// privacyDrops returns the --drop-metadata list with what the --privacy
+// profile drops added.
+func privacyDrops(profile, drop string) (string, error) {
+	groups, ok := privacyProfiles[profile]
+	if !ok {
+		return "", fmt.Errorf("unknown --privacy %q: use none, share or paranoid", profile)
+	}
+	return strings.Join(append(splitList(drop), groups...), ","), nil
+}
+
+// metadataPolicy is the parsed form of --keep-metadata and --drop-metadata.
+type metadataPolicy struct {
+	exif, xmp, icc bool
+	drop           []string // tag groups removed from kept EXIF and XMP
+}
+
+// parseMetadataPolicy combines the comma-separated --keep-metadata and
+// --drop-metadata lists. keep names the blocks copied to outputs (exif, xmp,
+// icc, or none); drop removes whole blocks or tag groups within them.
+func parseMetadataPolicy(keep, drop string) (metadataPolicy, error) {
+	var policy metadataPolicy
+	for _, name := range splitList(keep) {
+		switch name {
+		case metadataEXIF:
+			policy.exif = true
+		case metadataXMP:
+			policy.xmp = true
+		case metadataICC:
+			policy.icc = true
+		case "none":
+		default:
+			return policy, fmt.Errorf("unknown metadata %q in --keep-metadata: use exif, xmp, icc or none", name)
+		}
+	}
+	for _, name := range splitList(drop) {
+		switch name {
+		case metadataEXIF:
+			policy.exif = false
+		case metadataXMP:
+			policy.xmp = false
+		case metadataICC:
+			policy.icc = false
+		default:
+			if _, ok := metadataTagGroups[name]; !ok {
+				return policy, fmt.Errorf("unknown metadata %q in --drop-metadata: use exif, xmp, icc, %s", name, strings.Join(tagGroupNames(), ", "))
+			}
+			policy.drop = append(policy.drop, name)
+		}
+	}
+	return policy, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func tagGroupNames() []string {
+	names := make([]string, 0, len(metadataTagGroups))
+	for name := range metadataTagGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filterExif applies the policy to the EXIF block copied into an output.
+func filterExif(rawExif []byte, policy metadataPolicy) ([]byte, error) {
+	if !policy.exif {
+		return nil, nil
+	}
+	if len(rawExif) == 0 || len(policy.drop) == 0 {
+		return rawExif, nil
+	}
+	block, err := parseExifBlock(rawExif)
+	if err != nil {
+		return nil, fmt.Errorf("cannot remove %s from EXIF: %v", strings.Join(policy.drop, ", "), err)
+	}
+	for _, name := range policy.drop {
+		group := metadataTagGroups[name]
+		for _, tag := range group.ifd0 {
+			block.ifd0 = removeEntry(block.ifd0, tag)
+		}
+		for _, tag := range group.exif {
+			block.exif = removeEntry(block.exif, tag)
+		}
+		if group.gps {
+			block.gps = nil
+		}
+	}
+	return block.encode(), nil
+}
+
+// xmpProperty matches the start of an XMP property, written either as an
+// attribute (prefix:Name="...") or as an element (<prefix:Name ...>).
+var xmpProperty = regexp.MustCompile(`(\s)([\w.-]+):([\w.-]+)="[^"]*"|<([\w.-]+):([\w.-]+)[\s/>]`)
+
+// filterXMP applies the policy to the XMP packet copied into an output,
+// removing properties of dropped tag groups whatever namespace prefix they
+// use.
+func filterXMP(packet []byte, policy metadataPolicy) []byte {
+	if !policy.xmp {
+		return nil
+	}
+	if len(packet) == 0 || len(policy.drop) == 0 {
+		return packet
+	}
+	dropped := func(name string) bool {
+		for _, group := range policy.drop {
+			if re := metadataTagGroups[group].xmp; re != nil && re.MatchString(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var out bytes.Buffer
+	rest := packet
+	for {
+		loc := xmpProperty.FindSubmatchIndex(rest)
+		if loc == nil {
+			out.Write(rest)
+			return out.Bytes()
+		}
+		if loc[2] >= 0 { // attribute
+			end := loc[1]
+			if dropped(string(rest[loc[6]:loc[7]])) {
+				out.Write(rest[:loc[3]])
+			} else {
+				out.Write(rest[:end])
+			}
+			rest = rest[end:]
+			continue
+		}
+
+		prefix, name := string(rest[loc[8]:loc[9]]), string(rest[loc[10]:loc[11]])
+		if prefix == "rdf" || prefix == "x" || !dropped(name) {
+			out.Write(rest[:loc[11]])
+			rest = rest[loc[11]:]
+			continue
+		}
+		// Skip the whole element: either self-closing or up to its end tag.
+		out.Write(rest[:loc[0]])
+		rest = rest[loc[0]:]
+		openEnd := bytes.IndexByte(rest, '>')
+		if openEnd > 0 && rest[openEnd-1] == '/' {
+			rest = rest[openEnd+1:]
+			continue
+		}
+		closeTag := []byte("</" + prefix + ":" + name + ">")
+		if end := bytes.Index(rest, closeTag); end >= 0 {
+			rest = rest[end+len(closeTag):]
+		} else {
+			rest = nil
+		}
+	}
+}
+
+// xmpNamespace starts the APP1 segment holding an XMP packet in a JPEG.
+var xmpNamespace = []byte("http://ns.adobe.com/xap/1.0/\x00")
+
+// maxXMPPacket is the largest packet that fits in one APP1 segment.
+const maxXMPPacket = 0xffff - 2 - 29
+
+// writeXMP writes an XMP packet as an APP1 segment. Packets too large for a
+// single segment would need extended XMP and are left out.
+func writeXMP(w io.Writer, packet []byte) error {
+	if len(packet) == 0 || len(packet) > maxXMPPacket {
+		return nil
+	}
+	length := 2 + len(xmpNamespace) + len(packet)
+	segment := append([]byte{0xff, 0xe1, byte(length >> 8), byte(length)}, xmpNamespace...)
+	_, err := w.Write(append(segment, packet...))
+	return err
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseMetadataPolicy(t *testing.T) {
+	policy, err := parseMetadataPolicy("exif,xmp,icc", "gps, Serial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.exif || !policy.xmp || !policy.icc || len(policy.drop) != 2 || policy.drop[1] != "serial" {
+		t.Errorf("got %+v", policy)
+	}
+
+	policy, err = parseMetadataPolicy("exif,icc", "icc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy.exif || policy.xmp || policy.icc {
+		t.Errorf("got %+v, want only exif", policy)
+	}
+
+	if policy, err := parseMetadataPolicy("none", ""); err != nil || policy.exif || policy.xmp || policy.icc {
+		t.Errorf("none: got %+v, %v", policy, err)
+	}
+	if _, err := parseMetadataPolicy("exif,gps", ""); err == nil {
+		t.Error("tag groups cannot be kept selectively")
+	}
+	if _, err := parseMetadataPolicy("exif", "faces"); err == nil {
+		t.Error("expected an error for an unknown group")
+	}
+	if _, err := parseOptions([]string{"--drop-metadata", "faces"}, io.Discard); err == nil {
+		t.Error("parseOptions should reject unknown groups")
+	}
+}
+
+func TestFilterExif(t *testing.T) {
+	raw := buildTestExif(
+		[]testTag{asciiTag(0x010f, "Apple"), asciiTag(0x0110, "iPhone 15 Pro")},
+		[]testTag{asciiTag(0xa431, "F2LXK1234"), asciiTag(tagDateTimeOriginal, "2024:05:01 12:00:00")},
+		gpsTags(48.8584, 2.2945),
+	)
+
+	policy, _ := parseMetadataPolicy("exif", "gps,serial")
+	filtered, err := filterExif(raw, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := gpsCoordinates(filtered); ok {
+		t.Error("GPS position survived --drop-metadata gps")
+	}
+	if bytes.Contains(filtered, []byte("F2LXK1234")) {
+		t.Error("serial number survived --drop-metadata serial")
+	}
+	if !bytes.Contains(filtered, []byte("iPhone 15 Pro")) || !bytes.Contains(filtered, []byte("2024:05:01 12:00:00")) {
+		t.Error("tags outside the dropped groups were removed")
+	}
+
+	if got, _ := filterExif(raw, metadataPolicy{exif: true}); !bytes.Equal(got, raw) {
+		t.Error("EXIF should be copied unchanged when nothing is dropped")
+	}
+	if got, _ := filterExif(raw, metadataPolicy{xmp: true}); got != nil {
+		t.Error("EXIF should be dropped when not kept")
+	}
+}
+
+func TestPrivacyProfiles(t *testing.T) {
+	note := testAppleMakerNote(0x11, "0B1F6F8E-3C2A-4B4E-9A57-1D2C3E4F5A6B")
+	raw := buildTestExif(
+		[]testTag{asciiTag(0x010f, "Apple"), asciiTag(0x0110, "iPhone 15 Pro"), {id: tagOrientation, typ: 3, count: 1, value: []byte{6, 0, 0, 0}}},
+		[]testTag{
+			asciiTag(0xa430, "Jane Appleseed"), asciiTag(0xa431, "F2LXK1234"), asciiTag(0xa420, "5F0C2A91D3"),
+			asciiTag(tagDateTimeOriginal, "2024:05:01 12:00:00"),
+			{id: 0x927c, typ: 7, count: uint32(len(note)), value: note},
+		},
+		gpsTags(48.8584, 2.2945),
+	)
+	filtered := func(profile, drop string) (string, metadataPolicy) {
+		t.Helper()
+		opts, err := parseOptions([]string{"--privacy", profile, "--drop-metadata", drop, t.TempDir()}, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exif, err := filterExif(raw, opts.metadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, ok := gpsCoordinates(exif); ok && profile != "none" {
+			t.Errorf("%s: GPS position survived", profile)
+		}
+		if exifOrientation(exif) != 6 {
+			t.Errorf("%s: orientation removed", profile)
+		}
+		return string(exif), opts.metadata
+	}
+
+	if got, policy := filtered("none", ""); got != string(raw) || !policy.xmp {
+		t.Error("--privacy none changed the metadata")
+	}
+	got, policy := filtered("share", "")
+	for _, gone := range []string{"Jane Appleseed", "F2LXK1234", "5F0C2A91D3", "0B1F6F8E"} {
+		if strings.Contains(got, gone) {
+			t.Errorf("share: %s survived", gone)
+		}
+	}
+	if !strings.Contains(got, "iPhone 15 Pro") || !strings.Contains(got, "2024:05:01") || !policy.xmp {
+		t.Error("share removed the camera, capture time or XMP")
+	}
+	got, policy = filtered("paranoid", "")
+	if strings.Contains(got, "iPhone 15 Pro") || strings.Contains(got, "2024:05:01") || policy.xmp || !policy.icc {
+		t.Errorf("paranoid: got %+v", policy)
+	}
+	// --drop-metadata adds to a profile.
+	if got, _ := filtered("share", "datetime"); strings.Contains(got, "2024:05:01") {
+		t.Error("share with --drop-metadata datetime kept the capture time")
+	}
+
+	if _, err := parseOptions([]string{"--privacy", "public"}, io.Discard); err == nil {
+		t.Error("unknown --privacy accepted")
+	}
+	policy, _ = parseMetadataPolicy("xmp", "ids")
+	xmp := string(filterXMP([]byte(`<x:xmpmeta><rdf:RDF><rdf:Description xmpMM:DocumentID="xmp.did:1" xmpMM:InstanceID="xmp.iid:2" xmp:Rating="5"/></rdf:RDF></x:xmpmeta>`), policy))
+	if strings.Contains(xmp, "xmp.did") || strings.Contains(xmp, "xmp.iid") || !strings.Contains(xmp, "Rating") {
+		t.Errorf("ids: got %s", xmp)
+	}
+}
+
+func TestAppleMakerNoteKept(t *testing.T) {
+	const id = "0B1F6F8E-3C2A-4B4E-9A57-1D2C3E4F5A6B"
+	note := testAppleMakerNote(0x11, id)
+	raw := buildTestExif(
+		[]testTag{asciiTag(0x010f, "Apple"), {id: tagOrientation, typ: 3, count: 1, value: []byte{0, 6, 0, 0}}},
+		[]testTag{asciiTag(0xa431, "F2LXK1234"), {id: 0x927c, typ: 7, count: uint32(len(note)), value: note}},
+		gpsTags(48.8584, 2.2945),
+	)
+
+	// Every rewrite of the EXIF block on the way to an output moves the
+	// maker note; its offsets are relative to itself, so the bytes carry
+	// over as they are.
+	policy, _ := parseMetadataPolicy("exif", "gps,serial,camera,datetime")
+	rewritten, err := filterExif(raw, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewritten = setPixelDimensions(resetOrientation(rewritten), 1200, 800)
+	if rewritten, err = embedThumbnail(rewritten, image.NewRGBA(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	block, err := parseExifBlock(rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept := findEntry(block.exif, 0x927c); kept == nil || kept.typ != 7 || !bytes.Equal(kept.value, note) {
+		t.Fatalf("maker note changed: %+v", kept)
+	}
+	if got := appleContentIdentifier(rewritten); got != id {
+		t.Errorf("maker note unreadable after rewriting, got %q", got)
+	}
+
+	policy, _ = parseMetadataPolicy("exif", "makernote")
+	if dropped, _ := filterExif(raw, policy); appleContentIdentifier(dropped) != "" || bytes.Contains(dropped, []byte("Apple iOS")) {
+		t.Error("maker note survived --drop-metadata makernote")
+	}
+}
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+	`<rdf:Description rdf:about="" xmlns:exif="http://ns.adobe.com/exif/1.0/" xmlns:aux="http://ns.adobe.com/exif/1.0/aux/" xmlns:xmp="http://ns.adobe.com/xap/1.0/"` +
+	` exif:GPSLatitude="48,51.5N" aux:SerialNumber="F2LXK1234" xmp:CreatorTool="17.4">` +
+	`<exif:GPSLongitude>2,17.7E</exif:GPSLongitude><aux:LensSerialNumber/><xmp:Rating>5</xmp:Rating>` +
+	`</rdf:Description></rdf:RDF></x:xmpmeta>`
+
+func TestFilterXMP(t *testing.T) {
+	policy, _ := parseMetadataPolicy("xmp", "gps,serial")
+	got := string(filterXMP([]byte(testXMP), policy))
+	for _, gone := range []string{"GPSLatitude", "GPSLongitude", "SerialNumber", "F2LXK1234"} {
+		if strings.Contains(got, gone) {
+			t.Errorf("%s survived filtering:\n%s", gone, got)
+		}
+	}
+	for _, kept := range []string{`xmp:CreatorTool="17.4"`, "<xmp:Rating>5</xmp:Rating>", `xmlns:exif=`, "</rdf:Description></rdf:RDF></x:xmpmeta>"} {
+		if !strings.Contains(got, kept) {
+			t.Errorf("%s was removed:\n%s", kept, got)
+		}
+	}
+
+	if got := filterXMP([]byte(testXMP), metadataPolicy{exif: true}); got != nil {
+		t.Error("XMP should be dropped when not kept")
+	}
+}
+
+func TestWriteXMP(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeXMP(&buf, []byte(testXMP)); err != nil {
+		t.Fatal(err)
+	}
+	segment := buf.Bytes()
+	if segment[0] != 0xff || segment[1] != 0xe1 || int(segment[2])<<8|int(segment[3]) != len(segment)-2 {
+		t.Fatalf("bad APP1 header % x", segment[:4])
+	}
+	if !bytes.HasPrefix(segment[4:], xmpNamespace) || !bytes.HasSuffix(segment, []byte(testXMP)) {
+		t.Error("segment does not hold the namespace and packet")
+	}
+
+	buf.Reset()
+	if err := writeXMP(&buf, make([]byte, maxXMPPacket+1)); err != nil || buf.Len() != 0 {
+		t.Error("oversized packets should be left out")
+	}
+}
+
+func TestHEIFItemInfos(t *testing.T) {
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err := heifItemInfos(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) == 0 {
+		t.Fatal("expected item info entries")
+	}
+	if xmp := extractXMP(bytes.NewReader(data)); xmp != nil {
+		t.Errorf("fixture has no XMP, got %d bytes", len(xmp))
+	}
+}
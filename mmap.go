@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"os"
+)
+
+// sourceFile is the contents of a source being converted: read into a
+// pooled buffer, or with --mmap memory-mapped, so the file is paged in from
+// the page cache as the decoder reads it rather than copied up front.
+type sourceFile struct {
+	buf    *bytes.Buffer
+	mapped []byte
+}
+
+// openSource reads the source at path, mapping it when mmap is set and the
+// platform and filesystem allow, and reading it otherwise.
+func openSource(path string, mmap bool) (*sourceFile, error) {
+	if mmap {
+		if data, err := mapSource(path); err == nil {
+			return &sourceFile{mapped: data}, nil
+		} else if os.IsNotExist(err) || os.IsPermission(err) {
+			return nil, err
+		}
+		// Anything else, such as an empty file or a filesystem that
+		// cannot map, falls back to reading.
+	}
+	buf, err := readSource(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sourceFile{buf: buf}, nil
+}
+
+// Bytes returns the contents, valid until release.
+func (s *sourceFile) Bytes() []byte {
+	if s.mapped != nil {
+		return s.mapped
+	}
+	return s.buf.Bytes()
+}
+
+func (s *sourceFile) Len() int {
+	return len(s.Bytes())
+}
+
+// release unmaps the file or returns its buffer to the pool. Nothing may
+// use the contents afterwards.
+func (s *sourceFile) release() {
+	if s.mapped != nil {
+		unmapSource(s.mapped)
+		s.mapped = nil
+		return
+	}
+	putBuffer(s.buf)
+	s.buf = nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "errors"
+
+// mapSource is not available here, so --mmap reads sources as usual.
+func mapSource(path string) ([]byte, error) {
+	return nil, errors.New("memory mapping is not supported on this platform")
+}
+
+func unmapSource(data []byte) {}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestOpenSource(t *testing.T) {
+	path := "testdata/images/goheif-camel.heic"
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mmap := range []bool{false, true} {
+		source, err := openSource(path, mmap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(source.Bytes(), want) || source.Len() != len(want) {
+			t.Errorf("mmap %v: contents differ", mmap)
+		}
+		mapped := source.mapped != nil
+		if mapped != (mmap && runtime.GOOS != "windows") {
+			t.Errorf("mmap %v: mapped %v on %s", mmap, mapped, runtime.GOOS)
+		}
+		source.release()
+	}
+
+	// Empty files cannot be mapped and are read instead.
+	empty := filepath.Join(t.TempDir(), "empty.heic")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := openSource(empty, true)
+	if err != nil || source.mapped != nil || source.Len() != 0 {
+		t.Errorf("empty file: %+v, %v", source, err)
+	}
+	if _, err := openSource(filepath.Join(t.TempDir(), "missing.heic"), true); !os.IsNotExist(err) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
+
+func TestConvertMmap(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--mmap", "--sidecar", "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegDir, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpeg, err := os.ReadFile(filepath.Join(jpegDir, "camel.jpg"))
+	if err != nil || !bytes.HasPrefix(jpeg, []byte{0xff, 0xd8}) {
+		t.Errorf("no JPEG written (%v)", err)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mapSource maps the file at path read-only into memory.
+func mapSource(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	// The mapping outlives the descriptor.
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size <= 0 || int64(int(size)) != size {
+		return nil, errors.New("cannot map a file of this size")
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	// Sources are read front to back more or less once.
+	unix.Madvise(data, unix.MADV_SEQUENTIAL)
+	return data, nil
+}
+
+func unmapSource(data []byte) {
+	unix.Munmap(data)
+}
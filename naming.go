@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// nameData is the value a --name-template-file template is executed with.
+type nameData struct {
+	File    string // source file name, e.g. IMG_0001.HEIC
+	Name    string // source file name without extension
+	Ext     string // source extension including the dot
+	Dir     string // directory the source was read from
+	Size    int64  // source size in bytes
+	ModTime time.Time
+	Taken   time.Time // EXIF capture time in its time zone, or ModTime when missing
+	Make    string
+	Model   string
+	Exif    map[string]string // every EXIF field, keyed by field name
+	Index   int               // 1-based position in the processing order
+}
+
+var nameTemplateFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"date":    func(layout string, t time.Time) string { return t.Format(layout) },
+	"in":      inZone,
+	"pad":     func(width, n int) string { return fmt.Sprintf("%0*d", width, n) },
+	"default": func(fallback, s string) string {
+		if s == "" {
+			return fallback
+		}
+		return s
+	},
+}
+
+// loadNameTemplate parses a text/template file used to name outputs.
+func loadNameTemplate(templatePath string) (*template.Template, error) {
+	data, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(templatePath)).Funcs(nameTemplateFuncs).Parse(string(data))
+}
+
+// executeNameTemplate renders tmpl into a relative, slash-separated output
+// path. The ".jpg" extension is appended unless the template supplies it.
+func executeNameTemplate(tmpl *template.Template, data nameData) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	name := path.Clean(strings.TrimSpace(filepath.ToSlash(buf.String())))
+	if name == "." || name == "" || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("name template produced invalid path %q", buf.String())
+	}
+	if !strings.EqualFold(path.Ext(name), ".jpg") {
+		name += ".jpg"
+	}
+	return name, nil
+}
+
+// outputName returns the path of the converted file relative to jpegDir:
+// the name --execute-plan's plan gives it, or one worked out from opts.
+func outputName(currentDir, inputFileName string, rawExif []byte, opts options) (string, error) {
+	if name, ok := opts.plannedNames[inputFileName]; ok {
+		return name, nil
+	}
+	base := filepath.Base(inputFileName)
+	outputFileName := strings.TrimSuffix(base, filepath.Ext(base)) + ".jpg"
+	if opts.library != nil {
+		if name := opts.library.outputName(inputFileName); name != "" {
+			outputFileName = filepath.FromSlash(name)
+		}
+	}
+
+	if opts.nameTemplate != nil {
+		data := nameData{
+			File:  base,
+			Name:  strings.TrimSuffix(base, filepath.Ext(base)),
+			Ext:   filepath.Ext(base),
+			Dir:   currentDir,
+			Index: opts.fileIndex[inputFileName],
+		}
+		if info, err := os.Stat(filepath.Join(currentDir, inputFileName)); err == nil {
+			data.Size = info.Size()
+			data.ModTime = info.ModTime()
+		}
+		x := decodeExif(rawExif)
+		data.Taken = captureTimeIn(rawExif, data.ModTime, opts.assumeTimezone)
+		data.Make = exifStringField(x, exif.Make)
+		data.Model = exifStringField(x, exif.Model)
+		data.Exif = exifFields(x)
+
+		name, err := executeNameTemplate(opts.nameTemplate, data)
+		if err != nil {
+			return "", err
+		}
+		outputFileName = filepath.FromSlash(name)
+	}
+
+	if opts.organizeByLocation {
+		lat, lon, ok := gpsCoordinates(rawExif)
+		outputFileName = filepath.Join(locationFolder(lat, lon, ok), outputFileName)
+	}
+	if opts.organizeByCamera {
+		outputFileName = filepath.Join(cameraFolder(rawExif), outputFileName)
+	}
+	if opts.folderPerSource && opts.library == nil {
+		outputFileName = filepath.Join(sourceFolder(filepath.Join(currentDir, inputFileName)), outputFileName)
+	}
+	if opts.watchRecursive && opts.library == nil {
+		// Sources in folders below the input directory, as --watch-recursive
+		// lists them, go to the same folders below jpegDir.
+		outputFileName = filepath.Join(filepath.Dir(inputFileName), outputFileName)
+	}
+
+	if opts.normalizeNames {
+		outputFileName = normalizeOutputName(outputFileName)
+	}
+	return outputFileName, nil
+}
+
+// cameraFolder returns the --organize-by-camera output subfolder for a
+// photo: its EXIF camera model as recorded, e.g. "iPhone 12" or "Canon EOS
+// R6", with path separators replaced, or unknownCamera without one.
+func cameraFolder(rawExif []byte) string {
+	model := strings.NewReplacer("/", "-", "\\", "-").Replace(exifStringField(decodeExif(rawExif), exif.Model))
+	model = strings.TrimRight(model, ". ")
+	if model == "" {
+		return unknownCamera
+	}
+	return model
+}
+
+// nameClaims keeps outputs named by --name-template-file from overwriting
+// each other when the template gives two sources the same name, e.g. photos
+// taken in the same second. Names are claimed per output directory and
+// source: the first source keeps the template's name, later ones get their
+// EXIF sub-seconds appended ("_123"), or a counter ("_2", "_3", ...) when
+// that does not tell them apart either.
+type nameClaims struct {
+	mu      sync.Mutex
+	owners  map[string]string      // lower-case output path -> source path
+	claimed map[string]claimedName // output directory and source path -> claim
+}
+
+// claimedName is the name a source got, and the template's name for it.
+type claimedName struct {
+	template, name string
+}
+
+func newNameClaims() *nameClaims {
+	return &nameClaims{owners: map[string]string{}, claimed: map[string]claimedName{}}
+}
+
+// claim returns the output name, relative to jpegDir, that sourcePath gets
+// for the template's name, and the template's name when it had to be
+// changed. A source converted again, as in --watch, keeps its name.
+func (n *nameClaims) claim(jpegDir, sourcePath, name string, rawExif []byte) (string, string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	source := jpegDir + "\x00" + sourcePath
+	if previous, ok := n.claimed[source]; ok {
+		if previous.template == name {
+			return previous.name, renamedFrom(previous)
+		}
+		delete(n.owners, strings.ToLower(filepath.Join(jpegDir, previous.name)))
+	}
+	taken := func(name string) bool {
+		owner, ok := n.owners[strings.ToLower(filepath.Join(jpegDir, name))]
+		return ok && owner != sourcePath
+	}
+	claim := claimedName{template: name, name: name}
+	if taken(name) {
+		claim.name = uniqueName(name, exifStringField(decodeExif(rawExif), exif.SubSecTimeOriginal), taken)
+	}
+	n.owners[strings.ToLower(filepath.Join(jpegDir, claim.name))] = sourcePath
+	n.claimed[source] = claim
+	return claim.name, renamedFrom(claim)
+}
+
+func renamedFrom(claim claimedName) string {
+	if claim.name == claim.template {
+		return ""
+	}
+	return claim.template
+}
+
+// reserve claims the names of files in processing order before any is
+// converted, so collisions are resolved the same way on every run rather
+// than in the order the pipeline's workers get to the files. It costs a
+// second read of each source's EXIF block. Files whose name cannot be
+// worked out here are claimed, and fail, when they are converted.
+func (n *nameClaims) reserve(currentDir, jpegDir string, files []os.DirEntry, opts options) {
+	for _, file := range files {
+		if !isHEICFile(file.Name()) {
+			continue
+		}
+		sourcePath := filepath.Join(currentDir, file.Name())
+		rawExif, err := editExif(readExifFile(sourcePath), opts)
+		if err != nil {
+			continue
+		}
+		if name, err := outputName(currentDir, file.Name(), rawExif, opts); err == nil {
+			n.claim(jpegDir, sourcePath, name, rawExif)
+		}
+	}
+}
+
+// uniqueName appends the sub-seconds, if known, or else the lowest counter
+// from 2 up to name, before its extension, to get a name not taken.
+func uniqueName(name, subsec string, taken func(string) bool) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	if subsec = strings.TrimSpace(subsec); subsec != "" {
+		if candidate := stem + "_" + subsec + ext; !taken(candidate) {
+			return candidate
+		}
+	}
+	for i := 2; ; i++ {
+		if candidate := fmt.Sprintf("%s_%d%s", stem, i, ext); !taken(candidate) {
+			return candidate
+		}
+	}
+}
+
+// reservedNames are device names Windows refuses as file names on any
+// filesystem, with or without an extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// normalizeOutputName implements --normalize-names for a relative output
+// path: in every component, whitespace becomes "_", characters FAT, exFAT
+// and NTFS cannot store (control characters and "*/:<>?\|) are dropped, as
+// are trailing dots and spaces, and device names get a "_" suffix. The
+// file's extension is lowercased.
+func normalizeOutputName(name string) string {
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	for i, part := range parts {
+		var b strings.Builder
+		for _, r := range part {
+			switch {
+			case r < 0x20 || r == 0x7f || strings.ContainsRune(`"*:<>?\|`, r):
+			case unicode.IsSpace(r):
+				b.WriteByte('_')
+			default:
+				b.WriteRune(r)
+			}
+		}
+		part = strings.TrimRight(b.String(), ". ")
+		if i == len(parts)-1 {
+			ext := path.Ext(part)
+			part = strings.TrimSuffix(part, ext) + strings.ToLower(ext)
+		}
+		stem := part
+		if dot := strings.IndexByte(part, '.'); dot >= 0 {
+			stem = part[:dot]
+		}
+		if reservedNames[strings.ToUpper(stem)] {
+			part = stem + "_" + part[len(stem):]
+		}
+		if part == "" || strings.HasPrefix(part, ".") {
+			part = "_" + part
+		}
+		parts[i] = part
+	}
+	return filepath.FromSlash(strings.Join(parts, "/"))
+}
+
+// indexFiles numbers files in processing order, starting at 1.
+func indexFiles(files []os.DirEntry) map[string]int {
+	index := make(map[string]int, len(files))
+	for i, file := range files {
+		index[file.Name()] = i + 1
+	}
+	return index
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputNameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	templatePath := filepath.Join(dir, "name.tmpl")
+	template := `{{.Model | lower | replace " " "-"}}/{{.Taken | date "2006-01-02"}}_{{.Index | pad 4}}_{{.Name}}`
+	if err := os.WriteFile(templatePath, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := loadNameTemplate(templatePath)
+	if err != nil {
+		t.Fatalf("loadNameTemplate failed: %v", err)
+	}
+
+	raw := buildTestExif(
+		[]testTag{asciiTag(0x010f, "Apple"), asciiTag(0x0110, "iPhone 12")},
+		[]testTag{asciiTag(0x9003, "2023:07:14 18:30:05")},
+		nil,
+	)
+	opts := defaultOptions()
+	opts.nameTemplate = tmpl
+	opts.fileIndex = map[string]int{"IMG_0042.HEIC": 7}
+
+	name, err := outputName(dir, "IMG_0042.HEIC", raw, opts)
+	if err != nil {
+		t.Fatalf("outputName failed: %v", err)
+	}
+	want := filepath.FromSlash("iphone-12/2023-07-14_0007_IMG_0042.jpg")
+	if name != want {
+		t.Fatalf("got %q, want %q", name, want)
+	}
+}
+
+func TestOutputNameTemplateRejectsEscape(t *testing.T) {
+	dir := t.TempDir()
+	templatePath := filepath.Join(dir, "escape.tmpl")
+	if err := os.WriteFile(templatePath, []byte("../{{.Name}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := loadNameTemplate(templatePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.nameTemplate = tmpl
+	if _, err := outputName(dir, "IMG_0001.HEIC", nil, opts); err == nil {
+		t.Fatal("expected error for a template escaping the output directory")
+	}
+}
+
+func TestOrganizeByCamera(t *testing.T) {
+	opts := defaultOptions()
+	opts.organizeByCamera = true
+	for _, tc := range []struct {
+		model, want string
+	}{
+		{"iPhone 12", "iPhone 12"},
+		{"Canon EOS R6", "Canon EOS R6"},
+		{"AC/DC Cam.", "AC-DC Cam"},
+		{"", unknownCamera},
+	} {
+		var raw []byte
+		if tc.model != "" {
+			raw = buildTestExif([]testTag{asciiTag(0x010f, "Apple"), asciiTag(0x0110, tc.model)}, nil, nil)
+		}
+		name, err := outputName(t.TempDir(), "IMG_0001.HEIC", raw, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(tc.want, "IMG_0001.jpg"); name != want {
+			t.Errorf("model %q: got %q, want %q", tc.model, name, want)
+		}
+	}
+
+	// Camera folders hold the location folders.
+	opts.organizeByLocation = true
+	name, _ := outputName(t.TempDir(), "IMG_0001.HEIC", buildTestExif([]testTag{asciiTag(0x0110, "iPhone 12")}, nil, nil), opts)
+	if want := filepath.Join("iPhone 12", unknownLocation, "IMG_0001.jpg"); name != want {
+		t.Errorf("with --organize-by-location: got %q, want %q", name, want)
+	}
+}
+
+func TestNormalizeOutputName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"IMG_0001.jpg", "IMG_0001.jpg"},
+		{"Beach Day 10:42.JPG", "Beach_Day_1042.jpg"},
+		{"Summer: Trip?/IMG 1.Jpg", "Summer_Trip/IMG_1.jpg"},
+		{`a"b*c<d>e|f.jpg`, "abcdef.jpg"},
+		{"Trailing dots.../CON.jpg", "Trailing_dots/CON_.jpg"},
+		{"nul", "nul_"},
+		{"???/:.jpg", "_/_.jpg"},
+	}
+	for _, tt := range tests {
+		if got := normalizeOutputName(filepath.FromSlash(tt.in)); got != filepath.FromSlash(tt.want) {
+			t.Errorf("normalizeOutputName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	opts := defaultOptions()
+	opts.normalizeNames = true
+	if name, err := outputName(t.TempDir(), "My Photo.HEIC", nil, opts); err != nil || name != "My_Photo.jpg" {
+		t.Errorf("outputName = %q, %v", name, err)
+	}
+}
+
+func TestNameClaims(t *testing.T) {
+	claims := newNameClaims()
+	subsec := buildTestExif(nil, []testTag{asciiTag(0x9291, "042")}, nil)
+	for _, tc := range []struct {
+		dir, source, name string
+		exif              []byte
+		want, renamed     string
+	}{
+		{"out", "a.heic", "2023/120000.jpg", nil, "2023/120000.jpg", ""},
+		{"out", "b.heic", "2023/120000.jpg", subsec, "2023/120000_042.jpg", "2023/120000.jpg"},
+		{"out", "c.heic", "2023/120000.JPG", subsec, "2023/120000_2.JPG", "2023/120000.JPG"},
+		{"out", "d.heic", "2023/120000.jpg", nil, "2023/120000_3.jpg", "2023/120000.jpg"},
+		// Converted again: the source keeps its name.
+		{"out", "b.heic", "2023/120000.jpg", subsec, "2023/120000_042.jpg", "2023/120000.jpg"},
+		{"out", "a.heic", "2023/120000.jpg", nil, "2023/120000.jpg", ""},
+		// Another output directory has names of its own.
+		{"job-2", "e.heic", "2023/120000.jpg", nil, "2023/120000.jpg", ""},
+	} {
+		name, renamed := claims.claim(tc.dir, tc.source, filepath.FromSlash(tc.name), tc.exif)
+		if name != filepath.FromSlash(tc.want) || renamed != filepath.FromSlash(tc.renamed) {
+			t.Errorf("%s in %s: got %q (from %q), want %q (from %q)", tc.source, tc.dir, name, renamed, tc.want, tc.renamed)
+		}
+	}
+}
+
+func TestNameCollisionsRenamed(t *testing.T) {
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"c.heic", "a.heic", "b.heic"} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	templatePath := filepath.Join(t.TempDir(), "name.tmpl")
+	if err := os.WriteFile(templatePath, []byte(`{{.Model | default "camel"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--name-template-file", templatePath, "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegDir, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logData, err := os.ReadFile(filepath.Join(jpegDir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Names go in processing order, whichever worker finishes first.
+	for _, want := range []string{
+		"a.heic 329.6KB > Converted > jpegs/camel.jpg ",
+		"b.heic 329.6KB > Converted > jpegs/camel_2.jpg ",
+		"> Name collision, template gave jpegs/camel.jpg\n",
+		"c.heic 329.6KB > Converted > jpegs/camel_3.jpg ",
+		"Name Collisions==2\n",
+	} {
+		if !strings.Contains(string(logData), want) {
+			t.Errorf("missing %q in log:\n%s", want, logData)
+		}
+	}
+}
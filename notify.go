@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Payload formats of --notify-format.
+const (
+	notifyJSON     = "json"
+	notifySlack    = "slack"
+	notifyTelegram = "telegram"
+)
+
+// notifyTimeout bounds a webhook request, so an unreachable endpoint does
+// not hold up the run or the next watch cycle for long.
+const notifyTimeout = 15 * time.Second
+
+func isValidNotifyFormat(format string) bool {
+	return format == notifyJSON || format == notifySlack || format == notifyTelegram
+}
+
+// checkNotifyWebhook validates a --notify-webhook URL for its format.
+// Telegram's Bot API needs the chat to post to as a chat_id parameter.
+func checkNotifyWebhook(webhook, format string) error {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http:// or https:// URL", webhook)
+	}
+	if format == notifyTelegram && u.Query().Get("chat_id") == "" {
+		return fmt.Errorf("telegram webhooks need a chat_id, e.g. https://api.telegram.org/botTOKEN/sendMessage?chat_id=ID")
+	}
+	return nil
+}
+
+// notification is the summary posted to --notify-webhook when a run or a
+// watch cycle finishes ("finished") or stops with an error ("failed"). It is
+// the body of the json format.
+type notification struct {
+	Event   string   `json:"event"`
+	Host    string   `json:"host"`
+	Input   string   `json:"input"`
+	Output  string   `json:"output,omitempty"`
+	Summary []string `json:"summary,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Code    string   `json:"code,omitempty"` // errorCode of Error
+}
+
+// newNotification describes a run over opts' input. Summary lines are the
+// general lines of logs.txt.
+func newNotification(opts options, jpegDir string, general []string, err error) notification {
+	n := notification{Event: "finished", Input: inputDescription(opts), Output: outputDescription(opts, jpegDir)}
+	n.Host, _ = os.Hostname()
+	for _, line := range general {
+		if line = strings.TrimSpace(line); line != "" {
+			n.Summary = append(n.Summary, line)
+		}
+	}
+	if err != nil {
+		n.Event, n.Error, n.Code = "failed", err.Error(), errorCode(err)
+	}
+	return n
+}
+
+// outputDescription names where a run's outputs go: jpegDir, or the remote
+// destination or tar archive it only staged them for.
+func outputDescription(opts options, jpegDir string) string {
+	switch {
+	case opts.remote != nil:
+		return opts.remote.String()
+	case opts.outputTar != "":
+		return opts.outputTar
+	}
+	return jpegDir
+}
+
+// text is the notification as a chat message.
+func (n notification) text() string {
+	host := ""
+	if n.Host != "" {
+		host = " on " + n.Host
+	}
+	if n.Event == "failed" {
+		return fmt.Sprintf("heictojpeg%s failed converting %s: %s", host, n.Input, n.Error)
+	}
+	lines := append([]string{fmt.Sprintf("heictojpeg%s finished converting %s into %s", host, n.Input, n.Output)}, n.Summary...)
+	return strings.Join(lines, "\n")
+}
+
+// notificationRequest builds the POST of n in format.
+func notificationRequest(webhook, format string, n notification) (*http.Request, error) {
+	var payload interface{} = n
+	switch format {
+	case notifySlack:
+		payload = map[string]string{"text": n.text()}
+	case notifyTelegram:
+		u, err := url.Parse(webhook)
+		if err != nil {
+			return nil, err
+		}
+		query := u.Query()
+		payload = map[string]string{"chat_id": query.Get("chat_id"), "text": n.text()}
+		query.Del("chat_id")
+		u.RawQuery = query.Encode()
+		webhook = u.String()
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// notify posts n to --notify-webhook, if set. A notification that cannot
+// be delivered is logged; it does not fail the run.
+func notify(opts options, n notification) {
+	if opts.notifyWebhook == "" {
+		return
+	}
+	req, err := notificationRequest(opts.notifyWebhook, opts.notifyFormat, n)
+	if err == nil {
+		var resp *http.Response
+		client := &http.Client{Timeout: notifyTimeout}
+		resp, err = client.Do(req)
+		if urlErr, ok := err.(*url.Error); ok {
+			// The URL holds the webhook's secret, keep it out of the log.
+			err = urlErr.Err
+		}
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("%s", resp.Status)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to send notification: %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// webhookRecorder is a webhook endpoint that keeps the bodies posted to it.
+func webhookRecorder(t *testing.T) (*httptest.Server, chan []byte) {
+	bodies := make(chan []byte, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected %s request with %q", r.Method, r.Header.Get("Content-Type"))
+		}
+		if r.URL.Query().Get("chat_id") != "" {
+			t.Errorf("chat_id left in the URL %s", r.URL)
+		}
+		bodies <- body
+	}))
+	t.Cleanup(server.Close)
+	return server, bodies
+}
+
+func TestParseOptionsNotify(t *testing.T) {
+	opts, err := parseOptions([]string{"--notify-webhook", "https://hooks.example.com/x", "--notify-format", "slack"}, io.Discard)
+	if err != nil || opts.notifyWebhook != "https://hooks.example.com/x" || opts.notifyFormat != notifySlack {
+		t.Fatalf("unexpected webhook %q %q (%v)", opts.notifyWebhook, opts.notifyFormat, err)
+	}
+	for _, args := range [][]string{
+		{"--notify-format", "email"},
+		{"--notify-webhook", "ftp://example.com/x"},
+		{"--notify-webhook", "https://api.telegram.org/botTOKEN/sendMessage", "--notify-format", "telegram"},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
+
+func TestNotificationFormats(t *testing.T) {
+	server, bodies := webhookRecorder(t)
+	n := notification{Event: "finished", Host: "nas", Input: "/photos", Output: "/photos/jpegs", Summary: []string{"12 Files", "Failed Files==1"}}
+
+	opts := defaultOptions()
+	opts.notifyWebhook = server.URL
+	notify(opts, n)
+	var got notification
+	if err := json.Unmarshal(<-bodies, &got); err != nil || got.Event != "finished" || len(got.Summary) != 2 {
+		t.Errorf("unexpected json payload %+v (%v)", got, err)
+	}
+
+	want := "heictojpeg on nas finished converting /photos into /photos/jpegs\n12 Files\nFailed Files==1"
+	opts.notifyFormat = notifySlack
+	notify(opts, n)
+	var slack map[string]string
+	if err := json.Unmarshal(<-bodies, &slack); err != nil || slack["text"] != want {
+		t.Errorf("unexpected slack payload %q (%v)", slack, err)
+	}
+
+	opts.notifyFormat = notifyTelegram
+	opts.notifyWebhook = server.URL + "/botTOKEN/sendMessage?chat_id=42"
+	notify(opts, n)
+	var telegram map[string]string
+	if err := json.Unmarshal(<-bodies, &telegram); err != nil || telegram["chat_id"] != "42" || telegram["text"] != want {
+		t.Errorf("unexpected telegram payload %q (%v)", telegram, err)
+	}
+}
+
+func TestRunNotifies(t *testing.T) {
+	server, bodies := webhookRecorder(t)
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.inputPath = dir
+	opts.notifyWebhook = server.URL
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	var got notification
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != "finished" || got.Input != dir || len(got.Summary) == 0 || got.Summary[0] != "1 Files" {
+		t.Errorf("unexpected notification %+v", got)
+	}
+
+	opts.inputPath = filepath.Join(dir, "missing")
+	if _, err := run(opts); err == nil {
+		t.Fatal("expected error for a missing input")
+	}
+	got = notification{}
+	if err := json.Unmarshal(<-bodies, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != "failed" || !strings.Contains(got.Error, "missing") {
+		t.Errorf("unexpected notification %+v", got)
+	}
+}
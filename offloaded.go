@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// How --offloaded treats sources iCloud (or another file provider) has
+// offloaded, leaving a dataless placeholder on disk: download them before
+// converting, or skip them, leaving the placeholder as it is.
+const (
+	offloadedDownload = "download"
+	offloadedSkip     = "skip"
+)
+
+// errNotDownloaded marks a source whose contents are not on this machine and
+// could not be downloaded.
+var errNotDownloaded = errors.New("file not downloaded locally")
+
+func isValidOffloaded(mode string) bool {
+	return mode == offloadedDownload || mode == offloadedSkip
+}
+
+// skipsOffloaded reports whether the source at path is an offloaded file
+// --offloaded skip leaves out.
+func skipsOffloaded(path string, opts options) bool {
+	return opts.offloaded == offloadedSkip && isOffloaded(path)
+}
+
+// downloadSource makes sure the contents of the source at path are on disk,
+// reading an offloaded file through once, which has the file provider
+// download it. Reading a placeholder otherwise fails part way, or returns
+// data the decoder cannot make sense of, so a file that is still offloaded
+// afterwards fails with errNotDownloaded, as do offloaded files --offloaded
+// skip did not leave out when they were listed.
+func downloadSource(path string, opts options) error {
+	if !isOffloaded(path) {
+		return nil
+	}
+	if opts.offloaded == offloadedSkip {
+		return errNotDownloaded
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNotDownloaded, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.Discard, f); err != nil {
+		return fmt.Errorf("%w: %v", errNotDownloaded, err)
+	}
+	if isOffloaded(path) {
+		return fmt.Errorf("%w: still offloaded after reading it", errNotDownloaded)
+	}
+	return nil
+}
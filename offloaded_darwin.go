@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// isOffloaded reports whether the file at path is dataless: its contents
+// live in iCloud, with only a placeholder left on this Mac by Optimize Mac
+// Storage.
+func isOffloaded(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && st.Flags&unix.SF_DATALESS != 0
+}
@@ -0,0 +1,9 @@
+//go:build !darwin
+
+package main
+
+// isOffloaded reports whether the file at path is offloaded; only macOS
+// marks files as dataless.
+func isOffloaded(path string) bool {
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOffloadedOptions(t *testing.T) {
+	opts, err := parseOptions([]string{t.TempDir()}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.offloaded != offloadedDownload {
+		t.Errorf("default --offloaded is %q", opts.offloaded)
+	}
+	if _, err := parseOptions([]string{"--offloaded", "ignore", t.TempDir()}, io.Discard); err == nil {
+		t.Error("--offloaded ignore accepted")
+	}
+}
+
+func TestDownloadSource(t *testing.T) {
+	// Files on local disks are never offloaded, whatever --offloaded says.
+	path := filepath.Join(t.TempDir(), "IMG_0001.heic")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	for _, mode := range []string{offloadedDownload, offloadedSkip} {
+		opts.offloaded = mode
+		if isOffloaded(path) || skipsOffloaded(path, opts) {
+			t.Errorf("%s: local file taken as offloaded", mode)
+		}
+		if err := downloadSource(path, opts); err != nil {
+			t.Errorf("%s: %v", mode, err)
+		}
+	}
+}
+
+func TestNotDownloadedCode(t *testing.T) {
+	err := &stageError{stage: "check", err: fmt.Errorf("%w: resource deadlock avoided", errNotDownloaded)}
+	if code := errorCode(err); code != codeNotDownloaded {
+		t.Errorf("got %s, want %s", code, codeNotDownloaded)
+	}
+}
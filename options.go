@@ -0,0 +1,1073 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// envPrefix prefixes the environment variables that mirror each flag, e.g.
+// HEICTOJPEG_OUTPUT_DIR for --output-dir.
+const envPrefix = "HEICTOJPEG_"
+
+// options holds the settings for a single conversion run.
+type options struct {
+	inputPath string
+	outputDir string
+
+	// filesFrom names a manifest of paths to convert ("-" for stdin) used
+	// instead of inputPath. strict aborts on missing manifest entries.
+	filesFrom string
+	strict    bool
+
+	// inputPaths are the input paths when more than one is given, each
+	// directory or file converted as part of one run. folderPerSource writes
+	// outputs to a subfolder named after each source's directory; it is on
+	// by default for several inputs.
+	inputPaths      []string
+	folderPerSource bool
+
+	order   string
+	quality int
+
+	// optimizeHuffman and restartInterval re-code encoded JPEGs with
+	// Huffman tables built for each image and a restart marker every
+	// restartInterval MCUs (--optimize-huffman, --restart-interval).
+	optimizeHuffman bool
+	restartInterval int
+
+	// jpegEncoderName is the --jpeg-encoder, and jpegEncoder the native
+	// encoder it found; nil for image/jpeg.
+	jpegEncoderName string
+	jpegEncoder     *jpegEncoder
+
+	// outputFormat is "jpeg", "auto" to pick PNG or JPEG per file, or "pdf"
+	// to bind all pages into one PDF laid out according to pageSize and
+	// pageFit.
+	outputFormat string
+	pageSize     string
+	pageFit      string
+
+	// outputZip packs the converted files into this archive, encrypted
+	// with AES-256 when zipPassword is set.
+	outputZip   string
+	zipPassword string
+
+	// bundle (--bundle) packs outputs into zips of at most bundleSize
+	// bytes (--bundle-size) with simplified names; see bundle.go.
+	bundle          string
+	bundleSizeValue string
+	bundleSize      int64
+
+	// outputTar streams the converted files into this tar archive, or to
+	// tarStdout for "-" (--output-tar), through tar while the run lasts.
+	outputTar string
+	tarStdout io.Writer
+	tar       *tarStream
+
+	// rules override quality and size per camera (--rules). maxPixels is
+	// the size limit picked for the file being converted.
+	rulesFile string
+	rules     []conversionRule
+	maxPixels int
+
+	// resizeValue (--resize WxH) bounds outputs at resizeWidth x
+	// resizeHeight, interpreted according to fit (--fit).
+	resizeValue  string
+	resizeWidth  int
+	resizeHeight int
+	fit          string
+
+	// cropValue (--crop WxH+X+Y) cuts crop out of the image as displayed;
+	// cropAspect (--crop-aspect W:H) cuts the largest centred area of
+	// aspectWidth:aspectHeight instead. Both apply before --resize.
+	cropValue    string
+	crop         image.Rectangle
+	cropAspect   string
+	aspectWidth  int
+	aspectHeight int
+
+	// rotate (--rotate) turns the image as displayed clockwise by 90, 180
+	// or 270 degrees, then flip (--flip) mirrors it. Both apply before
+	// --crop.
+	rotate int
+	flip   string
+
+	// existing says what to do with the outputs of an earlier run found in
+	// the output directory (--existing); empty asks or merges.
+	existing string
+
+	// sessionDir (--session-dir) writes each run into a new timestamped
+	// folder inside the output directory; see createSessionDir.
+	sessionDir bool
+
+	// lock says what to do when another run holds the lock of the output
+	// directory (--lock), see acquireRunLock.
+	lock string
+
+	// sidecar writes a provenance NAME.jpg.json next to every output.
+	sidecar bool
+
+	// deleteSource removes each source once it is converted; with useTrash
+	// it is moved to trash, the platform's trash, instead.
+	deleteSource bool
+	useTrash     bool
+	trash        trashCan
+
+	// pluginValue (--plugin) lists external commands that transform each
+	// image before encoding, parsed into plugins. sourcePath is the file
+	// being converted, which plugins are told about.
+	pluginValue string
+	plugins     []pluginCommand
+	sourcePath  string
+
+	// copyVideos passes the videos among the sources through to the output
+	// folder; videoCommandValue (--video-command), parsed into
+	// videoCommand, transcodes them instead.
+	copyVideos        bool
+	videoCommandValue string
+	videoCommand      videoCommand
+
+	// onlyIfSmaller keeps JPEGs only when they are smaller than sourceSize,
+	// the size of the file being converted; ifLarger says what happens
+	// otherwise (--if-larger).
+	onlyIfSmaller bool
+	ifLarger      string
+	sourceSize    int64
+
+	// tile splits images larger than tileSize pixels on either side into
+	// JPEG tiles overlapping by tileOverlap pixels.
+	tile        bool
+	tileSize    int
+	tileOverlap int
+
+	// chmodValue and chownValue set the mode and owner of every output
+	// (--chmod, --chown), parsed into fileMode, uid and gid.
+	chmodValue string
+	fileMode   os.FileMode
+	chownValue string
+	uid, gid   int
+
+	// quarantineDir receives empty, truncated and malformed sources.
+	quarantineDir string
+
+	// keepMetadata and dropMetadata select the metadata copied into
+	// outputs, parsed into metadata together with what the privacy profile
+	// (--privacy) drops. xmpPacket and iccProfile are the blocks
+	// chosen for the file being converted.
+	keepMetadata string
+	dropMetadata string
+	privacy      string
+	metadata     metadataPolicy
+	xmpPacket    []byte
+	iccProfile   []byte
+
+	// alpha (--alpha) is how sources with an alpha plane are converted,
+	// onto alphaBackground (--alpha-background) when flattened; see
+	// alpha.go. alphaAction is what was done to a file's, for the log.
+	alpha                string
+	alphaBackgroundValue string
+	alphaBackground      color.RGBA
+	alphaAction          string
+
+	// strictMetadata (--strict-metadata) fails files whose kept metadata
+	// could not all be carried into the output, see metadataLosses.
+	strictMetadata bool
+
+	// validateOutput (--validate-output) checks every JPEG output is well
+	// formed and decodes before it is written; on by default with
+	// --strict-metadata.
+	validateOutput bool
+
+	// embedThumbnail stores a small preview in the output EXIF. It is on by
+	// default and disabled with --no-embed-thumbnail.
+	embedThumbnail   bool
+	noEmbedThumbnail bool
+
+	// targetProfile converts decoded pixels into this colour space when
+	// --target-profile is set.
+	targetProfileName string
+	targetProfile     *colorProfile
+
+	// autoEnhance (--auto-enhance) corrects the white balance, levels and
+	// midtones of decoded pixels. enhancement is the correction applied to
+	// the file being converted, for its sidecar.
+	autoEnhance bool
+	enhancement *enhancement
+
+	// timeShift and timezone correct EXIF timestamps (--time-shift and
+	// --set-timezone).
+	timeShiftValue string
+	timeShift      time.Duration
+	timezoneName   string
+	timezone       *time.Location
+
+	// assumeTimezone (--assume-timezone) is the time zone of EXIF
+	// timestamps without an offset tag, and of file times, for naming.
+	assumeTimezoneName string
+	assumeTimezone     *time.Location
+
+	// artist and copyright are written into the EXIF and IPTC of every
+	// output (--artist and --copyright).
+	artist    string
+	copyright string
+
+	// skipScreenshots and onlyScreenshots filter Apple screenshots out of,
+	// or into, the batch.
+	skipScreenshots bool
+	onlyScreenshots bool
+
+	// minWidth and minHeight leave out smaller images, and minMegapixels
+	// and maxMegapixels images outside a size range, judged from the HEIC
+	// header without decoding.
+	minWidth      int
+	minHeight     int
+	minMegapixels float64
+	maxMegapixels float64
+
+	// minRating leaves out images with fewer stars (XMP or EXIF rating);
+	// favoritesOnly keeps only Photos library favourites, or 5-star images
+	// for other inputs.
+	minRating     int
+	favoritesOnly bool
+
+	// burst keeps all frames of iPhone bursts, or only the first or the
+	// sharpest one (--burst).
+	burst string
+
+	// watch keeps running after the first pass and converts HEIC files
+	// added to the input directory, scanning every watchInterval. Files are
+	// converted once unchanged for watchSettle, watchBatch at a time. With
+	// watchRecursive (--watch-recursive) folders below it are converted and
+	// watched too, into the same folders under the output directory.
+	watch          bool
+	watchInterval  time.Duration
+	watchSettle    time.Duration
+	watchBatch     int
+	watchRecursive bool
+
+	// airDrop (--airdrop) watches the AirDrop inbox, converting only the
+	// files received over AirDrop.
+	airDrop bool
+
+	// offloaded (--offloaded) is what happens to sources iCloud has
+	// offloaded, see offloaded.go.
+	offloaded string
+
+	// scheduleValue (--schedule) limits watch mode conversions to daily
+	// windows, parsed into schedule.
+	scheduleValue string
+	schedule      conversionSchedule
+
+	// listen is the address of the control API of `heictojpeg serve`, which
+	// `heictojpeg queue` also connects to.
+	listen string
+
+	// dryRun lists what would be converted without converting it.
+	dryRun bool
+
+	// planOnly (--plan-only) writes the plan of the run to a file, or
+	// stdout for "-", instead of converting. executePlan (--execute-plan)
+	// runs such a plan as plan, with the output names in plannedNames.
+	planOnly     string
+	executePlan  string
+	plan         *conversionPlan
+	plannedNames map[string]string
+
+	// reportPath (--report) is where the run writes the outcome of every
+	// source as JSON, collected in report, for `heictojpeg diff`.
+	reportPath string
+	report     *runReport
+
+	// organizeByLocation places outputs in country/city folders derived
+	// from the EXIF GPS position.
+	organizeByLocation bool
+
+	// sharedAlbums (--shared-albums) reads the input as an Apple shared
+	// album export, see sharedAlbumExport.
+	sharedAlbums bool
+
+	// organizeByCamera places outputs in folders named after the EXIF
+	// camera model.
+	organizeByCamera bool
+
+	// normalizeNames makes output names safe for FAT/exFAT and NTFS
+	// (--normalize-names).
+	normalizeNames bool
+
+	// nameTemplate names outputs when --name-template-file is set, with
+	// nameClaims renaming outputs whose names collide.
+	nameTemplateFile string
+	nameTemplate     *template.Template
+	nameClaims       *nameClaims
+
+	// splitter spreads outputs over batch folders when --split-size or
+	// --split-count is set.
+	splitSize  string
+	splitCount int
+	splitter   *batchSplitter
+
+	// interactive enables features that need a terminal. It defaults to
+	// whether stdout is a TTY and can be forced off with --non-interactive.
+	interactive    bool
+	nonInteractive bool
+
+	// notifyWebhook (--notify-webhook) receives a summary when a run or a
+	// watch cycle finishes or fails, posted in notifyFormat.
+	notifyWebhook string
+	notifyFormat  string
+
+	// verbose prints each file's decoder warnings as it finishes, and logs
+	// every skipped entry with its reason; without it they are only
+	// counted in the summary.
+	verbose bool
+
+	// skipped collects the entries of the input a run leaves out, see
+	// skipLog.
+	skipped *skipLog
+
+	// showTUI (--tui) replaces the per-file output with a full-screen view
+	// of the workers, drawn by tui while files are converted.
+	showTUI bool
+	tui     *tuiDisplay
+
+	// pauser holds workers between files while the batch is paused.
+	pauser *pauseControl
+
+	// remote is set when outputDir is an sftp:// destination. With sync
+	// (--sync), remoteSync skips uploads the remote already has.
+	remote     *sftpTarget
+	sync       bool
+	remoteSync *remoteSync
+
+	// library is set when the input is an Apple Photos library package, or
+	// a shared album export with --shared-albums.
+	library sourceLibrary
+
+	// stats prints size, compression, timing and camera distributions at
+	// the end of a run and appends them to the log file.
+	stats bool
+
+	// computePHash records a perceptual hash of each converted image in the
+	// log and sidecar (--compute-phash).
+	computePHash bool
+
+	// mmap reads sources by memory-mapping them where the platform allows.
+	mmap bool
+
+	// lowMemory converts one file at a time, without pooled buffers, and
+	// encodes JPEGs straight to disk (--low-memory).
+	lowMemory bool
+
+	// maxMemoryValue (--max-memory) replaces the memory limit detected for
+	// the process in limits. workers is how many files are decoded and
+	// encoded at once within them.
+	maxMemoryValue string
+	limits         resourceLimits
+	workers        int
+
+	// ioWorkers, decodeWorkers and encodeWorkers (--io-workers,
+	// --decode-workers, --encode-workers) size the stages of the pipeline,
+	// 0 for calibrating them; stages holds them for the run.
+	ioWorkers     int
+	decodeWorkers int
+	encodeWorkers int
+	stages        *stageWorkers
+
+	// gui opens the graphical launcher instead of converting right away.
+	gui bool
+
+	// progress, when set, is called after each HEIC file finishes. total is
+	// 0 when the input is streamed and its size not known yet.
+	progress func(done, total int)
+
+	// onResult, when set, is called with each HEIC file's result.
+	onResult func(result *fileResult)
+
+	// ignore holds the patterns of the input folder's .heicignore file.
+	ignore ignoreRules
+
+	// fileIndex maps each file name to its 1-based position in the
+	// processing order. It is filled in by processFiles; streamed files are
+	// numbered by the pipeline as they arrive.
+	fileIndex map[string]int
+}
+
+func defaultOptions() options {
+	return options{
+		inputPath:            ".",
+		workers:              runtime.NumCPU(),
+		order:                orderName,
+		quality:              jpeg.DefaultQuality,
+		alpha:                alphaPNG,
+		alphaBackgroundValue: defaultAlphaBackground,
+		outputFormat:         formatJPEG,
+		pageSize:             pageSizeImage,
+		pageFit:              pageFitContain,
+		fit:                  fitContain,
+		burst:                burstAll,
+		offloaded:            offloadedDownload,
+		ifLarger:             largerRetry,
+		lock:                 lockFail,
+		jpegEncoderName:      encoderStdlib,
+		watchInterval:        defaultWatchInterval,
+		watchSettle:          defaultWatchSettle,
+		watchBatch:           defaultWatchBatch,
+		notifyFormat:         notifyJSON,
+		listen:               defaultListenAddress,
+		tileSize:             defaultTileSize,
+		tileOverlap:          defaultTileOverlap,
+		keepMetadata:         "exif,xmp,icc",
+		privacy:              "none",
+		metadata:             metadataPolicy{exif: true, xmp: true, icc: true},
+		embedThumbnail:       true,
+		interactive:          isTerminal(os.Stdout),
+	}
+}
+
+func newFlagSet(opts *options, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("heictojpeg", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: heictojpeg [flags] [directory|file ...]")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&opts.outputDir, "output-dir", opts.outputDir, "output directory or sftp://user@host/path (default: jpegs subfolder of the input)")
+	fs.BoolVar(&opts.sync, "sync", opts.sync, "with an sftp:// --output-dir, upload only files that are missing or changed on the remote")
+	fs.StringVar(&opts.filesFrom, "files-from", opts.filesFrom, "read paths to convert from this file, one per line (- for stdin)")
+	fs.BoolVar(&opts.strict, "strict", opts.strict, "abort when a --files-from entry is missing instead of skipping it")
+	fs.BoolVar(&opts.folderPerSource, "folder-per-source", opts.folderPerSource, "write outputs to a subfolder named after each source's directory (default with several inputs)")
+	fs.StringVar(&opts.resizeValue, "resize", opts.resizeValue, "resize outputs to WIDTHxHEIGHT, e.g. 1920x1080, according to --fit")
+	fs.StringVar(&opts.fit, "fit", opts.fit, "how --resize applies: contain, cover or exact")
+	fs.StringVar(&opts.cropValue, "crop", opts.cropValue, "crop outputs to WIDTHxHEIGHT+X+Y of the image as displayed, before --resize")
+	fs.StringVar(&opts.cropAspect, "crop-aspect", opts.cropAspect, "crop outputs to the largest centred area of this aspect ratio, e.g. 4:5")
+	fs.IntVar(&opts.rotate, "rotate", opts.rotate, "rotate outputs clockwise by 90, 180 or 270 degrees")
+	fs.StringVar(&opts.flip, "flip", opts.flip, "mirror outputs after --rotate: h (left to right) or v (top to bottom)")
+	fs.BoolVar(&opts.tile, "tile", opts.tile, "split images larger than --tile-size into overlapping JPEG tiles")
+	fs.IntVar(&opts.tileSize, "tile-size", opts.tileSize, "largest tile width/height in pixels, at most 65535")
+	fs.IntVar(&opts.tileOverlap, "tile-overlap", opts.tileOverlap, "pixels shared by neighbouring tiles")
+	fs.BoolVar(&opts.sessionDir, "session-dir", opts.sessionDir, "write each run into a new folder named by its start time, e.g. jpegs/2024-06-01T10-30")
+	fs.StringVar(&opts.existing, "existing", opts.existing, "when the output directory holds an earlier run: merge, clean (delete its outputs first) or skip (its sources)")
+	fs.StringVar(&opts.lock, "lock", opts.lock, "when another run is converting into the output directory: fail, wait for it, or ignore it")
+	fs.BoolVar(&opts.sidecar, "sidecar", opts.sidecar, "write a NAME.jpg.json provenance record next to every output")
+	fs.BoolVar(&opts.deleteSource, "delete-source", opts.deleteSource, "delete each HEIC file once it has been converted")
+	fs.BoolVar(&opts.useTrash, "use-trash", opts.useTrash, "with --delete-source, move sources to the trash / Recycle Bin instead of deleting them")
+	fs.BoolVar(&opts.copyVideos, "copy-videos", opts.copyVideos, "copy .mov, .mp4 and .m4v files to the output folder unchanged")
+	fs.StringVar(&opts.videoCommandValue, "video-command", opts.videoCommandValue, "transcode videos with this command instead of copying them, e.g. \"ffmpeg -i {in} {out}\" (implies --copy-videos)")
+	fs.StringVar(&opts.pluginValue, "plugin", opts.pluginValue, "transform each image with this command before encoding (PAM over stdin/stdout); separate several with |")
+	fs.BoolVar(&opts.onlyIfSmaller, "only-if-smaller", opts.onlyIfSmaller, "keep a JPEG only if it is smaller than its HEIC source")
+	fs.StringVar(&opts.ifLarger, "if-larger", opts.ifLarger, "with --only-if-smaller, what to do with larger JPEGs: skip, retry at lower quality, or copy the source")
+	fs.StringVar(&opts.rulesFile, "rules", opts.rulesFile, "JSON file of per-camera quality and size rules")
+	fs.StringVar(&opts.chmodValue, "chmod", opts.chmodValue, "set the permissions of every output file, e.g. 0644")
+	fs.StringVar(&opts.chownValue, "chown", opts.chownValue, "set the owner of every output file: user, user:group or :group (where permitted)")
+	fs.StringVar(&opts.quarantineDir, "quarantine-dir", opts.quarantineDir, "move empty, truncated or malformed HEIC files into this directory")
+	fs.IntVar(&opts.quality, "quality", opts.quality, "JPEG quality, 1-100")
+	fs.BoolVar(&opts.optimizeHuffman, "optimize-huffman", opts.optimizeHuffman, "build optimal Huffman tables for each JPEG, making it slightly smaller at no cost in quality")
+	fs.StringVar(&opts.jpegEncoderName, "jpeg-encoder", opts.jpegEncoderName, "JPEG encoder: stdlib, or the cjpeg of mozjpeg (smaller files) or libjpeg-turbo (faster)")
+	fs.IntVar(&opts.restartInterval, "restart-interval", opts.restartInterval, "add a JPEG restart marker every this many MCUs, so a damaged file loses less (0 for none)")
+	fs.StringVar(&opts.outputFormat, "output-format", opts.outputFormat, "jpeg, auto for PNG for screenshots and graphics and JPEG for photos, pdf to bind every converted image into one "+pdfFileName+", png for lossless PNGs, or ppm or raw-rgba for uncompressed pixels")
+	fs.StringVar(&opts.pageSize, "page-size", opts.pageSize, "PDF page size: image, a4 or letter")
+	fs.StringVar(&opts.bundle, "bundle", opts.bundle, "pack outputs into ready-to-send zips; only email: resized, smaller JPEGs named photo-001.jpg and so on")
+	fs.StringVar(&opts.bundleSizeValue, "bundle-size", opts.bundleSizeValue, "largest --bundle zip, e.g. 20MB; more photos go into further zips")
+	fs.StringVar(&opts.outputZip, "output-zip", opts.outputZip, "pack converted files into this zip archive instead of the output directory")
+	fs.StringVar(&opts.outputTar, "output-tar", opts.outputTar, "stream converted files into this tar archive as they finish, - for stdout")
+	fs.StringVar(&opts.zipPassword, "zip-password", opts.zipPassword, "encrypt the --output-zip archive with AES-256 using this password")
+	fs.StringVar(&opts.pageFit, "page-fit", opts.pageFit, "how images fill a4/letter PDF pages: contain or cover")
+	fs.StringVar(&opts.keepMetadata, "keep-metadata", opts.keepMetadata, "metadata copied to outputs: any of exif, xmp, icc, or none")
+	fs.StringVar(&opts.dropMetadata, "drop-metadata", opts.dropMetadata, "metadata removed from outputs: exif, xmp, icc or the tag groups gps, serial, camera, datetime, makernote, ids")
+	fs.StringVar(&opts.privacy, "privacy", opts.privacy, "metadata redaction profile: none, share (GPS, serial numbers, owner, maker note) or paranoid (also camera, times, unique IDs and XMP)")
+	fs.StringVar(&opts.alpha, "alpha", opts.alpha, "sources with transparency: png (save those files as PNG), flatten (onto --alpha-background) or ignore")
+	fs.StringVar(&opts.alphaBackgroundValue, "alpha-background", opts.alphaBackgroundValue, "colour transparent images are flattened onto, #rrggbb")
+	fs.BoolVar(&opts.strictMetadata, "strict-metadata", opts.strictMetadata, "fail files, writing no output, when an EXIF, XMP or ICC block that --keep-metadata keeps cannot be carried over")
+	fs.BoolVar(&opts.validateOutput, "validate-output", opts.validateOutput, "check every JPEG output has valid markers and decodes before writing it (default with --strict-metadata)")
+	fs.BoolVar(&opts.noEmbedThumbnail, "no-embed-thumbnail", opts.noEmbedThumbnail, "do not embed an EXIF preview thumbnail in outputs")
+	fs.BoolVar(&opts.autoEnhance, "auto-enhance", opts.autoEnhance, "correct the white balance, levels and brightness of each image, e.g. for underexposed phone photos")
+	fs.StringVar(&opts.targetProfileName, "target-profile", opts.targetProfileName, "convert colours to srgb, display-p3, adobe-rgb or an ICC profile file")
+	fs.StringVar(&opts.timeShiftValue, "time-shift", opts.timeShiftValue, "shift EXIF timestamps, e.g. +2h, -45m or +1d6h")
+	fs.StringVar(&opts.artist, "artist", opts.artist, "write this name into the EXIF Artist and IPTC By-line of every output")
+	fs.StringVar(&opts.copyright, "copyright", opts.copyright, "write this notice into the EXIF Copyright and IPTC Copyright Notice of every output")
+	fs.StringVar(&opts.assumeTimezoneName, "assume-timezone", opts.assumeTimezoneName, "for name templates, read EXIF times without an offset tag, and file times, in this time zone, e.g. +09:00 or Asia/Tokyo")
+	fs.StringVar(&opts.timezoneName, "set-timezone", opts.timezoneName, "record this time zone in EXIF offset tags, e.g. +02:00 or Europe/Paris")
+	fs.BoolVar(&opts.skipScreenshots, "skip-screenshots", opts.skipScreenshots, "do not convert iPhone/iPad screenshots")
+	fs.BoolVar(&opts.onlyScreenshots, "only-screenshots", opts.onlyScreenshots, "convert only iPhone/iPad screenshots")
+	fs.IntVar(&opts.minWidth, "min-width", opts.minWidth, "skip images narrower than this many pixels")
+	fs.IntVar(&opts.minHeight, "min-height", opts.minHeight, "skip images shorter than this many pixels")
+	fs.Float64Var(&opts.minMegapixels, "min-megapixels", opts.minMegapixels, "skip images of fewer megapixels, e.g. 8 to convert only full-resolution photos")
+	fs.Float64Var(&opts.maxMegapixels, "max-megapixels", opts.maxMegapixels, "skip images of more megapixels (0 for no limit)")
+	fs.IntVar(&opts.minRating, "min-rating", opts.minRating, "skip images rated fewer stars than this (1-5, from XMP or EXIF)")
+	fs.BoolVar(&opts.favoritesOnly, "favorites-only", opts.favoritesOnly, "convert only favourites: Photos library favourites, or 5-star images")
+	fs.StringVar(&opts.burst, "burst", opts.burst, "frames of iPhone bursts to convert: all, first or sharpest")
+	fs.BoolVar(&opts.watch, "watch", opts.watch, "keep running and convert HEIC files as they are added to the input directory")
+	fs.DurationVar(&opts.watchInterval, "watch-interval", opts.watchInterval, "how often --watch scans the input directory")
+	fs.DurationVar(&opts.watchSettle, "watch-settle", opts.watchSettle, "how long a file's size must stay the same, and a burst of new files stay quiet, before --watch converts them")
+	fs.IntVar(&opts.watchBatch, "watch-batch", opts.watchBatch, "most files --watch converts in one batch")
+	fs.BoolVar(&opts.watchRecursive, "watch-recursive", opts.watchRecursive, "with --watch, also convert and watch the folders inside the input directory, mirroring them in the output directory")
+	fs.BoolVar(&opts.airDrop, "airdrop", opts.airDrop, "macOS: watch the Downloads folder (unless an input is given) and convert only HEIC files received over AirDrop")
+	fs.StringVar(&opts.offloaded, "offloaded", opts.offloaded, "macOS: sources offloaded to iCloud by Optimize Mac Storage: download (them before converting) or skip")
+	fs.StringVar(&opts.scheduleValue, "schedule", opts.scheduleValue, "with --watch, convert only in these daily windows, e.g. 01:00-06:00")
+	fs.StringVar(&opts.listen, "listen", opts.listen, "address of the serve control API")
+	fs.BoolVar(&opts.dryRun, "dry-run", opts.dryRun, "list the files that would be converted and their output names, reading headers only")
+	fs.StringVar(&opts.planOnly, "plan-only", opts.planOnly, "write the sources, output names, conflicts and estimated output size of the run as JSON to this file, or - for stdout, without converting")
+	fs.StringVar(&opts.executePlan, "execute-plan", opts.executePlan, "convert the files of a plan written by --plan-only, with its settings and output names")
+	fs.StringVar(&opts.reportPath, "report", opts.reportPath, "write the outcome and output size of every source as JSON to this file, to compare runs with heictojpeg diff")
+	fs.StringVar(&opts.order, "order", opts.order, "processing order: name, size-asc, size-desc, date-asc, date-desc, directory")
+	fs.BoolVar(&opts.organizeByLocation, "organize-by-location", opts.organizeByLocation, "place outputs in country/city folders based on EXIF GPS data")
+	fs.BoolVar(&opts.sharedAlbums, "shared-albums", opts.sharedAlbums, "read the input as an Apple shared album export, converting its GUID folders into folders named after the albums")
+	fs.BoolVar(&opts.organizeByCamera, "organize-by-camera", opts.organizeByCamera, "place outputs in folders named after the EXIF camera model, e.g. iPhone 12")
+	fs.BoolVar(&opts.normalizeNames, "normalize-names", opts.normalizeNames, "lowercase extensions, replace spaces with underscores and drop characters FAT/exFAT cannot store in output names")
+	fs.StringVar(&opts.nameTemplateFile, "name-template-file", opts.nameTemplateFile, "Go text/template file used to name outputs")
+	fs.StringVar(&opts.splitSize, "split-size", opts.splitSize, "cap each batch-NNN output folder at this total size, e.g. 4GB")
+	fs.IntVar(&opts.splitCount, "split-count", opts.splitCount, "cap each batch-NNN output folder at this many files")
+	fs.BoolVar(&opts.stats, "stats", opts.stats, "print output size, compression ratio, duration and camera model distributions")
+	fs.BoolVar(&opts.computePHash, "compute-phash", opts.computePHash, "record a perceptual hash (pHash) of each converted image in the log and sidecar, for duplicate detection")
+	fs.BoolVar(&opts.mmap, "mmap", opts.mmap, "memory-map sources instead of reading them into memory, where the platform allows")
+	fs.BoolVar(&opts.lowMemory, "low-memory", opts.lowMemory, "convert one file at a time and encode straight to disk, for devices with little RAM")
+	fs.IntVar(&opts.ioWorkers, "io-workers", opts.ioWorkers, "files read and written at once, 0 to calibrate at startup; raise it for network shares")
+	fs.IntVar(&opts.decodeWorkers, "decode-workers", opts.decodeWorkers, "files decoded at once, 0 to calibrate at startup")
+	fs.IntVar(&opts.encodeWorkers, "encode-workers", opts.encodeWorkers, "files encoded at once, 0 to calibrate at startup")
+	fs.StringVar(&opts.maxMemoryValue, "max-memory", opts.maxMemoryValue, "memory to fit the run in, e.g. 512MB, instead of the container (cgroup) or ulimit -v limit")
+	fs.BoolVar(&opts.gui, "gui", opts.gui, "open the graphical launcher (Windows builds only)")
+	fs.StringVar(&opts.notifyWebhook, "notify-webhook", opts.notifyWebhook, "POST a summary to this URL when a run or watch cycle finishes or fails")
+	fs.StringVar(&opts.notifyFormat, "notify-format", opts.notifyFormat, "payload of --notify-webhook: json, slack or telegram")
+	fs.BoolVar(&opts.verbose, "verbose", opts.verbose, "print the decoder warnings of each file, such as colour information that is not applied, and log every skipped entry with its reason")
+	fs.BoolVar(&opts.showTUI, "tui", opts.showTUI, "show a full-screen table of the workers with each one's file, phase and time on it, the speed, and a scrollable pane of errors")
+	fs.BoolVar(&opts.nonInteractive, "non-interactive", opts.nonInteractive, "disable terminal-only features even when attached to a TTY")
+
+	return fs
+}
+
+// envName returns the environment variable that configures a flag.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets every flag that has a matching environment variable. It runs
+// before the command line is parsed so explicit flags take precedence.
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// parseOptions parses the command-line arguments (without the program name).
+// Flags may appear before, between or after the input paths. Every flag, and
+// the input path as HEICTOJPEG_INPUT, can also be set from the environment.
+func parseOptions(args []string, output io.Writer) (options, error) {
+	opts := defaultOptions()
+	fs := newFlagSet(&opts, output)
+
+	if err := applyEnv(fs); err != nil {
+		return opts, err
+	}
+	input, inputGiven := os.LookupEnv(envPrefix + "INPUT")
+	if inputGiven {
+		opts.inputPath = input
+	}
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return opts, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if opts.executePlan != "" {
+		if len(positional) > 0 {
+			return opts, fmt.Errorf("--execute-plan takes its inputs from the plan")
+		}
+		plan, err := loadPlan(opts.executePlan)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --execute-plan: %v", err)
+		}
+		if err := applyPlanSettings(fs, plan); err != nil {
+			return opts, fmt.Errorf("invalid --execute-plan: %v", err)
+		}
+		if opts.filesFrom == "" {
+			positional = plan.Inputs
+		}
+		opts.plan = plan
+	}
+
+	if len(positional) > 0 && opts.filesFrom != "" {
+		return opts, fmt.Errorf("cannot combine an input path with --files-from")
+	}
+	if len(positional) == 1 {
+		opts.inputPath = positional[0]
+	}
+	if len(positional) > 1 {
+		for _, path := range positional {
+			if path == stdioPath || isPhotosLibrary(path) {
+				return opts, fmt.Errorf("%s cannot be one of several input paths", path)
+			}
+		}
+		opts.inputPath = ""
+		opts.inputPaths = positional
+		// --folder-per-source defaults on here unless given, from the
+		// command line or the environment.
+		explicit := false
+		fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "folder-per-source" })
+		opts.folderPerSource = opts.folderPerSource || !explicit
+		if opts.folderPerSource {
+			if err := checkSourceFolders(positional); err != nil {
+				return opts, err
+			}
+		}
+	}
+
+	if opts.airDrop {
+		inbox, err := airDropInbox()
+		if err != nil {
+			return opts, err
+		}
+		if !inputGiven && len(positional) == 0 {
+			opts.inputPath = inbox
+		}
+		opts.watch = true
+	}
+
+	if opts.nonInteractive {
+		opts.interactive = false
+	}
+	if opts.showTUI && !opts.interactive {
+		return opts, fmt.Errorf("--tui needs a terminal, and cannot be combined with --non-interactive")
+	}
+	if opts.noEmbedThumbnail {
+		opts.embedThumbnail = false
+	}
+
+	if opts.skipScreenshots && opts.onlyScreenshots {
+		return opts, fmt.Errorf("--skip-screenshots and --only-screenshots are mutually exclusive")
+	}
+
+	if opts.minWidth < 0 || opts.minHeight < 0 {
+		return opts, fmt.Errorf("--min-width and --min-height must not be negative")
+	}
+	if opts.minMegapixels < 0 || opts.maxMegapixels < 0 {
+		return opts, fmt.Errorf("--min-megapixels and --max-megapixels must not be negative")
+	}
+	if opts.maxMegapixels > 0 && opts.maxMegapixels < opts.minMegapixels {
+		return opts, fmt.Errorf("--max-megapixels %g is below --min-megapixels %g", opts.maxMegapixels, opts.minMegapixels)
+	}
+	if opts.minRating < 0 || opts.minRating > maxRating {
+		return opts, fmt.Errorf("invalid --min-rating %d: must be between 1 and %d", opts.minRating, maxRating)
+	}
+
+	if !isValidOrder(opts.order) {
+		return opts, fmt.Errorf("invalid --order %q", opts.order)
+	}
+
+	if opts.bundle != "" {
+		if !isValidBundle(opts.bundle) {
+			return opts, fmt.Errorf("invalid --bundle %q", opts.bundle)
+		}
+		// The preset's resize and quality apply unless given, from the
+		// command line or the environment.
+		explicit := map[string]bool{}
+		fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+		if !explicit["resize"] {
+			opts.resizeValue = bundleEmailResize
+		}
+		if !explicit["quality"] {
+			opts.quality = bundleEmailQuality
+		}
+		if opts.bundleSizeValue == "" {
+			opts.bundleSizeValue = defaultBundleSize
+		}
+		size, err := parseByteSize(opts.bundleSizeValue)
+		if err != nil || size == 0 {
+			return opts, fmt.Errorf("invalid --bundle-size %q", opts.bundleSizeValue)
+		}
+		opts.bundleSize = size
+	} else if opts.bundleSizeValue != "" {
+		return opts, fmt.Errorf("--bundle-size needs --bundle")
+	}
+
+	if opts.quality < 1 || opts.quality > 100 {
+		return opts, fmt.Errorf("invalid --quality %d: must be between 1 and 100", opts.quality)
+	}
+	if opts.restartInterval < 0 || opts.restartInterval > maxRestartInterval {
+		return opts, fmt.Errorf("invalid --restart-interval %d: must be between 0 and %d", opts.restartInterval, maxRestartInterval)
+	}
+	if !isValidJPEGEncoder(opts.jpegEncoderName) {
+		return opts, fmt.Errorf("invalid --jpeg-encoder %q", opts.jpegEncoderName)
+	}
+	if opts.jpegEncoderName != encoderStdlib {
+		encoder, err := findJPEGEncoder(opts.jpegEncoderName)
+		if err != nil {
+			return opts, fmt.Errorf("--jpeg-encoder %s: %v", opts.jpegEncoderName, err)
+		}
+		opts.jpegEncoder = encoder
+	}
+
+	switch opts.outputFormat {
+	case formatJPEG, formatAuto, formatPDF, formatPNG, formatPPM, formatRawRGBA:
+	default:
+		return opts, fmt.Errorf("invalid --output-format %q", opts.outputFormat)
+	}
+	if !isValidPageSize(opts.pageSize) {
+		return opts, fmt.Errorf("invalid --page-size %q", opts.pageSize)
+	}
+	if !isValidPageFit(opts.pageFit) {
+		return opts, fmt.Errorf("invalid --page-fit %q", opts.pageFit)
+	}
+
+	if opts.timeShiftValue != "" {
+		shift, err := parseTimeShift(opts.timeShiftValue)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --time-shift: %v", err)
+		}
+		opts.timeShift = shift
+	}
+	if opts.timezoneName != "" {
+		zone, err := parseTimezone(opts.timezoneName)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --set-timezone: %v", err)
+		}
+		opts.timezone = zone
+	}
+	if opts.assumeTimezoneName != "" {
+		zone, err := parseTimezone(opts.assumeTimezoneName)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --assume-timezone: %v", err)
+		}
+		opts.assumeTimezone = zone
+	}
+
+	if opts.resizeValue != "" {
+		width, height, err := parseResize(opts.resizeValue)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --resize: %v", err)
+		}
+		opts.resizeWidth, opts.resizeHeight = width, height
+	}
+	if !isValidFit(opts.fit) {
+		return opts, fmt.Errorf("invalid --fit %q", opts.fit)
+	}
+	if !isValidRotation(opts.rotate) {
+		return opts, fmt.Errorf("invalid --rotate %d: must be 90, 180 or 270", opts.rotate)
+	}
+	if !isValidFlip(opts.flip) {
+		return opts, fmt.Errorf("invalid --flip %q: must be h or v", opts.flip)
+	}
+	if opts.cropValue != "" && opts.cropAspect != "" {
+		return opts, fmt.Errorf("--crop cannot be combined with --crop-aspect")
+	}
+	if opts.cropValue != "" {
+		crop, err := parseCrop(opts.cropValue)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --crop: %v", err)
+		}
+		opts.crop = crop
+	}
+	if opts.cropAspect != "" {
+		width, height, err := parseAspect(opts.cropAspect)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --crop-aspect: %v", err)
+		}
+		opts.aspectWidth, opts.aspectHeight = width, height
+	}
+
+	if opts.pluginValue != "" {
+		plugins, err := parsePlugins(opts.pluginValue)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --plugin: %v", err)
+		}
+		opts.plugins = plugins
+	}
+
+	if opts.videoCommandValue != "" {
+		command, err := parseVideoCommand(opts.videoCommandValue)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --video-command: %v", err)
+		}
+		opts.videoCommand, opts.copyVideos = command, true
+	}
+	if opts.copyVideos && opts.outputFormat == formatPDF {
+		return opts, fmt.Errorf("--copy-videos cannot be combined with --output-format pdf")
+	}
+
+	if !isValidExisting(opts.existing) {
+		return opts, fmt.Errorf("invalid --existing %q", opts.existing)
+	}
+	if opts.sessionDir && (opts.existing != "" || opts.outputTar != "" || isSFTPURL(opts.outputDir)) {
+		return opts, fmt.Errorf("--session-dir cannot be combined with --existing (session folders are always new), --output-tar or sftp:// output")
+	}
+	if !isValidLockMode(opts.lock) {
+		return opts, fmt.Errorf("invalid --lock %q", opts.lock)
+	}
+	if !isValidOffloaded(opts.offloaded) {
+		return opts, fmt.Errorf("invalid --offloaded %q", opts.offloaded)
+	}
+	if !isValidBurst(opts.burst) {
+		return opts, fmt.Errorf("invalid --burst %q", opts.burst)
+	}
+	if !isValidLargerPolicy(opts.ifLarger) {
+		return opts, fmt.Errorf("invalid --if-larger %q", opts.ifLarger)
+	}
+	if opts.onlyIfSmaller && opts.outputFormat != formatJPEG {
+		return opts, fmt.Errorf("--only-if-smaller needs --output-format jpeg")
+	}
+	if opts.inputPath == stdioPath {
+		if opts.filesFrom != "" || opts.outputFormat == formatPDF || opts.outputZip != "" || opts.outputTar != "" ||
+			opts.onlyIfSmaller || opts.watch || opts.dryRun || opts.outputDir != "" || opts.sidecar || opts.deleteSource || opts.showTUI {
+			return opts, fmt.Errorf("reading from stdin (-) cannot be combined with --files-from, --output-format pdf, --output-zip, --output-tar, --only-if-smaller, --watch, --dry-run, --output-dir, --sidecar, --delete-source or --tui")
+		}
+	}
+	if opts.planOnly != "" || opts.executePlan != "" {
+		if opts.planOnly != "" && opts.executePlan != "" {
+			return opts, fmt.Errorf("--plan-only and --execute-plan are mutually exclusive")
+		}
+		if opts.watch || opts.dryRun || opts.inputPath == stdioPath {
+			return opts, fmt.Errorf("--plan-only and --execute-plan cannot be combined with --watch, --dry-run or reading from stdin (-)")
+		}
+	}
+	if opts.reportPath != "" && (opts.dryRun || opts.planOnly != "") {
+		return opts, fmt.Errorf("--report cannot be combined with --dry-run or --plan-only, which do not convert")
+	}
+	if opts.sidecar && opts.outputFormat == formatPDF {
+		return opts, fmt.Errorf("--sidecar cannot be combined with --output-format pdf")
+	}
+	if !isValidAlpha(opts.alpha) {
+		return opts, fmt.Errorf("invalid --alpha %q", opts.alpha)
+	}
+	background, err := parseBackground(opts.alphaBackgroundValue)
+	if err != nil {
+		return opts, fmt.Errorf("invalid --alpha-background: %v", err)
+	}
+	opts.alphaBackground = background
+	if opts.strictMetadata && (opts.outputFormat == formatPDF || isRawFormat(opts.outputFormat)) {
+		return opts, fmt.Errorf("--strict-metadata cannot be combined with --output-format %s, which carries no metadata", opts.outputFormat)
+	}
+	if opts.strictMetadata {
+		// --validate-output defaults on here unless given, from the command
+		// line or the environment.
+		explicit := false
+		fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "validate-output" })
+		opts.validateOutput = opts.validateOutput || !explicit
+	}
+
+	if opts.tileSize < 1 || opts.tileSize > maxJPEGDimension {
+		return opts, fmt.Errorf("invalid --tile-size %d: must be between 1 and %d", opts.tileSize, maxJPEGDimension)
+	}
+	if opts.tileOverlap < 0 || opts.tileOverlap >= opts.tileSize {
+		return opts, fmt.Errorf("invalid --tile-overlap %d: must be smaller than --tile-size", opts.tileOverlap)
+	}
+
+	if opts.chmodValue != "" {
+		mode, err := parseFileMode(opts.chmodValue)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --chmod: %v", err)
+		}
+		opts.fileMode = mode
+	}
+	if opts.chownValue != "" {
+		uid, gid, err := parseOwner(opts.chownValue)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --chown: %v", err)
+		}
+		opts.uid, opts.gid = uid, gid
+	}
+
+	drop, err := privacyDrops(opts.privacy, opts.dropMetadata)
+	if err != nil {
+		return opts, err
+	}
+	metadata, err := parseMetadataPolicy(opts.keepMetadata, drop)
+	if err != nil {
+		return opts, err
+	}
+	opts.metadata = metadata
+
+	if opts.targetProfileName != "" {
+		profile, err := loadColorProfile(opts.targetProfileName)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --target-profile: %v", err)
+		}
+		opts.targetProfile = profile
+	}
+
+	if opts.rulesFile != "" {
+		rules, err := loadRules(opts.rulesFile)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --rules: %v", err)
+		}
+		opts.rules = rules
+	}
+
+	if opts.nameTemplateFile != "" {
+		tmpl, err := loadNameTemplate(opts.nameTemplateFile)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --name-template-file: %v", err)
+		}
+		opts.nameTemplate = tmpl
+		opts.nameClaims = newNameClaims()
+	}
+
+	var splitSize int64
+	if opts.splitSize != "" {
+		size, err := parseByteSize(opts.splitSize)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --split-size: %v", err)
+		}
+		splitSize = size
+	}
+	if opts.splitCount < 0 {
+		return opts, fmt.Errorf("invalid --split-count %d", opts.splitCount)
+	}
+	opts.splitter = newBatchSplitter(splitSize, opts.splitCount)
+
+	if opts.sharedAlbums {
+		if info, err := os.Stat(opts.inputPath); opts.filesFrom != "" || len(opts.inputPaths) > 0 || isPhotosLibrary(opts.inputPath) || err != nil || !info.IsDir() {
+			return opts, fmt.Errorf("--shared-albums needs the folder of a shared album export")
+		}
+		opts.library = &sharedAlbumExport{path: opts.inputPath}
+	} else if opts.filesFrom == "" && isPhotosLibrary(opts.inputPath) {
+		opts.library = &photosLibrary{path: opts.inputPath}
+	}
+
+	if opts.outputFormat == formatPDF && (opts.splitter != nil || isSFTPURL(opts.outputDir)) {
+		return opts, fmt.Errorf("--output-format pdf cannot be combined with --split-size, --split-count or sftp:// output")
+	}
+
+	if opts.zipPassword != "" && opts.outputZip == "" {
+		return opts, fmt.Errorf("--zip-password requires --output-zip")
+	}
+	if opts.outputZip != "" && (opts.outputFormat == formatPDF || isSFTPURL(opts.outputDir)) {
+		return opts, fmt.Errorf("--output-zip cannot be combined with --output-format pdf or sftp:// output")
+	}
+	if opts.outputTar != "" && (opts.outputDir != "" || opts.outputZip != "" || opts.outputFormat == formatPDF) {
+		return opts, fmt.Errorf("--output-tar cannot be combined with --output-dir, --output-zip or --output-format pdf")
+	}
+
+	if opts.bundle != "" && (opts.outputFormat != formatJPEG || opts.outputZip != "" || opts.outputTar != "" || opts.splitter != nil ||
+		opts.watch || opts.dryRun || opts.planOnly != "" || opts.deleteSource || opts.tile || isSFTPURL(opts.outputDir)) {
+		return opts, fmt.Errorf("--bundle cannot be combined with --output-format other than jpeg, --output-zip, --output-tar, --split-size, --split-count, --watch, --dry-run, --plan-only, --delete-source, --tile or sftp:// output")
+	}
+
+	if opts.useTrash && !opts.deleteSource {
+		return opts, fmt.Errorf("--use-trash requires --delete-source")
+	}
+	if opts.deleteSource {
+		// Bound and archived outputs only exist once the run is over, and
+		// a Photos library's originals belong to Photos, as do shared albums.
+		if opts.outputFormat == formatPDF || opts.outputZip != "" || opts.library != nil {
+			return opts, fmt.Errorf("--delete-source cannot be combined with --output-format pdf, --output-zip, a Photos library or --shared-albums")
+		}
+		if opts.useTrash {
+			trash, err := newTrash()
+			if err != nil {
+				return opts, fmt.Errorf("--use-trash: %v", err)
+			}
+			opts.trash = trash
+		}
+	}
+
+	if !isValidNotifyFormat(opts.notifyFormat) {
+		return opts, fmt.Errorf("invalid --notify-format %q", opts.notifyFormat)
+	}
+	if opts.notifyWebhook != "" {
+		if err := checkNotifyWebhook(opts.notifyWebhook, opts.notifyFormat); err != nil {
+			return opts, fmt.Errorf("invalid --notify-webhook: %v", err)
+		}
+	}
+
+	if opts.scheduleValue != "" {
+		if !opts.watch {
+			return opts, fmt.Errorf("--schedule needs --watch")
+		}
+		schedule, err := parseSchedule(opts.scheduleValue)
+		if err != nil {
+			return opts, fmt.Errorf("invalid --schedule: %v", err)
+		}
+		opts.schedule = schedule
+	}
+	if opts.watchRecursive && !opts.watch {
+		return opts, fmt.Errorf("--watch-recursive needs --watch")
+	}
+	if opts.watch {
+		if opts.watchInterval <= 0 {
+			return opts, fmt.Errorf("invalid --watch-interval %v", opts.watchInterval)
+		}
+		if opts.watchSettle < 0 {
+			return opts, fmt.Errorf("invalid --watch-settle %v", opts.watchSettle)
+		}
+		if opts.watchBatch < 1 {
+			return opts, fmt.Errorf("invalid --watch-batch %d: must be at least 1", opts.watchBatch)
+		}
+		if info, err := os.Stat(opts.inputPath); opts.filesFrom != "" || opts.library != nil || err != nil || !info.IsDir() {
+			return opts, fmt.Errorf("--watch needs an input directory")
+		}
+		if opts.dryRun || opts.outputFormat == formatPDF || opts.outputZip != "" || opts.outputTar != "" || isSFTPURL(opts.outputDir) {
+			return opts, fmt.Errorf("--watch cannot be combined with --dry-run, --output-format pdf, --output-zip, --output-tar or sftp:// output")
+		}
+	}
+
+	if opts.tile && (opts.outputFormat == formatPDF || opts.splitter != nil || isSFTPURL(opts.outputDir)) {
+		return opts, fmt.Errorf("--tile cannot be combined with --output-format pdf, --split-size, --split-count or sftp:// output")
+	}
+
+	if isSFTPURL(opts.outputDir) {
+		remote, err := parseSFTPURL(opts.outputDir)
+		if err != nil {
+			return opts, err
+		}
+		opts.remote = remote
+	}
+	if opts.sync && opts.remote == nil {
+		return opts, fmt.Errorf("--sync needs an sftp:// --output-dir")
+	}
+
+	var maxMemory int64
+	if opts.maxMemoryValue != "" {
+		size, err := parseByteSize(opts.maxMemoryValue)
+		if err != nil || size <= 0 {
+			return opts, fmt.Errorf("invalid --max-memory %q", opts.maxMemoryValue)
+		}
+		maxMemory = size
+	}
+	opts.limits = detectResourceLimits(maxMemory)
+	opts.workers = opts.limits.workers(runtime.NumCPU())
+	if opts.ioWorkers < 0 || opts.decodeWorkers < 0 || opts.encodeWorkers < 0 {
+		return opts, fmt.Errorf("invalid --io-workers, --decode-workers or --encode-workers: must be 0 or more")
+	}
+	if opts.lowMemory && opts.ioWorkers+opts.decodeWorkers+opts.encodeWorkers > 0 {
+		return opts, fmt.Errorf("--low-memory converts one file at a time and cannot be combined with --io-workers, --decode-workers or --encode-workers")
+	}
+	opts.stages = &stageWorkers{io: opts.ioWorkers, decode: opts.decodeWorkers, encode: opts.encodeWorkers}
+	if opts.limits.memory > 0 && opts.limits.memory < lowMemoryLimit {
+		opts.lowMemory = true
+	}
+
+	return opts, nil
+}
+
+// isTerminal reports whether f is attached to a character device.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
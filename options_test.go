@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestParseOptionsFromEnv(t *testing.T) {
+	t.Setenv("HEICTOJPEG_ORDER", "size-desc")
+	t.Setenv("HEICTOJPEG_OUTPUT_DIR", "/srv/out")
+	t.Setenv("HEICTOJPEG_NON_INTERACTIVE", "true")
+	t.Setenv("HEICTOJPEG_INPUT", "/srv/in")
+
+	opts, err := parseOptions(nil, io.Discard)
+	if err != nil {
+		t.Fatalf("parseOptions failed: %v", err)
+	}
+	if opts.order != orderSizeDesc || opts.outputDir != "/srv/out" || opts.inputPath != "/srv/in" {
+		t.Fatalf("environment not applied: %+v", opts)
+	}
+	if opts.interactive {
+		t.Fatal("expected interactive features to be disabled")
+	}
+
+	// Explicit arguments win over the environment.
+	opts, err = parseOptions([]string{"--order", "name", "photos"}, io.Discard)
+	if err != nil {
+		t.Fatalf("parseOptions failed: %v", err)
+	}
+	if opts.order != orderName || opts.inputPath != "photos" {
+		t.Fatalf("flags did not override environment: %+v", opts)
+	}
+}
+
+func TestParseOptionsInvalidEnv(t *testing.T) {
+	t.Setenv("HEICTOJPEG_NON_INTERACTIVE", "maybe")
+	if _, err := parseOptions(nil, io.Discard); err == nil {
+		t.Fatal("expected error for invalid environment value")
+	}
+}
+
+func TestParseOptionsQuality(t *testing.T) {
+	opts, err := parseOptions([]string{"--quality", "90"}, io.Discard)
+	if err != nil || opts.quality != 90 {
+		t.Fatalf("expected quality 90, got %d (%v)", opts.quality, err)
+	}
+	if _, err := parseOptions([]string{"--quality", "0"}, io.Discard); err == nil {
+		t.Fatal("expected error for out-of-range quality")
+	}
+}
+
+func TestParseOptionsMegapixels(t *testing.T) {
+	opts, err := parseOptions([]string{"--min-megapixels", "8", "--max-megapixels", "50"}, io.Discard)
+	if err != nil || opts.minMegapixels != 8 || opts.maxMegapixels != 50 {
+		t.Fatalf("unexpected megapixel range %g-%g (%v)", opts.minMegapixels, opts.maxMegapixels, err)
+	}
+	if _, err := parseOptions([]string{"--min-megapixels", "8", "--max-megapixels", "2"}, io.Discard); err == nil {
+		t.Fatal("expected error for an empty megapixel range")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	orderName     = "name"
+	orderSizeAsc  = "size-asc"
+	orderSizeDesc = "size-desc"
+	orderDateAsc  = "date-asc"
+	orderDateDesc = "date-desc"
+
+	// orderDirectory keeps the order the filesystem lists files in, which
+	// lets a directory be converted while it is still being listed.
+	orderDirectory = "directory"
+)
+
+func isValidOrder(order string) bool {
+	switch order {
+	case orderName, orderSizeAsc, orderSizeDesc, orderDateAsc, orderDateDesc, orderDirectory:
+		return true
+	}
+	return false
+}
+
+// sortFiles returns a copy of files arranged in the requested processing order.
+// Entries whose info cannot be read sort as empty and infinitely old.
+func sortFiles(files []os.DirEntry, order string) []os.DirEntry {
+	sorted := make([]os.DirEntry, len(files))
+	copy(sorted, files)
+	if order == orderDirectory {
+		return sorted
+	}
+
+	sizes := make(map[string]int64, len(files))
+	dates := make(map[string]time.Time, len(files))
+	if order != orderName {
+		for _, file := range sorted {
+			if info, err := file.Info(); err == nil && info != nil {
+				sizes[file.Name()] = info.Size()
+				dates[file.Name()] = info.ModTime()
+			}
+		}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Name(), sorted[j].Name()
+		switch order {
+		case orderSizeAsc:
+			if sizes[a] != sizes[b] {
+				return sizes[a] < sizes[b]
+			}
+		case orderSizeDesc:
+			if sizes[a] != sizes[b] {
+				return sizes[a] > sizes[b]
+			}
+		case orderDateAsc:
+			if !dates[a].Equal(dates[b]) {
+				return dates[a].Before(dates[b])
+			}
+		case orderDateDesc:
+			if !dates[a].Equal(dates[b]) {
+				return dates[a].After(dates[b])
+			}
+		}
+		return a < b
+	})
+
+	return sorted
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSortFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	fixtures := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{"a.heic", 30, 2 * time.Hour},
+		{"b.heic", 10, 0},
+		{"c.heic", 20, time.Hour},
+	}
+	for _, f := range fixtures {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, make([]byte, f.size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := base.Add(-f.age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][]string{
+		orderName:     {"a.heic", "b.heic", "c.heic"},
+		orderSizeAsc:  {"b.heic", "c.heic", "a.heic"},
+		orderSizeDesc: {"a.heic", "c.heic", "b.heic"},
+		orderDateAsc:  {"a.heic", "c.heic", "b.heic"},
+		orderDateDesc: {"b.heic", "c.heic", "a.heic"},
+	}
+	for order, want := range tests {
+		sorted := sortFiles(entries, order)
+		for i, entry := range sorted {
+			if entry.Name() != want[i] {
+				t.Errorf("order %s: position %d got %s, want %s", order, i, entry.Name(), want[i])
+			}
+		}
+	}
+}
+
+func TestParseOptionsOrder(t *testing.T) {
+	opts, err := parseOptions([]string{"testdata/images", "--order", "date-desc"}, os.Stderr)
+	if err != nil {
+		t.Fatalf("parseOptions failed: %v", err)
+	}
+	if opts.inputPath != "testdata/images" || opts.order != orderDateDesc {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+
+	if _, err := parseOptions([]string{"--order", "random"}, os.Stderr); err == nil {
+		t.Fatal("expected error for invalid order")
+	}
+}
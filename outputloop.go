@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// plannedOutputDir is the directory resolveOutputDir will write the outputs
+// of the input in currentDir to, or "" for a temporary staging directory,
+// which is never inside an input. It is known before anything is created,
+// so scans of the input can leave it out; --session-dir folders are made
+// inside it.
+func plannedOutputDir(currentDir string, opts options) string {
+	switch {
+	case opts.remote != nil || opts.outputTar != "":
+		return ""
+	case opts.outputDir != "":
+		return opts.outputDir
+	case opts.library != nil:
+		return opts.library.defaultOutputDir()
+	default:
+		return filepath.Join(currentDir, "jpegs")
+	}
+}
+
+// sameDir reports whether a and b are the same existing directory, however
+// they are spelt: relative or absolute, through symlinks, or in another
+// case on case-insensitive filesystems.
+func sameDir(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	return err == nil && os.SameFile(infoA, infoB)
+}
+
+// checkOutputLoop refuses to write outputs into an input directory when
+// they would be taken for sources: HEIC copies made by --if-larger copy,
+// videos copied by --copy-videos, which could even overwrite themselves,
+// or anything --watch picks up. Subfolders of the input, such as the
+// default jpegs folder, are never scanned as sources.
+func checkOutputLoop(inputDirs []string, jpegDir string, opts options) error {
+	risky := ""
+	switch {
+	case opts.copyVideos:
+		risky = "--copy-videos"
+	case opts.onlyIfSmaller && opts.ifLarger == largerCopy:
+		risky = "--if-larger copy"
+	case opts.watch:
+		risky = "--watch"
+	default:
+		return nil
+	}
+	for _, dir := range inputDirs {
+		if sameDir(dir, jpegDir) {
+			return fmt.Errorf("the output directory %s is the input directory, where %s would convert its own outputs again; choose another --output-dir", jpegDir, risky)
+		}
+	}
+	return nil
+}
+
+// inputDirs lists the directories the sources of opts are read from, for
+// checkOutputLoop: currentDir, or each directory among several inputs.
+func inputDirs(currentDir string, opts options) []string {
+	if len(opts.inputPaths) == 0 {
+		return []string{currentDir}
+	}
+	var dirs []string
+	for _, path := range opts.inputPaths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlannedOutputDir(t *testing.T) {
+	opts := defaultOptions()
+	if got := plannedOutputDir("in", opts); got != filepath.Join("in", "jpegs") {
+		t.Errorf("default: got %s", got)
+	}
+	opts.outputDir = "out"
+	if got := plannedOutputDir("in", opts); got != "out" {
+		t.Errorf("--output-dir: got %s", got)
+	}
+	opts.outputTar = "-"
+	if got := plannedOutputDir("in", opts); got != "" {
+		t.Errorf("--output-tar: got %s", got)
+	}
+}
+
+func TestSameDir(t *testing.T) {
+	dir := t.TempDir()
+	other := t.TempDir()
+	if !sameDir(dir, filepath.Join(dir, "..", filepath.Base(dir))) {
+		t.Error("same directory spelt differently")
+	}
+	if sameDir(dir, other) || sameDir(dir, "") || sameDir(dir, filepath.Join(dir, "missing")) {
+		t.Error("different directories taken for the same")
+	}
+	link := filepath.Join(other, "link")
+	if err := os.Symlink(dir, link); err == nil && !sameDir(dir, link) {
+		t.Error("symlink to the directory not recognised")
+	}
+}
+
+func TestCheckOutputLoop(t *testing.T) {
+	dir := t.TempDir()
+	opts := defaultOptions()
+	if err := checkOutputLoop([]string{dir}, dir, opts); err != nil {
+		t.Errorf("JPEGs next to their sources: %v", err)
+	}
+	for _, risky := range []func(*options){
+		func(o *options) { o.copyVideos = true },
+		func(o *options) { o.onlyIfSmaller, o.ifLarger = true, largerCopy },
+		func(o *options) { o.watch = true },
+	} {
+		opts := defaultOptions()
+		risky(&opts)
+		if err := checkOutputLoop([]string{t.TempDir(), dir}, dir, opts); err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+		if err := checkOutputLoop([]string{dir}, filepath.Join(dir, "jpegs"), opts); err != nil {
+			t.Errorf("output in a subfolder: %v", err)
+		}
+	}
+}
+
+func TestOutputLoopRefused(t *testing.T) {
+	dir := t.TempDir()
+	video := filepath.Join(dir, "IMG_0001.MOV")
+	if err := os.WriteFile(video, []byte("motion"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--copy-videos", "--output-dir", dir, "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(opts); err == nil || !strings.Contains(err.Error(), "input directory") {
+		t.Fatalf("expected the output loop to be refused, got %v", err)
+	}
+	if data, _ := os.ReadFile(video); string(data) != "motion" {
+		t.Errorf("source video changed to %q", data)
+	}
+}
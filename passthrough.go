@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	"github.com/adrium/goheif/heif"
+	"github.com/iancleary/heictojpeg/heic"
+)
+
+// JPEG markers looked at when splicing metadata into a bitstream.
+const (
+	markerSOI   = 0xd8
+	markerEOI   = 0xd9
+	markerSOS   = 0xda
+	markerAPP1  = 0xe1
+	markerAPP2  = 0xe2
+	markerAPP13 = 0xed
+)
+
+// primaryJPEG returns the bitstream of the primary image when it is
+// JPEG-coded. Items whose JPEG header is kept apart in a jpgC property do not
+// start with a SOI marker and are left to the decoder.
+func primaryJPEG(r io.ReaderAt) ([]byte, bool) {
+	hf := heif.Open(r)
+	item, err := hf.PrimaryItem()
+	if err != nil || item.Info == nil || item.Info.ItemType != heic.JPEGItemType {
+		return nil, false
+	}
+	data, err := hf.GetItemData(item)
+	if err != nil || len(data) < 4 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, false
+	}
+	return data, true
+}
+
+// canPassThrough reports whether a JPEG bitstream can be written out as it
+// is: it is a valid JPEG and nothing asked for changes its pixels, its
+// encoding or its size.
+func canPassThrough(data []byte, opts options) bool {
+	config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	bounds := image.Rect(0, 0, config.Width, config.Height)
+	return outputKind(bounds, opts) == outputJPEG && opts.maxPixels == 0 && opts.resizeWidth == 0 &&
+		opts.crop.Empty() && opts.aspectWidth == 0 && opts.rotate == 0 && opts.flip == "" &&
+		opts.targetProfile == nil && len(opts.plugins) == 0 && !opts.autoEnhance && !opts.onlyIfSmaller
+}
+
+// preparePassthrough is decodeImage for a JPEG bitstream written out as it
+// is: it picks the ICC profile and adds the EXIF thumbnail, the only step
+// that needs the pixels.
+func preparePassthrough(fileInput *bytes.Reader, data, exif []byte, opts options) ([]byte, options) {
+	if opts.metadata.icc {
+		opts.iccProfile = sourceICC(fileInput)
+	}
+	if opts.embedThumbnail && opts.metadata.exif {
+		if img, err := jpeg.Decode(bytes.NewReader(data)); err == nil {
+			if withThumbnail, err := embedThumbnail(exif, img); err == nil {
+				exif = withThumbnail
+			}
+		}
+	}
+	return exif, opts
+}
+
+// writePassthroughJPEG writes the JPEG bitstream data with the output's
+// metadata. The source's EXIF, XMP, ICC and IPTC segments are replaced by
+// the output's, or dropped when --keep-metadata leaves the block out; the
+// other segments and the compressed image are copied unchanged, unless
+// --optimize-huffman or --restart-interval re-code the latter.
+func writePassthroughJPEG(encoded *bytes.Buffer, data, exif []byte, opts options) error {
+	if _, err := writeJPEGHeader(encoded, exif, opts); err != nil {
+		return err
+	}
+	if opts.optimizeHuffman || opts.restartInterval > 0 {
+		// Re-coding is lossless; progressive bitstreams are kept as they are.
+		if recoded, err := recodeJPEG(data, opts.optimizeHuffman, opts.restartInterval); err == nil {
+			data = recoded
+		}
+	}
+
+	replaced := func(marker byte, payload []byte) bool {
+		switch {
+		case marker == markerAPP1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			return exif != nil || !opts.metadata.exif
+		case marker == markerAPP1 && bytes.HasPrefix(payload, xmpNamespace):
+			return opts.xmpPacket != nil || !opts.metadata.xmp
+		case marker == markerAPP2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")):
+			return opts.iccProfile != nil || !opts.metadata.icc
+		case marker == markerAPP13 && bytes.HasPrefix(payload, photoshopNamespace):
+			return opts.artist != "" || opts.copyright != ""
+		}
+		return false
+	}
+
+	rest := data[2:]
+	for {
+		// Markers may be preceded by any number of 0xff fill bytes.
+		for len(rest) > 1 && rest[0] == 0xff && rest[1] == 0xff {
+			rest = rest[1:]
+		}
+		if len(rest) < 2 || rest[0] != 0xff {
+			return fmt.Errorf("malformed JPEG bitstream")
+		}
+		marker := rest[1]
+		if marker == markerSOS || marker == markerEOI {
+			// The scans run to the end of the bitstream.
+			_, err := encoded.Write(rest)
+			return err
+		}
+		if len(rest) < 4 {
+			return fmt.Errorf("malformed JPEG bitstream")
+		}
+		length := int(rest[2])<<8 | int(rest[3])
+		if length < 2 || len(rest) < 2+length {
+			return fmt.Errorf("malformed JPEG bitstream")
+		}
+		segment := rest[:2+length]
+		if !replaced(marker, segment[4:]) {
+			encoded.Write(segment)
+		}
+		rest = rest[2+length:]
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBox encodes an ISO BMFF box.
+func testBox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(box, typ...), body...)
+}
+
+// testFullBox encodes a full box of version 0 with no flags.
+func testFullBox(typ string, payload ...[]byte) []byte {
+	return testBox(typ, append([][]byte{{0, 0, 0, 0}}, payload...)...)
+}
+
+// buildJPEGHEIF wraps a JPEG bitstream in a HEIF file as its primary,
+// JPEG-coded item.
+func buildJPEGHEIF(bitstream []byte) []byte {
+	u16 := func(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+	u32 := func(v int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(v)) }
+	ftyp := testBox("ftyp", []byte("mif1"), u32(0), []byte("mif1heic"))
+	meta := func(offset int) []byte {
+		return testFullBox("meta",
+			testFullBox("hdlr", u32(0), []byte("pict"), make([]byte, 13)),
+			testFullBox("pitm", u16(1)),
+			testFullBox("iinf", u16(1), testBox("infe", []byte{2, 0, 0, 0}, u16(1), u16(0), []byte("jpeg\x00"))),
+			// 4-byte offsets and lengths, no base offset.
+			testFullBox("iloc", []byte{0x44, 0x00}, u16(1), u16(1), u16(0), u16(1), u32(offset), u32(len(bitstream))),
+		)
+	}
+	offset := len(ftyp) + len(meta(0)) + 8
+	return bytes.Join([][]byte{ftyp, meta(offset), testBox("mdat", bitstream)}, nil)
+}
+
+func testJPEG(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	// Give the bitstream an EXIF segment of its own, as camera JPEGs have.
+	exif := buildTestExif([]testTag{asciiTag(0x010f, "Source")}, nil, nil)
+	segment := append([]byte{0xff, 0xe1, 0, 0}, append([]byte("Exif\x00\x00"), exif...)...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return append(append([]byte{0xff, 0xd8}, segment...), buf.Bytes()[2:]...)
+}
+
+func TestPrimaryJPEG(t *testing.T) {
+	bitstream := testJPEG(t)
+	data, ok := primaryJPEG(bytes.NewReader(buildJPEGHEIF(bitstream)))
+	if !ok || !bytes.Equal(data, bitstream) {
+		t.Fatalf("primaryJPEG found %d bytes, ok %v", len(data), ok)
+	}
+	camel, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := primaryJPEG(bytes.NewReader(camel)); ok {
+		t.Error("HEVC image taken for JPEG")
+	}
+
+	opts := defaultOptions()
+	if !canPassThrough(bitstream, opts) {
+		t.Error("no passthrough with default options")
+	}
+	opts.resizeWidth, opts.resizeHeight = 32, 32
+	if canPassThrough(bitstream, opts) {
+		t.Error("passthrough despite --resize")
+	}
+}
+
+func TestConvertJPEGCodedHEIC(t *testing.T) {
+	bitstream := testJPEG(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "wrapped.heic"), buildJPEGHEIF(bitstream), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	opts.artist = "Jane Doe"
+
+	results := make(chan *fileResult, 1)
+	runPipeline(sendEntries([]os.DirEntry{&mockDirEntry{name: "wrapped.heic"}}), dir, dir, opts, results)
+	result := <-results
+	if result.err != nil || !result.extracted {
+		t.Fatalf("result = %+v", result)
+	}
+	output, err := os.ReadFile(filepath.Join(dir, result.output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The compressed image is copied as it is.
+	sos := bytes.Index(bitstream, []byte{0xff, 0xda})
+	if !bytes.HasSuffix(output, bitstream[sos:]) {
+		t.Error("scan data re-encoded")
+	}
+	// The source's own EXIF is replaced by the edited one.
+	if bytes.Count(output, []byte("Exif\x00\x00")) != 1 || !bytes.Contains(output, []byte("Jane Doe")) {
+		t.Error("EXIF not replaced")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(output)); err != nil {
+		t.Errorf("output does not decode: %v", err)
+	}
+
+	// With a resize the image is decoded and encoded again.
+	opts.resizeWidth, opts.resizeHeight = 32, 32
+	results = make(chan *fileResult, 1)
+	runPipeline(sendEntries([]os.DirEntry{&mockDirEntry{name: "wrapped.heic"}}), dir, dir, opts, results)
+	if result := <-results; result.err != nil || result.extracted {
+		t.Fatalf("resized result = %+v", result)
+	}
+	output, _ = os.ReadFile(filepath.Join(dir, "wrapped.jpg"))
+	config, err := jpeg.DecodeConfig(bytes.NewReader(output))
+	if err != nil || config.Width != 32 {
+		t.Errorf("resized to %dx%d, %v", config.Width, config.Height, err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// keyInput hands the key presses on a terminal set up by enableKeyInput to
+// a key handler, such as pauseControl.handleKeys, until it is closed, which
+// also restores the terminal.
+type keyInput struct {
+	r       io.Reader
+	restore func()
+	done    chan struct{}
+	exited  chan struct{}
+	once    sync.Once
+}
+
+// startKeyInput runs handle on the key presses read from r in a goroutine
+// of its own. restore undoes enableKeyInput.
+func startKeyInput(r io.Reader, restore func(), handle func(io.Reader)) *keyInput {
+	k := &keyInput{r: r, restore: restore, done: make(chan struct{}), exited: make(chan struct{})}
+	go func() {
+		defer close(k.exited)
+		handle(k)
+	}()
+	return k
+}
+
+// Read reads key presses, and fails once the input is closed.
+func (k *keyInput) Read(p []byte) (int, error) {
+	for {
+		select {
+		case <-k.done:
+			return 0, io.EOF
+		default:
+		}
+		n, err := k.r.Read(p)
+		if n == 0 && err == io.EOF && keyInputPolls {
+			continue // no key pressed yet
+		}
+		return n, err
+	}
+}
+
+// close stops the key handler and restores the terminal. Where reads block
+// until a key is pressed, the handler is left to end with the process.
+func (k *keyInput) close() {
+	k.once.Do(func() {
+		close(k.done)
+		if keyInputPolls {
+			<-k.exited
+		}
+		k.restore()
+	})
+}
+
+// pauseControl lets a running batch be paused between files. The pipeline
+// calls wait before reading each file, so files already read finish.
+type pauseControl struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func newPauseControl() *pauseControl {
+	p := &pauseControl{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// pause reports whether the batch was running before the call.
+func (p *pauseControl) pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wasRunning := !p.paused
+	p.paused = true
+	return wasRunning
+}
+
+// resume reports whether the batch was paused before the call.
+func (p *pauseControl) resume() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wasPaused := p.paused
+	p.paused = false
+	p.cond.Broadcast()
+	return wasPaused
+}
+
+// isPaused reports whether the batch is paused.
+func (p *pauseControl) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// wait blocks while the batch is paused.
+func (p *pauseControl) wait() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.paused {
+		p.cond.Wait()
+	}
+}
+
+// handleKeys pauses on "p" and resumes on "r" read from r, until r fails.
+func (p *pauseControl) handleKeys(r io.Reader, out io.Writer) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := r.Read(buf); err != nil {
+			return
+		}
+		switch buf[0] {
+		case 'p', 'P':
+			if p.pause() {
+				fmt.Fprintln(out, "Pausing after the files in progress; press r to resume.")
+			}
+		case 'r', 'R':
+			if p.resume() {
+				fmt.Fprintln(out, "Resuming.")
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPauseControlBlocksUntilResumed(t *testing.T) {
+	p := newPauseControl()
+	p.handleKeys(strings.NewReader("xp"), io.Discard)
+
+	started := make(chan struct{})
+	go func() {
+		p.wait()
+		close(started)
+	}()
+
+	select {
+	case <-started:
+		t.Fatal("worker started while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	p.handleKeys(strings.NewReader("r"), io.Discard)
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("worker still blocked after resume")
+	}
+
+	if p.resume() {
+		t.Fatal("resume of a running batch should report it was not paused")
+	}
+}
+
+// idleTerminal reads like a terminal after enableKeyInput: its keys, then
+// nothing, a tenth of a second at a time.
+type idleTerminal struct{ keys string }
+
+func (r *idleTerminal) Read(p []byte) (int, error) {
+	if r.keys == "" {
+		time.Sleep(10 * time.Millisecond)
+		return 0, io.EOF
+	}
+	n := copy(p, r.keys)
+	r.keys = r.keys[n:]
+	return n, nil
+}
+
+func TestKeyInputClose(t *testing.T) {
+	if !keyInputPolls {
+		t.Skip("terminal reads block until a key is pressed")
+	}
+	p := newPauseControl()
+	restored := make(chan struct{})
+	keys := startKeyInput(&idleTerminal{keys: "p"}, func() { close(restored) }, func(r io.Reader) { p.handleKeys(r, io.Discard) })
+	for deadline := time.Now().Add(time.Second); !p.isPaused(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("key press not handled")
+		}
+	}
+
+	keys.close()
+	select {
+	case <-keys.exited:
+	default:
+		t.Error("key handler still running after close")
+	}
+	select {
+	case <-restored:
+	default:
+		t.Error("terminal not restored")
+	}
+	keys.close() // restores once only
+}
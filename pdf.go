@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	formatJPEG = "jpeg"
+	formatPDF  = "pdf"
+
+	pdfFileName = "converted.pdf"
+
+	pageSizeImage  = "image"
+	pageSizeA4     = "a4"
+	pageSizeLetter = "letter"
+
+	pageFitContain = "contain"
+	pageFitCover   = "cover"
+)
+
+// pageSizes are portrait page dimensions in PDF points.
+var pageSizes = map[string][2]float64{
+	pageSizeA4:     {595.28, 841.89},
+	pageSizeLetter: {612, 792},
+}
+
+func isValidPageSize(size string) bool {
+	_, ok := pageSizes[size]
+	return ok || size == pageSizeImage
+}
+
+func isValidPageFit(fit string) bool {
+	return fit == pageFitContain || fit == pageFitCover
+}
+
+// pdfWriter tracks byte offsets of the objects it writes for the xref table.
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int64
+	offsets []int64
+}
+
+func (p *pdfWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.n += int64(n)
+	return n, err
+}
+
+func (p *pdfWriter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(p, format, args...)
+}
+
+func (p *pdfWriter) beginObject(id int) {
+	p.offsets[id] = p.n
+	p.printf("%d 0 obj\n", id)
+}
+
+// writePDF binds JPEG files into a PDF with one image per page. The JPEG data
+// is embedded as-is (DCTDecode), so nothing is re-encoded. With pageSize
+// "image" each page matches its image at 72 dpi; otherwise pages are turned
+// to the image's orientation and the image is scaled to fit.
+func writePDF(out io.Writer, jpegPaths []string, pageSize, fit string) error {
+	p := &pdfWriter{w: bufio.NewWriter(out), offsets: make([]int64, 3+3*len(jpegPaths))}
+	p.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	p.beginObject(1)
+	p.printf("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+
+	kids := make([]string, len(jpegPaths))
+	for i := range jpegPaths {
+		kids[i] = fmt.Sprintf("%d 0 R", 3+3*i)
+	}
+	p.beginObject(2)
+	p.printf("<< /Type /Pages /Kids [%s] /Count %d >>\nendobj\n", strings.Join(kids, " "), len(jpegPaths))
+
+	for i, path := range jpegPaths {
+		if err := writePDFPage(p, 3+3*i, path, pageSize, fit); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	xref := p.n
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", len(p.offsets))
+	for _, offset := range p.offsets[1:] {
+		p.printf("%010d 00000 n \n", offset)
+	}
+	p.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.offsets), xref)
+	return p.w.Flush()
+}
+
+// centerOffset centres a length within a page length, snapping rounding
+// noise to zero so it is not written as "-0.00".
+func centerOffset(page, length float64) float64 {
+	offset := (page - length) / 2
+	if offset > -0.005 && offset < 0.005 {
+		return 0
+	}
+	return offset
+}
+
+// writePDFPage writes the page, content stream and image objects for one
+// JPEG, numbered id, id+1 and id+2.
+func writePDFPage(p *pdfWriter, id int, path, pageSize, fit string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	imgW, imgH := float64(config.Width), float64(config.Height)
+	pageW, pageH := imgW, imgH
+	x, y, w, h := 0.0, 0.0, imgW, imgH
+	if size, ok := pageSizes[pageSize]; ok {
+		pageW, pageH = size[0], size[1]
+		if imgW > imgH {
+			pageW, pageH = pageH, pageW
+		}
+		scale := pageW / imgW
+		if s := pageH / imgH; (fit == pageFitContain) == (s < scale) {
+			scale = s
+		}
+		w, h = imgW*scale, imgH*scale
+		x, y = centerOffset(pageW, w), centerOffset(pageH, h)
+	}
+
+	p.beginObject(id)
+	p.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /XObject << /Im0 %d 0 R >> >> /Contents %d 0 R >>\nendobj\n",
+		pageW, pageH, id+2, id+1)
+
+	content := fmt.Sprintf("q %.2f 0 0 %.2f %.2f %.2f cm /Im0 Do Q\n", w, h, x, y)
+	p.beginObject(id + 1)
+	p.printf("<< /Length %d >>\nstream\n%sendstream\nendobj\n", len(content), content)
+
+	colorSpace := "/DeviceRGB"
+	if config.ColorModel == color.GrayModel {
+		colorSpace = "/DeviceGray"
+	}
+	p.beginObject(id + 2)
+	p.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n",
+		config.Width, config.Height, colorSpace, info.Size())
+	if _, err := io.Copy(p, f); err != nil {
+		return err
+	}
+	p.printf("\nendstream\nendobj\n")
+	return nil
+}
+
+// bindPDF writes the converted results, which live in stagingDir, to a PDF at
+// pdfPath in processing order.
+func bindPDF(pdfPath, stagingDir string, results []*fileResult, index map[string]int, opts options) error {
+	sort.Slice(results, func(i, j int) bool { return index[results[i].name] < index[results[j].name] })
+	pages := make([]string, len(results))
+	for i, result := range results {
+		pages[i] = filepath.Join(stagingDir, result.output)
+	}
+
+	f, err := os.Create(pdfPath)
+	if err != nil {
+		return err
+	}
+	if err := writePDF(f, pages, opts.pageSize, opts.pageFit); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeTestJPEG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWritePDF(t *testing.T) {
+	dir := t.TempDir()
+	landscape := filepath.Join(dir, "landscape.jpg")
+	portrait := filepath.Join(dir, "portrait.jpg")
+	writeTestJPEG(t, landscape, image.NewRGBA(image.Rect(0, 0, 400, 200)))
+	writeTestJPEG(t, portrait, image.NewGray(image.Rect(0, 0, 100, 300)))
+
+	var buf bytes.Buffer
+	if err := writePDF(&buf, []string{landscape, portrait}, pageSizeA4, pageFitContain); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.String()
+
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatal("missing PDF header or trailer")
+	}
+	if !strings.Contains(pdf, "/Count 2") {
+		t.Fatal("expected two pages")
+	}
+	// A4 turned landscape for the wide image, with the image fitted to width.
+	if !strings.Contains(pdf, "/MediaBox [0 0 841.89 595.28]") || !strings.Contains(pdf, "q 841.89 0 0 420.95 0.00 87.17 cm") {
+		t.Fatalf("unexpected landscape page geometry:\n%s", pdf[:600])
+	}
+	if !strings.Contains(pdf, "/ColorSpace /DeviceGray") {
+		t.Fatal("grayscale JPEG should use DeviceGray")
+	}
+
+	// Every xref entry must point at the start of its object.
+	start, err := strconv.Atoi(regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(pdf)[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(pdf[start:], -1)
+	if len(entries) != 8 {
+		t.Fatalf("got %d xref entries, want 8", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(entry[1])
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !strings.HasPrefix(pdf[offset:], want) {
+			t.Fatalf("xref entry %d points at %q", i+1, pdf[offset:offset+10])
+		}
+	}
+}
+
+func TestWritePDFCoverAndImageSize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "square.jpg")
+	writeTestJPEG(t, path, image.NewRGBA(image.Rect(0, 0, 100, 100)))
+
+	var cover bytes.Buffer
+	if err := writePDF(&cover, []string{path}, pageSizeLetter, pageFitCover); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cover.String(), "q 792.00 0 0 792.00 -90.00 0.00 cm") {
+		t.Fatal("cover should fill the page height and crop the sides")
+	}
+
+	var native bytes.Buffer
+	if err := writePDF(&native, []string{path}, pageSizeImage, pageFitContain); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(native.String(), "/MediaBox [0 0 100.00 100.00]") {
+		t.Fatal("image-sized page should match the image")
+	}
+}
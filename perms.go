@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// parseFileMode parses a --chmod value: octal permission bits such as 0644.
+func parseFileMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("%q is not an octal mode between 0000 and 0777", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// parseOwner parses a --chown value of the form user, user:group or :group,
+// where each part is a name or a numeric ID. Parts that are left out are
+// returned as -1, which os.Chown leaves unchanged.
+func parseOwner(value string) (uid, gid int, err error) {
+	if runtime.GOOS == "windows" {
+		return -1, -1, fmt.Errorf("changing file ownership is not supported on Windows")
+	}
+	userName, groupName, _ := strings.Cut(value, ":")
+	if userName == "" && groupName == "" {
+		return -1, -1, fmt.Errorf("expected user, user:group or :group")
+	}
+
+	uid, gid = -1, -1
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return -1, -1, err
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return -1, -1, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// applyOwnership sets the --chmod mode and --chown owner on a written output.
+// Modes are applied explicitly, so outputs do not depend on the umask.
+func applyOwnership(path string, opts options) error {
+	if opts.chmodValue != "" {
+		if err := os.Chmod(path, opts.fileMode); err != nil {
+			return err
+		}
+	}
+	if opts.chownValue != "" {
+		if err := os.Chown(path, opts.uid, opts.gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
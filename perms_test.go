@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestParseFileMode(t *testing.T) {
+	if mode, err := parseFileMode("0644"); err != nil || mode != 0644 {
+		t.Errorf("got %v, %v; want 0644", mode, err)
+	}
+	if mode, err := parseFileMode("755"); err != nil || mode != 0755 {
+		t.Errorf("got %v, %v; want 0755", mode, err)
+	}
+	for _, value := range []string{"", "0888", "rw-r--r--", "01777"} {
+		if _, err := parseFileMode(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ownership is not supported on Windows")
+	}
+	tests := []struct {
+		value    string
+		uid, gid int
+	}{
+		{"1000:100", 1000, 100},
+		{"1000", 1000, -1},
+		{":100", -1, 100},
+	}
+	for _, tt := range tests {
+		uid, gid, err := parseOwner(tt.value)
+		if err != nil || uid != tt.uid || gid != tt.gid {
+			t.Errorf("%q: got %d, %d, %v; want %d, %d", tt.value, uid, gid, err, tt.uid, tt.gid)
+		}
+	}
+	if _, _, err := parseOwner(":"); err == nil {
+		t.Error("expected an error for an empty owner")
+	}
+	if _, _, err := parseOwner("no-such-user-heictojpeg"); err == nil {
+		t.Error("expected an error for an unknown user")
+	}
+}
+
+func TestApplyOwnership(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions")
+	}
+	path := filepath.Join(t.TempDir(), "out.jpg")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.chmodValue, opts.fileMode = "0644", 0644
+	// Changing to our own IDs is always permitted.
+	opts.chownValue = strconv.Itoa(os.Getuid()) + ":" + strconv.Itoa(os.Getgid())
+	opts.uid, opts.gid = os.Getuid(), os.Getgid()
+	if err := applyOwnership(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("got mode %v, want 0644", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+// phashSize is the side of the luma grid a perceptual hash is computed from.
+// The hash is made of the 8x8 lowest frequencies of the grid's DCT.
+const phashSize = 32
+
+// perceptualHash computes the DCT-based perceptual hash (pHash) of img, for
+// --compute-phash: the image is averaged down to a 32x32 luma grid, and each
+// of the 64 lowest DCT frequencies sets a bit when it is above their median.
+// Copies of a photo that were scaled, recompressed or slightly retouched get
+// hashes a few bits apart.
+func perceptualHash(img image.Image) uint64 {
+	grid := lumaGrid(img)
+
+	// 2-D DCT-II of the 8 lowest frequencies: rows, then columns.
+	var cosines [8][phashSize]float64
+	for u := range cosines {
+		for x := range cosines[u] {
+			cosines[u][x] = math.Cos(float64((2*x+1)*u) * math.Pi / (2 * phashSize))
+		}
+	}
+	var rows [phashSize][8]float64
+	for y := range grid {
+		for u := 0; u < 8; u++ {
+			for x, l := range grid[y] {
+				rows[y][u] += l * cosines[u][x]
+			}
+		}
+	}
+	var coefficients [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			for y := range rows {
+				coefficients[v*8+u] += rows[y][u] * cosines[v][y]
+			}
+		}
+	}
+
+	// The DC term is the mean brightness and left out of the median.
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	var hash uint64
+	for i, c := range coefficients {
+		if c > median {
+			hash |= 1 << (63 - i)
+		}
+	}
+	return hash
+}
+
+// formatPHash writes a perceptual hash as 16 hex digits.
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// lumaGrid averages the luma of img over a phashSize x phashSize grid. The Y
+// plane of YCbCr images is used as it is.
+func lumaGrid(img image.Image) *[phashSize][phashSize]float64 {
+	b := img.Bounds()
+	if b.Dx() < phashSize || b.Dy() < phashSize {
+		// Every cell needs at least one pixel.
+		img = scaleImage(img, phashSize, phashSize)
+		b = img.Bounds()
+	}
+	var luma func(x, y int) float64
+	switch m := img.(type) {
+	case *image.YCbCr:
+		luma = func(x, y int) float64 { return float64(m.Y[m.YOffset(x, y)]) }
+	case *image.Gray:
+		luma = func(x, y int) float64 { return float64(m.Pix[m.PixOffset(x, y)]) }
+	default:
+		luma = func(x, y int) float64 {
+			r, g, b, _ := img.At(x, y).RGBA()
+			return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+		}
+	}
+
+	var grid [phashSize][phashSize]float64
+	var counts [phashSize][phashSize]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * phashSize / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * phashSize / b.Dx()
+			grid[cy][cx] += luma(x, y)
+			counts[cy][cx]++
+		}
+	}
+	for y := range grid {
+		for x := range grid[y] {
+			grid[y][x] /= float64(counts[y][x])
+		}
+	}
+	return &grid
+}
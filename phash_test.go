@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// testScene is a smooth image with some structure, drawn at any size.
+func testScene(width, height int, invert bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			l := uint8(255 * fx * fy)
+			if fx > 0.3 && fx < 0.5 && fy > 0.6 {
+				l = 40
+			}
+			if invert {
+				l = 255 - l
+			}
+			img.SetRGBA(x, y, color.RGBA{l, l / 2, 255 - l, 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := perceptualHash(testScene(400, 300, false))
+	if original == 0 || original == ^uint64(0) {
+		t.Fatalf("degenerate hash %016x", original)
+	}
+	if again := perceptualHash(testScene(400, 300, false)); again != original {
+		t.Errorf("hash not deterministic: %016x, %016x", original, again)
+	}
+	if scaled := perceptualHash(testScene(120, 90, false)); bits.OnesCount64(scaled^original) > 6 {
+		t.Errorf("scaled copy differs by %d bits", bits.OnesCount64(scaled^original))
+	}
+	// Images smaller than the grid are enlarged first.
+	if tiny := perceptualHash(testScene(20, 15, false)); bits.OnesCount64(tiny^original) > 20 {
+		t.Errorf("tiny copy differs by %d bits", bits.OnesCount64(tiny^original))
+	}
+	if inverted := perceptualHash(testScene(400, 300, true)); bits.OnesCount64(inverted^original) < 24 {
+		t.Errorf("different image differs by only %d bits", bits.OnesCount64(inverted^original))
+	}
+}
+
+func TestPerceptualHashYCbCrMatchesRGBA(t *testing.T) {
+	rgba := testScene(64, 48, false)
+	ycc := image.NewYCbCr(rgba.Bounds(), image.YCbCrSubsampleRatio444)
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			c := rgba.RGBAAt(x, y)
+			ycc.Y[ycc.YOffset(x, y)], ycc.Cb[ycc.COffset(x, y)], ycc.Cr[ycc.COffset(x, y)] = color.RGBToYCbCr(c.R, c.G, c.B)
+		}
+	}
+	if d := bits.OnesCount64(perceptualHash(ycc) ^ perceptualHash(rgba)); d > 2 {
+		t.Errorf("YCbCr and RGBA hashes differ by %d bits", d)
+	}
+}
+
+func TestConvertWithPHash(t *testing.T) {
+	jpegDir := t.TempDir()
+	opts := defaultOptions()
+	opts.computePHash = true
+	opts.sidecar = true
+	path, _ := filepath.Abs("testdata/images/goheif-camel.heic")
+	ev := convertPath(path, jpegDir, opts)
+	if ev.Err != nil {
+		t.Fatal(ev.Err)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{16}$`).MatchString(ev.PHash) {
+		t.Fatalf("unexpected hash %q", ev.PHash)
+	}
+	data, err := os.ReadFile(sidecarPath(filepath.Join(jpegDir, ev.Output)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record sidecar
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.PHash != ev.PHash {
+		t.Errorf("sidecar hash %q, want %q", record.PHash, ev.PHash)
+	}
+}
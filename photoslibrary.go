@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const photosLibraryExt = ".photoslibrary"
+
+// sourceLibrary is an input whose files are stored in a tree under
+// generated names, with their real names and albums recorded alongside: a
+// Photos library, or a shared album export.
+type sourceLibrary interface {
+	// originalsDir is the root of the tree of source files.
+	originalsDir() string
+	// defaultOutputDir is where outputs go without --output-dir.
+	defaultOutputDir() string
+	// entries lists the source files, named by their path relative to
+	// originalsDir and sorted by it, and scan streams them unsorted.
+	entries(exclude string, found func(n int64)) ([]os.DirEntry, error)
+	scan(exclude string, found func(n int64)) *entryStream
+	// outputName is the output path of a source, or "" to keep its name.
+	outputName(master string) string
+	isFavorite(master string) bool
+}
+
+// photosLibrary is an Apple Photos library package. Masters live under
+// originals/ (Photos 5 and later) or Masters/ (older versions), stored by
+// UUID; their original names and albums come from database/Photos.sqlite,
+// which is read through the sqlite3 command-line tool that ships with macOS.
+type photosLibrary struct {
+	path string
+
+	once      sync.Once
+	names     map[string]string // master path relative to originalsDir -> output name
+	favorites map[string]bool   // master paths of assets marked as favourites
+	err       error
+}
+
+func isPhotosLibrary(inputPath string) bool {
+	return strings.EqualFold(filepath.Ext(filepath.Clean(inputPath)), photosLibraryExt)
+}
+
+// originalsDir returns the directory holding the library's master files.
+func (l *photosLibrary) originalsDir() string {
+	for _, dir := range []string{"originals", "Masters"} {
+		candidate := filepath.Join(l.path, dir)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate
+		}
+	}
+	return filepath.Join(l.path, "originals")
+}
+
+// defaultOutputDir keeps outputs outside the library package so Photos never
+// sees foreign files inside it.
+func (l *photosLibrary) defaultOutputDir() string {
+	return filepath.Join(filepath.Dir(filepath.Clean(l.path)), "jpegs")
+}
+
+// entries lists every master file, named by its path relative to originalsDir
+// and sorted by it, leaving out the output directory exclude. The subfolders
+// are scanned concurrently; found is called with the running count of files
+// found.
+func (l *photosLibrary) entries(exclude string, found func(n int64)) ([]os.DirEntry, error) {
+	return collectEntries(l.scan(exclude, found))
+}
+
+// scan streams every master file as it is found, in no particular order.
+func (l *photosLibrary) scan(exclude string, found func(n int64)) *entryStream {
+	return scanTree(l.originalsDir(), scanWorkers, exclude, found)
+}
+
+// outputName returns the album/original-filename based output path for a
+// master, or "" when the database has nothing for it.
+func (l *photosLibrary) outputName(master string) string {
+	l.load()
+	return l.names[filepath.ToSlash(master)]
+}
+
+// isFavorite reports whether a master is a favourite in Photos.
+func (l *photosLibrary) isFavorite(master string) bool {
+	l.load()
+	return l.favorites[filepath.ToSlash(master)]
+}
+
+// load reads the database once.
+func (l *photosLibrary) load() {
+	l.once.Do(func() {
+		var rows []libraryAsset
+		rows, l.err = queryLibraryAssets(filepath.Join(l.path, "database", "Photos.sqlite"))
+		if l.err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: cannot read Photos database, keeping UUID file names: %v\n", l.err)
+		}
+		l.names = libraryOutputNames(rows)
+		l.favorites = map[string]bool{}
+		for _, a := range rows {
+			if a.favorite {
+				l.favorites[path.Join(a.directory, a.filename)] = true
+			}
+		}
+	})
+}
+
+// libraryAsset is one master as recorded in the Photos database.
+type libraryAsset struct {
+	directory        string // ZASSET.ZDIRECTORY, e.g. "A"
+	filename         string // ZASSET.ZFILENAME, e.g. "<UUID>.heic"
+	originalFilename string // ZADDITIONALASSETATTRIBUTES.ZORIGINALFILENAME
+	album            string // title of the first album holding the asset
+	favorite         bool   // ZASSET.ZFAVORITE
+}
+
+// libraryOutputNames maps master paths to "Album/Original.jpg" (or just
+// "Original.jpg" for assets in no album). Duplicate names are disambiguated
+// with the start of the asset UUID.
+func libraryOutputNames(assets []libraryAsset) map[string]string {
+	sort.Slice(assets, func(i, j int) bool {
+		return path.Join(assets[i].directory, assets[i].filename) < path.Join(assets[j].directory, assets[j].filename)
+	})
+
+	names := make(map[string]string, len(assets))
+	used := make(map[string]bool, len(assets))
+	for _, a := range assets {
+		if a.originalFilename == "" {
+			continue
+		}
+		base := strings.TrimSuffix(a.originalFilename, filepath.Ext(a.originalFilename))
+		dir := sanitizePathComponent(a.album)
+
+		name := path.Join(dir, base+".jpg")
+		if used[strings.ToLower(name)] {
+			uuid := strings.TrimSuffix(a.filename, filepath.Ext(a.filename))
+			if len(uuid) > 8 {
+				uuid = uuid[:8]
+			}
+			name = path.Join(dir, base+"_"+uuid+".jpg")
+		}
+		used[strings.ToLower(name)] = true
+		names[path.Join(a.directory, a.filename)] = name
+	}
+	return names
+}
+
+// sanitizePathComponent makes an album title usable as a folder name.
+func sanitizePathComponent(s string) string {
+	s = strings.TrimSpace(strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(s))
+	if s == "." || s == ".." {
+		return ""
+	}
+	return s
+}
+
+// queryLibraryAssets reads master file names, original names and album
+// titles from a Photos database.
+func queryLibraryAssets(database string) ([]libraryAsset, error) {
+	if _, err := os.Stat(database); err != nil {
+		return nil, err
+	}
+
+	rows, err := sqliteQuery(database, `SELECT a.Z_PK, a.ZDIRECTORY, a.ZFILENAME, attr.ZORIGINALFILENAME, a.ZFAVORITE
+FROM ZASSET a JOIN ZADDITIONALASSETATTRIBUTES attr ON attr.ZASSET = a.Z_PK`)
+	if err != nil {
+		return nil, err
+	}
+
+	albums := libraryAlbums(database)
+	assets := make([]libraryAsset, 0, len(rows))
+	for _, row := range rows {
+		if len(row) != 5 {
+			continue
+		}
+		assets = append(assets, libraryAsset{
+			directory:        row[1],
+			filename:         row[2],
+			originalFilename: row[3],
+			album:            albums[row[0]],
+			favorite:         row[4] == "1",
+		})
+	}
+	return assets, nil
+}
+
+// libraryAlbums maps asset primary keys to an album title. The asset/album
+// join table is named after Core Data entity numbers (Z_26ASSETS,
+// Z_28ASSETS, ...) that change between Photos versions, so it is discovered
+// from the schema. Failures simply yield no album folders.
+func libraryAlbums(database string) map[string]string {
+	albums := map[string]string{}
+
+	tables, err := sqliteQuery(database, `SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'Z\_%ASSETS' ESCAPE '\'`)
+	if err != nil {
+		return albums
+	}
+	for _, table := range tables {
+		columns, err := sqliteQuery(database, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table[0]))
+		if err != nil {
+			continue
+		}
+		var albumColumn, assetColumn string
+		for _, column := range columns {
+			switch {
+			case strings.HasSuffix(column[0], "ALBUMS"):
+				albumColumn = column[0]
+			case strings.HasSuffix(column[0], "ASSETS"):
+				assetColumn = column[0]
+			}
+		}
+		if albumColumn == "" || assetColumn == "" {
+			continue
+		}
+
+		rows, err := sqliteQuery(database, fmt.Sprintf(`SELECT j.%s, al.ZTITLE FROM %s j
+JOIN ZGENERICALBUM al ON al.Z_PK = j.%s
+WHERE al.ZTITLE IS NOT NULL AND al.ZTITLE != '' ORDER BY al.Z_PK`, assetColumn, table[0], albumColumn))
+		if err != nil {
+			continue
+		}
+		for _, row := range rows {
+			if _, seen := albums[row[0]]; !seen && len(row) == 2 {
+				albums[row[0]] = row[1]
+			}
+		}
+	}
+	return albums
+}
+
+// sqliteQuery runs a read-only query with the sqlite3 CLI and splits the
+// output into tab-separated rows.
+func sqliteQuery(database, query string) ([][]string, error) {
+	cmd := exec.Command("sqlite3", "-readonly", "-separator", "\t", database, query)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sqlite3: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimRight(stdout.String(), "\n"), "\n") {
+		if line != "" {
+			rows = append(rows, strings.Split(line, "\t"))
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPhotosLibraryEntries(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Family.photoslibrary")
+	master := filepath.Join(root, "originals", "A", "A1B2C3D4-0000-0000-0000-000000000000.heic")
+	if err := os.MkdirAll(filepath.Dir(master), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(master, []byte("heic"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{root}, io.Discard)
+	if err != nil {
+		t.Fatalf("parseOptions failed: %v", err)
+	}
+	if opts.library == nil {
+		t.Fatal("expected a .photoslibrary input to enable library mode")
+	}
+
+	dir, files, err := resolveInput(opts)
+	if err != nil {
+		t.Fatalf("resolveInput failed: %v", err)
+	}
+	if dir != filepath.Join(root, "originals") {
+		t.Fatalf("unexpected originals dir %s", dir)
+	}
+	if len(files) != 1 || files[0].Name() != filepath.Join("A", "A1B2C3D4-0000-0000-0000-000000000000.heic") {
+		t.Fatalf("unexpected entries %v", files)
+	}
+	if got := opts.library.defaultOutputDir(); got != filepath.Join(filepath.Dir(root), "jpegs") {
+		t.Fatalf("outputs must stay outside the library, got %s", got)
+	}
+}
+
+func TestLibraryOutputNames(t *testing.T) {
+	names := libraryOutputNames([]libraryAsset{
+		{directory: "A", filename: "AAAA1111-X.heic", originalFilename: "IMG_0001.HEIC", album: "Summer: Italy"},
+		{directory: "B", filename: "BBBB2222-Y.heic", originalFilename: "IMG_0001.HEIC", album: "Summer: Italy"},
+		{directory: "C", filename: "CCCC3333-Z.heic", originalFilename: "IMG_0002.HEIC"},
+		{directory: "D", filename: "DDDD4444-W.heic"},
+	})
+
+	want := map[string]string{
+		"A/AAAA1111-X.heic": "Summer- Italy/IMG_0001.jpg",
+		"B/BBBB2222-Y.heic": "Summer- Italy/IMG_0001_BBBB2222.jpg",
+		"C/CCCC3333-Z.heic": "IMG_0002.jpg",
+	}
+	if len(names) != len(want) {
+		t.Fatalf("unexpected names %v", names)
+	}
+	for master, name := range want {
+		if names[master] != name {
+			t.Errorf("%s: got %q, want %q", master, names[master], name)
+		}
+	}
+}
+
+func TestPhotosLibraryStreamed(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "Family.photoslibrary")
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"0", "F"} {
+		if err := os.MkdirAll(filepath.Join(root, "originals", dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, "originals", dir, dir+"AAA.heic"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts, err := parseOptions([]string{"--order", "directory", "--non-interactive", root}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !streamsInput(opts) {
+		t.Fatal("library is not streamed")
+	}
+	jpegDir, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without a database the masters keep their UUID names.
+	for _, name := range []string{"0AAA.jpg", "FAAA.jpg"} {
+		if _, err := os.Stat(filepath.Join(jpegDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// conversion carries one source file through the stages of a conversion:
+// check and read (disk), decode and encode (CPU), then write (disk). Each
+// stage does nothing once err is set.
+type conversion struct {
+	currentDir, name, jpegDir string
+	opts                      options
+	sourcePath                string
+	video                     bool // a --copy-videos video, copied in write
+
+	source     *sourceFile // whole source file, kept for --sidecar and copies
+	exif       []byte
+	img        image.Image
+	jpeg       []byte          // JPEG-coded source image written out as it is
+	outputName string          // output relative to jpegDir
+	outputPath string          // where the JPEG goes
+	encoded    *bytes.Buffer   // JPEG waiting to be written, if any
+	bounds     image.Rectangle // size encoded must decode to, empty for any
+	written    string          // path actually written, e.g. the first tile
+	phash      string          // --compute-phash
+	renamed    string          // template name given up, see nameClaims
+	warnings   []string        // from decodeWarnings
+
+	err         error
+	quarantined string
+	busy        time.Duration // time spent in the stages, for --stats
+}
+
+func newConversion(currentDir, name, jpegDir string, opts options) *conversion {
+	return &conversion{
+		currentDir: currentDir,
+		name:       name,
+		jpegDir:    jpegDir,
+		opts:       opts,
+		sourcePath: filepath.Join(currentDir, name),
+		video:      !isHEICFile(name) && isVideoFile(name),
+	}
+}
+
+func (c *conversion) track(start time.Time) {
+	c.busy += time.Since(start)
+}
+
+// failed tags an error the stage ran into with the stage, for errorCode.
+func (c *conversion) failed(stage string) {
+	if c.err != nil {
+		c.err = &stageError{stage: stage, err: c.err}
+	}
+}
+
+// check downloads sources offloaded to iCloud and rejects empty and
+// truncated ones before anything is read, quarantining them with
+// --quarantine-dir.
+func (c *conversion) check() {
+	defer c.failed("check")
+	if !c.opts.tui.active() {
+		fmt.Printf("Processing file: %s\n", c.name)
+	}
+	if c.video {
+		_, c.err = os.Stat(c.sourcePath)
+		return
+	}
+	if c.err = downloadSource(c.sourcePath, c.opts); c.err != nil {
+		return
+	}
+	if c.err = checkSource(c.sourcePath); c.err == nil {
+		return
+	}
+	// Library masters are never moved out of the Photos package.
+	if c.opts.quarantineDir != "" && c.opts.library == nil && sourceProblem(c.err) != "" {
+		if moved, err := quarantine(c.sourcePath, c.opts.quarantineDir); err != nil {
+			log.Printf("Failed to quarantine %s: %v", c.name, err)
+		} else {
+			c.quarantined = moved
+		}
+	}
+}
+
+// read loads the whole source into a pooled buffer, or maps it with --mmap.
+// Videos are copied from disk as they are.
+func (c *conversion) read() {
+	if c.err != nil || c.video {
+		return
+	}
+	defer c.failed("read")
+	defer c.track(time.Now())
+	c.source, c.err = openSource(c.sourcePath, c.opts.mmap)
+	if c.err == nil {
+		c.opts.tui.read(int64(c.source.Len()))
+	}
+}
+
+// decode reads the metadata, picks the output name and decodes the image,
+// applying everything that happens before encoding.
+func (c *conversion) decode() {
+	if c.err != nil || c.video {
+		return
+	}
+	defer c.failed("decode")
+	defer c.track(time.Now())
+	fileInput := bytes.NewReader(c.source.Bytes())
+	opts := c.opts
+
+	exif, err := extractExif(fileInput)
+	if err != nil {
+		c.err = err
+		return
+	}
+	if exif, c.err = editExif(exif, opts); c.err != nil {
+		return
+	}
+	if len(opts.rules) > 0 {
+		width, height, _ := imageDimensions(fileInput)
+		opts = applyRules(opts, exif, width, height)
+	}
+
+	if c.outputName, c.err = outputName(c.currentDir, c.name, exif, opts); c.err != nil {
+		return
+	}
+	if opts.nameClaims != nil {
+		c.outputName, c.renamed = opts.nameClaims.claim(c.jpegDir, c.sourcePath, c.outputName, exif)
+	}
+	c.outputPath = filepath.Join(c.jpegDir, c.outputName)
+	if c.err = os.MkdirAll(filepath.Dir(c.outputPath), 0755); c.err != nil {
+		return
+	}
+	// The full EXIF block is used for naming; outputs get the filtered copy.
+	if exif, c.err = filterExif(exif, opts.metadata); c.err != nil {
+		return
+	}
+	opts.xmpPacket = filterXMP(extractXMP(fileInput), opts.metadata)
+	opts.sourcePath, opts.sourceSize = c.sourcePath, fileInput.Size()
+	c.warnings = decodeWarnings(fileInput, opts)
+	if data, ok := primaryJPEG(fileInput); ok && canPassThrough(data, opts) && !hasAlphaToApply(fileInput, opts) {
+		c.jpeg = data
+		c.exif, c.opts = preparePassthrough(fileInput, data, exif, opts)
+		if c.checkMetadata(fileInput, image.Rectangle{}); c.err != nil {
+			return
+		}
+		if opts.computePHash {
+			if img, err := jpeg.Decode(bytes.NewReader(data)); err == nil {
+				c.phash = formatPHash(perceptualHash(img))
+			}
+		}
+		return
+	}
+	c.img, c.exif, c.opts, c.err = decodeImage(fileInput, exif, opts)
+	if c.err == nil {
+		c.checkMetadata(fileInput, c.img.Bounds())
+	}
+	if c.err == nil && opts.computePHash {
+		c.phash = formatPHash(perceptualHash(c.img))
+	}
+}
+
+// checkMetadata fails the conversion under --strict-metadata when its
+// output, of bounds, would lose metadata, before anything is encoded.
+func (c *conversion) checkMetadata(ra io.ReaderAt, bounds image.Rectangle) {
+	if !c.opts.strictMetadata {
+		return
+	}
+	if losses := metadataLosses(ra, c.exif, bounds, c.opts); len(losses) > 0 {
+		c.err = metadataLossError(losses)
+		c.img, c.jpeg = nil, nil
+	}
+}
+
+// encode encodes a plain JPEG output into a buffer for write. Raw formats,
+// tiles and PNG fallbacks are written here directly, as they are encoded
+// piece by piece.
+func (c *conversion) encode() {
+	if c.err != nil || c.video {
+		return
+	}
+	defer c.failed("encode")
+	defer c.track(time.Now())
+	if c.jpeg != nil {
+		c.encoded = getBuffer(c.source.Len())
+		if c.err = writePassthroughJPEG(c.encoded, c.jpeg, c.exif, c.opts); c.err != nil {
+			putBuffer(c.encoded)
+			c.encoded = nil
+			return
+		}
+		c.written = c.outputPath
+		return
+	}
+	img := c.img
+	c.img = nil
+	sizeHint := c.source.Len()
+	if outputKind(img.Bounds(), c.opts) != outputJPEG {
+		c.written, c.err = writeOutput(img, c.exif, c.outputPath, sizeHint, c.opts)
+		return
+	}
+
+	if c.opts.lowMemory && !c.opts.onlyIfSmaller {
+		// --only-if-smaller needs the whole JPEG to compare and shrink.
+		if c.err = streamJPEG(img, c.exif, c.outputPath, outputCheck(img.Bounds(), c.opts), c.opts); c.err == nil {
+			c.written = c.outputPath
+		}
+		return
+	}
+	c.encoded = getBuffer(sizeHint)
+	c.err = encodeJPEG(c.encoded, img, c.exif, c.outputPath, c.opts)
+	if c.err == nil {
+		c.bounds = img.Bounds()
+		c.written = c.outputPath
+		return
+	}
+	putBuffer(c.encoded)
+	c.encoded = nil
+	if errors.Is(c.err, errNotSmaller) && c.opts.ifLarger == largerCopy {
+		c.written, c.err = copySource(c.source.Bytes(), c.name, c.outputPath, c.opts)
+	}
+}
+
+// write writes out the encoded JPEG, or copies a video, then files it into
+// a batch folder and writes its sidecar.
+func (c *conversion) write() {
+	if c.err != nil {
+		return
+	}
+	defer c.failed("write")
+	defer c.track(time.Now())
+	if c.video {
+		if c.err = c.copyVideo(); c.err != nil {
+			return
+		}
+	} else if c.encoded != nil {
+		c.err = writeEncoded(c.outputPath, c.encoded, outputCheck(c.bounds, c.opts), c.opts)
+		putBuffer(c.encoded)
+		c.encoded = nil
+		if c.err != nil {
+			return
+		}
+	}
+	if c.written != c.outputPath {
+		if c.outputName, c.err = filepath.Rel(c.jpegDir, c.written); c.err != nil {
+			c.outputName = ""
+			return
+		}
+	}
+
+	if c.opts.splitter != nil {
+		if c.outputName, c.err = c.opts.splitter.moveIntoBatch(c.jpegDir, c.outputName); c.err != nil {
+			return
+		}
+	}
+	if c.opts.sidecar && !c.video {
+		c.err = writeSidecar(c.jpegDir, c.outputName, c.source.Bytes(), c.sourcePath, c.phash, c.opts)
+	}
+}
+
+// release returns the conversion's buffers to their pool.
+func (c *conversion) release() {
+	if c.source != nil {
+		c.source.release()
+		c.source = nil
+	}
+	if c.encoded != nil {
+		putBuffer(c.encoded)
+		c.encoded = nil
+	}
+	c.img = nil
+	c.jpeg = nil
+}
+
+// finish releases the buffers, uploads the outputs to a remote destination
+// or adds them to the --output-tar archive, and returns the result to log.
+func (c *conversion) finish() *fileResult {
+	extracted := c.jpeg != nil
+	c.release()
+	// Sources whose metadata --strict-metadata would not let go are moved
+	// once they are no longer open, mapped by --mmap in particular.
+	if errors.Is(c.err, errMetadataLoss) && c.opts.quarantineDir != "" && c.opts.library == nil {
+		if moved, err := quarantine(c.sourcePath, c.opts.quarantineDir); err != nil {
+			log.Printf("Failed to quarantine %s: %v", c.name, err)
+		} else {
+			c.quarantined = moved
+		}
+	}
+	result := &fileResult{name: c.name, output: c.outputName, err: c.err, quarantined: c.quarantined, extracted: extracted, phash: c.phash, warnings: c.warnings, alpha: c.opts.alphaAction, renamedFrom: c.renamed}
+	// Sources rejected by check were never read, so have nothing to time.
+	if c.opts.stats && c.busy > 0 {
+		result.duration = c.busy
+		result.camera = cameraModel(c.sourcePath)
+	}
+	if result.err == nil && c.opts.remote != nil {
+		uploads := []string{result.output}
+		if c.opts.sidecar {
+			uploads = append(uploads, sidecarPath(result.output))
+		}
+		for i, name := range uploads {
+			if c.opts.remoteSync != nil {
+				var uploaded bool
+				uploaded, result.err = c.opts.remoteSync.upload(filepath.Join(c.jpegDir, name), name)
+				if i == 0 {
+					result.unchanged = !uploaded
+				}
+			} else {
+				result.err = c.opts.remote.upload(filepath.Join(c.jpegDir, name), name)
+			}
+			if result.err != nil {
+				result.err = &stageError{stage: "upload", err: result.err}
+				break
+			}
+		}
+	}
+	if result.err == nil && c.opts.tar != nil && result.output != "" {
+		names := []string{result.output}
+		if c.opts.sidecar {
+			names = append(names, sidecarPath(result.output))
+		}
+		for _, name := range names {
+			if result.err = c.opts.tar.add(c.jpegDir, name); result.err != nil {
+				result.err = &stageError{stage: "write", err: result.err}
+				break
+			}
+		}
+	}
+	if result.err == nil && c.opts.deleteSource {
+		result.sourceRemoval = removeSource(c.sourcePath, c.opts)
+	}
+	return result
+}
+
+// runPipeline converts the HEIC files among the entries, in the order they
+// arrive, and sends their results to results, which it closes at the end.
+// Reading, decoding, encoding and writing run as separate stages connected
+// by bounded channels, so disk and CPU work on different files overlap:
+// while one file is decoded the next is already being read and the previous
+// one written. Entries are taken as the first stage has room for them, so a
+// streamed directory never has more than a few files in flight. With a
+// pauser, no new file is read while paused; files already read are finished.
+// Each stage runs as many workers as opts.stages gives it.
+func runPipeline(entries <-chan os.DirEntry, currentDir, jpegDir string, opts options, results chan<- *fileResult) {
+	sources := make(chan *conversion)
+	go func() {
+		defer close(sources)
+		n := 0
+		for file := range entries {
+			if !isSourceFile(file.Name(), opts) {
+				opts.skipped.addIgnored(file)
+				continue
+			}
+			if skipsOffloaded(filepath.Join(currentDir, file.Name()), opts) {
+				opts.skipped.add(file.Name(), skipNotDownloaded, errNotDownloaded.Error())
+				continue
+			}
+			n++
+			c := newConversion(currentDir, file.Name(), jpegDir, opts)
+			if opts.fileIndex == nil {
+				// Streamed files are numbered as they arrive.
+				c.opts.fileIndex = map[string]int{file.Name(): n}
+			}
+			sources <- c
+		}
+	}()
+
+	if opts.lowMemory {
+		// One file at a time from start to finish, so no more than one
+		// image is ever in memory, which is returned to the system
+		// before the next one is decoded.
+		for c := range sources {
+			if opts.pauser != nil {
+				opts.pauser.wait()
+			}
+			row := opts.tui.begin("convert", c.name)
+			opts.tui.next(row, "read")
+			c.check()
+			c.read()
+			opts.tui.next(row, "decode")
+			c.decode()
+			opts.tui.next(row, "encode")
+			c.encode()
+			opts.tui.next(row, "write")
+			c.write()
+			opts.tui.next(row, "finish")
+			result := c.finish()
+			opts.tui.end(row)
+			results <- result
+			debug.FreeOSMemory()
+		}
+		close(results)
+		return
+	}
+
+	// The first source is converted an extra time to calibrate the stages
+	// on, when they are left to choose.
+	first, ok := <-sources
+	sample := ""
+	if ok && !first.video {
+		sample = first.sourcePath
+	}
+	ioWorkers, decodeWorkers, encodeWorkers := opts.stages.counts(sample, len(opts.fileIndex), opts)
+	queued := make(chan *conversion)
+	go func() {
+		defer close(queued)
+		if !ok {
+			return
+		}
+		queued <- first
+		for c := range sources {
+			queued <- c
+		}
+	}()
+
+	read := make(chan *conversion, decodeWorkers)
+	decoded := make(chan *conversion, encodeWorkers)
+	encoded := make(chan *conversion, ioWorkers)
+	runStage(ioWorkers, queued, read, func(c *conversion) {
+		if opts.pauser != nil {
+			opts.pauser.wait()
+		}
+		row := opts.tui.begin("read", c.name)
+		c.check()
+		c.read()
+		opts.tui.end(row)
+	})
+	runStage(decodeWorkers, read, decoded, tracked(opts.tui, "decode", (*conversion).decode))
+	runStage(encodeWorkers, decoded, encoded, tracked(opts.tui, "encode", (*conversion).encode))
+
+	var writers sync.WaitGroup
+	for i := 0; i < ioWorkers; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for c := range encoded {
+				row := opts.tui.begin("write", c.name)
+				c.write()
+				opts.tui.next(row, "finish")
+				result := c.finish()
+				opts.tui.end(row)
+				results <- result
+			}
+		}()
+	}
+	writers.Wait()
+	close(results)
+}
+
+// sendEntries passes listed files to runPipeline.
+func sendEntries(files []os.DirEntry) <-chan os.DirEntry {
+	entries := make(chan os.DirEntry)
+	go func() {
+		defer close(entries)
+		for _, file := range files {
+			entries <- file
+		}
+	}()
+	return entries
+}
+
+// tracked shows the workers running step on the --tui display, if any.
+func tracked(t *tuiDisplay, stage string, step func(*conversion)) func(*conversion) {
+	if t == nil {
+		return step
+	}
+	return func(c *conversion) {
+		row := t.begin(stage, c.name)
+		step(c)
+		t.end(row)
+	}
+}
+
+// runStage runs step on every conversion from in with the given number of
+// goroutines and passes it on to out, which is closed once in is drained.
+func runStage(workers int, in <-chan *conversion, out chan<- *conversion, step func(*conversion)) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range in {
+				step(c)
+				out <- c
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestRunPipeline(t *testing.T) {
+	dir := t.TempDir()
+	camel, err := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := 0; i < 6; i++ {
+		name := fmt.Sprintf("IMG_%d.heic", i)
+		if err := os.WriteFile(filepath.Join(dir, name), camel, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, name)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "empty.heic"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("skipped"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jpegDir := filepath.Join(dir, "jpegs")
+	if err := os.Mkdir(jpegDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	opts.stats = true
+	results := make(chan *fileResult)
+	go runPipeline(sendEntries(files), dir, jpegDir, opts, results)
+
+	var converted []string
+	for result := range results {
+		if result.name == "empty.heic" {
+			if !errors.Is(result.err, errEmptySource) || result.duration != 0 {
+				t.Errorf("empty.heic: err %v, duration %v", result.err, result.duration)
+			}
+			continue
+		}
+		if result.err != nil {
+			t.Fatalf("%s: %v", result.name, result.err)
+		}
+		if info, err := os.Stat(filepath.Join(jpegDir, result.output)); err != nil || info.Size() == 0 {
+			t.Errorf("%s: output %s missing", result.name, result.output)
+		}
+		if result.duration <= 0 {
+			t.Errorf("%s: no duration for --stats", result.name)
+		}
+		converted = append(converted, result.name)
+	}
+	sort.Strings(converted)
+	if fmt.Sprint(converted) != fmt.Sprint(want) {
+		t.Errorf("converted %v, want %v", converted, want)
+	}
+}
+
+func TestConversionStopsAtFirstError(t *testing.T) {
+	c := newConversion(t.TempDir(), "missing.heic", t.TempDir(), defaultOptions())
+	c.check()
+	c.read()
+	c.decode()
+	c.encode()
+	c.write()
+	result := c.finish()
+	if !errors.Is(result.err, fs.ErrNotExist) || result.output != "" {
+		t.Errorf("got %+v, want the not-found error from check", result)
+	}
+}
+
+func TestRunPipelineLowMemory(t *testing.T) {
+	dir := t.TempDir()
+	camel, err := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"a.heic", "b.heic"}
+	var files []os.DirEntry
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), camel, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, &mockDirEntry{name: name})
+	}
+
+	opts := defaultOptions()
+	buffered := filepath.Join(dir, "buffered")
+	if err := os.Mkdir(buffered, 0755); err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan *fileResult)
+	go runPipeline(sendEntries(files[:1]), dir, buffered, opts, results)
+	for range results {
+	}
+
+	opts.lowMemory = true
+	streamed := filepath.Join(dir, "streamed")
+	if err := os.Mkdir(streamed, 0755); err != nil {
+		t.Fatal(err)
+	}
+	results = make(chan *fileResult)
+	go runPipeline(sendEntries(files), dir, streamed, opts, results)
+	var order []string
+	for result := range results {
+		if result.err != nil {
+			t.Fatal(result.err)
+		}
+		order = append(order, result.name)
+	}
+	if fmt.Sprint(order) != fmt.Sprint(names) {
+		t.Errorf("converted %v, want one at a time in order", order)
+	}
+
+	// Encoding straight to disk gives the same file as encoding in memory.
+	want, _ := os.ReadFile(filepath.Join(buffered, "a.jpg"))
+	got, err := os.ReadFile(filepath.Join(streamed, "a.jpg"))
+	if err != nil || len(want) == 0 || !bytes.Equal(got, want) {
+		t.Errorf("streamed JPEG differs from the buffered one (%d and %d bytes)", len(got), len(want))
+	}
+}
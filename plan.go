@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// planVersion is the format of the plans --plan-only writes.
+const planVersion = 1
+
+// conversionPlan is what --plan-only works out without converting anything,
+// and --execute-plan carries out: the sources in processing order, the
+// output each gets, the conflicts between them and the space they need.
+// Output names may be edited before the plan is executed.
+type conversionPlan struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+
+	// Dir is the absolute directory the sources are named relative to, or
+	// "" when they carry absolute paths (--files-from, several inputs).
+	Dir string `json:"dir"`
+	// Inputs are the input paths, made absolute, and OutputDir where the
+	// outputs go, or "" for a remote destination or --output-tar.
+	Inputs    []string `json:"inputs,omitempty"`
+	OutputDir string   `json:"output_dir,omitempty"`
+	// Settings are the flags that differ from their defaults. Secret values
+	// are left out and must be given again to --execute-plan.
+	Settings map[string]string `json:"settings,omitempty"`
+
+	Files     []plannedFile  `json:"files"`
+	Conflicts []planConflict `json:"conflicts,omitempty"`
+	// Skipped are the entries of the input left out, with their reasons.
+	// They are not read back by --execute-plan.
+	Skipped []skippedFile `json:"skipped,omitempty"`
+
+	SourceBytes    int64 `json:"source_bytes"`
+	EstimatedBytes int64 `json:"estimated_bytes"`
+	// AvailableBytes is the free space where the outputs go, or -1 when it
+	// is not known.
+	AvailableBytes int64 `json:"available_bytes"`
+}
+
+// plannedFile is one source of a plan and its output, relative to the
+// output directory. Error is set when the source's headers could not be
+// read; it is still converted, and fails, when the plan is executed.
+type plannedFile struct {
+	Source        string    `json:"source"`
+	Size          int64     `json:"size"`
+	ModTime       time.Time `json:"mod_time"`
+	Width         int       `json:"width,omitempty"`
+	Height        int       `json:"height,omitempty"`
+	Output        string    `json:"output,omitempty"`
+	EstimatedSize int64     `json:"estimated_size"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Kinds of planConflict.
+const (
+	conflictCollision = "collision" // another source gets the same output
+	conflictExists    = "exists"    // the output is already there
+)
+
+// planConflict is an output that would collide with another source's or
+// with an existing file.
+type planConflict struct {
+	Kind   string `json:"kind"`
+	Source string `json:"source"`
+	Output string `json:"output"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// planFlags are never recorded in a plan's settings: the plan flags
+// themselves and --dry-run, which they cannot be combined with.
+var planFlags = map[string]bool{"plan-only": true, "execute-plan": true, "dry-run": true}
+
+// makePlan plans the conversion of files, as listed in currentDir, reading
+// source headers only.
+func makePlan(currentDir string, files []os.DirEntry, opts options, now time.Time) (*conversionPlan, error) {
+	plan := &conversionPlan{Version: planVersion, Created: now, Settings: planSettings(opts), AvailableBytes: -1}
+	var err error
+	if currentDir != "" {
+		if plan.Dir, err = filepath.Abs(currentDir); err != nil {
+			return nil, err
+		}
+	}
+	inputs := opts.inputPaths
+	if len(inputs) == 0 && opts.filesFrom == "" {
+		inputs = []string{opts.inputPath}
+	}
+	for _, input := range inputs {
+		abs, err := filepath.Abs(input)
+		if err != nil {
+			return nil, err
+		}
+		plan.Inputs = append(plan.Inputs, abs)
+	}
+	if dir := plannedOutputDir(currentDir, opts); dir != "" && !isSFTPURL(dir) {
+		if plan.OutputDir, err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.skipped == nil {
+		opts.skipped = &skipLog{}
+	}
+	sorted := sortFiles(selectBursts(currentDir, filterSources(currentDir, files, opts), opts), opts.order)
+	opts.fileIndex = indexFiles(sorted)
+	claims := newNameClaims()
+	owners := map[string]string{} // lower-case output -> source
+	for _, file := range sorted {
+		if !isSourceFile(file.Name(), opts) {
+			opts.skipped.addIgnored(file)
+			continue
+		}
+		entry := planFile(currentDir, file, opts)
+		if entry.Output != "" {
+			key := strings.ToLower(entry.Output)
+			if opts.nameClaims != nil && !isVideoFile(entry.Source) {
+				// As nameClaims.reserve would name it.
+				name, renamed := claims.claim(plan.OutputDir, entry.Source, entry.Output, readPlanExif(currentDir, entry.Source, opts))
+				if renamed != "" {
+					plan.Conflicts = append(plan.Conflicts, planConflict{Kind: conflictCollision, Source: entry.Source, Output: renamed, Detail: "renamed to " + name})
+				}
+				entry.Output, key = name, strings.ToLower(name)
+			} else if owner, ok := owners[key]; ok {
+				plan.Conflicts = append(plan.Conflicts, planConflict{Kind: conflictCollision, Source: entry.Source, Output: entry.Output, Detail: "also the output of " + owner})
+			}
+			owners[key] = entry.Source
+			if plan.OutputDir != "" {
+				if _, err := os.Stat(filepath.Join(plan.OutputDir, plannedPath(entry, opts))); err == nil {
+					plan.Conflicts = append(plan.Conflicts, planConflict{Kind: conflictExists, Source: entry.Source, Output: entry.Output})
+				}
+			}
+		}
+		plan.Files = append(plan.Files, entry)
+		plan.SourceBytes += entry.Size
+		plan.EstimatedBytes += entry.EstimatedSize
+	}
+	plan.Skipped = opts.skipped.drain()
+	if plan.OutputDir != "" {
+		if free, err := freeSpace(existingAncestor(plan.OutputDir)); err == nil {
+			plan.AvailableBytes = int64(free)
+		}
+	}
+	return plan, nil
+}
+
+// planFile plans one source: its size, dimensions and output.
+func planFile(currentDir string, file os.DirEntry, opts options) plannedFile {
+	source := file.Name()
+	if currentDir == "" {
+		if abs, err := filepath.Abs(source); err == nil {
+			source = abs
+		}
+	}
+	entry := plannedFile{Source: source}
+	if info, err := file.Info(); err == nil {
+		entry.Size, entry.ModTime = info.Size(), info.ModTime()
+	}
+	if isVideoFile(file.Name()) {
+		photoName, err := outputName(currentDir, file.Name(), nil, opts)
+		if err != nil {
+			entry.Error = err.Error()
+			return entry
+		}
+		entry.Output = filepath.ToSlash(videoOutputName(photoName, file.Name(), opts))
+		entry.EstimatedSize = entry.Size
+		return entry
+	}
+
+	info, err := probeSource(filepath.Join(currentDir, file.Name()))
+	if err != nil {
+		entry.Error = err.Error()
+		entry.EstimatedSize = estimateOutputSize(0, 0, entry.Size, opts)
+		return entry
+	}
+	rawExif, err := editExif(info.exif, opts)
+	if err == nil {
+		var name string
+		if name, err = outputName(currentDir, file.Name(), rawExif, opts); err == nil {
+			entry.Output = filepath.ToSlash(name)
+		}
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	entry.Width, entry.Height = info.width, info.height
+	entry.EstimatedSize = estimateOutputSize(info.width, info.height, entry.Size, opts)
+	return entry
+}
+
+// readPlanExif is the edited EXIF block of a source, for nameClaims.
+func readPlanExif(currentDir, source string, opts options) []byte {
+	rawExif, err := editExif(readExifFile(filepath.Join(currentDir, source)), opts)
+	if err != nil {
+		return nil
+	}
+	return rawExif
+}
+
+// plannedPath is the file the output of entry is written to, with the
+// extension --output-format gives it.
+func plannedPath(entry plannedFile, opts options) string {
+	name := filepath.FromSlash(entry.Output)
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	switch {
+	case isVideoFile(entry.Source):
+		return name
+	case opts.outputFormat == formatPNG:
+		return base + ".png"
+	case opts.outputFormat == formatPPM:
+		return base + ".ppm"
+	case opts.outputFormat == formatRawRGBA:
+		return fmt.Sprintf("%s.%dx%d.rgba", base, entry.Width, entry.Height)
+	}
+	return name
+}
+
+// jpegBitsPerPixel is roughly what camera photos take per pixel as JPEGs
+// at a range of qualities. Estimates interpolate between them.
+var jpegBitsPerPixel = []struct {
+	quality int
+	bits    float64
+}{{1, 0.2}, {50, 1.3}, {75, 2.0}, {85, 2.8}, {90, 3.5}, {95, 5.0}, {100, 9.0}}
+
+// estimateOutputSize guesses the size of the output of a width x height
+// source of sourceSize bytes, after --resize. Without dimensions it is one
+// and a half times the source, about what HEIC saves over JPEG.
+func estimateOutputSize(width, height int, sourceSize int64, opts options) int64 {
+	if width <= 0 || height <= 0 {
+		return sourceSize * 3 / 2
+	}
+	if opts.resizeWidth > 0 && opts.resizeHeight > 0 {
+		maxWidth, maxHeight := opts.resizeWidth, opts.resizeHeight
+		if (width > height) != (maxWidth > maxHeight) {
+			maxWidth, maxHeight = maxHeight, maxWidth
+		}
+		if opts.fit == fitExact {
+			width, height = maxWidth, maxHeight
+		} else {
+			width, height = fitDimensions(width, height, maxWidth, maxHeight)
+		}
+	}
+	pixels := float64(width) * float64(height)
+	switch opts.outputFormat {
+	case formatPPM:
+		return int64(pixels*3) + 20
+	case formatRawRGBA:
+		return int64(pixels * 4)
+	case formatPNG:
+		// Lossless photos take about half their raw size.
+		return int64(pixels * 1.5)
+	}
+	bits := jpegBitsPerPixel[len(jpegBitsPerPixel)-1].bits
+	for i := 1; i < len(jpegBitsPerPixel); i++ {
+		lo, hi := jpegBitsPerPixel[i-1], jpegBitsPerPixel[i]
+		if opts.quality <= hi.quality {
+			t := float64(opts.quality-lo.quality) / float64(hi.quality-lo.quality)
+			bits = lo.bits + t*(hi.bits-lo.bits)
+			break
+		}
+	}
+	return int64(math.Round(pixels * bits / 8))
+}
+
+// existingAncestor is dir, or its nearest parent that exists, whose
+// filesystem the outputs will be written to.
+func existingAncestor(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// planSettings are the flags of opts that differ from their defaults,
+// except planFlags and secrets.
+func planSettings(opts options) map[string]string {
+	// A flag set's defaults are the values it is bound to, so the real
+	// ones come from a set bound to defaultOptions.
+	defaults := map[string]string{}
+	base := defaultOptions()
+	newFlagSet(&base, io.Discard).VisitAll(func(f *flag.Flag) { defaults[f.Name] = f.Value.String() })
+	settings := map[string]string{}
+	newFlagSet(&opts, io.Discard).VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if planFlags[f.Name] || secretFlags[f.Name] || value == defaults[f.Name] {
+			return
+		}
+		settings[f.Name] = value
+	})
+	return settings
+}
+
+// writePlan implements --plan-only: it plans the conversion of files and
+// writes the plan to opts.planOnly, or stdout for "-", with a summary on
+// summary.
+func writePlan(currentDir string, files []os.DirEntry, opts options, summary io.Writer) error {
+	plan, err := makePlan(currentDir, files, opts, time.Now())
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if opts.planOnly == stdioPath {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(opts.planOnly, data, 0644); err != nil {
+		return err
+	}
+	printPlanSummary(summary, plan)
+	return nil
+}
+
+// printPlanSummary describes plan in a few lines.
+func printPlanSummary(w io.Writer, plan *conversionPlan) {
+	failed := 0
+	for _, file := range plan.Files {
+		if file.Error != "" {
+			failed++
+		}
+	}
+	fmt.Fprintf(w, "Plan: %d files, %s of sources, about %s of outputs\n", len(plan.Files), humanReadableFileSize(plan.SourceBytes), humanReadableFileSize(plan.EstimatedBytes))
+	if failed > 0 {
+		fmt.Fprintf(w, "Plan: %d files could not be read\n", failed)
+	}
+	if len(plan.Skipped) > 0 {
+		fmt.Fprintf(w, "Plan: %d entries skipped (%s)\n", len(plan.Skipped), skipCounts(plan.Skipped))
+	}
+	for _, conflict := range plan.Conflicts {
+		detail := ""
+		if conflict.Detail != "" {
+			detail = " (" + conflict.Detail + ")"
+		}
+		fmt.Fprintf(w, "Conflict: %s %s > %s%s\n", conflict.Kind, conflict.Source, conflict.Output, detail)
+	}
+	if plan.AvailableBytes >= 0 {
+		fmt.Fprintf(w, "Plan: %s free in %s\n", humanReadableFileSize(plan.AvailableBytes), plan.OutputDir)
+		if plan.EstimatedBytes > plan.AvailableBytes {
+			fmt.Fprintf(w, "Warning: the outputs may not fit, needing about %s more\n", humanReadableFileSize(plan.EstimatedBytes-plan.AvailableBytes))
+		}
+	}
+}
+
+// loadPlan reads a plan written by --plan-only.
+func loadPlan(path string) (*conversionPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan conversionPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, err
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("unsupported plan version %d", plan.Version)
+	}
+	return &plan, nil
+}
+
+// applyPlanSettings sets the flags the plan was made with on fs, except
+// those given on the command line or in the environment, which win.
+func applyPlanSettings(fs *flag.FlagSet, plan *conversionPlan) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for name, value := range plan.Settings {
+		if given[name] || planFlags[name] || fs.Lookup(name) == nil {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid setting %s=%q: %v", name, value, err)
+		}
+	}
+	return nil
+}
+
+// entries lists the sources of the plan in its order, for the pipeline,
+// with the output names it gives them. Sources that have changed since the
+// plan was made are reported on warnings and converted as they are now;
+// missing ones are kept, to fail when they are read.
+func (p *conversionPlan) entries(warnings io.Writer) ([]os.DirEntry, map[string]string) {
+	files := make([]os.DirEntry, 0, len(p.Files))
+	names := map[string]string{}
+	for _, file := range p.Files {
+		info, err := os.Stat(filepath.Join(p.Dir, file.Source))
+		switch {
+		case err != nil:
+			fmt.Fprintf(warnings, "Warning: %s is gone since the plan was made\n", file.Source)
+			info = plannedInfo{file}
+		case info.Size() != file.Size || !info.ModTime().Equal(file.ModTime):
+			fmt.Fprintf(warnings, "Warning: %s has changed since the plan was made\n", file.Source)
+		}
+		files = append(files, manifestEntry{path: file.Source, info: info})
+		if file.Output != "" {
+			names[file.Source] = filepath.FromSlash(file.Output)
+		}
+	}
+	return files, names
+}
+
+// plannedInfo stands in for the FileInfo of a planned source that no longer
+// exists.
+type plannedInfo struct {
+	file plannedFile
+}
+
+func (i plannedInfo) Name() string       { return filepath.Base(i.file.Source) }
+func (i plannedInfo) Size() int64        { return i.file.Size }
+func (i plannedInfo) Mode() fs.FileMode  { return 0 }
+func (i plannedInfo) ModTime() time.Time { return i.file.ModTime }
+func (i plannedInfo) IsDir() bool        { return false }
+func (i plannedInfo) Sys() interface{}   { return nil }
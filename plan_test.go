@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePlanFixture copies the camel photo into a temporary directory under
+// each of names.
+func writePlanFixture(t *testing.T, names ...string) string {
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	dir := writePlanFixture(t, "a.heic", "b.heic")
+	planPath := filepath.Join(t.TempDir(), "plan.json")
+
+	opts, err := parseOptions([]string{"--plan-only", planPath, "--quality", "80", "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jpegs")); !os.IsNotExist(err) {
+		t.Fatalf("--plan-only created the output directory: %v", err)
+	}
+	plan, err := loadPlan(planPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Files) != 2 || plan.Files[0].Output != "a.jpg" || plan.Files[1].Output != "b.jpg" {
+		t.Fatalf("unexpected files %+v", plan.Files)
+	}
+	if plan.Settings["quality"] != "80" || plan.Settings["plan-only"] != "" {
+		t.Errorf("unexpected settings %v", plan.Settings)
+	}
+	if plan.EstimatedBytes <= 0 || plan.SourceBytes != 2*plan.Files[0].Size {
+		t.Errorf("unexpected sizes: %d sources, %d estimated", plan.SourceBytes, plan.EstimatedBytes)
+	}
+
+	// Output names can be edited before the plan runs.
+	plan.Files[1].Output = "renamed/b-edited.jpg"
+	data, _ := json.Marshal(plan)
+	if err := os.WriteFile(planPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err = parseOptions([]string{"--execute-plan", planPath, "--non-interactive"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.quality != 80 {
+		t.Errorf("plan setting not applied: quality %d", opts.quality)
+	}
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", filepath.Join("renamed", "b-edited.jpg")} {
+		if _, err := os.Stat(filepath.Join(dir, "jpegs", name)); err != nil {
+			t.Errorf("missing output: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jpegs", "b.jpg")); err == nil {
+		t.Error("edited output name ignored")
+	}
+}
+
+func TestPlanConflicts(t *testing.T) {
+	dir := writePlanFixture(t, "a.heic", "b.heic")
+	if err := os.MkdirAll(filepath.Join(dir, "jpegs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "jpegs", "a.jpg"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := filepath.Join(t.TempDir(), "name.tmpl")
+	if err := os.WriteFile(tmpl, []byte("same"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--name-template-file", tmpl, dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentDir, files, err := resolveInput(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := makePlan(currentDir, files, opts, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Files[0].Output != "same.jpg" || plan.Files[1].Output == "same.jpg" {
+		t.Errorf("collision not resolved: %+v", plan.Files)
+	}
+	kinds := map[string]int{}
+	for _, conflict := range plan.Conflicts {
+		kinds[conflict.Kind]++
+	}
+	if kinds[conflictCollision] != 1 {
+		t.Errorf("unexpected conflicts %+v", plan.Conflicts)
+	}
+
+	opts, _ = parseOptions([]string{dir}, io.Discard)
+	plan, err = makePlan(currentDir, files, opts, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].Kind != conflictExists || plan.Conflicts[0].Output != "a.jpg" {
+		t.Errorf("existing output not reported: %+v", plan.Conflicts)
+	}
+}
+
+func TestEstimateOutputSize(t *testing.T) {
+	opts := defaultOptions()
+	opts.quality = 90
+	full := estimateOutputSize(4000, 3000, 0, opts)
+	if full < 4_000_000 || full > 6_000_000 {
+		t.Errorf("12 MP at quality 90: %d", full)
+	}
+	opts.resizeWidth, opts.resizeHeight = 1000, 1000
+	if small := estimateOutputSize(4000, 3000, 0, opts); small*10 > full {
+		t.Errorf("--resize not accounted for: %d", small)
+	}
+	if got := estimateOutputSize(0, 0, 1000, opts); got != 1500 {
+		t.Errorf("without dimensions: %d", got)
+	}
+}
+
+func TestPlanRejected(t *testing.T) {
+	for _, args := range [][]string{
+		{"--plan-only", "p.json", "--dry-run", "."},
+		{"--plan-only", "p.json", "--execute-plan", "p.json"},
+		{"--plan-only", "p.json", "-"},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil || !strings.Contains(err.Error(), "plan") {
+			t.Errorf("%v: expected error, got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+// errNotPlist is returned for files that are neither XML nor binary
+// property lists.
+var errNotPlist = errors.New("not a property list")
+
+// plistStrings reads the string values of the top-level dictionary of an
+// XML or binary (bplist00) property list, by key. Values of other types are
+// left out; that is all shared album metadata needs.
+func plistStrings(data []byte) (map[string]string, error) {
+	if bytes.HasPrefix(data, []byte("bplist00")) {
+		return binaryPlistStrings(data)
+	}
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	if bytes.Contains(head, []byte("<plist")) {
+		return xmlPlistStrings(data)
+	}
+	return nil, errNotPlist
+}
+
+// xmlPlistStrings walks the tokens of an XML property list, pairing each
+// <key> of the outermost <dict> with the <string> that follows it.
+func xmlPlistStrings(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	depth := 0 // of <dict> and <array> elements
+	key, haveKey := "", false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "dict", "array":
+				depth++
+				haveKey = false
+			case "key", "string":
+				var text string
+				if err := d.DecodeElement(&text, &t); err != nil {
+					return nil, err
+				}
+				if depth != 1 {
+					continue
+				}
+				if t.Name.Local == "key" {
+					key, haveKey = text, true
+				} else if haveKey {
+					values[key], haveKey = text, false
+				}
+			default:
+				// Any other value ends the pairing of its key.
+				if depth == 1 && t.Name.Local != "plist" {
+					haveKey = false
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "dict" || t.Name.Local == "array" {
+				depth--
+			}
+		}
+	}
+}
+
+// binaryPlistStrings reads the top object of a bplist00 file, which must
+// be a dictionary, and the ASCII and UTF-16 strings among its values.
+func binaryPlistStrings(data []byte) (map[string]string, error) {
+	if len(data) < 8+32 {
+		return nil, errNotPlist
+	}
+	trailer := data[len(data)-32:]
+	offsetSize, refSize := int(trailer[6]), int(trailer[7])
+	count := binary.BigEndian.Uint64(trailer[8:])
+	top := binary.BigEndian.Uint64(trailer[16:])
+	table := binary.BigEndian.Uint64(trailer[24:])
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 || top >= count ||
+		table > uint64(len(data)) || count > (uint64(len(data))-table)/uint64(offsetSize) {
+		return nil, errNotPlist
+	}
+	p := binaryPlist{data: data, offsetSize: offsetSize, refSize: refSize, count: count, table: int(table)}
+
+	offset, err := p.offset(top)
+	if err != nil {
+		return nil, err
+	}
+	marker := data[offset]
+	if marker>>4 != 0xd {
+		return nil, fmt.Errorf("top object of property list is not a dictionary")
+	}
+	n, start, err := p.length(offset)
+	if err != nil {
+		return nil, err
+	}
+	if start+2*n*refSize > len(data) {
+		return nil, errNotPlist
+	}
+	values := map[string]string{}
+	for i := 0; i < n; i++ {
+		key, err := p.stringAt(p.ref(start + i*refSize))
+		if err != nil {
+			continue
+		}
+		value, err := p.stringAt(p.ref(start + (n+i)*refSize))
+		if err != nil {
+			continue
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// binaryPlist is a bplist00 file and the sizes its trailer gives.
+type binaryPlist struct {
+	data       []byte
+	offsetSize int
+	refSize    int
+	count      uint64
+	table      int
+}
+
+func (p binaryPlist) ref(at int) uint64 {
+	return readUint(p.data[at : at+p.refSize])
+}
+
+// offset is where object number ref starts.
+func (p binaryPlist) offset(ref uint64) (int, error) {
+	if ref >= p.count {
+		return 0, errNotPlist
+	}
+	at := p.table + int(ref)*p.offsetSize
+	offset := readUint(p.data[at : at+p.offsetSize])
+	if offset < 8 || offset >= uint64(p.table) {
+		return 0, errNotPlist
+	}
+	return int(offset), nil
+}
+
+// length reads the element count of the object at offset, from its marker
+// or the integer that follows a marker of 0xF, and where its data starts.
+func (p binaryPlist) length(offset int) (n, start int, err error) {
+	n, start = int(p.data[offset]&0xf), offset+1
+	if n != 0xf {
+		return n, start, nil
+	}
+	if start >= len(p.data) || p.data[start]>>4 != 0x1 {
+		return 0, 0, errNotPlist
+	}
+	size := 1 << (p.data[start] & 0xf)
+	if size > 8 || start+1+size > len(p.data) {
+		return 0, 0, errNotPlist
+	}
+	count := readUint(p.data[start+1 : start+1+size])
+	if count > uint64(len(p.data)) {
+		return 0, 0, errNotPlist
+	}
+	return int(count), start + 1 + size, nil
+}
+
+// stringAt reads object number ref, which must be a string.
+func (p binaryPlist) stringAt(ref uint64) (string, error) {
+	offset, err := p.offset(ref)
+	if err != nil {
+		return "", err
+	}
+	n, start, err := p.length(offset)
+	if err != nil {
+		return "", err
+	}
+	switch p.data[offset] >> 4 {
+	case 0x5: // ASCII
+		if start+n > len(p.data) {
+			return "", errNotPlist
+		}
+		return string(p.data[start : start+n]), nil
+	case 0x6: // UTF-16BE
+		if start+2*n > len(p.data) {
+			return "", errNotPlist
+		}
+		units := make([]uint16, n)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(p.data[start+2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+	}
+	return "", fmt.Errorf("not a string")
+}
+
+// readUint reads a big-endian unsigned integer of up to eight bytes.
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+const testXMLPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>count</key>
+	<integer>3</integer>
+	<key>owner</key>
+	<dict>
+		<key>name</key>
+		<string>Nested</string>
+	</dict>
+	<key>name</key>
+	<string>Family &amp; Friends</string>
+</dict>
+</plist>
+`
+
+// testBinaryPlist builds a bplist00 file holding {"title": "Pets"}.
+func testBinaryPlist() []byte {
+	data := []byte("bplist00")
+	data = append(data, 0xd1, 1, 2)                    // dictionary of one entry, at 8
+	data = append(data, 0x55, 't', 'i', 't', 'l', 'e') // at 11
+	data = append(data, 0x54, 'P', 'e', 't', 's')      // at 17
+	table := len(data)
+	data = append(data, 8, 11, 17)
+	trailer := make([]byte, 32)
+	trailer[6], trailer[7] = 1, 1
+	binary.BigEndian.PutUint64(trailer[8:], 3)
+	binary.BigEndian.PutUint64(trailer[16:], 0)
+	binary.BigEndian.PutUint64(trailer[24:], uint64(table))
+	return append(data, trailer...)
+}
+
+func TestPlistStrings(t *testing.T) {
+	values, err := plistStrings([]byte(testXMLPlist))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values["name"] != "Family & Friends" {
+		t.Errorf("XML: got %v", values)
+	}
+
+	values, err = plistStrings(testBinaryPlist())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values["title"] != "Pets" {
+		t.Errorf("binary: got %v", values)
+	}
+
+	if _, err := plistStrings([]byte("name = Pets")); err != errNotPlist {
+		t.Errorf("text file: %v", err)
+	}
+	// Truncated binary lists are rejected, not read out of bounds.
+	data := testBinaryPlist()
+	for n := 8; n < len(data); n++ {
+		plistStrings(data[:n])
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A --plugin is an external command that transforms each image between
+// decoding and encoding. It reads one image from stdin and writes the result
+// to stdout, both as PAM (netpbm P7): a short text header followed by raw
+// 8-bit RGBA (or RGB) rows, top to bottom. Tools such as ImageMagick read and
+// write it directly ("magick pam:- ... pam:-"). Several plugins separated by
+// "|" run as a pipeline, in order.
+//
+// Plugins also get HEICTOJPEG_PLUGIN_SOURCE, the path of the HEIC being
+// converted, in their environment, and their stderr is passed through.
+
+// maxPluginScale bounds the images plugins may return to this many times
+// the pixels of the image passed to the first plugin (twice the width and
+// height), so a bad header cannot make readPAM allocate without limit.
+const maxPluginScale = 4
+
+// pluginTimeout bounds one plugin run, so a plugin that hangs fails its file
+// rather than stalling the batch.
+const pluginTimeout = 2 * time.Minute
+
+// pluginCommand is the program and arguments of one --plugin stage. They are
+// split on spaces; no shell is involved.
+type pluginCommand []string
+
+func (p pluginCommand) String() string {
+	return strings.Join(p, " ")
+}
+
+// parsePlugins parses a --plugin value and checks that every program exists.
+func parsePlugins(value string) ([]pluginCommand, error) {
+	var plugins []pluginCommand
+	for _, stage := range strings.Split(value, "|") {
+		fields := strings.Fields(stage)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("empty plugin command in %q", value)
+		}
+		if _, err := exec.LookPath(fields[0]); err != nil {
+			return nil, err
+		}
+		plugins = append(plugins, pluginCommand(fields))
+	}
+	return plugins, nil
+}
+
+// runPlugins passes img through each plugin in turn.
+func runPlugins(img image.Image, plugins []pluginCommand, sourcePath string) (image.Image, error) {
+	maxPixels := maxPluginScale * img.Bounds().Dx() * img.Bounds().Dy()
+	for _, plugin := range plugins {
+		var err error
+		if img, err = runPlugin(img, plugin, sourcePath, maxPixels); err != nil {
+			return nil, fmt.Errorf("plugin %q: %v", plugin, err)
+		}
+	}
+	return img, nil
+}
+
+func runPlugin(img image.Image, plugin pluginCommand, sourcePath string, maxPixels int) (image.Image, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, plugin[0], plugin[1:]...)
+	cmd.Env = append(os.Environ(), envPrefix+"PLUGIN_SOURCE="+sourcePath)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// Write and read concurrently so a plugin that streams its output does
+	// not block on a full pipe.
+	written := make(chan error, 1)
+	go func() {
+		err := writePAM(stdin, img)
+		stdin.Close()
+		written <- err
+	}()
+	result, readErr := readPAM(bufio.NewReader(stdout), maxPixels)
+	io.Copy(io.Discard, stdout)
+	writeErr := <-written
+	if err := cmd.Wait(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("timed out after %v", pluginTimeout)
+		}
+		return nil, err
+	}
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("bad output: %v", readErr)
+	}
+	return result, nil
+}
+
+// writePAM writes img as an RGB_ALPHA PAM image.
+func writePAM(w io.Writer, img image.Image) error {
+	nrgba := packedNRGBA(img)
+	b := nrgba.Bounds()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n", b.Dx(), b.Dy())
+	if _, err := bw.Write(nrgba.Pix); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// readPAM reads a PAM image with a depth of 3 (RGB) or 4 (RGBA) and a
+// maximum value of 255, of at most maxPixels pixels.
+func readPAM(r *bufio.Reader, maxPixels int) (*image.NRGBA, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "P7" {
+		return nil, fmt.Errorf("not a PAM image")
+	}
+	header := map[string]int{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("truncated PAM header")
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] == "ENDHDR" {
+			break
+		}
+		if len(fields) == 2 && fields[0] != "TUPLTYPE" {
+			if header[fields[0]], err = strconv.Atoi(fields[1]); err != nil {
+				return nil, fmt.Errorf("bad PAM %s %q", fields[0], fields[1])
+			}
+		}
+	}
+
+	width, height, depth := header["WIDTH"], header["HEIGHT"], header["DEPTH"]
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("bad PAM size %dx%d", width, height)
+	}
+	if width > maxPixels/height {
+		return nil, fmt.Errorf("PAM size %dx%d is over %d pixels", width, height, maxPixels)
+	}
+	if header["MAXVAL"] != 255 || (depth != 3 && depth != 4) {
+		return nil, fmt.Errorf("unsupported PAM depth %d or maximum value %d", depth, header["MAXVAL"])
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if depth == 4 {
+		if _, err := io.ReadFull(r, img.Pix); err != nil {
+			return nil, err
+		}
+		return img, nil
+	}
+	row := make([]byte, 3*width)
+	for y := 0; y < height; y++ {
+		if _, err := io.ReadFull(r, row); err != nil {
+			return nil, err
+		}
+		pix := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			copy(pix[4*x:4*x+3], row[3*x:3*x+3])
+			pix[4*x+3] = 0xff
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testPattern() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 5, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{uint8(50 * x), uint8(80 * y), 7, 255})
+		}
+	}
+	return img
+}
+
+func TestPAMRoundTrip(t *testing.T) {
+	img := testPattern()
+	var buf bytes.Buffer
+	if err := writePAM(&buf, img.SubImage(image.Rect(1, 1, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readPAM(bufio.NewReader(&buf), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bounds() != image.Rect(0, 0, 3, 2) || got.NRGBAAt(0, 0) != img.NRGBAAt(1, 1) || got.NRGBAAt(2, 1) != img.NRGBAAt(3, 2) {
+		t.Errorf("sub-image did not round-trip: %v", got.Bounds())
+	}
+
+	rgb := "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 3\nMAXVAL 255\n# from a plugin\nTUPLTYPE RGB\nENDHDR\n\x01\x02\x03\x04\x05\x06"
+	got, err = readPAM(bufio.NewReader(strings.NewReader(rgb)), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NRGBAAt(1, 0) != (color.NRGBA{4, 5, 6, 255}) {
+		t.Errorf("got %v for an RGB pixel", got.NRGBAAt(1, 0))
+	}
+
+	for _, bad := range []string{"P6\n", "P7\nWIDTH 2\n", "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 1\nMAXVAL 255\nENDHDR\n", "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nENDHDR\n\x00", "P7\nWIDTH 7\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nENDHDR\n"} {
+		if _, err := readPAM(bufio.NewReader(strings.NewReader(bad)), 6); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestRunPlugins(t *testing.T) {
+	// cat passes the image through unchanged.
+	plugins, err := parsePlugins("cat | cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := testPattern()
+	got, err := runPlugins(img, plugins, "IMG.HEIC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Bounds() != img.Bounds() || !bytes.Equal(got.(*image.NRGBA).Pix, img.Pix) {
+		t.Error("image changed passing through cat")
+	}
+
+	script := filepath.Join(t.TempDir(), "plugin.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat >/dev/null\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runPlugins(img, []pluginCommand{{script}}, "IMG.HEIC"); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("got %v, want the plugin's exit status", err)
+	}
+
+	// A plugin may return at most four times the pixels it was given.
+	grow := filepath.Join(t.TempDir(), "grow.sh")
+	if err := os.WriteFile(grow, []byte("#!/bin/sh\ncat >/dev/null\nprintf 'P7\\nWIDTH 10\\nHEIGHT 7\\nDEPTH 4\\nMAXVAL 255\\nENDHDR\\n'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runPlugins(img, []pluginCommand{{grow}}, "IMG.HEIC"); err == nil || !strings.Contains(err.Error(), "over 60 pixels") {
+		t.Errorf("got %v, want the output size refused", err)
+	}
+
+	if _, err := parsePlugins("cat |"); err == nil {
+		t.Error("expected an error for an empty stage")
+	}
+	if _, err := parseOptions([]string{"--plugin", "no-such-plugin-command"}, io.Discard); err == nil {
+		t.Error("expected an error for a missing program")
+	}
+}
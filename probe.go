@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// sourceInfo is what the header boxes of a HEIC file reveal without decoding
+// any image data.
+type sourceInfo struct {
+	size          int64
+	brand         string // describeBrands of the ftyp box, "" when missing
+	width, height int    // displayed size, 0 when unknown
+	exif          []byte
+	xmp           []byte
+	items         []heifItem
+
+	// favorite is set by filterSources for Photos library favourites.
+	favorite bool
+}
+
+// probeSource reads the size and EXIF of a HEIC file from its meta box (the
+// ispe property and the Exif item). The compressed image data is not read.
+func probeSource(path string) (sourceInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return sourceInfo{}, err
+	}
+	defer f.Close()
+
+	var info sourceInfo
+	if stat, err := f.Stat(); err == nil {
+		info.size = stat.Size()
+	}
+	if major, compatible := readBrands(f); major != "" {
+		info.brand = describeBrands(major, compatible)
+	}
+	info.width, info.height, _ = imageDimensions(f)
+	info.items, _ = listItems(f)
+	info.xmp = extractXMP(f)
+	info.exif, err = extractExif(f)
+	return info, err
+}
+
+// runInfo implements `heictojpeg info FILE...`: a summary of each file from
+// its headers only.
+func runInfo(paths []string, output io.Writer) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("usage: heictojpeg info FILE...")
+	}
+	failed := 0
+	for _, path := range paths {
+		info, err := probeSource(path)
+		if err != nil {
+			fmt.Fprintf(output, "%s: %v\n", path, err)
+			failed++
+			continue
+		}
+		writeInfo(output, path, info)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files could not be read", failed, len(paths))
+	}
+	return nil
+}
+
+func writeInfo(output io.Writer, path string, info sourceInfo) {
+	x := decodeExif(info.exif)
+	fmt.Fprintf(output, "%s\n", path)
+	fmt.Fprintf(output, "  Size:       %s\n", humanReadableFileSize(info.size))
+	if info.brand != "" {
+		fmt.Fprintf(output, "  Brand:      %s\n", info.brand)
+	}
+	if info.width > 0 {
+		fmt.Fprintf(output, "  Dimensions: %dx%d (%.1f MP)\n", info.width, info.height, float64(info.width*info.height)/1e6)
+	}
+	if camera := strings.TrimSpace(exifStringField(x, exif.Make) + " " + exifStringField(x, exif.Model)); camera != "" {
+		fmt.Fprintf(output, "  Camera:     %s\n", camera)
+	}
+	if lens := exifStringField(x, exif.LensModel); lens != "" {
+		fmt.Fprintf(output, "  Lens:       %s\n", lens)
+	}
+	if taken, zoned, ok := exifCaptureTime(info.exif, nil); ok {
+		layout := "2006-01-02 15:04:05"
+		if zoned {
+			layout += " -07:00"
+		}
+		fmt.Fprintf(output, "  Taken:      %s\n", taken.Format(layout))
+	}
+	if lat, lon, ok := gpsCoordinates(info.exif); ok {
+		fmt.Fprintf(output, "  Location:   %.5f, %.5f (%s)\n", lat, lon, locationFolder(lat, lon, ok))
+	}
+	if rating := sourceRating(info.exif, info.xmp); rating != 0 {
+		fmt.Fprintf(output, "  Rating:     %d\n", rating)
+	}
+	if isScreenshot(info.exif, info.width, info.height) {
+		fmt.Fprintf(output, "  Screenshot: yes\n")
+	}
+	if id := appleContentIdentifier(info.exif); id != "" {
+		fmt.Fprintf(output, "  Live Photo: %s (pairing identifier; the video is a separate file)\n", id)
+	}
+	if len(info.items) > 0 {
+		fmt.Fprintf(output, "  Items:\n")
+	}
+	for _, item := range info.items {
+		var details []string
+		if item.width > 0 {
+			details = append(details, fmt.Sprintf("%dx%d", item.width, item.height))
+		}
+		if item.tiles > 0 {
+			details = append(details, fmt.Sprintf("%d tiles", item.tiles))
+		}
+		details = append(details, humanReadableFileSize(item.size))
+		if !item.converted {
+			details = append(details, "not converted")
+		}
+		fmt.Fprintf(output, "    %-16s %s\n", item.kind, strings.Join(details, ", "))
+	}
+}
+
+// dryRun lists what a run would convert, and the output names it would use,
+// without decoding or writing anything.
+func dryRun(currentDir string, files []os.DirEntry, opts options, output io.Writer) error {
+	sorted := sortFiles(selectBursts(currentDir, filterSources(currentDir, files, opts), opts), opts.order)
+	opts.fileIndex = indexFiles(sorted)
+
+	count := 0
+	for _, file := range sorted {
+		if !isHEICFile(file.Name()) {
+			opts.skipped.addIgnored(file)
+			continue
+		}
+		count++
+		info, err := probeSource(filepath.Join(currentDir, file.Name()))
+		if err != nil {
+			fmt.Fprintf(output, "%s > Error > %v\n", file.Name(), err)
+			continue
+		}
+		name, err := outputName(currentDir, file.Name(), info.exif, opts)
+		if err != nil {
+			fmt.Fprintf(output, "%s > Error > %v\n", file.Name(), err)
+			continue
+		}
+		fmt.Fprintf(output, "%s %s %dx%d > %s\n", file.Name(), humanReadableFileSize(info.size), info.width, info.height, filepath.ToSlash(name))
+	}
+	if opts.verbose {
+		for _, file := range opts.skipped.drain() {
+			fmt.Fprintln(output, skipLine(currentDir, file))
+		}
+	}
+	fmt.Fprintf(output, "\nDry run: %d files would be converted\n", count)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProbeSource(t *testing.T) {
+	info, err := probeSource(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.width != 1596 || info.height != 1064 || info.size == 0 || info.exif != nil {
+		t.Fatalf("unexpected info %+v", info)
+	}
+}
+
+func TestMinDimensionFilter(t *testing.T) {
+	dir := filepath.Join("testdata", "images")
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasCamel := func(files []os.DirEntry) bool {
+		for _, file := range files {
+			if file.Name() == "goheif-camel.heic" {
+				return true
+			}
+		}
+		return false
+	}
+
+	opts := defaultOptions()
+	opts.minWidth = 1596
+	opts.minHeight = 1000
+	if !hasCamel(filterSources(dir, files, opts)) {
+		t.Error("1596x1064 image dropped by --min-width 1596 --min-height 1000")
+	}
+	opts.minWidth = 3000
+	if hasCamel(filterSources(dir, files, opts)) {
+		t.Error("1596x1064 image kept by --min-width 3000")
+	}
+
+	// 1596x1064 is 1.7 megapixels.
+	opts = defaultOptions()
+	opts.minMegapixels, opts.maxMegapixels = 1.5, 2
+	if !hasCamel(filterSources(dir, files, opts)) {
+		t.Error("1.7 MP image dropped by --min-megapixels 1.5 --max-megapixels 2")
+	}
+	opts.minMegapixels, opts.maxMegapixels = 2, 0
+	if hasCamel(filterSources(dir, files, opts)) {
+		t.Error("1.7 MP image kept by --min-megapixels 2")
+	}
+	opts.minMegapixels, opts.maxMegapixels = 0, 1
+	if hasCamel(filterSources(dir, files, opts)) {
+		t.Error("1.7 MP image kept by --max-megapixels 1")
+	}
+}
+
+func TestDryRunWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.HEIC"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := dryRun(dir, files, defaultOptions(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "IMG_0001.HEIC 329.6KB 1596x1064 > IMG_0001.jpg") || !strings.Contains(out.String(), "1 files would be converted") {
+		t.Fatalf("unexpected dry run output:\n%s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jpegs")); !os.IsNotExist(err) {
+		t.Fatal("dry run created the output directory")
+	}
+}
+
+func TestRunInfo(t *testing.T) {
+	var out bytes.Buffer
+	if err := runInfo([]string{filepath.Join("testdata", "images", "goheif-camel.heic")}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Dimensions: 1596x1064 (1.7 MP)") {
+		t.Fatalf("unexpected info output:\n%s", out.String())
+	}
+	if err := runInfo([]string{"missing.heic"}, &out); err == nil {
+		t.Fatal("expected error for a missing file")
+	}
+}
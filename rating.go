@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// tagRating is the star rating tag in IFD0, written by Windows and most
+// photo managers alongside xmp:Rating.
+const tagRating = 0x4746
+
+// maxRating is the highest star rating. --favorites-only treats images rated
+// this high as favourites when there is no Photos library to ask.
+const maxRating = 5
+
+// xmpRatingPattern matches xmp:Rating written as an attribute or an element.
+var xmpRatingPattern = regexp.MustCompile(`xmp:Rating(?:\s*=\s*["'](-?[0-9.]+)["']|>\s*(-?[0-9.]+)\s*<)`)
+
+// sourceRating returns the star rating of an image, 0 when unrated and -1
+// when rejected. XMP takes precedence over EXIF, as editors update it first.
+func sourceRating(rawExif, xmp []byte) int {
+	if m := xmpRatingPattern.FindSubmatch(xmp); m != nil {
+		value := m[1]
+		if value == nil {
+			value = m[2]
+		}
+		if rating, err := strconv.ParseFloat(string(value), 64); err == nil {
+			return clampRating(int(rating))
+		}
+	}
+	if len(rawExif) == 0 {
+		return 0
+	}
+	block, err := parseExifBlock(rawExif)
+	if err != nil {
+		return 0
+	}
+	if entry := findEntry(block.ifd0, tagRating); entry != nil && entry.typ == tiffShort && len(entry.value) >= 2 {
+		return clampRating(int(block.order.Uint16(entry.value)))
+	}
+	return 0
+}
+
+func clampRating(rating int) int {
+	switch {
+	case rating < -1:
+		return -1
+	case rating > maxRating:
+		return maxRating
+	}
+	return rating
+}
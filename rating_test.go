@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSourceRating(t *testing.T) {
+	exifRated := buildTestExif([]testTag{{id: tagRating, typ: 3, count: 1, value: []byte{4, 0}}}, nil, nil)
+	tests := []struct {
+		name string
+		exif []byte
+		xmp  string
+		want int
+	}{
+		{"unrated", nil, "", 0},
+		{"xmp attribute", nil, `<rdf:Description xmp:CreatorTool="17.4" xmp:Rating="3"/>`, 3},
+		{"xmp element", nil, `<xmp:Rating> 5 </xmp:Rating>`, 5},
+		{"xmp decimal", nil, `<xmp:Rating>2.0</xmp:Rating>`, 2},
+		{"rejected", nil, `xmp:Rating='-1'`, -1},
+		{"out of range", nil, `xmp:Rating="9"`, 5},
+		{"exif", exifRated, "", 4},
+		{"xmp wins over exif", exifRated, `xmp:Rating="1"`, 1},
+	}
+	for _, tt := range tests {
+		if got := sourceRating(tt.exif, []byte(tt.xmp)); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRatingSkipReason(t *testing.T) {
+	fourStars := sourceInfo{xmp: []byte(`xmp:Rating="4"`)}
+	fiveStars := sourceInfo{xmp: []byte(`xmp:Rating="5"`)}
+	favorite := sourceInfo{favorite: true}
+
+	opts := defaultOptions()
+	opts.minRating = 3
+	if _, reason := skipReason(fourStars, opts); reason != "" {
+		t.Errorf("4 stars skipped by --min-rating 3: %s", reason)
+	}
+	if _, reason := skipReason(favorite, opts); reason != "rated below 3" {
+		t.Errorf("unrated favourite: got %q", reason)
+	}
+
+	opts = defaultOptions()
+	opts.favoritesOnly = true
+	if _, reason := skipReason(fourStars, opts); reason != "not a favourite" {
+		t.Errorf("4 stars: got %q", reason)
+	}
+	_, fiveStarsReason := skipReason(fiveStars, opts)
+	_, favoriteReason := skipReason(favorite, opts)
+	if fiveStarsReason != "" || favoriteReason != "" {
+		t.Error("favourites skipped by --favorites-only")
+	}
+}
+
+func TestFilterByRating(t *testing.T) {
+	// The camel fixture has no XMP or EXIF, so it is unrated.
+	dir := filepath.Join("testdata", "images")
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	opts.favoritesOnly = true
+	for _, file := range filterSources(dir, files, opts) {
+		if file.Name() == "goheif-camel.heic" {
+			t.Errorf("%s kept by --favorites-only", file.Name())
+		}
+	}
+}
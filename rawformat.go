@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Uncompressed output formats for pipelines: binary PPM (P6, 8-bit RGB) and
+// headerless 8-bit RGBA rows, which tools such as ffmpeg read with
+// "-f rawvideo -pix_fmt rgba -s WxH". Neither carries metadata.
+const (
+	formatPPM     = "ppm"
+	formatRawRGBA = "raw-rgba"
+)
+
+func isRawFormat(format string) bool {
+	return format == formatPPM || format == formatRawRGBA
+}
+
+// rawOutputPath replaces the extension of output for format. Raw RGBA files
+// have no header, so their dimensions go into the name: IMG_0001.4032x3024.rgba.
+func rawOutputPath(output string, img image.Image, format string) string {
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	if format == formatPPM {
+		return base + ".ppm"
+	}
+	b := img.Bounds()
+	return fmt.Sprintf("%s.%dx%d.rgba", base, b.Dx(), b.Dy())
+}
+
+// writeRawFile writes img in a raw format next to where output would have
+// gone and returns the path written.
+func writeRawFile(img image.Image, output string, opts options) (string, error) {
+	path := rawOutputPath(output, img, opts.outputFormat)
+	f, err := createOutput(path)
+	if err != nil {
+		return "", err
+	}
+	if err := writeRaw(f, img, opts.outputFormat); err != nil {
+		f.discard()
+		return "", err
+	}
+	if err := f.commit(nil); err != nil {
+		return "", err
+	}
+	return path, applyOwnership(path, opts)
+}
+
+// writeRaw writes img to w as format.
+func writeRaw(w io.Writer, img image.Image, format string) error {
+	nrgba := packedNRGBA(img)
+	b := nrgba.Bounds()
+	bw := bufio.NewWriter(w)
+	if format == formatRawRGBA {
+		bw.Write(nrgba.Pix)
+		return bw.Flush()
+	}
+
+	// PPM has no alpha channel; --alpha flattens transparent images for it.
+	fmt.Fprintf(bw, "P6\n%d %d\n255\n", b.Dx(), b.Dy())
+	row := make([]byte, 3*b.Dx())
+	for y := 0; y < b.Dy(); y++ {
+		pix := nrgba.Pix[y*nrgba.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			copy(row[3*x:3*x+3], pix[4*x:4*x+3])
+		}
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// packedNRGBA returns img as non-premultiplied RGBA whose rows follow each
+// other without padding, starting at (0, 0). It converts only when needed.
+func packedNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Stride == 4*b.Dx() && b.Min == (image.Point{}) {
+		return nrgba
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	return nrgba
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRaw(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 10, 12, 11))
+	img.Set(10, 10, color.RGBA{1, 2, 3, 255})
+	img.Set(11, 10, color.RGBA{4, 5, 6, 255})
+
+	var ppm bytes.Buffer
+	if err := writeRaw(&ppm, img, formatPPM); err != nil {
+		t.Fatal(err)
+	}
+	if want := "P6\n2 1\n255\n\x01\x02\x03\x04\x05\x06"; ppm.String() != want {
+		t.Errorf("got %q, want %q", ppm.String(), want)
+	}
+
+	var rgba bytes.Buffer
+	if err := writeRaw(&rgba, img, formatRawRGBA); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{1, 2, 3, 255, 4, 5, 6, 255}; !bytes.Equal(rgba.Bytes(), want) {
+		t.Errorf("got % x, want % x", rgba.Bytes(), want)
+	}
+}
+
+func TestRawOutputPath(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4032, 3024))
+	output := filepath.Join("jpegs", "IMG_0001.jpg")
+	if got := rawOutputPath(output, img, formatPPM); got != filepath.Join("jpegs", "IMG_0001.ppm") {
+		t.Errorf("ppm: got %s", got)
+	}
+	if got := rawOutputPath(output, img, formatRawRGBA); got != filepath.Join("jpegs", "IMG_0001.4032x3024.rgba") {
+		t.Errorf("raw-rgba: got %s", got)
+	}
+}
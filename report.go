@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Statuses of the files of a --report.
+const (
+	reportConverted = "converted"
+	reportFailed    = "failed"
+	reportSkipped   = "skipped"
+)
+
+// runReport is the --report record of a run: the settings of its session
+// header and the outcome of every source, which `heictojpeg diff` compares
+// between two runs. Sources are named as in logs.txt, relative to the input
+// folder.
+type runReport struct {
+	sessionHeader
+	Finished  time.Time    `json:"finished"`
+	OutputDir string       `json:"output_dir"`
+	Files     []reportFile `json:"files"`
+
+	mu sync.Mutex
+}
+
+// reportFile is the outcome of one source.
+type reportFile struct {
+	Source     string `json:"source"`
+	Status     string `json:"status"`
+	Output     string `json:"output,omitempty"` // relative to the output directory
+	Size       int64  `json:"size"`
+	OutputSize int64  `json:"output_size,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Code       string `json:"code,omitempty"` // errorCode of Error, or the skip reason
+}
+
+func newRunReport(opts options, started time.Time, outputDir string) *runReport {
+	return &runReport{sessionHeader: newSessionHeader(opts, started), OutputDir: outputDir}
+}
+
+// add records a file. It does nothing on a nil report, as when --report is
+// not given.
+func (r *runReport) add(file reportFile) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Files = append(r.Files, file)
+}
+
+// addResult records the result of a conversion, with the sizes of its
+// source and output.
+func (r *runReport) addResult(result *fileResult, size, outputSize int64) {
+	file := reportFile{Source: result.name, Status: reportConverted, Output: result.output, Size: size, OutputSize: outputSize}
+	switch {
+	case errors.Is(result.err, errNotSmaller):
+		file.Status, file.Error, file.OutputSize = reportSkipped, result.err.Error(), 0
+	case result.err != nil:
+		file.Status, file.Error, file.Code, file.OutputSize = reportFailed, result.err.Error(), errorCode(result.err), 0
+	}
+	r.add(file)
+}
+
+// save writes the report to path, sorted by source. A --watch run saves it
+// again after every batch, each time with every file so far; a source
+// converted again keeps only its latest outcome.
+func (r *runReport) save(path string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	latest := make(map[string]int, len(r.Files))
+	files := r.Files[:0]
+	for _, file := range r.Files {
+		if i, ok := latest[file.Source]; ok {
+			files[i] = file
+			continue
+		}
+		latest[file.Source] = len(files)
+		files = append(files, file)
+	}
+	r.Files = files
+	sort.Slice(r.Files, func(i, j int) bool { return r.Files[i].Source < r.Files[j].Source })
+	r.Finished = time.Now()
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// readRunReport reads a report written by --report.
+func readRunReport(path string) (*runReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &runReport{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("%s is not a run report: %v", path, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunReport(t *testing.T) {
+	dir := writePlanFixture(t, "a.heic")
+	if err := os.WriteFile(filepath.Join(dir, "broken.heic"), []byte("not a HEIC file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "report.json")
+
+	opts, err := parseOptions([]string{"--non-interactive", "--report", path, dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	report, err := readRunReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Version == "" || report.OutputDir != filepath.Join(dir, "jpegs") || report.Settings["report"] != path {
+		t.Errorf("got header %+v, output dir %s", report.sessionHeader, report.OutputDir)
+	}
+	if len(report.Files) != 3 {
+		t.Fatalf("got %d files, want 3: %+v", len(report.Files), report.Files)
+	}
+	converted, broken, notes := report.Files[0], report.Files[1], report.Files[2]
+	if converted.Source != "a.heic" || converted.Status != reportConverted || converted.Output != "a.jpg" || converted.Size == 0 || converted.OutputSize == 0 {
+		t.Errorf("converted file = %+v", converted)
+	}
+	if broken.Source != "broken.heic" || broken.Status != reportFailed || broken.Error == "" || broken.Code == "" || broken.OutputSize != 0 {
+		t.Errorf("failed file = %+v", broken)
+	}
+	if notes.Source != "notes.txt" || notes.Status != reportSkipped || notes.Code != skipNotHEIC {
+		t.Errorf("skipped file = %+v", notes)
+	}
+}
+
+func TestRunReportKeepsLatestOutcome(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.json")
+	r := &runReport{}
+	r.add(reportFile{Source: "b.heic", Status: reportFailed})
+	r.add(reportFile{Source: "a.heic", Status: reportConverted})
+	if err := r.save(path); err != nil {
+		t.Fatal(err)
+	}
+	// Converted again by a --watch batch.
+	r.add(reportFile{Source: "b.heic", Status: reportConverted})
+	if err := r.save(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := readRunReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Files) != 2 || saved.Files[0].Source != "a.heic" || saved.Files[1].Status != reportConverted {
+		t.Errorf("got %+v", saved.Files)
+	}
+
+	var none *runReport
+	none.add(reportFile{Source: "a.heic"})
+	if err := none.save(filepath.Join(t.TempDir(), "none.json")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseOptionsReport(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"--report", "r.json", "--dry-run", dir},
+		{"--report", "r.json", "--plan-only", "plan.json", dir},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
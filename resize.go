@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// maxSamples bounds how many source pixels per axis are averaged into one
+// destination pixel when shrinking.
+const maxSamples = 4
+
+// fitDimensions scales width x height down to fit within maxWidth x
+// maxHeight, preserving the aspect ratio. Images that already fit are
+// returned unchanged.
+func fitDimensions(width, height, maxWidth, maxHeight int) (int, int) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+	if width*maxHeight > height*maxWidth {
+		h := height * maxWidth / width
+		if h < 1 {
+			h = 1
+		}
+		return maxWidth, h
+	}
+	w := width * maxHeight / height
+	if w < 1 {
+		w = 1
+	}
+	return w, maxHeight
+}
+
+// scaleImage resizes src to width x height. Each destination pixel averages a
+// grid of up to maxSamples x maxSamples source pixels from the area it covers,
+// which is a cheap box filter when shrinking and nearest-neighbour when
+// enlarging.
+func scaleImage(src image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw == 0 || sh == 0 {
+		return dst
+	}
+
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*sh/height, b.Min.Y+(y+1)*sh/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		stepY := (y1 - y0 + maxSamples - 1) / maxSamples
+
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*sw/width, b.Min.X+(x+1)*sw/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			stepX := (x1 - x0 + maxSamples - 1) / maxSamples
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+pr, g+pg, bl+pb, a+pa
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// limitPixels scales img down, preserving the aspect ratio, so it has at most
+// maxPixels pixels.
+func limitPixels(img image.Image, maxPixels int) image.Image {
+	b := img.Bounds()
+	if b.Dx()*b.Dy() <= maxPixels {
+		return img
+	}
+	scale := math.Sqrt(float64(maxPixels) / float64(b.Dx()*b.Dy()))
+	width, height := int(float64(b.Dx())*scale), int(float64(b.Dy())*scale)
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+	return scaleImage(img, width, height)
+}
+
+// --fit modes for --resize.
+const (
+	fitContain = "contain"
+	fitCover   = "cover"
+	fitExact   = "exact"
+)
+
+func isValidFit(fit string) bool {
+	return fit == fitContain || fit == fitCover || fit == fitExact
+}
+
+// parseResize parses a --resize value such as 1920x1080.
+func parseResize(value string) (width, height int, err error) {
+	w, h, ok := strings.Cut(strings.ToLower(value), "x")
+	if ok {
+		width, err = strconv.Atoi(w)
+	}
+	if ok && err == nil {
+		height, err = strconv.Atoi(h)
+	}
+	if !ok || err != nil || width < 1 || height < 1 {
+		return 0, 0, fmt.Errorf("%q is not WIDTHxHEIGHT", value)
+	}
+	return width, height, nil
+}
+
+// exifOrientation returns the EXIF Orientation tag, 1 (as stored) when it
+// is missing or invalid.
+func exifOrientation(rawExif []byte) int {
+	x := decodeExif(rawExif)
+	if x == nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// isRotated reports whether an EXIF Orientation tag turns the stored pixels
+// by 90 degrees for display (orientations 5 to 8).
+func isRotated(rawExif []byte) bool {
+	return exifOrientation(rawExif) >= 5
+}
+
+// resizeImage applies --resize. For contain and cover the width x height box
+// is turned to match the image's orientation, so 1920x1080 bounds portrait
+// photos at 1080x1920; images are never enlarged. contain fits the whole
+// image inside the box, cover fills the box and crops the overflow around
+// the centre. exact stretches the image to width x height as displayed,
+// which for EXIF-rotated images means height x width stored pixels.
+func resizeImage(img image.Image, width, height int, fit string, rotated bool) image.Image {
+	b := img.Bounds()
+	if fit == fitExact {
+		if rotated {
+			width, height = height, width
+		}
+		if b.Dx() == width && b.Dy() == height {
+			return img
+		}
+		return scaleImage(img, width, height)
+	}
+
+	if (b.Dx() > b.Dy()) != (width > height) && b.Dx() != b.Dy() && width != height {
+		width, height = height, width
+	}
+	if fit == fitContain {
+		w, h := fitDimensions(b.Dx(), b.Dy(), width, height)
+		if w == b.Dx() && h == b.Dy() {
+			return img
+		}
+		return scaleImage(img, w, h)
+	}
+
+	scale := math.Max(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	if scale > 1 {
+		scale = 1
+	}
+	w, h := int(math.Round(float64(b.Dx())*scale)), int(math.Round(float64(b.Dy())*scale))
+	if w < width {
+		width = w
+	}
+	if h < height {
+		height = h
+	}
+	if width == b.Dx() && height == b.Dy() {
+		return img
+	}
+	var scaled image.Image = img
+	if w != b.Dx() || h != b.Dy() {
+		scaled = scaleImage(img, w, h)
+	}
+	sb := scaled.Bounds()
+	x0, y0 := sb.Min.X+(w-width)/2, sb.Min.Y+(h-height)/2
+	crop := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(crop, crop.Bounds(), scaled, image.Pt(x0, y0), draw.Src)
+	return crop
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"image"
+	"io"
+	"testing"
+)
+
+func TestParseResize(t *testing.T) {
+	if w, h, err := parseResize("1920x1080"); err != nil || w != 1920 || h != 1080 {
+		t.Errorf("got %d, %d, %v", w, h, err)
+	}
+	for _, value := range []string{"", "1920", "1920x", "x1080", "0x100", "axb"} {
+		if _, _, err := parseResize(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+	if _, err := parseOptions([]string{"--resize", "800x600", "--fit", "stretch"}, io.Discard); err == nil {
+		t.Error("expected an error for an unknown --fit")
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	landscape := image.NewRGBA(image.Rect(0, 0, 4000, 3000))
+	portrait := image.NewRGBA(image.Rect(0, 0, 3000, 4000))
+	small := image.NewRGBA(image.Rect(0, 0, 640, 480))
+
+	tests := []struct {
+		name          string
+		img           image.Image
+		fit           string
+		rotated       bool
+		width, height int
+	}{
+		{"contain landscape", landscape, fitContain, false, 1440, 1080},
+		{"contain portrait turns the box", portrait, fitContain, false, 1080, 1440},
+		{"contain never enlarges", small, fitContain, false, 640, 480},
+		{"cover landscape crops height", landscape, fitCover, false, 1920, 1080},
+		{"cover portrait turns the box", portrait, fitCover, false, 1080, 1920},
+		{"cover never enlarges", small, fitCover, false, 640, 480},
+		{"exact stretches", portrait, fitExact, false, 1920, 1080},
+		{"exact follows EXIF rotation", landscape, fitExact, true, 1080, 1920},
+	}
+	for _, tt := range tests {
+		b := resizeImage(tt.img, 1920, 1080, tt.fit, tt.rotated).Bounds()
+		if b.Dx() != tt.width || b.Dy() != tt.height {
+			t.Errorf("%s: got %dx%d, want %dx%d", tt.name, b.Dx(), b.Dy(), tt.width, tt.height)
+		}
+	}
+}
+
+func TestIsRotated(t *testing.T) {
+	orientation := func(v uint16) []byte {
+		tag := testTag{id: 0x0112, typ: 3, count: 1, value: []byte{byte(v), byte(v >> 8), 0, 0}}
+		return buildTestExif([]testTag{tag}, nil, nil)
+	}
+	if isRotated(orientation(1)) || isRotated(orientation(3)) || isRotated(nil) {
+		t.Error("orientations 1 and 3 keep the stored width and height")
+	}
+	if !isRotated(orientation(6)) || !isRotated(orientation(8)) {
+		t.Error("orientations 6 and 8 turn the image by 90 degrees")
+	}
+}
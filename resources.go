@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Memory a run plans for. Each decode worker keeps about three images in
+// flight between the pipeline's stages; a 12 MP photo takes some 18MB
+// decoded, more while it is resized or converted to RGBA, plus its source
+// and JPEG buffers.
+const (
+	memoryPerWorker = 128 << 20
+	memoryReserve   = 64 << 20 // the runtime, goheif and everything but images
+	// lowMemoryLimit is the limit under which runs behave as with
+	// --low-memory, converting one file at a time.
+	lowMemoryLimit = 1 << 30
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted, and procCgroup
+// lists the cgroups of this process.
+const (
+	cgroupRoot = "/sys/fs/cgroup"
+	procCgroup = "/proc/self/cgroup"
+)
+
+// resourceLimits are the CPUs and memory a run may use, when they are less
+// than the host's: from --max-memory, the cgroup of a container or the
+// address space limit (ulimit -v). Zero means not limited.
+type resourceLimits struct {
+	cpus         int
+	memory       int64
+	memorySource string
+}
+
+// detectResourceLimits reads the limits of this process, with maxMemory,
+// the --max-memory, taking the place of any memory limit found.
+func detectResourceLimits(maxMemory int64) resourceLimits {
+	cpus, memory := readCgroupLimits(cgroupRoot, procCgroup)
+	limits := resourceLimits{cpus: cpus, memory: memory}
+	if memory > 0 {
+		limits.memorySource = "cgroup"
+	}
+	if as := addressSpaceLimit(); as > 0 && (limits.memory == 0 || as < limits.memory) {
+		limits.memory, limits.memorySource = as, "ulimit -v"
+	}
+	if maxMemory > 0 {
+		limits.memory, limits.memorySource = maxMemory, "--max-memory"
+	}
+	return limits
+}
+
+// workers is how many files are decoded and encoded at once within the
+// limits, on a host of hostCPUs: one per CPU, as long as there is memory
+// for their images, and at least one.
+func (l resourceLimits) workers(hostCPUs int) int {
+	n := hostCPUs
+	if l.cpus > 0 && l.cpus < n {
+		n = l.cpus
+	}
+	if l.memory > 0 {
+		if fit := int((l.memory - memoryReserve) / memoryPerWorker); fit < n {
+			n = fit
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (l resourceLimits) String() string {
+	var parts []string
+	if l.cpus > 0 {
+		parts = append(parts, fmt.Sprintf("%d CPUs", l.cpus))
+	}
+	if l.memory > 0 {
+		parts = append(parts, fmt.Sprintf("%s memory (%s)", humanReadableFileSize(l.memory), l.memorySource))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// resourceSummary describes the limits of opts and how the run fits them.
+func resourceSummary(opts options) string {
+	if opts.lowMemory {
+		return fmt.Sprintf("limits: %s; converting one file at a time", opts.limits)
+	}
+	return fmt.Sprintf("limits: %s; %d CPU workers", opts.limits, opts.workers)
+}
+
+// applyResourceLimits fits the Go runtime to the limits of opts: no more
+// threads running Go code than CPUs, and a garbage collector that works
+// harder as memory use nears the limit, rather than the process being
+// killed when it crosses it.
+func applyResourceLimits(opts options) {
+	if opts.limits.cpus > 0 && opts.limits.cpus < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(opts.limits.cpus)
+	}
+	if opts.limits.memory > 0 {
+		debug.SetMemoryLimit(opts.limits.memory / 10 * 9)
+	}
+	if opts.lowMemory {
+		poolBuffers = false
+	}
+}
+
+// readCgroupLimits reads the CPU quota, rounded up to whole CPUs, and the
+// memory limit of the cgroups procCgroup lists, under root. Version 2
+// limits are the tightest of the cgroup and its parents; version 1 ones
+// are read from the cgroup, or the root of its hierarchy as containers
+// usually see it.
+func readCgroupLimits(root, procCgroup string) (cpus int, memory int64) {
+	f, err := os.Open(procCgroup)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		controllers, cgroup := fields[1], path.Clean("/"+fields[2])
+		if controllers == "" {
+			// Version 2.
+			for dir := cgroup; ; dir = path.Dir(dir) {
+				base := filepath.Join(root, filepath.FromSlash(dir))
+				cpus = tighterCPUs(cpus, readCPUMax(filepath.Join(base, "cpu.max")))
+				memory = tighterMemory(memory, readLimit(filepath.Join(base, "memory.max")))
+				if dir == "/" {
+					break
+				}
+			}
+			continue
+		}
+		for _, controller := range strings.Split(controllers, ",") {
+			for _, base := range []string{filepath.Join(root, controllers, filepath.FromSlash(cgroup)), filepath.Join(root, controllers)} {
+				switch controller {
+				case "cpu":
+					quota := readLimit(filepath.Join(base, "cpu.cfs_quota_us"))
+					period := readLimit(filepath.Join(base, "cpu.cfs_period_us"))
+					if quota > 0 && period > 0 {
+						cpus = tighterCPUs(cpus, int(math.Ceil(float64(quota)/float64(period))))
+					}
+				case "memory":
+					memory = tighterMemory(memory, readLimit(filepath.Join(base, "memory.limit_in_bytes")))
+				}
+			}
+		}
+	}
+	return cpus, memory
+}
+
+// readCPUMax reads a cgroup v2 cpu.max, "QUOTA PERIOD" or "max PERIOD", as
+// whole CPUs.
+func readCPUMax(name string) int {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0
+	}
+	quota, err1 := strconv.ParseFloat(fields[0], 64)
+	period, err2 := strconv.ParseFloat(fields[1], 64)
+	if err1 != nil || err2 != nil || quota <= 0 || period <= 0 {
+		return 0
+	}
+	return int(math.Ceil(quota / period))
+}
+
+// readLimit reads a number from a cgroup file, 0 for "max", -1 and the
+// near-2^63 values version 1 uses for no limit.
+func readLimit(name string) int64 {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil || n <= 0 || n >= 1<<62 {
+		return 0
+	}
+	return n
+}
+
+func tighterCPUs(a, b int) int {
+	if b > 0 && (a == 0 || b < a) {
+		return b
+	}
+	return a
+}
+
+func tighterMemory(a, b int64) int64 {
+	if b > 0 && (a == 0 || b < a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCgroupFiles writes files, keyed by slash-separated path, under root.
+func writeCgroupFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadCgroupLimitsV2(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		"proc":                         "0::/docker/abc\n",
+		"cgroup/docker/abc/cpu.max":    "150000 100000\n",
+		"cgroup/docker/abc/memory.max": "max\n",
+		"cgroup/docker/memory.max":     "536870912\n",
+		"cgroup/memory.max":            "1073741824\n",
+	})
+	cpus, memory := readCgroupLimits(filepath.Join(root, "cgroup"), filepath.Join(root, "proc"))
+	if cpus != 2 || memory != 512<<20 {
+		t.Errorf("got %d CPUs, %d bytes; want 2 CPUs, 512MB", cpus, memory)
+	}
+}
+
+func TestReadCgroupLimitsV1(t *testing.T) {
+	root := t.TempDir()
+	writeCgroupFiles(t, root, map[string]string{
+		"proc":                                      "4:memory:/\n3:cpu,cpuacct:/\n1:name=systemd:/init.scope\n",
+		"cgroup/cpu,cpuacct/cpu.cfs_quota_us":       "-1\n",
+		"cgroup/cpu,cpuacct/cpu.cfs_period_us":      "100000\n",
+		"cgroup/memory/memory.limit_in_bytes":       "9223372036854771712\n",
+		"cgroup/name=systemd/memory.limit_in_bytes": "1",
+	})
+	if cpus, memory := readCgroupLimits(filepath.Join(root, "cgroup"), filepath.Join(root, "proc")); cpus != 0 || memory != 0 {
+		t.Errorf("unlimited: got %d CPUs, %d bytes", cpus, memory)
+	}
+
+	writeCgroupFiles(t, root, map[string]string{
+		"cgroup/cpu,cpuacct/cpu.cfs_quota_us": "200000\n",
+		"cgroup/memory/memory.limit_in_bytes": "268435456\n",
+	})
+	if cpus, memory := readCgroupLimits(filepath.Join(root, "cgroup"), filepath.Join(root, "proc")); cpus != 2 || memory != 256<<20 {
+		t.Errorf("limited: got %d CPUs, %d bytes", cpus, memory)
+	}
+
+	if cpus, memory := readCgroupLimits(root, filepath.Join(root, "missing")); cpus != 0 || memory != 0 {
+		t.Errorf("no cgroups: got %d CPUs, %d bytes", cpus, memory)
+	}
+}
+
+func TestResourceWorkers(t *testing.T) {
+	for _, tc := range []struct {
+		limits resourceLimits
+		want   int
+	}{
+		{resourceLimits{}, 8},
+		{resourceLimits{cpus: 2}, 2},
+		{resourceLimits{memory: 512 << 20}, 3},
+		{resourceLimits{cpus: 4, memory: 8 << 30}, 4},
+		{resourceLimits{memory: 64 << 20}, 1},
+	} {
+		if got := tc.limits.workers(8); got != tc.want {
+			t.Errorf("%+v: got %d workers, want %d", tc.limits, got, tc.want)
+		}
+	}
+}
+
+func TestMaxMemoryOption(t *testing.T) {
+	opts, err := parseOptions([]string{"--max-memory", "512MB"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.limits.memory != 512<<20 || opts.limits.memorySource != "--max-memory" || !opts.lowMemory {
+		t.Errorf("got %+v, low memory %t", opts.limits, opts.lowMemory)
+	}
+	opts, err = parseOptions([]string{"--max-memory", "16GB"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.lowMemory || opts.workers < 1 {
+		t.Errorf("16GB: %d workers, low memory %t", opts.workers, opts.lowMemory)
+	}
+	if _, err := parseOptions([]string{"--max-memory", "lots"}, io.Discard); err == nil {
+		t.Error("expected an invalid --max-memory to be rejected")
+	}
+}
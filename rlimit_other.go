@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// addressSpaceLimit is not known here.
+func addressSpaceLimit() int64 {
+	return 0
+}
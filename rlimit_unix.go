@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// addressSpaceLimit is the soft limit on the process's address space
+// (ulimit -v), or 0 when there is none.
+func addressSpaceLimit() int64 {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_AS, &lim); err != nil || lim.Cur == unix.RLIM_INFINITY || lim.Cur >= 1<<62 {
+		return 0
+	}
+	return int64(lim.Cur)
+}
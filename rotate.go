@@ -0,0 +1,99 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+)
+
+// tagOrientation is the EXIF Orientation tag.
+const tagOrientation = 0x0112
+
+// Values of --flip.
+const (
+	flipHorizontal = "h"
+	flipVertical   = "v"
+)
+
+func isValidRotation(degrees int) bool {
+	return degrees == 0 || degrees == 90 || degrees == 180 || degrees == 270
+}
+
+func isValidFlip(flip string) bool {
+	return flip == "" || flip == flipHorizontal || flip == flipVertical
+}
+
+// rotationOrientation is the EXIF orientation that turns an image clockwise
+// by degrees, and flipOrientation the one that mirrors it.
+func rotationOrientation(degrees int) int {
+	switch degrees {
+	case 90:
+		return 6
+	case 180:
+		return 3
+	case 270:
+		return 8
+	}
+	return 1
+}
+
+func flipOrientation(flip string) int {
+	switch flip {
+	case flipHorizontal:
+		return 2
+	case flipVertical:
+		return 4
+	}
+	return 1
+}
+
+// turnedSize is the displayed size of a width x height image with the given
+// EXIF orientation.
+func turnedSize(width, height, orientation int) (int, int) {
+	if orientation >= 5 {
+		return height, width
+	}
+	return width, height
+}
+
+// orientImage applies --rotate, then --flip, to the image as displayed: the
+// stored pixels are turned by their EXIF orientation first, and the result
+// is stored upright. Its EXIF orientation has to be reset to 1 with
+// resetOrientation.
+func orientImage(img image.Image, orientation, degrees int, flip string) *image.RGBA {
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok || b.Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+
+	// Each step is an EXIF orientation, so a pixel of the output is traced
+	// back through the flip, the rotation and the source's orientation.
+	rotation, mirror := rotationOrientation(degrees), flipOrientation(flip)
+	storedW, storedH := b.Dx(), b.Dy()
+	shownW, shownH := turnedSize(storedW, storedH, orientation)
+	rotatedW, rotatedH := turnedSize(shownW, shownH, rotation)
+
+	dst := image.NewRGBA(image.Rect(0, 0, rotatedW, rotatedH))
+	for y := 0; y < rotatedH; y++ {
+		row := dst.Pix[y*dst.Stride:]
+		for x := 0; x < rotatedW; x++ {
+			r := storedPoint(x, y, mirror, rotatedW, rotatedH)
+			d := storedPoint(r.X, r.Y, rotation, shownW, shownH)
+			s := storedPoint(d.X, d.Y, orientation, storedW, storedH)
+			copy(row[4*x:4*x+4], src.Pix[s.Y*src.Stride+4*s.X:])
+		}
+	}
+	return dst
+}
+
+// resetOrientation sets the EXIF Orientation tag, where the source has one,
+// to 1, for pixels orientImage has stored upright.
+func resetOrientation(rawExif []byte) []byte {
+	block, err := parseExifBlock(rawExif)
+	if err != nil || findEntry(block.ifd0, tagOrientation) == nil {
+		return rawExif
+	}
+	block.ifd0 = setEntry(block.ifd0, block.short(tagOrientation, 1))
+	return block.encode()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// numberedImage is a width x height image whose pixels hold their index in
+// the red channel.
+func numberedImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		img.Pix[4*i] = uint8(i)
+	}
+	return img
+}
+
+// pixelNumbers lists the red channel of img row by row.
+func pixelNumbers(img *image.RGBA) [][]uint8 {
+	var rows [][]uint8
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		var row []uint8
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			row = append(row, img.Pix[y*img.Stride+4*x])
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestOrientImage(t *testing.T) {
+	// Stored as
+	//   0 1 2
+	//   3 4 5
+	for _, tc := range []struct {
+		orientation, rotate int
+		flip                string
+		want                [][]uint8
+	}{
+		{1, 90, "", [][]uint8{{3, 0}, {4, 1}, {5, 2}}},
+		{1, 180, "", [][]uint8{{5, 4, 3}, {2, 1, 0}}},
+		{1, 270, "", [][]uint8{{2, 5}, {1, 4}, {0, 3}}},
+		{1, 0, "h", [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{1, 0, "v", [][]uint8{{3, 4, 5}, {0, 1, 2}}},
+		{1, 90, "h", [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+		// The source's own orientation is applied first.
+		{6, 270, "", [][]uint8{{0, 1, 2}, {3, 4, 5}}},
+		{3, 0, "v", [][]uint8{{2, 1, 0}, {5, 4, 3}}},
+		{6, 0, "h", [][]uint8{{0, 3}, {1, 4}, {2, 5}}},
+	} {
+		got := pixelNumbers(orientImage(numberedImage(3, 2), tc.orientation, tc.rotate, tc.flip))
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("orientation %d, --rotate %d --flip %q: got %v, want %v", tc.orientation, tc.rotate, tc.flip, got, tc.want)
+		}
+	}
+}
+
+func TestResetOrientation(t *testing.T) {
+	tag := testTag{id: tagOrientation, typ: 3, count: 1, value: []byte{6, 0, 0, 0}}
+	if got := exifOrientation(resetOrientation(buildTestExif([]testTag{tag}, nil, nil))); got != 1 {
+		t.Errorf("orientation %d after reset", got)
+	}
+	raw := buildTestExif([]testTag{asciiTag(0x010f, "Scanner")}, nil, nil)
+	if got := resetOrientation(raw); !reflect.DeepEqual(got, raw) {
+		t.Error("EXIF without an orientation was changed")
+	}
+}
+
+func TestConvertFileRotated(t *testing.T) {
+	opts, err := parseOptions([]string{"--rotate", "90", "--flip", "v"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegDir := t.TempDir()
+	output, err := convertFile("testdata/images", "goheif-camel.heic", jpegDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(jpegDir, output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil || config.Width != 1064 || config.Height != 1596 {
+		t.Errorf("got %dx%d (%v), want 1064x1596", config.Width, config.Height, err)
+	}
+
+	for _, args := range [][]string{{"--rotate", "45"}, {"--rotate", "-90"}, {"--flip", "x"}} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
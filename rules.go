@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// conversionRule overrides conversion settings for photos whose EXIF
+// matches. Make, Model and Lens are case-insensitive glob patterns (path.Match
+// syntax); empty fields match anything.
+type conversionRule struct {
+	Make          string  `json:"make"`
+	Model         string  `json:"model"`
+	Lens          string  `json:"lens"`
+	MinMegapixels float64 `json:"minMegapixels"`
+
+	Quality       int     `json:"quality"`
+	MaxMegapixels float64 `json:"maxMegapixels"`
+}
+
+// loadRules reads a --rules file: a JSON array of rules, tried in order.
+func loadRules(rulesPath string) ([]conversionRule, error) {
+	data, err := os.ReadFile(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	var rules []conversionRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	for i, rule := range rules {
+		for _, pattern := range []string{rule.Make, rule.Model, rule.Lens} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid pattern %q", i+1, pattern)
+			}
+		}
+		if rule.Quality != 0 && (rule.Quality < 1 || rule.Quality > 100) {
+			return nil, fmt.Errorf("rule %d: quality %d must be between 1 and 100", i+1, rule.Quality)
+		}
+		if rule.MaxMegapixels < 0 || rule.MinMegapixels < 0 {
+			return nil, fmt.Errorf("rule %d: megapixel limits must not be negative", i+1)
+		}
+	}
+	return rules, nil
+}
+
+func matchField(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return ok
+}
+
+func (r conversionRule) matches(cameraMake, model, lens string, megapixels float64) bool {
+	return matchField(r.Make, cameraMake) && matchField(r.Model, model) && matchField(r.Lens, lens) &&
+		megapixels >= r.MinMegapixels
+}
+
+// applyRules returns opts adjusted by the first rule matching the photo.
+func applyRules(opts options, rawExif []byte, width, height int) options {
+	x := decodeExif(rawExif)
+	cameraMake := exifStringField(x, exif.Make)
+	model := exifStringField(x, exif.Model)
+	lens := exifStringField(x, exif.LensModel)
+	megapixels := float64(width) * float64(height) / 1e6
+
+	for _, rule := range opts.rules {
+		if !rule.matches(cameraMake, model, lens, megapixels) {
+			continue
+		}
+		if rule.Quality != 0 {
+			opts.quality = rule.Quality
+		}
+		if rule.MaxMegapixels > 0 {
+			opts.maxPixels = int(rule.MaxMegapixels * 1e6)
+		}
+		break
+	}
+	return opts
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApplyRules(t *testing.T) {
+	rules, err := loadRules(writeRules(t, `[
+		{"model": "iPhone SE*", "quality": 80},
+		{"model": "iphone 15 pro*", "minMegapixels": 40, "quality": 90, "maxMegapixels": 24},
+		{"make": "Apple", "quality": 85}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	opts.rules = rules
+
+	exifFor := func(model string) []byte {
+		return buildTestExif([]testTag{asciiTag(0x010f, "Apple"), asciiTag(0x0110, model)}, nil, nil)
+	}
+	tests := []struct {
+		model         string
+		width, height int
+		quality       int
+		maxPixels     int
+	}{
+		{"iPhone SE (2nd generation)", 4032, 3024, 80, 0},
+		{"iPhone 15 Pro Max", 8064, 6048, 90, 24000000},
+		{"iPhone 15 Pro Max", 4032, 3024, 85, 0}, // 12MP shots fall through to the Apple rule
+	}
+	for _, tt := range tests {
+		got := applyRules(opts, exifFor(tt.model), tt.width, tt.height)
+		if got.quality != tt.quality || got.maxPixels != tt.maxPixels {
+			t.Errorf("%s %dx%d: quality %d, maxPixels %d; want %d, %d", tt.model, tt.width, tt.height, got.quality, got.maxPixels, tt.quality, tt.maxPixels)
+		}
+	}
+
+	if got := applyRules(opts, nil, 4032, 3024); got.quality != opts.quality {
+		t.Errorf("photo without EXIF matched a rule: quality %d", got.quality)
+	}
+}
+
+func TestLoadRulesRejectsInvalid(t *testing.T) {
+	for _, content := range []string{
+		`{"model": "not a list"}`,
+		`[{"model": "[", "quality": 80}]`,
+		`[{"quality": 101}]`,
+		`[{"maxMegapixels": -1}]`,
+	} {
+		if _, err := loadRules(writeRules(t, content)); err == nil {
+			t.Errorf("expected error for %s", content)
+		}
+	}
+}
+
+func TestConvertFileRuleResize(t *testing.T) {
+	opts := defaultOptions()
+	opts.embedThumbnail = false
+	opts.rules = []conversionRule{{MaxMegapixels: 0.5}}
+
+	jpegDir := t.TempDir()
+	output, err := convertFile(filepath.Join("testdata", "images"), "goheif-camel.heic", jpegDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(jpegDir, output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	config, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width*config.Height > 500000 || config.Width != 866 {
+		t.Fatalf("got %dx%d, want about 0.5MP with the 1596x1064 aspect ratio", config.Width, config.Height)
+	}
+}
+
+func TestLimitPixels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	if got := limitPixels(img, 200000); got != image.Image(img) {
+		t.Error("image within the limit should be returned as is")
+	}
+	if b := limitPixels(img, 30000).Bounds(); b.Dx() != 200 || b.Dy() != 150 {
+		t.Errorf("got %v, want 200x150", b)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Problems with a source file detected before it reaches the decoder.
+var (
+	errEmptySource     = errors.New("empty file")
+	errTruncatedSource = errors.New("truncated file")
+	errMalformedSource = errors.New("not a valid HEIF file")
+)
+
+// checkSource catches zero-byte, truncated and non-HEIF inputs by checking
+// the file size and walking the top-level box headers, so they can be
+// reported clearly instead of failing with a low-level decode error.
+func checkSource(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return errEmptySource
+	}
+	return checkBoxes(f, info.Size())
+}
+
+// checkBoxes verifies that the top-level ISO BMFF boxes start with ftyp,
+// include meta and fit exactly within size.
+func checkBoxes(r io.ReaderAt, size int64) error {
+	var header [16]byte
+	var offset int64
+	seenMeta := false
+	for offset < size {
+		if size-offset < 8 {
+			return fmt.Errorf("%w: incomplete box header at offset %d", errTruncatedSource, offset)
+		}
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch boxSize {
+		case 0: // the box extends to the end of the file
+			boxSize = size - offset
+		case 1:
+			if size-offset < 16 {
+				return fmt.Errorf("%w: incomplete %q box header", errTruncatedSource, boxType)
+			}
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+
+		if offset == 0 && boxType != "ftyp" {
+			return fmt.Errorf("%w: starts with %q instead of ftyp", errMalformedSource, boxType)
+		}
+		if offset == 0 {
+			if err := checkBrands(readBrands(r)); err != nil {
+				return err
+			}
+		}
+		if boxSize < headerSize {
+			return fmt.Errorf("%w: invalid %q box size %d", errMalformedSource, boxType, boxSize)
+		}
+		if boxSize > size-offset {
+			return fmt.Errorf("%w: %q box needs %d bytes, only %d remain", errTruncatedSource, boxType, boxSize, size-offset)
+		}
+		if boxType == "meta" {
+			seenMeta = true
+		}
+		offset += boxSize
+	}
+	if !seenMeta {
+		return fmt.Errorf("%w: no meta box", errTruncatedSource)
+	}
+	return nil
+}
+
+// sourceProblem returns a short description for source errors found by
+// checkSource, or "" for any other error.
+func sourceProblem(err error) string {
+	for _, known := range []error{errEmptySource, errTruncatedSource, errMalformedSource} {
+		if errors.Is(err, known) {
+			return strings.ToUpper(known.Error()[:1]) + known.Error()[1:]
+		}
+	}
+	return ""
+}
+
+// quarantine moves a problem source file into dir, renaming it if a file of
+// the same name is already there, and returns its new path.
+func quarantine(path, dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	target := filepath.Join(dir, base)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(base, ext), i, ext))
+	}
+	return target, os.Rename(path, target)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckSource(t *testing.T) {
+	fixture := filepath.Join("testdata", "images", "goheif-camel.heic")
+	if err := checkSource(fixture); err != nil {
+		t.Fatalf("valid fixture rejected: %v", err)
+	}
+	data, err := os.ReadFile(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty.heic", nil, errEmptySource},
+		{"cut.heic", data[:len(data)/2], errTruncatedSource},
+		{"header.heic", data[:30], errTruncatedSource},
+		{"photo.heic", []byte("\xff\xd8\xff\xe0 this is really a JPEG"), errMalformedSource},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkSource(path); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestProcessFileQuarantinesTruncatedSource(t *testing.T) {
+	dir := t.TempDir()
+	quarantineDir := filepath.Join(dir, "quarantine")
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.HEIC"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A file of the same name already quarantined must not be overwritten.
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(quarantineDir, "IMG_0001.HEIC"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "IMG_0001.HEIC"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	opts.quarantineDir = quarantineDir
+	result := processFile(manifestEntry{path: "IMG_0001.HEIC", info: info}, dir, t.TempDir(), opts)
+
+	if sourceProblem(result.err) != "Empty file" {
+		t.Fatalf("unexpected error %v", result.err)
+	}
+	if want := filepath.Join(quarantineDir, "IMG_0001-1.HEIC"); result.quarantined != want {
+		t.Fatalf("quarantined to %q, want %q", result.quarantined, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "IMG_0001.HEIC")); !os.IsNotExist(err) {
+		t.Fatal("source should have been moved")
+	}
+}
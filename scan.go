@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// scanWorkers is how many directories of a tree are listed at once. Listing
+// is bound by the filesystem rather than the CPU, so it does not follow the
+// number of decode workers.
+const scanWorkers = 8
+
+// scanProgressInterval is how often the scanning indicator is redrawn.
+const scanProgressInterval = 200 * time.Millisecond
+
+// scanTree lists the files under root, workers directories at a time, and
+// streams them as manifestEntry values named by their path relative to
+// root. Files come in no particular order. The directory exclude, the
+// active output directory when it is inside the tree, is left out so a run
+// never picks up its own outputs. found, when set, is called with the
+// running count of files found. err is set to the first directory that
+// could not be listed once entries is closed; the rest of the tree is still
+// scanned.
+func scanTree(root string, workers int, exclude string, found func(n int64)) *entryStream {
+	s := &entryStream{entries: make(chan os.DirEntry, streamBatchSize)}
+	var excluded os.FileInfo
+	if exclude != "" {
+		excluded, _ = os.Stat(exclude)
+	}
+	q := &dirQueue{dirs: []string{"."}, pending: 1}
+	q.cond = sync.NewCond(&q.mu)
+	var count int64
+	var errOnce sync.Once
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				rel, ok := q.pop()
+				if !ok {
+					return
+				}
+				entries, err := os.ReadDir(filepath.Join(root, rel))
+				if err != nil {
+					errOnce.Do(func() { s.err = err })
+				}
+				var subdirs []string
+				for _, entry := range entries {
+					name := filepath.Join(rel, entry.Name())
+					if entry.IsDir() {
+						if excluded != nil {
+							if info, err := os.Stat(filepath.Join(root, name)); err == nil && os.SameFile(info, excluded) {
+								continue
+							}
+						}
+						subdirs = append(subdirs, name)
+						continue
+					}
+					info, err := entry.Info()
+					if err != nil {
+						// Removed since the directory was listed.
+						continue
+					}
+					s.entries <- manifestEntry{path: name, info: info}
+					if n := atomic.AddInt64(&count, 1); found != nil {
+						found(n)
+					}
+				}
+				q.done(subdirs)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(s.entries)
+	}()
+	return s
+}
+
+// collectEntries reads all of stream, sorted by name.
+func collectEntries(stream *entryStream) ([]os.DirEntry, error) {
+	var entries []os.DirEntry
+	for entry := range stream.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, stream.err
+}
+
+// dirQueue holds the directories of a tree left to list. pending counts
+// those queued or being listed, so workers know the scan is over when it
+// drops to zero rather than when the queue is merely empty.
+type dirQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	dirs    []string
+	pending int
+}
+
+// pop waits for a directory to list, or reports false once there are none
+// left anywhere.
+func (q *dirQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.dirs) == 0 && q.pending > 0 {
+		q.cond.Wait()
+	}
+	if len(q.dirs) == 0 {
+		return "", false
+	}
+	// Depth first keeps the queue short on wide trees.
+	dir := q.dirs[len(q.dirs)-1]
+	q.dirs = q.dirs[:len(q.dirs)-1]
+	return dir, true
+}
+
+// done queues the subdirectories of a directory that has been listed.
+func (q *dirQueue) done(subdirs []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dirs = append(q.dirs, subdirs...)
+	q.pending += len(subdirs) - 1
+	q.cond.Broadcast()
+}
+
+// scanIndicator draws the "Scanning... N files found" line while a tree is
+// scanned, on a terminal only.
+type scanIndicator struct {
+	out   io.Writer
+	count int64
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startScanIndicator starts redrawing the indicator on out every
+// scanProgressInterval, or returns nil when out is not interactive.
+func startScanIndicator(out io.Writer, interactive bool) *scanIndicator {
+	if !interactive {
+		return nil
+	}
+	ind := &scanIndicator{out: out, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(ind.done)
+		ticker := time.NewTicker(scanProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ind.draw()
+			case <-ind.stop:
+				ind.draw()
+				fmt.Fprintln(ind.out)
+				return
+			}
+		}
+	}()
+	return ind
+}
+
+// found records the running count of files found. It is scanTree's found
+// callback, and does nothing on a nil indicator.
+func (ind *scanIndicator) found(n int64) {
+	if ind != nil {
+		atomic.StoreInt64(&ind.count, n)
+	}
+}
+
+func (ind *scanIndicator) draw() {
+	fmt.Fprintf(ind.out, "\rScanning... %d files found\x1b[K", atomic.LoadInt64(&ind.count))
+}
+
+// finish draws the final count and ends the line.
+func (ind *scanIndicator) finish() {
+	if ind == nil {
+		return
+	}
+	close(ind.stop)
+	<-ind.done
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestScanTree(t *testing.T) {
+	root := t.TempDir()
+	var want []string
+	for _, dir := range []string{"0", "1", filepath.Join("1", "deep", "er"), "F", "empty"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if dir == "empty" {
+			continue
+		}
+		for i := 0; i < 3; i++ {
+			name := filepath.Join(dir, fmt.Sprintf("IMG_%d.heic", i))
+			if err := os.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, name)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "top.heic"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "top.heic")
+	sort.Strings(want)
+
+	var last int64
+	stream := scanTree(root, 3, "", func(n int64) {
+		for {
+			prev := atomic.LoadInt64(&last)
+			if n <= prev || atomic.CompareAndSwapInt64(&last, prev, n) {
+				return
+			}
+		}
+	})
+	var got []string
+	for entry := range stream.entries {
+		if entry.IsDir() {
+			t.Errorf("%s is a directory", entry.Name())
+		}
+		got = append(got, entry.Name())
+	}
+	sort.Strings(got)
+	if stream.err != nil || strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("scanned %q (%v), want %q", got, stream.err, want)
+	}
+	if last != int64(len(want)) {
+		t.Errorf("found counted %d files, want %d", last, len(want))
+	}
+
+	stream = scanTree(filepath.Join(root, "missing"), scanWorkers, "", nil)
+	for range stream.entries {
+	}
+	if !os.IsNotExist(stream.err) {
+		t.Errorf("err = %v, want not exist", stream.err)
+	}
+}
+
+func TestScanIndicator(t *testing.T) {
+	if ind := startScanIndicator(&bytes.Buffer{}, false); ind != nil {
+		t.Error("indicator without a terminal")
+	}
+	var out bytes.Buffer
+	ind := startScanIndicator(&out, true)
+	ind.found(42)
+	ind.finish()
+	if !strings.HasSuffix(out.String(), "Scanning... 42 files found\x1b[K\n") {
+		t.Errorf("unexpected indicator %q", out.String())
+	}
+}
+
+func TestScanTreeExcludesOutput(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{filepath.Join("A", "IMG_0001.heic"), filepath.Join("out", "IMG_0001.heic"), filepath.Join("out", "sub", "x.heic")} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream := scanTree(root, scanWorkers, filepath.Join(root, "out"), nil)
+	var got []string
+	for entry := range stream.entries {
+		got = append(got, entry.Name())
+	}
+	if len(got) != 1 || got[0] != filepath.Join("A", "IMG_0001.heic") {
+		t.Errorf("scanned %q, want only A/IMG_0001.heic", got)
+	}
+}
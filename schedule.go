@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeWindow is a daily period between two times of day, as offsets from
+// midnight. A window whose end is before its start runs past midnight.
+type timeWindow struct {
+	start, end time.Duration
+}
+
+// conversionSchedule is the set of windows --schedule allows conversions in.
+// A nil schedule allows them at any time.
+type conversionSchedule []timeWindow
+
+// parseSchedule parses comma-separated HH:MM-HH:MM windows in local time,
+// e.g. "01:00-06:00" or "22:00-02:00,12:00-13:00".
+func parseSchedule(value string) (conversionSchedule, error) {
+	var schedule conversionSchedule
+	for _, part := range splitList(value) {
+		from, to, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("window %q is not HH:MM-HH:MM", part)
+		}
+		start, err := parseTimeOfDay(from)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(to)
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("window %q is empty", part)
+		}
+		schedule = append(schedule, timeWindow{start, end})
+	}
+	if len(schedule) == 0 {
+		return nil, fmt.Errorf("no windows given")
+	}
+	return schedule, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+func (w timeWindow) contains(t time.Time) bool {
+	d := sinceMidnight(t)
+	if w.start < w.end {
+		return d >= w.start && d < w.end
+	}
+	return d >= w.start || d < w.end
+}
+
+// allows reports whether conversions may run at t.
+func (s conversionSchedule) allows(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	for _, w := range s {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextStart returns when the next window after t opens.
+func (s conversionSchedule) nextStart(t time.Time) time.Time {
+	var next time.Time
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, w := range s {
+		start := midnight.Add(w.start)
+		if !start.After(t) {
+			start = start.AddDate(0, 0, 1)
+		}
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
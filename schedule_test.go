@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	schedule, err := parseSchedule("01:00-06:00, 22:30-00:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 5, 1, hour, minute, 0, 0, time.Local)
+	}
+	tests := map[time.Time]bool{
+		at(0, 59):  false,
+		at(1, 0):   true,
+		at(5, 59):  true,
+		at(6, 0):   false,
+		at(20, 0):  false,
+		at(23, 15): true,
+		at(0, 15):  true,
+		at(0, 30):  false,
+	}
+	for when, want := range tests {
+		if got := schedule.allows(when); got != want {
+			t.Errorf("%s: got %v, want %v", when.Format("15:04"), got, want)
+		}
+	}
+
+	if next := schedule.nextStart(at(7, 0)); !next.Equal(at(22, 30)) {
+		t.Errorf("after 07:00 the next window opens at %s", next)
+	}
+	if next := schedule.nextStart(at(23, 0)); !next.Equal(at(1, 0).AddDate(0, 0, 1)) {
+		t.Errorf("after 23:00 the next window opens at %s", next)
+	}
+
+	var always conversionSchedule
+	if !always.allows(at(12, 0)) {
+		t.Error("no schedule should allow any time")
+	}
+	for _, bad := range []string{"", "01:00", "25:00-06:00", "06:00-06:00"} {
+		if _, err := parseSchedule(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// screenSizes are the portrait pixel sizes of iPhone and iPad screens.
+var screenSizes = map[[2]int]bool{
+	// iPhone
+	{640, 960}: true, {640, 1136}: true, {750, 1334}: true, {1242, 2208}: true,
+	{828, 1792}: true, {1125, 2436}: true, {1242, 2688}: true, {1080, 2340}: true,
+	{1170, 2532}: true, {1284, 2778}: true, {1179, 2556}: true, {1290, 2796}: true,
+	{1206, 2622}: true, {1320, 2868}: true,
+	// iPad
+	{768, 1024}: true, {1536, 2048}: true, {1620, 2160}: true, {1668, 2224}: true,
+	{1640, 2360}: true, {1668, 2388}: true, {1488, 2266}: true, {2048, 2732}: true,
+	{1668, 2420}: true, {2064, 2752}: true,
+}
+
+// isScreenshot reports whether an image looks like an Apple screenshot:
+// either its EXIF user comment says so, or it has no camera make/model and
+// exactly the size of an iPhone or iPad screen.
+func isScreenshot(rawExif []byte, width, height int) bool {
+	x := decodeExif(rawExif)
+	if x != nil {
+		if tag, err := x.Get(exif.UserComment); err == nil && bytes.Contains(tag.Val, []byte("Screenshot")) {
+			return true
+		}
+		if exifStringField(x, exif.Make) != "" || exifStringField(x, exif.Model) != "" {
+			return false
+		}
+	}
+	if width > height {
+		width, height = height, width
+	}
+	return screenSizes[[2]int{width, height}]
+}
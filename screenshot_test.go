@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsScreenshot(t *testing.T) {
+	comment := []byte("ASCII\x00\x00\x00Screenshot")
+	screenshotExif := buildTestExif(
+		[]testTag{asciiTag(0x0131, "17.1")},
+		[]testTag{{id: 0x9286, typ: 7, count: uint32(len(comment)), value: comment}},
+		nil,
+	)
+	cameraExif := buildTestExif([]testTag{asciiTag(0x010f, "Apple"), asciiTag(0x0110, "iPhone 15 Pro")}, nil, nil)
+	softwareOnly := buildTestExif([]testTag{asciiTag(0x0131, "17.1")}, nil, nil)
+
+	tests := []struct {
+		name          string
+		raw           []byte
+		width, height int
+		want          bool
+	}{
+		{"user comment", screenshotExif, 4032, 3024, true},
+		{"screen size without camera", softwareOnly, 1179, 2556, true},
+		{"landscape screen size", softwareOnly, 2556, 1179, true},
+		{"screen size without EXIF", nil, 1170, 2532, true},
+		{"camera photo at a screen size", cameraExif, 1179, 2556, false},
+		{"camera photo", cameraExif, 4032, 3024, false},
+		{"other size without camera", softwareOnly, 4000, 3000, false},
+	}
+	for _, tt := range tests {
+		if got := isScreenshot(tt.raw, tt.width, tt.height); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterScreenshots(t *testing.T) {
+	// The camel fixture is a camera-sized image without EXIF, so it is not a
+	// screenshot. Files whose headers cannot be read are kept so their
+	// conversion reports the problem.
+	dir := filepath.Join("testdata", "images")
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.onlyScreenshots = true
+	for _, file := range filterSources(dir, files, opts) {
+		if file.Name() == "goheif-camel.heic" {
+			t.Errorf("%s kept by --only-screenshots", file.Name())
+		}
+	}
+
+	opts = defaultOptions()
+	opts.skipScreenshots = true
+	if kept := filterSources(dir, files, opts); len(kept) != len(files) {
+		t.Errorf("--skip-screenshots dropped %d files", len(files)-len(kept))
+	}
+}
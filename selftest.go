@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// selftestDir holds the fixtures `heictojpeg selftest` converts when no
+// folder is given: the test images of a source checkout. Their golden JPEGs
+// are in its goldenFolder, named after them.
+const (
+	selftestDir  = "testdata/images"
+	goldenFolder = "golden"
+)
+
+// An output matches its golden JPEG when the SSIM of their luma is at least
+// --min-ssim and their colours differ by at most goldenMaxColorDiff levels
+// per channel on average, which catches chroma going wrong that SSIM over
+// luma does not see. Both leave room for backends that round differently.
+const (
+	defaultGoldenSSIM  = 0.98
+	goldenMaxColorDiff = 3.0
+)
+
+// Outcomes of a fixture in `heictojpeg selftest`.
+const (
+	goldenOK      = "ok"
+	goldenFail    = "fail"
+	goldenMissing = "no-golden" // not compared, there being nothing to compare with
+	goldenUpdated = "updated"   // --update wrote its golden JPEG
+)
+
+// goldenResult is one line of `heictojpeg selftest`.
+type goldenResult struct {
+	Fixture   string  `json:"fixture"`
+	Status    string  `json:"status"`
+	SSIM      float64 `json:"ssim,omitempty"`
+	ColorDiff float64 `json:"color_diff,omitempty"`
+	Detail    string  `json:"detail,omitempty"`
+}
+
+// goldenPath is where the golden JPEG of fixture in dir is kept.
+func goldenPath(dir, fixture string) string {
+	return filepath.Join(dir, goldenFolder, strings.TrimSuffix(fixture, filepath.Ext(fixture))+".jpg")
+}
+
+// runGoldenTests converts every HEIC fixture in dir with the default
+// settings and compares the JPEG with its golden one, or with update writes
+// it as the new golden JPEG instead. Fixtures without a golden JPEG are
+// reported but not compared.
+func runGoldenTests(dir string, minSSIM float64, update bool) ([]goldenResult, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isHEICFile(entry.Name()) {
+			fixtures = append(fixtures, entry.Name())
+		}
+	}
+	sort.Strings(fixtures)
+
+	var results []goldenResult
+	for _, fixture := range fixtures {
+		result := goldenResult{Fixture: fixture}
+		golden, err := os.ReadFile(goldenPath(dir, fixture))
+		if err != nil && !(update && os.IsNotExist(err)) {
+			result.Status, result.Detail = goldenMissing, err.Error()
+			if os.IsNotExist(err) {
+				result.Detail = "no golden JPEG; create it with --update"
+			}
+			results = append(results, result)
+			continue
+		}
+		output, err := convertFixture(filepath.Join(dir, fixture))
+		switch {
+		case err != nil:
+			result.Status, result.Detail = goldenFail, fmt.Sprintf("converting failed: %v", err)
+		case update:
+			if err := writeGolden(goldenPath(dir, fixture), output); err != nil {
+				return results, err
+			}
+			result.Status, result.Detail = goldenUpdated, "golden JPEG written"
+		default:
+			result.SSIM, result.ColorDiff, err = compareGolden(output, golden, minSSIM)
+			result.Status = goldenOK
+			if err != nil {
+				result.Status, result.Detail = goldenFail, err.Error()
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// convertFixture converts the fixture at path as a plain run would.
+func convertFixture(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out bytes.Buffer
+	if err := convertStream(f, &out, defaultOptions()); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func writeGolden(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// compareGolden decodes the JPEGs output and golden and returns the SSIM of
+// their luma and their mean colour difference, with an error when they are
+// of different sizes or further apart than minSSIM and goldenMaxColorDiff
+// allow.
+func compareGolden(output, golden []byte, minSSIM float64) (float64, float64, error) {
+	got, err := jpeg.Decode(bytes.NewReader(output))
+	if err != nil {
+		return 0, 0, fmt.Errorf("output does not decode: %v", err)
+	}
+	want, err := jpeg.Decode(bytes.NewReader(golden))
+	if err != nil {
+		return 0, 0, fmt.Errorf("golden JPEG does not decode: %v", err)
+	}
+	if g, w := got.Bounds(), want.Bounds(); g.Dx() != w.Dx() || g.Dy() != w.Dy() {
+		return 0, 0, fmt.Errorf("output is %dx%d, golden JPEG %dx%d", g.Dx(), g.Dy(), w.Dx(), w.Dy())
+	}
+	gotLuma, width, height := lumaPlane(got)
+	wantLuma, _, _ := lumaPlane(want)
+	similarity := ssim(gotLuma, wantLuma, width, height)
+	diff := colorDifference(got, want)
+	if similarity < minSSIM {
+		return similarity, diff, fmt.Errorf("SSIM %.4f below %.4f", similarity, minSSIM)
+	}
+	if diff > goldenMaxColorDiff {
+		return similarity, diff, fmt.Errorf("colours differ by %.1f levels, more than %.1f", diff, goldenMaxColorDiff)
+	}
+	return similarity, diff, nil
+}
+
+// colorDifference is the mean absolute difference of the red, green and
+// blue levels (0-255) of two images of the same size.
+func colorDifference(a, b image.Image) float64 {
+	ab, bb := a.Bounds(), b.Bounds()
+	var total float64
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			r1, g1, b1, _ := a.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			r2, g2, b2, _ := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			total += math.Abs(float64(r1>>8)-float64(r2>>8)) + math.Abs(float64(g1>>8)-float64(g2>>8)) + math.Abs(float64(b1>>8)-float64(b2>>8))
+		}
+	}
+	if n := ab.Dx() * ab.Dy(); n > 0 {
+		return total / float64(3*n)
+	}
+	return 0
+}
+
+// runSelftest implements `heictojpeg selftest [DIR]`, which converts the
+// fixtures in DIR (selftestDir by default) and compares them with their
+// golden JPEGs, to catch regressions when the decoder or encoder changes.
+// It returns an error when any fixture failed, or none was compared.
+func runSelftest(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fs.SetOutput(out)
+	update := fs.Bool("update", false, "write the outputs as the new golden JPEGs instead of comparing them")
+	minSSIM := fs.Float64("min-ssim", defaultGoldenSSIM, "lowest SSIM of an output against its golden JPEG that passes")
+	asJSON := fs.Bool("json", false, "print the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("selftest takes one fixture folder")
+	}
+	if *minSSIM <= 0 || *minSSIM > 1 {
+		return fmt.Errorf("--min-ssim must be above 0 and at most 1")
+	}
+	dir := selftestDir
+	if fs.NArg() == 1 {
+		dir = fs.Arg(0)
+	}
+
+	results, err := runGoldenTests(dir, *minSSIM, *update)
+	if err != nil {
+		return err
+	}
+	failed, compared := 0, 0
+	for _, r := range results {
+		switch r.Status {
+		case goldenFail:
+			failed++
+			compared++
+		case goldenOK, goldenUpdated:
+			compared++
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			status := "ok  "
+			switch r.Status {
+			case goldenFail:
+				status = "FAIL"
+			case goldenMissing:
+				status = "skip"
+			}
+			detail := r.Detail
+			if r.Status == goldenOK {
+				detail = fmt.Sprintf("SSIM %.4f, colour difference %.2f", r.SSIM, r.ColorDiff)
+			}
+			fmt.Fprintf(out, "%s %s: %s\n", status, r.Fixture, detail)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fixtures failed", failed, compared)
+	}
+	if compared == 0 {
+		return fmt.Errorf("no fixture in %s has a golden JPEG", dir)
+	}
+	return nil
+}
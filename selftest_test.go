@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGoldenImages converts the fixtures in testdata/images and compares
+// them with their golden JPEGs. After a deliberate change to the output,
+// regenerate them with `heictojpeg selftest --update`.
+func TestGoldenImages(t *testing.T) {
+	results, err := runGoldenTests(selftestDir, defaultGoldenSSIM, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compared := 0
+	for _, r := range results {
+		switch r.Status {
+		case goldenFail:
+			t.Errorf("%s: %s", r.Fixture, r.Detail)
+		case goldenOK:
+			compared++
+		}
+	}
+	if compared < 2 {
+		t.Errorf("only %d fixtures compared: %+v", compared, results)
+	}
+}
+
+func TestSelftestUpdate(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join(selftestDir, "canon-layout.hif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "canon-layout.hif"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := runSelftest([]string{dir}, &out); err == nil || !strings.Contains(out.String(), "skip canon-layout.hif") {
+		t.Errorf("no golden JPEG: got %v\n%s", err, out.String())
+	}
+	if err := runSelftest([]string{"--update", dir}, &out); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := runSelftest([]string{dir}, &out); err != nil || !strings.Contains(out.String(), "ok   canon-layout.hif: SSIM 1.0000") {
+		t.Errorf("got %v\n%s", err, out.String())
+	}
+
+	// A golden JPEG of another image fails the fixture.
+	var golden bytes.Buffer
+	if err := jpeg.Encode(&golden, image.NewGray(image.Rect(0, 0, 320, 240)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(goldenPath(dir, "canon-layout.hif"), golden.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := runSelftest([]string{dir}, &out); err == nil || !strings.Contains(out.String(), "FAIL canon-layout.hif") {
+		t.Errorf("changed golden JPEG: got %v\n%s", err, out.String())
+	}
+}
+
+func TestCompareGolden(t *testing.T) {
+	encode := func(shift uint8) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+		for y := 0; y < 48; y++ {
+			for x := 0; x < 64; x++ {
+				img.SetRGBA(x, y, color.RGBA{uint8(4 * x), uint8(5 * y), 128 + shift, 255})
+			}
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	if similarity, diff, err := compareGolden(encode(0), encode(0), defaultGoldenSSIM); err != nil || similarity < 0.999 || diff > 0 {
+		t.Errorf("identical images: SSIM %v, difference %v, %v", similarity, diff, err)
+	}
+	// Blue moved by 40 levels leaves the luma much as it was.
+	if _, _, err := compareGolden(encode(40), encode(0), defaultGoldenSSIM); err == nil || !strings.Contains(err.Error(), "colours differ") {
+		t.Errorf("shifted blue: %v", err)
+	}
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultListenAddress is where `heictojpeg serve` listens and `heictojpeg
+// queue` connects unless --listen says otherwise. The API has no
+// authentication, so it only listens on the loopback interface by default.
+const defaultListenAddress = "127.0.0.1:8642"
+
+// maxUploadSize bounds the zip archives posted to /jobs.
+const maxUploadSize = 4 << 30
+
+// uploadLimits bounds what is extracted from an uploaded archive. HEIC files
+// hardly compress, so their uncompressed size is close to the upload's.
+var uploadLimits = extractLimits{entries: 100000, entrySize: 1 << 30, totalSize: 2 * maxUploadSize}
+
+// jobRequest is the body of POST /jobs.
+type jobRequest struct {
+	Paths    []string `json:"paths"`
+	Priority int      `json:"priority"`
+}
+
+// queueState is the reply to POST /pause and POST /resume. Changed is false
+// when the queue was already paused or running.
+type queueState struct {
+	Paused  bool `json:"paused"`
+	Changed bool `json:"changed"`
+}
+
+// runServeCommand implements `heictojpeg serve [flags]`: a daemon that
+// converts the files submitted to its control API, with the conversion flags
+// given here, into --output-dir (default: a jpegs folder in the working
+// directory). It runs until interrupted.
+func runServeCommand(args []string, output io.Writer) error {
+	opts, err := parseOptions(args, output)
+	if err != nil {
+		return err
+	}
+	if opts.watch || opts.dryRun || opts.filesFrom != "" || opts.outputFormat == formatPDF || opts.outputZip != "" || opts.outputTar != "" || opts.bundle != "" {
+		return fmt.Errorf("serve cannot be combined with --watch, --dry-run, --files-from, --output-format pdf, --output-zip, --output-tar or --bundle")
+	}
+	jpegDir, err := resolveOutputDir("", opts)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Listening on %s, converting into %s\n", listener.Addr(), jpegDir)
+
+	// Files of one job that share a name, coming from different folders, get
+	// names of their own as with --name-template-file.
+	if opts.nameClaims == nil {
+		opts.nameClaims = newNameClaims()
+	}
+	queue := newJobQueue()
+	applyResourceLimits(opts)
+	workerCount := opts.workers
+	if opts.lowMemory {
+		workerCount = 1
+	}
+	var workers sync.WaitGroup
+	var logMu sync.Mutex
+	for i := 0; i < workerCount; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for {
+				t, ok := queue.next()
+				if !ok {
+					return
+				}
+				ev := convertTask(t, jpegDir, opts)
+				queue.finish(t, ev)
+				logMu.Lock()
+				if ev.Err != nil {
+					fmt.Fprintf(output, "Job %d: %s > Failed > %v\n", t.job, t.path, ev.Err)
+				} else {
+					fmt.Fprintf(output, "Job %d: %s > Converted > %s\n", t.job, t.path, ev.Output)
+				}
+				logMu.Unlock()
+			}
+		}()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Handler: newControlHandler(queue, jpegDir, opts.listen), ReadHeaderTimeout: 10 * time.Second}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = server.Shutdown(shutdown)
+		cancel()
+	}
+	// Files already being converted finish; queued ones are dropped.
+	queue.close()
+	workers.Wait()
+	queue.removeUploads()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// convertTask converts the file of a task into the folder of its job in
+// jpegDir. Every job gets a folder, so that jobs never overwrite each
+// other's outputs and a result archive holds only its own job's.
+func convertTask(t task, jpegDir string, opts options) pathEvent {
+	folder := jobFolder(t.job)
+	ev := convertPath(t.path, filepath.Join(jpegDir, folder), opts)
+	if ev.Output != "" {
+		ev.Output = filepath.Join(folder, ev.Output)
+	}
+	return ev
+}
+
+// newControlHandler serves the control API, for outputs in jpegDir:
+//
+//	GET    /jobs                 list jobs, unfinished ones in the order they will run
+//	POST   /jobs                 submit a jobRequest, or upload a zip archive
+//	GET    /jobs/{id}            a job with the progress of each file
+//	GET    /jobs/{id}/result.zip the converted files of a finished job
+//	DELETE /jobs/{id}            cancel a job
+//	POST   /pause                stop starting files; those in progress finish
+//	POST   /resume               start files again
+//
+// Requests for other hosts than allowedHost accepts for listen are refused.
+func newControlHandler(queue *jobQueue, jpegDir, listen string) http.Handler {
+	mux := http.NewServeMux()
+	for path, paused := range map[string]bool{"/pause": true, "/resume": false} {
+		paused := paused
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", "POST")
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if mediaType(r) != "application/json" {
+				http.Error(w, "pause and resume must be posted as application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+			writeJSON(w, http.StatusOK, queueState{Paused: paused, Changed: queue.setPaused(paused)})
+		})
+	}
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, queue.list())
+		case http.MethodPost:
+			// Only content types a browser cannot send from another site
+			// without asking first, so that a page visited on the server's
+			// machine cannot queue jobs with a form post.
+			switch mediaType(r) {
+			case "application/zip":
+				submitArchive(w, r, queue)
+				return
+			case "application/json":
+			default:
+				http.Error(w, "jobs must be posted as application/json or application/zip", http.StatusUnsupportedMediaType)
+				return
+			}
+			var req jobRequest
+			if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid job: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, path := range req.Paths {
+				if !filepath.IsAbs(path) {
+					http.Error(w, fmt.Sprintf("path %q is not absolute", path), http.StatusBadRequest)
+					return
+				}
+			}
+			paths, err := expandDirectories(req.Paths)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, http.StatusCreated, queue.submit(paths, req.Priority))
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		zipped := strings.HasSuffix(rest, "/result.zip")
+		rest = strings.TrimSuffix(rest, "/result.zip")
+		id, err := strconv.Atoi(rest)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case zipped && r.Method == http.MethodGet:
+			writeResultZip(w, queue, id, jpegDir)
+		case zipped:
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		case r.Method == http.MethodGet:
+			detail, err := queue.detail(id)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, detail)
+		case r.Method == http.MethodDelete:
+			info, err := queue.cancel(id)
+			switch {
+			case errors.Is(err, errUnknownJob):
+				http.Error(w, err.Error(), http.StatusNotFound)
+			case errors.Is(err, errJobFinished):
+				http.Error(w, err.Error(), http.StatusConflict)
+			default:
+				writeJSON(w, http.StatusOK, info)
+			}
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowedHost(r.Host, listen) {
+			http.Error(w, fmt.Sprintf("host %q not allowed", r.Host), http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// allowedHost reports whether the control API answers requests for host, the
+// Host header: an IP address, localhost, or the host name of the listen
+// address. Other names are refused, so that a web page whose domain is made
+// to resolve to this machine (DNS rebinding) cannot reach the API as its own
+// origin.
+func allowedHost(host, listen string) bool {
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	name = strings.TrimSuffix(strings.Trim(name, "[]"), ".")
+	if net.ParseIP(name) != nil || strings.EqualFold(name, "localhost") {
+		return true
+	}
+	listenName, _, err := net.SplitHostPort(listen)
+	return err == nil && listenName != "" && strings.EqualFold(name, listenName)
+}
+
+// mediaType is the media type of a request's body, without parameters.
+func mediaType(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType
+}
+
+// expandDirectories replaces the directories among paths by the HEIC files
+// directly in them, in name order. Other paths are kept, so files that do
+// not exist fail on their own when converted.
+func expandDirectories(paths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err != nil || !info.IsDir() {
+			expanded = append(expanded, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && isHEICFile(entry.Name()) {
+				expanded = append(expanded, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// submitArchive queues the HEIC files of a zip archive posted to /jobs, at
+// the priority given by the priority query parameter. The archive is
+// extracted into a temporary directory that lives as long as the job.
+func submitArchive(w http.ResponseWriter, r *http.Request, queue *jobQueue) {
+	priority := priorityBulk
+	if value := r.URL.Query().Get("priority"); value != "" {
+		var err error
+		if priority, err = strconv.Atoi(value); err != nil {
+			http.Error(w, fmt.Sprintf("invalid priority %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "heictojpeg-upload-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	paths, err := receiveArchive(r.Body, dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		http.Error(w, "invalid archive: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusCreated, queue.submitUpload(paths, priority, dir))
+}
+
+// receiveArchive saves an uploaded zip archive next to dir, as zip archives
+// cannot be read as a stream, and extracts its HEIC files into dir.
+func receiveArchive(body io.Reader, dir string) ([]string, error) {
+	f, err := os.CreateTemp(filepath.Dir(dir), "heictojpeg-upload-*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, io.LimitReader(body, maxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > maxUploadSize {
+		return nil, fmt.Errorf("larger than %d bytes", int64(maxUploadSize))
+	}
+	return extractHEICFiles(f, size, dir, uploadLimits)
+}
+
+// writeResultZip streams the converted files of a finished job as a zip
+// archive, named by their paths in the output directory.
+func writeResultZip(w http.ResponseWriter, queue *jobQueue, id int, jpegDir string) {
+	detail, err := queue.detail(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if detail.active() {
+		http.Error(w, fmt.Sprintf("job %d is %s", id, detail.Status), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", jobFolder(id)+".zip"))
+	zw := zip.NewWriter(w)
+	for _, f := range detail.Items {
+		if f.Output == "" {
+			continue
+		}
+		// The status is already sent, so a missing output ends the archive
+		// early, which clients see as a truncated download.
+		path := filepath.Join(jpegDir, f.Output)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		header := &zip.FileHeader{Name: filepath.ToSlash(f.Output), Method: zip.Store}
+		if info, err := os.Stat(path); err == nil {
+			header.SetModTime(info.ModTime())
+		}
+		if err := addZipEntry(zw, header, data); err != nil {
+			return
+		}
+	}
+	zw.Close()
+}
+
+// jobFolder names the output folder of a job, and its result archive.
+func jobFolder(id int) string {
+	return fmt.Sprintf("job-%d", id)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// runQueueCommand implements `heictojpeg queue list|add|cancel|pause|resume`,
+// a client of the serve control API:
+//
+//	heictojpeg queue list
+//	heictojpeg queue add [--priority N | --interactive] FILE...
+//	heictojpeg queue cancel ID
+//	heictojpeg queue pause
+//	heictojpeg queue resume
+func runQueueCommand(args []string, output io.Writer) error {
+	usage := fmt.Errorf("usage: heictojpeg queue [--listen ADDRESS] list | add [--priority N | --interactive] FILE... | cancel ID | pause | resume")
+	fs := flag.NewFlagSet("heictojpeg queue", flag.ContinueOnError)
+	fs.SetOutput(output)
+	address := fs.String("listen", envOr(envPrefix+"LISTEN", defaultListenAddress), "address of the serve control API")
+	priority := fs.Int("priority", priorityBulk, "priority of added files; higher runs first")
+	interactive := fs.Bool("interactive", false, fmt.Sprintf("add at interactive priority (%d), ahead of bulk jobs", priorityInteractive))
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usage
+	}
+	command, rest := fs.Arg(0), fs.Args()[1:]
+	// Flags may also follow the command.
+	if err := fs.Parse(rest); err != nil {
+		return err
+	}
+	rest = fs.Args()
+	client := &queueClient{base: "http://" + *address, http: &http.Client{Timeout: 30 * time.Second}}
+
+	switch command {
+	case "list":
+		jobs, err := client.list()
+		if err != nil {
+			return err
+		}
+		writeJobs(output, jobs)
+		return nil
+	case "add":
+		if len(rest) == 0 {
+			return usage
+		}
+		if *interactive {
+			*priority = priorityInteractive
+		}
+		paths := make([]string, len(rest))
+		for i, path := range rest {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			paths[i] = abs
+		}
+		info, err := client.submit(jobRequest{Paths: paths, Priority: *priority})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "Queued job %d: %d files at priority %d\n", info.ID, info.Files, info.Priority)
+		return nil
+	case "cancel":
+		if len(rest) != 1 {
+			return usage
+		}
+		id, err := strconv.Atoi(rest[0])
+		if err != nil {
+			return fmt.Errorf("invalid job ID %q", rest[0])
+		}
+		info, err := client.cancel(id)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(output, "Cancelled job %d after %d of %d files\n", info.ID, info.Done, info.Files)
+		return nil
+	case "pause", "resume":
+		if len(rest) != 0 {
+			return usage
+		}
+		state, err := client.setPaused(command == "pause")
+		if err != nil {
+			return err
+		}
+		switch {
+		case state.Paused && state.Changed:
+			fmt.Fprintln(output, "Paused; files being converted finish, no new ones start")
+		case state.Paused:
+			fmt.Fprintln(output, "Already paused")
+		case state.Changed:
+			fmt.Fprintln(output, "Resumed")
+		default:
+			fmt.Fprintln(output, "Not paused")
+		}
+		return nil
+	}
+	return usage
+}
+
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+func writeJobs(output io.Writer, jobs []jobInfo) {
+	if len(jobs) == 0 {
+		fmt.Fprintln(output, "No jobs")
+		return
+	}
+	fmt.Fprintf(output, "%-6s %-9s %-10s %-12s %s\n", "ID", "PRIORITY", "STATUS", "PROGRESS", "SUBMITTED")
+	for _, job := range jobs {
+		progress := fmt.Sprintf("%d/%d", job.Done, job.Files)
+		if job.Failed > 0 {
+			progress += fmt.Sprintf(" (%d failed)", job.Failed)
+		}
+		fmt.Fprintf(output, "%-6d %-9d %-10s %-12s %s\n", job.ID, job.Priority, job.Status, progress, job.Submitted.Local().Format("2006-01-02 15:04:05"))
+	}
+}
+
+// queueClient calls the serve control API.
+type queueClient struct {
+	base string
+	http *http.Client
+}
+
+func (c *queueClient) list() ([]jobInfo, error) {
+	var jobs []jobInfo
+	return jobs, c.do(http.MethodGet, "/jobs", nil, &jobs)
+}
+
+func (c *queueClient) submit(req jobRequest) (jobInfo, error) {
+	var info jobInfo
+	body, err := json.Marshal(req)
+	if err != nil {
+		return info, err
+	}
+	return info, c.do(http.MethodPost, "/jobs", body, &info)
+}
+
+func (c *queueClient) cancel(id int) (jobInfo, error) {
+	var info jobInfo
+	return info, c.do(http.MethodDelete, "/jobs/"+strconv.Itoa(id), nil, &info)
+}
+
+func (c *queueClient) setPaused(paused bool) (queueState, error) {
+	var state queueState
+	path := "/resume"
+	if paused {
+		path = "/pause"
+	}
+	return state, c.do(http.MethodPost, path, []byte("{}"), &state)
+}
+
+func (c *queueClient) do(method, path string, body []byte, result interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s: %s", method, path, strings.TrimSpace(string(message)))
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
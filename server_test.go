@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestControlAPI(t *testing.T) {
+	server := httptest.NewServer(newControlHandler(newJobQueue(), t.TempDir(), defaultListenAddress))
+	defer server.Close()
+	client := &queueClient{base: server.URL, http: server.Client()}
+
+	if _, err := client.submit(jobRequest{Paths: []string{"relative.heic"}}); err == nil || !strings.Contains(err.Error(), "not absolute") {
+		t.Errorf("relative path accepted: %v", err)
+	}
+	bulk, err := client.submit(jobRequest{Paths: []string{"/photos/1.heic", "/photos/2.heic"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	interactive, err := client.submit(jobRequest{Paths: []string{"/photos/3.heic"}, Priority: priorityInteractive})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := client.list()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != interactive.ID || jobs[1].ID != bulk.ID {
+		t.Errorf("list = %+v, want the interactive job first", jobs)
+	}
+
+	if info, err := client.cancel(bulk.ID); err != nil || info.Status != jobCancelled {
+		t.Errorf("cancel = %+v, %v", info, err)
+	}
+	if _, err := client.cancel(bulk.ID); err == nil || !strings.Contains(err.Error(), "already finished") {
+		t.Errorf("second cancel = %v", err)
+	}
+	if _, err := client.cancel(42); err == nil || !strings.Contains(err.Error(), "unknown job") {
+		t.Errorf("cancel unknown = %v", err)
+	}
+
+	if state, err := client.setPaused(true); err != nil || !state.Paused || !state.Changed {
+		t.Errorf("pause = %+v, %v", state, err)
+	}
+	if state, err := client.setPaused(false); err != nil || state.Paused || !state.Changed {
+		t.Errorf("resume = %+v, %v", state, err)
+	}
+	if state, err := client.setPaused(false); err != nil || state.Changed {
+		t.Errorf("second resume = %+v, %v", state, err)
+	}
+	if resp, err := http.Get(server.URL + "/pause"); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /pause = %v, %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+
+	resp, err := http.Post(server.URL+"/jobs/1", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /jobs/1 = %d", resp.StatusCode)
+	}
+}
+
+func TestQueuePauseCommand(t *testing.T) {
+	queue := newJobQueue()
+	server := httptest.NewServer(newControlHandler(queue, t.TempDir(), defaultListenAddress))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "http://")
+
+	for _, tt := range []struct {
+		command, want string
+		paused        bool
+	}{
+		{"pause", "Paused; files being converted finish", true},
+		{"pause", "Already paused", true},
+		{"resume", "Resumed", false},
+		{"resume", "Not paused", false},
+	} {
+		var out bytes.Buffer
+		if err := runQueueCommand([]string{"--listen", address, tt.command}, &out); err != nil {
+			t.Fatalf("%s: %v", tt.command, err)
+		}
+		if !strings.HasPrefix(out.String(), tt.want) || queue.pause.isPaused() != tt.paused {
+			t.Errorf("%s: got %q, paused %v", tt.command, out.String(), queue.pause.isPaused())
+		}
+	}
+	if err := runQueueCommand([]string{"--listen", address, "pause", "now"}, io.Discard); err == nil {
+		t.Error("pause with an argument accepted")
+	}
+}
+
+func TestControlAPIHosts(t *testing.T) {
+	for _, tt := range []struct {
+		host, listen string
+		want         bool
+	}{
+		{"127.0.0.1:8642", defaultListenAddress, true},
+		{"[::1]:8642", defaultListenAddress, true},
+		{"localhost:8642", defaultListenAddress, true},
+		{"192.168.1.20:8642", ":8642", true},
+		{"nas.local:8642", "nas.local:8642", true},
+		{"NAS.local.", "nas.local:8642", true},
+		{"attacker.example:8642", defaultListenAddress, false},
+		{"attacker.example:8642", ":8642", false},
+		{"", defaultListenAddress, false},
+	} {
+		if got := allowedHost(tt.host, tt.listen); got != tt.want {
+			t.Errorf("allowedHost(%q, %q) = %v", tt.host, tt.listen, got)
+		}
+	}
+
+	server := httptest.NewServer(newControlHandler(newJobQueue(), t.TempDir(), defaultListenAddress))
+	defer server.Close()
+	post := func(path, host, contentType string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := post("/jobs", "attacker.example:8642", "application/json"); status != http.StatusForbidden {
+		t.Errorf("rebound host = %d", status)
+	}
+	// Pausing needs JSON too, which a page on another site cannot post
+	// without asking first.
+	for _, path := range []string{"/pause", "/resume"} {
+		if status := post(path, "", "text/plain"); status != http.StatusUnsupportedMediaType {
+			t.Errorf("%s as text = %d", path, status)
+		}
+		if status := post(path, "", "application/json"); status != http.StatusOK {
+			t.Errorf("%s as JSON = %d", path, status)
+		}
+	}
+}
+
+func TestConvertTaskFolders(t *testing.T) {
+	first, second := writePlanFixture(t, "IMG_1.heic"), writePlanFixture(t, "IMG_1.heic")
+	jpegDir := t.TempDir()
+	opts := defaultOptions()
+	opts.nameClaims = newNameClaims()
+
+	outputs := map[string]bool{}
+	for _, tt := range []task{
+		{job: 1, path: filepath.Join(first, "IMG_1.heic")},
+		{job: 1, path: filepath.Join(second, "IMG_1.heic")},
+		{job: 2, path: filepath.Join(first, "IMG_1.heic")},
+	} {
+		ev := convertTask(tt, jpegDir, opts)
+		if ev.Err != nil {
+			t.Fatal(ev.Err)
+		}
+		if !strings.HasPrefix(ev.Output, jobFolder(tt.job)+string(filepath.Separator)) || outputs[ev.Output] {
+			t.Errorf("job %d %s converted to %s, after %v", tt.job, tt.path, ev.Output, outputs)
+		}
+		outputs[ev.Output] = true
+	}
+	if !outputs[filepath.Join("job-1", "IMG_1.jpg")] || !outputs[filepath.Join("job-2", "IMG_1.jpg")] {
+		t.Errorf("outputs %v", outputs)
+	}
+}
+
+func TestJobsAPI(t *testing.T) {
+	queue := newJobQueue()
+	jpegDir := t.TempDir()
+	server := httptest.NewServer(newControlHandler(queue, jpegDir, defaultListenAddress))
+	defer server.Close()
+	client := &queueClient{base: server.URL, http: server.Client()}
+
+	// Directories are expanded to the HEIC files in them.
+	dir := t.TempDir()
+	for _, name := range []string{"b.HEIC", "a.heic", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := client.submit(jobRequest{Paths: []string{dir}})
+	if err != nil || info.Files != 2 {
+		t.Fatalf("submit directory = %+v, %v", info, err)
+	}
+	queue.cancel(info.ID)
+
+	// Archives are extracted and converted into a folder of their own.
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"trip/IMG_1.heic", "trip/._IMG_1.heic", "readme.txt"} {
+		w, _ := zw.Create(name)
+		w.Write([]byte("data"))
+	}
+	zw.Close()
+	resp, err := http.Post(server.URL+"/jobs?priority=5", "application/zip", &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || info.Files != 1 || info.Priority != 5 {
+		t.Fatalf("upload = %d %+v", resp.StatusCode, info)
+	}
+
+	get := func(path string) (*http.Response, []byte) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+	if resp, _ := get("/jobs/2/result.zip"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("result of a queued job = %d", resp.StatusCode)
+	}
+
+	task, _ := queue.next()
+	if filepath.Base(task.path) != "IMG_1.heic" {
+		t.Fatalf("task = %+v", task)
+	}
+	if err := os.MkdirAll(filepath.Join(jpegDir, "job-2"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(jpegDir, "job-2", "IMG_1.jpg"), []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	queue.finish(task, pathEvent{Output: filepath.Join("job-2", "IMG_1.jpg")})
+
+	_, body := get("/jobs/2")
+	var detail jobDetail
+	if err := json.Unmarshal(body, &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.Status != jobDone || len(detail.Items) != 1 || detail.Items[0].Output != "job-2/IMG_1.jpg" {
+		t.Errorf("detail = %s", body)
+	}
+
+	resp, body = get("/jobs/2/result.zip")
+	if resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("result = %d %s", resp.StatusCode, body)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "job-2/IMG_1.jpg" {
+		t.Errorf("result entries = %v", zr.File)
+	}
+
+	if resp, _ := get("/jobs/9"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job = %d", resp.StatusCode)
+	}
+	// Bodies a page on another site could post are refused.
+	for _, contentType := range []string{"text/plain", "application/x-www-form-urlencoded", ""} {
+		resp, err := http.Post(server.URL+"/jobs", contentType, strings.NewReader(`{"paths": ["/photos/1.heic"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Errorf("%q body = %d", contentType, resp.StatusCode)
+		}
+	}
+	resp, err = http.Post(server.URL+"/jobs", "application/json; charset=utf-8", strings.NewReader(`{"paths": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("JSON with a charset = %d", resp.StatusCode)
+	}
+	resp, err = http.Post(server.URL+"/jobs", "application/zip", strings.NewReader("not a zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid archive = %d", resp.StatusCode)
+	}
+}
+
+func TestWriteJobs(t *testing.T) {
+	var out bytes.Buffer
+	writeJobs(&out, nil)
+	if out.String() != "No jobs\n" {
+		t.Errorf("got %q", out.String())
+	}
+
+	out.Reset()
+	writeJobs(&out, []jobInfo{{ID: 7, Priority: 10, Status: jobRunning, Files: 4, Done: 2, Failed: 1}})
+	if !strings.Contains(out.String(), "2/4 (1 failed)") || !strings.HasPrefix(strings.Split(out.String(), "\n")[1], "7 ") {
+		t.Errorf("got %q", out.String())
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	serviceName        = "heictojpeg-watch"
+	serviceDisplayName = "HEIC to JPEG folder watcher"
+	launchdLabel       = "com.github.iancleary.heictojpeg.watch"
+)
+
+// runWatchCommand implements `heictojpeg watch [flags] DIR`, which is
+// --watch as a subcommand, and its --install-service and
+// --uninstall-service forms that run the watcher in the background.
+func runWatchCommand(args []string, output io.Writer) error {
+	rest, install, uninstall := serviceFlags(args)
+	if uninstall {
+		return uninstallService(output)
+	}
+
+	watchArgs := append([]string{"--watch"}, rest...)
+	opts, err := parseOptions(watchArgs, output)
+	if err != nil {
+		return err
+	}
+	if install {
+		args, env := serviceArgs(watchArgs, os.Environ())
+		return installService(args, env, output)
+	}
+	_, err = run(opts)
+	return err
+}
+
+// serviceFlags takes --install-service and --uninstall-service out of
+// args. Only the flags are taken, so a folder named install-service is
+// still an input.
+func serviceFlags(args []string) (rest []string, install, uninstall bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			return append(rest, args[i:]...), install, uninstall
+		}
+		switch arg {
+		case "-install-service", "--install-service":
+			install = true
+		case "-uninstall-service", "--uninstall-service":
+			uninstall = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return rest, install, uninstall
+}
+
+// serviceArgs returns the arguments the background service runs with:
+// HEICTOJPEG_* settings from the installing shell turned into flags, so the
+// service does not depend on its environment, followed by args, which take
+// precedence. The settings of secretFlags are returned as environment
+// variables for the service instead, as its command line is not private.
+func serviceArgs(args, environ []string) ([]string, []string) {
+	names := map[string]string{}
+	newFlagSet(&options{}, io.Discard).VisitAll(func(f *flag.Flag) {
+		names[envName(f.Name)] = f.Name
+	})
+
+	var serviceArgs, serviceEnv []string
+	input := ""
+	for _, variable := range environ {
+		key, value, _ := strings.Cut(variable, "=")
+		if name, ok := names[key]; ok && secretFlags[name] {
+			serviceEnv = append(serviceEnv, variable)
+		} else if ok {
+			serviceArgs = append(serviceArgs, "--"+name+"="+value)
+		} else if key == envPrefix+"INPUT" {
+			input = value
+		}
+	}
+	serviceArgs = append(serviceArgs, "--non-interactive")
+	serviceArgs = append(serviceArgs, args...)
+	if input != "" && !hasPositional(args) {
+		serviceArgs = append(serviceArgs, input)
+	}
+	return serviceArgs, serviceEnv
+}
+
+// hasPositional reports whether args include an input path.
+func hasPositional(args []string) bool {
+	fs := newFlagSet(&options{}, io.Discard)
+	return fs.Parse(args) == nil && fs.NArg() > 0
+}
+
+func serviceExecutable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return exe, nil
+}
+
+// systemdQuote quotes an argument for an ExecStart line.
+func systemdQuote(arg string) string {
+	arg = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(arg)
+	return `"` + arg + `"`
+}
+
+// systemdEnvQuote quotes a variable for an Environment line, which expands
+// specifiers but not variables.
+func systemdEnvQuote(variable string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%").Replace(variable) + `"`
+}
+
+// systemdUnit returns a systemd user unit running the watcher from workDir
+// with the environment variables env.
+func systemdUnit(exe, workDir string, args, env []string) string {
+	command := []string{systemdQuote(exe)}
+	for _, arg := range args {
+		command = append(command, systemdQuote(arg))
+	}
+	var environment strings.Builder
+	for _, variable := range env {
+		environment.WriteString("Environment=" + systemdEnvQuote(variable) + "\n")
+	}
+	return `[Unit]
+Description=` + serviceDisplayName + `
+
+[Service]
+WorkingDirectory=` + systemdQuote(workDir) + `
+` + environment.String() + `ExecStart=` + strings.Join(command, " ") + `
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// launchdPlist returns a LaunchAgent running the watcher from workDir at
+// login with the environment variables env, restarting it if it exits, with
+// output going to logPath.
+func launchdPlist(exe, workDir string, args, env []string, logPath string) string {
+	var program strings.Builder
+	for _, arg := range append([]string{exe}, args...) {
+		program.WriteString("\t\t<string>" + xmlEscape(arg) + "</string>\n")
+	}
+	environment := ""
+	if len(env) > 0 {
+		environment = "\t<key>EnvironmentVariables</key>\n\t<dict>\n"
+		for _, variable := range env {
+			key, value, _ := strings.Cut(variable, "=")
+			environment += "\t\t<key>" + xmlEscape(key) + "</key>\n\t\t<string>" + xmlEscape(value) + "</string>\n"
+		}
+		environment += "\t</dict>\n"
+	}
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + launchdLabel + `</string>
+	<key>ProgramArguments</key>
+	<array>
+` + program.String() + `	</array>
+	<key>WorkingDirectory</key>
+	<string>` + xmlEscape(workDir) + `</string>
+` + environment + `	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>` + xmlEscape(logPath) + `</string>
+	<key>StandardErrorPath</key>
+	<string>` + xmlEscape(logPath) + `</string>
+</dict>
+</plist>
+`
+}
+
+// servicePaths returns where the service definition and, on macOS, its log
+// file live for the current user.
+func servicePaths() (definition, logPath string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", err
+	}
+	switch runtime.GOOS {
+	case "linux":
+		config := os.Getenv("XDG_CONFIG_HOME")
+		if config == "" {
+			config = filepath.Join(home, ".config")
+		}
+		return filepath.Join(config, "systemd", "user", serviceName+".service"), "", nil
+	case "darwin":
+		return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"),
+			filepath.Join(home, "Library", "Logs", serviceName+".log"), nil
+	}
+	return "", "", fmt.Errorf("background services are supported on Linux (systemd), macOS (launchd) and Windows")
+}
+
+// installService registers the watcher with the platform's service manager
+// for the current user, with the environment variables env, and starts it.
+// On Windows it becomes a system service, which needs an elevated prompt.
+func installService(args, env []string, output io.Writer) error {
+	exe, err := serviceExecutable()
+	if err != nil {
+		return err
+	}
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		return installWindowsService(exe, workDir, args, env, output)
+	}
+
+	definition, logPath, err := servicePaths()
+	if err != nil {
+		return err
+	}
+	content := systemdUnit(exe, workDir, args, env)
+	if runtime.GOOS == "darwin" {
+		content = launchdPlist(exe, workDir, args, env, logPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(definition), 0755); err != nil {
+		return err
+	}
+	// The definition may hold a --zip-password or webhook URL, so only its
+	// owner may read it; WriteFile keeps the mode of an existing file.
+	if err := os.WriteFile(definition, []byte(content), 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(definition, 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Installed %s\n", definition)
+
+	if runtime.GOOS == "darwin" {
+		return runServiceCommand(output, "launchctl", "load", "-w", definition)
+	}
+	if err := runServiceCommand(output, "systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	return runServiceCommand(output, "systemctl", "--user", "enable", "--now", serviceName+".service")
+}
+
+// uninstallService stops the watcher and removes its service definition.
+func uninstallService(output io.Writer) error {
+	if runtime.GOOS == "windows" {
+		return uninstallWindowsService(output)
+	}
+	definition, _, err := servicePaths()
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "darwin" {
+		runServiceCommand(output, "launchctl", "unload", "-w", definition)
+	} else {
+		runServiceCommand(output, "systemctl", "--user", "disable", "--now", serviceName+".service")
+	}
+	if err := os.Remove(definition); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Fprintf(output, "Removed %s\n", definition)
+	return nil
+}
+
+func runServiceCommand(output io.Writer, name string, args ...string) error {
+	fmt.Fprintf(output, "Running %s %s\n", name, strings.Join(args, " "))
+	cmd := exec.Command(name, args...)
+	cmd.Stdout, cmd.Stderr = output, output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+var errNotWindows = errors.New("Windows services are only available in Windows builds")
+
+func installWindowsService(exe, workDir string, args, env []string, output io.Writer) error {
+	return errNotWindows
+}
+
+func uninstallWindowsService(output io.Writer) error {
+	return errNotWindows
+}
+
+// runWindowsService is the entry point the Windows service manager starts.
+func runWindowsService(args []string) error {
+	return errNotWindows
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServiceArgs(t *testing.T) {
+	environ := []string{
+		"HOME=/home/user",
+		"HEICTOJPEG_QUALITY=85",
+		"HEICTOJPEG_INPUT=/photos/inbox",
+		"HEICTOJPEG_UNKNOWN=1",
+		"HEICTOJPEG_ZIP_PASSWORD=s3cret",
+	}
+	args, env := serviceArgs([]string{"--watch"}, environ)
+	if got := strings.Join(args, " "); got != "--quality=85 --non-interactive --watch /photos/inbox" {
+		t.Errorf("got %q", got)
+	}
+	if len(env) != 1 || env[0] != "HEICTOJPEG_ZIP_PASSWORD=s3cret" {
+		t.Errorf("secrets should stay in the environment, got %q", env)
+	}
+
+	args, _ = serviceArgs([]string{"--watch", "/photos/other"}, environ)
+	if got := strings.Join(args, " "); got != "--quality=85 --non-interactive --watch /photos/other" {
+		t.Errorf("an input argument should replace HEICTOJPEG_INPUT, got %q", got)
+	}
+}
+
+func TestServiceFlags(t *testing.T) {
+	rest, install, uninstall := serviceFlags([]string{"--quality", "85", "--install-service", "install-service"})
+	if !install || uninstall || strings.Join(rest, " ") != "--quality 85 install-service" {
+		t.Errorf("got %q, %v, %v", rest, install, uninstall)
+	}
+	rest, install, uninstall = serviceFlags([]string{"-uninstall-service", "--", "--install-service"})
+	if install || !uninstall || strings.Join(rest, " ") != "-- --install-service" {
+		t.Errorf("got %q, %v, %v", rest, install, uninstall)
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	unit := systemdUnit("/usr/local/bin/heictojpeg", "/home/user", []string{"--watch", `My "100%" Photos`}, []string{`HEICTOJPEG_ZIP_PASSWORD=a"$b%`})
+	for _, want := range []string{
+		`ExecStart="/usr/local/bin/heictojpeg" "--watch" "My \"100%%\" Photos"` + "\n",
+		`Environment="HEICTOJPEG_ZIP_PASSWORD=a\"$b%%"` + "\n",
+	} {
+		if !strings.Contains(unit, want) {
+			t.Errorf("missing %q in\n%s", want, unit)
+		}
+	}
+	if !strings.Contains(unit, `WorkingDirectory="/home/user"`) || !strings.Contains(unit, "WantedBy=default.target") {
+		t.Errorf("unexpected unit:\n%s", unit)
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	plist := launchdPlist("/usr/local/bin/heictojpeg", "/Users/me", []string{"--watch", "Tom & Jerry"}, []string{"HEICTOJPEG_ZIP_PASSWORD=a<b"}, "/Users/me/Library/Logs/heictojpeg-watch.log")
+	for _, want := range []string{
+		"<key>EnvironmentVariables</key>\n\t<dict>\n\t\t<key>HEICTOJPEG_ZIP_PASSWORD</key>\n\t\t<string>a&lt;b</string>\n\t</dict>\n",
+		"<string>" + launchdLabel + "</string>",
+		"\t\t<string>/usr/local/bin/heictojpeg</string>\n\t\t<string>--watch</string>\n\t\t<string>Tom &amp; Jerry</string>\n",
+		"<key>StandardErrorPath</key>\n\t<string>/Users/me/Library/Logs/heictojpeg-watch.log</string>",
+	} {
+		if !strings.Contains(plist, want) {
+			t.Errorf("missing %q in\n%s", want, plist)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installWindowsService registers the watcher as an automatically started
+// Windows service with the environment variables env, and starts it. The
+// service manager starts programs in the system directory, so the working
+// directory is passed to the run-service entry point.
+func installWindowsService(exe, workDir string, args, env []string, output io.Writer) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to the service manager (run from an elevated prompt): %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: "Converts HEIC files added to a folder into JPEGs.",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"run-service", workDir}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if len(env) > 0 {
+		// The service manager reads a service's environment from the
+		// Environment value of its key.
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName, registry.SET_VALUE)
+		if err != nil {
+			return err
+		}
+		err = key.SetStringsValue("Environment", env)
+		key.Close()
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(output, "Installed service %s\n", serviceName)
+	return s.Start()
+}
+
+func uninstallWindowsService(output io.Writer) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to the service manager (run from an elevated prompt): %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.Control(svc.Stop)
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "Removed service %s\n", serviceName)
+	return nil
+}
+
+// runWindowsService is the entry point the Windows service manager starts:
+// `heictojpeg run-service WORKDIR FLAGS...`.
+func runWindowsService(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: heictojpeg run-service WORKDIR [flags] DIR")
+	}
+	if err := os.Chdir(args[0]); err != nil {
+		return err
+	}
+	opts, err := parseOptions(args[1:], io.Discard)
+	if err != nil {
+		return err
+	}
+	return svc.Run(serviceName, &watchService{opts: opts})
+}
+
+// watchService runs --watch until the service manager stops it.
+type watchService struct {
+	opts options
+}
+
+func (w *watchService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := runContext(ctx, w.opts)
+		done <- err
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case err := <-done:
+			cancel()
+			if err != nil {
+				log.Print(err)
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				select {
+				case <-done:
+				case <-time.After(20 * time.Second):
+				}
+				return false, 0
+			}
+		}
+	}
+}
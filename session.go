@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sessionFolderLayout names --session-dir folders by the minute the run
+// started, sorting in time order and without colons, which Windows and
+// macOS Finder do not allow.
+const sessionFolderLayout = "2006-01-02T15-04"
+
+// createSessionDir creates the --session-dir folder of a run started at
+// started inside base: base/2024-06-01T10-30, or base/2024-06-01T10-30-2
+// and so on when an earlier run of the same minute has that one, so two
+// runs never share a folder.
+func createSessionDir(base string, started time.Time) (string, error) {
+	name := started.Format(sessionFolderLayout)
+	for n := 1; ; n++ {
+		dir := filepath.Join(base, name)
+		if n > 1 {
+			dir = fmt.Sprintf("%s-%d", dir, n)
+		}
+		err := os.Mkdir(dir, 0755)
+		if err == nil {
+			return dir, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateSessionDir(t *testing.T) {
+	base := t.TempDir()
+	started := time.Date(2024, 6, 1, 10, 30, 15, 0, time.Local)
+	for _, want := range []string{"2024-06-01T10-30", "2024-06-01T10-30-2", "2024-06-01T10-30-3"} {
+		dir, err := createSessionDir(base, started)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir != filepath.Join(base, want) {
+			t.Errorf("got %s, want %s", dir, want)
+		}
+	}
+	if _, err := createSessionDir(filepath.Join(base, "missing"), started); err == nil {
+		t.Error("created a session inside a missing directory")
+	}
+}
+
+func TestRunSessionDir(t *testing.T) {
+	dir := writePlanFixture(t, "a.heic")
+	opts, err := parseOptions([]string{"--non-interactive", "--session-dir", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := run(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions, err := os.ReadDir(filepath.Join(dir, "jpegs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("got %d session folders, want one per run", len(sessions))
+	}
+	for _, session := range sessions {
+		for _, name := range []string{"a.jpg", logFileName} {
+			if _, err := os.Stat(filepath.Join(dir, "jpegs", session.Name(), name)); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	if _, err := parseOptions([]string{"--session-dir", "--existing", "clean", dir}, io.Discard); err == nil {
+		t.Error("--session-dir accepted with --existing")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sftpTarget is a remote output directory reached through the system sftp
+// client. Authentication must work non-interactively (keys or an agent).
+type sftpTarget struct {
+	user string
+	host string
+	port string
+	dir  string
+}
+
+func isSFTPURL(raw string) bool {
+	return strings.HasPrefix(raw, "sftp://")
+}
+
+// parseSFTPURL parses sftp://[user@]host[:port]/path.
+func parseSFTPURL(raw string) (*sftpTarget, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "sftp" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid sftp destination %q", raw)
+	}
+
+	dir := u.Path
+	if dir == "" {
+		dir = "."
+	}
+
+	return &sftpTarget{
+		user: u.User.Username(),
+		host: u.Hostname(),
+		port: u.Port(),
+		dir:  dir,
+	}, nil
+}
+
+func (t *sftpTarget) String() string {
+	host := t.host
+	if t.user != "" {
+		host = t.user + "@" + host
+	}
+	if t.port != "" {
+		host += ":" + t.port
+	}
+	return "sftp://" + host + t.dir
+}
+
+// batchScript returns the sftp batch commands uploading localPath to name,
+// a slash-separated path relative to the target directory. Directory creation
+// is prefixed with "-" so existing directories do not abort the batch.
+func (t *sftpTarget) batchScript(localPath, name string) string {
+	remotePath := path.Join(t.dir, filepath.ToSlash(name))
+
+	var script strings.Builder
+	current := ""
+	if strings.HasPrefix(remotePath, "/") {
+		current = "/"
+	}
+	for _, part := range strings.Split(strings.Trim(path.Dir(remotePath), "/"), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		current = path.Join(current, part)
+		fmt.Fprintf(&script, "-mkdir %q\n", current)
+	}
+	fmt.Fprintf(&script, "put %q %q\n", localPath, remotePath)
+	return script.String()
+}
+
+// upload copies localPath to name, relative to the remote directory.
+func (t *sftpTarget) upload(localPath, name string) error {
+	if _, err := t.run(t.batchScript(localPath, name)); err != nil {
+		return fmt.Errorf("sftp upload of %s failed: %v", name, err)
+	}
+	return nil
+}
+
+// run runs an sftp batch script against the target and returns its output.
+func (t *sftpTarget) run(script string) (string, error) {
+	args := []string{"-b", "-", "-o", "BatchMode=yes"}
+	if t.port != "" {
+		args = append(args, "-P", t.port)
+	}
+	host := t.host
+	if t.user != "" {
+		host = t.user + "@" + host
+	}
+	args = append(args, host)
+
+	cmd := exec.Command("sftp", args...)
+	cmd.Stdin = strings.NewReader(script)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
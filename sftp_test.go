@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSFTPURL(t *testing.T) {
+	target, err := parseSFTPURL("sftp://ian@nas.local:2222/photos/jpegs")
+	if err != nil {
+		t.Fatalf("parseSFTPURL failed: %v", err)
+	}
+	if target.user != "ian" || target.host != "nas.local" || target.port != "2222" || target.dir != "/photos/jpegs" {
+		t.Fatalf("unexpected target: %+v", target)
+	}
+	if target.String() != "sftp://ian@nas.local:2222/photos/jpegs" {
+		t.Fatalf("unexpected string form %s", target)
+	}
+
+	if _, err := parseSFTPURL("sftp:///photos"); err == nil {
+		t.Fatal("expected error for missing host")
+	}
+}
+
+func TestSFTPBatchScript(t *testing.T) {
+	target := &sftpTarget{host: "nas", dir: "/photos/jpegs"}
+	script := target.batchScript("/tmp/stage/Japan/Kyoto/IMG_0001.jpg", "Japan/Kyoto/IMG_0001.jpg")
+
+	want := []string{
+		`-mkdir "/photos"`,
+		`-mkdir "/photos/jpegs"`,
+		`-mkdir "/photos/jpegs/Japan"`,
+		`-mkdir "/photos/jpegs/Japan/Kyoto"`,
+		`put "/tmp/stage/Japan/Kyoto/IMG_0001.jpg" "/photos/jpegs/Japan/Kyoto/IMG_0001.jpg"`,
+	}
+	if got := strings.Split(strings.TrimSpace(script), "\n"); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected batch script:\n%s", script)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// sharedAlbumKeys are the property list keys an album's title is read
+// from, compared case-insensitively, in order of preference.
+var sharedAlbumKeys = []string{"name", "title", "albumname", "streamname"}
+
+// sharedAlbumExport is an export of Apple shared albums (iCloud shared
+// streams), as selected by --shared-albums: a folder per album, named by
+// GUID, holding a folder per asset, also named by GUID, around each
+// original file. Album titles come from the property list kept with each
+// album folder, ALBUM.plist beside it or any .plist inside it.
+type sharedAlbumExport struct {
+	path string
+
+	once  sync.Once
+	names map[string]string // source path relative to path -> output name
+}
+
+func (e *sharedAlbumExport) originalsDir() string {
+	return e.path
+}
+
+// defaultOutputDir keeps outputs next to the export, out of the GUID tree.
+func (e *sharedAlbumExport) defaultOutputDir() string {
+	return filepath.Join(filepath.Dir(filepath.Clean(e.path)), "jpegs")
+}
+
+func (e *sharedAlbumExport) entries(exclude string, found func(n int64)) ([]os.DirEntry, error) {
+	return collectEntries(e.scan(exclude, found))
+}
+
+func (e *sharedAlbumExport) scan(exclude string, found func(n int64)) *entryStream {
+	return scanTree(e.path, scanWorkers, exclude, found)
+}
+
+// outputName returns "Album/ORIGINAL.jpg" for a source of the export.
+func (e *sharedAlbumExport) outputName(source string) string {
+	e.once.Do(e.load)
+	return e.names[filepath.ToSlash(source)]
+}
+
+// isFavorite reports false: shared albums have no favourites of their own.
+func (e *sharedAlbumExport) isFavorite(string) bool {
+	return false
+}
+
+// load names every source of the export once, so that two contributors'
+// IMG_0001.HEIC in one album get different names on every run.
+func (e *sharedAlbumExport) load() {
+	var sources []string
+	filepath.WalkDir(e.path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !(isHEICFile(d.Name()) || isVideoFile(d.Name())) {
+			return nil
+		}
+		if rel, err := filepath.Rel(e.path, p); err == nil {
+			sources = append(sources, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	titles := map[string]string{}
+	for _, source := range sources {
+		album := strings.SplitN(source, "/", 2)[0]
+		if _, ok := titles[album]; !ok && album != source {
+			titles[album] = sharedAlbumTitle(filepath.Join(e.path, album))
+		}
+	}
+	e.names = sharedAlbumOutputNames(sources, titles)
+}
+
+// sharedAlbumOutputNames maps source paths, relative to the export, to
+// "Title/ORIGINAL.jpg", with the album folder's GUID for albums without a
+// title and no folder for files at the top. Names used twice get the start
+// of the asset folder's GUID appended.
+func sharedAlbumOutputNames(sources []string, titles map[string]string) map[string]string {
+	sort.Strings(sources)
+	names := make(map[string]string, len(sources))
+	used := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		base := path.Base(source)
+		base = strings.TrimSuffix(base, path.Ext(base))
+		dir := ""
+		if album := strings.SplitN(source, "/", 2)[0]; album != source {
+			dir = sanitizePathComponent(titles[album])
+			if dir == "" {
+				dir = album
+			}
+		}
+
+		name := path.Join(dir, base+".jpg")
+		if used[strings.ToLower(name)] {
+			asset := path.Base(path.Dir(source))
+			if len(asset) > 8 {
+				asset = asset[:8]
+			}
+			name = path.Join(dir, base+"_"+asset+".jpg")
+		}
+		used[strings.ToLower(name)] = true
+		names[source] = name
+	}
+	return names
+}
+
+// sharedAlbumTitle reads the title of the album folder dir from its
+// property list, or returns "".
+func sharedAlbumTitle(dir string) string {
+	candidates := []string{dir + ".plist"}
+	if matches, err := filepath.Glob(filepath.Join(dir, "*.plist")); err == nil {
+		sort.Strings(matches)
+		candidates = append(candidates, matches...)
+	}
+	for _, candidate := range candidates {
+		data, err := os.ReadFile(candidate)
+		if err != nil {
+			continue
+		}
+		values, err := plistStrings(data)
+		if err != nil {
+			continue
+		}
+		lower := make(map[string]string, len(values))
+		for key, value := range values {
+			lower[strings.ToLower(key)] = value
+		}
+		for _, key := range sharedAlbumKeys {
+			if title := strings.TrimSpace(lower[key]); title != "" {
+				return title
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSharedAlbumOutputNames(t *testing.T) {
+	sources := []string{
+		"B1D0/F00D2222-9999/IMG_0001.HEIC",
+		"A9C4/0AB2C3D4E5/IMG_0001.HEIC",
+		"A9C4/1111/IMG_0001.heic",
+		"A9C4/2222/IMG_0002.MOV",
+		"loose.heic",
+	}
+	titles := map[string]string{"A9C4": "Trip: Kyoto", "B1D0": ""}
+	names := sharedAlbumOutputNames(sources, titles)
+	want := map[string]string{
+		"A9C4/0AB2C3D4E5/IMG_0001.HEIC":    "Trip- Kyoto/IMG_0001.jpg",
+		"A9C4/1111/IMG_0001.heic":          "Trip- Kyoto/IMG_0001_1111.jpg",
+		"A9C4/2222/IMG_0002.MOV":           "Trip- Kyoto/IMG_0002.jpg",
+		"B1D0/F00D2222-9999/IMG_0001.HEIC": "B1D0/IMG_0001.jpg",
+		"loose.heic":                       "loose.jpg",
+	}
+	for source, name := range want {
+		if names[source] != name {
+			t.Errorf("%s: got %q, want %q", source, names[source], name)
+		}
+	}
+}
+
+func TestSharedAlbumsRun(t *testing.T) {
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(t.TempDir(), "export")
+	for _, name := range []string{
+		"6E1F4C2A/0B9A2E44-1C3D/IMG_0001.HEIC",
+		"6E1F4C2A/7D3C9F10-8B2A/IMG_0001.HEIC",
+		"9A8B7C6D/5E4F3A2B-1C0D/IMG_0002.HEIC",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "6E1F4C2A", "Info.plist"), []byte(testXMLPlist), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "9A8B7C6D.plist"), testBinaryPlist(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--shared-albums", "--non-interactive", root}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	jpegDir := filepath.Join(filepath.Dir(root), "jpegs")
+	for _, name := range []string{"Family & Friends/IMG_0001.jpg", "Family & Friends/IMG_0001_7D3C9F10.jpg", "Pets/IMG_0002.jpg"} {
+		if _, err := os.Stat(filepath.Join(jpegDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("missing output: %v", err)
+		}
+	}
+}
+
+func TestSharedAlbumsRejected(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.heic")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"--shared-albums", file},
+		{"--shared-albums", t.TempDir(), t.TempDir()},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sidecar is the provenance record --sidecar writes next to each output as
+// NAME.jpg.json, so it stays with the file when the log does not.
+type sidecar struct {
+	Source         string            `json:"source"`
+	SourceSHA256   string            `json:"sourceSha256"`
+	SourceSize     int64             `json:"sourceSize"`
+	SourceModified time.Time         `json:"sourceModified"`
+	Output         string            `json:"output"`
+	Converted      time.Time         `json:"converted"`
+	Tool           versionInfo       `json:"tool"`
+	Settings       map[string]string `json:"settings"`
+	PHash          string            `json:"phash,omitempty"`
+	Enhancement    *enhancement      `json:"enhancement,omitempty"` // --auto-enhance
+}
+
+func sidecarPath(output string) string {
+	return output + ".json"
+}
+
+// writeSidecar records how output, relative to jpegDir, was made from the
+// source bytes read from sourcePath, with its perceptual hash if one was
+// computed and the --auto-enhance correction if one was applied. Settings
+// are those used for this file, after --rules.
+func writeSidecar(jpegDir, output string, source []byte, sourcePath, phash string, opts options) error {
+	if abs, err := filepath.Abs(sourcePath); err == nil {
+		sourcePath = abs
+	}
+	sum := sha256.Sum256(source)
+	header := newSessionHeader(opts, time.Now())
+	record := sidecar{
+		Source:       sourcePath,
+		SourceSHA256: hex.EncodeToString(sum[:]),
+		SourceSize:   int64(len(source)),
+		Output:       filepath.ToSlash(output),
+		Converted:    header.Started,
+		Tool:         header.versionInfo,
+		Settings:     header.Settings,
+		PHash:        phash,
+		Enhancement:  opts.enhancement,
+	}
+	if info, err := os.Stat(sourcePath); err == nil {
+		record.SourceModified = info.ModTime()
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := sidecarPath(filepath.Join(jpegDir, output))
+	return writeEncoded(path, bytes.NewBuffer(append(data, '\n')), nil, opts)
+}
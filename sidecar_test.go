@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestWriteSidecar(t *testing.T) {
+	jpegDir := t.TempDir()
+	opts := defaultOptions()
+	opts.sidecar = true
+	opts.quality = 77
+	output, err := convertFile("testdata/images", "goheif-camel.heic", jpegDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(jpegDir, "goheif-camel.jpg.json"))
+	if err != nil {
+		t.Fatalf("sidecar missing: %v", err)
+	}
+	var record sidecar
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	source, _ := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	sum := sha256.Sum256(source)
+	if record.SourceSHA256 != hex.EncodeToString(sum[:]) || record.SourceSize != int64(len(source)) {
+		t.Errorf("wrong source hash or size: %+v", record)
+	}
+	if !filepath.IsAbs(record.Source) || filepath.Base(record.Source) != "goheif-camel.heic" || record.SourceModified.IsZero() {
+		t.Errorf("wrong source: %s modified %s", record.Source, record.SourceModified)
+	}
+	if record.Output != output || record.Converted.IsZero() || record.Tool.Version == "" {
+		t.Errorf("wrong output or tool: %+v", record)
+	}
+	if record.Settings["quality"] != strconv.Itoa(opts.quality) {
+		t.Errorf("settings record quality %q", record.Settings["quality"])
+	}
+
+	if _, err := parseOptions([]string{"--sidecar", "--output-format", "pdf"}, io.Discard); err == nil {
+		t.Error("expected an error with --output-format pdf")
+	}
+}
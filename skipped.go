@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Reasons an entry of the input is not converted, as they appear in the
+// logs and plans. They are stable so audits can match on them.
+const (
+	skipNotHEIC          = "not-heic"
+	skipDirectory        = "directory"
+	skipAlreadyConverted = "already-converted"
+	skipExcluded         = "excluded" // .heicignore or --airdrop
+	skipTooSmall         = "too-small"
+	skipTooLarge         = "too-large"
+	skipScreenshot       = "screenshot"
+	skipNotScreenshot    = "not-screenshot"
+	skipLowRating        = "low-rating"
+	skipNotFavorite      = "not-favorite"
+	skipBurstFrame       = "burst-frame"
+	skipNotDownloaded    = "not-downloaded" // --offloaded skip
+)
+
+// skippedFile is an entry of the input left out of a run.
+type skippedFile struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// skipLog collects the entries a run leaves out, from the goroutines that
+// list and filter them, so every entry scanned is accounted for in the logs.
+// A nil skipLog records nothing.
+type skipLog struct {
+	mu    sync.Mutex
+	files []skippedFile
+}
+
+// add records that name was skipped for reason, a skip constant; detail is
+// the human readable reason where it says more, such as "below 2 MP".
+func (l *skipLog) add(name, reason, detail string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files = append(l.files, skippedFile{Name: name, Reason: reason, Detail: detail})
+}
+
+// addUnlisted records the entries of files that are not in kept.
+func (l *skipLog) addUnlisted(files, kept []os.DirEntry, reason string) {
+	if l == nil || len(kept) == len(files) {
+		return
+	}
+	left := make(map[string]bool, len(kept))
+	for _, file := range kept {
+		left[file.Name()] = true
+	}
+	for _, file := range files {
+		if !left[file.Name()] {
+			l.add(file.Name(), reason, "")
+		}
+	}
+}
+
+// addIgnored records a source that is not converted: a directory or a file
+// the pipeline does not handle.
+func (l *skipLog) addIgnored(file os.DirEntry) {
+	if file.IsDir() {
+		l.add(file.Name(), skipDirectory, "")
+	} else {
+		l.add(file.Name(), skipNotHEIC, "")
+	}
+}
+
+// drain returns the entries recorded so far, sorted by name, and forgets
+// them, so each --watch cycle logs its own.
+func (l *skipLog) drain() []skippedFile {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	files := l.files
+	l.files = nil
+	l.mu.Unlock()
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// skipLine is the log line of a skipped entry of currentDir, "NAME SIZE >
+// Skipped > reason (detail)".
+func skipLine(currentDir string, file skippedFile) string {
+	line := fmt.Sprintf("%s %s > Skipped > %s", file.Name, humanReadableFileSize(getFileSize(filepath.Join(currentDir, file.Name))), file.Reason)
+	if file.Detail != "" {
+		line += " (" + file.Detail + ")"
+	}
+	return line
+}
+
+// skipSummary is the "Skipped Files==N (reason n, ...)" general log line,
+// or "" when nothing was skipped.
+func skipSummary(files []skippedFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Skipped Files==%d (%s)", len(files), skipCounts(files))
+}
+
+// skipCounts counts files by reason, "reason n, ...".
+func skipCounts(files []skippedFile) string {
+	counts := map[string]int{}
+	for _, file := range files {
+		counts[file.Reason]++
+	}
+	reasons := make([]string, 0, len(counts))
+	for reason, n := range counts {
+		reasons = append(reasons, fmt.Sprintf("%s %d", reason, n))
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, ", ")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSkippedEntriesLogged(t *testing.T) {
+	dir := writePlanFixture(t, "a.heic", "b.heic")
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte("b.heic\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "raw"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--verbose", "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "jpegs", logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := string(data)
+	for _, want := range []string{
+		"b.heic 329.6KB > Skipped > excluded\n",
+		"notes.txt 5B > Skipped > not-heic\n",
+		"> Skipped > directory\n",
+		"Skipped Files==4 (directory 1, excluded 1, not-heic 2)\n",
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("logs do not contain %q:\n%s", want, logs)
+		}
+	}
+	if strings.Contains(logs, "a.heic 329.6KB > Skipped") {
+		t.Errorf("converted file logged as skipped:\n%s", logs)
+	}
+}
+
+func TestSkippedFilesInPlan(t *testing.T) {
+	dir := writePlanFixture(t, "a.heic")
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--min-width", "100000", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentDir, files, err := resolveInput(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := makePlan(currentDir, files, opts, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Files) != 0 {
+		t.Errorf("unexpected files %+v", plan.Files)
+	}
+	want := []skippedFile{
+		{Name: "a.heic", Reason: skipTooSmall, Detail: "too small"},
+		{Name: "notes.txt", Reason: skipNotHEIC},
+	}
+	if len(plan.Skipped) != len(want) {
+		t.Fatalf("skipped %+v, want %+v", plan.Skipped, want)
+	}
+	for i := range want {
+		if plan.Skipped[i] != want[i] {
+			t.Errorf("skipped %+v, want %+v", plan.Skipped[i], want[i])
+		}
+	}
+}
+
+func TestSkipSummary(t *testing.T) {
+	if got := skipSummary(nil); got != "" {
+		t.Errorf("nothing skipped: %q", got)
+	}
+	files := []skippedFile{
+		{Name: "a.txt", Reason: skipNotHEIC},
+		{Name: "b.heic", Reason: skipBurstFrame},
+		{Name: "c.txt", Reason: skipNotHEIC},
+	}
+	if got, want := skipSummary(files), "Skipped Files==3 (burst-frame 1, not-heic 2)"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// A nil log records nothing.
+	var l *skipLog
+	l.add("a.txt", skipNotHEIC, "")
+	if l.drain() != nil {
+		t.Error("nil skipLog recorded an entry")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+)
+
+// What --only-if-smaller does with a JPEG that is not smaller than its
+// source (--if-larger).
+const (
+	largerSkip  = "skip"  // write nothing
+	largerRetry = "retry" // lower the quality step by step, then skip
+	largerCopy  = "copy"  // copy the HEIC into the output directory instead
+)
+
+// Quality steps tried by --if-larger retry, down to minRetryQuality.
+const (
+	retryQualityStep = 10
+	minRetryQuality  = 40
+)
+
+var errNotSmaller = errors.New("JPEG would not be smaller than the source")
+
+func isValidLargerPolicy(policy string) bool {
+	return policy == largerSkip || policy == largerRetry || policy == largerCopy
+}
+
+// shrinkJPEG re-encodes img at lower qualities into encoded, which holds a
+// JPEG encoded at opts.quality after headerLen bytes of SOI and metadata
+// segments, until it is smaller than opts.sourceSize. It returns
+// errNotSmaller if even minRetryQuality is too large, or if the policy is not
+// retry.
+func shrinkJPEG(encoded *bytes.Buffer, headerLen int, img image.Image, output string, opts options) error {
+	if int64(encoded.Len()) < opts.sourceSize {
+		return nil
+	}
+	if opts.ifLarger != largerRetry {
+		return errNotSmaller
+	}
+
+	for quality := opts.quality - retryQualityStep; quality >= minRetryQuality; quality -= retryQualityStep {
+		encoded.Truncate(headerLen)
+		if err := encodeJPEGImage(&writerSkipper{encoded, 2}, img, quality, opts); err != nil {
+			return err
+		}
+		if int64(encoded.Len()) < opts.sourceSize {
+			fmt.Printf("%s: re-encoded at quality %d to stay smaller than the source\n", filepath.Base(output), quality)
+			return nil
+		}
+	}
+	return errNotSmaller
+}
+
+// copySource implements --if-larger copy: it copies the source into the
+// place of output, keeping the source extension, and returns the new path.
+func copySource(source []byte, sourceName, output string, opts options) (string, error) {
+	copied := strings.TrimSuffix(output, filepath.Ext(output)) + filepath.Ext(sourceName)
+	return copied, writeEncoded(copied, bytes.NewBuffer(source), nil, opts)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOnlyIfSmaller(t *testing.T) {
+	const source = "goheif-camel.heic"
+	sourceSize := getFileSize(filepath.Join("testdata", "images", source))
+
+	// At quality 100 the fixture's JPEG is about three times its HEIC.
+	opts := defaultOptions()
+	opts.quality = 100
+	opts.onlyIfSmaller = true
+
+	opts.ifLarger = largerRetry
+	jpegDir := t.TempDir()
+	output, err := convertFile("testdata/images", source, jpegDir, opts)
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if size := getFileSize(filepath.Join(jpegDir, output)); size == 0 || size >= sourceSize {
+		t.Errorf("retry: got a %d byte JPEG for a %d byte source", size, sourceSize)
+	}
+
+	opts.ifLarger = largerSkip
+	jpegDir = t.TempDir()
+	if _, err := convertFile("testdata/images", source, jpegDir, opts); !errors.Is(err, errNotSmaller) {
+		t.Errorf("skip: got %v, want errNotSmaller", err)
+	}
+	if entries, _ := os.ReadDir(jpegDir); len(entries) != 0 {
+		t.Errorf("skip: %d files were written", len(entries))
+	}
+
+	opts.ifLarger = largerCopy
+	jpegDir = t.TempDir()
+	output, err = convertFile("testdata/images", source, jpegDir, opts)
+	if err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if output != source || getFileSize(filepath.Join(jpegDir, output)) != sourceSize {
+		t.Errorf("copy: got %s, want an unchanged %s", output, source)
+	}
+}
+
+func TestParseOptionsIfLarger(t *testing.T) {
+	opts, err := parseOptions([]string{"--only-if-smaller", "--if-larger", "copy"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.onlyIfSmaller || opts.ifLarger != largerCopy {
+		t.Errorf("got %v, %q", opts.onlyIfSmaller, opts.ifLarger)
+	}
+	if _, err := parseOptions([]string{"--only-if-smaller", "--if-larger", "shrink"}, io.Discard); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+	if _, err := parseOptions([]string{"--only-if-smaller", "--output-format", "pdf"}, io.Discard); err == nil {
+		t.Error("expected an error with --output-format pdf")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inputDescription names what a run converts, for logs and notifications.
+func inputDescription(opts options) string {
+	switch {
+	case opts.filesFrom != "":
+		return "--files-from " + opts.filesFrom
+	case len(opts.inputPaths) > 0:
+		return strings.Join(opts.inputPaths, ", ")
+	}
+	return opts.inputPath
+}
+
+// sourceEntries lists the files of several input paths, directories and
+// single files alike. Like manifest entries they carry their own paths, so
+// there is no one input directory to join them with.
+func sourceEntries(paths []string) ([]os.DirEntry, error) {
+	var entries []os.DirEntry
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			entries = append(entries, manifestEntry{path: path, info: info})
+			continue
+		}
+		files, err := getFilesInDirectory(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			info, err := file.Info()
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, manifestEntry{path: filepath.Join(path, file.Name()), info: info})
+		}
+	}
+	return entries, nil
+}
+
+// sourceFolder is the output subfolder of a source with --folder-per-source:
+// the name of the directory it was read from.
+func sourceFolder(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		dir = filepath.Dir(path)
+	}
+	name := filepath.Base(dir)
+	if name == string(filepath.Separator) || name == "." {
+		return ""
+	}
+	return name
+}
+
+// checkSourceFolders rejects input directories that would share an output
+// subfolder, as two folders named Vacation in different places would.
+func checkSourceFolders(paths []string) error {
+	seen := map[string]string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			continue
+		}
+		folder := sourceFolder(filepath.Join(path, "x"))
+		if other, ok := seen[folder]; ok && filepath.Clean(other) != filepath.Clean(path) {
+			return fmt.Errorf("input directories %s and %s would both be written to %s/; rename one or pass --folder-per-source=false", other, path, folder)
+		}
+		seen[folder] = path
+	}
+	return nil
+}
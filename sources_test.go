@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseOptionsSeveralInputs(t *testing.T) {
+	root := t.TempDir()
+	vacation := filepath.Join(root, "Vacation2023")
+	birthday := filepath.Join(root, "Birthday")
+	if err := os.Mkdir(vacation, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(birthday, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{vacation, "--quality", "90", birthday}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opts.inputPaths, []string{vacation, birthday}) || !opts.folderPerSource {
+		t.Fatalf("unexpected inputs %q (folder per source %v)", opts.inputPaths, opts.folderPerSource)
+	}
+	opts, err = parseOptions([]string{"--folder-per-source=false", vacation, birthday}, io.Discard)
+	if err != nil || opts.folderPerSource {
+		t.Fatalf("--folder-per-source=false not applied (%v)", err)
+	}
+
+	// Folders with the same name would share an output subfolder.
+	other := filepath.Join(root, "old", "Birthday")
+	if err := os.MkdirAll(other, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseOptions([]string{birthday, other}, io.Discard); err == nil {
+		t.Error("expected error for clashing folder names")
+	}
+	if _, err := parseOptions([]string{"--folder-per-source=false", birthday, other}, io.Discard); err != nil {
+		t.Error(err)
+	}
+	if _, err := parseOptions([]string{vacation, "-"}, io.Discard); err == nil {
+		t.Error("expected error for stdin among several inputs")
+	}
+	if _, err := parseOptions([]string{"--files-from", "list.txt", vacation}, io.Discard); err == nil {
+		t.Error("expected error for an input path with --files-from")
+	}
+}
+
+func TestSourceFolder(t *testing.T) {
+	for path, want := range map[string]string{
+		filepath.Join("photos", "Vacation2023", "IMG_1.heic"): "Vacation2023",
+		filepath.Join("Birthday", "IMG_2.heic"):               "Birthday",
+		string(filepath.Separator) + "IMG_3.heic":             "",
+	} {
+		if got := sourceFolder(path); got != want {
+			t.Errorf("%s: got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestRunSeveralInputs(t *testing.T) {
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	var inputs []string
+	for _, name := range []string{"Vacation2023", "Birthday"} {
+		dir := filepath.Join(root, name)
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, dir)
+	}
+
+	opts, err := parseOptions(append([]string{"--output-dir", filepath.Join(root, "out")}, inputs...), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := run(opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Vacation2023", "Birthday"} {
+		if _, err := os.Stat(filepath.Join(root, "out", name, "camel.jpg")); err != nil {
+			t.Error(err)
+		}
+	}
+}
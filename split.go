@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// batchSplitter distributes outputs into batch-001, batch-002, ... folders
+// capped by total size and/or file count. It is shared by all workers.
+type batchSplitter struct {
+	maxSize  int64
+	maxCount int
+
+	mu      sync.Mutex
+	batch   int
+	size    int64
+	count   int
+	started bool
+}
+
+func newBatchSplitter(maxSize int64, maxCount int) *batchSplitter {
+	if maxSize <= 0 && maxCount <= 0 {
+		return nil
+	}
+	return &batchSplitter{maxSize: maxSize, maxCount: maxCount, batch: 1}
+}
+
+// assign reserves room for a file of the given size and returns the batch
+// folder it belongs in. A file larger than the size cap gets a batch of its own.
+func (s *batchSplitter) assign(size int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	full := (s.maxCount > 0 && s.count >= s.maxCount) ||
+		(s.maxSize > 0 && s.size+size > s.maxSize)
+	if s.started && full {
+		s.batch++
+		s.size, s.count = 0, 0
+	}
+
+	s.started = true
+	s.size += size
+	s.count++
+	return fmt.Sprintf("batch-%03d", s.batch)
+}
+
+// moveIntoBatch moves a converted file into its batch folder and returns the
+// new path relative to jpegDir.
+func (s *batchSplitter) moveIntoBatch(jpegDir, outputFileName string) (string, error) {
+	source := filepath.Join(jpegDir, outputFileName)
+	info, err := os.Stat(source)
+	if err != nil {
+		return outputFileName, err
+	}
+
+	batched := filepath.Join(s.assign(info.Size()), outputFileName)
+	target := filepath.Join(jpegDir, batched)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return outputFileName, err
+	}
+	if err := os.Rename(source, target); err != nil {
+		return outputFileName, err
+	}
+	return batched, nil
+}
+
+// parseByteSize parses sizes such as "4GB", "700MB", "512K" or "1024".
+// Units are powers of 1024, matching the sizes printed in the log.
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "B")
+
+	multiplier := int64(1)
+	if s != "" {
+		if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+			for ; i >= 0; i-- {
+				multiplier *= 1024
+			}
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
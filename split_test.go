@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"1024":  1024,
+		"512K":  512 * 1024,
+		"700MB": 700 * 1024 * 1024,
+		"4GB":   4 << 30,
+		"1.5g":  3 << 29,
+	}
+	for input, want := range tests {
+		got, err := parseByteSize(input)
+		if err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	if _, err := parseByteSize("lots"); err == nil {
+		t.Error("expected error for invalid size")
+	}
+}
+
+func TestBatchSplitterAssign(t *testing.T) {
+	bySize := newBatchSplitter(100, 0)
+	var got []string
+	for _, size := range []int64{40, 50, 20, 150, 10} {
+		got = append(got, bySize.assign(size))
+	}
+	want := []string{"batch-001", "batch-001", "batch-002", "batch-003", "batch-004"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("size split: got %v, want %v", got, want)
+		}
+	}
+
+	byCount := newBatchSplitter(0, 2)
+	got = got[:0]
+	for i := 0; i < 5; i++ {
+		got = append(got, byCount.assign(1))
+	}
+	want = []string{"batch-001", "batch-001", "batch-002", "batch-002", "batch-003"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("count split: got %v, want %v", got, want)
+		}
+	}
+
+	if newBatchSplitter(0, 0) != nil {
+		t.Fatal("expected no splitter without caps")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	statsBuckets  = 8
+	statsBarWidth = 40
+	unknownCamera = "Unknown camera"
+)
+
+// fileStat is what --stats records about one converted file.
+type fileStat struct {
+	heicSize int64
+	jpegSize int64
+	duration time.Duration
+	camera   string
+}
+
+// cameraModel returns "Make Model" for a HEIC file, read from its EXIF.
+func cameraModel(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return unknownCamera
+	}
+	defer f.Close()
+
+	raw, err := extractExif(f)
+	if err != nil {
+		return unknownCamera
+	}
+	x := decodeExif(raw)
+	cameraMake, model := exifStringField(x, exif.Make), exifStringField(x, exif.Model)
+	switch {
+	case model == "":
+		return unknownCamera
+	case cameraMake == "" || strings.HasPrefix(model, cameraMake):
+		return model
+	default:
+		return cameraMake + " " + model
+	}
+}
+
+// statsLines renders the distributions printed at the end of a --stats run.
+func statsLines(stats []fileStat) []string {
+	if len(stats) == 0 {
+		return nil
+	}
+
+	var sizes, ratios, durations []float64
+	cameras := map[string]int{}
+	for _, s := range stats {
+		sizes = append(sizes, float64(s.jpegSize))
+		if s.heicSize > 0 {
+			ratios = append(ratios, float64(s.jpegSize)/float64(s.heicSize))
+		}
+		durations = append(durations, float64(s.duration.Milliseconds()))
+		cameras[s.camera]++
+	}
+
+	lines := []string{"", "Output sizes:"}
+	lines = append(lines, histogram(sizes, func(v float64) string { return humanReadableFileSize(int64(v)) })...)
+	lines = append(lines, "", "Compression ratios (JPEG/HEIC):")
+	lines = append(lines, histogram(ratios, func(v float64) string { return fmt.Sprintf("%.2fx", v) })...)
+	lines = append(lines, "", "Conversion times:")
+	lines = append(lines, histogram(durations, func(v float64) string { return fmt.Sprintf("%dms", int64(v)) })...)
+
+	names := make([]string, 0, len(cameras))
+	for name := range cameras {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if cameras[names[i]] != cameras[names[j]] {
+			return cameras[names[i]] > cameras[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	labels := make([]string, len(names))
+	counts := make([]int, len(names))
+	for i, name := range names {
+		labels[i], counts[i] = name, cameras[name]
+	}
+	lines = append(lines, "", "Camera models:")
+	return append(lines, bars(labels, counts)...)
+}
+
+// histogram buckets values into equal-width ranges between their minimum and
+// maximum.
+func histogram(values []float64, format func(float64) string) []string {
+	if len(values) == 0 {
+		return []string{"  (no data)"}
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	if lo == hi {
+		return bars([]string{format(lo)}, []int{len(values)})
+	}
+
+	width := (hi - lo) / statsBuckets
+	counts := make([]int, statsBuckets)
+	for _, v := range values {
+		i := int((v - lo) / width)
+		if i >= statsBuckets {
+			i = statsBuckets - 1
+		}
+		counts[i]++
+	}
+	labels := make([]string, statsBuckets)
+	for i := range labels {
+		labels[i] = format(lo+width*float64(i)) + " - " + format(lo+width*float64(i+1))
+	}
+	return bars(labels, counts)
+}
+
+// bars draws one labelled bar per count, scaled to the largest count.
+func bars(labels []string, counts []int) []string {
+	labelWidth, maxCount := 0, 0
+	for i, label := range labels {
+		if len(label) > labelWidth {
+			labelWidth = len(label)
+		}
+		if counts[i] > maxCount {
+			maxCount = counts[i]
+		}
+	}
+
+	lines := make([]string, len(labels))
+	for i, label := range labels {
+		bar := 0
+		if maxCount > 0 {
+			bar = counts[i] * statsBarWidth / maxCount
+		}
+		if bar == 0 && counts[i] > 0 {
+			bar = 1
+		}
+		lines[i] = fmt.Sprintf("  %-*s | %s %d", labelWidth, label, strings.Repeat("#", bar), counts[i])
+	}
+	return lines
+}
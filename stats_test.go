@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	lines := histogram([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8}, func(v float64) string { return strings.Repeat("x", int(v)) })
+	if len(lines) != statsBuckets {
+		t.Fatalf("got %d buckets, want %d", len(lines), statsBuckets)
+	}
+	// The maximum value falls into the last bucket rather than a ninth one.
+	if !strings.HasSuffix(lines[statsBuckets-1], " 2") {
+		t.Fatalf("last bucket %q should hold 2 values", lines[statsBuckets-1])
+	}
+
+	single := histogram([]float64{3, 3}, func(v float64) string { return "three" })
+	if len(single) != 1 || !strings.Contains(single[0], "three") || !strings.HasSuffix(single[0], " 2") {
+		t.Fatalf("unexpected single-value histogram %q", single)
+	}
+}
+
+func TestStatsLines(t *testing.T) {
+	stats := []fileStat{
+		{heicSize: 1000, jpegSize: 800, duration: 100 * time.Millisecond, camera: "iPhone 12"},
+		{heicSize: 2000, jpegSize: 3000, duration: 300 * time.Millisecond, camera: "iPhone 15 Pro"},
+		{heicSize: 1500, jpegSize: 1500, duration: 200 * time.Millisecond, camera: "iPhone 15 Pro"},
+	}
+	out := strings.Join(statsLines(stats), "\n")
+	for _, want := range []string{"Output sizes:", "Compression ratios (JPEG/HEIC):", "0.80x", "1.50x", "Conversion times:", "100ms", "Camera models:"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stats output missing %q:\n%s", want, out)
+		}
+	}
+	// Cameras are listed most common first.
+	if strings.Index(out, "iPhone 15 Pro") > strings.Index(out, "iPhone 12") {
+		t.Errorf("camera models not sorted by count:\n%s", out)
+	}
+
+	if lines := statsLines(nil); lines != nil {
+		t.Errorf("expected no output without conversions, got %q", lines)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// stdioPath is the input path that converts one HEIC read from stdin and
+// writes the result to stdout, e.g. `heictojpeg - --output-format ppm | ...`.
+const stdioPath = "-"
+
+// convertStream implements stdin/stdout mode in opts.outputFormat. Nothing
+// but the image is written to w; messages go to stderr.
+func convertStream(r io.Reader, w io.Writer, opts options) error {
+	source, err := readIntoBuffer(r, 0)
+	if err != nil {
+		return err
+	}
+	defer putBuffer(source)
+	if source.Len() == 0 {
+		return fmt.Errorf("no HEIC data on stdin")
+	}
+	fileInput := bytes.NewReader(source.Bytes())
+
+	exif, err := extractExif(fileInput)
+	if err != nil {
+		return err
+	}
+	if exif, err = editExif(exif, opts); err != nil {
+		return err
+	}
+	if len(opts.rules) > 0 {
+		width, height, _ := imageDimensions(fileInput)
+		opts = applyRules(opts, exif, width, height)
+	}
+	if exif, err = filterExif(exif, opts.metadata); err != nil {
+		return err
+	}
+	opts.xmpPacket = filterXMP(extractXMP(fileInput), opts.metadata)
+
+	if data, ok := primaryJPEG(fileInput); ok && canPassThrough(data, opts) {
+		exif, opts = preparePassthrough(fileInput, data, exif, opts)
+		encoded := getBuffer(source.Len())
+		defer putBuffer(encoded)
+		if err := writePassthroughJPEG(encoded, data, exif, opts); err != nil {
+			return err
+		}
+		_, err = encoded.WriteTo(w)
+		return err
+	}
+
+	img, exif, opts, err := decodeImage(fileInput, exif, opts)
+	if err != nil {
+		return err
+	}
+	if isRawFormat(opts.outputFormat) {
+		return writeRaw(w, img, opts.outputFormat)
+	}
+	if opts.outputFormat == formatPNG {
+		data, err := encodePNG(img, exif)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	if b := img.Bounds(); b.Dx() > maxJPEGDimension || b.Dy() > maxJPEGDimension {
+		return fmt.Errorf("%dx%d is too large for JPEG; use --output-format png, ppm or raw-rgba", b.Dx(), b.Dy())
+	}
+	encoded := getBuffer(source.Len())
+	defer putBuffer(encoded)
+	if err := encodeJPEG(encoded, img, exif, "stdout", opts); err != nil {
+		return err
+	}
+	_, err = encoded.WriteTo(w)
+	return err
+}
+
+// runStream runs stdin/stdout mode on the process's standard streams.
+func runStream(opts options) error {
+	out := bufio.NewWriter(os.Stdout)
+	if err := convertStream(os.Stdin, out, opts); err != nil {
+		return err
+	}
+	return out.Flush()
+}
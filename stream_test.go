@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestConvertStream(t *testing.T) {
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := convertStream(bytes.NewReader(data), &out, defaultOptions()); err != nil {
+		t.Fatal(err)
+	}
+	config, err := jpeg.DecodeConfig(&out)
+	if err != nil || config.Width != 1596 || config.Height != 1064 {
+		t.Fatalf("stdout is not the 1596x1064 JPEG: %+v, %v", config, err)
+	}
+
+	opts := defaultOptions()
+	opts.outputFormat = formatPPM
+	out.Reset()
+	if err := convertStream(bytes.NewReader(data), &out, opts); err != nil {
+		t.Fatal(err)
+	}
+	header := fmt.Sprintf("P6\n%d %d\n255\n", 1596, 1064)
+	if !bytes.HasPrefix(out.Bytes(), []byte(header)) || out.Len() != len(header)+1596*1064*3 {
+		t.Errorf("unexpected PPM of %d bytes starting %q", out.Len(), out.Bytes()[:16])
+	}
+
+	if err := convertStream(bytes.NewReader(nil), io.Discard, opts); err == nil {
+		t.Error("expected an error for empty input")
+	}
+}
+
+func TestParseOptionsStream(t *testing.T) {
+	opts, err := parseOptions([]string{"-", "--output-format", "raw-rgba"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.inputPath != stdioPath || opts.outputFormat != formatRawRGBA {
+		t.Errorf("got input %q, format %q", opts.inputPath, opts.outputFormat)
+	}
+	for _, args := range [][]string{
+		{"-", "--output-format", "pdf"},
+		{"-", "--only-if-smaller"},
+		{"-", "--output-dir", "out"},
+		{"--only-if-smaller", "--output-format", "ppm"},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+)
+
+// errMetadataLoss fails a file under --strict-metadata when a metadata block
+// the --keep-metadata policy keeps could not be carried into its output.
+var errMetadataLoss = errors.New("metadata would be lost")
+
+// metadataLosses lists the blocks of the source ra that the output of a
+// conversion with the per-file opts, its EXIF block exif and its pixels of
+// bounds would silently go without: blocks that could not be read, that are
+// too large for a JPEG segment, or that the output format has no place for.
+// Blocks left out by the policy, or replaced by --target-profile, are not
+// losses.
+func metadataLosses(ra io.ReaderAt, exif []byte, bounds image.Rectangle, opts options) []string {
+	var losses []string
+	png := outputKind(bounds, opts) == outputPNG
+	if opts.metadata.exif && len(exif) > maxAPP1Payload && !png {
+		losses = append(losses, fmt.Sprintf("EXIF block of %d bytes exceeds the JPEG APP1 limit", len(exif)))
+	}
+	if opts.metadata.xmp && hasXMPItem(ra) {
+		switch {
+		case len(opts.xmpPacket) == 0:
+			losses = append(losses, "XMP packet could not be read")
+		case png:
+			losses = append(losses, "XMP packet has no place in a PNG output")
+		case len(opts.xmpPacket) > maxXMPPacket:
+			losses = append(losses, fmt.Sprintf("XMP packet of %d bytes needs extended XMP", len(opts.xmpPacket)))
+		}
+	}
+	if opts.metadata.icc && opts.targetProfile == nil && hasICCProperty(ra) {
+		switch {
+		case len(opts.iccProfile) == 0:
+			losses = append(losses, "ICC profile could not be read")
+		case png:
+			losses = append(losses, "ICC profile has no place in a PNG output")
+		}
+	}
+	return losses
+}
+
+// metadataLossError is the --strict-metadata failure for losses.
+func metadataLossError(losses []string) error {
+	return fmt.Errorf("%w: %s", errMetadataLoss, strings.Join(losses, "; "))
+}
+
+// hasXMPItem reports whether the HEIC ra holds an XMP packet item.
+func hasXMPItem(ra io.ReaderAt) bool {
+	infos, err := heifItemInfos(ra)
+	if err != nil {
+		return false
+	}
+	for _, info := range infos {
+		if info.ItemType == "mime" && info.ContentType == xmpContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// hasICCProperty reports whether the primary image of ra carries an ICC
+// profile, rather than only nclx colour information.
+func hasICCProperty(ra io.ReaderAt) bool {
+	for _, body := range colrBodies(ra) {
+		if len(body) >= 4 && (string(body[:4]) == "prof" || string(body[:4]) == "rICC") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// buildXMPJPEGHEIF is buildJPEGHEIF with a second item declared as an XMP
+// packet but given no location, so it cannot be read.
+func buildXMPJPEGHEIF(bitstream []byte) []byte {
+	u16 := func(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+	u32 := func(v int) []byte { return binary.BigEndian.AppendUint32(nil, uint32(v)) }
+	ftyp := testBox("ftyp", []byte("mif1"), u32(0), []byte("mif1heic"))
+	meta := func(offset int) []byte {
+		return testFullBox("meta",
+			testFullBox("hdlr", u32(0), []byte("pict"), make([]byte, 13)),
+			testFullBox("pitm", u16(1)),
+			testFullBox("iinf", u16(2),
+				testBox("infe", []byte{2, 0, 0, 0}, u16(1), u16(0), []byte("jpeg\x00")),
+				testBox("infe", []byte{2, 0, 0, 0}, u16(2), u16(0), []byte("mime\x00"+xmpContentType+"\x00"))),
+			testFullBox("iloc", []byte{0x44, 0x00}, u16(1), u16(1), u16(0), u16(1), u32(offset), u32(len(bitstream))),
+		)
+	}
+	offset := len(ftyp) + len(meta(0)) + 8
+	return bytes.Join([][]byte{ftyp, meta(offset), testBox("mdat", bitstream)}, nil)
+}
+
+func TestMetadataLosses(t *testing.T) {
+	withXMP := bytes.NewReader(buildXMPJPEGHEIF(testJPEG(t)))
+	plain := bytes.NewReader(buildJPEGHEIF(testJPEG(t)))
+	bounds := image.Rect(0, 0, 64, 48)
+	opts := defaultOptions()
+
+	if losses := metadataLosses(plain, nil, bounds, opts); len(losses) != 0 {
+		t.Errorf("losses without XMP or ICC: %v", losses)
+	}
+	if losses := metadataLosses(withXMP, nil, bounds, opts); len(losses) != 1 || !strings.Contains(losses[0], "XMP") {
+		t.Errorf("unreadable XMP: got %v", losses)
+	}
+	opts.metadata.xmp = false
+	if losses := metadataLosses(withXMP, nil, bounds, opts); len(losses) != 0 {
+		t.Errorf("XMP dropped by the policy counted as lost: %v", losses)
+	}
+
+	opts = defaultOptions()
+	if losses := metadataLosses(plain, make([]byte, maxAPP1Payload+1), bounds, opts); len(losses) != 1 || !strings.Contains(losses[0], "EXIF") {
+		t.Errorf("oversized EXIF: got %v", losses)
+	}
+	opts.metadata.exif = false
+	if losses := metadataLosses(plain, make([]byte, maxAPP1Payload+1), bounds, opts); len(losses) != 0 {
+		t.Errorf("EXIF dropped by the policy counted as lost: %v", losses)
+	}
+}
+
+func TestStrictMetadataFailsAndQuarantines(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.HEIC"), buildXMPJPEGHEIF(testJPEG(t)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, "IMG_0001.HEIC"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegDir := t.TempDir()
+	opts := defaultOptions()
+	opts.strictMetadata = true
+	opts.quarantineDir = filepath.Join(dir, "quarantine")
+	result := processFile(manifestEntry{path: "IMG_0001.HEIC", info: info}, dir, jpegDir, opts)
+
+	if !errors.Is(result.err, errMetadataLoss) || errorCode(result.err) != codeMetadataLoss {
+		t.Fatalf("got %v, want a metadata loss", result.err)
+	}
+	if entries, _ := os.ReadDir(jpegDir); len(entries) != 0 {
+		t.Errorf("output written despite the loss: %d entries", len(entries))
+	}
+	if want := filepath.Join(opts.quarantineDir, "IMG_0001.HEIC"); result.quarantined != want {
+		t.Errorf("quarantined to %q, want %q", result.quarantined, want)
+	}
+
+	// Without the flag the same file converts, without its XMP.
+	if err := os.Rename(result.quarantined, filepath.Join(dir, "IMG_0001.HEIC")); err != nil {
+		t.Fatal(err)
+	}
+	opts.strictMetadata = false
+	if result := processFile(manifestEntry{path: "IMG_0001.HEIC", info: info}, dir, jpegDir, opts); result.err != nil {
+		t.Fatalf("conversion without --strict-metadata failed: %v", result.err)
+	}
+}
+
+func TestStrictMetadataOptions(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := parseOptions([]string{"--strict-metadata", "--output-format", "pdf", dir}, io.Discard); err == nil {
+		t.Error("--strict-metadata accepted with a PDF output")
+	}
+	opts, err := parseOptions([]string{"--strict-metadata", dir}, io.Discard)
+	if err != nil || !opts.strictMetadata {
+		t.Errorf("got %v, strict %v", err, opts.strictMetadata)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// syncManifestName is the file in the remote directory that records the hash
+// of every file --sync uploaded, since sftp cannot hash remote files.
+const syncManifestName = ".heictojpeg-sync.tsv"
+
+type syncEntry struct {
+	hash string
+	size int64
+}
+
+// remoteSync implements --sync: converted files are only uploaded when the
+// remote copy is missing or differs. The remote manifest says what was
+// uploaded before, and a listing of the remote directories confirms those
+// files are still there with the same size.
+type remoteSync struct {
+	target *sftpTarget
+
+	mu      sync.Mutex
+	entries map[string]syncEntry // keyed by slash-separated name
+}
+
+// loadRemoteSync fetches the manifest into stagingDir and drops the entries
+// whose files are gone from the remote or have a different size.
+func loadRemoteSync(target *sftpTarget, stagingDir string) (*remoteSync, error) {
+	s := &remoteSync{target: target, entries: map[string]syncEntry{}}
+	local := filepath.Join(stagingDir, syncManifestName)
+	if _, err := target.run(fmt.Sprintf("-get %q %q\n", path.Join(target.dir, syncManifestName), local)); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(local)
+	if os.IsNotExist(err) {
+		return s, nil // first sync to this destination
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	manifest := parseSyncManifest(f)
+
+	dirs := map[string]bool{}
+	for name := range manifest {
+		dirs[path.Dir(name)] = true
+	}
+	var script strings.Builder
+	for dir := range dirs {
+		fmt.Fprintf(&script, "-ls -ln %q\n", path.Join(target.dir, dir))
+	}
+	listing, err := target.run(script.String())
+	if err != nil {
+		return nil, err
+	}
+	sizes := parseListing(listing)
+	for name, entry := range manifest {
+		if size, ok := sizes[path.Join(target.dir, name)]; ok && size == entry.size {
+			s.entries[name] = entry
+		}
+	}
+	return s, nil
+}
+
+// parseSyncManifest reads "hash<TAB>size<TAB>name" lines.
+func parseSyncManifest(r io.Reader) map[string]syncEntry {
+	entries := map[string]syncEntry{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		entries[fields[2]] = syncEntry{hash: fields[0], size: size}
+	}
+	return entries
+}
+
+// parseListing returns the size of every regular file in the output of sftp
+// "ls -ln" commands on absolute directories, keyed by remote path.
+func parseListing(listing string) map[string]int64 {
+	sizes := map[string]int64{}
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		// -rw-r--r--  1 1000  1000  240522 Oct 15 09:06 /photos/IMG 1.jpg
+		if len(fields) < 9 || !strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			continue
+		}
+		// Take the name from the line itself so spaces in it survive.
+		i := strings.Index(line, " "+fields[8])
+		if i < 0 {
+			continue
+		}
+		sizes[path.Clean(line[i+1:])] = size
+	}
+	return sizes
+}
+
+// upload uploads localPath as name unless the remote already has it, and
+// reports whether it did.
+func (s *remoteSync) upload(localPath, name string) (bool, error) {
+	name = filepath.ToSlash(name)
+	entry, err := hashFile(localPath)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	previous, ok := s.entries[name]
+	s.mu.Unlock()
+	if ok && previous == entry {
+		return false, nil
+	}
+
+	if err := s.target.upload(localPath, name); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	s.entries[name] = entry
+	s.mu.Unlock()
+	return true, nil
+}
+
+func hashFile(path string) (syncEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return syncEntry{}, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return syncEntry{}, err
+	}
+	return syncEntry{hash: hex.EncodeToString(h.Sum(nil)), size: size}, nil
+}
+
+// save writes the manifest to stagingDir and uploads it.
+func (s *remoteSync) save(stagingDir string) error {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var manifest strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&manifest, "%s\t%d\t%s\n", s.entries[name].hash, s.entries[name].size, name)
+	}
+	s.mu.Unlock()
+
+	local := filepath.Join(stagingDir, syncManifestName)
+	if err := os.WriteFile(local, []byte(manifest.String()), 0644); err != nil {
+		return err
+	}
+	return s.target.upload(local, syncManifestName)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseListing(t *testing.T) {
+	listing := "sftp> -ls -ln \"/photos/jpegs\"\n" +
+		"-rw-r--r--    1 1000     1000       240522 Oct 15 09:06 /photos/jpegs/IMG_0001.jpg\n" +
+		"-rw-r--r--    1 1000     1000         1500 Jan  2  2024 /photos/jpegs/Trip 2024/IMG 2.jpg\n" +
+		"drwxr-xr-x    2 1000     1000         4096 Oct 15 09:06 /photos/jpegs/Japan\n"
+	sizes := parseListing(listing)
+	if len(sizes) != 2 || sizes["/photos/jpegs/IMG_0001.jpg"] != 240522 || sizes["/photos/jpegs/Trip 2024/IMG 2.jpg"] != 1500 {
+		t.Errorf("got %v", sizes)
+	}
+}
+
+func TestParseSyncManifest(t *testing.T) {
+	entries := parseSyncManifest(strings.NewReader("abc\t12\tJapan/Kyoto/IMG 1.jpg\nbroken line\ndef\tx\tIMG_2.jpg\n"))
+	if len(entries) != 1 || entries["Japan/Kyoto/IMG 1.jpg"] != (syncEntry{"abc", 12}) {
+		t.Errorf("got %v", entries)
+	}
+}
+
+// TestRemoteSyncUpload runs against a fake sftp client that records the
+// batch scripts it is given.
+func TestRemoteSyncUpload(t *testing.T) {
+	bin := t.TempDir()
+	scripts := filepath.Join(bin, "scripts.txt")
+	fake := "#!/bin/sh\ncat >> " + scripts + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "sftp"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	staging := t.TempDir()
+	target := &sftpTarget{host: "nas", dir: "/photos"}
+	s, err := loadRemoteSync(target, staging)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.entries) != 0 {
+		t.Fatalf("a destination without a manifest should be empty, got %v", s.entries)
+	}
+
+	local := filepath.Join(staging, "IMG_0001.jpg")
+	if err := os.WriteFile(local, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded, err := s.upload(local, "IMG_0001.jpg"); err != nil || !uploaded {
+		t.Fatalf("first upload: %v, %v", uploaded, err)
+	}
+	if uploaded, err := s.upload(local, "IMG_0001.jpg"); err != nil || uploaded {
+		t.Fatalf("unchanged file was uploaded again: %v", err)
+	}
+	if err := os.WriteFile(local, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded, _ := s.upload(local, "IMG_0001.jpg"); !uploaded {
+		t.Fatal("changed file was not uploaded")
+	}
+	if err := s.save(staging); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, _ := os.ReadFile(scripts)
+	if n := strings.Count(string(recorded), `"/photos/IMG_0001.jpg"`); n != 2 {
+		t.Errorf("got %d uploads, want 2:\n%s", n, recorded)
+	}
+	if !strings.Contains(string(recorded), `"/photos/`+syncManifestName+`"`) {
+		t.Errorf("manifest was not uploaded:\n%s", recorded)
+	}
+	manifest, _ := os.ReadFile(filepath.Join(staging, syncManifestName))
+	if !strings.HasSuffix(string(manifest), "\t6\tIMG_0001.jpg\n") {
+		t.Errorf("unexpected manifest %q", manifest)
+	}
+}
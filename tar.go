@@ -0,0 +1,101 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// tarStream is the --output-tar archive. Converted files are appended to it
+// as they finish and removed from the staging directory, so a run needs no
+// more local room than the files in progress, and `--output-tar -` can be
+// piped straight into `ssh host tar -x`.
+type tarStream struct {
+	mu    sync.Mutex
+	tw    *tar.Writer
+	file  *os.File // the archive, nil when it goes to stdout
+	count int
+}
+
+// openTarStream creates the --output-tar archive, or writes it to stdout for
+// "-". main points os.Stdout at stderr for the run, so the original stdout
+// is passed in.
+func openTarStream(path string, stdout io.Writer) (*tarStream, error) {
+	if path == stdioPath {
+		return &tarStream{tw: tar.NewWriter(stdout)}, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &tarStream{tw: tar.NewWriter(f), file: f}, nil
+}
+
+// add appends the file or folder name in jpegDir to the archive under its
+// path relative to jpegDir, and removes it once it is archived.
+func (t *tarStream) add(jpegDir, name string) error {
+	root := filepath.Join(jpegDir, name)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(jpegDir, path)
+		if err != nil {
+			return err
+		}
+		return t.addFile(path, filepath.ToSlash(rel))
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(root)
+}
+
+func (t *tarStream) addFile(path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := io.Copy(t.tw, f); err != nil {
+		return err
+	}
+	t.count++
+	return t.tw.Flush()
+}
+
+// archived is how many files have been added so far.
+func (t *tarStream) archived() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// close writes the end of the archive.
+func (t *tarStream) close() error {
+	err := t.tw.Close()
+	if t.file != nil {
+		if closeErr := t.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readTar returns the entries of a tar archive by name, in order.
+func readTar(t *testing.T, data []byte) ([]string, map[string][]byte) {
+	t.Helper()
+	var names []string
+	files := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+		files[header.Name] = body
+	}
+}
+
+func TestTarStream(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.jpg"), []byte("jpeg a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "tiles", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tiles", "b", "0_0.jpg"), []byte("tile"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	stream, err := openTarStream(stdioPath, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.jpg", filepath.Join("tiles", "b")} {
+		if err := stream.add(dir, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stream.archived() != 2 {
+		t.Errorf("archived %d files, want 2", stream.archived())
+	}
+	if err := stream.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, files := readTar(t, out.Bytes())
+	if strings.Join(names, " ") != "a.jpg tiles/b/0_0.jpg" || string(files["a.jpg"]) != "jpeg a" {
+		t.Errorf("unexpected archive %q", names)
+	}
+	// Archived files are removed from the staging directory.
+	for _, name := range []string{"a.jpg", "tiles/b"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s left behind (%v)", name, err)
+		}
+	}
+}
+
+func TestOutputTar(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--output-tar", "-", "--sidecar", "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	opts.tarStdout = &out
+	staging, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, files := readTar(t, out.Bytes())
+	if len(names) != 5 || names[len(names)-1] != logFileName {
+		t.Fatalf("unexpected archive %q", names)
+	}
+	for _, name := range []string{"a.jpg", "b.jpg", "a.jpg.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("%s missing from %q", name, names)
+		}
+	}
+	if !bytes.HasPrefix(files["a.jpg"], []byte{0xff, 0xd8}) {
+		t.Error("a.jpg is not a JPEG")
+	}
+	if !strings.Contains(string(files[logFileName]), "Tar==- (4 files)") {
+		t.Errorf("no Tar line in log:\n%s", files[logFileName])
+	}
+	// Nothing is left locally: not the staging directory, nor a jpegs
+	// folder next to the sources.
+	for _, path := range []string{staging, filepath.Join(dir, "jpegs")} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s exists (%v)", path, err)
+		}
+	}
+}
+
+func TestParseOptionsOutputTar(t *testing.T) {
+	for _, args := range [][]string{
+		{"--output-tar", "-", "--output-zip", "out.zip"},
+		{"--output-tar", "-", "--output-dir", "out"},
+		{"--output-tar", "-", "--output-format", "pdf"},
+		{"--output-tar", "out.tar", "-"},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
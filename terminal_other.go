@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "os"
+
+// keyInputPolls is false as reads of the terminal block until Enter.
+const keyInputPolls = false
+
+// enableKeyInput leaves the terminal as it is; keys take effect once Enter
+// is pressed.
+func enableKeyInput(f *os.File) (func(), error) {
+	return func() {}, nil
+}
+
+// terminalSize does not know the size of the terminal here.
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// keyInputPolls tells keyInput that reads of a terminal set up by
+// enableKeyInput come back empty when no key was pressed.
+const keyInputPolls = true
+
+// enableKeyInput switches the terminal on f to unbuffered, unechoed input so
+// single key presses can be read, and returns a function restoring it.
+// Reads return empty-handed after a tenth of a second without a key press,
+// so that a reader can notice it should stop.
+func enableKeyInput(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	saved, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+	raw := *saved
+	raw.Lflag &^= unix.ICANON | unix.ECHO
+	raw.Cc[unix.VMIN] = 0
+	raw.Cc[unix.VTIME] = 1
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &raw); err != nil {
+		return nil, err
+	}
+	return func() { unix.IoctlSetTermios(fd, ioctlWriteTermios, saved) }, nil
+}
+
+// terminalSize returns the width and height of the terminal on f in
+// characters.
+func terminalSize(f *os.File) (width, height int, ok bool) {
+	ws, err := unix.IoctlGetWinsize(int(f.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 0, 0, false
+	}
+	return int(ws.Col), int(ws.Row), true
+}
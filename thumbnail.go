@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+)
+
+const (
+	thumbnailMaxWidth  = 160
+	thumbnailMaxHeight = 120
+	thumbnailQuality   = 70
+
+	// maxAPP1Payload is the largest EXIF block one APP1 segment can hold.
+	maxAPP1Payload = 0xffff - 2
+)
+
+// embedThumbnail returns rawExif with a small JPEG preview of img stored in
+// IFD1, the way cameras do. A minimal EXIF block is created when rawExif is
+// empty. Any thumbnail already present is replaced.
+func embedThumbnail(rawExif []byte, img image.Image) ([]byte, error) {
+	block := newExifBlock()
+	if len(rawExif) > 0 {
+		parsed, err := parseExifBlock(rawExif)
+		if err != nil {
+			return nil, err
+		}
+		block = parsed
+	}
+
+	b := img.Bounds()
+	width, height := fitDimensions(b.Dx(), b.Dy(), thumbnailMaxWidth, thumbnailMaxHeight)
+	var thumb bytes.Buffer
+	if err := jpeg.Encode(&thumb, scaleImage(img, width, height), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+
+	block.thumbnail = thumb.Bytes()
+	block.ifd1 = append(block.resolutionEntries(), block.short(tagCompression, 6))
+
+	encoded := block.encode()
+	if len(encoded) > maxAPP1Payload {
+		return nil, fmt.Errorf("EXIF with thumbnail is %d bytes, exceeding the APP1 limit", len(encoded))
+	}
+	return encoded, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"path/filepath"
+	"strings"
+)
+
+// maxJPEGDimension is the largest width or height a baseline JPEG can
+// record; image/jpeg refuses anything bigger.
+const maxJPEGDimension = 65535
+
+const (
+	defaultTileSize    = 65500
+	defaultTileOverlap = 256
+)
+
+// formatPNG is --output-format png: lossless PNGs with the EXIF block, as
+// otherwise written only for images too large for JPEG.
+const formatPNG = "png"
+
+// writeOutput writes img to output as a JPEG, or in a raw or PNG
+// --output-format under a name with its extension. Images wider or taller
+// than --tile-size are split into overlapping JPEG tiles when --tile is set;
+// otherwise images beyond the JPEG limit are saved as PNG instead. It
+// returns the path written (the first tile when tiling).
+func writeOutput(img image.Image, exif []byte, output string, sizeHint int, opts options) (string, error) {
+	switch outputKind(img.Bounds(), opts) {
+	case outputRaw:
+		return writeRawFile(img, output, opts)
+	case outputTiles:
+		return writeTiles(img, exif, output, sizeHint, opts)
+	case outputPNG:
+		b := img.Bounds()
+		pngOutput := strings.TrimSuffix(output, filepath.Ext(output)) + ".png"
+		if opts.outputFormat != formatPNG {
+			fmt.Printf("%s is %dx%d, too large for JPEG; saving as PNG\n", filepath.Base(output), b.Dx(), b.Dy())
+		}
+		return pngOutput, writePNG(img, exif, pngOutput, opts)
+	}
+	return output, writeJPEG(img, exif, output, sizeHint, opts)
+}
+
+// What writeOutput writes for an image.
+const (
+	outputJPEG  = iota // a single JPEG
+	outputRaw          // a raw format; these have no size limit, so are never tiled
+	outputTiles        // --tile JPEG tiles
+	outputPNG          // a PNG, for --output-format png or images too large for JPEG
+)
+
+func outputKind(b image.Rectangle, opts options) int {
+	switch {
+	case isRawFormat(opts.outputFormat):
+		return outputRaw
+	case opts.outputFormat == formatPNG:
+		return outputPNG
+	case opts.tile && (b.Dx() > opts.tileSize || b.Dy() > opts.tileSize):
+		return outputTiles
+	case b.Dx() > maxJPEGDimension || b.Dy() > maxJPEGDimension:
+		return outputPNG
+	}
+	return outputJPEG
+}
+
+// tileRects covers bounds with tiles at most size pixels on each side that
+// overlap their neighbours by overlap pixels, row by row.
+func tileRects(bounds image.Rectangle, size, overlap int) []image.Rectangle {
+	starts := func(min, max int) []int {
+		var s []int
+		for start := min; ; start += size - overlap {
+			if start+size >= max {
+				// The last tile is aligned to the far edge so all tiles
+				// have the full size.
+				last := max - size
+				if last < min {
+					last = min
+				}
+				return append(s, last)
+			}
+			s = append(s, start)
+		}
+	}
+
+	var rects []image.Rectangle
+	for _, y := range starts(bounds.Min.Y, bounds.Max.Y) {
+		for _, x := range starts(bounds.Min.X, bounds.Max.X) {
+			rects = append(rects, image.Rect(x, y, x+size, y+size).Intersect(bounds))
+		}
+	}
+	return rects
+}
+
+// tileName returns output with a _tileNN suffix before the extension.
+func tileName(output string, i, n int) string {
+	ext := filepath.Ext(output)
+	width := len(fmt.Sprint(n))
+	if width < 2 {
+		width = 2
+	}
+	return fmt.Sprintf("%s_tile%0*d%s", strings.TrimSuffix(output, ext), width, i+1, ext)
+}
+
+func writeTiles(img image.Image, exif []byte, output string, sizeHint int, opts options) (string, error) {
+	rects := tileRects(img.Bounds(), opts.tileSize, opts.tileOverlap)
+	opts.sourceSize = 0 // tiles are not held to --only-if-smaller
+	fmt.Printf("Splitting %s into %d tiles\n", filepath.Base(output), len(rects))
+
+	for i, r := range rects {
+		var tile image.Image
+		if sub, ok := img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}); ok {
+			tile = sub.SubImage(r)
+		} else {
+			rgba := image.NewRGBA(r)
+			draw.Draw(rgba, r, img, r.Min, draw.Src)
+			tile = rgba
+		}
+		if err := writeJPEG(tile, exif, tileName(output, i, len(rects)), sizeHint/len(rects), opts); err != nil {
+			return "", err
+		}
+	}
+	return tileName(output, 0, len(rects)), nil
+}
+
+// writePNG saves img as a PNG, carrying the EXIF block in an eXIf chunk.
+func writePNG(img image.Image, exif []byte, output string, opts options) error {
+	data, err := encodePNG(img, exif)
+	if err != nil {
+		return err
+	}
+	return writeEncoded(output, bytes.NewBuffer(data), nil, opts)
+}
+
+// encodePNG encodes img as a PNG with the EXIF block in an eXIf chunk.
+func encodePNG(img image.Image, exif []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if len(exif) > 0 {
+		data = insertPNGExif(data, bytes.TrimPrefix(exif, exifHeader))
+	}
+	return data, nil
+}
+
+// insertPNGExif adds an eXIf chunk holding tiffData right after the IHDR
+// chunk of an encoded PNG.
+func insertPNGExif(data, tiffData []byte) []byte {
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4 // signature, IHDR length, type, data and CRC
+	if len(data) < ihdrEnd {
+		return data
+	}
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiffData)))
+	chunk = append(chunk, "eXIf"...)
+	chunk = append(chunk, tiffData...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
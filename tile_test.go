@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTileRects(t *testing.T) {
+	rects := tileRects(image.Rect(0, 0, 250, 80), 100, 10)
+	want := []image.Rectangle{
+		image.Rect(0, 0, 100, 80),
+		image.Rect(90, 0, 190, 80),
+		image.Rect(150, 0, 250, 80),
+	}
+	if len(rects) != len(want) {
+		t.Fatalf("got %v, want %v", rects, want)
+	}
+	for i := range want {
+		if rects[i] != want[i] {
+			t.Fatalf("got %v, want %v", rects, want)
+		}
+	}
+
+	if n := len(tileRects(image.Rect(0, 0, 250, 250), 100, 10)); n != 9 {
+		t.Fatalf("got %d tiles for a 250x250 grid, want 9", n)
+	}
+}
+
+func TestWriteOutputTiles(t *testing.T) {
+	dir := t.TempDir()
+	opts := defaultOptions()
+	opts.tile = true
+	opts.tileSize = 100
+	opts.tileOverlap = 10
+
+	output := filepath.Join(dir, "pano.jpg")
+	written, err := writeOutput(image.NewRGBA(image.Rect(0, 0, 250, 80)), nil, output, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != filepath.Join(dir, "pano_tile01.jpg") {
+		t.Fatalf("unexpected first tile %s", written)
+	}
+	for i, width := range []int{100, 100, 100} {
+		f, err := os.Open(tileName(output, i, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, err := jpeg.DecodeConfig(f)
+		f.Close()
+		if err != nil || config.Width != width || config.Height != 80 {
+			t.Fatalf("tile %d: %dx%d, %v", i+1, config.Width, config.Height, err)
+		}
+	}
+}
+
+func TestWriteOutputPNGFallback(t *testing.T) {
+	dir := t.TempDir()
+	exif := buildTestExif([]testTag{asciiTag(0x0110, "iPhone 15 Pro")}, nil, nil)
+
+	written, err := writeOutput(image.NewGray(image.Rect(0, 0, maxJPEGDimension+1, 2)), exif, filepath.Join(dir, "pano.jpg"), 0, defaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(written) != ".png" {
+		t.Fatalf("expected a PNG fallback, got %s", written)
+	}
+	data, err := os.ReadFile(written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("PNG with eXIf chunk does not decode: %v", err)
+	}
+	if !bytes.Contains(data, []byte("eXIfII*\x00")) {
+		t.Fatal("EXIF not stored in an eXIf chunk")
+	}
+}
+
+func TestWriteOutputPNGFormat(t *testing.T) {
+	opts := defaultOptions()
+	opts.outputFormat = formatPNG
+	written, err := writeOutput(image.NewGray(image.Rect(0, 0, 4, 2)), nil, filepath.Join(t.TempDir(), "small.jpg"), 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Ext(written) != ".png" {
+		t.Fatalf("expected a PNG, got %s", written)
+	}
+}
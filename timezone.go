@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// exifCaptureTime is when the photo with the EXIF block rawExif was taken:
+// DateTimeOriginal, or DateTime without it, in the time zone its OffsetTime
+// tag (EXIF 2.31) records. Timestamps without one are read in assume, the
+// --assume-timezone, or the local time zone. zoned reports whether the
+// offset was recorded; ok is false when there is no usable timestamp.
+func exifCaptureTime(rawExif []byte, assume *time.Location) (t time.Time, zoned, ok bool) {
+	if len(rawExif) == 0 {
+		return time.Time{}, false, false
+	}
+	block, err := parseExifBlock(rawExif)
+	if err != nil {
+		return time.Time{}, false, false
+	}
+	stamps := []struct {
+		entries   []tiffEntry
+		tag       uint16
+		offsetTag uint16
+	}{
+		{block.exif, tagDateTimeOriginal, tagOffsetTimeOriginal},
+		{block.ifd0, tagDateTime, tagOffsetTime},
+	}
+	for _, stamp := range stamps {
+		entry := findEntry(stamp.entries, stamp.tag)
+		if entry == nil {
+			continue
+		}
+		zone, zoned := assumedZone(assume), false
+		if offset := findEntry(block.exif, stamp.offsetTag); offset != nil {
+			// Unknown offsets are recorded as "   :  ".
+			value := string(bytes.TrimRight(offset.value, "\x00 "))
+			if strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-") {
+				if recorded, err := parseTimezone(value); err == nil {
+					zone, zoned = recorded, true
+				}
+			}
+		}
+		t, err := time.ParseInLocation(exifTimeLayout, string(bytes.TrimRight(entry.value, "\x00 ")), zone)
+		if err == nil {
+			return t, zoned, true
+		}
+	}
+	return time.Time{}, false, false
+}
+
+// captureTimeIn is exifCaptureTime, falling back to fallback, such as the
+// file's modification time, shown in assume when there is no timestamp.
+func captureTimeIn(rawExif []byte, fallback time.Time, assume *time.Location) time.Time {
+	if t, _, ok := exifCaptureTime(rawExif, assume); ok {
+		return t
+	}
+	if fallback.IsZero() {
+		return fallback
+	}
+	return fallback.In(assumedZone(assume))
+}
+
+// assumedZone is the --assume-timezone, or the local time zone.
+func assumedZone(assume *time.Location) *time.Location {
+	if assume == nil {
+		return time.Local
+	}
+	return assume
+}
+
+// inZone is the "in" name template function: t in another time zone, given
+// as for --set-timezone, e.g. {{.Taken | in "UTC" | date "2006-01-02"}}.
+func inZone(zone string, t time.Time) (time.Time, error) {
+	loc, err := parseTimezone(zone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.In(loc), nil
+}
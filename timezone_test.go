@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestExifCaptureTime(t *testing.T) {
+	tokyo := time.FixedZone("+09:00", 9*3600)
+	cases := []struct {
+		name   string
+		raw    []byte
+		assume *time.Location
+		want   time.Time
+		zoned  bool
+	}{
+		{
+			name: "recorded offset",
+			raw: buildTestExif(nil, []testTag{
+				asciiTag(tagDateTimeOriginal, "2023:07:01 23:30:00"),
+				asciiTag(tagOffsetTimeOriginal, "+09:00"),
+			}, nil),
+			want:  time.Date(2023, 7, 1, 14, 30, 0, 0, time.UTC),
+			zoned: true,
+		},
+		{
+			name:   "assumed zone",
+			raw:    buildTestExif(nil, []testTag{asciiTag(tagDateTimeOriginal, "2023:07:01 23:30:00")}, nil),
+			assume: tokyo,
+			want:   time.Date(2023, 7, 1, 14, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "unknown offset",
+			raw: buildTestExif(nil, []testTag{
+				asciiTag(tagDateTimeOriginal, "2023:07:01 23:30:00"),
+				asciiTag(tagOffsetTimeOriginal, "   :  "),
+			}, nil),
+			assume: time.UTC,
+			want:   time.Date(2023, 7, 1, 23, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "modification time with its offset",
+			raw: buildTestExif([]testTag{asciiTag(tagDateTime, "2023:07:02 00:10:00")}, []testTag{
+				asciiTag(tagOffsetTime, "-05:00"),
+			}, nil),
+			want:  time.Date(2023, 7, 2, 5, 10, 0, 0, time.UTC),
+			zoned: true,
+		},
+	}
+	for _, c := range cases {
+		got, zoned, ok := exifCaptureTime(c.raw, c.assume)
+		if !ok || !got.Equal(c.want) || zoned != c.zoned {
+			t.Errorf("%s: got %v (zoned %v, ok %v), want %v (zoned %v)", c.name, got, zoned, ok, c.want, c.zoned)
+		}
+	}
+	// The day is the one on the camera's clock, whatever the local zone.
+	got, _, _ := exifCaptureTime(cases[0].raw, time.UTC)
+	if day := got.Format("2006-01-02"); day != "2023-07-01" {
+		t.Errorf("day %s, want 2023-07-01", day)
+	}
+
+	if _, _, ok := exifCaptureTime(nil, nil); ok {
+		t.Error("timestamp without EXIF")
+	}
+	if _, _, ok := exifCaptureTime(buildTestExif([]testTag{asciiTag(0x010f, "Apple")}, nil, nil), nil); ok {
+		t.Error("timestamp without a date tag")
+	}
+}
+
+func TestCaptureTimeIn(t *testing.T) {
+	// Modified at 00:30 on the 2nd in Tokyo, still the 1st in UTC.
+	modified := time.Date(2023, 7, 1, 15, 30, 0, 0, time.UTC)
+	tokyo := time.FixedZone("+09:00", 9*3600)
+	if got := captureTimeIn(nil, modified, tokyo).Format("2006-01-02 15:04"); got != "2023-07-02 00:30" {
+		t.Errorf("got %s", got)
+	}
+	if got := captureTimeIn(nil, time.Time{}, tokyo); !got.IsZero() {
+		t.Errorf("no fallback: got %v", got)
+	}
+}
+
+func TestNameTemplateTimezone(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.HEIC"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	raw := buildTestExif(nil, []testTag{
+		asciiTag(tagDateTimeOriginal, "2023:07:01 23:30:00"),
+		asciiTag(tagOffsetTimeOriginal, "+09:00"),
+	}, nil)
+	tmpl := template.Must(template.New("t").Funcs(nameTemplateFuncs).Parse(`{{.Taken | date "2006-01-02"}}/{{.Taken | in "UTC" | date "15h"}}`))
+	opts := defaultOptions()
+	opts.nameTemplate = tmpl
+	name, err := outputName(dir, "IMG_0001.HEIC", raw, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("2023-07-01", "14h.jpg"); name != want {
+		t.Errorf("got %s, want %s", name, want)
+	}
+}
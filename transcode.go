@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// transcodeFormat is one format of the transcode matrix: whether sources in
+// it can be converted and the --output-format that writes it, if any.
+// missing says why the directions it cannot handle are not available.
+type transcodeFormat struct {
+	readable     bool
+	outputFormat string
+	missing      string
+}
+
+// transcodeFormats is the format registry of `transcode`. Reading goes
+// through the HEIF pipeline, writing through --output-format. AVIF, JPEG XL
+// and WebP have no codec built in and are not listed.
+var transcodeFormats = map[string]transcodeFormat{
+	"heic": {readable: true, missing: "no HEVC encoder is built in"},
+	"jpeg": {outputFormat: formatJPEG, missing: "only HEIF sources are read"},
+	"png":  {outputFormat: formatPNG, missing: "only HEIF sources are read"},
+}
+
+// transcodeAliases are other names accepted for registry formats.
+var transcodeAliases = map[string]string{"heif": "heic", "jpg": "jpeg"}
+
+// transcodeNames lists the formats usable in one direction.
+func transcodeNames(usable func(transcodeFormat) bool) string {
+	var names []string
+	for name, format := range transcodeFormats {
+		if usable(format) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// resolveTranscode checks --from and --to against the registry and returns
+// the --output-format to convert with.
+func resolveTranscode(from, to string) (string, error) {
+	if alias, ok := transcodeAliases[from]; ok {
+		from = alias
+	}
+	if alias, ok := transcodeAliases[to]; ok {
+		to = alias
+	}
+	source, ok := transcodeFormats[from]
+	if !ok {
+		return "", fmt.Errorf("unknown --from format %q (--from: %s)", from,
+			transcodeNames(func(f transcodeFormat) bool { return f.readable }))
+	}
+	target, ok := transcodeFormats[to]
+	if !ok {
+		return "", fmt.Errorf("unknown --to format %q (--to: %s)", to,
+			transcodeNames(func(f transcodeFormat) bool { return f.outputFormat != "" }))
+	}
+	if !source.readable {
+		return "", fmt.Errorf("cannot read %s: %s (--from: %s)", from, source.missing,
+			transcodeNames(func(f transcodeFormat) bool { return f.readable }))
+	}
+	if target.outputFormat == "" {
+		return "", fmt.Errorf("cannot write %s: %s (--to: %s)", to, target.missing,
+			transcodeNames(func(f transcodeFormat) bool { return f.outputFormat != "" }))
+	}
+	return target.outputFormat, nil
+}
+
+// runTranscodeCommand implements `heictojpeg transcode --from FORMAT --to
+// FORMAT [flags] [input]`, the classic conversion generalised over the
+// format registry. The other flags are those of a plain run.
+func runTranscodeCommand(args []string, output io.Writer) error {
+	from, to, rest, err := splitTranscodeArgs(args)
+	if err != nil {
+		return err
+	}
+	if from == "" || to == "" {
+		return fmt.Errorf("transcode needs --from (%s) and --to (%s)",
+			transcodeNames(func(f transcodeFormat) bool { return f.readable }),
+			transcodeNames(func(f transcodeFormat) bool { return f.outputFormat != "" }))
+	}
+	outputFormat, err := resolveTranscode(from, to)
+	if err != nil {
+		return err
+	}
+
+	opts, err := parseOptions(append([]string{"--output-format", outputFormat}, rest...), output)
+	if err != nil {
+		return err
+	}
+	if opts.outputFormat != outputFormat {
+		return fmt.Errorf("--output-format %s conflicts with --to %s", opts.outputFormat, to)
+	}
+	if opts.inputPath == stdioPath {
+		return runStream(opts)
+	}
+	_, err = run(opts)
+	return err
+}
+
+// splitTranscodeArgs takes --from and --to, with their values as the next
+// argument or after =, out of args.
+func splitTranscodeArgs(args []string) (from, to string, rest []string, err error) {
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || (name != "from" && name != "to") {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return "", "", nil, fmt.Errorf("flag needs an argument: --%s", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "from" {
+			from = strings.ToLower(value)
+		} else {
+			to = strings.ToLower(value)
+		}
+	}
+	return from, to, rest, nil
+}
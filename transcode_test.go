@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveTranscode(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		want     string
+		err      string
+	}{
+		{"heic", "jpeg", formatJPEG, ""},
+		{"heif", "jpg", formatJPEG, ""},
+		{"heic", "png", formatPNG, ""},
+		{"jpeg", "png", "", "cannot read jpeg: only HEIF sources are read (--from: heic)"},
+		{"heic", "heic", "", "cannot write heic: no HEVC encoder is built in (--to: jpeg, png)"},
+		{"avif", "jpeg", "", `unknown --from format "avif" (--from: heic)`},
+		{"heic", "webp", "", `unknown --to format "webp" (--to: jpeg, png)`},
+		{"heic", "gif", "", `unknown --to format "gif"`},
+	} {
+		got, err := resolveTranscode(tc.from, tc.to)
+		if tc.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("%s to %s: expected error %q, got %v", tc.from, tc.to, tc.err, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s to %s: got %q, %v", tc.from, tc.to, got, err)
+		}
+	}
+}
+
+func TestSplitTranscodeArgs(t *testing.T) {
+	from, to, rest, err := splitTranscodeArgs([]string{"--from", "HEIC", "--quality", "90", "-to=png", "photos"})
+	if err != nil || from != "heic" || to != "png" || !reflect.DeepEqual(rest, []string{"--quality", "90", "photos"}) {
+		t.Fatalf("got %q, %q, %q, %v", from, to, rest, err)
+	}
+	if _, _, _, err := splitTranscodeArgs([]string{"--to"}); err == nil {
+		t.Fatal("expected an error for --to without a value")
+	}
+}
+
+func TestRunTranscodeCommand(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := runTranscodeCommand([]string{"--from", "heic", "--to", "png", "--output-dir", out, "--non-interactive", dir}, os.Stderr); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(out, "camel.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if config, err := png.DecodeConfig(f); err != nil || config.Width != 1596 {
+		t.Fatalf("unexpected PNG: %+v, %v", config, err)
+	}
+
+	err = runTranscodeCommand([]string{"--from", "heic", "--to", "png", "--output-format", "jpeg", dir}, os.Stderr)
+	if err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Fatalf("expected a conflict error, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// trashCan moves files to the platform's trash (--use-trash), where they
+// can be restored from the file manager. newTrash returns the one of the
+// current platform; see trash_*.go.
+type trashCan interface {
+	put(path string) error
+}
+
+// removeSource deletes a converted source for --delete-source, or moves it
+// to the trash with --use-trash. It returns what happened, for the log. A
+// source that cannot be removed does not fail its conversion.
+func removeSource(path string, opts options) string {
+	if opts.trash != nil {
+		if err := opts.trash.put(path); err != nil {
+			return fmt.Sprintf("Source not moved to trash: %v", err)
+		}
+		return "Source moved to trash"
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Sprintf("Source not deleted: %v", err)
+	}
+	return "Source deleted"
+}
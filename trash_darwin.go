@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// finderTrash is the macOS trash: ~/.Trash for the startup disk and
+// .Trashes/UID at the top of other volumes, as the Finder uses them. Files
+// are shown in the Trash and can be dragged out, but Put Back, which needs
+// the Finder's own records, is not offered for them.
+type finderTrash struct {
+	home string
+}
+
+func newTrash() (trashCan, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("no trash folder: %v", err)
+	}
+	return &finderTrash{home: filepath.Join(home, ".Trash")}, nil
+}
+
+func (t *finderTrash) put(path string) error {
+	noRecord := func(string) (func(), error) { return func() {}, nil }
+	err := moveIntoTrash(path, t.home, noRecord)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	top, err := mountPoint(filepath.Dir(abs))
+	if err != nil {
+		return err
+	}
+	return moveIntoTrash(abs, filepath.Join(top, ".Trashes", fmt.Sprint(os.Getuid())), noRecord)
+}
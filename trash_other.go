@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly && !(windows && (amd64 || arm64))
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newTrash() (trashCan, error) {
+	return nil, fmt.Errorf("--use-trash is not supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeTrash records what it is given instead of moving it.
+type fakeTrash struct {
+	paths []string
+	err   error
+}
+
+func (t *fakeTrash) put(path string) error {
+	t.paths = append(t.paths, path)
+	return t.err
+}
+
+func TestRemoveSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.heic")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := removeSource(path, defaultOptions()); got != "Source deleted" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("source still there: %v", err)
+	}
+	if got := removeSource(path, defaultOptions()); !strings.HasPrefix(got, "Source not deleted: ") {
+		t.Errorf("got %q", got)
+	}
+
+	opts := defaultOptions()
+	trash := &fakeTrash{}
+	opts.trash = trash
+	if got := removeSource(path, opts); got != "Source moved to trash" || len(trash.paths) != 1 || trash.paths[0] != path {
+		t.Errorf("got %q, trashed %q", got, trash.paths)
+	}
+	trash.err = errors.New("full")
+	if got := removeSource(path, opts); got != "Source not moved to trash: full" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseOptionsDeleteSource(t *testing.T) {
+	if _, err := parseOptions([]string{"--use-trash"}, io.Discard); err == nil {
+		t.Error("expected error for --use-trash without --delete-source")
+	}
+	if _, err := parseOptions([]string{"--delete-source", "--output-zip", "out.zip"}, io.Discard); err == nil {
+		t.Error("expected error for --delete-source with --output-zip")
+	}
+	opts, err := parseOptions([]string{"--delete-source"}, io.Discard)
+	if err != nil || !opts.deleteSource || opts.trash != nil {
+		t.Errorf("unexpected options %v %v (%v)", opts.deleteSource, opts.trash, err)
+	}
+}
+
+func TestRunDeletesConvertedSources(t *testing.T) {
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.heic"), data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.inputPath = dir
+	opts.deleteSource = true
+	trash := &fakeTrash{}
+	opts.trash = trash
+	jpegDir, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.paths) != 1 || filepath.Base(trash.paths[0]) != "camel.heic" {
+		t.Errorf("trashed %q, want only the converted source", trash.paths)
+	}
+	log, _ := os.ReadFile(filepath.Join(jpegDir, logFileName))
+	if !strings.Contains(string(log), "camel.jpg") || !strings.Contains(string(log), "> Source moved to trash") {
+		t.Errorf("removal not logged:\n%s", log)
+	}
+}
@@ -0,0 +1,67 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// mountPoint returns the top directory of the filesystem holding path: the
+// highest ancestor on the same device.
+func mountPoint(path string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", err
+	}
+	dir := path
+	for {
+		parent := filepath.Dir(dir)
+		var up syscall.Stat_t
+		if parent == dir || syscall.Stat(parent, &up) != nil || up.Dev != st.Dev {
+			return dir, nil
+		}
+		dir = parent
+	}
+}
+
+// trashName returns the i-th candidate name for base in a trash folder,
+// numbered before the extension when files of that name are already there.
+func trashName(base string, i int) string {
+	if i == 1 {
+		return base
+	}
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(base, ext), i, ext)
+}
+
+// moveIntoTrash renames path into dir under the first free name, calling
+// record with that name first so the file is never in the trash without its
+// restore information. A rename to another filesystem fails with EXDEV.
+func moveIntoTrash(path, dir string, record func(name string) (undo func(), err error)) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	base := filepath.Base(path)
+	for i := 1; ; i++ {
+		name := trashName(base, i)
+		if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+			continue
+		}
+		undo, err := record(name)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(path, filepath.Join(dir, name)); err != nil {
+			undo()
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+//go:build windows && (amd64 || arm64)
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// SHFileOperationW and its SHFILEOPSTRUCTW, in the natural 64-bit layout
+// (32-bit Windows packs the structure to one byte, see trash_other.go).
+var procSHFileOperationW = windows.NewLazySystemDLL("shell32.dll").NewProc("SHFileOperationW")
+
+type shFileOpStruct struct {
+	hwnd                  uintptr
+	wFunc                 uint32
+	pFrom                 *uint16
+	pTo                   *uint16
+	fFlags                uint16
+	fAnyOperationsAborted int32
+	hNameMappings         uintptr
+	lpszProgressTitle     *uint16
+}
+
+const (
+	foDelete          = 0x0003
+	fofSilent         = 0x0004
+	fofNoConfirmation = 0x0010
+	fofAllowUndo      = 0x0040
+	fofNoErrorUI      = 0x0400
+)
+
+// recycleBin is the Windows Recycle Bin, reached through the shell so
+// files keep their original location for Restore.
+type recycleBin struct{}
+
+func newTrash() (trashCan, error) {
+	if err := procSHFileOperationW.Find(); err != nil {
+		return nil, fmt.Errorf("no Recycle Bin: %v", err)
+	}
+	return recycleBin{}, nil
+}
+
+func (recycleBin) put(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	// pFrom is a list of paths ended by an empty one.
+	from, err := windows.UTF16FromString(abs)
+	if err != nil {
+		return err
+	}
+	op := shFileOpStruct{
+		wFunc:  foDelete,
+		pFrom:  &append(from, 0)[0],
+		fFlags: fofAllowUndo | fofNoConfirmation | fofSilent | fofNoErrorUI,
+	}
+	if r, _, _ := procSHFileOperationW.Call(uintptr(unsafe.Pointer(&op))); r != 0 {
+		return fmt.Errorf("SHFileOperation failed with code %#x", r)
+	}
+	if op.fAnyOperationsAborted != 0 {
+		return fmt.Errorf("moving to the Recycle Bin was cancelled")
+	}
+	return nil
+}
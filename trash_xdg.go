@@ -0,0 +1,83 @@
+//go:build linux || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// xdgTrash is the trash of the FreeDesktop.org Trash specification, used by
+// GNOME, KDE and most Linux and BSD file managers: files/ holds the trashed
+// files and info/ a NAME.trashinfo with the original path and deletion time
+// of each, from which they are restored.
+type xdgTrash struct {
+	home string // $XDG_DATA_HOME/Trash
+}
+
+func newTrash() (trashCan, error) {
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("no trash folder: %v", err)
+		}
+		data = filepath.Join(home, ".local", "share")
+	}
+	return &xdgTrash{home: filepath.Join(data, "Trash")}, nil
+}
+
+// put moves path to the home trash or, when it is on another filesystem,
+// to the $topdir/.Trash-$uid trash of that filesystem, as the specification
+// asks, so nothing is copied.
+func (t *xdgTrash) put(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	err = trashXDG(t.home, abs, abs)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	top, err := mountPoint(filepath.Dir(abs))
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(top, abs)
+	if err != nil {
+		return err
+	}
+	// Paths in a $topdir trash are relative to $topdir.
+	return trashXDG(filepath.Join(top, fmt.Sprintf(".Trash-%d", os.Getuid())), abs, rel)
+}
+
+// trashXDG moves abs into the trash directory dir, recording it as origin.
+func trashXDG(dir, abs, origin string) error {
+	info := filepath.Join(dir, "info")
+	if err := os.MkdirAll(info, 0700); err != nil {
+		return err
+	}
+	return moveIntoTrash(abs, filepath.Join(dir, "files"), func(name string) (func(), error) {
+		infoPath := filepath.Join(info, name+".trashinfo")
+		f, err := os.OpenFile(infoPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return nil, err
+		}
+		_, err = fmt.Fprintf(f, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+			(&url.URL{Path: origin}).EscapedPath(), time.Now().Format("2006-01-02T15:04:05"))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		undo := func() { os.Remove(infoPath) }
+		if err != nil {
+			undo()
+			return nil, err
+		}
+		return undo, nil
+	})
+}
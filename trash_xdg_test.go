@@ -0,0 +1,49 @@
+//go:build linux || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestXDGTrash(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	trash, err := newTrash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(dir, "IMG 1.heic")
+		if err := os.WriteFile(path, []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := trash.put(path); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("source still there: %v", err)
+		}
+	}
+
+	// The second file of the same name is numbered.
+	for i, name := range []string{"IMG 1.heic", "IMG 1.2.heic"} {
+		content, err := os.ReadFile(filepath.Join(data, "Trash", "files", name))
+		if err != nil || len(content) != 1 || content[0] != byte(i) {
+			t.Errorf("%s: %v %v", name, content, err)
+		}
+		info, err := os.ReadFile(filepath.Join(data, "Trash", "info", name+".trashinfo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "[Trash Info]\nPath=" + strings.ReplaceAll(filepath.Join(dir, "IMG 1.heic"), " ", "%20") + "\nDeletionDate="
+		if !strings.HasPrefix(string(info), want) {
+			t.Errorf("%s: got %q, want prefix %q", name, info, want)
+		}
+	}
+}
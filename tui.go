@@ -0,0 +1,379 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// tuiInterval is how often --tui redraws the screen.
+const tuiInterval = 250 * time.Millisecond
+
+// tuiSlow is how long a worker can spend on one phase of a file before its
+// row is flagged as possibly stuck.
+const tuiSlow = 30 * time.Second
+
+// tuiWorker is one row of the --tui worker table.
+type tuiWorker struct {
+	label string    // stage and number, e.g. "decode 3"
+	phase string    // what the worker is doing, "" when idle
+	file  string    // the file it is doing it to
+	since time.Time // when the phase started
+}
+
+// tuiDisplay is the full-screen --tui view of a run: a table of the
+// pipeline's workers with the file and phase each one is on, the run's
+// speed, and a scrollable pane of errors and warnings. The pipeline reports
+// to it as files move through the stages; a nil *tuiDisplay ignores all
+// calls, so the pipeline reports unconditionally.
+type tuiDisplay struct {
+	mu      sync.Mutex
+	out     io.Writer
+	pauser  *pauseControl
+	started time.Time
+	workers []*tuiWorker
+	stages  map[string]int // workers per stage, for numbering new rows
+
+	done, failed, total int
+	bytesRead           int64
+
+	messages []string // errors and log output, oldest first
+	scroll   int      // message lines scrolled back from the newest
+	verbose  bool     // decoder warnings are messages too (--verbose)
+
+	running bool
+	quit    chan struct{}
+	drawn   sync.WaitGroup
+	logOut  io.Writer // where log wrote before start
+}
+
+func newTUI(out io.Writer, pauser *pauseControl, verbose bool) *tuiDisplay {
+	return &tuiDisplay{out: out, pauser: pauser, verbose: verbose, started: time.Now(), stages: map[string]int{}}
+}
+
+// begin shows an idle worker of stage starting on file, adding a row when
+// all of the stage's workers are busy. It returns the row for next and end.
+func (t *tuiDisplay) begin(stage, file string) int {
+	if t == nil {
+		return -1
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	prefix := stage + " "
+	for i, w := range t.workers {
+		if w.phase == "" && strings.HasPrefix(w.label, prefix) {
+			w.phase, w.file, w.since = stage, file, now
+			return i
+		}
+	}
+	t.stages[stage]++
+	t.workers = append(t.workers, &tuiWorker{label: fmt.Sprintf("%s%d", prefix, t.stages[stage]), phase: stage, file: file, since: now})
+	return len(t.workers) - 1
+}
+
+// next moves the worker of row on to another phase of the same file.
+func (t *tuiDisplay) next(row int, phase string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.workers[row].phase, t.workers[row].since = phase, time.Now()
+}
+
+// end shows the worker of row as idle.
+func (t *tuiDisplay) end(row int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.workers[row].phase, t.workers[row].file = "", ""
+}
+
+// read counts source bytes read, for the throughput.
+func (t *tuiDisplay) read(n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bytesRead += n
+}
+
+// finished records a file's result: the progress counts, and its error, and
+// with --verbose its warnings, in the message pane.
+func (t *tuiDisplay) finished(result *fileResult, done, total int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done, t.total = done, total
+	if result.err != nil {
+		t.failed++
+		t.messages = append(t.messages, fmt.Sprintf("%s: %v", result.name, result.err))
+	}
+	if t.verbose {
+		for _, warning := range result.warnings {
+			t.messages = append(t.messages, fmt.Sprintf("%s: warning: %s", result.name, warning))
+		}
+	}
+}
+
+// Write adds log output to the message pane while the display is running.
+func (t *tuiDisplay) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.messages = append(t.messages, line)
+	}
+	return len(p), nil
+}
+
+// scrollBy moves the message pane by lines, positive towards older ones.
+func (t *tuiDisplay) scrollBy(lines int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scroll += lines
+	if t.scroll > len(t.messages)-1 {
+		t.scroll = len(t.messages) - 1
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+}
+
+// handleKeys reads key presses from r until it fails: p and r pause and
+// resume like without --tui, the arrow keys, j/k and Page Up/Down scroll the
+// message pane.
+func (t *tuiDisplay) handleKeys(r io.Reader) {
+	buf := make([]byte, 8)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		switch key := string(buf[:n]); key {
+		case "p", "P":
+			if t.pauser.pause() && !t.active() {
+				fmt.Fprintln(t.out, "Pausing after the files in progress; press r to resume.")
+			}
+		case "r", "R":
+			if t.pauser.resume() && !t.active() {
+				fmt.Fprintln(t.out, "Resuming.")
+			}
+		case "k", "\x1b[A":
+			t.scrollBy(1)
+		case "j", "\x1b[B":
+			t.scrollBy(-1)
+		case "\x1b[5~":
+			t.scrollBy(10)
+		case "\x1b[6~":
+			t.scrollBy(-10)
+		}
+	}
+}
+
+// active reports whether the display is on screen. Outside the first run
+// of --watch, for instance, output is printed as without --tui.
+func (t *tuiDisplay) active() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.running
+}
+
+// start switches to the terminal's alternate screen and redraws it until
+// stop. Log output goes to the message pane in the meantime.
+func (t *tuiDisplay) start() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.running = true
+	t.quit = make(chan struct{})
+	t.logOut = log.Writer()
+	t.mu.Unlock()
+	log.SetOutput(t)
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+
+	t.drawn.Add(1)
+	go func() {
+		defer t.drawn.Done()
+		ticker := time.NewTicker(tuiInterval)
+		defer ticker.Stop()
+		for {
+			t.draw()
+			select {
+			case <-ticker.C:
+			case <-t.quit:
+				return
+			}
+		}
+	}()
+}
+
+// stop leaves the alternate screen, so the run's summary is printed to the
+// terminal as usual, and prints the messages that were shown. Calls after
+// the first do nothing.
+func (t *tuiDisplay) stop() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if !t.running {
+		t.mu.Unlock()
+		return
+	}
+	t.running = false
+	close(t.quit)
+	t.mu.Unlock()
+	t.drawn.Wait()
+
+	log.SetOutput(t.logOut)
+	fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, line := range t.messages {
+		fmt.Fprintln(t.out, line)
+	}
+}
+
+// draw redraws the whole screen from the top left corner.
+func (t *tuiDisplay) draw() {
+	width, height, ok := terminalSize(os.Stdout)
+	if !ok {
+		width, height = 80, 24
+	}
+	lines := t.frame(width, height, time.Now())
+	// No line break after the last line, which would scroll the screen.
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	b.WriteString(strings.Join(lines, "\x1b[K\r\n"))
+	b.WriteString("\x1b[K\x1b[J")
+	io.WriteString(t.out, b.String())
+}
+
+// frame lays out the screen as at most height lines of at most width
+// characters: the progress line, the worker table (busy workers first when
+// they do not all fit), and the message pane in what is left.
+func (t *tuiDisplay) frame(width, height int, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elapsed := now.Sub(t.started)
+	status := fmt.Sprintf("heictojpeg  %d", t.done)
+	if t.total > 0 {
+		status += fmt.Sprintf("/%d files (%d%%)", t.total, 100*t.done/t.total)
+	} else {
+		status += " files"
+	}
+	if t.failed > 0 {
+		status += fmt.Sprintf(", %d failed", t.failed)
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		status += fmt.Sprintf(", %.1f files/s, %s/s read", float64(t.done)/seconds, humanReadableFileSize(int64(float64(t.bytesRead)/seconds)))
+	}
+	status += ", elapsed " + elapsed.Truncate(time.Second).String()
+	if t.total > t.done && t.done > 0 {
+		eta := time.Duration(float64(elapsed) / float64(t.done) * float64(t.total-t.done))
+		status += ", ETA " + eta.Truncate(time.Second).String()
+	}
+	if t.pauser != nil && t.pauser.isPaused() {
+		status += "  [PAUSED]"
+	}
+	lines := []string{status, ""}
+
+	// The message pane keeps at least a few lines for itself.
+	const paneMin = 4
+	rows := make([]*tuiWorker, 0, len(t.workers))
+	for _, w := range t.workers {
+		if w.phase != "" {
+			rows = append(rows, w)
+		}
+	}
+	busy := len(rows)
+	for _, w := range t.workers {
+		if w.phase == "" {
+			rows = append(rows, w)
+		}
+	}
+	// Below the table header: a blank line, the pane's title, its lines
+	// and the key help.
+	room := height - len(lines) - 1 - (paneMin + 3)
+	if room < 1 {
+		room = 1
+	}
+	idle := len(rows) - busy
+	hidden := 0
+	if len(rows) > room {
+		// Make room for the line counting the rest.
+		hidden = len(rows) - (room - 1)
+		rows = rows[:room-1]
+		if len(rows) > busy {
+			idle -= len(rows) - busy
+		}
+	}
+	lines = append(lines, fmt.Sprintf("%-10s %-8s %8s  %s", "WORKER", "PHASE", "TIME", "FILE"))
+	for _, w := range rows {
+		if w.phase == "" {
+			lines = append(lines, fmt.Sprintf("%-10s idle", w.label))
+			continue
+		}
+		took := now.Sub(w.since)
+		flag := " "
+		if took >= tuiSlow {
+			flag = "!"
+		}
+		lines = append(lines, fmt.Sprintf("%-10s %-8s %7.1fs%s %s", w.label, w.phase, took.Seconds(), flag, w.file))
+	}
+	if hidden > 0 {
+		lines = append(lines, fmt.Sprintf("... %d more workers (%d idle)", hidden, idle))
+	}
+
+	lines = append(lines, "")
+	title := fmt.Sprintf("Errors and warnings (%d)", len(t.messages))
+	pane := height - len(lines) - 2
+	if pane < 1 {
+		pane = 1
+	}
+	last := len(t.messages) - t.scroll
+	first := last - pane
+	if first < 0 {
+		first = 0
+	}
+	if t.scroll > 0 {
+		title += fmt.Sprintf(", %d newer below", t.scroll)
+	}
+	lines = append(lines, title)
+	lines = append(lines, t.messages[first:last]...)
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, "p pause  r resume  up/down/PgUp/PgDn scroll")
+
+	for i, line := range lines {
+		lines[i] = truncateRunes(line, width)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+// truncateRunes shortens s to at most n characters.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTUIWorkerRows(t *testing.T) {
+	d := newTUI(io.Discard, nil, false)
+	first := d.begin("read", "a.heic")
+	second := d.begin("read", "b.heic")
+	decode := d.begin("decode", "c.heic")
+	d.end(first)
+	if again := d.begin("read", "d.heic"); again != first {
+		t.Errorf("idle row %d not reused, got %d", first, again)
+	}
+	d.next(decode, "finish")
+	var labels []string
+	for _, w := range d.workers {
+		labels = append(labels, w.label+"="+w.phase+":"+w.file)
+	}
+	if got := strings.Join(labels, " "); got != "read 1=read:d.heic read 2=read:b.heic decode 1=finish:c.heic" {
+		t.Errorf("unexpected rows %s (second %d)", got, second)
+	}
+}
+
+func TestTUINilDisplay(t *testing.T) {
+	var d *tuiDisplay
+	row := d.begin("read", "a.heic")
+	d.next(row, "decode")
+	d.end(row)
+	d.read(10)
+	d.finished(&fileResult{name: "a.heic"}, 1, 1)
+	d.start()
+	d.stop()
+	if d.active() {
+		t.Error("nil display is active")
+	}
+}
+
+func TestTUIFrame(t *testing.T) {
+	now := time.Now()
+	d := newTUI(io.Discard, newPauseControl(), false)
+	d.started = now.Add(-10 * time.Second)
+	d.begin("read", "IMG_0001.HEIC")
+	d.begin("decode", "IMG_0002.HEIC")
+	d.workers[0].since = now.Add(-200 * time.Millisecond)
+	d.workers[1].since = now.Add(-45 * time.Second)
+	d.end(d.begin("encode", "IMG_0003.HEIC"))
+	d.read(20 << 20)
+	for i := 1; i <= 5; i++ {
+		result := &fileResult{name: fmt.Sprintf("IMG_%04d.HEIC", 100+i), warnings: []string{"alpha channel dropped"}}
+		if i == 5 {
+			result.err = errors.New("decode failed")
+		}
+		d.finished(result, i, 10)
+	}
+	d.pauser.pause()
+
+	lines := d.frame(100, 20, now)
+	if len(lines) != 20 {
+		t.Fatalf("got %d lines, want 20", len(lines))
+	}
+	screen := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"heictojpeg  5/10 files (50%), 1 failed, 0.5 files/s, 2.0MB/s read, elapsed 10s, ETA 10s  [PAUSED]",
+		"read 1     read         0.2s  IMG_0001.HEIC",
+		"decode 1   decode      45.0s! IMG_0002.HEIC",
+		"encode 1   idle\n",
+		"Errors and warnings (1)\nIMG_0105.HEIC: decode failed",
+	} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("missing %q in:\n%s", want, screen)
+		}
+	}
+	if strings.Contains(screen, "alpha channel") {
+		t.Error("warnings shown without --verbose")
+	}
+	for _, line := range d.frame(30, 20, now) {
+		if len([]rune(line)) > 30 {
+			t.Errorf("line %q wider than the terminal", line)
+		}
+	}
+}
+
+func TestTUIManyWorkers(t *testing.T) {
+	d := newTUI(io.Discard, nil, false)
+	for i := 0; i < 12; i++ {
+		d.begin("decode", fmt.Sprintf("%d.heic", i))
+	}
+	for i := 0; i < 8; i++ {
+		d.end(i)
+	}
+	lines := d.frame(80, 15, time.Now())
+	if len(lines) != 15 {
+		t.Fatalf("got %d lines, want 15", len(lines))
+	}
+	screen := strings.Join(lines, "\n")
+	// The busy workers come first, the rest is counted.
+	if !strings.Contains(screen, "decode 12") || !strings.Contains(screen, "... 8 more workers (8 idle)") {
+		t.Errorf("unexpected table:\n%s", screen)
+	}
+}
+
+// keyReader returns one key press per Read.
+type keyReader []string
+
+func (k *keyReader) Read(p []byte) (int, error) {
+	if len(*k) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, (*k)[0])
+	*k = (*k)[1:]
+	return n, nil
+}
+
+func TestTUIKeys(t *testing.T) {
+	d := newTUI(io.Discard, newPauseControl(), false)
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(d, "message %d\n", i)
+	}
+	keys := keyReader{"p", "\x1b[A", "\x1b[A", "k", "\x1b[5~", "j"}
+	d.handleKeys(&keys)
+	if !d.pauser.isPaused() {
+		t.Error("p did not pause")
+	}
+	if d.scroll != 12 {
+		t.Errorf("scrolled back %d lines, want 12", d.scroll)
+	}
+	screen := strings.Join(d.frame(80, 20, time.Now()), "\n")
+	if !strings.Contains(screen, "message 17\n") || strings.Contains(screen, "message 18") || !strings.Contains(screen, "12 newer below") {
+		t.Errorf("unexpected pane:\n%s", screen)
+	}
+
+	keys = keyReader{"r", "\x1b[6~", "\x1b[6~"}
+	d.handleKeys(&keys)
+	if d.pauser.isPaused() || d.scroll != 0 {
+		t.Errorf("paused %v, scroll %d after r and Page Down", d.pauser.isPaused(), d.scroll)
+	}
+}
+
+func TestParseOptionsTUI(t *testing.T) {
+	opts := defaultOptions()
+	if opts.interactive {
+		if _, err := parseOptions([]string{"--tui"}, io.Discard); err != nil {
+			t.Errorf("--tui on a terminal: %v", err)
+		}
+	}
+	if _, err := parseOptions([]string{"--tui", "--non-interactive"}, io.Discard); err == nil {
+		t.Error("expected an error for --tui without a terminal")
+	}
+}
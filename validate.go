@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+)
+
+// errInvalidOutput fails a file under --validate-output whose JPEG output is
+// malformed, so that an encoder or write bug is caught before the output
+// replaces anything.
+var errInvalidOutput = errors.New("invalid output")
+
+// validateJPEG checks the structure of the JPEG data: a start of image
+// marker, well-formed segments up to a frame header and the first scan, an
+// end of image marker, and that it decodes, to the size of bounds unless
+// bounds is empty.
+func validateJPEG(data []byte, bounds image.Rectangle) error {
+	if err := checkJPEGMarkers(data); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidOutput, err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: does not decode: %v", errInvalidOutput, err)
+	}
+	if got := img.Bounds(); !bounds.Empty() && (got.Dx() != bounds.Dx() || got.Dy() != bounds.Dy()) {
+		return fmt.Errorf("%w: decodes to %dx%d, want %dx%d", errInvalidOutput, got.Dx(), got.Dy(), bounds.Dx(), bounds.Dy())
+	}
+	return nil
+}
+
+// checkJPEGMarkers walks the segments of data from its start of image
+// marker to its first scan, and checks it ends with an end of image marker.
+func checkJPEGMarkers(data []byte) error {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return errors.New("no start of image marker")
+	}
+	frame := false
+	for i := 2; ; {
+		if i+4 > len(data) {
+			return errors.New("no scan")
+		}
+		if data[i] != 0xff {
+			return fmt.Errorf("no marker at offset %d", i)
+		}
+		marker := data[i+1]
+		if marker == 0xff {
+			// Fill byte before a marker.
+			i++
+			continue
+		}
+		switch {
+		case marker == 0xd8 || marker == 0xd9 || marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			return fmt.Errorf("unexpected marker %#02x before the first scan", marker)
+		case marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			frame = true
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return fmt.Errorf("segment %#02x at offset %d overruns the file", marker, i)
+		}
+		if marker == 0xda {
+			if !frame {
+				return errors.New("scan before the frame header")
+			}
+			break
+		}
+		i += 2 + length
+	}
+	if !bytes.HasSuffix(data, []byte{0xff, 0xd9}) {
+		return errors.New("no end of image marker")
+	}
+	return nil
+}
+
+// validateJPEGFile is validateJPEG for a JPEG written to path.
+func validateJPEGFile(path string, bounds image.Rectangle) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return validateJPEG(data, bounds)
+}
+
+// outputCheck is the check an output of bounds has to pass before it
+// replaces anything: validateJPEGFile under --validate-output, otherwise none.
+func outputCheck(bounds image.Rectangle, opts options) func(string) error {
+	if !opts.validateOutput {
+		return nil
+	}
+	return func(path string) error {
+		return validateJPEGFile(path, bounds)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	if err := validateJPEG(valid, img.Bounds()); err != nil {
+		t.Fatalf("valid JPEG rejected: %v", err)
+	}
+	if err := validateJPEG(testJPEG(t), image.Rectangle{}); err != nil {
+		t.Fatalf("JPEG with an EXIF segment rejected: %v", err)
+	}
+
+	overrun := append([]byte{}, valid...)
+	overrun[4], overrun[5] = 0xff, 0xff // length of the first segment
+	tests := []struct {
+		name   string
+		data   []byte
+		bounds image.Rectangle
+	}{
+		{"empty", nil, image.Rectangle{}},
+		{"no SOI", valid[2:], image.Rectangle{}},
+		{"no EOI", valid[:len(valid)-2], image.Rectangle{}},
+		{"truncated scan", append(append([]byte{}, valid[:len(valid)/2]...), 0xff, 0xd9), image.Rectangle{}},
+		{"segment overrun", overrun, image.Rectangle{}},
+		{"header only", []byte{0xff, 0xd8, 0xff, 0xd9}, image.Rectangle{}},
+		{"wrong size", valid, image.Rect(0, 0, 48, 64)},
+	}
+	for _, tt := range tests {
+		if err := validateJPEG(tt.data, tt.bounds); !errors.Is(err, errInvalidOutput) {
+			t.Errorf("%s: got %v, want an invalid output", tt.name, err)
+		}
+	}
+}
+
+func TestValidateOutputConversion(t *testing.T) {
+	dir := t.TempDir()
+	opts := defaultOptions()
+	opts.validateOutput = true
+	for _, lowMemory := range []bool{false, true} {
+		opts.lowMemory = lowMemory
+		if _, err := convertFile("testdata/images", "goheif-camel.heic", dir, opts); err != nil {
+			t.Fatalf("low memory %v: %v", lowMemory, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "goheif-camel.jpg")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInvalidOutputKeepsExisting(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "a.jpg")
+	if err := os.WriteFile(output, []byte("earlier"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	opts.validateOutput = true
+	check := outputCheck(image.Rect(0, 0, 8, 8), opts)
+	if err := writeEncoded(output, bytes.NewBufferString("not a JPEG"), check, opts); !errors.Is(err, errInvalidOutput) {
+		t.Errorf("got %v, want an invalid output", err)
+	}
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	if err := streamJPEG(img, nil, output, outputCheck(image.Rect(0, 0, 16, 16), opts), opts); !errors.Is(err, errInvalidOutput) {
+		t.Errorf("streamed: got %v, want an invalid output", err)
+	}
+	if data, err := os.ReadFile(output); err != nil || string(data) != "earlier" {
+		t.Errorf("existing output replaced: %q, %v", data, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+
+	if err := streamJPEG(img, nil, output, outputCheck(img.Bounds(), opts), opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateJPEGFile(output, img.Bounds()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateOutputDefault(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{dir}, false},
+		{[]string{"--validate-output", dir}, true},
+		{[]string{"--strict-metadata", dir}, true},
+		{[]string{"--strict-metadata", "--validate-output=false", dir}, false},
+	}
+	for _, tt := range tests {
+		opts, err := parseOptions(tt.args, io.Discard)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.args, err)
+		}
+		if opts.validateOutput != tt.want {
+			t.Errorf("%v: validate output %v, want %v", tt.args, opts.validateOutput, tt.want)
+		}
+	}
+}
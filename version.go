@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// version is set at release build time with
+// -ldflags "-X main.version=v1.2.3". Other builds fall back to the module
+// version recorded by the Go toolchain.
+var version = ""
+
+// versionInfo describes the build, for `heictojpeg version` and the header
+// of each log file.
+type versionInfo struct {
+	Version   string            `json:"version"`
+	Revision  string            `json:"revision,omitempty"`
+	Modified  bool              `json:"modified,omitempty"`
+	Go        string            `json:"go"`
+	Platform  string            `json:"platform"`
+	Backend   string            `json:"backend"`
+	Libraries map[string]string `json:"libraries"`
+}
+
+func currentVersion() versionInfo {
+	info := versionInfo{
+		Version:   version,
+		Go:        runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		Backend:   decoderBackend,
+		Libraries: map[string]string{},
+	}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		if info.Version == "" {
+			info.Version = "unknown"
+		}
+		return info
+	}
+	if info.Version == "" {
+		info.Version = build.Main.Version
+	}
+	if info.Version == "" {
+		info.Version = "(devel)"
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	for _, dep := range build.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		info.Libraries[dep.Path] = dep.Version
+	}
+	return info
+}
+
+// runVersion implements the version subcommand.
+func runVersion(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	fs.SetOutput(out)
+	asJSON := fs.Bool("json", false, "print the version, backend and library versions as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	info := currentVersion()
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(info)
+	}
+	fmt.Fprintf(out, "heictojpeg %s (%s, %s)\n", info.Version, info.Go, info.Platform)
+	fmt.Fprintf(out, "Decoder: %s\n", info.Backend)
+	return nil
+}
+
+// sessionHeader is written as a single JSON line at the top of each log file
+// so a run can be audited or reproduced later.
+type sessionHeader struct {
+	versionInfo
+	Started  time.Time         `json:"started"`
+	Input    string            `json:"input"`
+	Settings map[string]string `json:"settings"`
+}
+
+// secretFlags are never written to logs.
+var secretFlags = map[string]bool{"zip-password": true, "notify-webhook": true}
+
+func newSessionHeader(opts options, started time.Time) sessionHeader {
+	// A flag set bound to a copy of opts reports the effective value of
+	// every flag, whether it came from the command line, the environment or
+	// the defaults.
+	settings := map[string]string{}
+	newFlagSet(&opts, io.Discard).VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretFlags[f.Name] && value != "" {
+			value = "(redacted)"
+		}
+		settings[f.Name] = value
+	})
+	return sessionHeader{
+		versionInfo: currentVersion(),
+		Started:     started,
+		Input:       inputDescription(opts),
+		Settings:    settings,
+	}
+}
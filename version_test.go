@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunVersionJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := runVersion([]string{"--json"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var info versionInfo
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	if info.Version == "" || info.Go == "" || info.Backend != decoderBackend {
+		t.Errorf("incomplete version info: %+v", info)
+	}
+}
+
+func TestSessionHeader(t *testing.T) {
+	opts, err := parseOptions([]string{"--quality", "80", "--output-zip", "out.zip", "--zip-password", "hunter2", "photos"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := newSessionHeader(opts, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	if header.Input != "photos" {
+		t.Errorf("got input %q", header.Input)
+	}
+	if header.Settings["quality"] != "80" || header.Settings["order"] != orderName {
+		t.Errorf("settings do not reflect the options: %v", header.Settings)
+	}
+	if header.Settings["zip-password"] != "(redacted)" {
+		t.Errorf("zip password not redacted: %q", header.Settings["zip-password"])
+	}
+
+	dir := t.TempDir()
+	saveLogsToFile(dir, header, map[string][]string{"general": {"1 Files"}})
+	data, err := os.ReadFile(filepath.Join(dir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Fatal("log file contains the zip password")
+	}
+	first, rest, _ := strings.Cut(string(data), "\n")
+	var decoded sessionHeader
+	if err := json.Unmarshal([]byte(first), &decoded); err != nil {
+		t.Fatalf("first log line is not the JSON header: %v", err)
+	}
+	if !decoded.Started.Equal(header.Started) || decoded.Settings["quality"] != "80" {
+		t.Errorf("header did not round-trip: %+v", decoded)
+	}
+	if rest != "1 Files\n" {
+		t.Errorf("got log body %q", rest)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// videoExtensions are the videos phones export alongside their photos: Live
+// Photo motion, recordings and screen recordings. --copy-videos passes them
+// through to the output folder.
+var videoExtensions = map[string]bool{".mov": true, ".mp4": true, ".m4v": true}
+
+// transcodedExt is the extension of --video-command outputs.
+const transcodedExt = ".mp4"
+
+// isVideoFile reports whether name has one of the videoExtensions.
+func isVideoFile(name string) bool {
+	return videoExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// isSourceFile reports whether the pipeline converts, or with --copy-videos
+// copies, the file name.
+func isSourceFile(name string, opts options) bool {
+	return isHEICFile(name) || opts.copyVideos && isVideoFile(name)
+}
+
+// videoCommand is the program and arguments of --video-command, which
+// transcodes each video instead of copying it. The {in} and {out}
+// placeholders are replaced with the paths of the video and of the output,
+// which ends in transcodedExt. Arguments are split on spaces; no shell is
+// involved.
+type videoCommand []string
+
+func (v videoCommand) String() string {
+	return strings.Join(v, " ")
+}
+
+// parseVideoCommand parses a --video-command value and checks that the
+// program exists.
+func parseVideoCommand(value string) (videoCommand, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	if !strings.Contains(value, "{in}") || !strings.Contains(value, "{out}") {
+		return nil, fmt.Errorf("%q must contain {in} and {out}", value)
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return nil, err
+	}
+	return videoCommand(fields), nil
+}
+
+// videoOutputName is where the video name goes, given the output name a
+// photo of that name would get: the same name, so a Live Photo's video
+// stays next to its JPEG, with the video's extension, or transcodedExt
+// when it is transcoded.
+func videoOutputName(photoName, name string, opts options) string {
+	ext := filepath.Ext(name)
+	if opts.videoCommand != nil {
+		ext = transcodedExt
+	}
+	return strings.TrimSuffix(photoName, filepath.Ext(photoName)) + ext
+}
+
+// copyVideo writes the video source to output, as it is with its
+// modification time, or transcoded by --video-command.
+func copyVideo(source, output string, opts options) error {
+	if opts.videoCommand != nil {
+		if err := runVideoCommand(opts.videoCommand, source, output); err != nil {
+			return err
+		}
+		return applyOwnership(output, opts)
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := createOutput(output)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.discard()
+		return err
+	}
+	// The rename keeps the modification time.
+	if err := os.Chtimes(out.Name(), info.ModTime(), info.ModTime()); err != nil {
+		out.discard()
+		return err
+	}
+	if err := out.commit(nil); err != nil {
+		return err
+	}
+	return applyOwnership(output, opts)
+}
+
+// runVideoCommand runs command on source, writing output. Its stderr is
+// passed through; a failed command leaves no partial output behind.
+func runVideoCommand(command videoCommand, source, output string) error {
+	args := make([]string, len(command))
+	for i, arg := range command {
+		args[i] = strings.NewReplacer("{in}", source, "{out}", output).Replace(arg)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(output)
+		return fmt.Errorf("video command %q: %v", command, err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		return fmt.Errorf("video command %q wrote no output: %v", command, err)
+	}
+	return nil
+}
+
+// copyVideo copies, or transcodes, a video source to where a photo of its
+// name would go.
+func (c *conversion) copyVideo() error {
+	if name, ok := c.opts.plannedNames[c.name]; ok {
+		c.outputName = name
+	} else {
+		photoName, err := outputName(c.currentDir, c.name, nil, c.opts)
+		if err != nil {
+			return err
+		}
+		c.outputName = videoOutputName(photoName, c.name, c.opts)
+	}
+	c.outputPath = filepath.Join(c.jpegDir, c.outputName)
+	c.written = c.outputPath
+	if err := os.MkdirAll(filepath.Dir(c.outputPath), 0755); err != nil {
+		return err
+	}
+	return copyVideo(c.sourcePath, c.outputPath, c.opts)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsVideoFile(t *testing.T) {
+	for name, want := range map[string]bool{"IMG_0001.MOV": true, "clip.mp4": true, "a.m4v": true, "IMG_0001.HEIC": false, "mov": false} {
+		if got := isVideoFile(name); got != want {
+			t.Errorf("isVideoFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+	opts := defaultOptions()
+	if isSourceFile("IMG_0001.MOV", opts) || !isSourceFile("IMG_0001.HEIC", opts) {
+		t.Error("videos are sources without --copy-videos")
+	}
+	opts.copyVideos = true
+	if !isSourceFile("IMG_0001.MOV", opts) {
+		t.Error("videos are not sources with --copy-videos")
+	}
+}
+
+func TestVideoOutputName(t *testing.T) {
+	opts := defaultOptions()
+	if got := videoOutputName(filepath.Join("Album", "IMG_0001.jpg"), "UUID.MOV", opts); got != filepath.Join("Album", "IMG_0001.MOV") {
+		t.Errorf("copied: got %s", got)
+	}
+	opts.videoCommand = videoCommand{"ffmpeg", "-i", "{in}", "{out}"}
+	if got := videoOutputName("IMG_0001.jpg", "IMG_0001.MOV", opts); got != "IMG_0001.mp4" {
+		t.Errorf("transcoded: got %s", got)
+	}
+}
+
+func TestParseOptionsVideos(t *testing.T) {
+	opts, err := parseOptions([]string{"--video-command", "cp {in} {out}", "in"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.copyVideos || opts.videoCommand.String() != "cp {in} {out}" {
+		t.Errorf("unexpected options: copy %v, command %q", opts.copyVideos, opts.videoCommand)
+	}
+	for _, args := range [][]string{
+		{"--video-command", "cp {in}", "in"},
+		{"--video-command", "no-such-program-heictojpeg {in} {out}", "in"},
+		{"--copy-videos", "--output-format", "pdf", "in"},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
+
+func TestCopyVideos(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.HEIC"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0001.MOV"), []byte("live photo motion"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "clip.mp4"), []byte("recording"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	taken := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "clip.mp4"), taken, taken); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--copy-videos", "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegDir, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"IMG_0001.MOV": "live photo motion", "clip.mp4": "recording"} {
+		got, err := os.ReadFile(filepath.Join(jpegDir, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v)", name, got, err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(jpegDir, "clip.mp4")); err != nil || !info.ModTime().Equal(taken) {
+		t.Errorf("clip.mp4 modification time not kept (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(jpegDir, "IMG_0001.jpg")); err != nil {
+		t.Error(err)
+	}
+	logs, err := os.ReadFile(filepath.Join(jpegDir, logFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"IMG_0001.MOV 17B > Copied > jpegs/IMG_0001.MOV 17B", "Videos==2 (26B)"} {
+		if !strings.Contains(string(logs), want) {
+			t.Errorf("missing %q in log:\n%s", want, logs)
+		}
+	}
+}
+
+func TestTranscodeVideos(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "IMG_0002.MOV"), []byte("motion"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseOptions([]string{"--video-command", "cp {in} {out}", "--non-interactive", dir}, io.Discard)
+	if err != nil {
+		t.Skipf("no cp: %v", err)
+	}
+	jpegDir, err := run(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(jpegDir, "IMG_0002.mp4")); err != nil || string(got) != "motion" {
+		t.Errorf("got %q (%v)", got, err)
+	}
+	logs, _ := os.ReadFile(filepath.Join(jpegDir, logFileName))
+	if !strings.Contains(string(logs), "> Transcoded > jpegs/IMG_0002.mp4") {
+		t.Errorf("no Transcoded line in log:\n%s", logs)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+)
+
+// Display modes of the view subcommand.
+const (
+	viewAuto   = "auto"
+	viewWindow = "window" // a window of its own, or the desktop's image viewer
+	viewITerm  = "iterm"  // iTerm2 inline images, also shown by WezTerm
+	viewSixel  = "sixel"  // DEC sixel graphics
+)
+
+// defaultViewWidth is the width, in pixels, images are scaled down to for
+// terminal graphics. Sixel output of a full-size photo would be tens of
+// megabytes of escape sequences.
+const defaultViewWidth = 800
+
+// sixelTerminals are TERM values of terminals known to draw sixels.
+var sixelTerminals = []string{"mlterm", "foot", "yaft", "contour", "sixel"}
+
+// runView implements the view subcommand: decode a HEIC file and show it,
+// upright, for a quick look before choosing conversion settings.
+func runView(args []string, output io.Writer) error {
+	fs := flag.NewFlagSet("heictojpeg view", flag.ContinueOnError)
+	fs.SetOutput(output)
+	mode := fs.String("mode", viewAuto, "how to show the image: auto, window, iterm or sixel")
+	width := fs.Int("width", defaultViewWidth, "width in pixels the image is scaled down to for iterm and sixel")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: heictojpeg view [flags] FILE")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("view takes one file")
+	}
+	if *width < 1 {
+		return fmt.Errorf("invalid --width %d", *width)
+	}
+	if *mode == viewAuto {
+		*mode = detectViewMode(os.Getenv, isTerminal(os.Stdout))
+	}
+
+	path := fs.Arg(0)
+	img, err := decodeForView(path)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	switch *mode {
+	case viewWindow:
+		return showImageWindow(path, img)
+	case viewITerm:
+		return writeITermImage(output, fitForView(img, *width))
+	case viewSixel:
+		return writeSixel(output, fitForView(img, *width))
+	}
+	return fmt.Errorf("invalid --mode %q", *mode)
+}
+
+// detectViewMode picks terminal graphics when the terminal is known to draw
+// them, and a window otherwise.
+func detectViewMode(getenv func(string) string, terminal bool) string {
+	if !terminal {
+		return viewWindow
+	}
+	if getenv("TERM_PROGRAM") == "iTerm.app" || getenv("LC_TERMINAL") == "iTerm2" || getenv("TERM_PROGRAM") == "WezTerm" {
+		return viewITerm
+	}
+	term := getenv("TERM")
+	for _, name := range sixelTerminals {
+		if strings.Contains(term, name) {
+			return viewSixel
+		}
+	}
+	return viewWindow
+}
+
+// decodeForView decodes the primary image of path and turns it upright by
+// its EXIF orientation.
+func decodeForView(path string) (image.Image, error) {
+	source, err := readSource(path)
+	if err != nil {
+		return nil, err
+	}
+	defer putBuffer(source)
+	data := bytes.NewReader(source.Bytes())
+	rawExif, _ := extractExif(data)
+	img, err := decodeHEIC(data)
+	if err != nil {
+		return nil, err
+	}
+	if orientation := exifOrientation(rawExif); orientation > 1 {
+		img = orientImage(img, orientation, 0, "")
+	}
+	return img, nil
+}
+
+// fitForView scales img down to at most width pixels wide.
+func fitForView(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	w, h := fitDimensions(b.Dx(), b.Dy(), width, b.Dy())
+	if w == b.Dx() {
+		return img
+	}
+	return scaleImage(img, w, h)
+}
+
+// writeITermImage draws img inline with iTerm2's escape sequence, as a PNG.
+func writeITermImage(w io.Writer, img image.Image) error {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:%s\a\n", encoded.Len(), base64.StdEncoding.EncodeToString(encoded.Bytes()))
+	return err
+}
+
+// writeSixel draws img as DEC sixel graphics, dithered to the 216 colours
+// of the web-safe palette. Each band of six rows is written as one
+// run-length encoded pass per colour used in it.
+func writeSixel(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	paletted := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette.WebSafe)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), img, b.Min)
+
+	out := bufio.NewWriter(w)
+	// Pixel aspect 1:1, background left as it is, then the raster size.
+	fmt.Fprintf(out, "\x1bP0;1;0q\"1;1;%d;%d", paletted.Rect.Dx(), paletted.Rect.Dy())
+	for i, c := range paletted.Palette {
+		r, g, bl, _ := c.RGBA()
+		fmt.Fprintf(out, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, bl*100/0xffff)
+	}
+
+	width, height := paletted.Rect.Dx(), paletted.Rect.Dy()
+	bits := make([]byte, width)
+	for top := 0; top < height; top += 6 {
+		used := map[uint8]bool{}
+		for y := top; y < top+6 && y < height; y++ {
+			for _, index := range paletted.Pix[y*paletted.Stride : y*paletted.Stride+width] {
+				used[index] = true
+			}
+		}
+		first := true
+		for index := 0; index < len(paletted.Palette); index++ {
+			if !used[uint8(index)] {
+				continue
+			}
+			for x := range bits {
+				bits[x] = 0
+			}
+			for y := top; y < top+6 && y < height; y++ {
+				row := paletted.Pix[y*paletted.Stride:]
+				for x := 0; x < width; x++ {
+					if row[x] == uint8(index) {
+						bits[x] |= 1 << uint(y-top)
+					}
+				}
+			}
+			if !first {
+				out.WriteByte('$') // back to the start of the band
+			}
+			first = false
+			fmt.Fprintf(out, "#%d", index)
+			writeSixelRuns(out, bits)
+		}
+		out.WriteByte('-') // next band
+	}
+	out.WriteString("\x1b\\\n")
+	return out.Flush()
+}
+
+// writeSixelRuns writes one colour's pass over a band, repeats of the same
+// sixel as "!COUNT" runs.
+func writeSixelRuns(out *bufio.Writer, bits []byte) {
+	for x := 0; x < len(bits); {
+		n := 1
+		for x+n < len(bits) && bits[x+n] == bits[x] {
+			n++
+		}
+		c := byte('?' + bits[x])
+		if n > 3 {
+			fmt.Fprintf(out, "!%d%c", n, c)
+		} else {
+			for i := 0; i < n; i++ {
+				out.WriteByte(c)
+			}
+		}
+		x += n
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// showImageWindow opens img with the desktop's image viewer, as a PNG in
+// the temporary directory. The file is left there for the viewer, which may
+// read it after the command has returned.
+func showImageWindow(path string, img image.Image) error {
+	opener := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		opener = "open"
+	} else if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return fmt.Errorf("no display to open a window on; use --mode sixel or --mode iterm in a terminal that shows images")
+	}
+	f, err := os.CreateTemp("", "heictojpeg-view-*.png")
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := exec.Command(opener, f.Name()).Run(); err != nil {
+		return fmt.Errorf("failed to open %s with %s: %v", f.Name(), opener, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestDetectViewMode(t *testing.T) {
+	for _, tc := range []struct {
+		env      map[string]string
+		terminal bool
+		want     string
+	}{
+		{map[string]string{"TERM_PROGRAM": "iTerm.app"}, true, viewITerm},
+		{map[string]string{"TERM_PROGRAM": "WezTerm", "TERM": "xterm-256color"}, true, viewITerm},
+		{map[string]string{"TERM": "foot"}, true, viewSixel},
+		{map[string]string{"TERM": "xterm-256color"}, true, viewWindow},
+		{map[string]string{"TERM_PROGRAM": "iTerm.app"}, false, viewWindow},
+	} {
+		getenv := func(key string) string { return tc.env[key] }
+		if got := detectViewMode(getenv, tc.terminal); got != tc.want {
+			t.Errorf("%v, terminal %t: got %s, want %s", tc.env, tc.terminal, got, tc.want)
+		}
+	}
+}
+
+func TestWriteSixel(t *testing.T) {
+	// Two columns, seven rows: a white band over one red row.
+	img := image.NewRGBA(image.Rect(0, 0, 2, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 2; x++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	img.Set(0, 6, color.RGBA{R: 255, A: 255})
+	img.Set(1, 6, color.RGBA{R: 255, A: 255})
+
+	var out bytes.Buffer
+	if err := writeSixel(&out, img); err != nil {
+		t.Fatal(err)
+	}
+	s := out.String()
+	if !strings.HasPrefix(s, "\x1bP0;1;0q\"1;1;2;7") || !strings.HasSuffix(s, "\x1b\\\n") {
+		t.Fatalf("not a sixel image: %q", s)
+	}
+	// White is palette entry 215, red 180; every sixel of the first band is
+	// set ('~'), and only the top one of the second.
+	for _, want := range []string{"#215~~-", "#180@@-", "#215;2;100;100;100"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("missing %q in %q", want, s)
+		}
+	}
+}
+
+func TestWriteSixelRuns(t *testing.T) {
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	writeSixelRuns(w, []byte{63, 63, 63, 63, 63, 1, 0, 0})
+	w.Flush()
+	if got, want := out.String(), "!5~@??"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestViewITerm(t *testing.T) {
+	var out bytes.Buffer
+	if err := runView([]string{"--mode", "iterm", "--width", "64", "testdata/images/goheif-camel.heic"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	s := strings.TrimSuffix(out.String(), "\a\n")
+	prefix := "\x1b]1337;File=inline=1;"
+	i := strings.IndexByte(s, ':')
+	if !strings.HasPrefix(s, prefix) || i < 0 {
+		t.Fatalf("not an inline image: %.60q", s)
+	}
+	data, err := base64.StdEncoding.DecodeString(s[i+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 64 {
+		t.Errorf("image not scaled to --width: %v", img.Bounds())
+	}
+}
@@ -0,0 +1,45 @@
+//go:build windows
+
+package main
+
+import (
+	"image"
+	"path/filepath"
+
+	"github.com/lxn/walk"
+	. "github.com/lxn/walk/declarative"
+)
+
+// Largest size the view window opens at; the image is shrunk to fit it and
+// to the window as it is resized.
+const (
+	viewWindowWidth  = 1200
+	viewWindowHeight = 900
+)
+
+// showImageWindow shows img in a window of its own until it is closed.
+func showImageWindow(path string, img image.Image) error {
+	bitmap, err := walk.NewBitmapFromImage(img)
+	if err != nil {
+		return err
+	}
+	defer bitmap.Dispose()
+
+	b := img.Bounds()
+	width, height := fitDimensions(b.Dx(), b.Dy(), viewWindowWidth, viewWindowHeight)
+	var mw *walk.MainWindow
+	err = MainWindow{
+		AssignTo: &mw,
+		Title:    filepath.Base(path),
+		Size:     Size{Width: width, Height: height},
+		Layout:   VBox{MarginsZero: true},
+		Children: []Widget{
+			ImageView{Image: bitmap, Mode: ImageViewModeShrink},
+		},
+	}.Create()
+	if err != nil {
+		return err
+	}
+	mw.Run()
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/adrium/goheif/heif"
+)
+
+// nclx values (ISO/IEC 23091-2) that decoding handles as they are: JPEG
+// stores full-range BT.601 YCbCr, which is what libde265's planes are
+// written out as, and the curve of sRGB, BT.709 and BT.601 is taken as one.
+const (
+	nclxUnspecified   = 2
+	nclxTransferBT709 = 1
+	nclxTransferBT601 = 6
+	nclxTransferSRGB  = 13
+	nclxMatrixBT470BG = 5
+	nclxMatrixBT601   = 6
+)
+
+// Names of the nclx values warned about most often.
+var (
+	nclxMatrixNames   = map[uint16]string{0: "identity (GBR)", 1: "BT.709", 9: "BT.2020"}
+	nclxTransferNames = map[uint16]string{16: "PQ (HDR)", 18: "HLG (HDR)"}
+)
+
+// decodeWarnings lists what converting a HEIC's primary image has to assume
+// or leave out, which the output does not show until its colours look off:
+// colour information that is not applied, profiles --target-profile cannot
+// read, sample formats the decoder handles only in part, and alpha left out by
+// --alpha ignore. The
+// decoder's own warnings are printed by goheif as they happen.
+func decodeWarnings(ra io.ReaderAt, opts options) []string {
+	hf := heif.Open(ra)
+	primary, err := hf.PrimaryItem()
+	if err != nil {
+		return nil
+	}
+	var warnings []string
+	for _, body := range colrBodies(ra) {
+		warnings = append(warnings, colrWarnings(body, opts)...)
+	}
+
+	// Grids and overlays carry the sample format on their coded inputs.
+	coded := primary
+	if ref := primary.Reference("dimg"); ref != nil && len(ref.ToItemIDs) > 0 {
+		if input, err := hf.ItemByID(ref.ToItemIDs[0]); err == nil {
+			coded = input
+		}
+	}
+	for _, prop := range coded.Properties {
+		if !prop.Type().EqualString("hvcC") {
+			continue
+		}
+		if body, err := io.ReadAll(prop.Body()); err == nil {
+			warnings = append(warnings, hvccWarnings(body)...)
+		}
+	}
+
+	if _, ok := alphaItemID(ra); ok && opts.alpha == alphaIgnore {
+		warnings = append(warnings, "alpha channel ignored, JPEG has no transparency")
+	}
+	return warnings
+}
+
+// colrWarnings checks one colr box.
+func colrWarnings(body []byte, opts options) []string {
+	if len(body) < 4 {
+		return []string{"truncated colour box ignored"}
+	}
+	switch kind := string(body[:4]); kind {
+	case "nclx":
+		if len(body) < 11 {
+			return []string{"truncated nclx colour box, assuming BT.709 (sRGB)"}
+		}
+		primaries := binary.BigEndian.Uint16(body[4:])
+		transfer := binary.BigEndian.Uint16(body[6:])
+		matrix := binary.BigEndian.Uint16(body[8:])
+		fullRange := body[10]&0x80 != 0
+
+		var warnings []string
+		if primaries != nclxPrimariesBT709 && primaries != nclxPrimariesP3D65 && primaries != nclxUnspecified {
+			warnings = append(warnings, fmt.Sprintf("unsupported colour primaries %d in nclx colour box, assuming BT.709 (sRGB)", primaries))
+		}
+		switch transfer {
+		case nclxTransferBT709, nclxTransferBT601, nclxTransferSRGB, nclxUnspecified:
+		default:
+			name := nclxTransferNames[transfer]
+			if name == "" {
+				name = fmt.Sprint(transfer)
+			}
+			warnings = append(warnings, fmt.Sprintf("transfer characteristics %s not applied, assuming sRGB", name))
+		}
+		switch matrix {
+		case nclxMatrixBT470BG, nclxMatrixBT601, nclxUnspecified:
+		default:
+			name := nclxMatrixNames[matrix]
+			if name == "" {
+				name = fmt.Sprint(matrix)
+			}
+			warnings = append(warnings, fmt.Sprintf("matrix coefficients %s not applied, assuming BT.601", name))
+		}
+		if !fullRange {
+			warnings = append(warnings, "limited-range (video) levels not expanded, assuming full range")
+		}
+		return warnings
+	case "prof", "rICC":
+		if opts.targetProfile != nil {
+			if _, err := parseICCProfile(body[4:]); err != nil {
+				return []string{fmt.Sprintf("ICC profile cannot be converted from (%v), assuming sRGB for --target-profile", err)}
+			}
+		}
+		return nil
+	default:
+		return []string{fmt.Sprintf("unsupported colour box type %q ignored", kind)}
+	}
+}
+
+// hvccWarnings checks the sample format of an HEVC configuration box, which
+// libde265's planes are read as: 4:2:0, 4:2:2 or 4:4:4, with samples of more
+// than 8 bits (10-bit .HIF files) reduced to 8. Luma and chroma have to
+// share a bit depth for that.
+func hvccWarnings(body []byte) []string {
+	if len(body) < 19 {
+		return nil
+	}
+	var warnings []string
+	if body[16]&3 == 0 {
+		warnings = append(warnings, "monochrome HEVC image, chroma planes missing")
+	}
+	luma, chroma := 8+int(body[17]&7), 8+int(body[18]&7)
+	if luma != chroma {
+		warnings = append(warnings, fmt.Sprintf("HEVC image with %d-bit luma and %d-bit chroma, chroma levels are wrong", luma, chroma))
+	}
+	return warnings
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func nclxBody(primaries, transfer, matrix uint16, fullRange bool) []byte {
+	body := []byte("nclx")
+	for _, v := range []uint16{primaries, transfer, matrix} {
+		body = append(body, byte(v>>8), byte(v))
+	}
+	if fullRange {
+		return append(body, 0x80)
+	}
+	return append(body, 0)
+}
+
+func TestColrWarnings(t *testing.T) {
+	for _, tc := range []struct {
+		body []byte
+		want []string
+	}{
+		{nclxBody(1, 13, 6, true), nil},
+		{nclxBody(12, 13, 6, true), nil},
+		{nclxBody(9, 16, 9, true), []string{
+			"unsupported colour primaries 9 in nclx colour box, assuming BT.709 (sRGB)",
+			"transfer characteristics PQ (HDR) not applied, assuming sRGB",
+			"matrix coefficients BT.2020 not applied, assuming BT.601",
+		}},
+		{nclxBody(1, 1, 1, false), []string{
+			"matrix coefficients BT.709 not applied, assuming BT.601",
+			"limited-range (video) levels not expanded, assuming full range",
+		}},
+		{[]byte("nclx\x00\x01"), []string{"truncated nclx colour box, assuming BT.709 (sRGB)"}},
+		{[]byte("xyzw"), []string{`unsupported colour box type "xyzw" ignored`}},
+	} {
+		if got := colrWarnings(tc.body, defaultOptions()); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.body, got, tc.want)
+		}
+	}
+
+	// Profiles are only parsed when --target-profile converts from them.
+	broken := append([]byte("prof"), make([]byte, 16)...)
+	if got := colrWarnings(broken, defaultOptions()); got != nil {
+		t.Errorf("unexpected warnings %q", got)
+	}
+	opts := defaultOptions()
+	opts.targetProfile = profileSRGB
+	if got := colrWarnings(broken, opts); len(got) != 1 || !strings.Contains(got[0], "--target-profile") {
+		t.Errorf("expected a --target-profile warning, got %q", got)
+	}
+}
+
+func TestHvccWarnings(t *testing.T) {
+	body := make([]byte, 23)
+	body[16], body[17], body[18] = 0xfc|1, 0xf8, 0xf8 // 4:2:0, 8 bits
+	if got := hvccWarnings(body); got != nil {
+		t.Errorf("unexpected warnings %q", got)
+	}
+	body[16], body[17], body[18] = 0xfc|2, 0xf8|2, 0xf8|2 // 4:2:2, 10 bits, as in .HIF files
+	if got := hvccWarnings(body); got != nil {
+		t.Errorf("unexpected warnings %q", got)
+	}
+	body[16], body[17], body[18] = 0xfc, 0xf8|2, 0xf8 // monochrome, 10-bit luma
+	want := []string{"monochrome HEVC image, chroma planes missing", "HEVC image with 10-bit luma and 8-bit chroma, chroma levels are wrong"}
+	if got := hvccWarnings(body); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDecodeWarningsCleanFile(t *testing.T) {
+	data, err := os.ReadFile("testdata/images/goheif-camel.heic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decodeWarnings(bytes.NewReader(data), defaultOptions()); got != nil {
+		t.Errorf("unexpected warnings %q", got)
+	}
+}
+
+func TestAggregateLogsCountsWarnings(t *testing.T) {
+	results := make(chan *fileResult, 3)
+	results <- &fileResult{name: "a.heic", output: "a.jpg", warnings: []string{"one", "two"}}
+	results <- &fileResult{name: "b.heic", output: "b.jpg"}
+	results <- &fileResult{name: "c.heic", output: "c.jpg", warnings: []string{"three"}}
+	close(results)
+	logs := map[string][]string{}
+	aggregateLogs(results, logs, nil, t.TempDir(), t.TempDir(), 3, defaultOptions(), time.Now())
+	found := false
+	for _, line := range logs["general"] {
+		found = found || line == "Decoder Warnings==3 in 2 files"
+	}
+	if !found {
+		t.Errorf("no warning count in %q", logs["general"])
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"time"
+)
+
+const defaultWatchInterval = 2 * time.Second
+
+// sourceState identifies a version of a source file between scans.
+type sourceState struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshotSources records the state of the HEIC files among entries.
+func snapshotSources(entries []os.DirEntry) map[string]sourceState {
+	states := map[string]sourceState{}
+	for _, entry := range entries {
+		if entry.IsDir() || !isHEICFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		states[entry.Name()] = sourceState{info.Size(), info.ModTime()}
+	}
+	return states
+}
+
+// changedSources returns the entries that are new or changed since seen and
+// updates seen to the current state. Removed files are forgotten, so they
+// are converted again if they come back.
+func changedSources(entries []os.DirEntry, seen map[string]sourceState) []os.DirEntry {
+	current := snapshotSources(entries)
+	var changed []os.DirEntry
+	for _, entry := range entries {
+		state, ok := current[entry.Name()]
+		if !ok {
+			continue
+		}
+		if previous, known := seen[entry.Name()]; !known || previous != state {
+			changed = append(changed, entry)
+		}
+	}
+	for name := range seen {
+		delete(seen, name)
+	}
+	for name, state := range current {
+		seen[name] = state
+	}
+	return changed
+}
+
+// watchedSources lists the input directory dir for --watch: its files, or
+// with --watch-recursive every file below it, named by its path relative to
+// dir, leaving out the output directory jpegDir. Folders that appear are
+// listed from the scan after they are created, so a sync tool's new dated
+// folder is picked up like a new file; folders removed while they are
+// listed are skipped.
+func watchedSources(dir, jpegDir string, opts options) ([]os.DirEntry, error) {
+	if !opts.watchRecursive {
+		return getFilesInDirectory(dir)
+	}
+	entries, err := collectEntries(scanTree(dir, scanWorkers, jpegDir, nil))
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			err = nil
+		}
+	}
+	return entries, err
+}
+
+// Defaults of --watch-settle and --watch-batch.
+const (
+	defaultWatchSettle = 2 * time.Second
+	defaultWatchBatch  = 200
+)
+
+// pendingSource is a changed source waiting to be converted.
+type pendingSource struct {
+	state sourceState
+	since time.Time // when the file was first seen in this state
+}
+
+// watchQueue collects the sources that changed between watch scans. A file
+// is ready once its size and modification time have not changed for settle,
+// so files still being copied or synced are not converted half-written.
+type watchQueue struct {
+	pending    map[string]pendingSource
+	lastChange time.Time // when a source last appeared or changed
+}
+
+func newWatchQueue() *watchQueue {
+	return &watchQueue{pending: map[string]pendingSource{}}
+}
+
+// add records the changed entries of a scan made at now.
+func (q *watchQueue) add(changed []os.DirEntry, seen map[string]sourceState, now time.Time) {
+	for _, entry := range changed {
+		q.pending[entry.Name()] = pendingSource{state: seen[entry.Name()], since: now}
+		q.lastChange = now
+	}
+}
+
+// ready returns the pending entries that have settled, in the order of
+// entries, dropping pending files that are no longer there. With a burst
+// of files still coming in, as when a phone syncs hundreds of photos at
+// once, nothing is ready until it has been quiet for settle or a whole
+// batch has settled, so the burst is converted in full batches rather than
+// a few files per scan.
+func (q *watchQueue) ready(entries []os.DirEntry, now time.Time, settle time.Duration, batch int) []os.DirEntry {
+	present := map[string]bool{}
+	var ready []os.DirEntry
+	for _, entry := range entries {
+		present[entry.Name()] = true
+		if p, ok := q.pending[entry.Name()]; ok && now.Sub(p.since) >= settle {
+			ready = append(ready, entry)
+		}
+	}
+	for name := range q.pending {
+		if !present[name] {
+			delete(q.pending, name)
+		}
+	}
+	if now.Sub(q.lastChange) < settle && len(ready) < batch {
+		return nil
+	}
+	return ready
+}
+
+// done removes converted entries from the queue.
+func (q *watchQueue) done(entries []os.DirEntry) {
+	for _, entry := range entries {
+		delete(q.pending, entry.Name())
+	}
+}
+
+// watchDirectory implements --watch: after the initial run it polls dir
+// every opts.watchInterval, and the folders below it with --watch-recursive
+// (see watchedSources), and converts HEIC files that appear or change,
+// appending their results to the log file, until ctx is cancelled. Files
+// are converted once they have settled (see watchQueue), in batches of at
+// most opts.watchBatch through the worker pipeline; each batch is a cycle
+// with its own log lines and notification. Outside the --schedule windows,
+// files are queued until the next window opens.
+func watchDirectory(ctx context.Context, dir, jpegDir string, seen map[string]sourceState, opts options) error {
+	fmt.Printf("Watching %s for new HEIC files (Ctrl+C to stop)...\n", dir)
+	ticker := time.NewTicker(opts.watchInterval)
+	defer ticker.Stop()
+
+	queue := newWatchQueue()
+	announced := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		entries, err := watchedSources(dir, jpegDir, opts)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %v", dir, err)
+		}
+		entries = opts.ignore.filter(entries)
+		now := time.Now()
+		queue.add(changedSources(entries, seen), seen, now)
+		if len(queue.pending) == 0 {
+			continue
+		}
+		if !opts.schedule.allows(now) {
+			if !announced {
+				fmt.Printf("Queued %d files until %s\n", len(queue.pending), opts.schedule.nextStart(now).Format("15:04"))
+				announced = true
+			}
+			continue
+		}
+		announced = false
+
+		// The directory is not scanned while batches are converted; what
+		// changes in the meantime is picked up by the next scan.
+		ready := queue.ready(entries, now, opts.watchSettle, opts.watchBatch)
+		for len(ready) > 0 && ctx.Err() == nil && opts.schedule.allows(time.Now()) {
+			batch := ready
+			if len(batch) > opts.watchBatch {
+				batch = batch[:opts.watchBatch]
+			}
+			ready = ready[len(batch):]
+			if len(queue.pending) > len(batch) {
+				fmt.Printf("Converting %d of %d queued files\n", len(batch), len(queue.pending))
+			}
+			logs := processFiles(dir, jpegDir, batch, opts)
+			queue.done(batch)
+			appendLogsToFile(jpegDir, logs)
+			if err := opts.report.save(opts.reportPath); err != nil {
+				log.Printf("Failed to write report: %v", err)
+			}
+			notify(opts, newNotification(opts, jpegDir, logs["general"], nil))
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangedSources(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(entries []os.DirEntry) string {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return strings.Join(names, ",")
+	}
+	scan := func() []os.DirEntry {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return entries
+	}
+
+	write("a.heic", "first")
+	write("notes.txt", "ignored")
+	seen := snapshotSources(scan())
+	if got := names(changedSources(scan(), seen)); got != "" {
+		t.Errorf("nothing changed, got %s", got)
+	}
+
+	write("b.HEIC", "new")
+	write("a.heic", "second, longer")
+	if got := names(changedSources(scan(), seen)); got != "a.heic,b.HEIC" {
+		t.Errorf("got %s, want a.heic,b.HEIC", got)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "b.HEIC")); err != nil {
+		t.Fatal(err)
+	}
+	changedSources(scan(), seen)
+	write("b.HEIC", "new")
+	if got := names(changedSources(scan(), seen)); got != "b.HEIC" {
+		t.Errorf("a file that came back should be converted again, got %s", got)
+	}
+}
+
+func TestWatchDirectory(t *testing.T) {
+	dir := t.TempDir()
+	jpegDir := filepath.Join(dir, "jpegs")
+	if err := os.Mkdir(jpegDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.watchInterval = 10 * time.Millisecond
+	opts.watchSettle = 30 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watchDirectory(ctx, dir, jpegDir, map[string]sourceState{}, opts) }()
+
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(jpegDir, "camel.jpg")); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the added file was not converted")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if logs, err := os.ReadFile(filepath.Join(jpegDir, logFileName)); err != nil || !strings.Contains(string(logs), "camel.heic") {
+		t.Errorf("the batch was not appended to the log file: %v", err)
+	}
+}
+
+func TestWatchQueue(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(entries []os.DirEntry) string {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return strings.Join(names, ",")
+	}
+	seen := map[string]sourceState{}
+	queue := newWatchQueue()
+	start := time.Now()
+	scan := func(after time.Duration, batch int) string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := start.Add(after)
+		queue.add(changedSources(entries, seen), seen, now)
+		return names(queue.ready(entries, now, time.Second, batch))
+	}
+
+	write("a.heic", "part")
+	if got := scan(0, 10); got != "" {
+		t.Errorf("new file ready at once: %s", got)
+	}
+	// Still being written: the clock starts again.
+	write("a.heic", "partial, longer")
+	if got := scan(800*time.Millisecond, 10); got != "" {
+		t.Errorf("growing file ready: %s", got)
+	}
+	write("b.heic", "b")
+	if got := scan(1900*time.Millisecond, 10); got != "" {
+		t.Errorf("ready during a burst: %s", got)
+	}
+	if got := scan(3*time.Second, 10); got != "a.heic,b.heic" {
+		t.Errorf("settled files: got %q", got)
+	}
+
+	// A full batch goes ahead while more files keep coming.
+	entries, _ := os.ReadDir(dir)
+	queue.done(entries)
+	write("c.heic", "c")
+	write("d.heic", "d")
+	scan(4*time.Second, 2)
+	write("e.heic", "e")
+	if got := scan(5500*time.Millisecond, 2); got != "c.heic,d.heic" {
+		t.Errorf("full batch: got %q", got)
+	}
+
+	// Removed files are dropped.
+	if err := os.Remove(filepath.Join(dir, "e.heic")); err != nil {
+		t.Fatal(err)
+	}
+	scan(6*time.Second, 2)
+	if _, ok := queue.pending["e.heic"]; ok {
+		t.Error("removed file still queued")
+	}
+}
+
+func TestWatchDirectoryBatches(t *testing.T) {
+	dir := t.TempDir()
+	jpegDir := filepath.Join(dir, "jpegs")
+	if err := os.Mkdir(jpegDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.watchInterval = 10 * time.Millisecond
+	opts.watchSettle = 50 * time.Millisecond
+	opts.watchBatch = 2
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watchDirectory(ctx, dir, jpegDir, map[string]sourceState{}, opts) }()
+
+	for _, name := range []string{"a.heic", "b.heic", "c.heic"} {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		logs, _ := os.ReadFile(filepath.Join(jpegDir, logFileName))
+		if strings.Count(string(logs), "> Converted >") == 3 {
+			// Two batches, each with its own summary.
+			if got := strings.Count(string(logs), "Total Time Taken=="); got != 2 {
+				t.Errorf("got %d batches, want 2:\n%s", got, logs)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the added files were not converted:\n%s", logs)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchedSources(t *testing.T) {
+	dir := t.TempDir()
+	jpegDir := filepath.Join(dir, "jpegs")
+	for _, name := range []string{"top.heic", "2026-10-14/a.heic", "2026-10-14/raw/b.heic", "jpegs/top.jpg"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(entries []os.DirEntry) string {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, filepath.ToSlash(entry.Name()))
+		}
+		return strings.Join(names, ",")
+	}
+
+	opts := defaultOptions()
+	entries, err := watchedSources(dir, jpegDir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(entries); got != "2026-10-14,jpegs,top.heic" {
+		t.Errorf("got %s, want only the top folder", got)
+	}
+
+	opts.watchRecursive = true
+	if entries, err = watchedSources(dir, jpegDir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := names(entries), "2026-10-14/a.heic,2026-10-14/raw/b.heic,top.heic"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestWatchDirectoryRecursive(t *testing.T) {
+	dir := t.TempDir()
+	jpegDir := filepath.Join(dir, "jpegs")
+	if err := os.Mkdir(jpegDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", "images", "goheif-camel.heic"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := defaultOptions()
+	opts.watchRecursive = true
+	opts.watchInterval = 10 * time.Millisecond
+	opts.watchSettle = 30 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- watchDirectory(ctx, dir, jpegDir, map[string]sourceState{}, opts) }()
+
+	// A folder created after the watch started, as a sync tool makes one a
+	// day.
+	day := filepath.Join(dir, "2026-10-15")
+	if err := os.Mkdir(day, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(day, "camel.heic"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(jpegDir, "2026-10-15", "camel.jpg")
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		if _, err := os.Stat(want); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the file in the new folder was not converted to %s", want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchDirectoryQueuesOutsideSchedule(t *testing.T) {
+	dir := t.TempDir()
+	jpegDir := filepath.Join(dir, "jpegs")
+	if err := os.WriteFile(filepath.Join(dir, "camel.heic"), []byte("not decoded"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A one-minute window two hours from now.
+	start := time.Now().Add(2 * time.Hour)
+	schedule, err := parseSchedule(start.Format("15:04") + "-" + start.Add(time.Minute).Format("15:04"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOptions()
+	opts.watchInterval = 10 * time.Millisecond
+	opts.schedule = schedule
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := watchDirectory(ctx, dir, jpegDir, map[string]sourceState{}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(jpegDir); !os.IsNotExist(err) {
+		t.Error("a file was converted outside the schedule")
+	}
+}
+
+func TestParseOptionsWatch(t *testing.T) {
+	dir := t.TempDir()
+	opts, err := parseOptions([]string{"--watch", "--watch-interval", "5s", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.watch || opts.watchInterval != 5*time.Second {
+		t.Errorf("got watch %v every %v", opts.watch, opts.watchInterval)
+	}
+	if opts, err = parseOptions([]string{"--watch", "--watch-recursive", dir}, io.Discard); err != nil || !opts.watchRecursive {
+		t.Errorf("--watch-recursive: got %v, %v", opts.watchRecursive, err)
+	}
+
+	for _, args := range [][]string{
+		{"--watch", filepath.Join("testdata", "images", "goheif-camel.heic")},
+		{"--watch", "--watch-interval", "0s", dir},
+		{"--watch", "--watch-settle", "-1s", dir},
+		{"--watch", "--watch-batch", "0", dir},
+		{"--watch", "--dry-run", dir},
+		{"--watch", "--output-format", "pdf", dir},
+		{"--schedule", "01:00-06:00", dir},
+		{"--watch", "--schedule", "1am-6am", dir},
+		{"--watch-recursive", dir},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// defaultIOWorkers is how many files the pipeline reads, and writes, at the
+// same time when it is not calibrated. Disks, spinning ones in particular,
+// do best with few concurrent streams, while decoding and encoding use
+// every CPU.
+const defaultIOWorkers = 2
+
+// maxIOWorkers caps the I/O workers calibration picks: past this, a slow
+// network share is the bottleneck however many requests are in flight.
+const maxIOWorkers = 32
+
+// calibrationMinFiles is the smallest run that is calibrated. Calibrating
+// converts one source an extra time, which small runs do not win back.
+const calibrationMinFiles = 16
+
+// stageWorkers is how many goroutines each stage of the pipeline runs:
+// reading and writing (I/O), decoding, and encoding. Counts not given with
+// --io-workers, --decode-workers and --encode-workers are worked out once
+// per run by calibrating on its first source, and kept for the batches of
+// --watch.
+type stageWorkers struct {
+	io, decode, encode int // as given, 0 for those left to choose
+
+	once        sync.Once
+	tuned       [3]int // I/O, decode and encode workers calibration chose
+	calibration string // what was measured, for --verbose
+}
+
+// counts returns the worker counts of the stages for a pipeline whose first
+// source is sample, out of total sources (0 when not known up front, as for
+// streamed folders). A nil w, runs smaller than calibrationMinFiles and
+// samples that cannot be converted get defaultIOWorkers and opts.workers
+// for each CPU stage.
+func (w *stageWorkers) counts(sample string, total int, opts options) (ioN, decodeN, encodeN int) {
+	if w == nil {
+		return defaultIOWorkers, opts.workers, opts.workers
+	}
+	tuned := [3]int{defaultIOWorkers, opts.workers, opts.workers}
+	if (w.io == 0 || w.decode == 0 || w.encode == 0) && sample != "" && (total == 0 || total >= calibrationMinFiles) {
+		w.once.Do(func() {
+			read, decode, encode, err := measureStages(sample, opts)
+			if err != nil {
+				return
+			}
+			w.tuned[0], w.tuned[1], w.tuned[2] = tuneWorkers(opts.workers, read, decode, encode)
+			w.calibration = fmt.Sprintf("read %v, decode %v, encode %v", read.Round(time.Millisecond), decode.Round(time.Millisecond), encode.Round(time.Millisecond))
+			if opts.verbose {
+				fmt.Printf("Workers: %d I/O, %d decode, %d encode (calibrated on %s: %s)\n",
+					orDefault(w.io, w.tuned[0]), orDefault(w.decode, w.tuned[1]), orDefault(w.encode, w.tuned[2]), sample, w.calibration)
+			}
+		})
+		if w.calibration != "" {
+			tuned = w.tuned
+		}
+	}
+	return orDefault(w.io, tuned[0]), orDefault(w.decode, tuned[1]), orDefault(w.encode, tuned[2])
+}
+
+func orDefault(n, fallback int) int {
+	if n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// measureStages times reading the source at path, decoding it and encoding
+// it as a JPEG at opts.quality.
+func measureStages(path string, opts options) (read, decode, encode time.Duration, err error) {
+	start := time.Now()
+	source, err := readSource(path)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer putBuffer(source)
+	read = time.Since(start)
+
+	start = time.Now()
+	img, err := decodeHEIC(bytes.NewReader(source.Bytes()))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	decode = time.Since(start)
+
+	start = time.Now()
+	if err := jpeg.Encode(io.Discard, img, &jpeg.Options{Quality: opts.quality}); err != nil {
+		return 0, 0, 0, err
+	}
+	return read, decode, time.Since(start), nil
+}
+
+// tuneWorkers splits cpus between decoding and encoding in proportion to
+// the time each takes, and gives I/O enough workers to read a file in the
+// time the CPU stages take to finish one, at least defaultIOWorkers: few on
+// a local SSD, many on a network share whose every read waits on a round
+// trip.
+func tuneWorkers(cpus int, read, decode, encode time.Duration) (ioN, decodeN, encodeN int) {
+	cpu := decode + encode
+	if cpu <= 0 {
+		return defaultIOWorkers, cpus, cpus
+	}
+	decodeN = int(math.Ceil(float64(cpus) * float64(decode) / float64(cpu)))
+	encodeN = int(math.Ceil(float64(cpus) * float64(encode) / float64(cpu)))
+	if decodeN < 1 {
+		decodeN = 1
+	}
+	if encodeN < 1 {
+		encodeN = 1
+	}
+
+	ioN = int(math.Ceil(float64(read) * float64(cpus) / float64(cpu)))
+	if ioN < defaultIOWorkers {
+		ioN = defaultIOWorkers
+	}
+	if ioN > maxIOWorkers {
+		ioN = maxIOWorkers
+	}
+	return ioN, decodeN, encodeN
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTuneWorkers(t *testing.T) {
+	ms := time.Millisecond
+	for _, test := range []struct {
+		name                 string
+		cpus                 int
+		read, decode, encode time.Duration
+		io, dec, enc         int
+	}{
+		{"local SSD", 8, 2 * ms, 300 * ms, 100 * ms, defaultIOWorkers, 6, 2},
+		{"network share", 8, 400 * ms, 300 * ms, 100 * ms, 8, 6, 2},
+		{"slow share", 8, 10 * time.Second, 300 * ms, 100 * ms, maxIOWorkers, 6, 2},
+		{"one CPU", 1, 2 * ms, 300 * ms, 100 * ms, defaultIOWorkers, 1, 1},
+		{"nothing measured", 4, 0, 0, 0, defaultIOWorkers, 4, 4},
+	} {
+		io, dec, enc := tuneWorkers(test.cpus, test.read, test.decode, test.encode)
+		if io != test.io || dec != test.dec || enc != test.enc {
+			t.Errorf("%s: got %d I/O, %d decode, %d encode, want %d, %d, %d", test.name, io, dec, enc, test.io, test.dec, test.enc)
+		}
+	}
+}
+
+func TestStageWorkerCounts(t *testing.T) {
+	opts := defaultOptions()
+	opts.workers = 4
+	sample := filepath.Join("testdata", "images", "goheif-camel.heic")
+
+	var none *stageWorkers
+	if io, dec, enc := none.counts(sample, 0, opts); io != defaultIOWorkers || dec != 4 || enc != 4 {
+		t.Errorf("without stages got %d, %d, %d", io, dec, enc)
+	}
+
+	given := &stageWorkers{io: 16, decode: 3, encode: 1}
+	if io, dec, enc := given.counts(sample, 0, opts); io != 16 || dec != 3 || enc != 1 || given.calibration != "" {
+		t.Errorf("given counts got %d, %d, %d, calibrated %q", io, dec, enc, given.calibration)
+	}
+
+	small := &stageWorkers{}
+	if io, dec, enc := small.counts(sample, calibrationMinFiles-1, opts); io != defaultIOWorkers || dec != 4 || enc != 4 || small.calibration != "" {
+		t.Errorf("a small run got %d, %d, %d, calibrated %q", io, dec, enc, small.calibration)
+	}
+
+	auto := &stageWorkers{io: 5}
+	io, dec, enc := auto.counts(sample, 0, opts)
+	if auto.calibration == "" || io != 5 || dec < 1 || enc < 1 || dec+enc < 4 {
+		t.Errorf("calibrated run got %d, %d, %d (%s)", io, dec, enc, auto.calibration)
+	}
+	// Later batches, as of --watch, keep the calibration.
+	if io2, dec2, enc2 := auto.counts("missing.heic", 0, opts); io2 != io || dec2 != dec || enc2 != enc {
+		t.Errorf("second pipeline got %d, %d, %d, want %d, %d, %d", io2, dec2, enc2, io, dec, enc)
+	}
+
+	broken := &stageWorkers{}
+	if io, dec, enc := broken.counts("missing.heic", 0, opts); io != defaultIOWorkers || dec != 4 || enc != 4 {
+		t.Errorf("a sample that cannot be converted got %d, %d, %d", io, dec, enc)
+	}
+}
+
+func TestParseOptionsWorkers(t *testing.T) {
+	dir := t.TempDir()
+	opts, err := parseOptions([]string{"--io-workers", "12", "--decode-workers", "3", dir}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.stages == nil || opts.stages.io != 12 || opts.stages.decode != 3 || opts.stages.encode != 0 {
+		t.Errorf("got stages %+v", opts.stages)
+	}
+	for _, args := range [][]string{
+		{"--io-workers", "-1", dir},
+		{"--low-memory", "--decode-workers", "2", dir},
+	} {
+		if _, err := parseOptions(args, io.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}